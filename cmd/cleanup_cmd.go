@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+var cleanupCmd = cobra.Command{
+	Use:  "cleanup",
+	Long: "Remove expired and stale rows from the database, such as from a cron job",
+	Run: func(cmd *cobra.Command, args []string) {
+		execWithConfigAndArgs(cmd, cleanup, args)
+	},
+}
+
+func cleanup(config *conf.GlobalConfiguration, args []string) {
+	db, err := storage.Dial(config)
+	if err != nil {
+		logrus.Fatalf("Error opening database: %+v", err)
+	}
+	defer db.Close()
+
+	affectedRows, err := models.NewCleanup(config).CleanAll(db)
+	if err != nil {
+		logrus.WithError(err).WithField("affected_rows", affectedRows).Fatal("database cleanup failed")
+	}
+
+	logrus.WithField("affected_rows", affectedRows).Info("cleaned up expired or stale rows")
+}
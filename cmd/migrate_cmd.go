@@ -1,34 +1,124 @@
 package cmd
 
 import (
-	"fmt"
-	"net/url"
+	"context"
 	"os"
+	"strconv"
 
 	"github.com/gobuffalo/pop/v6"
 	"github.com/gobuffalo/pop/v6/logging"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/storage"
 )
 
-var migrateCmd = cobra.Command{
-	Use:  "migrate",
-	Long: "Migrate database strucutures. This will create new tables and add missing columns and indexes.",
-	Run:  migrate,
+var migrateDryRun bool
+
+func migrateCmd() *cobra.Command {
+	var migrateCmd = &cobra.Command{
+		Use:  "migrate",
+		Long: "Migrate database strucutures. This will create new tables and add missing columns and indexes.",
+		Run:  migrate,
+	}
+
+	migrateCmd.AddCommand(&migrateStatusCmd, &migrateUpCmd, &migrateDownCmd)
+	migrateUpCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Print the SQL of the pending migrations without applying them")
+
+	return migrateCmd
 }
 
-func migrate(cmd *cobra.Command, args []string) {
-	globalConfig := loadGlobalConfig(cmd.Context())
+var migrateStatusCmd = cobra.Command{
+	Use:   "status",
+	Short: "List the applied and pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		withMigrator(cmd, func(ctx context.Context, migrator *storage.Migrator) {
+			statuses, err := migrator.Status(ctx)
+			if err != nil {
+				logrus.Fatalf("%+v", errors.Wrap(err, "migration status"))
+			}
 
-	if globalConfig.DB.Driver == "" && globalConfig.DB.URL != "" {
-		u, err := url.Parse(globalConfig.DB.URL)
+			if err := storage.PrintMigrationStatus(os.Stdout, statuses); err != nil {
+				logrus.Fatalf("%+v", errors.Wrap(err, "migration status"))
+			}
+		})
+	},
+}
+
+var migrateUpCmd = cobra.Command{
+	Use:   "up",
+	Short: "Apply the pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		if migrateDryRun {
+			withMigrator(cmd, func(ctx context.Context, migrator *storage.Migrator) {
+				if err := migrator.Plan(ctx, os.Stdout); err != nil {
+					logrus.Fatalf("%+v", errors.Wrap(err, "planning db migrations"))
+				}
+			})
+			return
+		}
+
+		migrate(cmd, args)
+	},
+}
+
+var migrateDownCmd = cobra.Command{
+	Use:   "down [N]",
+	Short: "Roll back the last N applied migrations, 1 by default",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		steps := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				logrus.Fatalf("Invalid number of migrations to roll back: %s", args[0])
+			}
+			steps = n
+		}
+
+		withMigrator(cmd, func(ctx context.Context, migrator *storage.Migrator) {
+			versions, err := migrator.Down(ctx, steps)
+			if err != nil {
+				logrus.Fatalf("%+v", errors.Wrap(err, "rolling back db migrations"))
+			}
+
+			for _, version := range versions {
+				logrus.Infof("Rolled back migration %s", version)
+			}
+		})
+	},
+}
+
+func migrate(cmd *cobra.Command, args []string) {
+	withMigrator(cmd, func(ctx context.Context, migrator *storage.Migrator) {
+		versions, err := migrator.Up(ctx)
 		if err != nil {
-			logrus.Fatalf("%+v", errors.Wrap(err, "parsing db connection url"))
+			logrus.Fatalf("%v", errors.Wrap(err, "running db migrations"))
+		}
+
+		for _, version := range versions {
+			logrus.Debugf("Applied migration %s", version)
 		}
-		globalConfig.DB.Driver = u.Scheme
+		logrus.Infof("GoTrue migrations applied successfully")
+	})
+}
+
+func withMigrator(cmd *cobra.Command, fn func(ctx context.Context, migrator *storage.Migrator)) {
+	globalConfig := loadGlobalConfig(cmd.Context())
+	configureMigrationLogging(globalConfig)
+
+	logrus.Debugf("Reading migrations from %s", globalConfig.DB.MigrationsPath)
+	migrator, err := storage.NewMigrator(globalConfig)
+	if err != nil {
+		logrus.Fatalf("%+v", errors.Wrap(err, "creating db migrator"))
 	}
+	defer migrator.Close()
+
+	fn(cmd.Context(), migrator)
+}
 
+func configureMigrationLogging(globalConfig *conf.GlobalConfiguration) {
 	log := logrus.StandardLogger()
 
 	pop.Debug = false
@@ -49,63 +139,4 @@ func migrate(cmd *cobra.Command, args []string) {
 			pop.SetLogger(noopLogger)
 		}
 	}
-
-	u, _ := url.Parse(globalConfig.DB.URL)
-	processedUrl := globalConfig.DB.URL
-	if len(u.Query()) != 0 {
-		processedUrl = fmt.Sprintf("%s&application_name=gotrue_migrations", processedUrl)
-	} else {
-		processedUrl = fmt.Sprintf("%s?application_name=gotrue_migrations", processedUrl)
-	}
-	deets := &pop.ConnectionDetails{
-		Dialect: globalConfig.DB.Driver,
-		URL:     processedUrl,
-	}
-	deets.Options = map[string]string{
-		"migration_table_name": "schema_migrations",
-		"Namespace":            globalConfig.DB.Namespace,
-	}
-
-	db, err := pop.NewConnection(deets)
-	if err != nil {
-		log.Fatalf("%+v", errors.Wrap(err, "opening db connection"))
-	}
-	defer db.Close()
-
-	if err := db.Open(); err != nil {
-		log.Fatalf("%+v", errors.Wrap(err, "checking database connection"))
-	}
-
-	log.Debugf("Reading migrations from %s", globalConfig.DB.MigrationsPath)
-	mig, err := pop.NewFileMigrator(globalConfig.DB.MigrationsPath, db)
-	if err != nil {
-		log.Fatalf("%+v", errors.Wrap(err, "creating db migrator"))
-	}
-	log.Debugf("before status")
-
-	if log.Level == logrus.DebugLevel {
-		err = mig.Status(os.Stdout)
-		if err != nil {
-			log.Fatalf("%+v", errors.Wrap(err, "migration status"))
-		}
-	}
-
-	// turn off schema dump
-	mig.SchemaPath = ""
-
-	err = mig.Up()
-	if err != nil {
-		log.Fatalf("%v", errors.Wrap(err, "running db migrations"))
-	} else {
-		log.Infof("GoTrue migrations applied successfully")
-	}
-
-	log.Debugf("after status")
-
-	if log.Level == logrus.DebugLevel {
-		err = mig.Status(os.Stdout)
-		if err != nil {
-			log.Fatalf("%+v", errors.Wrap(err, "migration status"))
-		}
-	}
 }
@@ -14,14 +14,16 @@ var configFile = ""
 var rootCmd = cobra.Command{
 	Use: "gotrue",
 	Run: func(cmd *cobra.Command, args []string) {
-		migrate(cmd, args)
+		if loadGlobalConfig(cmd.Context()).DB.Automigrate {
+			migrate(cmd, args)
+		}
 		serve(cmd.Context())
 	},
 }
 
 // RootCommand will setup and return the root command
 func RootCommand() *cobra.Command {
-	rootCmd.AddCommand(&serveCmd, &migrateCmd, &versionCmd, adminCmd())
+	rootCmd.AddCommand(&serveCmd, migrateCmd(), &versionCmd, adminCmd(), &cleanupCmd)
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "the config file to use")
 
 	return &rootCmd
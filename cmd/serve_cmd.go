@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/supabase/auth/internal/api"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/mailer"
 	"github.com/supabase/auth/internal/storage"
 	"github.com/supabase/auth/internal/utilities"
 )
@@ -26,16 +27,22 @@ func serve(ctx context.Context) {
 		logrus.WithError(err).Fatal("unable to load config")
 	}
 
+	if err := mailer.ValidateTemplates(config); err != nil {
+		logrus.WithError(err).Fatal("unable to load email templates")
+	}
+
 	db, err := storage.Dial(config)
 	if err != nil {
 		logrus.Fatalf("error opening database: %+v", err)
 	}
-	defer db.Close()
 
 	api := api.NewAPIWithVersion(config, db, utilities.Version)
 
 	addr := net.JoinHostPort(config.API.Host, config.API.Port)
 	logrus.Infof("GoTrue API started on: %s", addr)
 
-	api.ListenAndServe(ctx, addr)
+	// closes the database connection once active requests have completed
+	if err := api.ListenAndServe(ctx, addr); err != nil {
+		logrus.WithError(err).Fatal("http server listen failed")
+	}
 }
@@ -35,7 +35,7 @@ type adminUserParamsWrapper struct {
 	Body api.AdminUserParams
 }
 
-// swagger:route GET /admin/user/{user_id} admin admin-get-user
+// swagger:route GET /admin/users/{user_id} admin admin-get-user
 // Get a user.
 // security:
 //   - bearer:
@@ -51,7 +51,7 @@ type adminUserParamsWrapper struct {
 // The user specified.
 // swagger:response userResponse
 
-// swagger:route PUT /admin/user/{user_id} admin admin-update-user
+// swagger:route PUT /admin/users/{user_id} admin admin-update-user
 // Update a user.
 // security:
 //   - bearer:
@@ -67,7 +67,7 @@ type adminUserParamsWrapper struct {
 // The updated user.
 // swagger:response userResponse
 
-// swagger:route DELETE /admin/user/{user_id} admin admin-delete-user
+// swagger:route DELETE /admin/users/{user_id} admin admin-delete-user
 // Deletes a user.
 // security:
 //   - bearer:
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/sethvargo/go-password/password"
 	"github.com/supabase/auth/internal/api/provider"
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
@@ -28,6 +30,10 @@ type AdminUserParams struct {
 	UserMetaData map[string]interface{} `json:"user_metadata"`
 	AppMetaData  map[string]interface{} `json:"app_metadata"`
 	BanDuration  string                 `json:"ban_duration"`
+
+	// SkipPasswordValidation allows admins to set passwords that do not
+	// satisfy the configured password strength policy.
+	SkipPasswordValidation bool `json:"skip_password_validation"`
 }
 
 type adminUserDeleteParams struct {
@@ -42,6 +48,10 @@ type adminUserUpdateFactorParams struct {
 type AdminListUsersResponse struct {
 	Users []*models.User `json:"users"`
 	Aud   string         `json:"aud"`
+
+	// NextCursor reads the next page when passed as the cursor parameter.
+	// It's empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 func (a *API) loadUser(w http.ResponseWriter, r *http.Request) (context.Context, error) {
@@ -50,7 +60,7 @@ func (a *API) loadUser(w http.ResponseWriter, r *http.Request) (context.Context,
 
 	userID, err := uuid.FromString(chi.URLParam(r, "user_id"))
 	if err != nil {
-		return nil, notFoundError(ErrorCodeValidationFailed, "user_id must be an UUID")
+		return nil, badRequestError(ErrorCodeValidationFailed, "user_id must be an UUID")
 	}
 
 	observability.LogEntrySetField(r, "user_id", userID)
@@ -81,6 +91,10 @@ func (a *API) loadFactor(w http.ResponseWriter, r *http.Request) (context.Contex
 		}
 		return nil, internalServerError("Database error loading factor").WithInternalError(err)
 	}
+	// factors of other users are not found, rather than acted on
+	if user := getUser(r.Context()); user != nil && !f.IsOwnedBy(user) {
+		return nil, notFoundError(ErrorCodeMFAFactorNotFound, "Factor not found")
+	}
 	return withFactor(r.Context(), f), nil
 }
 
@@ -104,12 +118,41 @@ func (a *API) adminUsers(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError(ErrorCodeValidationFailed, "Bad Pagination Parameters: %v", err).WithInternalError(err)
 	}
 
-	sortParams, err := sort(r, map[string]bool{models.CreatedAt: true}, []models.SortField{{Name: models.CreatedAt, Dir: models.Descending}})
+	sortParams, err := adminUsersSort(r)
+	if err != nil {
+		return err
+	}
+
+	filter, err := adminUsersFilter(r)
 	if err != nil {
-		return badRequestError(ErrorCodeValidationFailed, "Bad Sort Parameters: %v", err)
+		return err
 	}
 
-	filter := r.URL.Query().Get("filter")
+	if cursorParam := r.URL.Query().Get("cursor"); cursorParam != "" {
+		cursor, err := models.ParseUserCursor(cursorParam)
+		if err != nil {
+			return badRequestError(ErrorCodeValidationFailed, "Bad Pagination Parameters: %v", err)
+		}
+		if pageParams.PerPage == 0 {
+			return badRequestError(ErrorCodeValidationFailed, "Bad Pagination Parameters: per_page must be at least 1")
+		}
+
+		users, next, err := models.FindUsersInAudienceAfter(db, aud, cursor, int(pageParams.PerPage), sortParams.Fields[0].Dir, filter)
+		if err != nil {
+			return internalServerError("Database error finding users").WithInternalError(err)
+		}
+
+		response := AdminListUsersResponse{
+			Users: users,
+			Aud:   aud,
+		}
+		if next != nil {
+			response.NextCursor = next.String()
+			addCursorPaginationHeaders(w, r, response.NextCursor)
+		}
+
+		return sendJSON(w, http.StatusOK, response)
+	}
 
 	users, err := models.FindUsersInAudience(db, aud, pageParams, sortParams, filter)
 	if err != nil {
@@ -117,15 +160,73 @@ func (a *API) adminUsers(w http.ResponseWriter, r *http.Request) error {
 	}
 	addPaginationHeaders(w, r, pageParams)
 
-	return sendJSON(w, http.StatusOK, AdminListUsersResponse{
+	response := AdminListUsersResponse{
 		Users: users,
 		Aud:   aud,
-	})
+	}
+	if len(users) > 0 && pageParams.Page*pageParams.PerPage < pageParams.Count {
+		// the following pages can be read with the cursor, see
+		// FindUsersInAudience
+		response.NextCursor = models.NewUserCursor(users[len(users)-1]).String()
+	}
+
+	return sendJSON(w, http.StatusOK, response)
 }
 
-// adminUserGet returns information about a single user
+// adminUsersSort parses the sort of the users listing. Users can only be
+// sorted by created_at, which the cursor depends on.
+func adminUsersSort(r *http.Request) (*models.SortParams, error) {
+	sortParams, err := sort(r, map[string]bool{models.CreatedAt: true}, []models.SortField{{Name: models.CreatedAt, Dir: models.Descending}})
+	if err != nil {
+		return nil, badRequestError(ErrorCodeValidationFailed, "Bad Sort Parameters: %v", err)
+	}
+
+	return sortParams, nil
+}
+
+// adminUsersFilter parses the filter of the users listing and export.
+func adminUsersFilter(r *http.Request) (*models.UserFilter, error) {
+	query := r.URL.Query()
+	filter := &models.UserFilter{
+		Query:          query.Get("filter"),
+		IncludeDeleted: query.Get("include_deleted") == "true",
+	}
+
+	if isAnonymous := query.Get("is_anonymous"); isAnonymous != "" {
+		value, err := strconv.ParseBool(isAnonymous)
+		if err != nil {
+			return nil, badRequestError(ErrorCodeValidationFailed, "is_anonymous must be true or false")
+		}
+		filter.IsAnonymous = &value
+	}
+
+	return filter, nil
+}
+
+// adminUserGet returns information about a single user, including their MFA
+// factors and the number of their active sessions
 func (a *API) adminUserGet(w http.ResponseWriter, r *http.Request) error {
-	user := getUser(r.Context())
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.getConfig(ctx)
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+
+	if err := user.LoadRecoveryCodesRemaining(db); err != nil {
+		return internalServerError("Database error loading recovery codes").WithInternalError(err)
+	}
+
+	if err := user.LoadActiveSessions(db, a.Now(), config.Sessions.Timebox, config.Sessions.InactivityTimeout); err != nil {
+		return internalServerError("Database error loading sessions").WithInternalError(err)
+	}
+
+	if err := models.NewAuditLogEntry(r, db, adminUser, models.UserViewedAction, "", map[string]interface{}{
+		"user_id":    user.ID,
+		"user_email": user.Email,
+		"user_phone": user.Phone,
+	}); err != nil {
+		return internalServerError("Error recording audit log entry").WithInternalError(err)
+	}
 
 	return sendJSON(w, http.StatusOK, user)
 }
@@ -172,8 +273,10 @@ func (a *API) adminUserUpdate(w http.ResponseWriter, r *http.Request) error {
 	if params.Password != nil {
 		password := *params.Password
 
-		if err := a.checkPasswordStrength(ctx, password); err != nil {
-			return err
+		if !params.SkipPasswordValidation {
+			if err := a.checkPasswordStrength(ctx, password); err != nil {
+				return err
+			}
 		}
 
 		if err := user.SetPassword(ctx, password, config.Security.DBEncryption.Encrypt, config.Security.DBEncryption.EncryptionKeyID, config.Security.DBEncryption.EncryptionKey); err != nil {
@@ -298,6 +401,7 @@ func (a *API) adminUserUpdate(w http.ResponseWriter, r *http.Request) error {
 		}); terr != nil {
 			return terr
 		}
+		a.notifyWebhook(r, tx, conf.WebhookUserUpdatedEvent, user)
 		return nil
 	})
 
@@ -362,6 +466,10 @@ func (a *API) adminUserCreate(w http.ResponseWriter, r *http.Request) error {
 			return internalServerError("Error generating password").WithInternalError(err)
 		}
 		params.Password = &password
+	} else if !params.SkipPasswordValidation {
+		if err := a.checkPasswordStrength(ctx, *params.Password); err != nil {
+			return err
+		}
 	}
 
 	user, err := models.NewUser(params.Phone, params.Email, *params.Password, aud, params.UserMetaData)
@@ -502,21 +610,8 @@ func (a *API) adminUserDelete(w http.ResponseWriter, r *http.Request) error {
 				// user has been soft deleted already
 				return nil
 			}
-			if terr := user.SoftDeleteUser(tx); terr != nil {
-				return internalServerError("Error soft deleting user").WithInternalError(terr)
-			}
-
-			if terr := user.SoftDeleteUserIdentities(tx); terr != nil {
-				return internalServerError("Error soft deleting user identities").WithInternalError(terr)
-			}
-
-			// hard delete all associated factors
-			if terr := models.DeleteFactorsByUserId(tx, user.ID); terr != nil {
-				return internalServerError("Error deleting user's factors").WithInternalError(terr)
-			}
-			// hard delete all associated sessions
-			if terr := models.Logout(tx, user.ID); terr != nil {
-				return internalServerError("Error deleting user's sessions").WithInternalError(terr)
+			if terr := softDeleteUser(tx, user); terr != nil {
+				return terr
 			}
 		} else {
 			if terr := tx.Destroy(user); terr != nil {
@@ -530,24 +625,60 @@ func (a *API) adminUserDelete(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	a.notifyWebhook(r, a.db, conf.WebhookUserDeletedEvent, user)
+
 	return sendJSON(w, http.StatusOK, map[string]interface{}{})
 }
 
+// softDeleteUser obfuscates the user and their identities and removes their
+// factors and sessions.
+func softDeleteUser(tx *storage.Connection, user *models.User) error {
+	if err := user.SoftDeleteUser(tx); err != nil {
+		return internalServerError("Error soft deleting user").WithInternalError(err)
+	}
+
+	if err := user.SoftDeleteUserIdentities(tx); err != nil {
+		return internalServerError("Error soft deleting user identities").WithInternalError(err)
+	}
+
+	// hard delete all associated factors
+	if err := models.DeleteFactorsByUserId(tx, user.ID); err != nil {
+		return internalServerError("Error deleting user's factors").WithInternalError(err)
+	}
+	// hard delete all associated sessions
+	if err := models.Logout(tx, user.ID); err != nil {
+		return internalServerError("Error deleting user's sessions").WithInternalError(err)
+	}
+
+	return nil
+}
+
 func (a *API) adminUserDeleteFactor(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
 	factor := getFactor(ctx)
 
-	err := a.db.Transaction(func(tx *storage.Connection) error {
-		if terr := models.NewAuditLogEntry(r, tx, user, models.DeleteFactorAction, r.RemoteAddr, map[string]interface{}{
+	err := a.db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.DeleteFactorAction, "", map[string]interface{}{
 			"user_id":   user.ID,
 			"factor_id": factor.ID,
 		}); terr != nil {
 			return terr
 		}
+		// sessions verified with a lost authenticator must not keep AAL2
+		if terr := factor.DowngradeSessionsToAAL1(tx); terr != nil {
+			return internalServerError("Database error updating sessions").WithInternalError(terr)
+		}
 		if terr := tx.Destroy(factor); terr != nil {
 			return internalServerError("Database error deleting factor").WithInternalError(terr)
 		}
+		if factor.IsVerified() && !hasOtherVerifiedFactor(user, factor) {
+			// recovery codes are useless without a verified factor
+			if terr := models.DeleteRecoveryCodes(tx, user.ID); terr != nil {
+				return internalServerError("Database error deleting recovery codes").WithInternalError(terr)
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -556,6 +687,83 @@ func (a *API) adminUserDeleteFactor(w http.ResponseWriter, r *http.Request) erro
 	return sendJSON(w, http.StatusOK, factor)
 }
 
+// adminUserDeleteFactors removes all factors and recovery codes of a user,
+// for example when they have lost access to all of them.
+func (a *API) adminUserDeleteFactors(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+
+	factorIDs := make([]string, 0, len(user.Factors))
+	for _, factor := range user.Factors {
+		factorIDs = append(factorIDs, factor.ID.String())
+	}
+
+	err := a.db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.DeleteFactorAction, "", map[string]interface{}{
+			"user_id":    user.ID,
+			"factor_ids": factorIDs,
+		}); terr != nil {
+			return terr
+		}
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.DeleteRecoveryCodesAction, "", map[string]interface{}{
+			"user_id": user.ID,
+		}); terr != nil {
+			return terr
+		}
+		if terr := models.DowngradeUserSessionsToAAL1(tx, user.ID); terr != nil {
+			return internalServerError("Database error updating sessions").WithInternalError(terr)
+		}
+		if terr := models.DeleteFactorsByUserId(tx, user.ID); terr != nil {
+			return internalServerError("Database error deleting factors").WithInternalError(terr)
+		}
+		if terr := models.DeleteRecoveryCodes(tx, user.ID); terr != nil {
+			return internalServerError("Database error deleting recovery codes").WithInternalError(terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+// adminUserClearLockout clears the failed logins and lockout of the user's
+// email address and phone number.
+func (a *API) adminUserClearLockout(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+
+	var keys []string
+	if user.GetEmail() != "" {
+		keys = append(keys, models.EmailLoginAttemptKey(user.Aud, user.GetEmail()))
+	}
+	if user.GetPhone() != "" {
+		keys = append(keys, models.PhoneLoginAttemptKey(user.Aud, user.GetPhone()))
+	}
+
+	err := a.db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.UserUnlockedAction, "", map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+			"user_phone": user.Phone,
+		}); terr != nil {
+			return terr
+		}
+		if terr := models.ClearLoginAttempts(tx, keys...); terr != nil {
+			return internalServerError("Database error clearing lockout").WithInternalError(terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
 func (a *API) adminUserGetFactors(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	user := getUser(ctx)
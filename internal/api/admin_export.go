@@ -0,0 +1,284 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
+)
+
+// adminExportBatchSize is the number of users read per query when exporting
+// users. The response is flushed after each batch, so that proxies see
+// progress on long exports.
+const adminExportBatchSize = 1000
+
+const (
+	adminExportFormatNDJSON = "ndjson"
+	adminExportFormatCSV    = "csv"
+)
+
+// adminExportFields are the fields of the users that can be exported, named
+// like in the JSON representation of users.
+var adminExportFields = []string{
+	"id",
+	"aud",
+	"role",
+	"email",
+	"email_confirmed_at",
+	"invited_at",
+	"phone",
+	"phone_confirmed_at",
+	"confirmation_sent_at",
+	"confirmed_at",
+	"recovery_sent_at",
+	"new_email",
+	"email_change_sent_at",
+	"new_phone",
+	"phone_change_sent_at",
+	"last_sign_in_at",
+	"app_metadata",
+	"user_metadata",
+	"identities",
+	"created_at",
+	"updated_at",
+	"banned_until",
+	"deleted_at",
+	"is_anonymous",
+}
+
+// adminExportDefaultFields are exported unless the fields are chosen.
+var adminExportDefaultFields = []string{
+	"id",
+	"email",
+	"phone",
+	"email_confirmed_at",
+	"phone_confirmed_at",
+	"last_sign_in_at",
+	"created_at",
+	"updated_at",
+	"is_anonymous",
+}
+
+// userExportWriter writes the chosen fields of users in an export format.
+type userExportWriter interface {
+	Write(values []interface{}) error
+	Flush() error
+}
+
+type ndjsonExportWriter struct {
+	fields  []string
+	encoder *json.Encoder
+}
+
+func (e *ndjsonExportWriter) Write(values []interface{}) error {
+	row := make(map[string]interface{}, len(values))
+	for i, value := range values {
+		row[e.fields[i]] = value
+	}
+	return e.encoder.Encode(row)
+}
+
+func (e *ndjsonExportWriter) Flush() error {
+	return nil
+}
+
+// csvExportWriter writes a header row with the field names, then one row per
+// user. Missing values are empty and objects and arrays are written as JSON.
+// Strings that spreadsheets would read as formulas are escaped with a
+// leading quote, see escapeCSVFormula.
+type csvExportWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func (e *csvExportWriter) Write(values []interface{}) error {
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			e.record[i] = ""
+		case string:
+			e.record[i] = escapeCSVFormula(v)
+		case json.Number:
+			e.record[i] = v.String()
+		case bool:
+			e.record[i] = fmt.Sprint(v)
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			e.record[i] = string(encoded)
+		}
+	}
+	return e.writer.Write(e.record)
+}
+
+// escapeCSVFormula prefixes value with a quote if it starts like a formula,
+// so that user controlled values, like the metadata or the email, aren't
+// run by spreadsheets opening the export.
+func escapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (e *csvExportWriter) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func newUserExportWriter(w io.Writer, format string, fields []string) (userExportWriter, error) {
+	switch format {
+	case adminExportFormatNDJSON:
+		return &ndjsonExportWriter{fields: fields, encoder: json.NewEncoder(w)}, nil
+	case adminExportFormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(fields); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{writer: writer, record: make([]string, len(fields))}, nil
+	}
+
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// exportUserValues returns the values of the fields of the user, nil for
+// the fields that aren't set.
+func exportUserValues(user *models.User, fields []string) ([]interface{}, error) {
+	encoded, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		values[i] = object[field]
+	}
+	return values, nil
+}
+
+// adminUsersExport streams all of the users matching the filters of the
+// users listing as newline delimited JSON or CSV. Users are read in batches
+// and written as they are read, so the export doesn't need to fit in memory.
+// The export stops when the client disconnects, as the request context
+// cancels the queries.
+func (a *API) adminUsersExport(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	aud := a.requestAud(ctx, r)
+	adminUser := getAdminUser(ctx)
+	query := r.URL.Query()
+
+	sortParams, err := adminUsersSort(r)
+	if err != nil {
+		return err
+	}
+
+	filter, err := adminUsersFilter(r)
+	if err != nil {
+		return err
+	}
+
+	format := query.Get("format")
+	contentType := ""
+	switch format {
+	case "", adminExportFormatNDJSON:
+		format = adminExportFormatNDJSON
+		contentType = "application/x-ndjson"
+	case adminExportFormatCSV:
+		contentType = "text/csv; charset=utf-8"
+	default:
+		return badRequestError(ErrorCodeValidationFailed, "format must be %q or %q", adminExportFormatNDJSON, adminExportFormatCSV)
+	}
+
+	fields := adminExportDefaultFields
+	if value := query.Get("fields"); value != "" {
+		fields = strings.Split(value, ",")
+		for i, field := range fields {
+			fields[i] = strings.TrimSpace(field)
+			if !slices.Contains(adminExportFields, fields[i]) {
+				return badRequestError(ErrorCodeValidationFailed, "Unknown field %q, fields can be: %s", fields[i], strings.Join(adminExportFields, ", "))
+			}
+		}
+	}
+
+	if err := models.NewAuditLogEntry(r, db, adminUser, models.UsersExportedAction, "", map[string]interface{}{
+		"format": format,
+		"fields": fields,
+	}); err != nil {
+		return internalServerError("Error recording audit log entry").WithInternalError(err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"users.%s\"", format))
+	w.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(w)
+	flush := func() error {
+		if err := controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+
+	writer, err := newUserExportWriter(w, format, fields)
+	if err == nil {
+		err = exportUsers(models.NewUserIterator(db, aud, sortParams.Fields[0].Dir, filter, adminExportBatchSize), writer, fields, flush)
+	}
+	if err != nil {
+		// the response has started, so the error can't be sent. Aborting
+		// it tells the client that the export is incomplete.
+		observability.GetLogEntry(r).Entry.WithError(err).Warn("users export failed")
+		panic(http.ErrAbortHandler)
+	}
+
+	return nil
+}
+
+// exportUsers writes the users of it, flushing after each batch.
+func exportUsers(it *models.UserIterator, writer userExportWriter, fields []string, flush func() error) error {
+	count := 0
+	for it.Next() {
+		values, err := exportUserValues(it.User(), fields)
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(values); err != nil {
+			return err
+		}
+
+		count++
+		if count%adminExportBatchSize == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/models"
+)
+
+// seedUsers inserts count users with a single statement. Three users at a
+// time share their created_at, so that the users created at the same time
+// are sorted by ID.
+func (ts *AdminTestSuite) seedUsers(count int) {
+	tableName := (&pop.Model{Value: models.User{}}).TableName()
+	require.NoError(ts.T(), ts.API.db.RawQuery(
+		"insert into "+tableName+" (instance_id, id, aud, role, email, raw_app_meta_data, raw_user_meta_data, created_at, updated_at) "+
+			"select '00000000-0000-0000-0000-000000000000', md5('seeded' || i)::uuid, ?, 'authenticated', 'seeded' || i || '@example.com', '{}', '{}', "+
+			"now() - make_interval(secs => i / 3), now() from generate_series(1, ?) i",
+		ts.Config.JWT.Aud,
+		count,
+	).Exec())
+}
+
+// listUsers returns the IDs of the users of a page of the users listing and
+// the cursor of the next page.
+func (ts *AdminTestSuite) listUsers(path string) ([]string, string) {
+	w := ts.adminRequest(http.MethodGet, path, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	data := AdminListUsersResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+
+	ids := make([]string, len(data.Users))
+	for i, user := range data.Users {
+		ids[i] = user.ID.String()
+	}
+	return ids, data.NextCursor
+}
+
+func (ts *AdminTestSuite) TestAdminUsersCursorPagination() {
+	ts.seedUsers(250)
+
+	ids, cursor := ts.listUsers("/admin/users?per_page=100")
+	require.Len(ts.T(), ids, 100)
+	require.NotEmpty(ts.T(), cursor)
+
+	// users created between the pages neither shift the following pages
+	// nor show up in them
+	for i := 0; i < 10; i++ {
+		u, err := models.NewUser("", fmt.Sprintf("new%d@example.com", i), "password", ts.Config.JWT.Aud, nil)
+		require.NoError(ts.T(), err)
+		require.NoError(ts.T(), ts.API.db.Create(u))
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		seen[id] = true
+	}
+
+	pages := 1
+	for cursor != "" {
+		ids, cursor = ts.listUsers("/admin/users?per_page=100&cursor=" + cursor)
+		for _, id := range ids {
+			require.False(ts.T(), seen[id], "user %s listed twice", id)
+			seen[id] = true
+		}
+		pages++
+	}
+	require.Equal(ts.T(), 3, pages)
+	require.Len(ts.T(), seen, 250)
+
+	// pages after a cursor have the same order as the offset pages
+	offsetIDs, _ := ts.listUsers("/admin/users?per_page=100&page=2&sort=created_at%20asc")
+	_, cursor = ts.listUsers("/admin/users?per_page=100&sort=created_at%20asc")
+	cursorIDs, _ := ts.listUsers("/admin/users?per_page=100&sort=created_at%20asc&cursor=" + cursor)
+	require.Equal(ts.T(), offsetIDs, cursorIDs)
+
+	w := ts.adminRequest(http.MethodGet, "/admin/users?cursor=invalid", nil)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+// exportRecorder is a response writer that only keeps the last line written,
+// and records the flushes and the memory in use when flushing.
+type exportRecorder struct {
+	header http.Header
+	code   int
+
+	lines     int
+	lastLine  []byte
+	unflushed int
+
+	flushes      int
+	maxUnflushed int
+	maxHeap      uint64
+}
+
+func newExportRecorder() *exportRecorder {
+	return &exportRecorder{header: make(http.Header)}
+}
+
+func (e *exportRecorder) Header() http.Header {
+	return e.header
+}
+
+func (e *exportRecorder) WriteHeader(code int) {
+	e.code = code
+}
+
+func (e *exportRecorder) Write(data []byte) (int, error) {
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if bytes.HasSuffix(line, []byte("\n")) {
+			e.lines++
+			e.unflushed++
+			e.lastLine = append(e.lastLine[:0], line...)
+		}
+	}
+	return len(data), nil
+}
+
+func (e *exportRecorder) Flush() {
+	e.flushes++
+	e.maxUnflushed = max(e.maxUnflushed, e.unflushed)
+	e.unflushed = 0
+
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	e.maxHeap = max(e.maxHeap, stats.HeapAlloc)
+}
+
+func (ts *AdminTestSuite) exportRequest(query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/admin/users/export"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *AdminTestSuite) TestAdminUsersExportStreams() {
+	const count = 20000
+	ts.seedUsers(count)
+
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/users/export", nil)
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+	w := newExportRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+
+	require.Equal(ts.T(), http.StatusOK, w.code)
+	require.Equal(ts.T(), "application/x-ndjson", w.header.Get("Content-Type"))
+	require.Equal(ts.T(), count, w.lines)
+
+	// the users are written batch by batch, not all at once
+	require.GreaterOrEqual(ts.T(), w.flushes, count/adminExportBatchSize)
+	require.LessOrEqual(ts.T(), w.maxUnflushed, adminExportBatchSize)
+
+	// only a batch of users is kept in memory, while all of them would
+	// take up tens of megabytes
+	require.Less(ts.T(), w.maxHeap, baseline+16<<20, "heap grew by %d bytes", w.maxHeap-baseline)
+
+	var last map[string]interface{}
+	require.NoError(ts.T(), json.Unmarshal(w.lastLine, &last))
+	require.Len(ts.T(), last, len(adminExportDefaultFields))
+	require.Contains(ts.T(), last, "email")
+}
+
+func (ts *AdminTestSuite) TestAdminUsersExportCSV() {
+	ts.seedUsers(5)
+
+	w := ts.exportRequest("?format=csv&fields=id,email,user_metadata,is_anonymous&filter=seeded1&sort=created_at%20asc")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.Equal(ts.T(), "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(ts.T(), "attachment; filename=\"users.csv\"", w.Header().Get("Content-Disposition"))
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), records, 2)
+	require.Equal(ts.T(), []string{"id", "email", "user_metadata", "is_anonymous"}, records[0])
+	require.Equal(ts.T(), []string{"seeded1@example.com", "{}", "false"}, records[1][1:])
+
+	entries, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Action: models.UsersExportedAction}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), entries, 1)
+}
+
+func TestUserExportWriterCSVFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := newUserExportWriter(&buf, adminExportFormatCSV, []string{"email", "user_metadata", "phone"})
+	require.NoError(t, err)
+
+	for _, values := range [][]interface{}{
+		{"=HYPERLINK(\"https://example.com\")@example.com", map[string]interface{}{"name": "=1+1"}, "+15555550100"},
+		{"-2@example.com", nil, "@SUM(A1)"},
+		{"user@example.com", nil, ""},
+	} {
+		require.NoError(t, writer.Write(values))
+	}
+	require.NoError(t, writer.Flush())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"email", "user_metadata", "phone"},
+		{"'=HYPERLINK(\"https://example.com\")@example.com", `{"name":"=1+1"}`, "'+15555550100"},
+		{"'-2@example.com", "", "'@SUM(A1)"},
+		{"user@example.com", "", ""},
+	}, records)
+
+	_, err = newUserExportWriter(&buf, "xml", nil)
+	require.Error(t, err)
+}
+
+func (ts *AdminTestSuite) TestAdminUsersExportFilters() {
+	ts.seedUsers(3)
+
+	u, err := models.NewUser("", "anonymous@example.com", "", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	u.IsAnonymous = true
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	w := ts.exportRequest("?is_anonymous=true&fields=email,identities")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(ts.T(), json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Equal(ts.T(), []map[string]interface{}{
+		{"email": "anonymous@example.com", "identities": []interface{}{}},
+	}, lines)
+}
+
+func (ts *AdminTestSuite) TestAdminUsersExportInvalidParams() {
+	for _, query := range []string{
+		"?format=xml",
+		"?fields=id,encrypted_password",
+		"?is_anonymous=maybe",
+		"?sort=email",
+	} {
+		w := ts.exportRequest(query)
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code, query)
+	}
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// AdminIdentityParams are the parameters adminUserLinkIdentity accepts
+type AdminIdentityParams struct {
+	Provider     string                 `json:"provider"`
+	ProviderID   string                 `json:"provider_id"`
+	IdentityData map[string]interface{} `json:"identity_data"`
+}
+
+// AdminUserMergeParams are the parameters adminUserMerge accepts
+type AdminUserMergeParams struct {
+	SourceUserID  uuid.UUID `json:"source_user_id"`
+	MergeMetadata bool      `json:"merge_metadata"`
+}
+
+// isEmailOrPhoneIdentity reports whether the identity holds the email address
+// or phone number of its user rather than an external account.
+func isEmailOrPhoneIdentity(provider string) bool {
+	return provider == "email" || provider == "phone"
+}
+
+// adminUserLinkIdentity attaches an identity of an external provider to a
+// user, for example to let them sign in with an account that was used to
+// create a duplicate user.
+func (a *API) adminUserLinkIdentity(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+
+	params := &AdminIdentityParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.Provider == "" || params.ProviderID == "" {
+		return badRequestError(ErrorCodeValidationFailed, "provider and provider_id are required")
+	}
+
+	if isEmailOrPhoneIdentity(params.Provider) {
+		return badRequestError(ErrorCodeValidationFailed, "Email and phone identities are managed by updating the user's email or phone")
+	}
+
+	identityData := params.IdentityData
+	if identityData == nil {
+		identityData = make(map[string]interface{})
+	}
+	if sub, ok := identityData["sub"]; ok && sub != params.ProviderID {
+		return badRequestError(ErrorCodeValidationFailed, "identity_data.sub must match provider_id")
+	}
+	identityData["sub"] = params.ProviderID
+	if email, ok := identityData["email"]; ok {
+		if _, ok := email.(string); !ok {
+			return badRequestError(ErrorCodeValidationFailed, "identity_data.email must be a string")
+		}
+	}
+
+	var identity *models.Identity
+	err := db.Transaction(func(tx *storage.Connection) error {
+		existing, terr := models.FindIdentityByIdAndProvider(tx, params.ProviderID, params.Provider)
+		if terr != nil && !models.IsNotFoundError(terr) {
+			return internalServerError("Database error finding identity").WithInternalError(terr)
+		}
+		if existing != nil {
+			if existing.UserID == user.ID {
+				return unprocessableEntityError(ErrorCodeIdentityAlreadyExists, "Identity is already linked")
+			}
+			return unprocessableEntityError(ErrorCodeIdentityAlreadyExists, "Identity is already linked to another user")
+		}
+
+		if identity, terr = a.createNewIdentity(tx, user, params.Provider, identityData); terr != nil {
+			return terr
+		}
+
+		if terr := user.UpdateAppMetaDataProviders(tx); terr != nil {
+			return internalServerError("Database error updating user providers").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, adminUser, models.IdentityLinkAction, "", map[string]interface{}{
+			"user_id":     user.ID,
+			"identity_id": identity.ID,
+			"provider":    identity.Provider,
+			"provider_id": identity.ProviderID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, identity)
+}
+
+// adminUserUnlinkIdentity detaches an identity from a user. The user must
+// still be able to sign in afterwards, with another identity or a password.
+func (a *API) adminUserUnlinkIdentity(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+
+	identityID, err := uuid.FromString(chi.URLParam(r, "identity_id"))
+	if err != nil {
+		return notFoundError(ErrorCodeValidationFailed, "identity_id must be an UUID")
+	}
+
+	var identity *models.Identity
+	for i := range user.Identities {
+		if user.Identities[i].ID == identityID {
+			identity = &user.Identities[i]
+			break
+		}
+	}
+	if identity == nil {
+		return notFoundError(ErrorCodeIdentityNotFound, "Identity doesn't exist")
+	}
+
+	// a password alone only works while the user keeps an email or phone
+	canUsePassword := user.EncryptedPassword != "" && !isEmailOrPhoneIdentity(identity.Provider) && (user.GetEmail() != "" || user.GetPhone() != "")
+	if len(user.Identities) <= 1 && !canUsePassword {
+		return unprocessableEntityError(ErrorCodeSingleIdentityNotDeletable, "User must have at least one way to sign in after unlinking")
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.IdentityUnlinkAction, "", map[string]interface{}{
+			"user_id":     user.ID,
+			"identity_id": identity.ID,
+			"provider":    identity.Provider,
+			"provider_id": identity.ProviderID,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		return a.unlinkIdentity(tx, user, identity)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+// adminUserMerge moves the external identities of a duplicate source user to
+// the target user and soft deletes the source user. The source user's email
+// and phone identities are not moved, as they belong to its email and phone.
+// With merge_metadata, user metadata keys the target user doesn't have are
+// copied from the source user.
+func (a *API) adminUserMerge(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+
+	params := &AdminUserMergeParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.SourceUserID == uuid.Nil {
+		return badRequestError(ErrorCodeValidationFailed, "source_user_id is required")
+	}
+	if params.SourceUserID == user.ID {
+		return badRequestError(ErrorCodeValidationFailed, "A user can't be merged into itself")
+	}
+	if user.DeletedAt != nil {
+		return unprocessableEntityError(ErrorCodeUserNotFound, "User has been deleted")
+	}
+
+	var source *models.User
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		source, terr = models.FindUserByID(tx, params.SourceUserID)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				return notFoundError(ErrorCodeUserNotFound, "Source user not found")
+			}
+			return internalServerError("Database error finding user").WithInternalError(terr)
+		}
+		if source.DeletedAt != nil {
+			return unprocessableEntityError(ErrorCodeUserNotFound, "Source user has been deleted")
+		}
+		if source.Aud != user.Aud {
+			return badRequestError(ErrorCodeValidationFailed, "Source user belongs to another audience")
+		}
+
+		movedIdentityIDs := []uuid.UUID{}
+		for i := range source.Identities {
+			identity := &source.Identities[i]
+			if isEmailOrPhoneIdentity(identity.Provider) {
+				continue
+			}
+
+			identity.UserID = user.ID
+			if terr := tx.UpdateOnly(identity, "user_id"); terr != nil {
+				return internalServerError("Database error moving identity").WithInternalError(terr)
+			}
+			movedIdentityIDs = append(movedIdentityIDs, identity.ID)
+		}
+
+		if params.MergeMetadata {
+			updates := make(map[string]interface{})
+			for key, value := range source.UserMetaData {
+				if _, ok := user.UserMetaData[key]; !ok {
+					updates[key] = value
+				}
+			}
+			if len(updates) > 0 {
+				if terr := user.UpdateUserMetaData(tx, updates); terr != nil {
+					return internalServerError("Database error updating user metadata").WithInternalError(terr)
+				}
+			}
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.UserMergedAction, "", map[string]interface{}{
+			"user_id":        user.ID,
+			"source_user_id": source.ID,
+			"identity_ids":   movedIdentityIDs,
+			"merge_metadata": params.MergeMetadata,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		if terr := softDeleteUser(tx, source); terr != nil {
+			return terr
+		}
+
+		if user, terr = models.FindUserByID(tx, user.ID); terr != nil {
+			return internalServerError("Database error loading user").WithInternalError(terr)
+		}
+
+		if terr := user.UpdateAppMetaDataProviders(tx); terr != nil {
+			return internalServerError("Database error updating user providers").WithInternalError(terr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	a.notifyWebhook(r, db, conf.WebhookUserDeletedEvent, source)
+
+	return sendJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/models"
+)
+
+func (ts *AdminTestSuite) adminRequest(method, path string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if body != nil {
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+// createUserWithIdentity creates a user with an identity of the provider.
+func (ts *AdminTestSuite) createUserWithIdentity(email, password, provider string) *models.User {
+	u, err := models.NewUser("", email, password, ts.Config.JWT.Aud, map[string]interface{}{
+		"name": email,
+	})
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	sub := u.ID.String()
+	if provider != "email" {
+		sub = "external-" + email
+	}
+	identity, err := models.NewIdentity(u, provider, map[string]interface{}{
+		"sub":   sub,
+		"email": email,
+	})
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(identity))
+
+	u, err = models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	return u
+}
+
+func (ts *AdminTestSuite) TestAdminUserLinkIdentity() {
+	u := ts.createUserWithIdentity("test@example.com", "test123", "email")
+	other := ts.createUserWithIdentity("other@example.com", "", "google")
+
+	w := ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/identities", u.ID), map[string]interface{}{
+		"provider":    "github",
+		"provider_id": "12345",
+		"identity_data": map[string]interface{}{
+			"user_name": "octocat",
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	identity, err := models.FindIdentityByIdAndProvider(ts.API.db, "12345", "github")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), u.ID, identity.UserID)
+	require.Equal(ts.T(), "octocat", identity.IdentityData["user_name"])
+
+	u, err = models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.ElementsMatch(ts.T(), []interface{}{"email", "github"}, u.AppMetaData["providers"])
+
+	cases := []struct {
+		desc   string
+		params map[string]interface{}
+		code   int
+	}{
+		{
+			desc:   "Already linked to the user",
+			params: map[string]interface{}{"provider": "github", "provider_id": "12345"},
+			code:   http.StatusUnprocessableEntity,
+		},
+		{
+			desc:   "Linked to another user",
+			params: map[string]interface{}{"provider": "google", "provider_id": "external-" + other.GetEmail()},
+			code:   http.StatusUnprocessableEntity,
+		},
+		{
+			desc:   "Email identity",
+			params: map[string]interface{}{"provider": "email", "provider_id": "another@example.com"},
+			code:   http.StatusBadRequest,
+		},
+		{
+			desc:   "Missing provider id",
+			params: map[string]interface{}{"provider": "github"},
+			code:   http.StatusBadRequest,
+		},
+		{
+			desc: "Mismatched sub",
+			params: map[string]interface{}{
+				"provider":      "github",
+				"provider_id":   "67890",
+				"identity_data": map[string]interface{}{"sub": "12345"},
+			},
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.desc, func() {
+			w := ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/identities", u.ID), c.params)
+			require.Equal(ts.T(), c.code, w.Code, w.Body.String())
+		})
+	}
+
+	// the conflicting identity stays with its user
+	identity, err = models.FindIdentityByIdAndProvider(ts.API.db, "external-"+other.GetEmail(), "google")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), other.ID, identity.UserID)
+}
+
+func (ts *AdminTestSuite) TestAdminUserUnlinkIdentity() {
+	// a passwordless user can't lose their only identity
+	passwordless := ts.createUserWithIdentity("passwordless@example.com", "", "google")
+	w := ts.adminRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%s/identities/%s", passwordless.ID, passwordless.Identities[0].ID), nil)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	// nor can a user whose password only works with the email identity
+	u := ts.createUserWithIdentity("test@example.com", "test123", "email")
+	w = ts.adminRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%s/identities/%s", u.ID, u.Identities[0].ID), nil)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	w = ts.adminRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%s/identities/%s", u.ID, uuid.Must(uuid.NewV4())), nil)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code, w.Body.String())
+
+	w = ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/identities", u.ID), map[string]interface{}{
+		"provider":    "github",
+		"provider_id": "12345",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	identity, err := models.FindIdentityByIdAndProvider(ts.API.db, "12345", "github")
+	require.NoError(ts.T(), err)
+
+	w = ts.adminRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%s/identities/%s", u.ID, identity.ID), nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	_, err = models.FindIdentityByIdAndProvider(ts.API.db, "12345", "github")
+	require.True(ts.T(), models.IsNotFoundError(err))
+
+	u, err = models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), []interface{}{"email"}, u.AppMetaData["providers"])
+}
+
+func (ts *AdminTestSuite) TestAdminUserMerge() {
+	target := ts.createUserWithIdentity("test@example.com", "test123", "email")
+	source := ts.createUserWithIdentity("test@gmail.example.com", "", "google")
+	require.NoError(ts.T(), source.UpdateUserMetaData(ts.API.db, map[string]interface{}{
+		"avatar_url": "https://example.com/avatar.png",
+	}))
+
+	cases := []struct {
+		desc   string
+		params map[string]interface{}
+		code   int
+	}{
+		{
+			desc:   "Merge into itself",
+			params: map[string]interface{}{"source_user_id": target.ID},
+			code:   http.StatusBadRequest,
+		},
+		{
+			desc:   "Unknown source user",
+			params: map[string]interface{}{"source_user_id": uuid.Must(uuid.NewV4())},
+			code:   http.StatusNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.desc, func() {
+			w := ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/merge", target.ID), c.params)
+			require.Equal(ts.T(), c.code, w.Code, w.Body.String())
+		})
+	}
+
+	w := ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/merge", target.ID), map[string]interface{}{
+		"source_user_id": source.ID,
+		"merge_metadata": true,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	target, err := models.FindUserByID(ts.API.db, target.ID)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), target.Identities, 2)
+	require.ElementsMatch(ts.T(), []interface{}{"email", "google"}, target.AppMetaData["providers"])
+	require.Equal(ts.T(), "test@example.com", target.GetEmail())
+
+	// keys the target has keep their value
+	require.Equal(ts.T(), "test@example.com", target.UserMetaData["name"])
+	require.Equal(ts.T(), "https://example.com/avatar.png", target.UserMetaData["avatar_url"])
+
+	identity, err := models.FindIdentityByIdAndProvider(ts.API.db, "external-test@gmail.example.com", "google")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), target.ID, identity.UserID)
+
+	source, err = models.FindUserByID(ts.API.db, source.ID)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), source.DeletedAt)
+	require.Empty(ts.T(), source.Identities)
+
+	// a soft deleted user can't be merged again
+	w = ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/merge", target.ID), map[string]interface{}{
+		"source_user_id": source.ID,
+	})
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	entries, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Action: models.UserMergedAction}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), entries, 1)
+	require.Equal(ts.T(), source.ID.String(), entries[0].Payload["traits"].(map[string]interface{})["source_user_id"])
+}
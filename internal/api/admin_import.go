@@ -0,0 +1,389 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/gofrs/uuid"
+	"github.com/supabase/auth/internal/api/provider"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// adminImportBatchSize is the number of users inserted per transaction when
+// importing users.
+const adminImportBatchSize = 500
+
+const (
+	adminImportStatusCreated   = "created"
+	adminImportStatusDuplicate = "skipped_duplicate"
+	adminImportStatusError     = "error"
+)
+
+// AdminImportUserParams describes a single user to be imported. Passwords
+// can be imported as bcrypt, argon2, scrypt or Firebase scrypt hashes, see
+// crypto.ValidatePasswordHash.
+type AdminImportUserParams struct {
+	Email            string                 `json:"email"`
+	Phone            string                 `json:"phone"`
+	PasswordHash     string                 `json:"password_hash"`
+	Role             string                 `json:"role"`
+	EmailConfirmedAt *time.Time             `json:"email_confirmed_at"`
+	PhoneConfirmedAt *time.Time             `json:"phone_confirmed_at"`
+	UserMetaData     map[string]interface{} `json:"user_metadata"`
+	AppMetaData      map[string]interface{} `json:"app_metadata"`
+}
+
+// AdminImportUserResult reports what happened to a single row of an import.
+type AdminImportUserResult struct {
+	Index  int        `json:"index"`
+	Status string     `json:"status"`
+	UserID *uuid.UUID `json:"user_id,omitempty"`
+	Email  string     `json:"email,omitempty"`
+	Phone  string     `json:"phone,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+type AdminImportUsersResponse struct {
+	DryRun  bool                     `json:"dry_run"`
+	Created int                      `json:"created"`
+	Skipped int                      `json:"skipped"`
+	Failed  int                      `json:"failed"`
+	Results []*AdminImportUserResult `json:"results"`
+}
+
+// pendingImport is a validated row that is ready to be inserted.
+type pendingImport struct {
+	result     *AdminImportUserResult
+	user       *models.User
+	identities []models.Identity
+}
+
+// adminImportReader reads the users to import one at a time from either a
+// JSON array or a stream of newline delimited JSON objects, so that the body
+// of large imports doesn't need to fit in memory.
+type adminImportReader struct {
+	reader  *bufio.Reader
+	decoder *json.Decoder
+	array   bool
+	index   int
+}
+
+func newAdminImportReader(body io.Reader) *adminImportReader {
+	return &adminImportReader{reader: bufio.NewReader(body)}
+}
+
+// start tells arrays from newline delimited JSON by the first character of
+// the body. It returns false if the body is empty.
+func (ir *adminImportReader) start() (bool, error) {
+	for {
+		c, err := ir.reader.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+
+		if err := ir.reader.UnreadByte(); err != nil {
+			return false, err
+		}
+
+		ir.array = c == '['
+		ir.decoder = json.NewDecoder(ir.reader)
+		if ir.array {
+			// reads the opening bracket
+			if _, err := ir.decoder.Token(); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// Next reads the next row into row. It returns false once there are no more
+// rows.
+func (ir *adminImportReader) Next(row *AdminImportUserParams) (bool, error) {
+	if ir.decoder == nil {
+		if ok, err := ir.start(); err != nil {
+			return false, badRequestError(ErrorCodeBadJSON, "Could not parse request body as JSON: %v", err)
+		} else if !ok {
+			return false, nil
+		}
+	}
+
+	if ir.array && !ir.decoder.More() {
+		// reads the closing bracket
+		if _, err := ir.decoder.Token(); err != nil {
+			return false, badRequestError(ErrorCodeBadJSON, "Could not parse request body as JSON: %v", err)
+		}
+		if ir.decoder.More() {
+			return false, badRequestError(ErrorCodeBadJSON, "Could not parse request body as JSON: unexpected data after the array")
+		}
+		return false, nil
+	}
+
+	if err := ir.decoder.Decode(row); err == io.EOF && !ir.array {
+		return false, nil
+	} else if err != nil {
+		return false, badRequestError(ErrorCodeBadJSON, "Could not parse row %d as JSON: %v", ir.index, err)
+	}
+
+	ir.index++
+	return true, nil
+}
+
+func adminImportErrorMessage(err error) string {
+	if httpErr, ok := err.(*HTTPError); ok {
+		return httpErr.Message
+	}
+	return err.Error()
+}
+
+// adminUsersImport creates users in bulk from pre-hashed passwords. Rows are
+// validated and reported individually, so a bad row does not fail the import.
+// The rows are read and inserted in batches as the body is read. A row that
+// can't be parsed ends the import: it's reported as an error and the rows
+// after it aren't read.
+func (a *API) adminUsersImport(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	aud := a.requestAud(ctx, r)
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	response := &AdminImportUsersResponse{
+		DryRun:  dryRun,
+		Results: []*AdminImportUserResult{},
+	}
+
+	seenEmails := make(map[string]bool)
+	seenPhones := make(map[string]bool)
+
+	rows := newAdminImportReader(r.Body)
+	pending := make([]*pendingImport, 0, adminImportBatchSize)
+	for {
+		var row AdminImportUserParams
+		more, err := rows.Next(&row)
+		if err != nil {
+			if len(response.Results) == 0 {
+				return err
+			}
+			response.Results = append(response.Results, &AdminImportUserResult{
+				Index:  len(response.Results),
+				Status: adminImportStatusError,
+				Error:  adminImportErrorMessage(err),
+			})
+			break
+		} else if !more {
+			break
+		}
+
+		result := &AdminImportUserResult{
+			Index: len(response.Results),
+			Email: row.Email,
+			Phone: row.Phone,
+		}
+		response.Results = append(response.Results, result)
+
+		p, err := a.validateImportRow(db, aud, &row, seenEmails, seenPhones)
+		if err != nil {
+			result.Status = adminImportStatusError
+			result.Error = adminImportErrorMessage(err)
+			continue
+		} else if p == nil {
+			result.Status = adminImportStatusDuplicate
+			continue
+		}
+
+		p.result = result
+		result.Email = p.user.GetEmail()
+		result.Phone = p.user.GetPhone()
+		pending = append(pending, p)
+
+		if len(pending) == adminImportBatchSize {
+			a.importUsersBatch(r, db, pending, dryRun)
+			pending = pending[:0]
+		}
+	}
+
+	if len(response.Results) == 0 {
+		return badRequestError(ErrorCodeValidationFailed, "No users to import")
+	}
+
+	a.importUsersBatch(r, db, pending, dryRun)
+
+	for _, result := range response.Results {
+		switch result.Status {
+		case adminImportStatusCreated:
+			response.Created++
+		case adminImportStatusDuplicate:
+			response.Skipped++
+		default:
+			response.Failed++
+		}
+	}
+
+	return sendJSON(w, http.StatusOK, response)
+}
+
+// importUsersBatch inserts the users of a batch in a single transaction and
+// sets the results of their rows. Each user is inserted behind a savepoint,
+// so that a user that can't be inserted, for example because a concurrent
+// sign up took its email, fails its own row instead of the whole batch.
+func (a *API) importUsersBatch(r *http.Request, db *storage.Connection, batch []*pendingImport, dryRun bool) {
+	if dryRun {
+		for _, p := range batch {
+			p.result.Status = adminImportStatusCreated
+		}
+		return
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	config := a.config
+	adminUser := getAdminUser(r.Context())
+
+	terr := db.Transaction(func(tx *storage.Connection) error {
+		userIDs := make([]uuid.UUID, 0, len(batch))
+		for _, p := range batch {
+			if p.user.Role == "" {
+				p.user.Role = config.JWT.DefaultGroupName
+			}
+
+			err := tx.Savepoint("import_user", func() error {
+				if err := tx.Create(p.user); err != nil {
+					return err
+				}
+				return tx.Create(&p.identities)
+			})
+			var savepointErr *storage.SavepointError
+			if errors.As(err, &savepointErr) {
+				return err
+			} else if err != nil {
+				p.result.Status = adminImportStatusError
+				p.result.Error = "Database error importing user"
+				continue
+			}
+
+			id := p.user.ID
+			p.result.Status = adminImportStatusCreated
+			p.result.UserID = &id
+			userIDs = append(userIDs, id)
+		}
+
+		if len(userIDs) == 0 {
+			return nil
+		}
+
+		return models.NewAuditLogEntry(r, tx, adminUser, models.UsersImportedAction, "", map[string]interface{}{
+			"user_ids": userIDs,
+		})
+	})
+	if terr != nil {
+		for _, p := range batch {
+			p.result.Status = adminImportStatusError
+			p.result.Error = "Database error importing user"
+			p.result.UserID = nil
+		}
+	}
+}
+
+// validateImportRow checks a single row and builds the user and identities to
+// insert. It returns nil without an error when the row is a duplicate of an
+// existing user or of an earlier row in the same import.
+func (a *API) validateImportRow(db *storage.Connection, aud string, row *AdminImportUserParams, seenEmails, seenPhones map[string]bool) (*pendingImport, error) {
+	var err error
+
+	if row.Email == "" && row.Phone == "" {
+		return nil, badRequestError(ErrorCodeValidationFailed, "Cannot import a user without either an email or phone")
+	}
+
+	var providers []string
+	if row.Email != "" {
+		row.Email, err = validateEmail(row.Email)
+		if err != nil {
+			return nil, err
+		}
+		if seenEmails[row.Email] {
+			return nil, nil
+		}
+		if user, err := models.IsDuplicatedEmail(db, row.Email, aud, nil); err != nil {
+			return nil, internalServerError("Database error checking email").WithInternalError(err)
+		} else if user != nil {
+			return nil, nil
+		}
+		providers = append(providers, "email")
+	}
+
+	if row.Phone != "" {
+		row.Phone, err = validatePhone(row.Phone)
+		if err != nil {
+			return nil, err
+		}
+		if seenPhones[row.Phone] {
+			return nil, nil
+		}
+		if exists, err := models.IsDuplicatedPhone(db, row.Phone, aud); err != nil {
+			return nil, internalServerError("Database error checking phone").WithInternalError(err)
+		} else if exists {
+			return nil, nil
+		}
+		providers = append(providers, "phone")
+	}
+
+	user, err := models.NewUserWithPasswordHash(row.Phone, row.Email, row.PasswordHash, aud, row.UserMetaData)
+	if err != nil {
+		return nil, badRequestError(ErrorCodeValidationFailed, "Invalid password hash, only bcrypt, argon2, scrypt and Firebase scrypt hashes can be imported")
+	}
+
+	user.Role = row.Role
+	user.EmailConfirmedAt = row.EmailConfirmedAt
+	user.PhoneConfirmedAt = row.PhoneConfirmedAt
+	user.AppMetaData = map[string]interface{}{
+		"provider":  providers[0],
+		"providers": providers,
+	}
+	for key, value := range row.AppMetaData {
+		user.AppMetaData[key] = value
+	}
+
+	p := &pendingImport{user: user}
+
+	if row.Email != "" {
+		identity, err := models.NewIdentity(user, "email", structs.Map(provider.Claims{
+			Subject:       user.ID.String(),
+			Email:         user.GetEmail(),
+			EmailVerified: row.EmailConfirmedAt != nil,
+		}))
+		if err != nil {
+			return nil, internalServerError("Error creating identity").WithInternalError(err)
+		}
+		p.identities = append(p.identities, *identity)
+		seenEmails[row.Email] = true
+	}
+
+	if row.Phone != "" {
+		identity, err := models.NewIdentity(user, "phone", structs.Map(provider.Claims{
+			Subject:       user.ID.String(),
+			Phone:         user.GetPhone(),
+			PhoneVerified: row.PhoneConfirmedAt != nil,
+		}))
+		if err != nil {
+			return nil, internalServerError("Error creating identity").WithInternalError(err)
+		}
+		p.identities = append(p.identities, *identity)
+		seenPhones[row.Phone] = true
+	}
+
+	return p, nil
+}
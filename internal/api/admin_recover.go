@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/supabase/auth/internal/crypto"
+	mail "github.com/supabase/auth/internal/mailer"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+// AdminUserRecoverResponse is the response of adminUserRecover. The link is
+// only set when it was requested with return_link instead of being emailed.
+type AdminUserRecoverResponse struct {
+	ActionLink string `json:"action_link,omitempty"`
+	RedirectTo string `json:"redirect_to,omitempty"`
+}
+
+// adminUserRecover starts a password recovery on behalf of the user. The
+// recovery email is sent to the user, unless return_link is set, in which case
+// the recovery link is returned to the admin instead.
+func (a *API) adminUserRecover(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.getConfig(ctx)
+	user := getUser(ctx)
+	adminUser := getAdminUser(ctx)
+
+	returnLink := false
+	if value := r.URL.Query().Get("return_link"); value != "" {
+		var err error
+		if returnLink, err = strconv.ParseBool(value); err != nil {
+			return badRequestError(ErrorCodeValidationFailed, "return_link must be a boolean")
+		}
+	}
+
+	if user.DeletedAt != nil {
+		return unprocessableEntityError(ErrorCodeUserNotFound, "User has been deleted")
+	}
+	if user.GetEmail() == "" {
+		return unprocessableEntityError(ErrorCodeValidationFailed, "User has no email address to send the recovery to")
+	}
+
+	referrer := utilities.GetReferrer(r, config)
+
+	response := &AdminUserRecoverResponse{}
+	err := db.Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, adminUser, models.UserRecoveryRequestedAction, "", map[string]interface{}{
+			"user_id":     user.ID,
+			"user_email":  user.Email,
+			"return_link": returnLink,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		if !returnLink {
+			return a.handleEmailSendLimit(r, "recover", a.sendPasswordRecovery(r, tx, user, models.ImplicitFlow))
+		}
+
+		otp, terr := generateOtp(config.Mailer.OtpLength, config.Mailer.OtpAlphabet)
+		if terr != nil {
+			// OTP generation must always succeed
+			panic(terr)
+		}
+
+		now := time.Now()
+		user.RecoveryToken = crypto.GenerateTokenHash(user.GetEmail(), otp)
+		user.RecoverySentAt = &now
+		if terr := tx.UpdateOnly(user, "recovery_token", "recovery_sent_at"); terr != nil {
+			return internalServerError("Database error updating user for recovery").WithInternalError(terr)
+		}
+
+		if terr := models.CreateOneTimeToken(tx, user.ID, user.GetEmail(), user.RecoveryToken, models.RecoveryToken); terr != nil {
+			return internalServerError("Database error creating recovery token").WithInternalError(terr)
+		}
+
+		response.ActionLink, terr = a.Mailer(ctx).GetEmailActionLink(user, mail.RecoveryVerification, referrer, getExternalHost(ctx))
+		if terr != nil {
+			return internalServerError("Error creating recovery link").WithInternalError(terr)
+		}
+		response.RedirectTo = referrer
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, MaxFrequencyLimitError) {
+			return tooManyRequestsError(ErrorCodeOverEmailSendRateLimit, "For security purposes, you can only request this once every 60 seconds")
+		}
+		return mailerError("Unable to send recovery email", err)
+	}
+
+	return sendJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/models"
+)
+
+// requireRecoveryRequestedByAdmin checks that the last recovery request of the
+// user was audited with the admin as the actor.
+func (ts *AdminTestSuite) requireRecoveryRequestedByAdmin(u *models.User, returnLink bool) {
+	entries, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Action: models.UserRecoveryRequestedAction}, nil)
+	require.NoError(ts.T(), err)
+	require.NotEmpty(ts.T(), entries)
+
+	payload := entries[0].Payload
+	require.Equal(ts.T(), "supabase_admin", payload["actor_username"])
+	traits := payload["traits"].(map[string]interface{})
+	require.Equal(ts.T(), u.ID.String(), traits["user_id"])
+	require.Equal(ts.T(), returnLink, traits["return_link"])
+}
+
+func (ts *AdminTestSuite) TestAdminUserRecoverReturnLink() {
+	u := ts.createUserWithIdentity("test@example.com", "test123", "email")
+
+	w := ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/recover?return_link=true", u.ID), nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := &AdminUserRecoverResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	require.NotEmpty(ts.T(), resp.ActionLink)
+	require.Equal(ts.T(), ts.Config.SiteURL, resp.RedirectTo)
+
+	u, err := models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.NotEmpty(ts.T(), u.RecoveryToken)
+	require.NotNil(ts.T(), u.RecoverySentAt)
+
+	// the link verifies the user's current recovery token
+	link, err := url.Parse(resp.ActionLink)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "recovery", link.Query().Get("type"))
+	require.Equal(ts.T(), u.RecoveryToken, link.Query().Get("token"))
+
+	_, err = models.FindOneTimeToken(ts.API.db, u.RecoveryToken, models.RecoveryToken)
+	require.NoError(ts.T(), err)
+
+	ts.requireRecoveryRequestedByAdmin(u, true)
+}
+
+func (ts *AdminTestSuite) TestAdminUserRecoverEmail() {
+	u := ts.createUserWithIdentity("test@example.com", "test123", "email")
+
+	w := ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/recover", u.ID), nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// the link is only sent to the user
+	data := map[string]interface{}{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.NotContains(ts.T(), data, "action_link")
+
+	u, err := models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.NotEmpty(ts.T(), u.RecoveryToken)
+	require.NotNil(ts.T(), u.RecoverySentAt)
+
+	_, err = models.FindOneTimeToken(ts.API.db, u.RecoveryToken, models.RecoveryToken)
+	require.NoError(ts.T(), err)
+
+	ts.requireRecoveryRequestedByAdmin(u, false)
+}
+
+func (ts *AdminTestSuite) TestAdminUserRecoverInvalid() {
+	u, err := models.NewUser("123456789", "", "test123", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	w := ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/recover", u.ID), nil)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	w = ts.adminRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/recover?return_link=maybe", u.ID), nil)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func (ts *AdminTestSuite) TestAdminUserGetFactorsAndSessions() {
+	u := ts.createUserWithIdentity("test@example.com", "test123", "email")
+
+	f := models.NewFactor(u, "authenticator", models.TOTP, models.FactorStateVerified)
+	require.NoError(ts.T(), f.SetSecret("factorsecret", ts.Config.Security.DBEncryption.Encrypt, ts.Config.Security.DBEncryption.EncryptionKeyID, ts.Config.Security.DBEncryption.EncryptionKey))
+	require.NoError(ts.T(), ts.API.db.Create(f))
+
+	for i := 0; i < 2; i++ {
+		s, err := models.NewSession(u.ID, nil)
+		require.NoError(ts.T(), err)
+		require.NoError(ts.T(), ts.API.db.Create(s))
+	}
+
+	w := ts.adminRequest(http.MethodGet, fmt.Sprintf("/admin/users/%s", u.ID), nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.NotContains(ts.T(), w.Body.String(), "factorsecret")
+	require.NotContains(ts.T(), w.Body.String(), f.Secret)
+
+	data := map[string]interface{}{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Equal(ts.T(), float64(2), data["active_sessions"])
+
+	factors := data["factors"].([]interface{})
+	require.Len(ts.T(), factors, 1)
+	factor := factors[0].(map[string]interface{})
+	require.Equal(ts.T(), models.TOTP, factor["factor_type"])
+	require.Equal(ts.T(), models.FactorStateVerified.String(), factor["status"])
+	require.NotEmpty(ts.T(), factor["created_at"])
+	require.NotContains(ts.T(), factor, "secret")
+
+	entries, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Action: models.UserViewedAction}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), entries, 1)
+	require.Equal(ts.T(), "supabase_admin", entries[0].Payload["actor_username"])
+}
@@ -7,14 +7,17 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gofrs/uuid"
 	jwt "github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
 	"github.com/supabase/auth/internal/models"
 )
 
@@ -58,6 +61,10 @@ func (ts *AdminTestSuite) TestAdminUsersUnauthorized() {
 
 	ts.API.handler.ServeHTTP(w, req)
 	assert.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeNoAuthorization, data.ErrorCode)
 }
 
 // TestAdminUsers tests API /admin/users route
@@ -75,6 +82,43 @@ func (ts *AdminTestSuite) TestAdminUsers() {
 	assert.Equal(ts.T(), "0", w.Header().Get("X-Total-Count"))
 }
 
+// TestAdminUsersIncludesIdentities tests that listed users include their
+// identities and last sign in time
+func (ts *AdminTestSuite) TestAdminUsersIncludesIdentities() {
+	u, err := models.NewUser("", "test1@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err, "Error making new user")
+	require.NoError(ts.T(), ts.API.db.Create(u), "Error creating user")
+
+	identity, err := models.NewIdentity(u, "github", map[string]interface{}{
+		"sub":   "123456",
+		"email": "test1@example.com",
+	})
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(identity))
+
+	_, err = models.GrantAuthenticatedUser(ts.API.db, u, models.GrantParams{})
+	require.NoError(ts.T(), err)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	data := AdminListUsersResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Len(ts.T(), data.Users, 1)
+
+	user := data.Users[0]
+	require.NotNil(ts.T(), user.LastSignInAt)
+	require.Len(ts.T(), user.Identities, 1)
+	require.Equal(ts.T(), "github", user.Identities[0].Provider)
+	require.Equal(ts.T(), "test1@example.com", user.Identities[0].IdentityData["email"])
+	require.False(ts.T(), user.Identities[0].CreatedAt.IsZero())
+	require.False(ts.T(), user.Identities[0].UpdatedAt.IsZero())
+}
+
 // TestAdminUsers tests API /admin/users route
 func (ts *AdminTestSuite) TestAdminUsers_Pagination() {
 	u, err := models.NewUser("12345678", "test1@example.com", "test", ts.Config.JWT.Aud, nil)
@@ -385,6 +429,12 @@ func (ts *AdminTestSuite) TestAdminUserGet() {
 	md := data["user_metadata"].(map[string]interface{})
 	assert.Len(ts.T(), md, 1)
 	assert.Equal(ts.T(), "Test Get User", md["full_name"])
+
+	w = ts.adminRequest(http.MethodGet, fmt.Sprintf("/admin/users/%s", uuid.Must(uuid.NewV4())), nil)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+	httpErr := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(httpErr))
+	require.Equal(ts.T(), ErrorCodeUserNotFound, httpErr.ErrorCode)
 }
 
 // TestAdminUserUpdate tests API /admin/user route (UPDATE)
@@ -453,6 +503,104 @@ func (ts *AdminTestSuite) TestAdminUserUpdate() {
 	}
 }
 
+// TestAdminUserUpdateMetadata tests that PUT /admin/users/<user_id> merges
+// the metadata into the existing metadata
+func (ts *AdminTestSuite) TestAdminUserUpdateMetadata() {
+	u, err := models.NewUser("", "test1@example.com", "test", ts.Config.JWT.Aud, map[string]interface{}{
+		"name":     "David",
+		"nickname": "Dave",
+	})
+	require.NoError(ts.T(), err)
+	u.AppMetaData = map[string]interface{}{
+		"plan": "free",
+	}
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	role := u.Role
+
+	w := ts.adminRequest(http.MethodPut, fmt.Sprintf("/admin/users/%s", u.ID), map[string]interface{}{
+		"user_metadata": map[string]interface{}{
+			"nickname": nil,
+			"country":  "NZ",
+		},
+		"app_metadata": map[string]interface{}{
+			"roles": []string{"writer"},
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	u, err = models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), map[string]interface{}{
+		"name":    "David",
+		"country": "NZ",
+	}, map[string]interface{}(u.UserMetaData))
+	require.Equal(ts.T(), "free", u.AppMetaData["plan"])
+	require.Equal(ts.T(), []interface{}{"writer"}, u.AppMetaData["roles"])
+
+	// fields left out are kept
+	require.Equal(ts.T(), "test1@example.com", u.GetEmail())
+	require.Equal(ts.T(), role, u.Role)
+}
+
+// TestAdminUserByID tests that the /admin/users/<user_id> routes resolve the
+// user by the ID in the path only
+func (ts *AdminTestSuite) TestAdminUserByID() {
+	u, err := models.NewUser("", "test1@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	// the IDs of the list can be used in the URLs
+	w := ts.adminRequest(http.MethodGet, "/admin/users", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	list := AdminListUsersResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&list))
+	require.Len(ts.T(), list.Users, 1)
+
+	w = ts.adminRequest(http.MethodGet, fmt.Sprintf("/admin/users/%s", list.Users[0].ID), nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	unknownID := uuid.Must(uuid.NewV4())
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		w = ts.adminRequest(method, fmt.Sprintf("/admin/users/%s", unknownID), map[string]interface{}{
+			"email": "test1@example.com",
+		})
+		require.Equal(ts.T(), http.StatusNotFound, w.Code, method)
+		httpErr := &HTTPError{}
+		require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(httpErr))
+		require.Equal(ts.T(), ErrorCodeUserNotFound, httpErr.ErrorCode)
+
+		w = ts.adminRequest(method, "/admin/users/not-an-id", nil)
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code, method)
+		httpErr = &HTTPError{}
+		require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(httpErr))
+		require.Equal(ts.T(), ErrorCodeValidationFailed, httpErr.ErrorCode)
+	}
+}
+
+// TestAdminUserLegacyRoute tests that the /admin/user/<user_id> routes of
+// older clients keep working
+func (ts *AdminTestSuite) TestAdminUserLegacyRoute() {
+	u, err := models.NewUser("", "test1@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	w := ts.adminRequest(http.MethodGet, fmt.Sprintf("/admin/user/%s", u.ID), nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	w = ts.adminRequest(http.MethodPut, fmt.Sprintf("/admin/user/%s", u.ID), map[string]interface{}{
+		"role": "testing",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	data := models.User{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Equal(ts.T(), "testing", data.Role)
+
+	w = ts.adminRequest(http.MethodDelete, fmt.Sprintf("/admin/user/%s", u.ID), nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	_, err = models.FindUserByID(ts.API.db, u.ID)
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
 func (ts *AdminTestSuite) TestAdminUserUpdatePasswordFailed() {
 	u, err := models.NewUser("12345678", "test1@example.com", "test", ts.Config.JWT.Aud, nil)
 	require.NoError(ts.T(), err, "Error making new user")
@@ -561,7 +709,7 @@ func (ts *AdminTestSuite) TestAdminUserDelete() {
 			require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(c.body))
 			u, err := signupParams.ToUserModel(false /* <- isSSOUser */)
 			require.NoError(ts.T(), err)
-			u, err = ts.API.signupNewUser(ts.API.db, u)
+			u, err = ts.API.signupNewUser(httptest.NewRequest(http.MethodPost, "/signup", nil), ts.API.db, u)
 			require.NoError(ts.T(), err)
 
 			// Setup request
@@ -651,6 +799,167 @@ func (ts *AdminTestSuite) TestAdminUserSoftDeletion() {
 	}
 }
 
+func (ts *AdminTestSuite) TestAdminUserSoftDeletionLifecycle() {
+	ts.Config.Mailer.Autoconfirm = true
+	defer func() {
+		ts.Config.Mailer.Autoconfirm = false
+	}()
+
+	u, err := models.NewUser("", "soft-delete@example.com", "test-password", ts.Config.JWT.Aud, map[string]interface{}{"name": "test"})
+	require.NoError(ts.T(), err)
+	u.AppMetaData = map[string]interface{}{
+		"provider": "email",
+	}
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	require.NoError(ts.T(), u.Confirm(ts.API.db))
+
+	_, err = models.GrantAuthenticatedUser(ts.API.db, u, models.GrantParams{})
+	require.NoError(ts.T(), err)
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"should_soft_delete": true,
+	}))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%s", u.ID), &buffer)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	ts.Run("Original values are recoverable", func() {
+		deletion, err := models.FindUserSoftDeletion(ts.API.db, u.ID)
+		require.NoError(ts.T(), err)
+		require.Equal(ts.T(), "soft-delete@example.com", deletion.Email.String())
+		require.Equal(ts.T(), "test", deletion.UserMetaData["name"])
+		require.Equal(ts.T(), "email", deletion.AppMetaData["provider"])
+	})
+
+	ts.Run("Refresh tokens are revoked", func() {
+		count, err := ts.API.db.Q().Where("user_id = ?", u.ID).Count(&models.RefreshToken{})
+		require.NoError(ts.T(), err)
+		require.Zero(ts.T(), count)
+	})
+
+	ts.Run("Soft deleted users are only listed with include_deleted", func() {
+		for query, count := range map[string]int{"": 0, "?include_deleted=true": 1} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/admin/users"+query, nil)
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+			ts.API.handler.ServeHTTP(w, req)
+			require.Equal(ts.T(), http.StatusOK, w.Code)
+
+			data := struct {
+				Users []*models.User `json:"users"`
+			}{}
+			require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+			require.Len(ts.T(), data.Users, count, query)
+		}
+	})
+
+	ts.Run("Soft deleted users cannot sign in", func() {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"email":    "soft-delete@example.com",
+			"password": "test-password",
+		}))
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	})
+
+	ts.Run("Signing up again with the same email is allowed", func() {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"email":    "soft-delete@example.com",
+			"password": "new-password",
+		}))
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/signup", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusOK, w.Code)
+
+		newUser, err := models.FindUserByEmailAndAudience(ts.API.db, "soft-delete@example.com", ts.Config.JWT.Aud)
+		require.NoError(ts.T(), err)
+		require.NotEqual(ts.T(), u.ID, newUser.ID)
+	})
+
+	ts.Run("Soft deleted users can be hard deleted", func() {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%s", u.ID), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusOK, w.Code)
+
+		_, err := models.FindUserByID(ts.API.db, u.ID)
+		require.Equal(ts.T(), models.UserNotFoundError{}, err)
+
+		_, err = models.FindUserSoftDeletion(ts.API.db, u.ID)
+		require.Equal(ts.T(), models.UserNotFoundError{}, err)
+	})
+}
+
+func (ts *AdminTestSuite) TestAdminUserCreatePasswordStrength() {
+	ts.Config.Password.RequiredCharacters = []string{"abcdefghijklmnopqrstuvwxyz", "0123456789"}
+	defer func() {
+		ts.Config.Password.RequiredCharacters = nil
+	}()
+
+	cases := []struct {
+		desc     string
+		params   map[string]interface{}
+		expected int
+	}{
+		{
+			desc: "Weak password",
+			params: map[string]interface{}{
+				"email":    "weak@example.com",
+				"password": "abcdef",
+			},
+			expected: http.StatusUnprocessableEntity,
+		},
+		{
+			desc: "Weak password with validation skipped",
+			params: map[string]interface{}{
+				"email":                    "skipped@example.com",
+				"password":                 "abcdef",
+				"skip_password_validation": true,
+			},
+			expected: http.StatusOK,
+		},
+		{
+			desc: "Strong password",
+			params: map[string]interface{}{
+				"email":    "strong@example.com",
+				"password": "abcdef123",
+			},
+			expected: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.desc, func() {
+			var buffer bytes.Buffer
+			require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(c.params))
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/users", &buffer)
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+			w := httptest.NewRecorder()
+			ts.API.handler.ServeHTTP(w, req)
+			require.Equal(ts.T(), c.expected, w.Code, w.Body.String())
+
+			if c.expected == http.StatusUnprocessableEntity {
+				data := map[string]interface{}{}
+				require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+				require.Equal(ts.T(), ErrorCodeWeakPassword, data["error_code"])
+				require.Equal(ts.T(), []interface{}{"characters"}, data["weak_password"].(map[string]interface{})["reasons"])
+			}
+		})
+	}
+}
+
 func (ts *AdminTestSuite) TestAdminUserCreateWithDisabledLogin() {
 	var cases = []struct {
 		desc         string
@@ -747,6 +1056,57 @@ func (ts *AdminTestSuite) TestAdminUserDeleteFactor() {
 
 }
 
+func (ts *AdminTestSuite) TestAdminUserDeleteFactorOfOtherUser() {
+	u, err := models.NewUser("", "test-factor-owner@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err, "Error making new user")
+	require.NoError(ts.T(), ts.API.db.Create(u), "Error creating user")
+
+	other, err := models.NewUser("", "test-factor-other@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err, "Error making new user")
+	require.NoError(ts.T(), ts.API.db.Create(other), "Error creating user")
+
+	f := models.NewFactor(u, "testSimpleName", models.TOTP, models.FactorStateVerified)
+	require.NoError(ts.T(), f.SetSecret("secretkey", ts.Config.Security.DBEncryption.Encrypt, ts.Config.Security.DBEncryption.EncryptionKeyID, ts.Config.Security.DBEncryption.EncryptionKey))
+	require.NoError(ts.T(), ts.API.db.Create(f), "Error saving new test factor")
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%s/factors/%s/", other.ID, f.ID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+
+	_, err = models.FindFactorByFactorID(ts.API.db, f.ID)
+	require.NoError(ts.T(), err)
+}
+
+// TestAdminUserClearLockout tests API /admin/users/<user_id>/lockout
+func (ts *AdminTestSuite) TestAdminUserClearLockout() {
+	u, err := models.NewUser("", "test-lockout@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err, "Error making new user")
+	require.NoError(ts.T(), ts.API.db.Create(u), "Error creating user")
+
+	key := models.EmailLoginAttemptKey(u.Aud, u.GetEmail())
+	attempt, err := models.RecordFailedLogin(ts.API.db, key, time.Minute)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), attempt.Lock(ts.API.db, time.Minute))
+
+	lockout, err := models.FindLoginLockout(ts.API.db, key)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), lockout)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%s/lockout", u.ID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	lockout, err = models.FindLoginLockout(ts.API.db, key)
+	require.NoError(ts.T(), err)
+	require.Nil(ts.T(), lockout)
+}
+
 // TestAdminUserGetFactor tests API /admin/user/<user_id>/factors/
 func (ts *AdminTestSuite) TestAdminUserGetFactors() {
 	u, err := models.NewUser("123456789", "test-delete@example.com", "test", ts.Config.JWT.Aud, nil)
@@ -822,3 +1182,201 @@ func (ts *AdminTestSuite) TestAdminUserUpdateFactor() {
 	}
 
 }
+
+func (ts *AdminTestSuite) TestAdminUsersImport() {
+	existing, err := models.NewUser("", "existing@example.com", "test", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(existing))
+
+	hash, err := crypto.GenerateFromPassword(context.Background(), "imported-password")
+	require.NoError(ts.T(), err)
+
+	rows := []map[string]interface{}{
+		{
+			"email":              "imported@example.com",
+			"password_hash":      hash,
+			"email_confirmed_at": time.Now(),
+			"user_metadata":      map[string]interface{}{"name": "Imported"},
+		},
+		{"email": "existing@example.com", "password_hash": hash},
+		{"email": "imported@example.com", "password_hash": hash},
+		{"email": "invalid-hash@example.com", "password_hash": "plaintext"},
+		{"email": "not-an-email"},
+		{"phone": "123456789"},
+	}
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(rows))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/import", &buffer)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	data := AdminImportUsersResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.False(ts.T(), data.DryRun)
+	require.Equal(ts.T(), 2, data.Created)
+	require.Equal(ts.T(), 2, data.Skipped)
+	require.Equal(ts.T(), 2, data.Failed)
+	require.Len(ts.T(), data.Results, len(rows))
+
+	expectedStatuses := []string{
+		adminImportStatusCreated,
+		adminImportStatusDuplicate,
+		adminImportStatusDuplicate,
+		adminImportStatusError,
+		adminImportStatusError,
+		adminImportStatusCreated,
+	}
+	for i, result := range data.Results {
+		require.Equal(ts.T(), i, result.Index)
+		require.Equal(ts.T(), expectedStatuses[i], result.Status, "row %d", i)
+		if result.Status == adminImportStatusError {
+			require.NotEmpty(ts.T(), result.Error)
+		}
+	}
+
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "imported@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), *data.Results[0].UserID, u.ID)
+	require.True(ts.T(), u.IsConfirmed())
+	require.Equal(ts.T(), "Imported", u.UserMetaData["name"])
+
+	isValid, _, err := u.Authenticate(context.Background(), "imported-password", nil, false, "")
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), isValid)
+
+	identities, err := models.FindIdentitiesByUserID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), identities, 1)
+
+	_, err = models.FindUserByEmailAndAudience(ts.API.db, "invalid-hash@example.com", ts.Config.JWT.Aud)
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *AdminTestSuite) TestAdminUsersImportForeignHashes() {
+	rows := []map[string]interface{}{
+		{"email": "argon2id@example.com", "password_hash": "$argon2id$v=19$m=32,t=3,p=2$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk"},
+		{"email": "scrypt@example.com", "password_hash": "$scrypt$ln=4,r=8,p=1$YzJGc2RITmhiSFE$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68"},
+		{"email": "firebase@example.com", "password_hash": "$fbscrypt$v=1,n=14,r=8,p=1,ss=Bw==,sk=jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ=="},
+		{"email": "argon2d@example.com", "password_hash": "$argon2d$v=19$m=32,t=3,p=2$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk"},
+	}
+	passwords := []string{"test", "test", "user1password"}
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(rows))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/import", &buffer)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	data := AdminImportUsersResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Equal(ts.T(), 3, data.Created)
+	require.Equal(ts.T(), 1, data.Failed)
+	require.Equal(ts.T(), adminImportStatusError, data.Results[3].Status)
+
+	for i, password := range passwords {
+		u, err := models.FindUserByEmailAndAudience(ts.API.db, rows[i]["email"].(string), ts.Config.JWT.Aud)
+		require.NoError(ts.T(), err)
+
+		isValid, _, err := u.Authenticate(context.Background(), password, nil, false, "")
+		require.NoError(ts.T(), err)
+		require.True(ts.T(), isValid, rows[i]["email"])
+	}
+}
+
+func (ts *AdminTestSuite) TestAdminUsersImportMalformedRow() {
+	body := `{"email": "first@example.com"}
+{"email": "second@example.com"}
+{"email": "third@
+{"email": "fourth@example.com"}
+`
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/import", strings.NewReader(body))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	data := AdminImportUsersResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Equal(ts.T(), 2, data.Created)
+	require.Equal(ts.T(), 1, data.Failed)
+	require.Len(ts.T(), data.Results, 3)
+	require.Equal(ts.T(), adminImportStatusError, data.Results[2].Status)
+
+	// the rows before the malformed one are imported, the rows after it
+	// aren't read
+	_, err := models.FindUserByEmailAndAudience(ts.API.db, "second@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	_, err = models.FindUserByEmailAndAudience(ts.API.db, "fourth@example.com", ts.Config.JWT.Aud)
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func TestAdminImportReader(t *testing.T) {
+	cases := []struct {
+		desc   string
+		body   string
+		emails []string
+		err    bool
+	}{
+		{desc: "Array", body: ` [{"email": "a@example.com"}, {"email": "b@example.com"}] `, emails: []string{"a@example.com", "b@example.com"}},
+		{desc: "Empty array", body: `[]`},
+		{desc: "NDJSON", body: "{\"email\": \"a@example.com\"}\n{\"email\": \"b@example.com\"}\n", emails: []string{"a@example.com", "b@example.com"}},
+		{desc: "Empty body", body: "  \n"},
+		{desc: "Malformed row", body: `[{"email": "a@example.com"}, {"email": ]`, emails: []string{"a@example.com"}, err: true},
+		{desc: "Unterminated array", body: `[{"email": "a@example.com"}`, emails: []string{"a@example.com"}, err: true},
+		{desc: "Data after the array", body: `[{"email": "a@example.com"}] {}`, emails: []string{"a@example.com"}, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			rows := newAdminImportReader(strings.NewReader(c.body))
+			var emails []string
+			for {
+				var row AdminImportUserParams
+				more, err := rows.Next(&row)
+				if c.err && err != nil {
+					break
+				}
+				require.NoError(t, err)
+				if !more {
+					require.False(t, c.err, "expected an error")
+					break
+				}
+				emails = append(emails, row.Email)
+			}
+			require.Equal(t, c.emails, emails)
+		})
+	}
+}
+
+func (ts *AdminTestSuite) TestAdminUsersImportNDJSONDryRun() {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	require.NoError(ts.T(), encoder.Encode(map[string]interface{}{"email": "first@example.com"}))
+	require.NoError(ts.T(), encoder.Encode(map[string]interface{}{"email": "second@example.com"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/import?dry_run=true", &buffer)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	data := AdminImportUsersResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.True(ts.T(), data.DryRun)
+	require.Equal(ts.T(), 2, data.Created)
+
+	for _, result := range data.Results {
+		require.Nil(ts.T(), result.UserID)
+		_, err := models.FindUserByEmailAndAudience(ts.API.db, result.Email, ts.Config.JWT.Aud)
+		require.True(ts.T(), models.IsNotFoundError(err))
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/metering"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/storage"
@@ -10,7 +11,7 @@ import (
 
 func (a *API) SignupAnonymously(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	config := a.config
+	config := a.getConfig(ctx)
 	db := a.db.WithContext(ctx)
 	aud := a.requestAud(ctx, r)
 
@@ -18,6 +19,10 @@ func (a *API) SignupAnonymously(w http.ResponseWriter, r *http.Request) error {
 		return unprocessableEntityError(ErrorCodeSignupDisabled, "Signups not allowed for this instance")
 	}
 
+	if err := a.requireFeature(ctx, conf.FeatureSignup); err != nil {
+		return err
+	}
+
 	params := &SignupParams{}
 	if err := retrieveRequestParams(r, params); err != nil {
 		return err
@@ -36,7 +41,7 @@ func (a *API) SignupAnonymously(w http.ResponseWriter, r *http.Request) error {
 	var token *AccessTokenResponse
 	err = db.Transaction(func(tx *storage.Connection) error {
 		var terr error
-		newUser, terr = a.signupNewUser(tx, newUser)
+		newUser, terr = a.signupNewUser(r, tx, newUser)
 		if terr != nil {
 			return terr
 		}
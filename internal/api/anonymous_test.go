@@ -112,7 +112,9 @@ func (ts *AnonymousTestSuite) TestConvertAnonymousUserToPermanent() {
 		ts.Run(c.desc, func() {
 			// Request body
 			var buffer bytes.Buffer
-			require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{}))
+			require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"theme": "dark"},
+			}))
 
 			req := httptest.NewRequest(http.MethodPost, "/signup", &buffer)
 			req.Header.Set("Content-Type", "application/json")
@@ -170,6 +172,7 @@ func (ts *AnonymousTestSuite) TestConvertAnonymousUserToPermanent() {
 			assert.Equal(ts.T(), signupResponse.User.ID, data.User.ID)
 			assert.Equal(ts.T(), ts.Config.JWT.Aud, data.User.Aud)
 			assert.False(ts.T(), data.User.IsAnonymous)
+			assert.Equal(ts.T(), "dark", data.User.UserMetaData["theme"])
 
 			// User should have an identity
 			assert.Len(ts.T(), data.User.Identities, 1)
@@ -306,3 +309,60 @@ func (ts *AnonymousTestSuite) TestAdminUpdateAnonymousUser() {
 		})
 	}
 }
+
+func (ts *AnonymousTestSuite) TestAdminListAnonymousUsers() {
+	claims := &AccessTokenClaims{
+		Role: "supabase_admin",
+	}
+	adminJwt, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+
+	u, err := models.NewUser("", "permanent@example.com", "", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	cases := []struct {
+		desc        string
+		query       string
+		code        int
+		isAnonymous []bool
+	}{
+		{
+			desc:        "anonymous users only",
+			query:       "is_anonymous=true",
+			code:        http.StatusOK,
+			isAnonymous: []bool{true},
+		},
+		{
+			desc:        "permanent users only",
+			query:       "is_anonymous=false",
+			code:        http.StatusOK,
+			isAnonymous: []bool{false},
+		},
+		{
+			desc:  "invalid value",
+			query: "is_anonymous=maybe",
+			code:  http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.desc, func() {
+			req := httptest.NewRequest(http.MethodGet, "/admin/users?"+c.query, nil)
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", adminJwt))
+			w := httptest.NewRecorder()
+			ts.API.handler.ServeHTTP(w, req)
+			require.Equal(ts.T(), c.code, w.Code)
+			if c.code != http.StatusOK {
+				return
+			}
+
+			data := AdminListUsersResponse{}
+			require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+			require.Len(ts.T(), data.Users, len(c.isAnonymous))
+			for i, user := range data.Users {
+				require.Equal(ts.T(), c.isAnonymous[i], user.IsAnonymous)
+			}
+		})
+	}
+}
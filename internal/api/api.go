@@ -1,19 +1,22 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/didip/tollbooth/v5"
 	"github.com/didip/tollbooth/v5/limiter"
 	"github.com/rs/cors"
-	"github.com/sebest/xff"
 	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
 	"github.com/supabase/auth/internal/mailer"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/ratelimit"
 	"github.com/supabase/auth/internal/storage"
 	"github.com/supabase/auth/internal/utilities"
 	"github.com/supabase/hibp"
@@ -35,6 +38,23 @@ type API struct {
 
 	hibpClient *hibp.PwnedClient
 
+	rateLimiter    ratelimit.Limiter
+	trustedProxies *utilities.TrustedProxies
+
+	instanceConfigs   instanceConfigCache
+	featureFlagsCache featureFlagsCache
+	jwtKeyCache       jwtKeyCache
+
+	// cleanup is set when stale rows are removed after requests or in the
+	// background.
+	cleanup *models.Cleanup
+
+	serverMutex       sync.Mutex
+	server            *http.Server
+	baseContext       context.Context
+	cancelBaseContext context.CancelFunc
+	shutdownOnce      sync.Once
+
 	// overrideTime can be used to override the clock used by handlers. Should only be used in tests!
 	overrideTime func() time.Time
 }
@@ -74,12 +94,16 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 		httpClient := &http.Client{
 			// all HIBP API requests should finish quickly to avoid
 			// unnecessary slowdowns
-			Timeout: 5 * time.Second,
+			Timeout: api.config.Password.HIBP.Timeout,
 		}
 
 		api.hibpClient = &hibp.PwnedClient{
 			UserAgent: api.config.Password.HIBP.UserAgent,
-			HTTP:      httpClient,
+			HTTP: &utilities.HIBPRangeClient{
+				HTTP:      httpClient,
+				Threshold: api.config.Password.HIBP.Threshold,
+				TTL:       api.config.Password.HIBP.CacheTTL,
+			},
 		}
 
 		if api.config.Password.HIBP.Bloom.Enabled {
@@ -90,15 +114,31 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 		}
 	}
 
+	crypto.PasswordHashing = crypto.PasswordHashParams{
+		Algorithm:         globalConfig.Password.HashAlgorithm,
+		BcryptCost:        globalConfig.Password.BcryptCost,
+		Argon2Memory:      globalConfig.Password.Argon2Memory,
+		Argon2Iterations:  globalConfig.Password.Argon2Iterations,
+		Argon2Parallelism: globalConfig.Password.Argon2Parallelism,
+	}
+
 	api.deprecationNotices()
 
-	xffmw, _ := xff.Default()
+	api.rateLimiter = ratelimit.NewMemoryLimiter()
+
+	// the networks have been validated with the configuration
+	trustedNetworks, _ := globalConfig.API.TrustedProxyNetworks()
+	api.trustedProxies = &utilities.TrustedProxies{
+		Networks: trustedNetworks,
+		Depth:    globalConfig.API.XFFDepth,
+	}
+
 	logger := observability.NewStructuredLogger(logrus.StandardLogger(), globalConfig)
 
 	r := newRouter()
+	r.UseBypass(api.withTrustedProxies)
 	r.UseBypass(observability.AddRequestID(globalConfig))
 	r.UseBypass(logger)
-	r.UseBypass(xffmw.Handler)
 	r.UseBypass(recoverer)
 
 	if globalConfig.API.MaxRequestDuration > 0 {
@@ -110,16 +150,23 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 		r.UseBypass(observability.RequestTracing())
 	}
 
+	if globalConfig.DB.CleanupEnabled || globalConfig.DB.CleanupInterval > 0 {
+		api.cleanup = models.NewCleanup(globalConfig)
+	}
+
 	if globalConfig.DB.CleanupEnabled {
-		cleanup := models.NewCleanup(globalConfig)
-		r.UseBypass(api.databaseCleanup(cleanup))
+		r.UseBypass(api.databaseCleanup(api.cleanup))
 	}
 
+	r.Use(api.limitAllRequestsByIP())
+
 	r.Get("/health", api.HealthCheck)
+	r.Get("/.well-known/jwks.json", api.JWKS)
+	r.Get("/.well-known/oauth-authorization-server", api.AuthorizationServerMetadata)
+	r.Get("/.well-known/openid-configuration", api.AuthorizationServerMetadata)
 
 	r.Route("/callback", func(r *router) {
 		r.Use(api.isValidExternalHost)
-		r.Use(api.loadFlowState)
 
 		r.Get("/", api.ExternalProviderCallback)
 		r.Post("/", api.ExternalProviderCallback)
@@ -128,207 +175,334 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 	r.Route("/", func(r *router) {
 		r.Use(api.isValidExternalHost)
 
-		r.Get("/settings", api.Settings)
-
-		r.Get("/authorize", api.ExternalProviderRedirect)
-
-		sharedLimiter := api.limitEmailOrPhoneSentHandler()
-		r.With(sharedLimiter).With(api.requireAdminCredentials).Post("/invite", api.Invite)
-		r.With(sharedLimiter).With(api.verifyCaptcha).Route("/signup", func(r *router) {
-			// rate limit per hour
-			limitAnonymousSignIns := tollbooth.NewLimiter(api.config.RateLimitAnonymousUsers/(60*60), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(int(api.config.RateLimitAnonymousUsers)).SetMethods([]string{"POST"})
-
-			limitSignups := tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30)
-
-			r.Post("/", func(w http.ResponseWriter, r *http.Request) error {
-				params := &SignupParams{}
-				if err := retrieveRequestParams(r, params); err != nil {
-					return err
-				}
-				if params.Email == "" && params.Phone == "" {
-					if !api.config.External.AnonymousUsers.Enabled {
-						return unprocessableEntityError(ErrorCodeAnonymousProviderDisabled, "Anonymous sign-ins are disabled")
+		// the settings don't wait for the database, so that clients can
+		// load them while it's unavailable
+		r.With(api.loadCachedInstanceConfig).Get("/settings", api.Settings)
+
+		r.Group(func(r *router) {
+			r.Use(api.loadInstanceConfig)
+			r.Use(api.requireNotInMaintenance)
+
+			r.Get("/authorize", api.ExternalProviderRedirect)
+
+			sharedLimiter := api.limitEmailOrPhoneSentHandler()
+			r.With(sharedLimiter).With(api.requireAdminCredentials).Post("/invite", api.Invite)
+			r.With(api.limitRequestsByIP("signup", api.config.IPRateLimit.Signup)).With(sharedLimiter).With(api.verifyCaptcha).Route("/signup", func(r *router) {
+				// rate limit per hour
+				limitAnonymousSignIns := tollbooth.NewLimiter(api.config.RateLimitAnonymousUsers/(60*60), &limiter.ExpirableOptions{
+					DefaultExpirationTTL: time.Hour,
+				}).SetBurst(int(api.config.RateLimitAnonymousUsers)).SetMethods([]string{"POST"})
+
+				limitSignups := tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
+					DefaultExpirationTTL: time.Hour,
+				}).SetBurst(30)
+
+				r.Post("/", func(w http.ResponseWriter, r *http.Request) error {
+					params := &SignupParams{}
+					if err := retrieveRequestParams(r, params); err != nil {
+						return err
 					}
-					if _, err := api.limitHandler(limitAnonymousSignIns)(w, r); err != nil {
+					if params.Email == "" && params.Phone == "" {
+						if !api.config.External.AnonymousUsers.Enabled {
+							return unprocessableEntityError(ErrorCodeAnonymousProviderDisabled, "Anonymous sign-ins are disabled")
+						}
+						if _, err := api.limitHandler(limitAnonymousSignIns)(w, r); err != nil {
+							return err
+						}
+						return api.SignupAnonymously(w, r)
+					}
+
+					// apply ip-based rate limiting on otps
+					if _, err := api.limitHandler(limitSignups)(w, r); err != nil {
 						return err
 					}
-					return api.SignupAnonymously(w, r)
-				}
-
-				// apply ip-based rate limiting on otps
-				if _, err := api.limitHandler(limitSignups)(w, r); err != nil {
-					return err
-				}
-				// apply shared rate limiting on email / phone
-				if _, err := sharedLimiter(w, r); err != nil {
-					return err
-				}
-				return api.Signup(w, r)
+					// apply shared rate limiting on email / phone
+					if _, err := sharedLimiter(w, r); err != nil {
+						return err
+					}
+					return api.Signup(w, r)
+				})
 			})
-		})
-		r.With(api.limitHandler(
-			// Allow requests at the specified rate per 5 minutes
-			tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
-		)).With(sharedLimiter).With(api.verifyCaptcha).With(api.requireEmailProvider).Post("/recover", api.Recover)
-
-		r.With(api.limitHandler(
-			// Allow requests at the specified rate per 5 minutes
-			tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
-		)).With(sharedLimiter).With(api.verifyCaptcha).Post("/resend", api.Resend)
-
-		r.With(api.limitHandler(
-			// Allow requests at the specified rate per 5 minutes
-			tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
-		)).With(sharedLimiter).With(api.verifyCaptcha).Post("/magiclink", api.MagicLink)
-
-		r.With(api.limitHandler(
-			// Allow requests at the specified rate per 5 minutes
-			tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
-		)).With(sharedLimiter).With(api.verifyCaptcha).Post("/otp", api.Otp)
-
-		r.With(api.limitHandler(
-			// Allow requests at the specified rate per 5 minutes.
-			tollbooth.NewLimiter(api.config.RateLimitTokenRefresh/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
-		)).With(api.verifyCaptcha).Post("/token", api.Token)
-
-		r.With(api.limitHandler(
-			// Allow requests at the specified rate per 5 minutes.
-			tollbooth.NewLimiter(api.config.RateLimitVerify/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
-		)).Route("/verify", func(r *router) {
-			r.Get("/", api.Verify)
-			r.Post("/", api.Verify)
-		})
-
-		r.With(api.requireAuthentication).Post("/logout", api.Logout)
+			r.With(api.limitHandler(
+				// Allow requests at the specified rate per 5 minutes
+				tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
+					DefaultExpirationTTL: time.Hour,
+				}).SetBurst(30),
+			)).With(api.limitRequestsByIP("recover", api.config.IPRateLimit.Recover)).With(sharedLimiter).With(api.verifyCaptcha).With(api.requireEmailProvider).Post("/recover", api.Recover)
 
-		r.With(api.requireAuthentication).Route("/reauthenticate", func(r *router) {
-			r.Get("/", api.Reauthenticate)
-		})
+			r.With(api.limitHandler(
+				// Allow requests at the specified rate per 5 minutes
+				tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
+					DefaultExpirationTTL: time.Hour,
+				}).SetBurst(30),
+			)).With(sharedLimiter).With(api.verifyCaptcha).Post("/resend", api.Resend)
 
-		r.With(api.requireAuthentication).Route("/user", func(r *router) {
-			r.Get("/", api.UserGet)
 			r.With(api.limitHandler(
 				// Allow requests at the specified rate per 5 minutes
 				tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
 					DefaultExpirationTTL: time.Hour,
 				}).SetBurst(30),
-			)).With(sharedLimiter).Put("/", api.UserUpdate)
+			)).With(sharedLimiter).With(api.verifyCaptcha).Post("/magiclink", api.MagicLink)
 
-			r.Route("/identities", func(r *router) {
-				r.Use(api.requireManualLinkingEnabled)
-				r.Get("/authorize", api.LinkIdentity)
-				r.Delete("/{identity_id}", api.DeleteIdentity)
-			})
-		})
+			r.With(api.limitHandler(
+				// Allow requests at the specified rate per 5 minutes
+				tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
+					DefaultExpirationTTL: time.Hour,
+				}).SetBurst(30),
+			)).With(api.limitRequestsByIP("otp", api.config.IPRateLimit.Otp)).With(sharedLimiter).With(api.verifyCaptcha).Post("/otp", api.Otp)
 
-		r.With(api.requireAuthentication).Route("/factors", func(r *router) {
-			r.Use(api.requireNotAnonymous)
-			r.Post("/", api.EnrollFactor)
-			r.Route("/{factor_id}", func(r *router) {
-				r.Use(api.loadFactor)
+			r.With(api.limitHandler(
+				// Allow requests at the specified rate per 5 minutes.
+				tollbooth.NewLimiter(api.config.RateLimitTokenRefresh/(60*5), &limiter.ExpirableOptions{
+					DefaultExpirationTTL: time.Hour,
+				}).SetBurst(30),
+			)).With(api.limitPasswordGrantsByIP()).With(api.verifyCaptcha).Post("/token", api.Token)
 
-				r.With(api.limitHandler(
-					tollbooth.NewLimiter(api.config.MFA.RateLimitChallengeAndVerify/60, &limiter.ExpirableOptions{
-						DefaultExpirationTTL: time.Minute,
-					}).SetBurst(30))).Post("/verify", api.VerifyFactor)
-				r.With(api.limitHandler(
-					tollbooth.NewLimiter(api.config.MFA.RateLimitChallengeAndVerify/60, &limiter.ExpirableOptions{
-						DefaultExpirationTTL: time.Minute,
-					}).SetBurst(30))).Post("/challenge", api.ChallengeFactor)
-				r.Delete("/", api.UnenrollFactor)
+			r.With(api.requireAdminCredentials).Post("/token/introspect", api.TokenIntrospect)
 
-			})
-		})
+			r.With(api.limitHandler(
+				// Allow requests at the specified rate per 5 minutes.
+				tollbooth.NewLimiter(api.config.RateLimitTokenRefresh/(60*5), &limiter.ExpirableOptions{
+					DefaultExpirationTTL: time.Hour,
+				}).SetBurst(30),
+			)).Post("/token/revoke", api.TokenRevoke)
 
-		r.Route("/sso", func(r *router) {
-			r.Use(api.requireSAMLEnabled)
 			r.With(api.limitHandler(
 				// Allow requests at the specified rate per 5 minutes.
-				tollbooth.NewLimiter(api.config.RateLimitSso/(60*5), &limiter.ExpirableOptions{
+				tollbooth.NewLimiter(api.config.RateLimitVerify/(60*5), &limiter.ExpirableOptions{
 					DefaultExpirationTTL: time.Hour,
 				}).SetBurst(30),
-			)).With(api.verifyCaptcha).Post("/", api.SingleSignOn)
+			)).With(api.limitRequestsByIP("verify", api.config.IPRateLimit.Verify)).Route("/verify", func(r *router) {
+				r.Get("/", api.Verify)
+				r.Post("/", api.Verify)
+			})
+
+			r.With(api.requireDeviceAuthorizationEnabled).Route("/device", func(r *router) {
+				r.With(api.limitRequestsByIP("device", api.config.IPRateLimit.Device)).Post("/code", api.DeviceAuthorization)
+
+				r.With(api.requireAuthentication).With(api.requireNotAnonymous).With(api.requireAudienceAAL).Get("/", api.DeviceVerifyGet)
+				r.With(api.limitRequestsByIP("device", api.config.IPRateLimit.Device)).With(api.requireAuthentication).With(api.requireNotAnonymous).With(api.requireAudienceAAL).Post("/", api.DeviceVerify)
+			})
+
+			r.With(api.requireAuthentication).Post("/logout", api.Logout)
 
-			r.Route("/saml", func(r *router) {
-				r.Get("/metadata", api.SAMLMetadata)
+			r.With(api.requireAuthentication).With(api.requireAudienceAAL).Route("/reauthenticate", func(r *router) {
+				r.Get("/", api.Reauthenticate)
+			})
 
+			r.With(api.requireAuthentication).Route("/user", func(r *router) {
+				r.Get("/", api.UserGet)
 				r.With(api.limitHandler(
-					// Allow requests at the specified rate per 5 minutes.
-					tollbooth.NewLimiter(api.config.SAML.RateLimitAssertion/(60*5), &limiter.ExpirableOptions{
+					// Allow requests at the specified rate per 5 minutes
+					tollbooth.NewLimiter(api.config.RateLimitOtp/(60*5), &limiter.ExpirableOptions{
 						DefaultExpirationTTL: time.Hour,
 					}).SetBurst(30),
-				)).Post("/acs", api.SAMLACS)
+				)).With(sharedLimiter).With(api.requireAudienceAAL).Put("/", api.UserUpdate)
+
+				r.Route("/identities", func(r *router) {
+					r.Get("/", api.UserIdentities)
+					r.With(api.requireManualLinkingEnabled).With(api.requireAudienceAAL).Get("/authorize", api.LinkIdentity)
+					r.With(api.requireManualLinkingEnabled).With(api.requireAudienceAAL).Delete("/{identity_id}", api.DeleteIdentity)
+				})
 			})
-		})
 
-		r.Route("/admin", func(r *router) {
-			r.Use(api.requireAdminCredentials)
+			r.With(api.requireAuthentication).With(api.requireAudienceAAL).Route("/sessions", func(r *router) {
+				r.Get("/", api.UserSessions)
+				r.Delete("/{session_id}", api.UserRevokeSession)
+			})
+
+			r.With(api.requireAuthentication).Route("/factors", func(r *router) {
+				r.Use(api.requireNotAnonymous)
+				r.With(api.requireSufficientAAL).Post("/", api.EnrollFactor)
+				r.Route("/recovery_codes", func(r *router) {
+					r.With(api.requireSufficientAAL).Post("/", api.GenerateRecoveryCodes)
+					r.With(api.limitHandler(
+						tollbooth.NewLimiter(api.config.MFA.RateLimitChallengeAndVerify/60, &limiter.ExpirableOptions{
+							DefaultExpirationTTL: time.Minute,
+						}).SetBurst(30))).Post("/verify", api.VerifyRecoveryCode)
+				})
+				r.Route("/{factor_id}", func(r *router) {
+					r.Use(api.loadFactor)
+
+					r.With(api.limitHandler(
+						tollbooth.NewLimiter(api.config.MFA.RateLimitChallengeAndVerify/60, &limiter.ExpirableOptions{
+							DefaultExpirationTTL: time.Minute,
+						}).SetBurst(30))).Post("/verify", api.VerifyFactor)
+					r.With(api.limitHandler(
+						tollbooth.NewLimiter(api.config.MFA.RateLimitChallengeAndVerify/60, &limiter.ExpirableOptions{
+							DefaultExpirationTTL: time.Minute,
+						}).SetBurst(30))).Post("/challenge", api.ChallengeFactor)
+					r.Delete("/", api.UnenrollFactor)
 
-			r.Route("/audit", func(r *router) {
-				r.Get("/", api.adminAuditLog)
+				})
 			})
 
-			r.Route("/users", func(r *router) {
-				r.Get("/", api.adminUsers)
-				r.Post("/", api.adminUserCreate)
+			r.With(api.requireWebAuthnEnabled).Route("/webauthn", func(r *router) {
+				r.With(api.limitHandler(
+					tollbooth.NewLimiter(api.config.MFA.RateLimitChallengeAndVerify/60, &limiter.ExpirableOptions{
+						DefaultExpirationTTL: time.Minute,
+					}).SetBurst(30))).Post("/challenge", api.WebAuthnChallenge)
+			})
 
-				r.Route("/{user_id}", func(r *router) {
-					r.Use(api.loadUser)
-					r.Route("/factors", func(r *router) {
-						r.Get("/", api.adminUserGetFactors)
-						r.Route("/{factor_id}", func(r *router) {
-							r.Use(api.loadFactor)
-							r.Delete("/", api.adminUserDeleteFactor)
-							r.Put("/", api.adminUserUpdateFactor)
+			r.Route("/sso", func(r *router) {
+				r.Use(api.requireSAMLEnabled)
+				r.With(api.limitHandler(
+					// Allow requests at the specified rate per 5 minutes.
+					tollbooth.NewLimiter(api.config.RateLimitSso/(60*5), &limiter.ExpirableOptions{
+						DefaultExpirationTTL: time.Hour,
+					}).SetBurst(30),
+				)).With(api.verifyCaptcha).Post("/", api.SingleSignOn)
+
+				r.Route("/saml", func(r *router) {
+					r.Get("/metadata", api.SAMLMetadata)
+
+					r.With(api.limitHandler(
+						// Allow requests at the specified rate per 5 minutes.
+						tollbooth.NewLimiter(api.config.SAML.RateLimitAssertion/(60*5), &limiter.ExpirableOptions{
+							DefaultExpirationTTL: time.Hour,
+						}).SetBurst(30),
+					)).Post("/acs", api.SAMLACS)
+				})
+			})
+
+			r.Route("/admin", func(r *router) {
+				r.Use(api.requireAdminCredentials)
+
+				r.Route("/audit", func(r *router) {
+					r.Get("/", api.adminAuditLog)
+				})
+
+				r.Route("/users", func(r *router) {
+					r.Get("/", api.adminUsers)
+					r.Post("/", api.adminUserCreate)
+					r.Post("/import", api.adminUsersImport)
+					r.Get("/export", api.adminUsersExport)
+
+					r.Route("/{user_id}", func(r *router) {
+						r.Use(api.loadUser)
+						r.Route("/factors", func(r *router) {
+							r.Get("/", api.adminUserGetFactors)
+							r.Delete("/", api.adminUserDeleteFactors)
+							r.Route("/{factor_id}", func(r *router) {
+								r.Use(api.loadFactor)
+								r.Delete("/", api.adminUserDeleteFactor)
+								r.Put("/", api.adminUserUpdateFactor)
+							})
+						})
+
+						r.Route("/identities", func(r *router) {
+							r.Post("/", api.adminUserLinkIdentity)
+							r.Delete("/{identity_id}", api.adminUserUnlinkIdentity)
+						})
+
+						r.Post("/merge", api.adminUserMerge)
+
+						r.Post("/recover", api.adminUserRecover)
+
+						r.Delete("/lockout", api.adminUserClearLockout)
+
+						r.Route("/sessions", func(r *router) {
+							r.Get("/", api.adminUserSessions)
+							r.Delete("/", api.adminUserRevokeSessions)
+							r.Delete("/{session_id}", api.adminUserRevokeSession)
 						})
+
+						r.Get("/", api.adminUserGet)
+						r.Put("/", api.adminUserUpdate)
+						r.Delete("/", api.adminUserDelete)
 					})
+				})
 
+				// /admin/user/{user_id} is the path used by older clients
+				r.Route("/user/{user_id}", func(r *router) {
+					r.Use(api.loadUser)
 					r.Get("/", api.adminUserGet)
 					r.Put("/", api.adminUserUpdate)
 					r.Delete("/", api.adminUserDelete)
 				})
-			})
 
-			r.Post("/generate_link", api.adminGenerateLink)
+				r.Post("/generate_link", api.adminGenerateLink)
 
-			r.Route("/sso", func(r *router) {
-				r.Route("/providers", func(r *router) {
-					r.Get("/", api.adminSSOProvidersList)
-					r.Post("/", api.adminSSOProvidersCreate)
+				r.Route("/settings/flags", func(r *router) {
+					r.Get("/", api.adminFeatureFlagsGet)
+					r.Put("/", api.adminFeatureFlagsUpdate)
+				})
+
+				r.Route("/jwt/keys", func(r *router) {
+					r.Get("/", api.adminJWTKeysList)
+					r.Post("/", api.adminJWTKeyCreate)
+					r.Post("/{kid}/promote", api.adminJWTKeyPromote)
+					r.Delete("/{kid}", api.adminJWTKeyDelete)
+				})
 
-					r.Route("/{idp_id}", func(r *router) {
-						r.Use(api.loadSSOProvider)
+				r.Route("/oauth/clients", func(r *router) {
+					r.Get("/", api.adminOAuthClientsList)
+					r.Post("/", api.adminOAuthClientCreate)
+					r.Delete("/{client_id}", api.adminOAuthClientDelete)
+				})
 
-						r.Get("/", api.adminSSOProvidersGet)
-						r.Put("/", api.adminSSOProvidersUpdate)
-						r.Delete("/", api.adminSSOProvidersDelete)
+				r.Route("/instances/{aud}/config", func(r *router) {
+					r.Get("/", api.adminInstanceConfigGet)
+					r.Put("/", api.adminInstanceConfigUpdate)
+				})
+
+				r.Route("/sso", func(r *router) {
+					r.Route("/providers", func(r *router) {
+						r.Get("/", api.adminSSOProvidersList)
+						r.Post("/", api.adminSSOProvidersCreate)
+
+						r.Route("/{idp_id}", func(r *router) {
+							r.Use(api.loadSSOProvider)
+
+							r.Get("/", api.adminSSOProvidersGet)
+							r.Put("/", api.adminSSOProvidersUpdate)
+							r.Delete("/", api.adminSSOProvidersDelete)
+						})
 					})
 				})
+
 			})
 
+			r.Route("/scim/v2", func(r *router) {
+				r.Use(api.requireSCIMEnabled)
+				r.Use(api.requireSCIMCredentials)
+
+				r.Get("/ServiceProviderConfig", scimHandler(api.scimServiceProviderConfig))
+
+				r.Route("/Users", func(r *router) {
+					r.Get("/", scimHandler(api.scimUsersList))
+					r.Post("/", scimHandler(api.scimUserCreate))
+
+					r.Route("/{id}", func(r *router) {
+						r.Get("/", scimHandler(api.scimUserGet))
+						r.Put("/", scimHandler(api.scimUserReplace))
+						r.Patch("/", scimHandler(api.scimUserPatch))
+						r.Delete("/", scimHandler(api.scimUserDelete))
+					})
+				})
+
+				r.Route("/Groups", func(r *router) {
+					r.Get("/", scimHandler(api.scimGroupsList))
+					r.Post("/", scimHandler(api.scimGroupCreate))
+
+					r.Route("/{id}", func(r *router) {
+						r.Get("/", scimHandler(api.scimGroupGet))
+						r.Put("/", scimHandler(api.scimGroupReplace))
+						r.Patch("/", scimHandler(api.scimGroupPatch))
+						r.Delete("/", scimHandler(api.scimGroupDelete))
+					})
+				})
+			})
 		})
 	})
 
 	corsHandler := cors.New(cors.Options{
+		// an empty list allows all origins
+		AllowedOrigins:   globalConfig.CORS.AllowedOrigins,
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
 		AllowedHeaders:   globalConfig.CORS.AllAllowedHeaders([]string{"Accept", "Authorization", "Content-Type", "X-Client-IP", "X-Client-Info", audHeaderName, useCookieHeader}),
-		ExposedHeaders:   []string{"X-Total-Count", "Link"},
+		ExposedHeaders:   globalConfig.CORS.AllExposedHeaders([]string{"X-Total-Count", "Link"}),
+		MaxAge:           int(globalConfig.CORS.MaxAge.Seconds()),
 		AllowCredentials: true,
 	})
 
@@ -336,23 +510,40 @@ func NewAPIWithVersion(globalConfig *conf.GlobalConfiguration, db *storage.Conne
 	return api
 }
 
+// healthCheckTimeout bounds the database ping of the health check.
+const healthCheckTimeout = 2 * time.Second
+
 type HealthCheckResponse struct {
 	Version     string `json:"version"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	Database    string `json:"database"`
 }
 
-// HealthCheck endpoint indicates if the gotrue api service is available
+// HealthCheck endpoint indicates if the gotrue api service is available. It
+// responds with 503 when the database can't be reached.
 func (a *API) HealthCheck(w http.ResponseWriter, r *http.Request) error {
-	return sendJSON(w, http.StatusOK, HealthCheckResponse{
+	resp := HealthCheckResponse{
 		Version:     a.version,
 		Name:        "GoTrue",
 		Description: "GoTrue is a user registration and authentication API",
-	})
+		Database:    "ok",
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	if err := a.db.Ping(ctx); err != nil {
+		observability.GetLogEntry(r).Entry.WithError(err).Warn("health check failed to reach the database")
+		resp.Database = "unavailable"
+		return sendJSON(w, http.StatusServiceUnavailable, resp)
+	}
+
+	return sendJSON(w, http.StatusOK, resp)
 }
 
-// Mailer returns NewMailer with the current tenant config
-func (a *API) Mailer() mailer.Mailer {
-	config := a.config
+// Mailer returns NewMailer with the config of the request's instance
+func (a *API) Mailer(ctx context.Context) mailer.Mailer {
+	config := a.getConfig(ctx)
 	return mailer.NewMailer(config)
 }
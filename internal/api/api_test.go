@@ -1,13 +1,24 @@
 package api
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stretchr/testify/require"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/crypto"
 	"github.com/supabase/auth/internal/storage"
 	"github.com/supabase/auth/internal/storage/test"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 )
 
 const (
@@ -54,3 +65,134 @@ func TestEmailEnabledByDefault(t *testing.T) {
 
 	require.True(t, api.config.External.Email.Enabled)
 }
+
+func TestHealthCheck(t *testing.T) {
+	api, _, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	check := func() (int, HealthCheckResponse) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/health", nil)
+		w := httptest.NewRecorder()
+		api.handler.ServeHTTP(w, req)
+
+		resp := HealthCheckResponse{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return w.Code, resp
+	}
+
+	code, resp := check()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, apiTestVersion, resp.Version)
+	require.Equal(t, "GoTrue", resp.Name)
+	require.Equal(t, "ok", resp.Database)
+
+	require.NoError(t, api.db.Close())
+
+	code, resp = check()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, apiTestVersion, resp.Version)
+	require.Equal(t, "unavailable", resp.Database)
+}
+
+// metricsRegistry exports all metrics recorded by the tests. The meter
+// provider is global, so it is only configured once.
+var metricsRegistry = sync.OnceValue(func() *promclient.Registry {
+	registry := promclient.NewRegistry()
+	exporter, err := prometheus.New(prometheus.WithRegisterer(registry))
+	if err != nil {
+		panic(err)
+	}
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)))
+	return registry
+})
+
+// scrapeMetrics returns the metrics in the Prometheus text format.
+func scrapeMetrics(t *testing.T) string {
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(metricsRegistry(), promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestCORSPreflight(t *testing.T) {
+	preflight := func(api *API, origin, requestHeaders string) http.Header {
+		req := httptest.NewRequest(http.MethodOptions, "http://localhost/token", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		if requestHeaders != "" {
+			req.Header.Set("Access-Control-Request-Headers", requestHeaders)
+		}
+
+		w := httptest.NewRecorder()
+		api.handler.ServeHTTP(w, req)
+		return w.Header()
+	}
+
+	newAPI := func(cb func(*conf.CORSConfiguration)) *API {
+		config, err := conf.LoadGlobal(apiTestConfig)
+		require.NoError(t, err)
+		cb(&config.CORS)
+
+		// preflight requests are answered without touching the database
+		return NewAPIWithVersion(config, nil, apiTestVersion)
+	}
+
+	t.Run("AnyOriginByDefault", func(t *testing.T) {
+		api := newAPI(func(c *conf.CORSConfiguration) {})
+
+		headers := preflight(api, "https://anything.example.org", "X-Client-Info, X-JWT-AUD")
+		require.Equal(t, "*", headers.Get("Access-Control-Allow-Origin"))
+		require.Equal(t, "POST", headers.Get("Access-Control-Allow-Methods"))
+		require.Equal(t, "X-Client-Info, X-Jwt-Aud", headers.Get("Access-Control-Allow-Headers"))
+	})
+
+	t.Run("AllowedOrigins", func(t *testing.T) {
+		api := newAPI(func(c *conf.CORSConfiguration) {
+			c.AllowedOrigins = []string{"https://app.example.com", "https://*.example.org"}
+			c.AllowedHeaders = []string{"X-Custom-Header"}
+			c.MaxAge = 10 * time.Minute
+		})
+
+		for _, origin := range []string{"https://app.example.com", "https://preview.example.org"} {
+			headers := preflight(api, origin, "X-Custom-Header")
+			require.Equal(t, origin, headers.Get("Access-Control-Allow-Origin"), origin)
+			require.Equal(t, "true", headers.Get("Access-Control-Allow-Credentials"), origin)
+			require.Equal(t, "X-Custom-Header", headers.Get("Access-Control-Allow-Headers"), origin)
+			require.Equal(t, "600", headers.Get("Access-Control-Max-Age"), origin)
+		}
+
+		for _, origin := range []string{"https://evil.example.com", "http://app.example.com", "https://example.org"} {
+			headers := preflight(api, origin, "")
+			require.Empty(t, headers.Get("Access-Control-Allow-Origin"), origin)
+			require.Empty(t, headers.Get("Access-Control-Allow-Methods"), origin)
+		}
+	})
+
+	t.Run("DisallowedHeader", func(t *testing.T) {
+		api := newAPI(func(c *conf.CORSConfiguration) {
+			c.AllowedOrigins = []string{"https://app.example.com"}
+		})
+
+		headers := preflight(api, "https://app.example.com", "X-Not-Allowed")
+		require.Empty(t, headers.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("ExposedHeaders", func(t *testing.T) {
+		api := newAPI(func(c *conf.CORSConfiguration) {
+			c.AllowedOrigins = []string{"https://app.example.com"}
+			c.ExposedHeaders = []string{"X-Request-ID"}
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/settings", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+
+		w := httptest.NewRecorder()
+		api.handler.ServeHTTP(w, req)
+
+		require.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		require.Equal(t, "X-Total-Count, Link, X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+	})
+}
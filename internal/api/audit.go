@@ -3,7 +3,9 @@ package api
 import (
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/supabase/auth/internal/models"
 )
 
@@ -25,7 +27,8 @@ func (a *API) adminAuditLog(w http.ResponseWriter, r *http.Request) error {
 
 	var col []string
 	var qval string
-	q := r.URL.Query().Get("query")
+	query := r.URL.Query()
+	q := query.Get("query")
 	if q != "" {
 		var exists bool
 		qparts := strings.SplitN(q, ":", 2)
@@ -36,7 +39,29 @@ func (a *API) adminAuditLog(w http.ResponseWriter, r *http.Request) error {
 		qval = qparts[1]
 	}
 
-	logs, err := models.FindAuditLogEntries(db, col, qval, pageParams)
+	filter := &models.AuditLogFilter{
+		Columns: col,
+		Value:   qval,
+		Action:  models.AuditAction(query.Get("action")),
+	}
+
+	if actorID := query.Get("actor_id"); actorID != "" {
+		id, err := uuid.FromString(actorID)
+		if err != nil {
+			return badRequestError(ErrorCodeValidationFailed, "actor_id must be an UUID")
+		}
+		filter.ActorID = &id
+	}
+
+	if filter.From, err = parseAuditTime(query.Get("from")); err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "from must be a RFC3339 timestamp")
+	}
+
+	if filter.To, err = parseAuditTime(query.Get("to")); err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "to must be a RFC3339 timestamp")
+	}
+
+	logs, err := models.FindAuditLogEntries(db, filter, pageParams)
 	if err != nil {
 		return internalServerError("Error searching for audit logs").WithInternalError(err)
 	}
@@ -45,3 +70,16 @@ func (a *API) adminAuditLog(w http.ResponseWriter, r *http.Request) error {
 
 	return sendJSON(w, http.StatusOK, logs)
 }
+
+func parseAuditTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
+	"github.com/gofrs/uuid"
 	jwt "github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -123,6 +126,55 @@ func (ts *AuditTestSuite) TestAuditFilters() {
 	}
 }
 
+func (ts *AuditTestSuite) TestAuditExactFilters() {
+	ts.prepareDeleteEvent()
+
+	logs := []models.AuditLogEntry{}
+	ts.getAuditLogs("/admin/audit", &logs)
+	require.Len(ts.T(), logs, 1)
+	actorID := logs[0].Payload["actor_id"].(string)
+
+	from := logs[0].CreatedAt.Add(-time.Minute).Format(time.RFC3339)
+	to := logs[0].CreatedAt.Add(time.Minute).Format(time.RFC3339)
+
+	cases := []struct {
+		query    string
+		expected int
+	}{
+		{query: "action=user_deleted", expected: 1},
+		{query: "action=user_modified", expected: 0},
+		{query: "actor_id=" + actorID, expected: 1},
+		{query: "actor_id=" + uuid.Must(uuid.NewV4()).String(), expected: 0},
+		{query: "from=" + url.QueryEscape(from) + "&to=" + url.QueryEscape(to), expected: 1},
+		{query: "to=" + url.QueryEscape(from), expected: 0},
+	}
+
+	for _, c := range cases {
+		logs := []models.AuditLogEntry{}
+		ts.getAuditLogs("/admin/audit?"+c.query, &logs)
+		require.Len(ts.T(), logs, c.expected, c.query)
+	}
+
+	for _, q := range []string{"actor_id=not-an-uuid", "from=yesterday"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit?"+q, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code, q)
+	}
+}
+
+func (ts *AuditTestSuite) getAuditLogs(path string, logs *[]models.AuditLogEntry) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(logs))
+}
+
 func (ts *AuditTestSuite) prepareDeleteEvent() {
 	// DELETE USER
 	u, err := models.NewUser("12345678", "test-delete@example.com", "test", ts.Config.JWT.Aud, nil)
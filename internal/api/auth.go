@@ -7,7 +7,6 @@ import (
 	"strings"
 
 	"github.com/gofrs/uuid"
-	jwt "github.com/golang-jwt/jwt"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/storage"
 )
@@ -27,12 +26,19 @@ func (a *API) requireAuthentication(w http.ResponseWriter, r *http.Request) (con
 		return ctx, err
 	}
 
+	if err := a.requireUnrevokedToken(ctx, token); err != nil {
+		a.clearCookieTokens(config, w)
+		return nil, err
+	}
+
 	ctx, err = a.maybeLoadUserOrSession(ctx)
 	if err != nil {
 		a.clearCookieTokens(config, w)
 		return ctx, err
 	}
-	return ctx, err
+
+	// the token's audience selects the instance unless the request names one
+	return a.withInstanceConfig(ctx, a.requestAud(ctx, r))
 }
 
 func (a *API) requireNotAnonymous(w http.ResponseWriter, r *http.Request) (context.Context, error) {
@@ -44,6 +50,43 @@ func (a *API) requireNotAnonymous(w http.ResponseWriter, r *http.Request) (conte
 	return ctx, nil
 }
 
+// requireSufficientAAL rejects requests from users with a verified factor
+// unless the session has been upgraded to AAL2.
+func (a *API) requireSufficientAAL(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	user := getUser(ctx)
+	session := getSession(ctx)
+	if user == nil {
+		return ctx, nil
+	}
+
+	for _, factor := range user.Factors {
+		if factor.IsVerified() && (session == nil || !session.IsAAL2()) {
+			return nil, forbiddenError(ErrorCodeInsufficientAAL, "AAL2 required to perform this action")
+		}
+	}
+
+	return ctx, nil
+}
+
+// requireAudienceAAL requires an AAL2 session from the users of the
+// audiences in MFA_REQUIRE_AAL2_AUDIENCES, so that they verify a factor
+// before managing their account. Enrolling and verifying factors is not
+// guarded by it.
+func (a *API) requireAudienceAAL(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	claims := getClaims(ctx)
+	if claims == nil || !a.config.MFA.RequiresAAL2(claims.Audience) {
+		return ctx, nil
+	}
+
+	if session := getSession(ctx); session == nil || !session.IsAAL2() {
+		return nil, forbiddenError(ErrorCodeInsufficientAAL, "AAL2 required for this audience")
+	}
+
+	return ctx, nil
+}
+
 func (a *API) requireAdmin(ctx context.Context) (context.Context, error) {
 	// Find the administrative user
 	claims := getClaims(ctx)
@@ -73,12 +116,8 @@ func (a *API) extractBearerToken(r *http.Request) (string, error) {
 
 func (a *API) parseJWTClaims(bearer string, r *http.Request) (context.Context, error) {
 	ctx := r.Context()
-	config := a.config
 
-	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
-	token, err := p.ParseWithClaims(bearer, &AccessTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.JWT.Secret), nil
-	})
+	token, err := a.parseAccessToken(ctx, bearer)
 	if err != nil {
 		return nil, forbiddenError(ErrorCodeBadJWT, "invalid JWT: unable to parse or verify signature, %v", err).WithInternalError(err)
 	}
@@ -3,6 +3,9 @@ package api
 import (
 	"context"
 	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -28,3 +31,30 @@ func WaitForCleanup(ctx context.Context) {
 		return
 	}
 }
+
+// runCleanup removes all stale rows from the database every interval until
+// ctx is done.
+func (a *API) runCleanup(ctx context.Context, interval time.Duration) {
+	log := logrus.WithField("component", "cleanup")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+		}
+
+		affectedRows, err := a.cleanup.CleanAll(a.db.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).WithField("affected_rows", affectedRows).Warn("database cleanup failed")
+			}
+			continue
+		}
+		log.WithField("affected_rows", affectedRows).Info("cleaned up expired or stale rows")
+	}
+}
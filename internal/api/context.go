@@ -5,6 +5,7 @@ import (
 	"net/url"
 
 	jwt "github.com/golang-jwt/jwt"
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/models"
 )
 
@@ -31,6 +32,8 @@ const (
 	ssoProviderKey          = contextKey("sso_provider")
 	externalHostKey         = contextKey("external_host")
 	flowStateKey            = contextKey("flow_state_id")
+	instanceAudKey          = contextKey("instance_aud")
+	instanceConfigKey       = contextKey("instance_config")
 )
 
 // withToken adds the JWT token to the context.
@@ -126,6 +129,16 @@ func withSignature(ctx context.Context, id string) context.Context {
 	return context.WithValue(ctx, signatureKey, id)
 }
 
+// getSignature reads the state of the external provider flow from the
+// context.
+func getSignature(ctx context.Context) string {
+	obj := ctx.Value(signatureKey)
+	if obj == nil {
+		return ""
+	}
+	return obj.(string)
+}
+
 func withInviteToken(ctx context.Context, token string) context.Context {
 	return context.WithValue(ctx, inviteTokenKey, token)
 }
@@ -241,3 +254,26 @@ func getExternalHost(ctx context.Context) *url.URL {
 	}
 	return obj.(*url.URL)
 }
+
+// withInstance adds the audience and configuration of the request's
+// instance to the context.
+func withInstance(ctx context.Context, aud string, config *conf.GlobalConfiguration) context.Context {
+	ctx = context.WithValue(ctx, instanceAudKey, aud)
+	return context.WithValue(ctx, instanceConfigKey, config)
+}
+
+func getInstanceAud(ctx context.Context) string {
+	obj := ctx.Value(instanceAudKey)
+	if obj == nil {
+		return ""
+	}
+	return obj.(string)
+}
+
+func getInstanceConfig(ctx context.Context) *conf.GlobalConfiguration {
+	obj := ctx.Value(instanceConfigKey)
+	if obj == nil {
+		return nil
+	}
+	return obj.(*conf.GlobalConfiguration)
+}
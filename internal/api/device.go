@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// deviceCodeGrantType is the grant_type of token requests exchanging a
+// device code, see RFC 8628. deviceCodeGrantTypeShort is accepted too, for
+// clients which don't spell out the URN.
+const (
+	deviceCodeGrantType      = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceCodeGrantTypeShort = "device_code"
+)
+
+// DeviceAuthorizationResponse is the response of a device authorization
+// request, see https://www.rfc-editor.org/rfc/rfc8628#section-3.2
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceCodeGrantParams are the parameters the DeviceCodeGrant method accepts
+type DeviceCodeGrantParams struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// DeviceVerifyParams are the parameters the DeviceVerify method accepts
+type DeviceVerifyParams struct {
+	UserCode string `json:"user_code"`
+}
+
+// DeviceVerificationResponse describes a pending device authorization to the
+// user asked to approve it.
+type DeviceVerificationResponse struct {
+	UserCode  string    `json:"user_code"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Approved  bool      `json:"approved"`
+}
+
+func (a *API) deviceVerificationURI(ctx context.Context) string {
+	if uri := a.config.DeviceAuthorization.VerificationURI; uri != "" {
+		return uri
+	}
+
+	return strings.TrimSuffix(a.getConfig(ctx).SiteURL, "/") + "/device"
+}
+
+// DeviceAuthorization starts the device flow by issuing a device code for
+// the client to poll with and a user code for the user to approve.
+func (a *API) DeviceAuthorization(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.config.DeviceAuthorization
+
+	userCode, err := models.GenerateUserCode()
+	if err != nil {
+		return internalServerError("Error generating user code").WithInternalError(err)
+	}
+
+	deviceCode := crypto.SecureToken(32)
+	deviceAuthorization, err := models.NewDeviceCode(deviceCode, userCode, config.PollInterval, a.Now().Add(config.CodeExpiry))
+	if err != nil {
+		return internalServerError("Error creating device code").WithInternalError(err)
+	}
+
+	if err := db.Create(deviceAuthorization); err != nil {
+		return internalServerError("Database error saving device code").WithInternalError(err)
+	}
+
+	verificationURI := a.deviceVerificationURI(ctx)
+	complete, err := url.Parse(verificationURI)
+	if err != nil {
+		return internalServerError("Invalid verification URI").WithInternalError(err)
+	}
+	query := complete.Query()
+	query.Set("user_code", userCode)
+	complete.RawQuery = query.Encode()
+
+	return sendJSON(w, http.StatusOK, &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: complete.String(),
+		ExpiresIn:               int(config.CodeExpiry.Seconds()),
+		Interval:                deviceAuthorization.PollInterval,
+	})
+}
+
+func (a *API) findDeviceCodeByUserCode(tx *storage.Connection, userCode string) (*models.DeviceCode, error) {
+	userCode = models.NormalizeUserCode(userCode)
+	if userCode == "" {
+		return nil, notFoundError(ErrorCodeDeviceCodeNotFound, "Invalid user code")
+	}
+
+	deviceAuthorization, err := models.FindDeviceCodeByUserCode(tx, userCode)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, notFoundError(ErrorCodeDeviceCodeNotFound, "Invalid user code")
+		}
+		return nil, internalServerError("Database error finding device code").WithInternalError(err)
+	}
+
+	if deviceAuthorization.IsExpired(a.Now()) {
+		return nil, unprocessableEntityError(ErrorCodeDeviceCodeExpired, "User code has expired")
+	}
+
+	return deviceAuthorization, nil
+}
+
+// DeviceVerifyGet returns the device authorization of a user code, so that
+// the verification page can ask the signed in user to approve it.
+func (a *API) DeviceVerifyGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	deviceAuthorization, err := a.findDeviceCodeByUserCode(db, r.URL.Query().Get("user_code"))
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, &DeviceVerificationResponse{
+		UserCode:  deviceAuthorization.UserCode,
+		ExpiresAt: deviceAuthorization.ExpiresAt,
+		Approved:  deviceAuthorization.IsApproved(),
+	})
+}
+
+// DeviceVerify approves the device authorization of a user code on behalf of
+// the signed in user. The device then receives a session of that user.
+func (a *API) DeviceVerify(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+
+	params := &DeviceVerifyParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	var deviceAuthorization *models.DeviceCode
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		deviceAuthorization, terr = a.findDeviceCodeByUserCode(tx, params.UserCode)
+		if terr != nil {
+			return terr
+		}
+
+		if deviceAuthorization.IsApproved() {
+			if *deviceAuthorization.UserID != user.ID {
+				return unprocessableEntityError(ErrorCodeDeviceCodeAlreadyApproved, "User code has already been used")
+			}
+			return nil
+		}
+
+		if terr := deviceAuthorization.Approve(tx, user, a.Now()); terr != nil {
+			return internalServerError("Database error approving device code").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, user, models.DeviceApprovedAction, "", map[string]interface{}{
+			"user_code": deviceAuthorization.UserCode,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, &DeviceVerificationResponse{
+		UserCode:  deviceAuthorization.UserCode,
+		ExpiresAt: deviceAuthorization.ExpiresAt,
+		Approved:  true,
+	})
+}
+
+// DeviceCodeGrant implements the device code grant type flow, see
+// https://www.rfc-editor.org/rfc/rfc8628#section-3.4
+func (a *API) DeviceCodeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(ctx)
+
+	if !a.config.DeviceAuthorization.Enabled {
+		return oauthError("unsupported_grant_type", ErrorCodeDeviceAuthorizationDisabled, "")
+	}
+
+	var grantParams models.GrantParams
+	grantParams.FillGrantParams(r)
+
+	params := &DeviceCodeGrantParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.DeviceCode == "" {
+		return oauthError("invalid_request", ErrorCodeValidationFailed, "device_code is required")
+	}
+
+	// polls that don't issue tokens still commit, to record the poll time or
+	// remove an expired device code, and return grantErr afterwards
+	var grantErr error
+	var token *AccessTokenResponse
+	err := db.Transaction(func(tx *storage.Connection) error {
+		deviceAuthorization, terr := models.FindDeviceCodeForUpdate(tx, params.DeviceCode)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				grantErr = oauthError("invalid_grant", ErrorCodeDeviceCodeNotFound, "Invalid device code")
+				return nil
+			}
+			return internalServerError("Database error finding device code").WithInternalError(terr)
+		}
+
+		now := a.Now()
+		if deviceAuthorization.IsExpired(now) {
+			grantErr = oauthError("expired_token", ErrorCodeDeviceCodeExpired, "Device code has expired")
+			return tx.Destroy(deviceAuthorization)
+		}
+
+		tooEarly, terr := deviceAuthorization.RecordPoll(tx, now)
+		if terr != nil {
+			return internalServerError("Database error updating device code").WithInternalError(terr)
+		}
+		if tooEarly {
+			grantErr = oauthError("slow_down", ErrorCodeOverRequestRateLimit, "Polling too frequently")
+			return nil
+		}
+
+		if !deviceAuthorization.IsApproved() {
+			grantErr = oauthError("authorization_pending", ErrorCodeDeviceAuthorizationPending, "The user has not approved the device yet")
+			return nil
+		}
+
+		user, terr := models.FindUserByID(tx, *deviceAuthorization.UserID)
+		if terr != nil {
+			return internalServerError("Database error finding user").WithInternalError(terr)
+		}
+		if user.IsBanned() {
+			grantErr = forbiddenError(ErrorCodeUserBanned, "User is banned")
+			return tx.Destroy(deviceAuthorization)
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, user, models.LoginAction, "", map[string]interface{}{
+			"provider_type": models.DeviceCodeGrant.String(),
+		}); terr != nil {
+			return terr
+		}
+		a.notifyWebhook(r, tx, conf.WebhookLoginEvent, user)
+
+		token, terr = a.issueRefreshToken(r, tx, user, models.DeviceCodeGrant, grantParams)
+		if terr != nil {
+			return oauthError("server_error", ErrorCodeUnexpectedFailure, terr.Error())
+		}
+
+		return tx.Destroy(deviceAuthorization)
+	})
+	if err != nil {
+		return err
+	}
+	if grantErr != nil {
+		return grantErr
+	}
+
+	return sendJSON(w, http.StatusOK, token)
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+type DeviceTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	now   time.Time
+	user  *models.User
+	token string
+}
+
+func TestDevice(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &DeviceTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *DeviceTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	ts.Config.DeviceAuthorization = conf.DeviceAuthorizationConfiguration{
+		Enabled:      true,
+		CodeExpiry:   10 * time.Minute,
+		PollInterval: 5 * time.Second,
+	}
+
+	ts.now = time.Now()
+	ts.API.overrideTime = func() time.Time {
+		return ts.now
+	}
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	ts.user = u
+
+	s, err := models.NewSession(u.ID, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(s))
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", nil)
+	ts.token, _, err = ts.API.generateAccessToken(req, ts.API.db, u, &s.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+}
+
+func (ts *DeviceTestSuite) TearDownTest() {
+	ts.API.overrideTime = nil
+	ts.Config.DeviceAuthorization = conf.DeviceAuthorizationConfiguration{}
+}
+
+func (ts *DeviceTestSuite) advance(d time.Duration) {
+	ts.now = ts.now.Add(d)
+}
+
+func (ts *DeviceTestSuite) request(method, path string, body map[string]interface{}, token string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if body != nil {
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *DeviceTestSuite) authorize() *DeviceAuthorizationResponse {
+	w := ts.request(http.MethodPost, "/device/code", nil, "")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := &DeviceAuthorizationResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	return resp
+}
+
+func (ts *DeviceTestSuite) poll(deviceCode string) *httptest.ResponseRecorder {
+	return ts.request(http.MethodPost, "/token?grant_type="+deviceCodeGrantType, map[string]interface{}{
+		"device_code": deviceCode,
+	}, "")
+}
+
+func (ts *DeviceTestSuite) requirePollError(w *httptest.ResponseRecorder, expected string) {
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+
+	data := map[string]interface{}{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Equal(ts.T(), expected, data["error"])
+}
+
+func (ts *DeviceTestSuite) TestDisabled() {
+	ts.Config.DeviceAuthorization.Enabled = false
+
+	w := ts.request(http.MethodPost, "/device/code", nil, "")
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+
+	ts.requirePollError(ts.poll("device-code"), "unsupported_grant_type")
+}
+
+func (ts *DeviceTestSuite) TestAuthorization() {
+	resp := ts.authorize()
+	require.NotEmpty(ts.T(), resp.DeviceCode)
+	require.Equal(ts.T(), resp.UserCode, models.NormalizeUserCode(resp.UserCode))
+	require.Equal(ts.T(), ts.Config.SiteURL+"/device", resp.VerificationURI)
+	require.Equal(ts.T(), resp.VerificationURI+"?user_code="+resp.UserCode, resp.VerificationURIComplete)
+	require.Equal(ts.T(), 600, resp.ExpiresIn)
+	require.Equal(ts.T(), 5, resp.Interval)
+}
+
+func (ts *DeviceTestSuite) TestFullFlow() {
+	resp := ts.authorize()
+
+	ts.requirePollError(ts.poll(resp.DeviceCode), "authorization_pending")
+
+	// polling before the interval has passed slows the client down
+	ts.advance(2 * time.Second)
+	ts.requirePollError(ts.poll(resp.DeviceCode), "slow_down")
+
+	// the interval is now 10 seconds, and polling early again lengthens it
+	// to 15 seconds
+	ts.advance(5 * time.Second)
+	ts.requirePollError(ts.poll(resp.DeviceCode), "slow_down")
+	ts.advance(15 * time.Second)
+	ts.requirePollError(ts.poll(resp.DeviceCode), "authorization_pending")
+
+	// the verification page shows the code to the signed in user, who
+	// approves it with any case and separator
+	w := ts.request(http.MethodGet, "/device?user_code="+resp.UserCode, nil, "")
+	require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+
+	w = ts.request(http.MethodGet, "/device?user_code="+resp.UserCode, nil, ts.token)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	verification := &DeviceVerificationResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(verification))
+	require.Equal(ts.T(), resp.UserCode, verification.UserCode)
+	require.False(ts.T(), verification.Approved)
+
+	w = ts.request(http.MethodPost, "/device", map[string]interface{}{
+		"user_code": "wrong",
+	}, ts.token)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code, w.Body.String())
+
+	w = ts.request(http.MethodPost, "/device", map[string]interface{}{
+		"user_code": " " + resp.UserCode[:4] + resp.UserCode[5:] + " ",
+	}, ts.token)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	ts.advance(15 * time.Second)
+	w = ts.poll(resp.DeviceCode)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	require.NotEmpty(ts.T(), token.Token)
+	require.NotEmpty(ts.T(), token.RefreshToken)
+	require.Equal(ts.T(), ts.user.ID, token.User.ID)
+
+	// the device code can only be exchanged once
+	ts.advance(15 * time.Second)
+	ts.requirePollError(ts.poll(resp.DeviceCode), "invalid_grant")
+}
+
+func (ts *DeviceTestSuite) TestShortGrantType() {
+	resp := ts.authorize()
+
+	w := ts.request(http.MethodPost, "/token?grant_type="+deviceCodeGrantTypeShort, map[string]interface{}{
+		"device_code": resp.DeviceCode,
+	}, "")
+	ts.requirePollError(w, "authorization_pending")
+}
+
+func (ts *DeviceTestSuite) TestExpiry() {
+	resp := ts.authorize()
+
+	ts.advance(10 * time.Minute)
+
+	w := ts.request(http.MethodPost, "/device", map[string]interface{}{
+		"user_code": resp.UserCode,
+	}, ts.token)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	ts.requirePollError(ts.poll(resp.DeviceCode), "expired_token")
+	ts.requirePollError(ts.poll(resp.DeviceCode), "invalid_grant")
+}
+
+func (ts *DeviceTestSuite) TestApprovedByAnotherUser() {
+	resp := ts.authorize()
+
+	w := ts.request(http.MethodPost, "/device", map[string]interface{}{
+		"user_code": resp.UserCode,
+	}, ts.token)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// approving again is a no-op
+	w = ts.request(http.MethodPost, "/device", map[string]interface{}{
+		"user_code": resp.UserCode,
+	}, ts.token)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	other, err := models.NewUser("", "other@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(other))
+
+	s, err := models.NewSession(other.ID, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(s))
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", nil)
+	otherToken, _, err := ts.API.generateAccessToken(req, ts.API.db, other, &s.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+
+	w = ts.request(http.MethodPost, "/device", map[string]interface{}{
+		"user_code": resp.UserCode,
+	}, otherToken)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+}
+
+func (ts *DeviceTestSuite) TestBannedUser() {
+	resp := ts.authorize()
+
+	w := ts.request(http.MethodPost, "/device", map[string]interface{}{
+		"user_code": resp.UserCode,
+	}, ts.token)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(ts.T(), ts.user.Ban(ts.API.db, time.Hour))
+
+	ts.advance(5 * time.Second)
+	w = ts.poll(resp.DeviceCode)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code, w.Body.String())
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeUserBanned, data.ErrorCode)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AuthorizationServerMetadataResponse is the OAuth 2.0 Authorization Server
+// Metadata. See: https://www.rfc-editor.org/rfc/rfc8414#section-2
+type AuthorizationServerMetadataResponse struct {
+	Issuer                                 string   `json:"issuer"`
+	TokenEndpoint                          string   `json:"token_endpoint"`
+	JWKSURI                                string   `json:"jwks_uri"`
+	RevocationEndpoint                     string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint                  string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint            string   `json:"device_authorization_endpoint,omitempty"`
+	ResponseTypesSupported                 []string `json:"response_types_supported"`
+	GrantTypesSupported                    []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported      []string `json:"token_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported []string `json:"revocation_endpoint_auth_methods_supported"`
+}
+
+// AuthorizationServerMetadata describes the endpoints and grant types of the
+// server, so that OAuth client libraries can be pointed at it directly. Only
+// grant types with registered identifiers are listed. There's no
+// authorization endpoint: /authorize signs in with external providers, and
+// no ID tokens are issued. The issuer is the JWT issuer, which the access
+// tokens are stamped with, so the metadata isn't available without one.
+func (a *API) AuthorizationServerMetadata(w http.ResponseWriter, r *http.Request) error {
+	config := a.getConfig(r.Context())
+
+	if config.JWT.Issuer == "" {
+		return notFoundError(ErrorCodeJWTIssuerNotConfigured, "The JWT issuer is not configured")
+	}
+
+	baseURL := strings.TrimSuffix(config.API.ExternalURL, "/")
+
+	resp := &AuthorizationServerMetadataResponse{
+		Issuer:                                 config.JWT.Issuer,
+		TokenEndpoint:                          baseURL + "/token",
+		JWKSURI:                                baseURL + "/.well-known/jwks.json",
+		RevocationEndpoint:                     baseURL + "/token/revoke",
+		IntrospectionEndpoint:                  baseURL + "/token/introspect",
+		ResponseTypesSupported:                 []string{},
+		GrantTypesSupported:                    []string{"password", "refresh_token"},
+		TokenEndpointAuthMethodsSupported:      []string{"none"},
+		RevocationEndpointAuthMethodsSupported: []string{"none"},
+	}
+
+	if config.DeviceAuthorization.Enabled {
+		resp.DeviceAuthorizationEndpoint = baseURL + "/device/code"
+		resp.GrantTypesSupported = append(resp.GrantTypesSupported, deviceCodeGrantType)
+	}
+	if config.ClientCredentials.Enabled {
+		resp.GrantTypesSupported = append(resp.GrantTypesSupported, "client_credentials")
+		resp.TokenEndpointAuthMethodsSupported = append(resp.TokenEndpointAuthMethodsSupported, "client_secret_basic", "client_secret_post")
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	return sendJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/storage"
+)
+
+func TestAuthorizationServerMetadata(t *testing.T) {
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.JWT.Issuer = "https://auth.example.com"
+			config.DeviceAuthorization.Enabled = true
+		}
+	})
+	require.NoError(t, err)
+
+	for _, path := range []string{"/.well-known/oauth-authorization-server", "/.well-known/openid-configuration"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil)
+		w := httptest.NewRecorder()
+		api.handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := map[string]interface{}{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+
+		baseURL := strings.TrimSuffix(config.API.ExternalURL, "/")
+		require.Equal(t, "https://auth.example.com", resp["issuer"])
+		require.Equal(t, baseURL+"/token", resp["token_endpoint"])
+		require.Equal(t, baseURL+"/.well-known/jwks.json", resp["jwks_uri"])
+		require.Equal(t, baseURL+"/device/code", resp["device_authorization_endpoint"])
+		require.Equal(t, []interface{}{}, resp["response_types_supported"])
+		require.Equal(t, []interface{}{"password", "refresh_token", deviceCodeGrantType}, resp["grant_types_supported"])
+
+		// no OpenID Connect features are advertised
+		require.NotContains(t, resp, "authorization_endpoint")
+		require.NotContains(t, resp, "id_token_signing_alg_values_supported")
+		require.NotContains(t, resp, "subject_types_supported")
+	}
+}
+
+func TestAuthorizationServerMetadataWithoutIssuer(t *testing.T) {
+	api, _, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.JWT.Issuer = ""
+		}
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/.well-known/oauth-authorization-server", nil)
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
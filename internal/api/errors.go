@@ -34,6 +34,8 @@ var oauthErrorMap = map[int]string{
 type OAuthError struct {
 	Err             string `json:"error"`
 	Description     string `json:"error_description,omitempty"`
+	ErrorCode       string `json:"error_code,omitempty"`
+	ErrorID         string `json:"error_id,omitempty"`
 	InternalError   error  `json:"-"`
 	InternalMessage string `json:"-"`
 }
@@ -65,8 +67,8 @@ func (e *OAuthError) Cause() error {
 	return e
 }
 
-func oauthError(err string, description string) *OAuthError {
-	return &OAuthError{Err: err, Description: description}
+func oauthError(err string, errorCode ErrorCode, description string) *OAuthError {
+	return &OAuthError{Err: err, ErrorCode: errorCode, Description: description}
 }
 
 func badRequestError(errorCode ErrorCode, fmtString string, args ...interface{}) *HTTPError {
@@ -146,6 +148,18 @@ func httpError(httpStatus int, errorCode ErrorCode, fmtString string, args ...in
 	}
 }
 
+// code returns the error code of the error, falling back to a generic code
+// when none was assigned.
+func (e *HTTPError) code() ErrorCode {
+	if e.ErrorCode != "" {
+		return e.ErrorCode
+	}
+	if e.HTTPStatus == http.StatusInternalServerError {
+		return ErrorCodeUnexpectedFailure
+	}
+	return ErrorCodeUnknown
+}
+
 // Recoverer is a middleware that recovers from panics, logs the panic (and a
 // backtrace), and returns a HTTP 500 (Internal Server Error) status if
 // possible. Recoverer prints a request ID if one is provided.
@@ -153,6 +167,11 @@ func recoverer(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rvr := recover(); rvr != nil {
+				if rvr == http.ErrAbortHandler {
+					// the handler aborted the response on purpose
+					panic(rvr)
+				}
+
 				logEntry := observability.GetLogEntry(r)
 				if logEntry != nil {
 					logEntry.Panic(rvr, debug.Stack())
@@ -163,6 +182,7 @@ func recoverer(next http.Handler) http.Handler {
 
 				se := &HTTPError{
 					HTTPStatus: http.StatusInternalServerError,
+					ErrorCode:  ErrorCodeUnexpectedFailure,
 					Message:    http.StatusText(http.StatusInternalServerError),
 				}
 				HandleResponseError(se, w, r)
@@ -181,6 +201,7 @@ type ErrorCause interface {
 type HTTPErrorResponse20240101 struct {
 	Code    ErrorCode `json:"code"`
 	Message string    `json:"message"`
+	ErrorID string    `json:"error_id,omitempty"`
 }
 
 func HandleResponseError(err error, w http.ResponseWriter, r *http.Request) {
@@ -207,6 +228,7 @@ func HandleResponseError(err error, w http.ResponseWriter, r *http.Request) {
 
 			output.Code = ErrorCodeWeakPassword
 			output.Message = e.Message
+			output.ErrorID = errorID
 			output.Payload.Reasons = e.Reasons
 
 			if jsonErr := sendJSON(w, http.StatusUnprocessableEntity, output); jsonErr != nil && jsonErr != context.DeadlineExceeded {
@@ -224,6 +246,7 @@ func HandleResponseError(err error, w http.ResponseWriter, r *http.Request) {
 			output.HTTPStatus = http.StatusUnprocessableEntity
 			output.ErrorCode = ErrorCodeWeakPassword
 			output.Message = e.Message
+			output.ErrorID = errorID
 			output.Payload.Reasons = e.Reasons
 
 			if jsonErr := sendJSON(w, output.HTTPStatus, output); jsonErr != nil && jsonErr != context.DeadlineExceeded {
@@ -231,9 +254,23 @@ func HandleResponseError(err error, w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+	case *SCIMError:
+		e.ErrorID = errorID
+		if e.HTTPStatus >= http.StatusInternalServerError {
+			log.WithError(e.Cause()).Error(e.Error())
+		} else {
+			log.WithError(e.Cause()).Info(e.Error())
+		}
+
+		if jsonErr := sendSCIM(w, e.HTTPStatus, e.response()); jsonErr != nil && jsonErr != context.DeadlineExceeded {
+			log.WithError(jsonErr).Warn("Failed to send JSON on ResponseWriter")
+		}
+
 	case *HTTPError:
+		// the request ID lets users report the error so that it can be
+		// found in the logs
+		e.ErrorID = errorID
 		if e.HTTPStatus >= http.StatusInternalServerError {
-			e.ErrorID = errorID
 			// this will get us the stack trace too
 			log.WithError(e.Cause()).Error(e.Error())
 		} else {
@@ -242,29 +279,16 @@ func HandleResponseError(err error, w http.ResponseWriter, r *http.Request) {
 
 		if apiVersion.Compare(APIVersion20240101) >= 0 {
 			resp := HTTPErrorResponse20240101{
-				Code:    e.ErrorCode,
+				Code:    e.code(),
 				Message: e.Message,
-			}
-
-			if resp.Code == "" {
-				if e.HTTPStatus == http.StatusInternalServerError {
-					resp.Code = ErrorCodeUnexpectedFailure
-				} else {
-					resp.Code = ErrorCodeUnknown
-				}
+				ErrorID: e.ErrorID,
 			}
 
 			if jsonErr := sendJSON(w, e.HTTPStatus, resp); jsonErr != nil && jsonErr != context.DeadlineExceeded {
 				log.WithError(jsonErr).Warn("Failed to send JSON on ResponseWriter")
 			}
 		} else {
-			if e.ErrorCode == "" {
-				if e.HTTPStatus == http.StatusInternalServerError {
-					e.ErrorCode = ErrorCodeUnexpectedFailure
-				} else {
-					e.ErrorCode = ErrorCodeUnknown
-				}
-			}
+			e.ErrorCode = e.code()
 
 			// Provide better error messages for certain user-triggered Postgres errors.
 			if pgErr := utilities.NewPostgresError(e.InternalError); pgErr != nil {
@@ -280,6 +304,14 @@ func HandleResponseError(err error, w http.ResponseWriter, r *http.Request) {
 		}
 
 	case *OAuthError:
+		e.ErrorID = errorID
+		if e.ErrorCode == "" {
+			if e.Err == "server_error" {
+				e.ErrorCode = ErrorCodeUnexpectedFailure
+			} else {
+				e.ErrorCode = ErrorCodeUnknown
+			}
+		}
 		log.WithError(e.Cause()).Info(e.Error())
 		if jsonErr := sendJSON(w, http.StatusBadRequest, e); jsonErr != nil && jsonErr != context.DeadlineExceeded {
 			log.WithError(jsonErr).Warn("Failed to send JSON on ResponseWriter")
@@ -295,6 +327,7 @@ func HandleResponseError(err error, w http.ResponseWriter, r *http.Request) {
 			resp := HTTPErrorResponse20240101{
 				Code:    ErrorCodeUnexpectedFailure,
 				Message: "Unexpected failure, please check server logs for more information",
+				ErrorID: errorID,
 			}
 
 			if jsonErr := sendJSON(w, http.StatusInternalServerError, resp); jsonErr != nil && jsonErr != context.DeadlineExceeded {
@@ -305,6 +338,7 @@ func HandleResponseError(err error, w http.ResponseWriter, r *http.Request) {
 				HTTPStatus: http.StatusInternalServerError,
 				ErrorCode:  ErrorCodeUnexpectedFailure,
 				Message:    "Unexpected failure, please check server logs for more information",
+				ErrorID:    errorID,
 			}
 
 			if jsonErr := sendJSON(w, http.StatusInternalServerError, httpError); jsonErr != nil && jsonErr != context.DeadlineExceeded {
@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/utilities"
 )
 
 func TestHandleResponseErrorWithHTTPError(t *testing.T) {
@@ -68,6 +70,51 @@ func TestHandleResponseErrorWithHTTPError(t *testing.T) {
 	}
 }
 
+func TestHandleResponseErrorIncludesRequestID(t *testing.T) {
+	examples := []struct {
+		Error        error
+		APIVersion   string
+		ExpectedBody string
+	}{
+		{
+			Error:        badRequestError(ErrorCodeBadJSON, "Unable to parse JSON"),
+			ExpectedBody: "{\"code\":400,\"error_code\":\"" + ErrorCodeBadJSON + "\",\"msg\":\"Unable to parse JSON\",\"error_id\":\"test-request-id\"}",
+		},
+		{
+			Error:        badRequestError(ErrorCodeBadJSON, "Unable to parse JSON"),
+			APIVersion:   "2024-01-01",
+			ExpectedBody: "{\"code\":\"" + ErrorCodeBadJSON + "\",\"message\":\"Unable to parse JSON\",\"error_id\":\"test-request-id\"}",
+		},
+		{
+			Error:        oauthError("invalid_grant", ErrorCodeRefreshTokenAlreadyUsed, "Invalid Refresh Token"),
+			ExpectedBody: "{\"error\":\"invalid_grant\",\"error_description\":\"Invalid Refresh Token\",\"error_code\":\"" + ErrorCodeRefreshTokenAlreadyUsed + "\",\"error_id\":\"test-request-id\"}",
+		},
+		{
+			Error:        &OAuthError{Err: "invalid_grant", Description: "Uncoded failure"},
+			ExpectedBody: "{\"error\":\"invalid_grant\",\"error_description\":\"Uncoded failure\",\"error_code\":\"" + ErrorCodeUnknown + "\",\"error_id\":\"test-request-id\"}",
+		},
+		{
+			Error:        errors.New("unhandled"),
+			ExpectedBody: "{\"code\":500,\"error_code\":\"" + ErrorCodeUnexpectedFailure + "\",\"msg\":\"Unexpected failure, please check server logs for more information\",\"error_id\":\"test-request-id\"}",
+		},
+	}
+
+	for _, example := range examples {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, "http://example.com", nil)
+		require.NoError(t, err)
+		req = req.WithContext(utilities.WithRequestID(req.Context(), "test-request-id"))
+
+		if example.APIVersion != "" {
+			req.Header.Set(APIVersionHeaderName, example.APIVersion)
+		}
+
+		HandleResponseError(example.Error, rec, req)
+
+		require.Equal(t, example.ExpectedBody, rec.Body.String())
+	}
+}
+
 func TestRecoverer(t *testing.T) {
 	var logBuffer bytes.Buffer
 	config, err := conf.LoadGlobal(apiTestConfig)
@@ -103,3 +150,20 @@ func TestRecoverer(t *testing.T) {
 	require.Equal(t, "test panic", logs["panic"])
 	require.NotEmpty(t, logs["stack"])
 }
+
+func TestRecovererAbortHandler(t *testing.T) {
+	abortHandler := recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic(http.ErrAbortHandler)
+	}))
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	require.NoError(t, err)
+
+	// aborted responses are left to the server to cut off
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		abortHandler.ServeHTTP(w, req)
+	})
+	require.Empty(t, w.Body.String())
+}
@@ -2,8 +2,13 @@ package api
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -15,6 +20,8 @@ import (
 	jwt "github.com/golang-jwt/jwt"
 	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/api/provider"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
@@ -29,7 +36,74 @@ type ExternalProviderClaims struct {
 	InviteToken     string `json:"invite_token,omitempty"`
 	Referrer        string `json:"referrer,omitempty"`
 	FlowStateID     string `json:"flow_state_id"`
+	FlowType        string `json:"flow_type"`
 	LinkingTargetID string `json:"linking_target_id,omitempty"`
+
+	// Nonce makes every state unique, so that a state can't be guessed
+	// or forged from another one.
+	Nonce string `json:"nonce"`
+
+	// CookieBound is set when the nonce is also kept in a cookie, so that
+	// the callback is only completed in the browser that started the flow.
+	CookieBound bool `json:"cookie_bound,omitempty"`
+}
+
+// oauthNonceCookie is the name of the cookie with the nonce of the OAuth
+// flow the browser started.
+func oauthNonceCookie(config *conf.GlobalConfiguration) string {
+	return config.Cookie.Key + "-oauth-nonce"
+}
+
+// setOAuthNonceCookie binds the OAuth flow with the nonce to the browser.
+// Providers such as Apple post the callback from their site, so the cookie
+// is sent with cross-site requests.
+func setOAuthNonceCookie(config *conf.GlobalConfiguration, w http.ResponseWriter, nonce string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthNonceCookie(config),
+		Value:    nonce,
+		MaxAge:   int(config.External.StateExpiryDuration.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+		Path:     "/",
+		Domain:   config.Cookie.Domain,
+	})
+}
+
+// clearOAuthNonceCookie removes the nonce once the callback is done.
+func clearOAuthNonceCookie(config *conf.GlobalConfiguration, w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthNonceCookie(config),
+		Value:    "",
+		Expires:  time.Now().Add(-1 * time.Hour * 10),
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+		Path:     "/",
+		Domain:   config.Cookie.Domain,
+	})
+}
+
+// isSecureRequest reports whether browsers keep secure cookies of the
+// request, which is made over HTTPS or to localhost, to the external host of
+// the request or otherwise the configured external URL.
+func isSecureRequest(r *http.Request, config *conf.GlobalConfiguration) bool {
+	u := getExternalHost(r.Context())
+	if u == nil {
+		var err error
+		if u, err = url.Parse(config.API.ExternalURL); err != nil {
+			return false
+		}
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
 }
 
 // ExternalProviderRedirect redirects the request to the oauth provider
@@ -46,7 +120,7 @@ func (a *API) ExternalProviderRedirect(w http.ResponseWriter, r *http.Request) e
 func (a *API) GetExternalProviderRedirectURL(w http.ResponseWriter, r *http.Request, linkingTargetUser *models.User) (string, error) {
 	ctx := r.Context()
 	db := a.db.WithContext(ctx)
-	config := a.config
+	config := a.getConfig(ctx)
 
 	query := r.URL.Query()
 	providerType := query.Get("provider")
@@ -59,6 +133,10 @@ func (a *API) GetExternalProviderRedirectURL(w http.ResponseWriter, r *http.Requ
 		return "", badRequestError(ErrorCodeValidationFailed, "Unsupported provider: %+v", err).WithInternalError(err)
 	}
 
+	if err := a.requireFeature(ctx, conf.ExternalProviderFeature(providerType)); err != nil {
+		return "", err
+	}
+
 	inviteToken := query.Get("invite_token")
 	if inviteToken != "" {
 		_, userErr := models.FindUserByConfirmationToken(db, inviteToken)
@@ -87,10 +165,20 @@ func (a *API) GetExternalProviderRedirectURL(w http.ResponseWriter, r *http.Requ
 		flowStateID = flowState.ID.String()
 	}
 
+	now := a.Now()
+	nonce := crypto.SecureToken()
+	// Only the browser's navigation to /authorize keeps the cookie. Linking
+	// is started by a fetch with the user's token, whose cookies
+	// cross-origin apps don't keep, and secure cookies aren't kept over
+	// plain HTTP.
+	cookieBound := linkingTargetUser == nil && isSecureRequest(r, config)
 	claims := ExternalProviderClaims{
 		AuthMicroserviceClaims: AuthMicroserviceClaims{
 			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+				IssuedAt:  now.Unix(),
+				ExpiresAt: now.Add(config.External.StateExpiryDuration).Unix(),
+				// the callback uses the configuration of the same instance
+				Audience: a.requestAud(ctx, r),
 			},
 			SiteURL:    config.SiteURL,
 			InstanceID: uuid.Nil.String(),
@@ -99,6 +187,9 @@ func (a *API) GetExternalProviderRedirectURL(w http.ResponseWriter, r *http.Requ
 		InviteToken: inviteToken,
 		Referrer:    redirectURL,
 		FlowStateID: flowStateID,
+		FlowType:    flowType.String(),
+		Nonce:       nonce,
+		CookieBound: cookieBound,
 	}
 
 	if linkingTargetUser != nil {
@@ -126,13 +217,45 @@ func (a *API) GetExternalProviderRedirectURL(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
+	if _, ok := p.(provider.PKCEOAuthProvider); ok {
+		authUrlParams = append(authUrlParams, oauth2.S256ChallengeOption(providerCodeVerifier(config, tokenString)))
+	}
+
 	authURL := p.AuthCodeURL(tokenString, authUrlParams...)
+	if cookieBound {
+		setOAuthNonceCookie(config, w, nonce)
+	}
 
 	return authURL, nil
 }
 
+// providerCodeVerifier returns the PKCE code verifier of the flow with the
+// state, for providers that require PKCE. It's derived from the state, so
+// that it doesn't need to be stored, and can't be computed without the JWT
+// secret.
+func providerCodeVerifier(config *conf.GlobalConfiguration, state string) string {
+	mac := hmac.New(sha256.New, []byte(config.JWT.Secret))
+	mac.Write([]byte("code_verifier:" + state))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
 // ExternalProviderCallback handles the callback endpoint in the external oauth provider flow
 func (a *API) ExternalProviderCallback(w http.ResponseWriter, r *http.Request) error {
+	clearOAuthNonceCookie(a.config, w)
+
+	ctx, err := a.loadFlowState(w, r)
+	if err != nil {
+		u, perr := url.Parse(a.stateErrorRedirectURL(r))
+		if perr != nil {
+			return err
+		}
+		a.redirectErrors(func(w http.ResponseWriter, r *http.Request) error {
+			return err
+		}, w, r, u)
+		return nil
+	}
+	r = r.WithContext(ctx)
+
 	rurl := a.getExternalRedirectURL(r)
 	u, err := url.Parse(rurl)
 	if err != nil {
@@ -142,10 +265,34 @@ func (a *API) ExternalProviderCallback(w http.ResponseWriter, r *http.Request) e
 	return nil
 }
 
+// stateErrorRedirectURL returns where the errors of a callback with an
+// invalid state are sent: the redirect URL named in the state, if it's
+// allowed, as the state may only have expired, or else the site URL.
+func (a *API) stateErrorRedirectURL(r *http.Request) string {
+	config := a.config
+
+	state := r.FormValue("state")
+	claims := ExternalProviderClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(state, &claims); err == nil && claims.Audience != "" {
+		if ctx, err := a.withInstanceConfig(r.Context(), claims.Audience); err == nil {
+			config = a.getConfig(ctx)
+		}
+	}
+
+	if claims.Referrer != "" && utilities.IsRedirectURLValid(config, claims.Referrer) {
+		return claims.Referrer
+	}
+	return config.SiteURL
+}
+
 func (a *API) handleOAuthCallback(r *http.Request) (*OAuthProviderData, error) {
 	ctx := r.Context()
 	providerType := getExternalProviderType(ctx)
 
+	if err := providerCallbackError(r); err != nil {
+		return nil, err
+	}
+
 	var oAuthResponseData *OAuthProviderData
 	var err error
 	switch providerType {
@@ -164,12 +311,16 @@ func (a *API) handleOAuthCallback(r *http.Request) (*OAuthProviderData, error) {
 func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	db := a.db.WithContext(ctx)
-	config := a.config
+	config := a.getConfig(ctx)
 
 	var grantParams models.GrantParams
 	grantParams.FillGrantParams(r)
 
 	providerType := getExternalProviderType(ctx)
+	if err := a.requireFeature(ctx, conf.ExternalProviderFeature(providerType)); err != nil {
+		return err
+	}
+
 	data, err := a.handleOAuthCallback(r)
 	if err != nil {
 		return err
@@ -177,7 +328,12 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 
 	userData := data.userData
 	if len(userData.Emails) <= 0 {
-		return internalServerError("Error getting user email from external provider")
+		// SSO providers and custom ID token issuers have no provider
+		// settings
+		providerConfig := config.External.OAuthProvider(providerType)
+		if providerConfig == nil || !providerConfig.EmailOptional {
+			return internalServerError("Error getting user email from external provider")
+		}
 	}
 	userData.Metadata.EmailVerified = false
 	for _, email := range userData.Emails {
@@ -202,6 +358,9 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 		} else if err != nil {
 			return internalServerError("Failed to find flow state").WithInternalError(err)
 		}
+		if flowState.ProviderType != providerType || flowState.AuthenticationMethod != models.OAuth.String() {
+			return badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state (flow state of another provider)")
+		}
 
 	}
 
@@ -236,7 +395,7 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 		}
 
 		if terr != nil {
-			return oauthError("server_error", terr.Error())
+			return oauthError("server_error", ErrorCodeUnexpectedFailure, terr.Error())
 		}
 		return nil
 	})
@@ -276,7 +435,15 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 func (a *API) createAccountFromExternalIdentity(tx *storage.Connection, r *http.Request, userData *provider.UserProvidedData, providerType string) (*models.User, error) {
 	ctx := r.Context()
 	aud := a.requestAud(ctx, r)
-	config := a.config
+	config := a.getConfig(ctx)
+
+	// SSO providers and custom ID token issuers have no provider settings
+	providerConfig := config.External.OAuthProvider(providerType)
+	if providerConfig != nil {
+		if terr := restrictProviderEmails(providerConfig, providerType, userData); terr != nil {
+			return nil, terr
+		}
+	}
 
 	var user *models.User
 	var identity *models.Identity
@@ -311,6 +478,21 @@ func (a *API) createAccountFromExternalIdentity(tx *storage.Connection, r *http.
 			return nil, unprocessableEntityError(ErrorCodeSignupDisabled, "Signups not allowed for this instance")
 		}
 
+		if providerConfig != nil && !providerConfig.AllowSignup {
+			return nil, unprocessableEntityError(ErrorCodeSignupDisabled, "Signups with %v are not allowed, only existing users can sign in", providerType)
+		}
+
+		if terr := a.requireFeature(r.Context(), conf.FeatureSignup); terr != nil {
+			return nil, terr
+		}
+
+		// domains of SSO users are already restricted by the SSO provider
+		if !strings.HasPrefix(providerType, "sso:") && decision.CandidateEmail.Email != "" {
+			if terr := a.checkEmailDomain(decision.CandidateEmail.Email); terr != nil {
+				return nil, terr
+			}
+		}
+
 		params := &SignupParams{
 			Provider: providerType,
 			Email:    decision.CandidateEmail.Email,
@@ -331,7 +513,7 @@ func (a *API) createAccountFromExternalIdentity(tx *storage.Connection, r *http.
 			return nil, terr
 		}
 
-		if user, terr = a.signupNewUser(tx, user); terr != nil {
+		if user, terr = a.signupNewUser(r, tx, user); terr != nil {
 			return nil, terr
 		}
 
@@ -386,11 +568,11 @@ func (a *API) createAccountFromExternalIdentity(tx *storage.Connection, r *http.
 		} else {
 			emailConfirmationSent := false
 			if decision.CandidateEmail.Email != "" {
-				if terr = a.sendConfirmation(r, tx, user, models.ImplicitFlow); terr != nil {
+				if terr = a.handleEmailSendLimit(r, "external", a.sendConfirmation(r, tx, user, models.ImplicitFlow)); terr != nil {
 					if errors.Is(terr, MaxFrequencyLimitError) {
 						return nil, tooManyRequestsError(ErrorCodeOverEmailSendRateLimit, "For security purposes, you can only request this once every minute")
 					}
-					return nil, internalServerError("Error sending confirmation mail").WithInternalError(terr)
+					return nil, mailerError("Error sending confirmation mail", terr)
 				}
 				emailConfirmationSent = true
 			}
@@ -407,11 +589,43 @@ func (a *API) createAccountFromExternalIdentity(tx *storage.Connection, r *http.
 		}); terr != nil {
 			return nil, terr
 		}
+		a.notifyWebhook(r, tx, conf.WebhookLoginEvent, user)
 	}
 
 	return user, nil
 }
 
+// restrictProviderEmails removes the email addresses the provider may not
+// sign in with from the user data, leaving only verified addresses of the
+// provider's email domains. It fails when no address is left.
+func restrictProviderEmails(providerConfig *conf.OAuthProviderConfiguration, providerType string, userData *provider.UserProvidedData) error {
+	if len(providerConfig.EmailDomains) == 0 {
+		return nil
+	}
+
+	var emails []provider.Email
+	metadataEmailAllowed := false
+	for _, email := range userData.Emails {
+		if email.Verified && providerConfig.IsEmailDomainAllowed(email.Email) {
+			emails = append(emails, email)
+			if userData.Metadata != nil && email.Email == userData.Metadata.Email {
+				metadataEmailAllowed = true
+			}
+		}
+	}
+	if len(emails) == 0 {
+		return forbiddenError(ErrorCodeEmailDomainNotAllowed, "Sign ins with %v are restricted to verified email addresses of allowed domains", providerType)
+	}
+	userData.Emails = emails
+
+	if userData.Metadata != nil && !metadataEmailAllowed {
+		userData.Metadata.Email = emails[0].Email
+		userData.Metadata.EmailVerified = true
+	}
+
+	return nil
+}
+
 func (a *API) processInvite(r *http.Request, tx *storage.Connection, userData *provider.UserProvidedData, inviteToken, providerType string) (*models.User, error) {
 	user, err := models.FindUserByConfirmationToken(tx, inviteToken)
 	if err != nil {
@@ -478,7 +692,10 @@ func (a *API) processInvite(r *http.Request, tx *storage.Connection, userData *p
 	return user, nil
 }
 
-func (a *API) loadExternalState(ctx context.Context, state string) (context.Context, error) {
+// loadExternalState verifies the state of an OAuth callback. When the state
+// is bound to the browser's cookie, its nonce must be the one of the cookie.
+// When callbackProvider isn't empty, the state must have been issued for it.
+func (a *API) loadExternalState(ctx context.Context, state, nonce, callbackProvider string) (context.Context, error) {
 	config := a.config
 	claims := ExternalProviderClaims{}
 	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
@@ -486,11 +703,41 @@ func (a *API) loadExternalState(ctx context.Context, state string) (context.Cont
 		return []byte(config.JWT.Secret), nil
 	})
 	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth state has expired, please sign in again").WithInternalError(err)
+		}
 		return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state").WithInternalError(err)
 	}
 	if claims.Provider == "" {
 		return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state (missing provider)")
 	}
+	if claims.Nonce == "" {
+		return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state (missing nonce)")
+	}
+	if claims.CookieBound && subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state (started in another browser)")
+	}
+	switch claims.FlowType {
+	case models.PKCEFlow.String():
+		if claims.FlowStateID == "" {
+			return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state (missing flow state)")
+		}
+	case models.ImplicitFlow.String():
+		if claims.FlowStateID != "" {
+			return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state (unexpected flow state)")
+		}
+	default:
+		return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state (unknown flow type)")
+	}
+	if callbackProvider != "" && !strings.EqualFold(callbackProvider, claims.Provider) {
+		return nil, badRequestError(ErrorCodeBadOAuthState, "OAuth callback with invalid state (issued for another provider)")
+	}
+	if claims.Audience != "" {
+		if ctx, err = a.withInstanceConfig(ctx, claims.Audience); err != nil {
+			return nil, err
+		}
+	}
 	if claims.InviteToken != "" {
 		ctx = withInviteToken(ctx, claims.InviteToken)
 	}
@@ -520,7 +767,7 @@ func (a *API) loadExternalState(ctx context.Context, state string) (context.Cont
 
 // Provider returns a Provider interface for the given name.
 func (a *API) Provider(ctx context.Context, name string, scopes string) (provider.Provider, error) {
-	config := a.config
+	config := a.getConfig(ctx)
 	name = strings.ToLower(name)
 
 	switch name {
@@ -548,12 +795,16 @@ func (a *API) Provider(ctx context.Context, name string, scopes string) (provide
 		return provider.NewKakaoProvider(config.External.Kakao, scopes)
 	case "keycloak":
 		return provider.NewKeycloakProvider(config.External.Keycloak, scopes)
+	case "line":
+		return provider.NewLineProvider(config.External.Line, scopes)
 	case "linkedin":
 		return provider.NewLinkedinProvider(config.External.Linkedin, scopes)
 	case "linkedin_oidc":
 		return provider.NewLinkedinOIDCProvider(config.External.LinkedinOIDC, scopes)
 	case "notion":
 		return provider.NewNotionProvider(config.External.Notion)
+	case "oidc":
+		return provider.NewGenericOIDCProvider(ctx, config.External.OIDC, scopes)
 	case "spotify":
 		return provider.NewSpotifyProvider(config.External.Spotify, scopes)
 	case "slack":
@@ -566,6 +817,8 @@ func (a *API) Provider(ctx context.Context, name string, scopes string) (provide
 		return provider.NewTwitterProvider(config.External.Twitter, scopes)
 	case "workos":
 		return provider.NewWorkOSProvider(config.External.WorkOS)
+	case "x":
+		return provider.NewXProvider(config.External.X, scopes)
 	case "zoom":
 		return provider.NewZoomProvider(config.External.Zoom)
 	default:
@@ -593,12 +846,20 @@ func (a *API) redirectErrors(handler apiHandler, w http.ResponseWriter, r *http.
 		if q.Get("error_code") != "" {
 			hq.Set("error_code", q.Get("error_code"))
 		}
+		if q.Get("error_reason") != "" {
+			hq.Set("error_reason", q.Get("error_reason"))
+		}
 		u.Fragment = hq.Encode()
 		http.Redirect(w, r, u.String(), http.StatusFound)
 	}
 }
 
+// getErrorQueryString sets the error params of a redirect. error_code is the
+// HTTP status, as it has always been, and error_reason the error code
+// clients should handle the error by.
 func getErrorQueryString(err error, errorID string, log logrus.FieldLogger, q url.Values) *url.Values {
+	var status int
+	var reason ErrorCode
 	switch e := err.(type) {
 	case *HTTPError:
 		if e.ErrorCode == ErrorCodeSignupDisabled {
@@ -607,6 +868,8 @@ func getErrorQueryString(err error, errorID string, log logrus.FieldLogger, q ur
 			q.Set("error", "access_denied")
 		} else if e.ErrorCode == ErrorCodeProviderEmailNeedsVerification {
 			q.Set("error", "access_denied")
+		} else if e.ErrorCode == ErrorCodeEmailDomainNotAllowed {
+			q.Set("error", "access_denied")
 		} else if str, ok := oauthErrorMap[e.HTTPStatus]; ok {
 			q.Set("error", str)
 		} else {
@@ -620,10 +883,11 @@ func getErrorQueryString(err error, errorID string, log logrus.FieldLogger, q ur
 			log.WithError(e.Cause()).Info(e.Error())
 		}
 		q.Set("error_description", e.Message)
-		q.Set("error_code", strconv.Itoa(e.HTTPStatus))
+		status, reason = e.HTTPStatus, e.code()
 	case *OAuthError:
 		q.Set("error", e.Err)
 		q.Set("error_description", e.Description)
+		reason = e.ErrorCode
 		log.WithError(e.Cause()).Info(e.Error())
 	case ErrorCause:
 		return getErrorQueryString(e.Cause(), errorID, log, q)
@@ -641,12 +905,19 @@ func getErrorQueryString(err error, errorID string, log logrus.FieldLogger, q ur
 		q.Set("error", error_type)
 		q.Set("error_description", error_description)
 	}
+
+	if status != 0 {
+		q.Set("error_code", strconv.Itoa(status))
+	}
+	if reason != "" {
+		q.Set("error_reason", reason)
+	}
 	return &q
 }
 
 func (a *API) getExternalRedirectURL(r *http.Request) string {
 	ctx := r.Context()
-	config := a.config
+	config := a.getConfig(ctx)
 	if config.External.RedirectURL != "" {
 		return config.External.RedirectURL
 	}
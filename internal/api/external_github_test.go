@@ -168,6 +168,23 @@ func (ts *ExternalTestSuite) TestSignupExternalGitHubDisableSignupErrorWhenNoUse
 	assertAuthorizationFailure(ts, u, "Signups not allowed for this instance", "access_denied", "github@example.com")
 }
 
+func (ts *ExternalTestSuite) TestSignupExternalGitHubErrorWhenEmailDomainNotAllowed() {
+	ts.Config.Signup.EmailDomainAllowList = []string{"ourcompany.com"}
+	defer func() {
+		ts.Config.Signup.EmailDomainAllowList = nil
+	}()
+
+	tokenCount, userCount := 0, 0
+	code := "authcode"
+	emails := `[{"email":"github@example.com", "primary": true, "verified": true}]`
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, code, emails)
+	defer server.Close()
+
+	u := performAuthorization(ts, "github", code, "")
+
+	assertAuthorizationFailure(ts, u, "Signups from this email domain are not allowed", "access_denied", "github@example.com")
+}
+
 func (ts *ExternalTestSuite) TestSignupExternalGitHubDisableSignupErrorWhenEmptyEmail() {
 	ts.Config.DisableSignup = true
 	tokenCount, userCount := 0, 0
@@ -213,6 +230,63 @@ func (ts *ExternalTestSuite) TestSignupExternalGitHubDisableSignupSuccessWithNon
 	assertAuthorizationSuccess(ts, u, tokenCount, userCount, "secondary@example.com", "GitHub Test", "123", "http://example.com/avatar")
 }
 
+func (ts *ExternalTestSuite) TestSignupExternalGitHubProviderSignupDisabled() {
+	ts.Config.External.Github.AllowSignup = false
+	defer func() {
+		ts.Config.External.Github.AllowSignup = true
+	}()
+
+	tokenCount, userCount := 0, 0
+	code := "authcode"
+	emails := `[{"email":"github@example.com", "primary": true, "verified": true}]`
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, code, emails)
+	defer server.Close()
+
+	u := performAuthorization(ts, "github", code, "")
+	assertAuthorizationFailure(ts, u, "Signups with github are not allowed, only existing users can sign in", "access_denied", "github@example.com")
+
+	v, err := url.ParseQuery(u.RawQuery)
+	ts.Require().NoError(err)
+	ts.Require().Equal(string(ErrorCodeSignupDisabled), v.Get("error_reason"))
+
+	// existing users still sign in
+	ts.createUser("123", "github@example.com", "GitHub Test", "http://example.com/avatar", "")
+
+	tokenCount, userCount = 0, 0
+	u = performAuthorization(ts, "github", code, "")
+	assertAuthorizationSuccess(ts, u, tokenCount, userCount, "github@example.com", "GitHub Test", "123", "http://example.com/avatar")
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalGitHubProviderEmailDomains() {
+	ts.Config.External.Github.EmailDomains = []string{"ourcompany.com"}
+	defer func() {
+		ts.Config.External.Github.EmailDomains = nil
+	}()
+
+	tokenCount, userCount := 0, 0
+	code := "authcode"
+
+	// unverified addresses of allowed domains don't count
+	emails := `[{"email":"github@example.com", "primary": true, "verified": true},{"email":"github@ourcompany.com", "primary": false, "verified": false}]`
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, code, emails)
+
+	u := performAuthorization(ts, "github", code, "")
+	assertAuthorizationFailure(ts, u, "Sign ins with github are restricted to verified email addresses of allowed domains", "access_denied", "github@example.com")
+	server.Close()
+
+	// the user is created with the address of the allowed domain
+	emails = `[{"email":"github@example.com", "primary": true, "verified": true},{"email":"github@ourcompany.com", "primary": false, "verified": true}]`
+	tokenCount, userCount = 0, 0
+	server = GitHubTestSignupSetup(ts, &tokenCount, &userCount, code, emails)
+	defer server.Close()
+
+	u = performAuthorization(ts, "github", code, "")
+	assertAuthorizationSuccess(ts, u, tokenCount, userCount, "github@ourcompany.com", "GitHub Test", "123", "http://example.com/avatar")
+
+	_, err := models.FindUserByEmailAndAudience(ts.API.db, "github@example.com", ts.Config.JWT.Aud)
+	ts.Require().True(models.IsNotFoundError(err))
+}
+
 func (ts *ExternalTestSuite) TestInviteTokenExternalGitHubSuccessWhenMatchingToken() {
 	// name and avatar should be populated from GitHub API
 	ts.createUser("123", "github@example.com", "", "", "invite_token")
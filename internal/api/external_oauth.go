@@ -10,8 +10,13 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/api/provider"
 	"github.com/supabase/auth/internal/observability"
+	"golang.org/x/oauth2"
 )
 
+// cancelledAtProviderMessage describes an access_denied error of a provider,
+// which is how users cancelling the sign in are reported.
+const cancelledAtProviderMessage = "The sign in was cancelled at the provider"
+
 // OAuthProviderData contains the userData and token returned by the oauth provider
 type OAuthProviderData struct {
 	userData     *provider.UserProvidedData
@@ -21,7 +26,10 @@ type OAuthProviderData struct {
 }
 
 // loadFlowState parses the `state` query parameter as a JWS payload,
-// extracting the provider requested
+// extracting the provider requested. Callbacks can name their provider with
+// the `provider` query parameter, for example by configuring the redirect URI
+// of GitHub as /callback?provider=github, to refuse states issued for other
+// providers.
 func (a *API) loadFlowState(w http.ResponseWriter, r *http.Request) (context.Context, error) {
 	var state string
 	if r.Method == http.MethodPost {
@@ -43,10 +51,16 @@ func (a *API) loadFlowState(w http.ResponseWriter, r *http.Request) (context.Con
 	if oauthVerifier != "" {
 		ctx = withOAuthVerifier(ctx, oauthVerifier)
 	}
-	return a.loadExternalState(ctx, state)
+	var nonce string
+	if cookie, err := r.Cookie(oauthNonceCookie(a.config)); err == nil {
+		nonce = cookie.Value
+	}
+	return a.loadExternalState(ctx, state, nonce, r.URL.Query().Get("provider"))
 }
 
-func (a *API) oAuthCallback(ctx context.Context, r *http.Request, providerType string) (*OAuthProviderData, error) {
+// providerCallbackError returns the error a provider redirected back with,
+// such as access_denied when the user cancelled the sign in.
+func providerCallbackError(r *http.Request) error {
 	var rq url.Values
 	if err := r.ParseForm(); r.Method == http.MethodPost && err == nil {
 		rq = r.Form
@@ -54,9 +68,28 @@ func (a *API) oAuthCallback(ctx context.Context, r *http.Request, providerType s
 		rq = r.URL.Query()
 	}
 
-	extError := rq.Get("error")
-	if extError != "" {
-		return nil, oauthError(extError, rq.Get("error_description"))
+	if extError := rq.Get("error"); extError != "" {
+		description := rq.Get("error_description")
+		if extError == "access_denied" && description == "" {
+			description = cancelledAtProviderMessage
+		}
+		return oauthError(extError, ErrorCodeBadOAuthCallback, description)
+	}
+
+	// OAuth 1.0 providers report cancelled sign ins with denied instead
+	if rq.Get("denied") != "" {
+		return oauthError("access_denied", ErrorCodeBadOAuthCallback, cancelledAtProviderMessage)
+	}
+
+	return nil
+}
+
+func (a *API) oAuthCallback(ctx context.Context, r *http.Request, providerType string) (*OAuthProviderData, error) {
+	var rq url.Values
+	if err := r.ParseForm(); r.Method == http.MethodPost && err == nil {
+		rq = r.Form
+	} else {
+		rq = r.URL.Query()
 	}
 
 	oauthCode := rq.Get("code")
@@ -75,7 +108,12 @@ func (a *API) oAuthCallback(ctx context.Context, r *http.Request, providerType s
 		"code":     oauthCode,
 	}).Debug("Exchanging oauth code")
 
-	token, err := oAuthProvider.GetOAuthToken(oauthCode)
+	var token *oauth2.Token
+	if pkceProvider, ok := oAuthProvider.(provider.PKCEOAuthProvider); ok {
+		token, err = pkceProvider.GetOAuthTokenWithVerifier(oauthCode, providerCodeVerifier(a.getConfig(ctx), getSignature(ctx)))
+	} else {
+		token, err = oAuthProvider.GetOAuthToken(oauthCode)
+	}
 	if err != nil {
 		return nil, internalServerError("Unable to exchange external code: %s", oauthCode).WithInternalError(err)
 	}
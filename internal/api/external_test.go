@@ -1,11 +1,17 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	jwt "github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/supabase/auth/internal/conf"
@@ -84,6 +90,15 @@ func performAuthorizationRequest(ts *ExternalTestSuite, provider string, inviteT
 	return w
 }
 
+// withCookies adds the cookies set in the response to the request, as
+// browsers do.
+func withCookies(req *http.Request, w *httptest.ResponseRecorder) *http.Request {
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
 func performPKCEAuthorizationRequest(ts *ExternalTestSuite, provider, codeChallenge, codeChallengeMethod string) *httptest.ResponseRecorder {
 	authorizeURL := "http://localhost/authorize?provider=" + provider
 	if codeChallenge != "" {
@@ -112,7 +127,7 @@ func performPKCEAuthorization(ts *ExternalTestSuite, provider, code, codeChallen
 	v.Set("state", state)
 	testURL.RawQuery = v.Encode()
 	// Use the code to get a token
-	req := httptest.NewRequest(http.MethodGet, testURL.String(), nil)
+	req := withCookies(httptest.NewRequest(http.MethodGet, testURL.String(), nil), w)
 	w = httptest.NewRecorder()
 	ts.API.handler.ServeHTTP(w, req)
 	ts.Require().Equal(http.StatusFound, w.Code)
@@ -138,7 +153,7 @@ func performAuthorization(ts *ExternalTestSuite, provider string, code string, i
 	v.Set("code", code)
 	v.Set("state", state)
 	testURL.RawQuery = v.Encode()
-	req := httptest.NewRequest(http.MethodGet, testURL.String(), nil)
+	req := withCookies(httptest.NewRequest(http.MethodGet, testURL.String(), nil), w)
 	w = httptest.NewRecorder()
 	ts.API.handler.ServeHTTP(w, req)
 	ts.Require().Equal(http.StatusFound, w.Code)
@@ -252,3 +267,188 @@ func (ts *ExternalTestSuite) TestRedirectErrorsShouldPreserveParams() {
 		}
 	}
 }
+
+// authorizeState starts an OAuth flow with the provider and returns its state
+// and the response, which sets the nonce cookie.
+func (ts *ExternalTestSuite) authorizeState(provider string) (string, *httptest.ResponseRecorder) {
+	w := performAuthorizationRequest(ts, provider, "")
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err)
+	return u.Query().Get("state"), w
+}
+
+// callbackError performs the callback in the browser the authorization
+// response went to and returns the URL it redirected to, which must carry an
+// error.
+func (ts *ExternalTestSuite) callbackError(query url.Values, authorization *httptest.ResponseRecorder) *url.URL {
+	req := withCookies(httptest.NewRequest(http.MethodGet, "http://localhost/callback?"+query.Encode(), nil), authorization)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err)
+	ts.Require().NotEmpty(u.Query().Get("error"))
+	return u
+}
+
+func (ts *ExternalTestSuite) TestOAuthStateClaims() {
+	state, w := ts.authorizeState("github")
+
+	claims := ExternalProviderClaims{}
+	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
+	_, err := p.ParseWithClaims(state, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	ts.Require().NoError(err)
+	ts.Equal("github", claims.Provider)
+	ts.Equal("https://example.netlify.com/admin", claims.Referrer)
+	ts.Equal(models.ImplicitFlow.String(), claims.FlowType)
+	ts.NotEmpty(claims.Nonce)
+	ts.True(claims.CookieBound)
+	ts.Equal(int64(ts.Config.External.StateExpiryDuration.Seconds()), claims.ExpiresAt-claims.IssuedAt)
+
+	// the nonce is bound to the browser
+	cookies := w.Result().Cookies()
+	ts.Require().Len(cookies, 1)
+	ts.Equal(oauthNonceCookie(ts.Config), cookies[0].Name)
+	ts.Equal(claims.Nonce, cookies[0].Value)
+	ts.True(cookies[0].HttpOnly)
+	ts.True(cookies[0].Secure)
+
+	other, _ := ts.authorizeState("github")
+	ts.NotEqual(state, other)
+}
+
+func (ts *ExternalTestSuite) TestOAuthStateTampered() {
+	state, w := ts.authorizeState("github")
+
+	parts := strings.Split(state, ".")
+	ts.Require().Len(parts, 3)
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	ts.Require().NoError(err)
+	payload = bytes.Replace(payload, []byte("https://example.netlify.com/admin"), []byte("https://evil.example.com/admin"), 1)
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+
+	for _, tampered := range []string{strings.Join(parts, "."), "not-a-jwt"} {
+		u := ts.callbackError(url.Values{"code": {"code"}, "state": {tampered}}, w)
+
+		// the error goes to the site URL, as the state's redirect URL isn't allowed
+		ts.Equal("example.netlify.com", u.Host)
+		ts.Equal("", u.Path)
+		ts.Equal("invalid_request", u.Query().Get("error"))
+		ts.Equal("OAuth callback with invalid state", u.Query().Get("error_description"))
+	}
+}
+
+func (ts *ExternalTestSuite) TestOAuthStateExpired() {
+	ts.API.overrideTime = func() time.Time {
+		return time.Now().Add(-ts.Config.External.StateExpiryDuration - time.Minute)
+	}
+	state, w := ts.authorizeState("github")
+	ts.API.overrideTime = nil
+
+	// the error goes to the state's redirect URL, as it's allowed
+	u := ts.callbackError(url.Values{"code": {"code"}, "state": {state}}, w)
+	ts.Equal("example.netlify.com", u.Host)
+	ts.Equal("/admin", u.Path)
+	ts.Equal("invalid_request", u.Query().Get("error"))
+	ts.Equal("OAuth state has expired, please sign in again", u.Query().Get("error_description"))
+}
+
+func (ts *ExternalTestSuite) TestOAuthStateOtherBrowser() {
+	state, _ := ts.authorizeState("github")
+	_, other := ts.authorizeState("github")
+
+	for _, w := range []*httptest.ResponseRecorder{other, httptest.NewRecorder()} {
+		u := ts.callbackError(url.Values{"code": {"code"}, "state": {state}}, w)
+		ts.Equal("/admin", u.Path)
+		ts.Equal("OAuth callback with invalid state (started in another browser)", u.Query().Get("error_description"))
+	}
+}
+
+func (ts *ExternalTestSuite) TestOAuthStateWithoutCookie() {
+	// browsers don't keep secure cookies over plain HTTP
+	req := httptest.NewRequest(http.MethodGet, "http://auth.example.com/authorize?provider=github", nil)
+	req.Header.Set("Referer", "https://example.netlify.com/admin")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	ts.Empty(w.Result().Cookies())
+
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err)
+	state := authURL.Query().Get("state")
+
+	// the callback is completed without the cookie
+	_, err = ts.API.loadExternalState(context.Background(), state, "", "github")
+	ts.NoError(err)
+}
+
+func (ts *ExternalTestSuite) TestOAuthStateFlowType() {
+	state, w := ts.authorizeState("github")
+
+	claims := ExternalProviderClaims{}
+	_, err := jwt.ParseWithClaims(state, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	ts.Require().NoError(err)
+
+	// an implicit flow can't be turned into a PKCE one
+	claims.FlowType = models.PKCEFlow.String()
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(ts.Config.JWT.Secret))
+	ts.Require().NoError(err)
+
+	u := ts.callbackError(url.Values{"code": {"code"}, "state": {forged}}, w)
+	ts.Equal("OAuth callback with invalid state (missing flow state)", u.Query().Get("error_description"))
+}
+
+func (ts *ExternalTestSuite) TestOAuthStateProviderMismatch() {
+	state, w := ts.authorizeState("github")
+
+	u := ts.callbackError(url.Values{"code": {"code"}, "state": {state}, "provider": {"gitlab"}}, w)
+	ts.Equal("example.netlify.com", u.Host)
+	ts.Equal("OAuth callback with invalid state (issued for another provider)", u.Query().Get("error_description"))
+}
+
+func (ts *ExternalTestSuite) TestOAuthCancelledAtProvider() {
+	state, w := ts.authorizeState("github")
+
+	u := ts.callbackError(url.Values{"error": {"access_denied"}, "state": {state}, "provider": {"github"}}, w)
+	ts.Equal("/admin", u.Path)
+	ts.Equal("access_denied", u.Query().Get("error"))
+	ts.Equal(cancelledAtProviderMessage, u.Query().Get("error_description"))
+
+	// OAuth 1.0 providers send denied instead
+	state, w = ts.authorizeState("github")
+	u = ts.callbackError(url.Values{"denied": {"token"}, "state": {state}}, w)
+	ts.Equal("access_denied", u.Query().Get("error"))
+
+	users, err := models.FindUsersInAudience(ts.API.db, ts.Config.JWT.Aud, nil, nil, nil)
+	ts.Require().NoError(err)
+	ts.Empty(users)
+}
+
+func TestIsSecureRequest(t *testing.T) {
+	config := &conf.GlobalConfiguration{}
+	config.API.ExternalURL = "https://auth.example.com"
+
+	for host, expected := range map[string]bool{
+		"https://auth.example.com": true,
+		"http://auth.example.com":  false,
+		"http://localhost:9999":    true,
+		"http://app.localhost":     true,
+		"http://127.0.0.1:9999":    true,
+		"http://[::1]:9999":        true,
+	} {
+		u, err := url.Parse(host)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/authorize", nil)
+		req = req.WithContext(withExternalHost(req.Context(), u))
+		require.Equal(t, expected, isSecureRequest(req, config), host)
+	}
+
+	// without an external host, the external URL is used
+	require.True(t, isSecureRequest(httptest.NewRequest(http.MethodGet, "/authorize", nil), config))
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/supabase/auth/internal/models"
+)
+
+const (
+	xUser        string = `{"data":{"id":"xTestId","name":"X Test","username":"xtest","profile_image_url":"https://pbs.twimg.com/profile_images/1/avatar_normal.jpg","confirmed_email":"x@example.com"}}`
+	xUserNoEmail string = `{"data":{"id":"xTestId","name":"X Test","username":"xtest","profile_image_url":"https://pbs.twimg.com/profile_images/1/avatar_normal.jpg"}}`
+)
+
+func (ts *ExternalTestSuite) TestSignupExternalX() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/authorize?provider=x", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	q := u.Query()
+	ts.Equal(ts.Config.External.X.RedirectURI, q.Get("redirect_uri"))
+	ts.Equal(ts.Config.External.X.ClientID, []string{q.Get("client_id")})
+	ts.Equal("code", q.Get("response_type"))
+	ts.Equal("tweet.read users.read users.email", q.Get("scope"))
+	ts.Equal("S256", q.Get("code_challenge_method"))
+	ts.NotEmpty(q.Get("code_challenge"))
+
+	claims := ExternalProviderClaims{}
+	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
+	_, err = p.ParseWithClaims(q.Get("state"), &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.Config.JWT.Secret), nil
+	})
+	ts.Require().NoError(err)
+
+	ts.Equal("x", claims.Provider)
+	ts.Equal(ts.Config.SiteURL, claims.SiteURL)
+}
+
+// XTestSignupSetup starts a fake X, which checks that the code verifier of the
+// token request matches the code challenge of the authorization request.
+func XTestSignupSetup(ts *ExternalTestSuite, tokenCount *int, userCount *int, code string, challenge *string, user string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2/oauth2/token":
+			*tokenCount++
+			ts.Equal(code, r.FormValue("code"))
+			ts.Equal("authorization_code", r.FormValue("grant_type"))
+			ts.Equal(ts.Config.External.X.RedirectURI, r.FormValue("redirect_uri"))
+
+			sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			ts.Equal(*challenge, base64.RawURLEncoding.EncodeToString(sum[:]))
+
+			w.Header().Add("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token":"x_token","token_type":"bearer","expires_in":7200}`)
+		case "/2/users/me":
+			*userCount++
+			ts.Equal("Bearer x_token", r.Header.Get("Authorization"))
+			w.Header().Add("Content-Type", "application/json")
+			fmt.Fprint(w, user)
+		default:
+			w.WriteHeader(500)
+			ts.Fail("unknown X oauth call %s", r.URL.Path)
+		}
+	}))
+
+	ts.Config.External.X.URL = server.URL
+
+	return server
+}
+
+// performXAuthorization signs in with X like performAuthorization, and
+// keeps the code challenge sent to X.
+func performXAuthorization(ts *ExternalTestSuite, code string, challenge *string) *url.URL {
+	w := performAuthorizationRequest(ts, "x", "")
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	*challenge = u.Query().Get("code_challenge")
+
+	v := url.Values{}
+	v.Set("code", code)
+	v.Set("state", u.Query().Get("state"))
+	req := withCookies(httptest.NewRequest(http.MethodGet, "http://localhost/callback?"+v.Encode(), nil), w)
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err = url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	ts.Require().Equal("/admin", u.Path)
+
+	return u
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalX_AuthorizationCode() {
+	ts.Config.DisableSignup = false
+	tokenCount, userCount := 0, 0
+	code := "authcode"
+	var challenge string
+	server := XTestSignupSetup(ts, &tokenCount, &userCount, code, &challenge, xUser)
+	defer server.Close()
+
+	u := performXAuthorization(ts, code, &challenge)
+
+	assertAuthorizationSuccess(ts, u, tokenCount, userCount, "x@example.com", "X Test", "xTestId", "https://pbs.twimg.com/profile_images/1/avatar_400x400.jpg")
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalXWithoutEmail() {
+	ts.Config.DisableSignup = false
+	tokenCount, userCount := 0, 0
+	code := "authcode"
+	var challenge string
+	server := XTestSignupSetup(ts, &tokenCount, &userCount, code, &challenge, xUserNoEmail)
+	defer server.Close()
+
+	u := performXAuthorization(ts, code, &challenge)
+	assertAuthorizationFailure(ts, u, "Error getting user email from external provider", "server_error", "")
+
+	ts.Config.External.X.EmailOptional = true
+	defer func() {
+		ts.Config.External.X.EmailOptional = false
+	}()
+
+	u = performXAuthorization(ts, code, &challenge)
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.NotEmpty(v.Get("access_token"))
+
+	// the user can add an email address later
+	identity, err := models.FindIdentityByIdAndProvider(ts.API.db, "xTestId", "x")
+	ts.Require().NoError(err)
+	user, err := models.FindUserByID(ts.API.db, identity.UserID)
+	ts.Require().NoError(err)
+	ts.Empty(user.GetEmail())
+	ts.Equal("xtest", user.UserMetaData["user_name"])
+}
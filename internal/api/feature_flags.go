@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+	"golang.org/x/sync/singleflight"
+)
+
+// featureFlagsCache holds the feature flags, so that every request doesn't
+// query the database for them.
+type featureFlagsCache struct {
+	mu       sync.Mutex
+	flags    *conf.FeatureFlags
+	loadedAt time.Time
+
+	// loads makes concurrent requests share a load of the flags
+	loads singleflight.Group
+}
+
+func (c *featureFlagsCache) get(now time.Time, ttl time.Duration) (*conf.FeatureFlags, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flags, c.flags != nil && now.Sub(c.loadedAt) < ttl
+}
+
+func (c *featureFlagsCache) set(flags *conf.FeatureFlags, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flags = flags
+	c.loadedAt = now
+}
+
+// featureFlags returns the feature flags set through the admin API, or the
+// configured defaults if they were never set. While the flags can't be
+// loaded, the last loaded ones are used, or else the configured defaults.
+func (a *API) featureFlags(ctx context.Context) *conf.FeatureFlags {
+	cache := &a.featureFlagsCache
+
+	flags, fresh := cache.get(a.Now(), a.config.FeatureFlags.CacheTTL)
+	if fresh {
+		return flags
+	}
+
+	loaded, err, _ := cache.loads.Do("", func() (interface{}, error) {
+		settings, err := models.FindFeatureFlags(a.db.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		flags := a.effectiveFeatureFlags(settings)
+		cache.set(flags, a.Now())
+		return flags, nil
+	})
+	if err != nil {
+		if flags == nil {
+			logrus.WithError(err).Warn("Unable to load feature flags, using the configured defaults")
+			return a.effectiveFeatureFlags(nil)
+		}
+		logrus.WithError(err).Warn("Unable to load feature flags, using the last loaded ones")
+		return flags
+	}
+
+	return loaded.(*conf.FeatureFlags)
+}
+
+func (a *API) effectiveFeatureFlags(settings *models.FeatureFlagSettings) *conf.FeatureFlags {
+	flags := a.config.FeatureFlags.FeatureFlags
+	if settings != nil {
+		flags = conf.FeatureFlags(settings.Flags)
+	}
+	// the flags are changed by adminFeatureFlagsUpdate, which mustn't
+	// change the configuration
+	flags.DisabledProviders = slices.Clone(flags.DisabledProviders)
+	if flags.DisabledProviders == nil {
+		flags.DisabledProviders = []string{}
+	}
+
+	return &flags
+}
+
+// invalidateFeatureFlags drops the cached feature flags.
+func (a *API) invalidateFeatureFlags() {
+	cache := &a.featureFlagsCache
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.loadedAt = time.Time{}
+}
+
+func featureDisabledError(feature conf.Feature) *HTTPError {
+	return httpError(http.StatusServiceUnavailable, ErrorCodeFeatureDisabled, "This feature is temporarily disabled").WithInternalMessage("feature %s is disabled", feature)
+}
+
+// isFeatureDisabledError reports whether err, which may be wrapped, refuses
+// a disabled feature.
+func isFeatureDisabledError(err error) bool {
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && httpErr.ErrorCode == ErrorCodeFeatureDisabled
+}
+
+// requireFeature returns an error if the feature flags disable the feature.
+func (a *API) requireFeature(ctx context.Context, feature conf.Feature) error {
+	if a.featureFlags(ctx).IsDisabled(feature) {
+		return featureDisabledError(feature)
+	}
+
+	return nil
+}
+
+// isServedInMaintenance reports whether the request is served in
+// maintenance mode: reads, token refreshes, introspection and logouts keep
+// existing sessions working, and admin requests let admins end the
+// maintenance. Links from emails and sign ins with external providers are
+// not served, although they're GET requests.
+func isServedInMaintenance(r *http.Request) bool {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch path {
+	case "/verify", "/authorize", "/user/identities/authorize":
+		return false
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	switch {
+	case path == "/admin" || strings.HasPrefix(path, "/admin/"):
+		return true
+	case path == "/logout", path == "/token/revoke", path == "/token/introspect":
+		return true
+	case strings.HasPrefix(path, "/sessions/"):
+		return r.Method == http.MethodDelete
+	case path == "/token":
+		return r.FormValue("grant_type") == "refresh_token"
+	}
+
+	return false
+}
+
+// requireNotInMaintenance refuses the requests that aren't served in
+// maintenance mode.
+func (a *API) requireNotInMaintenance(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	if isServedInMaintenance(r) {
+		return ctx, nil
+	}
+
+	if a.featureFlags(ctx).Maintenance {
+		return nil, httpError(http.StatusServiceUnavailable, ErrorCodeFeatureDisabled, "The service is in maintenance, please try again later")
+	}
+
+	return ctx, nil
+}
+
+// FeatureFlagsResponse is the response of the feature flag endpoints.
+// UpdatedAt is missing while the configured defaults are used.
+type FeatureFlagsResponse struct {
+	conf.FeatureFlags
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+func (a *API) newFeatureFlagsResponse(settings *models.FeatureFlagSettings) *FeatureFlagsResponse {
+	resp := &FeatureFlagsResponse{
+		FeatureFlags: *a.effectiveFeatureFlags(settings),
+	}
+	if settings != nil {
+		resp.UpdatedAt = &settings.UpdatedAt
+	}
+
+	return resp
+}
+
+// adminFeatureFlagsGet returns the current feature flags.
+func (a *API) adminFeatureFlagsGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	settings, err := models.FindFeatureFlags(db)
+	if err != nil {
+		return internalServerError("Database error loading feature flags").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, a.newFeatureFlagsResponse(settings))
+}
+
+// adminFeatureFlagsUpdate changes the feature flags. Flags left out of the
+// request keep their current value.
+func (a *API) adminFeatureFlagsUpdate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	var settings *models.FeatureFlagSettings
+	err := db.Transaction(func(tx *storage.Connection) error {
+		current, terr := models.FindFeatureFlags(tx)
+		if terr != nil {
+			return internalServerError("Database error loading feature flags").WithInternalError(terr)
+		}
+
+		previous := a.effectiveFeatureFlags(current)
+		flags := a.effectiveFeatureFlags(current)
+		if terr := retrieveRequestParams(r, flags); terr != nil {
+			return terr
+		}
+
+		for i, name := range flags.DisabledProviders {
+			flags.DisabledProviders[i] = strings.ToLower(name)
+		}
+		if terr := flags.Validate(); terr != nil {
+			return badRequestError(ErrorCodeValidationFailed, "Invalid feature flags: %v", terr)
+		}
+
+		if settings, terr = models.SaveFeatureFlags(tx, *flags); terr != nil {
+			return internalServerError("Database error saving feature flags").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.FeatureFlagsUpdatedAction, "", map[string]interface{}{
+			"previous": previous,
+			"flags":    flags,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	a.invalidateFeatureFlags()
+
+	return sendJSON(w, http.StatusOK, a.newFeatureFlagsResponse(settings))
+}
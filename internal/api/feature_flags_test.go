@@ -0,0 +1,285 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+func TestFeatureFlagsWithoutDatabase(t *testing.T) {
+	api, _, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, _ *storage.Connection) {
+		if config != nil {
+			config.DB.URL = "postgres://localhost:1/unavailable?connect_timeout=1"
+			config.FeatureFlags.DisableEmail = true
+		}
+	})
+	require.NoError(t, err)
+	defer api.db.Close()
+
+	// until the flags were loaded, the configured defaults are used
+	flags := api.featureFlags(context.Background())
+	require.True(t, flags.DisableEmail)
+	require.False(t, flags.Maintenance)
+
+	// afterwards the last loaded flags are used
+	api.featureFlagsCache.set(&conf.FeatureFlags{Maintenance: true, DisabledProviders: []string{}}, time.Now())
+	api.invalidateFeatureFlags()
+
+	flags = api.featureFlags(context.Background())
+	require.True(t, flags.Maintenance)
+	require.False(t, flags.DisableEmail)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/signup", strings.NewReader(`{"email":"test@example.com","password":"password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code, w.Body.String())
+}
+
+type FeatureFlagsTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	now          time.Time
+	adminToken   string
+	refreshToken *models.RefreshToken
+}
+
+func TestFeatureFlags(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &FeatureFlagsTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *FeatureFlagsTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+	ts.API.invalidateFeatureFlags()
+
+	ts.now = time.Now()
+	ts.API.overrideTime = func() time.Time {
+		return ts.now
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+	ts.adminToken = token
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	ts.refreshToken, err = models.GrantAuthenticatedUser(ts.API.db, u, models.GrantParams{})
+	require.NoError(ts.T(), err)
+}
+
+func (ts *FeatureFlagsTestSuite) TearDownTest() {
+	ts.API.overrideTime = nil
+	ts.API.invalidateFeatureFlags()
+}
+
+func (ts *FeatureFlagsTestSuite) request(method, path string, body map[string]interface{}, token string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if body != nil {
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *FeatureFlagsTestSuite) updateFlags(flags map[string]interface{}) *FeatureFlagsResponse {
+	w := ts.request(http.MethodPut, "/admin/settings/flags", flags, ts.adminToken)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := &FeatureFlagsResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	return resp
+}
+
+func (ts *FeatureFlagsTestSuite) signup(email string) *httptest.ResponseRecorder {
+	return ts.request(http.MethodPost, "/signup", map[string]interface{}{
+		"email":    email,
+		"password": "test123",
+	}, "")
+}
+
+// refresh exchanges the current refresh token and keeps the new one.
+func (ts *FeatureFlagsTestSuite) refresh() {
+	w := ts.request(http.MethodPost, "/token?grant_type=refresh_token", map[string]interface{}{
+		"refresh_token": ts.refreshToken.Token,
+	}, "")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	ts.refreshToken = &models.RefreshToken{Token: resp.RefreshToken}
+}
+
+func (ts *FeatureFlagsTestSuite) requireFeatureDisabled(w *httptest.ResponseRecorder) {
+	require.Equal(ts.T(), http.StatusServiceUnavailable, w.Code, w.Body.String())
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeFeatureDisabled, data.ErrorCode)
+}
+
+func (ts *FeatureFlagsTestSuite) TestDefaults() {
+	w := ts.request(http.MethodGet, "/admin/settings/flags", nil, ts.adminToken)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := &FeatureFlagsResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	require.Equal(ts.T(), ts.Config.FeatureFlags.FeatureFlags.Maintenance, resp.Maintenance)
+	require.Empty(ts.T(), resp.DisabledProviders)
+	require.Nil(ts.T(), resp.UpdatedAt)
+
+	w = ts.request(http.MethodGet, "/admin/settings/flags", nil, "")
+	require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+}
+
+func (ts *FeatureFlagsTestSuite) TestDisableSignup() {
+	w := ts.signup("first@example.com")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := ts.updateFlags(map[string]interface{}{
+		"disable_signup": true,
+	})
+	require.True(ts.T(), resp.DisableSignup)
+	require.False(ts.T(), resp.DisablePasswordLogin)
+	require.NotNil(ts.T(), resp.UpdatedAt)
+
+	ts.requireFeatureDisabled(ts.signup("second@example.com"))
+
+	// existing sessions keep working and users can still sign in
+	ts.refresh()
+	w = ts.request(http.MethodPost, "/token?grant_type=password", map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "password",
+	}, "")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// flags left out keep their value
+	resp = ts.updateFlags(map[string]interface{}{
+		"disable_password_login": true,
+	})
+	require.True(ts.T(), resp.DisableSignup)
+	require.True(ts.T(), resp.DisablePasswordLogin)
+
+	w = ts.request(http.MethodPost, "/token?grant_type=password", map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "password",
+	}, "")
+	ts.requireFeatureDisabled(w)
+	ts.refresh()
+
+	entries, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Action: models.FeatureFlagsUpdatedAction}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), entries, 2)
+	require.Equal(ts.T(), "supabase_admin", entries[0].Payload["actor_username"])
+}
+
+func (ts *FeatureFlagsTestSuite) TestOtherServerChange() {
+	w := ts.signup("first@example.com")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// another server disables signups, this one keeps its cached flags
+	// until they expire
+	_, err := models.SaveFeatureFlags(ts.API.db, conf.FeatureFlags{DisableSignup: true})
+	require.NoError(ts.T(), err)
+
+	w = ts.signup("second@example.com")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	ts.now = ts.now.Add(ts.Config.FeatureFlags.CacheTTL)
+	ts.requireFeatureDisabled(ts.signup("third@example.com"))
+	ts.refresh()
+}
+
+func (ts *FeatureFlagsTestSuite) TestDisableEmail() {
+	ts.updateFlags(map[string]interface{}{
+		"disable_email": true,
+	})
+
+	ts.requireFeatureDisabled(ts.request(http.MethodPost, "/recover", map[string]interface{}{
+		"email": "test@example.com",
+	}, ""))
+}
+
+func (ts *FeatureFlagsTestSuite) TestDisableProvider() {
+	ts.Config.External.Github.Enabled = true
+	defer func() {
+		ts.Config.External.Github.Enabled = false
+	}()
+
+	w := ts.request(http.MethodPut, "/admin/settings/flags", map[string]interface{}{
+		"disabled_providers": []string{"unknown"},
+	}, ts.adminToken)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+
+	resp := ts.updateFlags(map[string]interface{}{
+		"disabled_providers": []string{"GitHub"},
+	})
+	require.Equal(ts.T(), []string{"github"}, resp.DisabledProviders)
+
+	ts.requireFeatureDisabled(ts.request(http.MethodGet, "/authorize?provider=github", nil, ""))
+}
+
+func (ts *FeatureFlagsTestSuite) TestMaintenance() {
+	ts.updateFlags(map[string]interface{}{
+		"maintenance": true,
+	})
+
+	ts.requireFeatureDisabled(ts.signup("first@example.com"))
+	ts.requireFeatureDisabled(ts.request(http.MethodPost, "/otp", map[string]interface{}{
+		"email": "test@example.com",
+	}, ""))
+
+	// email links sign in with GET requests
+	ts.requireFeatureDisabled(ts.request(http.MethodGet, "/verify?type=signup&token=token&redirect_to=https://example.com", nil, ""))
+
+	w := ts.request(http.MethodGet, "/settings", nil, "")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	w = ts.request(http.MethodGet, "/health", nil, "")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	ts.refresh()
+
+	// admins can end the maintenance
+	resp := ts.updateFlags(map[string]interface{}{
+		"maintenance": false,
+	})
+	require.False(ts.T(), resp.Maintenance)
+
+	w = ts.signup("first@example.com")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+}
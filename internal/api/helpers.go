@@ -40,6 +40,11 @@ func (a *API) requestAud(ctx context.Context, r *http.Request) string {
 		return claims.Audience
 	}
 
+	// Then the instance the request was started for, e.g. in OAuth callbacks
+	if aud := getInstanceAud(ctx); aud != "" {
+		return aud
+	}
+
 	// Finally, return the default if none of the above methods are successful
 	return config.JWT.Aud
 }
@@ -59,25 +64,39 @@ func getBodyBytes(req *http.Request) ([]byte, error) {
 }
 
 type RequestParams interface {
-	AdminUserParams |
+	AdminIdentityParams |
+		AdminUserMergeParams |
+		AdminUserParams |
+		ChallengeFactorParams |
+		ClientCredentialsGrantParams |
 		CreateSSOProviderParams |
+		DeviceCodeGrantParams |
+		DeviceVerifyParams |
 		EnrollFactorParams |
 		GenerateLinkParams |
 		IdTokenGrantParams |
+		IntrospectParams |
 		InviteParams |
+		JWTKeyCreateParams |
+		OAuthClientCreateParams |
 		OtpParams |
 		PKCEGrantParams |
 		PasswordGrantParams |
 		RecoverParams |
 		RefreshTokenGrantParams |
 		ResendConfirmationParams |
+		RevokeParams |
 		SignupParams |
 		SingleSignOnParams |
 		SmsParams |
 		UserUpdateParams |
 		VerifyFactorParams |
 		VerifyParams |
+		VerifyRecoveryCodeParams |
+		WebAuthnGrantParams |
 		adminUserUpdateFactorParams |
+		conf.FeatureFlags |
+		conf.InstanceOverrides |
 		struct {
 			Email string `json:"email"`
 			Phone string `json:"phone"`
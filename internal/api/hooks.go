@@ -24,6 +24,7 @@ import (
 	"github.com/supabase/auth/internal/hooks"
 
 	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/utilities"
 )
 
 const (
@@ -128,7 +129,9 @@ func (a *API) runHTTPHook(r *http.Request, hookConfig conf.ExtensibilityPointCon
 		} else if err != nil {
 			if terr, ok := err.(net.Error); ok && terr.Timeout() || i < DefaultHTTPHookRetries-1 {
 				hookLog.Errorf("Request timed out for attempt %d with err %s", i, err)
-				time.Sleep(HTTPHookBackoffDuration)
+				if err := utilities.Sleep(ctx, HTTPHookBackoffDuration); err != nil {
+					return nil, unprocessableEntityError(ErrorCodeHookTimeout, fmt.Sprintf("Failed to reach hook within maximum time of %f seconds", DefaultHTTPHookTimeout.Seconds()))
+				}
 				continue
 			} else if i == DefaultHTTPHookRetries-1 {
 				return nil, unprocessableEntityError(ErrorCodeHookTimeoutAfterRetry, "Failed to reach hook after maximum retries")
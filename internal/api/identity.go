@@ -13,6 +13,23 @@ import (
 	"github.com/supabase/auth/internal/storage"
 )
 
+// IdentitiesResponse lists the identities linked to a user.
+type IdentitiesResponse struct {
+	Identities []models.Identity `json:"identities"`
+}
+
+// UserIdentities responds with the identities linked to the user, so that
+// clients can show which providers are linked and can be unlinked.
+func (a *API) UserIdentities(w http.ResponseWriter, r *http.Request) error {
+	user := getUser(r.Context())
+
+	identities := user.Identities
+	if identities == nil {
+		identities = []models.Identity{}
+	}
+	return sendJSON(w, http.StatusOK, IdentitiesResponse{Identities: identities})
+}
+
 func (a *API) DeleteIdentity(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
@@ -55,31 +72,7 @@ func (a *API) DeleteIdentity(w http.ResponseWriter, r *http.Request) error {
 		}); terr != nil {
 			return internalServerError("Error recording audit log entry").WithInternalError(terr)
 		}
-		if terr := tx.Destroy(identityToBeDeleted); terr != nil {
-			return internalServerError("Database error deleting identity").WithInternalError(terr)
-		}
-
-		switch identityToBeDeleted.Provider {
-		case "phone":
-			user.PhoneConfirmedAt = nil
-			if terr := user.SetPhone(tx, ""); terr != nil {
-				return internalServerError("Database error updating user phone").WithInternalError(terr)
-			}
-			if terr := tx.UpdateOnly(user, "phone_confirmed_at"); terr != nil {
-				return internalServerError("Database error updating user phone").WithInternalError(terr)
-			}
-		default:
-			if terr := user.UpdateUserEmailFromIdentities(tx); terr != nil {
-				if models.IsUniqueConstraintViolatedError(terr) {
-					return unprocessableEntityError(ErrorCodeEmailConflictIdentityNotDeletable, "Unable to unlink identity due to email conflict").WithInternalError(terr)
-				}
-				return internalServerError("Database error updating user email").WithInternalError(terr)
-			}
-		}
-		if terr := user.UpdateAppMetaDataProviders(tx); terr != nil {
-			return internalServerError("Database error updating user providers").WithInternalError(terr)
-		}
-		return nil
+		return a.unlinkIdentity(tx, user, identityToBeDeleted)
 	})
 	if err != nil {
 		return err
@@ -88,6 +81,36 @@ func (a *API) DeleteIdentity(w http.ResponseWriter, r *http.Request) error {
 	return sendJSON(w, http.StatusOK, map[string]interface{}{})
 }
 
+// unlinkIdentity deletes the identity of the user and updates the user's
+// email or phone and providers to the remaining identities.
+func (a *API) unlinkIdentity(tx *storage.Connection, user *models.User, identity *models.Identity) error {
+	if err := tx.Destroy(identity); err != nil {
+		return internalServerError("Database error deleting identity").WithInternalError(err)
+	}
+
+	switch identity.Provider {
+	case "phone":
+		user.PhoneConfirmedAt = nil
+		if err := user.SetPhone(tx, ""); err != nil {
+			return internalServerError("Database error updating user phone").WithInternalError(err)
+		}
+		if err := tx.UpdateOnly(user, "phone_confirmed_at"); err != nil {
+			return internalServerError("Database error updating user phone").WithInternalError(err)
+		}
+	default:
+		if err := user.UpdateUserEmailFromIdentities(tx); err != nil {
+			if models.IsUniqueConstraintViolatedError(err) {
+				return unprocessableEntityError(ErrorCodeEmailConflictIdentityNotDeletable, "Unable to unlink identity due to email conflict").WithInternalError(err)
+			}
+			return internalServerError("Database error updating user email").WithInternalError(err)
+		}
+	}
+	if err := user.UpdateAppMetaDataProviders(tx); err != nil {
+		return internalServerError("Database error updating user providers").WithInternalError(err)
+	}
+	return nil
+}
+
 func (a *API) LinkIdentity(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	user := getUser(ctx)
@@ -135,6 +158,13 @@ func (a *API) linkIdentityToUser(r *http.Request, ctx context.Context, tx *stora
 				if errors.Is(terr, MaxFrequencyLimitError) {
 					return nil, tooManyRequestsError(ErrorCodeOverSMSSendRateLimit, "For security purposes, you can only request this once every minute")
 				}
+				if errors.Is(terr, EmailSendLimitError) {
+					// identities are linked in the callback of the
+					// external providers
+					if terr := a.handleEmailSendLimit(r, "external", terr); terr != nil {
+						return nil, terr
+					}
+				}
 			}
 			return nil, storage.NewCommitWithError(unprocessableEntityError(ErrorCodeEmailNotConfirmed, "Unverified email with %v. A confirmation email has been sent to your %v email", providerType, providerType))
 		}
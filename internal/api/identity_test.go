@@ -6,14 +6,18 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
+	"github.com/gobuffalo/pop/v6"
 	"github.com/gofrs/uuid"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/supabase/auth/internal/api/provider"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
 )
 
 type IdentityTestSuite struct {
@@ -105,6 +109,101 @@ func (ts *IdentityTestSuite) TestLinkIdentityToUser() {
 	require.Nil(ts.T(), u)
 }
 
+func (ts *IdentityTestSuite) TestLinkIdentityEmailSendLimit() {
+	defer func(maxFrequency time.Duration, sendLimit conf.EmailSendLimitConfiguration) {
+		ts.Config.SMTP.MaxFrequency = maxFrequency
+		ts.Config.Mailer.SendLimit = sendLimit
+	}(ts.Config.SMTP.MaxFrequency, ts.Config.Mailer.SendLimit)
+
+	ts.Config.SMTP.MaxFrequency = 0
+	ts.Config.Mailer.SendLimit = conf.EmailSendLimitConfiguration{MaxPerHour: 1}
+
+	u, err := models.NewUser("987654321", "", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	link := func(subject string) error {
+		u, err := models.FindUserByID(ts.API.db, u.ID)
+		require.NoError(ts.T(), err)
+
+		r := httptest.NewRequest(http.MethodGet, "/callback", nil)
+		_, err = ts.API.linkIdentityToUser(r, withTargetUser(context.Background(), u), ts.API.db, &provider.UserProvidedData{
+			Metadata: &provider.Claims{
+				Subject: subject,
+				Email:   "linked@example.com",
+			},
+		}, "test")
+		return err
+	}
+
+	// a confirmation email is sent for the unverified email
+	err = link("first")
+	commitErr, ok := err.(*storage.CommitWithError)
+	require.True(ts.T(), ok, "expected a commit with error, got %v", err)
+	require.Equal(ts.T(), ErrorCodeEmailNotConfirmed, commitErr.Err.(*HTTPError).ErrorCode)
+
+	// linking another identity with the email counts against the limit
+	require.NoError(ts.T(), ts.API.db.RawQuery("update "+(&pop.Model{Value: models.User{}}).TableName()+" set email = null where id = ?", u.ID).Exec())
+	err = link("second")
+	httpErr, ok := err.(*HTTPError)
+	require.True(ts.T(), ok, "expected an HTTP error, got %v", err)
+	require.Equal(ts.T(), http.StatusTooManyRequests, httpErr.HTTPStatus)
+	require.Equal(ts.T(), ErrorCodeOverEmailSendRateLimit, httpErr.ErrorCode)
+}
+
+func (ts *IdentityTestSuite) TestLinkIdentitySkipHTTPRedirect() {
+	ts.Config.Security.ManualLinkingEnabled = true
+	defer func() { ts.Config.Security.ManualLinkingEnabled = false }()
+
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "one@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	token := ts.generateAccessTokenAndSession(u)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/user/identities/authorize?provider=github&skip_http_redirect=true", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// the state isn't bound to a cookie, which the fetch of a cross-origin
+	// app doesn't keep
+	require.Empty(ts.T(), w.Result().Cookies())
+
+	var data struct {
+		URL string `json:"url"`
+	}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	authURL, err := url.Parse(data.URL)
+	require.NoError(ts.T(), err)
+
+	ctx, err := ts.API.loadExternalState(context.Background(), authURL.Query().Get("state"), "", "github")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), u.ID, getTargetUser(ctx).ID)
+}
+
+func (ts *IdentityTestSuite) TestUserIdentities() {
+	// listing identities doesn't need manual linking
+	ts.Config.Security.ManualLinkingEnabled = false
+
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "two@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	token := ts.generateAccessTokenAndSession(u)
+	req, err := http.NewRequest(http.MethodGet, "/user/identities", nil)
+	require.NoError(ts.T(), err)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	var data IdentitiesResponse
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Len(ts.T(), data.Identities, 2)
+	for _, identity := range data.Identities {
+		require.Equal(ts.T(), u.ID, identity.UserID)
+	}
+}
+
 func (ts *IdentityTestSuite) TestUnlinkIdentityError() {
 	ts.Config.Security.ManualLinkingEnabled = true
 	userWithOneIdentity, err := models.FindUserByEmailAndAudience(ts.API.db, "one@example.com", ts.Config.JWT.Aud)
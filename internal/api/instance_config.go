@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+	"golang.org/x/sync/singleflight"
+)
+
+// instanceConfigCache holds the merged configurations of all instances with
+// configuration overrides. They are loaded together, so that requests for
+// instances without overrides don't query the database.
+type instanceConfigCache struct {
+	mu       sync.Mutex
+	entries  map[string]instanceConfigEntry
+	loadedAt time.Time
+
+	// loads makes concurrent requests share a load of the configurations
+	loads singleflight.Group
+}
+
+type instanceConfigEntry struct {
+	config *conf.GlobalConfiguration
+	err    error
+}
+
+func (c *instanceConfigCache) get(ttl time.Duration) (map[string]instanceConfigEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries, c.entries != nil && time.Since(c.loadedAt) < ttl
+}
+
+func (c *instanceConfigCache) set(entries map[string]instanceConfigEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = entries
+	c.loadedAt = time.Now()
+}
+
+// instanceConfig returns the configuration of the instance with the
+// audience aud: the global configuration with the instance's overrides
+// applied. While the configurations can't be loaded, the last loaded ones
+// are used, or else the global configuration.
+func (a *API) instanceConfig(ctx context.Context, aud string) (*conf.GlobalConfiguration, error) {
+	cache := &a.instanceConfigs
+
+	entries, fresh := cache.get(a.config.API.InstanceConfigCacheTTL)
+	if !fresh && a.db != nil {
+		loaded, err, _ := cache.loads.Do("", func() (interface{}, error) {
+			entries, err := a.loadInstanceConfigs(ctx)
+			if err != nil {
+				return nil, err
+			}
+			cache.set(entries)
+			return entries, nil
+		})
+		if err != nil {
+			logrus.WithError(err).Warn("Unable to load instance configs, using the last loaded ones")
+		} else {
+			entries = loaded.(map[string]instanceConfigEntry)
+		}
+	}
+
+	return a.instanceConfigEntry(entries, aud)
+}
+
+// cachedInstanceConfig is like instanceConfig, but never waits for the
+// database: stale configurations are refreshed in the background.
+func (a *API) cachedInstanceConfig(aud string) (*conf.GlobalConfiguration, error) {
+	cache := &a.instanceConfigs
+
+	entries, fresh := cache.get(a.config.API.InstanceConfigCacheTTL)
+	if !fresh && a.db != nil {
+		go cache.loads.Do("", func() (interface{}, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), instanceConfigLoadTimeout)
+			defer cancel()
+
+			entries, err := a.loadInstanceConfigs(ctx)
+			if err != nil {
+				logrus.WithError(err).Warn("Unable to load instance configs, using the last loaded ones")
+				return nil, err
+			}
+			cache.set(entries)
+			return entries, nil
+		})
+	}
+
+	return a.instanceConfigEntry(entries, aud)
+}
+
+// instanceConfigLoadTimeout bounds loads of the instance configurations in
+// the background.
+const instanceConfigLoadTimeout = 10 * time.Second
+
+func (a *API) instanceConfigEntry(entries map[string]instanceConfigEntry, aud string) (*conf.GlobalConfiguration, error) {
+	entry, ok := entries[aud]
+	if !ok {
+		return a.config, nil
+	}
+
+	return entry.config, entry.err
+}
+
+// invalidateInstanceConfigs drops the cached instance configurations.
+func (a *API) invalidateInstanceConfigs() {
+	cache := &a.instanceConfigs
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.loadedAt = time.Time{}
+}
+
+func (a *API) loadInstanceConfigs(ctx context.Context) (map[string]instanceConfigEntry, error) {
+	configs, err := models.FindInstanceConfigs(a.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]instanceConfigEntry, len(configs))
+	for _, instanceConfig := range configs {
+		// an instance whose secrets can't be decrypted fails its own
+		// requests instead of falling back to the global configuration
+		overrides, err := instanceConfig.GetOverrides(a.config.Security.DBEncryption.DecryptionKeys)
+		if err != nil {
+			entries[instanceConfig.Aud] = instanceConfigEntry{err: err}
+			continue
+		}
+
+		entries[instanceConfig.Aud] = instanceConfigEntry{config: overrides.Apply(a.config)}
+	}
+
+	return entries, nil
+}
+
+// withInstanceConfig adds the configuration of the instance with the audience
+// aud to the context.
+func (a *API) withInstanceConfig(ctx context.Context, aud string) (context.Context, error) {
+	config, err := a.instanceConfig(ctx, aud)
+	if err != nil {
+		return nil, internalServerError("Error loading instance config").WithInternalError(err)
+	}
+
+	return withInstance(ctx, aud, config), nil
+}
+
+// loadInstanceConfig resolves the instance of the request from its audience.
+func (a *API) loadInstanceConfig(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	return a.withInstanceConfig(ctx, a.requestAud(ctx, r))
+}
+
+// loadCachedInstanceConfig resolves the instance of the request from its
+// audience without waiting for the database, for routes such as /settings
+// that have to keep working while it's unavailable.
+func (a *API) loadCachedInstanceConfig(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	aud := a.requestAud(ctx, r)
+
+	config, err := a.cachedInstanceConfig(aud)
+	if err != nil {
+		return nil, internalServerError("Error loading instance config").WithInternalError(err)
+	}
+
+	return withInstance(ctx, aud, config), nil
+}
+
+// getConfig returns the configuration of the request's instance, or the
+// global configuration outside of requests.
+func (a *API) getConfig(ctx context.Context) *conf.GlobalConfiguration {
+	if config := getInstanceConfig(ctx); config != nil {
+		return config
+	}
+
+	return a.config
+}
+
+// InstanceConfigResponse is the configuration of an instance. The external
+// provider secrets are not returned, ExternalSecrets lists the providers
+// with a secret.
+type InstanceConfigResponse struct {
+	Aud             string                 `json:"aud"`
+	Overrides       conf.InstanceOverrides `json:"overrides"`
+	ExternalSecrets []string               `json:"external_secrets"`
+	CreatedAt       *time.Time             `json:"created_at,omitempty"`
+	UpdatedAt       *time.Time             `json:"updated_at,omitempty"`
+}
+
+func newInstanceConfigResponse(aud string, instanceConfig *models.InstanceConfig, overrides *conf.InstanceOverrides) *InstanceConfigResponse {
+	resp := &InstanceConfigResponse{
+		Aud:             aud,
+		ExternalSecrets: []string{},
+	}
+
+	if instanceConfig == nil {
+		return resp
+	}
+
+	resp.CreatedAt = &instanceConfig.CreatedAt
+	resp.UpdatedAt = &instanceConfig.UpdatedAt
+	resp.Overrides = conf.InstanceOverrides(instanceConfig.Overrides)
+
+	for name := range overrides.Secrets() {
+		resp.ExternalSecrets = append(resp.ExternalSecrets, name)
+	}
+	slices.Sort(resp.ExternalSecrets)
+
+	return resp
+}
+
+// adminInstanceConfigGet returns the configuration overrides of an instance.
+func (a *API) adminInstanceConfigGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	aud := chi.URLParam(r, "aud")
+
+	instanceConfig, err := models.FindInstanceConfigByAud(db, aud)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return sendJSON(w, http.StatusOK, newInstanceConfigResponse(aud, nil, nil))
+		}
+		return internalServerError("Database error finding instance config").WithInternalError(err)
+	}
+
+	overrides, err := instanceConfig.GetOverrides(a.config.Security.DBEncryption.DecryptionKeys)
+	if err != nil {
+		return internalServerError("Error decrypting instance config").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, newInstanceConfigResponse(aud, instanceConfig, overrides))
+}
+
+// adminInstanceConfigUpdate replaces the configuration overrides of an
+// instance. Secrets left out for an external provider keep their current
+// value, so that the response of adminInstanceConfigGet can be sent back.
+func (a *API) adminInstanceConfigUpdate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	aud := chi.URLParam(r, "aud")
+	encryption := a.config.Security.DBEncryption
+
+	overrides := &conf.InstanceOverrides{}
+	if err := retrieveRequestParams(r, overrides); err != nil {
+		return err
+	}
+
+	if err := overrides.Validate(); err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "Invalid instance config: %v", err)
+	}
+
+	var instanceConfig *models.InstanceConfig
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		instanceConfig, terr = models.FindInstanceConfigByAud(tx, aud)
+		if terr != nil && !models.IsNotFoundError(terr) {
+			return internalServerError("Database error finding instance config").WithInternalError(terr)
+		}
+
+		exists := instanceConfig != nil
+		if exists {
+			current, terr := instanceConfig.GetOverrides(encryption.DecryptionKeys)
+			if terr != nil {
+				return internalServerError("Error decrypting instance config").WithInternalError(terr)
+			}
+
+			for name, provider := range overrides.External {
+				if provider.Secret == "" && current.External[name] != nil {
+					provider.Secret = current.External[name].Secret
+				}
+			}
+		} else if instanceConfig, terr = models.NewInstanceConfig(aud); terr != nil {
+			return internalServerError("Error creating instance config").WithInternalError(terr)
+		}
+
+		if terr := instanceConfig.SetOverrides(*overrides, encryption); terr != nil {
+			if !encryption.Encrypt {
+				return badRequestError(ErrorCodeValidationFailed, "External provider secrets can only be stored with database encryption enabled")
+			}
+			return internalServerError("Error encrypting instance config").WithInternalError(terr)
+		}
+
+		if exists {
+			terr = tx.Update(instanceConfig)
+		} else {
+			terr = tx.Create(instanceConfig)
+		}
+		if terr != nil {
+			return internalServerError("Database error saving instance config").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.InstanceConfigUpdatedAction, "", map[string]interface{}{
+			"aud": aud,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	a.invalidateInstanceConfigs()
+
+	return sendJSON(w, http.StatusOK, newInstanceConfigResponse(aud, instanceConfig, overrides))
+}
@@ -0,0 +1,400 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/hooks"
+	mail "github.com/supabase/auth/internal/mailer"
+	"github.com/supabase/auth/internal/models"
+)
+
+type InstanceConfigTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	token string
+}
+
+func TestInstanceConfig(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &InstanceConfigTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *InstanceConfigTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+	ts.API.invalidateInstanceConfigs()
+
+	ts.Config.External.Email.Enabled = true
+	ts.Config.Mailer.Autoconfirm = false
+	ts.Config.DisableSignup = false
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+	ts.token = token
+}
+
+func (ts *InstanceConfigTestSuite) putInstanceConfig(aud string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/admin/instances/%s/config", aud), &buffer)
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *InstanceConfigTestSuite) getInstanceConfig(aud string) *InstanceConfigResponse {
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/admin/instances/%s/config", aud), nil)
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := &InstanceConfigResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	return resp
+}
+
+func (ts *InstanceConfigTestSuite) TestGetWithoutOverrides() {
+	resp := ts.getInstanceConfig("site-a")
+	require.Equal(ts.T(), "site-a", resp.Aud)
+	require.Nil(ts.T(), resp.Overrides.SiteURL)
+	require.Empty(ts.T(), resp.ExternalSecrets)
+}
+
+func (ts *InstanceConfigTestSuite) TestUpdateKeepsSecretsOutOfResponses() {
+	w := ts.putInstanceConfig("site-a", map[string]interface{}{
+		"site_url": "https://a.example.com",
+		"external": map[string]interface{}{
+			"github": map[string]interface{}{
+				"client_id": []string{"site-a-client"},
+				"secret":    "site-a-secret",
+			},
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.NotContains(ts.T(), w.Body.String(), "site-a-secret")
+
+	resp := ts.getInstanceConfig("site-a")
+	require.Equal(ts.T(), "https://a.example.com", *resp.Overrides.SiteURL)
+	require.Equal(ts.T(), []string{"site-a-client"}, resp.Overrides.External["github"].ClientID)
+	require.Empty(ts.T(), resp.Overrides.External["github"].Secret)
+	require.Equal(ts.T(), []string{"github"}, resp.ExternalSecrets)
+
+	instanceConfig, err := models.FindInstanceConfigByAud(ts.API.db, "site-a")
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), instanceConfig.EncryptedSecrets)
+	require.NotContains(ts.T(), *instanceConfig.EncryptedSecrets, "site-a-secret")
+
+	// sending the overrides back without the secret keeps it
+	w = ts.putInstanceConfig("site-a", map[string]interface{}{
+		"site_url": "https://a.example.com",
+		"external": map[string]interface{}{
+			"github": map[string]interface{}{
+				"client_id": []string{"site-a-client-2"},
+			},
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	config, err := ts.API.instanceConfig(context.Background(), "site-a")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), []string{"site-a-client-2"}, config.External.Github.ClientID)
+	require.Equal(ts.T(), "site-a-secret", config.External.Github.Secret)
+}
+
+func (ts *InstanceConfigTestSuite) TestUpdateValidation() {
+	w := ts.putInstanceConfig("site-a", map[string]interface{}{
+		"external": map[string]interface{}{
+			"myspace": map[string]interface{}{
+				"client_id": []string{"client"},
+			},
+		},
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	encryption := ts.Config.Security.DBEncryption
+	defer func() {
+		ts.Config.Security.DBEncryption = encryption
+	}()
+	ts.Config.Security.DBEncryption.Encrypt = false
+
+	w = ts.putInstanceConfig("site-a", map[string]interface{}{
+		"external": map[string]interface{}{
+			"github": map[string]interface{}{
+				"secret": "plaintext",
+			},
+		},
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	_, err := models.FindInstanceConfigByAud(ts.API.db, "site-a")
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *InstanceConfigTestSuite) TestConfirmationLinksPerInstance() {
+	var payloads []hooks.SendEmailInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload hooks.SendEmailInput
+		require.NoError(ts.T(), json.NewDecoder(r.Body).Decode(&payload))
+		payloads = append(payloads, payload)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	mailer, hook := ts.Config.Mailer, ts.Config.Hook.SendEmail
+	defer func() {
+		ts.Config.Mailer, ts.Config.Hook.SendEmail = mailer, hook
+	}()
+	ts.Config.Mailer.Provider = "hook"
+	ts.Config.Hook.SendEmail = conf.ExtensibilityPointConfiguration{
+		Enabled:         true,
+		URI:             server.URL,
+		HTTPHookSecrets: conf.HTTPHookSecrets{"v1,whsec_aWxpa2VzdXBhYmFzZXZlcnltdWNoYW5kaWhvcGV5b3Vkb3Rvbw=="},
+	}
+
+	for _, aud := range []string{"site-a", "site-b"} {
+		w := ts.putInstanceConfig(aud, map[string]interface{}{
+			"site_url": fmt.Sprintf("https://%s.example.com", aud),
+		})
+		require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	}
+
+	for _, aud := range []string{"site-a", "site-b"} {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"email":    fmt.Sprintf("user@%s.example.com", aud),
+			"password": "test123",
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "/signup", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(audHeaderName, aud)
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	}
+
+	require.Len(ts.T(), payloads, 2)
+	for i, aud := range []string{"site-a", "site-b"} {
+		require.Equal(ts.T(), mail.SignupVerification, payloads[i].EmailData.EmailActionType)
+		require.Equal(ts.T(), aud, payloads[i].User.Aud)
+		require.Equal(ts.T(), fmt.Sprintf("https://%s.example.com", aud), payloads[i].EmailData.RedirectTo)
+	}
+}
+
+func (ts *InstanceConfigTestSuite) TestGithubCredentialsPerInstance() {
+	for _, aud := range []string{"site-a", "site-b"} {
+		w := ts.putInstanceConfig(aud, map[string]interface{}{
+			"external": map[string]interface{}{
+				"github": map[string]interface{}{
+					"client_id": []string{aud + "-client"},
+					"secret":    aud + "-secret",
+				},
+			},
+		})
+		require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	}
+
+	for _, aud := range []string{"site-a", "site-b", ts.Config.JWT.Aud} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/authorize?provider=github", nil)
+		req.Header.Set(audHeaderName, aud)
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusFound, w.Code, w.Body.String())
+
+		u, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(ts.T(), err)
+
+		expectedClientID, expectedSecret := aud+"-client", aud+"-secret"
+		if aud == ts.Config.JWT.Aud {
+			expectedClientID, expectedSecret = ts.Config.External.Github.ClientID[0], ts.Config.External.Github.Secret
+		}
+		require.Equal(ts.T(), expectedClientID, u.Query().Get("client_id"))
+
+		// the callback resolves the same instance from the state
+		cookies := w.Result().Cookies()
+		require.Len(ts.T(), cookies, 1)
+		ctx, err := ts.API.loadExternalState(context.Background(), u.Query().Get("state"), cookies[0].Value, "")
+		require.NoError(ts.T(), err)
+		require.Equal(ts.T(), aud, getInstanceAud(ctx))
+		require.Equal(ts.T(), expectedSecret, ts.API.getConfig(ctx).External.Github.Secret)
+	}
+}
+
+func (ts *InstanceConfigTestSuite) TestSignupDisabledPerInstance() {
+	w := ts.putInstanceConfig("site-a", map[string]interface{}{
+		"disable_signup": true,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	for aud, expected := range map[string]int{"site-a": http.StatusUnprocessableEntity, "site-b": http.StatusOK} {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"email":    fmt.Sprintf("user@%s.example.com", aud),
+			"password": "test123",
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "/signup", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(audHeaderName, aud)
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), expected, w.Code, w.Body.String())
+	}
+}
+
+func (ts *InstanceConfigTestSuite) postJSON(path, aud string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(http.MethodPost, path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(audHeaderName, aud)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *InstanceConfigTestSuite) TestMagicLinkSignupDisabledPerInstance() {
+	w := ts.putInstanceConfig("site-a", map[string]interface{}{
+		"disable_signup": true,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	for aud, expected := range map[string]int{"site-a": http.StatusUnprocessableEntity, "site-b": http.StatusOK} {
+		w := ts.postJSON("/magiclink", aud, map[string]interface{}{
+			"email": fmt.Sprintf("user@%s.example.com", aud),
+		})
+		require.Equal(ts.T(), expected, w.Code, w.Body.String())
+	}
+}
+
+func (ts *InstanceConfigTestSuite) TestPhoneOtpSignupDisabledPerInstance() {
+	external, sms := ts.Config.External, ts.Config.Sms
+	defer func() {
+		ts.Config.External, ts.Config.Sms = external, sms
+	}()
+	ts.Config.External.Phone.Enabled = true
+	ts.Config.Sms.Autoconfirm = false
+	ts.Config.Sms.TestOTP = map[string]string{
+		"123456789": "123456",
+		"123456788": "123456",
+	}
+
+	w := ts.putInstanceConfig("site-a", map[string]interface{}{
+		"disable_signup": true,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	for aud, phone := range map[string]string{"site-a": "123456789", "site-b": "123456788"} {
+		w := ts.postJSON("/otp", aud, map[string]interface{}{
+			"phone": phone,
+		})
+		if aud == "site-a" {
+			require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+		} else {
+			require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+}
+
+func (ts *InstanceConfigTestSuite) TestAnonymousSignupDisabledPerInstance() {
+	anonymous := ts.Config.External.AnonymousUsers.Enabled
+	defer func() {
+		ts.Config.External.AnonymousUsers.Enabled = anonymous
+	}()
+	ts.Config.External.AnonymousUsers.Enabled = true
+
+	w := ts.putInstanceConfig("site-a", map[string]interface{}{
+		"disable_signup": true,
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	for aud, expected := range map[string]int{"site-a": http.StatusUnprocessableEntity, "site-b": http.StatusOK} {
+		w := ts.postJSON("/signup", aud, map[string]interface{}{})
+		require.Equal(ts.T(), expected, w.Code, w.Body.String())
+	}
+}
+
+func (ts *InstanceConfigTestSuite) TestSettingsPerInstance() {
+	w := ts.putInstanceConfig("site-a", map[string]interface{}{
+		"disable_signup": true,
+		"external": map[string]interface{}{
+			"github": map[string]interface{}{
+				"enabled": false,
+			},
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// the settings are served from the cache without waiting for the
+	// database, load it first
+	_, err := ts.API.instanceConfig(context.Background(), "site-a")
+	require.NoError(ts.T(), err)
+
+	for _, aud := range []string{"site-a", "site-b"} {
+		req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+		req.Header.Set(audHeaderName, aud)
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+		resp := Settings{}
+		require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+		require.Equal(ts.T(), aud == "site-a", resp.DisableSignup)
+		require.Equal(ts.T(), aud != "site-a", resp.ExternalProviders.GitHub)
+	}
+}
+
+func (ts *InstanceConfigTestSuite) TestProviderDisabledPerInstance() {
+	w := ts.putInstanceConfig("site-a", map[string]interface{}{
+		"external": map[string]interface{}{
+			"github": map[string]interface{}{
+				"enabled": false,
+			},
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	for aud, expected := range map[string]int{"site-a": http.StatusBadRequest, "site-b": http.StatusFound} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/authorize?provider=github", nil)
+		req.Header.Set(audHeaderName, aud)
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), expected, w.Code, w.Body.String())
+	}
+}
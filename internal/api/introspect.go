@@ -0,0 +1,209 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// IntrospectParams are the parameters of a token introspection request,
+// sent as a form or as JSON.
+type IntrospectParams struct {
+	Token         string `json:"token"`
+	TokenTypeHint string `json:"token_type_hint"`
+}
+
+// IntrospectResponse tells whether a token is active, and describes it when
+// it is. See: https://datatracker.ietf.org/doc/html/rfc7662#section-2.2
+type IntrospectResponse struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	AAL       string `json:"aal,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+}
+
+var inactiveToken = &IntrospectResponse{Active: false}
+
+// TokenIntrospect tells resource servers whether an access or refresh token
+// is active. Unlike checking the JWT alone, access tokens of sessions which
+// were logged out or expired, and of users who were banned or deleted, are
+// inactive.
+func (a *API) TokenIntrospect(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	params := &IntrospectParams{}
+	if isFormRequest(r) {
+		params.Token = r.PostFormValue("token")
+		params.TokenTypeHint = r.PostFormValue("token_type_hint")
+	} else if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.Token == "" {
+		return badRequestError(ErrorCodeValidationFailed, "token is required")
+	}
+
+	var (
+		resp *IntrospectResponse
+		err  error
+	)
+	switch params.TokenTypeHint {
+	case "refresh_token":
+		resp, err = a.introspectRefreshToken(db, params.Token)
+		if err == nil && !resp.Active {
+			resp, err = a.introspectAccessToken(r, db, params.Token)
+		}
+	default:
+		resp, err = a.introspectAccessToken(r, db, params.Token)
+		if err == nil && !resp.Active {
+			resp, err = a.introspectRefreshToken(db, params.Token)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return sendJSON(w, http.StatusOK, resp)
+}
+
+// isFormRequest returns whether the body of the request is form encoded, as
+// OAuth clients send it, rather than JSON.
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded"
+}
+
+func (a *API) introspectAccessToken(r *http.Request, db *storage.Connection, bearer string) (*IntrospectResponse, error) {
+	token, err := a.parseAccessToken(r.Context(), bearer)
+	if err != nil {
+		return inactiveToken, nil
+	}
+	claims := token.Claims.(*AccessTokenClaims)
+
+	if err := a.requireUnrevokedToken(r.Context(), bearer); err != nil {
+		if httpErr, ok := err.(*HTTPError); ok && httpErr.ErrorCode == ErrorCodeTokenRevoked {
+			return inactiveToken, nil
+		}
+		return nil, err
+	}
+
+	resp := &IntrospectResponse{
+		Active:    true,
+		TokenType: "bearer",
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		Role:      claims.Role,
+		SessionID: claims.SessionId,
+		AAL:       claims.AuthenticatorAssuranceLevel,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+	}
+
+	// tokens of no session, such as the anon and service role keys and the
+	// tokens of OAuth clients, are active until they expire
+	if claims.SessionId == "" {
+		return resp, nil
+	}
+
+	sessionID, err := uuid.FromString(claims.SessionId)
+	if err != nil {
+		return inactiveToken, nil
+	}
+
+	session, err := models.FindSessionByID(db, sessionID, false)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return inactiveToken, nil
+		}
+		return nil, internalServerError("Database error finding session").WithInternalError(err)
+	}
+
+	if session.UserID.String() != claims.Subject {
+		return inactiveToken, nil
+	}
+
+	return a.introspectSession(db, session, nil, resp)
+}
+
+func (a *API) introspectRefreshToken(db *storage.Connection, token string) (*IntrospectResponse, error) {
+	user, refreshToken, session, err := models.FindUserWithRefreshToken(db, token, false)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return inactiveToken, nil
+		}
+		return nil, internalServerError("Database error finding refresh token").WithInternalError(err)
+	}
+
+	// refresh tokens which were used are replaced by their child
+	if refreshToken.Revoked || session == nil {
+		return inactiveToken, nil
+	}
+
+	resp := &IntrospectResponse{
+		Active:    true,
+		TokenType: "refresh_token",
+		Subject:   user.ID.String(),
+		Audience:  user.Aud,
+		Issuer:    a.config.JWT.Issuer,
+		IssuedAt:  refreshToken.CreatedAt.Unix(),
+		Role:      user.Role,
+		SessionID: session.ID.String(),
+		AAL:       session.GetAAL(),
+	}
+
+	return a.introspectSession(db, session, &refreshToken.UpdatedAt, resp)
+}
+
+// introspectSession returns the response of a token of a session, or an
+// inactive one when the session or its user is no longer valid.
+func (a *API) introspectSession(db *storage.Connection, session *models.Session, refreshedAt *time.Time, resp *IntrospectResponse) (*IntrospectResponse, error) {
+	config := a.config
+
+	if session.CheckValidity(a.Now(), refreshedAt, config.Sessions.Timebox, config.Sessions.InactivityTimeout) != models.SessionValid {
+		return inactiveToken, nil
+	}
+
+	user, err := models.FindUserByID(db, session.UserID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return inactiveToken, nil
+		}
+		return nil, internalServerError("Database error finding user").WithInternalError(err)
+	}
+
+	if user.IsBanned() || user.DeletedAt != nil {
+		return inactiveToken, nil
+	}
+
+	// refresh tokens expire with their session
+	if resp.ExpiresAt == 0 {
+		if session.NotAfter != nil {
+			resp.ExpiresAt = session.NotAfter.Unix()
+		}
+		if config.Sessions.Timebox != nil && *config.Sessions.Timebox != 0 {
+			timeboxEnd := session.CreatedAt.Add(*config.Sessions.Timebox).Unix()
+			if resp.ExpiresAt == 0 || timeboxEnd < resp.ExpiresAt {
+				resp.ExpiresAt = timeboxEnd
+			}
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+type IntrospectTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	adminToken   string
+	user         *models.User
+	session      *models.Session
+	accessToken  string
+	refreshToken string
+}
+
+func TestIntrospect(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &IntrospectTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *IntrospectTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+	ts.API.invalidateJWTKeys()
+
+	adminToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+	ts.adminToken = adminToken
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	ts.user = u
+
+	refreshToken, err := models.GrantAuthenticatedUser(ts.API.db, u, models.GrantParams{})
+	require.NoError(ts.T(), err)
+	ts.refreshToken = refreshToken.Token
+
+	ts.session, err = models.FindSessionByID(ts.API.db, *refreshToken.SessionId, false)
+	require.NoError(ts.T(), err)
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", nil)
+	ts.accessToken, _, err = ts.API.generateAccessToken(req, ts.API.db, u, &ts.session.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+}
+
+func (ts *IntrospectTestSuite) introspect(token, hint string) *IntrospectResponse {
+	form := url.Values{"token": {token}}
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/token/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Authorization", "Bearer "+ts.adminToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := &IntrospectResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	return resp
+}
+
+func (ts *IntrospectTestSuite) TestAccessToken() {
+	resp := ts.introspect(ts.accessToken, "")
+	require.True(ts.T(), resp.Active)
+	require.Equal(ts.T(), "bearer", resp.TokenType)
+	require.Equal(ts.T(), ts.user.ID.String(), resp.Subject)
+	require.Equal(ts.T(), ts.session.ID.String(), resp.SessionID)
+	require.NotZero(ts.T(), resp.ExpiresAt)
+
+	// the JWT is still valid, but its session was logged out
+	require.NoError(ts.T(), models.LogoutSession(ts.API.db, ts.session.ID))
+	require.False(ts.T(), ts.introspect(ts.accessToken, "").Active)
+}
+
+func (ts *IntrospectTestSuite) TestRefreshToken() {
+	resp := ts.introspect(ts.refreshToken, "refresh_token")
+	require.True(ts.T(), resp.Active)
+	require.Equal(ts.T(), "refresh_token", resp.TokenType)
+	require.Equal(ts.T(), ts.user.ID.String(), resp.Subject)
+
+	// the hint is only a hint
+	require.True(ts.T(), ts.introspect(ts.refreshToken, "access_token").Active)
+
+	require.NoError(ts.T(), ts.user.Ban(ts.API.db, time.Hour))
+	require.False(ts.T(), ts.introspect(ts.refreshToken, "refresh_token").Active)
+}
+
+func (ts *IntrospectTestSuite) TestInvalidToken() {
+	require.False(ts.T(), ts.introspect("not-a-token", "").Active)
+}
+
+func (ts *IntrospectTestSuite) TestRequiresAdmin() {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]string{"token": ts.accessToken}))
+
+	req := httptest.NewRequest(http.MethodPost, "/token/introspect", &buffer)
+	req.Header.Set("Authorization", "Bearer "+ts.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+}
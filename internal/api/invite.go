@@ -58,7 +58,7 @@ func (a *API) Invite(w http.ResponseWriter, r *http.Request) error {
 				return err
 			}
 
-			user, err = a.signupNewUser(tx, user)
+			user, err = a.signupNewUser(r, tx, user)
 			if err != nil {
 				return err
 			}
@@ -80,7 +80,7 @@ func (a *API) Invite(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		if err := a.sendInvite(r, tx, user); err != nil {
-			return internalServerError("Error inviting user").WithInternalError(err)
+			return mailerError("Error inviting user", err)
 		}
 		return nil
 	})
@@ -235,6 +235,98 @@ func (ts *InviteTestSuite) TestVerifyInvite() {
 	}
 }
 
+func (ts *InviteTestSuite) invite(email string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email": email,
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/invite", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *InviteTestSuite) TestInviteAcceptLoop() {
+	email := "invitee@example.com"
+
+	w := ts.invite(email)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	user, err := models.FindUserByEmailAndAudience(ts.API.db, email, ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), user.InvitedAt)
+	require.False(ts.T(), user.IsConfirmed())
+	firstToken := user.ConfirmationToken
+
+	// re-inviting an unaccepted invite regenerates the token
+	w = ts.invite(email)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(ts.T(), ts.API.db.Reload(user))
+	require.NotEqual(ts.T(), firstToken, user.ConfirmationToken)
+
+	_, err = models.FindOneTimeToken(ts.API.db, firstToken, models.ConfirmationToken)
+	require.True(ts.T(), models.IsNotFoundError(err))
+
+	// accept the invite, setting a password in the process
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":       "invite",
+		"token_hash": user.ConfirmationToken,
+		"password":   "invitee-password",
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(ts.T(), ts.API.db.Reload(user))
+	require.True(ts.T(), user.IsConfirmed())
+
+	// the invitee can now sign in with the chosen password
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    email,
+		"password": "invitee-password",
+	}))
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=password", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// inviting a confirmed user is rejected
+	w = ts.invite(email)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+}
+
+func (ts *InviteTestSuite) TestVerifyInviteWithWeakPassword() {
+	w := ts.invite("weak@example.com")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	user, err := models.FindUserByEmailAndAudience(ts.API.db, "weak@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":       "invite",
+		"token_hash": user.ConfirmationToken,
+		"password":   "a",
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	require.NoError(ts.T(), ts.API.db.Reload(user))
+	require.False(ts.T(), user.IsConfirmed())
+}
+
 func (ts *InviteTestSuite) TestInviteExternalGitlab() {
 	tokenCount, userCount := 0, 0
 	code := "authcode"
@@ -297,7 +389,7 @@ func (ts *InviteTestSuite) TestInviteExternalGitlab() {
 	v.Set("code", code)
 	v.Set("state", state)
 	testURL.RawQuery = v.Encode()
-	req = httptest.NewRequest(http.MethodGet, testURL.String(), nil)
+	req = withCookies(httptest.NewRequest(http.MethodGet, testURL.String(), nil), w)
 	w = httptest.NewRecorder()
 	ts.API.handler.ServeHTTP(w, req)
 	ts.Require().Equal(http.StatusFound, w.Code)
@@ -389,7 +481,7 @@ func (ts *InviteTestSuite) TestInviteExternalGitlab_MismatchedEmails() {
 	v.Set("code", code)
 	v.Set("state", state)
 	testURL.RawQuery = v.Encode()
-	req = httptest.NewRequest(http.MethodGet, testURL.String(), nil)
+	req = withCookies(httptest.NewRequest(http.MethodGet, testURL.String(), nil), w)
 	w = httptest.NewRecorder()
 	ts.API.handler.ServeHTTP(w, req)
 	ts.Require().Equal(http.StatusFound, w.Code)
@@ -402,3 +494,44 @@ func (ts *InviteTestSuite) TestInviteExternalGitlab_MismatchedEmails() {
 	ts.Require().NotEmpty(v.Get("error_description"))
 	ts.Require().Equal("invalid_request", v.Get("error"))
 }
+
+func (ts *InviteTestSuite) TestInviteWithSignupsDisabled() {
+	ts.Config.DisableSignup = true
+	defer func() {
+		ts.Config.DisableSignup = false
+	}()
+
+	email := "invitee@example.com"
+	w := ts.invite(email)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// self-registration is rejected
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    "stranger@example.com",
+		"password": "test123456",
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/signup", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeSignupDisabled, data.ErrorCode)
+
+	// the invited user can still ask for a magic link
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email": email,
+	}))
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/magiclink", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	user, err := models.FindUserByEmailAndAudience(ts.API.db, email, ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.NotEmpty(ts.T(), user.RecoveryToken)
+}
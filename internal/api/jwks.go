@@ -0,0 +1,33 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/supabase/auth/internal/conf"
+)
+
+// JWKSResponse is the JSON Web Key Set of the keys signing the access
+// tokens.
+type JWKSResponse struct {
+	Keys []conf.JWK `json:"keys"`
+}
+
+// JWKS publishes the public keys verifying the access tokens, so that other
+// services can verify them without the JWT secret. The set is empty when
+// the access tokens are signed with the secret.
+func (a *API) JWKS(w http.ResponseWriter, r *http.Request) error {
+	keys, err := a.jwtKeys(r.Context())
+	if err != nil {
+		return internalServerError("Error loading JWT keys").WithInternalError(err)
+	}
+
+	resp := JWKSResponse{Keys: []conf.JWK{}}
+	for _, key := range keys.verifyingKeys() {
+		resp.Keys = append(resp.Keys, key.JWK())
+	}
+
+	// keys are published for as long before they sign access tokens
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(conf.JWTKeyPublishPeriod.Seconds())))
+	return sendJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+func TestJWKS(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+	require.NoError(t, models.TruncateAll(api.db))
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := JWKSResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Empty(t, resp.Keys)
+	require.Nil(t, config.JWT.Key)
+}
+
+func TestJWKSWithSigningKey(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, conn *storage.Connection) {
+		if config != nil {
+			config.JWT.SigningKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+			require.NoError(t, config.JWT.PopulateFields())
+		}
+	})
+	require.NoError(t, err)
+	require.NoError(t, models.TruncateAll(api.db))
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := JWKSResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(t, []conf.JWK{config.JWT.Key.JWK()}, resp.Keys)
+	require.Equal(t, "OKP", resp.Keys[0].KeyType)
+	require.Equal(t, "EdDSA", resp.Keys[0].Algorithm)
+
+	claims := &AccessTokenClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "a0f5c3e6-6c7a-4b3c-9a0e-0b0d6f1f5b8a"},
+		Role:           "authenticated",
+	}
+
+	signed, err := api.signAccessToken(req.Context(), claims)
+	require.NoError(t, err)
+
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		return privateKey.Public(), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, config.JWT.Key.KeyID, token.Header["kid"])
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/user", nil)
+	_, err = api.parseJWTClaims(signed, req)
+	require.NoError(t, err)
+
+	// tokens signed with the secret, such as the service role key, are
+	// still accepted
+	serviceRole, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{Role: "service_role"}).SignedString([]byte(config.JWT.Secret))
+	require.NoError(t, err)
+	_, err = api.parseJWTClaims(serviceRole, req)
+	require.NoError(t, err)
+}
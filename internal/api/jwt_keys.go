@@ -0,0 +1,477 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+	"golang.org/x/sync/singleflight"
+)
+
+var errUnknownJWTKey = errors.New("token is signed with an unknown key")
+
+// jwtKeyCache holds the decrypted keys generated through the admin API.
+type jwtKeyCache struct {
+	mu       sync.Mutex
+	keys     *jwtKeySet
+	loadedAt time.Time
+
+	// loads makes concurrent requests share a load of the keys
+	loads singleflight.Group
+}
+
+func (c *jwtKeyCache) get(ttl time.Duration) (*jwtKeySet, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.keys, c.keys != nil && time.Since(c.loadedAt) < ttl
+}
+
+func (c *jwtKeyCache) set(keys *jwtKeySet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.keys = keys
+	c.loadedAt = time.Now()
+}
+
+// jwtKeySet holds the keys signing and verifying the access tokens. The
+// most recently promoted key generated through the admin API signs them, or
+// the configured signing key, or the secret when there is neither.
+type jwtKeySet struct {
+	config  *conf.JWTConfiguration
+	stored  []*conf.JWTKey
+	signing *conf.JWTKey
+}
+
+// jwtKeys returns the keys, loading the keys generated through the admin API
+// again once the cached ones expired. While they can't be loaded, the last
+// loaded keys are used.
+func (a *API) jwtKeys(ctx context.Context) (*jwtKeySet, error) {
+	cache := &a.jwtKeyCache
+
+	keys, fresh := cache.get(a.config.JWT.KeysCacheTTL)
+	if fresh {
+		return keys, nil
+	}
+
+	loaded, err, _ := cache.loads.Do("", func() (interface{}, error) {
+		keys, err := a.loadJWTKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cache.set(keys)
+		return keys, nil
+	})
+	if err != nil {
+		if keys == nil {
+			return nil, err
+		}
+		logrus.WithError(err).Warn("Unable to load JWT keys, using the last loaded ones")
+		return keys, nil
+	}
+
+	return loaded.(*jwtKeySet), nil
+}
+
+// loadJWTKeys loads the keys generated through the admin API. Keys that
+// only verify and can't be decrypted are left out, so that they don't fail
+// every request.
+func (a *API) loadJWTKeys(ctx context.Context) (*jwtKeySet, error) {
+	stored, err := models.FindJWTKeys(a.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	signing := models.SigningJWTKey(stored)
+	keys := &jwtKeySet{config: &a.config.JWT, stored: make([]*conf.JWTKey, 0, len(stored))}
+	for _, key := range stored {
+		decrypted, err := key.Key(a.config.Security.DBEncryption.DecryptionKeys)
+		if err != nil {
+			if key == signing {
+				return nil, err
+			}
+			logrus.WithError(err).WithField("kid", key.KeyID).Error("Unable to decrypt JWT key, it doesn't verify access tokens")
+			continue
+		}
+
+		if key == signing {
+			keys.signing = decrypted
+		}
+		keys.stored = append(keys.stored, decrypted)
+	}
+
+	return keys, nil
+}
+
+// invalidateJWTKeys makes the next request load the keys generated through
+// the admin API again.
+func (a *API) invalidateJWTKeys() {
+	cache := &a.jwtKeyCache
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.loadedAt = time.Time{}
+}
+
+// signAccessToken signs the claims of an access token with the current
+// signing key.
+func (a *API) signAccessToken(ctx context.Context, claims jwt.Claims) (string, error) {
+	keys, err := a.jwtKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return keys.sign(claims)
+}
+
+// parseAccessToken verifies an access token. Until the keys generated
+// through the admin API were loaded once, only the configured keys verify
+// it. Keys are published for conf.JWTKeyPublishPeriod before they sign, so
+// every server knows them by then.
+func (a *API) parseAccessToken(ctx context.Context, bearer string) (*jwt.Token, error) {
+	keys, err := a.jwtKeys(ctx)
+	if err != nil {
+		logrus.WithError(err).Warn("Unable to load JWT keys, using the configured ones")
+		keys = &jwtKeySet{config: &a.config.JWT}
+	}
+
+	return keys.parse(bearer)
+}
+
+// signingKey returns the key signing the access tokens, or nil when the
+// secret signs them.
+func (s *jwtKeySet) signingKey() *conf.JWTKey {
+	if s.signing != nil {
+		return s.signing
+	}
+
+	return s.config.Key
+}
+
+// verifyingKeys returns the keys whose access tokens are accepted, the
+// signing key first.
+func (s *jwtKeySet) verifyingKeys() []*conf.JWTKey {
+	keys := make([]*conf.JWTKey, 0, len(s.stored)+len(s.config.VerifyingKeys)+1)
+	if s.signing != nil {
+		keys = append(keys, s.signing)
+	}
+	for _, key := range s.stored {
+		if key != s.signing {
+			keys = append(keys, key)
+		}
+	}
+	if s.config.Key != nil {
+		keys = append(keys, s.config.Key)
+	}
+
+	return append(keys, s.config.VerifyingKeys...)
+}
+
+func (s *jwtKeySet) sign(claims jwt.Claims) (string, error) {
+	if key := s.signingKey(); key != nil {
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+		token.Header["kid"] = key.KeyID
+		return token.SignedString(key.PrivateKey)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if s.config.KeyID != "" {
+		token.Header["kid"] = s.config.KeyID
+	}
+	return token.SignedString([]byte(s.config.Secret))
+}
+
+// parse verifies an access token with the key with its key ID, or else with
+// each key with its algorithm. Tokens signed with the secret, such as the
+// anon and service role keys, are verified with the secret and then the
+// earlier secrets.
+func (s *jwtKeySet) parse(bearer string) (*jwt.Token, error) {
+	methods := []string{jwt.SigningMethodHS256.Name}
+	for _, key := range s.verifyingKeys() {
+		methods = append(methods, key.Algorithm)
+	}
+	p := jwt.Parser{ValidMethods: methods}
+
+	unverified, _, err := p.ParseUnverified(bearer, &AccessTokenClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []interface{}
+	known := false
+	if unverified.Method.Alg() == jwt.SigningMethodHS256.Name {
+		known = true
+		candidates = append(candidates, []byte(s.config.Secret))
+		for _, secret := range s.config.VerificationSecrets {
+			candidates = append(candidates, []byte(secret))
+		}
+	} else {
+		kid, _ := unverified.Header["kid"].(string)
+		for _, key := range s.verifyingKeys() {
+			if key.Algorithm != unverified.Method.Alg() {
+				continue
+			}
+			if kid != "" && key.KeyID == kid {
+				known = true
+				candidates = []interface{}{key.PublicKey}
+				break
+			}
+			candidates = append(candidates, key.PublicKey)
+		}
+	}
+
+	err = errUnknownJWTKey
+	for _, candidate := range candidates {
+		var token *jwt.Token
+		token, err = p.ParseWithClaims(bearer, &AccessTokenClaims{}, func(*jwt.Token) (interface{}, error) {
+			return candidate, nil
+		})
+
+		var validationErr *jwt.ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			return token, err
+		}
+	}
+
+	if !known {
+		return nil, errUnknownJWTKey
+	}
+
+	return nil, err
+}
+
+// JWTKeyResponse is a key signing or verifying the access tokens. Keys from
+// the configuration have no creation time.
+type JWTKeyResponse struct {
+	KeyID     string     `json:"kid"`
+	Algorithm string     `json:"alg"`
+	Signing   bool       `json:"signing"`
+	Source    string     `json:"source"`
+	JWK       conf.JWK   `json:"jwk"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+
+	// PromotedAt is when a key generated through the admin API was
+	// promoted to sign the access tokens.
+	PromotedAt *time.Time `json:"promoted_at,omitempty"`
+}
+
+// JWTKeysResponse lists the keys, the signing key first.
+type JWTKeysResponse struct {
+	Keys []*JWTKeyResponse `json:"keys"`
+}
+
+// JWTKeyCreateParams are the parameters for generating a key.
+type JWTKeyCreateParams struct {
+	Algorithm string `json:"algorithm"`
+}
+
+func newJWTKeyResponse(key *conf.JWTKey, signing bool, stored *models.JWTKey) *JWTKeyResponse {
+	resp := &JWTKeyResponse{
+		KeyID:     key.KeyID,
+		Algorithm: key.Algorithm,
+		Signing:   signing,
+		Source:    "config",
+		JWK:       key.JWK(),
+	}
+
+	if stored != nil {
+		resp.Source = "database"
+		resp.CreatedAt = &stored.CreatedAt
+		resp.PromotedAt = stored.PromotedAt
+	}
+
+	return resp
+}
+
+// adminJWTKeysList lists the keys signing and verifying the access tokens.
+func (a *API) adminJWTKeysList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	keys, err := a.loadJWTKeys(ctx)
+	if err != nil {
+		return internalServerError("Error loading JWT keys").WithInternalError(err)
+	}
+
+	stored, err := models.FindJWTKeys(db)
+	if err != nil {
+		return internalServerError("Database error finding JWT keys").WithInternalError(err)
+	}
+	storedByKeyID := make(map[string]*models.JWTKey, len(stored))
+	for _, key := range stored {
+		storedByKeyID[key.KeyID] = key
+	}
+
+	signing := keys.signingKey()
+	resp := JWTKeysResponse{Keys: []*JWTKeyResponse{}}
+	for _, key := range keys.verifyingKeys() {
+		resp.Keys = append(resp.Keys, newJWTKeyResponse(key, key == signing, storedByKeyID[key.KeyID]))
+	}
+
+	return sendJSON(w, http.StatusOK, resp)
+}
+
+// adminJWTKeyCreate generates a key. It's published right away, but only
+// verifies the access tokens until it's promoted, once the servers and the
+// clients caching the JSON Web Key Set know it.
+func (a *API) adminJWTKeyCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	encryption := a.config.Security.DBEncryption
+
+	params := &JWTKeyCreateParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.Algorithm == "" {
+		params.Algorithm = "ES256"
+		if a.config.JWT.Key != nil {
+			params.Algorithm = a.config.JWT.Key.Algorithm
+		}
+	}
+
+	switch params.Algorithm {
+	case "RS256", "ES256", "EdDSA":
+	default:
+		return badRequestError(ErrorCodeValidationFailed, "JWT signing algorithm must be one of RS256, ES256 or EdDSA")
+	}
+
+	if !encryption.Encrypt {
+		return badRequestError(ErrorCodeValidationFailed, "JWT signing keys can only be stored with database encryption enabled")
+	}
+
+	stored, err := models.NewJWTKey(params.Algorithm, encryption)
+	if err != nil {
+		return internalServerError("Error generating JWT key").WithInternalError(err)
+	}
+	stored.CreatedAt = a.Now()
+
+	key, err := stored.Key(encryption.DecryptionKeys)
+	if err != nil {
+		return internalServerError("Error decrypting JWT key").WithInternalError(err)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.Create(stored); terr != nil {
+			return internalServerError("Database error saving JWT key").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.JWTKeyCreatedAction, "", map[string]interface{}{
+			"kid": stored.KeyID,
+			"alg": stored.Algorithm,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	a.invalidateJWTKeys()
+
+	return sendJSON(w, http.StatusCreated, newJWTKeyResponse(key, false, stored))
+}
+
+// findStoredJWTKey finds the key generated through the admin API with the
+// kid of the route.
+func (a *API) findStoredJWTKey(r *http.Request, tx *storage.Connection) (*models.JWTKey, *conf.JWTKey, error) {
+	stored, err := models.FindJWTKeyByKeyID(tx, chi.URLParam(r, "kid"))
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, nil, notFoundError(ErrorCodeJWTKeyNotFound, "JWT key not found, keys from the configuration can't be changed")
+		}
+		return nil, nil, internalServerError("Database error finding JWT key").WithInternalError(err)
+	}
+
+	key, err := stored.Key(a.config.Security.DBEncryption.DecryptionKeys)
+	if err != nil {
+		return nil, nil, internalServerError("Error decrypting JWT key").WithInternalError(err)
+	}
+
+	return stored, key, nil
+}
+
+// adminJWTKeyPromote makes a key generated through the admin API sign the
+// access tokens, once it has been published for conf.JWTKeyPublishPeriod.
+// The other servers pick it up once their cached keys expire.
+func (a *API) adminJWTKeyPromote(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	var stored *models.JWTKey
+	var key *conf.JWTKey
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		if stored, key, terr = a.findStoredJWTKey(r, tx); terr != nil {
+			return terr
+		}
+
+		if publishedAt := stored.CreatedAt.Add(conf.JWTKeyPublishPeriod); a.Now().Before(publishedAt) {
+			return unprocessableEntityError(ErrorCodeJWTKeyNotPublished, "JWT key can only sign access tokens after it was published for %s, at %s", conf.JWTKeyPublishPeriod, publishedAt.UTC().Format(time.RFC3339))
+		}
+
+		if terr := stored.Promote(tx, a.Now()); terr != nil {
+			return internalServerError("Database error promoting JWT key").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.JWTKeyPromotedAction, "", map[string]interface{}{
+			"kid": stored.KeyID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	a.invalidateJWTKeys()
+
+	return sendJSON(w, http.StatusOK, newJWTKeyResponse(key, true, stored))
+}
+
+// adminJWTKeyDelete deletes a key generated through the admin API, so that
+// the access tokens it signed are no longer accepted. The signing key can't
+// be deleted, another key has to be promoted first.
+func (a *API) adminJWTKeyDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	var stored *models.JWTKey
+	var key *conf.JWTKey
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		if stored, key, terr = a.findStoredJWTKey(r, tx); terr != nil {
+			return terr
+		}
+
+		all, terr := models.FindJWTKeys(tx)
+		if terr != nil {
+			return internalServerError("Database error finding JWT keys").WithInternalError(terr)
+		}
+		if signing := models.SigningJWTKey(all); signing != nil && signing.ID == stored.ID {
+			return unprocessableEntityError(ErrorCodeJWTKeyInUse, "The signing JWT key can't be deleted, promote another key first")
+		}
+
+		if terr := tx.Destroy(stored); terr != nil {
+			return internalServerError("Database error deleting JWT key").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.JWTKeyDeletedAction, "", map[string]interface{}{
+			"kid": stored.KeyID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	a.invalidateJWTKeys()
+
+	return sendJSON(w, http.StatusOK, newJWTKeyResponse(key, false, stored))
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+func TestJWTKeySet(t *testing.T) {
+	current, err := conf.GenerateJWTKey("ES256")
+	require.NoError(t, err)
+	previous, err := conf.GenerateJWTKey("EdDSA")
+	require.NoError(t, err)
+	unknown, err := conf.GenerateJWTKey("ES256")
+	require.NoError(t, err)
+
+	config := &conf.JWTConfiguration{
+		Secret:              "current-secret",
+		VerificationSecrets: []string{"previous-secret"},
+		Key:                 current,
+		VerifyingKeys:       []*conf.JWTKey{{KeyID: previous.KeyID, Algorithm: previous.Algorithm, PublicKey: previous.PublicKey}},
+	}
+	keys := &jwtKeySet{config: config}
+
+	claims := &AccessTokenClaims{Role: "authenticated"}
+
+	signed, err := keys.sign(claims)
+	require.NoError(t, err)
+	token, err := keys.parse(signed)
+	require.NoError(t, err)
+	require.Equal(t, current.KeyID, token.Header["kid"])
+
+	// tokens signed with the earlier key are still accepted
+	signed, err = (&jwtKeySet{config: &conf.JWTConfiguration{Key: previous}}).sign(claims)
+	require.NoError(t, err)
+	_, err = keys.parse(signed)
+	require.NoError(t, err)
+
+	// and so are those signed with the secrets
+	for _, secret := range []string{"current-secret", "previous-secret"} {
+		signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		require.NoError(t, err)
+		_, err = keys.parse(signed)
+		require.NoError(t, err)
+	}
+
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("other-secret"))
+	require.NoError(t, err)
+	_, err = keys.parse(signed)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errUnknownJWTKey)
+
+	signed, err = (&jwtKeySet{config: &conf.JWTConfiguration{Key: unknown}}).sign(claims)
+	require.NoError(t, err)
+	_, err = keys.parse(signed)
+	require.ErrorIs(t, err, errUnknownJWTKey)
+
+	// keys generated through the admin API only verify until promoted
+	keys.stored = []*conf.JWTKey{unknown}
+	_, err = keys.parse(signed)
+	require.NoError(t, err)
+	signed, err = keys.sign(claims)
+	require.NoError(t, err)
+	token, err = keys.parse(signed)
+	require.NoError(t, err)
+	require.Equal(t, current.KeyID, token.Header["kid"])
+
+	keys.signing = unknown
+	signed, err = keys.sign(claims)
+	require.NoError(t, err)
+	token, err = keys.parse(signed)
+	require.NoError(t, err)
+	require.Equal(t, unknown.KeyID, token.Header["kid"])
+}
+
+func TestJWTKeysWithoutDatabase(t *testing.T) {
+	api, config, err := setupAPIForTestWithCallback(func(config *conf.GlobalConfiguration, _ *storage.Connection) {
+		if config != nil {
+			config.DB.URL = "postgres://localhost:1/unavailable?connect_timeout=1"
+		}
+	})
+	require.NoError(t, err)
+	defer api.db.Close()
+
+	ctx := context.Background()
+	claims := &AccessTokenClaims{Role: "authenticated"}
+
+	// until the keys were loaded, the configured keys verify the access
+	// tokens, but none are signed
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.JWT.Secret))
+	require.NoError(t, err)
+	_, err = api.parseAccessToken(ctx, signed)
+	require.NoError(t, err)
+
+	_, err = api.signAccessToken(ctx, claims)
+	require.Error(t, err)
+
+	// afterwards the last loaded keys are used
+	key, err := conf.GenerateJWTKey("ES256")
+	require.NoError(t, err)
+	api.jwtKeyCache.set(&jwtKeySet{config: &config.JWT, stored: []*conf.JWTKey{key}, signing: key})
+	api.invalidateJWTKeys()
+
+	signed, err = api.signAccessToken(ctx, claims)
+	require.NoError(t, err)
+	token, err := api.parseAccessToken(ctx, signed)
+	require.NoError(t, err)
+	require.Equal(t, key.KeyID, token.Header["kid"])
+}
+
+type JWTKeysTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	token string
+	now   time.Time
+}
+
+func TestJWTKeys(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &JWTKeysTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *JWTKeysTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+	ts.API.invalidateJWTKeys()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+	ts.token = token
+
+	ts.now = time.Now()
+	ts.API.overrideTime = func() time.Time {
+		return ts.now
+	}
+}
+
+func (ts *JWTKeysTestSuite) TearDownTest() {
+	ts.API.overrideTime = nil
+}
+
+func (ts *JWTKeysTestSuite) request(method, path string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *JWTKeysTestSuite) createKey(algorithm string) JWTKeyResponse {
+	w := ts.request(http.MethodPost, "/admin/jwt/keys", map[string]interface{}{"algorithm": algorithm})
+	require.Equal(ts.T(), http.StatusCreated, w.Code, w.Body.String())
+
+	key := JWTKeyResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&key))
+	return key
+}
+
+func (ts *JWTKeysTestSuite) listKeys() []*JWTKeyResponse {
+	w := ts.request(http.MethodGet, "/admin/jwt/keys", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	list := JWTKeysResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&list))
+	return list.Keys
+}
+
+func (ts *JWTKeysTestSuite) TestRotate() {
+	first := ts.createKey("ES256")
+	require.False(ts.T(), first.Signing)
+	require.Equal(ts.T(), "database", first.Source)
+
+	// the key is published, but doesn't sign before it's promoted
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	require.Contains(ts.T(), w.Body.String(), first.KeyID)
+
+	signed, err := ts.API.signAccessToken(req.Context(), &AccessTokenClaims{Role: "authenticated"})
+	require.NoError(ts.T(), err)
+	token, _, err := new(jwt.Parser).ParseUnverified(signed, &AccessTokenClaims{})
+	require.NoError(ts.T(), err)
+	require.NotEqual(ts.T(), first.KeyID, token.Header["kid"])
+
+	w = ts.request(http.MethodPost, "/admin/jwt/keys/"+first.KeyID+"/promote", nil)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	ts.now = ts.now.Add(conf.JWTKeyPublishPeriod)
+	w = ts.request(http.MethodPost, "/admin/jwt/keys/"+first.KeyID+"/promote", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	signed, err = ts.API.signAccessToken(req.Context(), &AccessTokenClaims{Role: "authenticated"})
+	require.NoError(ts.T(), err)
+	token, _, err = new(jwt.Parser).ParseUnverified(signed, &AccessTokenClaims{})
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), first.KeyID, token.Header["kid"])
+
+	second := ts.createKey("EdDSA")
+	keys := ts.listKeys()
+	require.Equal(ts.T(), first.KeyID, keys[0].KeyID)
+	require.True(ts.T(), keys[0].Signing)
+	require.NotNil(ts.T(), keys[0].PromotedAt)
+	require.Equal(ts.T(), second.KeyID, keys[1].KeyID)
+	require.False(ts.T(), keys[1].Signing)
+
+	// the signing key can't be deleted
+	w = ts.request(http.MethodDelete, "/admin/jwt/keys/"+first.KeyID, nil)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	ts.now = ts.now.Add(conf.JWTKeyPublishPeriod)
+	w = ts.request(http.MethodPost, "/admin/jwt/keys/"+second.KeyID+"/promote", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	keys = ts.listKeys()
+	require.Equal(ts.T(), second.KeyID, keys[0].KeyID)
+	require.True(ts.T(), keys[0].Signing)
+
+	// tokens signed with the earlier key are still accepted until it's
+	// deleted
+	_, err = ts.API.parseJWTClaims(signed, req)
+	require.NoError(ts.T(), err)
+
+	w = ts.request(http.MethodDelete, "/admin/jwt/keys/"+first.KeyID, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	_, err = ts.API.parseJWTClaims(signed, req)
+	require.Error(ts.T(), err)
+
+	w = ts.request(http.MethodDelete, "/admin/jwt/keys/"+first.KeyID, nil)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+}
+
+func (ts *JWTKeysTestSuite) TestCreateRequiresEncryption() {
+	encryption := ts.Config.Security.DBEncryption
+	defer func() {
+		ts.Config.Security.DBEncryption = encryption
+	}()
+	ts.Config.Security.DBEncryption.Encrypt = false
+
+	w := ts.request(http.MethodPost, "/admin/jwt/keys", map[string]interface{}{"algorithm": "ES256"})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	w = ts.request(http.MethodPost, "/admin/jwt/keys", map[string]interface{}{"algorithm": "HS512"})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/fatih/structs"
+	"github.com/supabase/auth/internal/api/provider"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// ldapLogin checks an email address and password against the LDAP directory.
+// It returns no user and no error when the directory doesn't know the
+// address, so that the user logs in with their local password instead. Users
+// logging in for the first time are provisioned, and users with a local
+// account for the address are linked to their directory entry.
+//
+// When the directory can't be reached, users without an ldap identity still
+// log in with their local password.
+func (a *API) ldapLogin(ctx context.Context, r *http.Request, db *storage.Connection, email, password, aud, accountKey, ipKey string) (*models.User, error) {
+	config := a.getConfig(ctx)
+
+	ldapUser, err := provider.NewLDAPDirectory(&config.LDAP).Authenticate(email, password)
+	if err != nil {
+		if errors.Is(err, provider.ErrLDAPUserNotFound) {
+			return nil, nil
+		}
+		if errors.Is(err, provider.ErrLDAPInvalidCredentials) {
+			a.recordFailedLogin(ctx, r, db, nil, accountKey, ipKey)
+			return nil, oauthError("invalid_grant", ErrorCodeInvalidCredentials, InvalidLoginMessage)
+		}
+		observability.GetLogEntry(r).Entry.WithError(err).Error("LDAP authentication failed")
+
+		user, terr := models.FindUserByEmailAndAudience(db, email, aud)
+		if terr != nil && !models.IsNotFoundError(terr) {
+			return nil, internalServerError("Database error finding user").WithInternalError(terr)
+		}
+		if user != nil && isLDAPUser(user) {
+			return nil, internalServerError("Error authenticating with the LDAP directory").WithInternalError(err)
+		}
+		return nil, nil
+	}
+
+	if _, err := validateEmail(ldapUser.Email); err != nil {
+		return nil, internalServerError("LDAP user has an invalid email address").WithInternalError(err)
+	}
+
+	identityData := structs.Map(provider.Claims{
+		Subject:       ldapUser.DN,
+		Email:         ldapUser.Email,
+		EmailVerified: true,
+		Name:          ldapUser.Name,
+	})
+
+	var user *models.User
+	err = db.Transaction(func(tx *storage.Connection) error {
+		identity, terr := models.FindIdentityByIdAndProvider(tx, ldapUser.DN, "ldap")
+		if terr != nil && !models.IsNotFoundError(terr) {
+			return internalServerError("Database error finding identity").WithInternalError(terr)
+		}
+
+		if identity != nil {
+			if user, terr = models.FindUserByID(tx, identity.UserID); terr != nil {
+				return internalServerError("Database error finding user").WithInternalError(terr)
+			}
+			if user.IsBanned() {
+				return forbiddenError(ErrorCodeUserBanned, "User is banned")
+			}
+			identity.IdentityData = identityData
+			if terr = tx.UpdateOnly(identity, "identity_data", "last_sign_in_at"); terr != nil {
+				return internalServerError("Database error updating identity").WithInternalError(terr)
+			}
+		} else {
+			user, terr = models.FindUserByEmailAndAudience(tx, ldapUser.Email, aud)
+			if terr != nil && !models.IsNotFoundError(terr) {
+				return internalServerError("Database error finding user").WithInternalError(terr)
+			}
+
+			if user != nil && user.IsBanned() {
+				return forbiddenError(ErrorCodeUserBanned, "User is banned")
+			}
+
+			if user == nil {
+				if user, terr = models.NewUser("", ldapUser.Email, "", aud, map[string]interface{}{"full_name": ldapUser.Name}); terr != nil {
+					return internalServerError("Error creating user").WithInternalError(terr)
+				}
+				user.AppMetaData = map[string]interface{}{
+					"provider":  "ldap",
+					"providers": []string{"ldap"},
+				}
+				if user, terr = a.signupNewUser(r, tx, user); terr != nil {
+					return terr
+				}
+				if terr = models.NewAuditLogEntry(r, tx, user, models.UserSignedUpAction, "", map[string]interface{}{
+					"provider": "ldap",
+				}); terr != nil {
+					return terr
+				}
+			} else if !user.IsConfirmed() {
+				// whoever signed up with the address never proved they own
+				// it, so they lose the password they set
+				user.EncryptedPassword = ""
+				if terr = tx.UpdateOnly(user, "encrypted_password"); terr != nil {
+					return internalServerError("Database error updating user").WithInternalError(terr)
+				}
+			}
+
+			if _, terr = a.createNewIdentity(tx, user, "ldap", identityData); terr != nil {
+				return terr
+			}
+			if terr = user.UpdateAppMetaDataProviders(tx); terr != nil {
+				return internalServerError("Database error updating user").WithInternalError(terr)
+			}
+		}
+
+		if !user.IsConfirmed() {
+			if terr = user.Confirm(tx); terr != nil {
+				return internalServerError("Database error confirming user").WithInternalError(terr)
+			}
+		}
+
+		if len(config.LDAP.GroupRoles) > 0 {
+			role := ldapUser.Role(config.LDAP.GroupRoles)
+			if role == "" {
+				role = config.JWT.DefaultGroupName
+			}
+			if user.Role != role {
+				if terr = user.SetRole(tx, role); terr != nil {
+					return internalServerError("Database error updating user").WithInternalError(terr)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// isLDAPUser reports whether the user is linked to an entry of the LDAP
+// directory, which checks their password.
+func isLDAPUser(user *models.User) bool {
+	for _, identity := range user.Identities {
+		if identity.Provider == "ldap" {
+			return true
+		}
+	}
+	return false
+}
@@ -2,22 +2,44 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// ListenAndServe starts the REST API
-func (a *API) ListenAndServe(ctx context.Context, hostAndPort string) {
+// defaultShutdownTimeout is used when API_SHUTDOWN_TIMEOUT isn't set.
+const defaultShutdownTimeout = time.Minute
+
+// ListenAndServe starts the REST API and blocks until it has shut down. The
+// server shuts down gracefully when ctx is done or on SIGTERM and SIGINT.
+func (a *API) ListenAndServe(ctx context.Context, hostAndPort string) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	listener, err := net.Listen("tcp", hostAndPort)
+	if err != nil {
+		return err
+	}
+
+	return a.Serve(ctx, listener)
+}
+
+// Serve serves the REST API on listener until ctx is done, then waits for
+// active requests to complete for at most API_SHUTDOWN_TIMEOUT.
+func (a *API) Serve(ctx context.Context, listener net.Listener) error {
 	baseCtx, cancel := context.WithCancel(context.Background())
 
 	log := logrus.WithField("component", "api")
 
 	server := &http.Server{
-		Addr:              hostAndPort,
 		Handler:           a.handler,
 		ReadHeaderTimeout: 2 * time.Second, // to mitigate a Slowloris attack
 		BaseContext: func(net.Listener) context.Context {
@@ -25,23 +47,191 @@ func (a *API) ListenAndServe(ctx context.Context, hostAndPort string) {
 		},
 	}
 
-	cleanupWaitGroup.Add(1)
-	go func() {
-		defer cleanupWaitGroup.Done()
+	var certificates *certificateReloader
+	if a.config.API.TLSEnabled() {
+		var err error
+		certificates, err = newCertificateReloader(a.config.API.TLSCertFile, a.config.API.TLSKeyFile)
+		if err != nil {
+			cancel()
+			listener.Close()
+			return err
+		}
 
-		<-ctx.Done()
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certificates.getCertificate,
+		}
+	}
+
+	a.serverMutex.Lock()
+	a.server = server
+	a.baseContext = baseCtx
+	a.cancelBaseContext = cancel
+	a.serverMutex.Unlock()
+
+	// SIGHUP is always handled so that it doesn't terminate the process,
+	// even when there are no certificates to reload
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	defer signal.Stop(reloadSignals)
 
-		defer cancel() // close baseContext
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
 
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Minute)
-		defer shutdownCancel()
+	go watchReloadSignal(watchCtx, reloadSignals, certificates)
 
-		if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.Canceled) {
-			log.WithError(err).Error("shutdown failed")
+	if interval := a.config.DB.CleanupInterval; interval > 0 && a.cleanup != nil {
+		go a.runCleanup(watchCtx, interval)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if certificates != nil {
+			serveErr <- server.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- server.Serve(listener)
 		}
 	}()
 
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		log.WithError(err).Fatal("http server listen failed")
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			// Shutdown was called directly
+			return nil
+		}
+
+		cancel()
+		return err
+
+	case <-ctx.Done():
+	}
+
+	cleanupWaitGroup.Add(1)
+	defer cleanupWaitGroup.Done()
+
+	log.Info("shutting down, waiting for active requests to complete")
+
+	timeout := a.config.API.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), timeout)
+	defer shutdownCancel()
+
+	if err := a.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Error("shutdown failed")
+		return err
+	}
+
+	return nil
+}
+
+// backgroundContext returns the context of work that outlives its request,
+// which is done once the server has shut down.
+func (a *API) backgroundContext() context.Context {
+	a.serverMutex.Lock()
+	defer a.serverMutex.Unlock()
+
+	if a.baseContext == nil {
+		return context.Background()
+	}
+	return a.baseContext
+}
+
+// Shutdown stops accepting new connections, waits until active requests
+// have completed or ctx is done and then closes the database connection.
+func (a *API) Shutdown(ctx context.Context) error {
+	a.serverMutex.Lock()
+	server := a.server
+	cancel := a.cancelBaseContext
+	a.serverMutex.Unlock()
+
+	var err error
+	a.shutdownOnce.Do(func() {
+		if server != nil {
+			err = server.Shutdown(ctx)
+			if err != nil {
+				// drain timeout exceeded, drop the remaining connections
+				server.Close()
+			}
+		}
+
+		if cancel != nil {
+			cancel() // close baseContext
+		}
+
+		if a.db != nil {
+			if dbErr := a.db.Close(); dbErr != nil && err == nil {
+				err = dbErr
+			}
+		}
+	})
+
+	return err
+}
+
+// certificateReloader serves the TLS certificate from the configured files
+// and allows them to be replaced without restarting the server.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.certificate = &certificate
+	return nil
+}
+
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.certificate, nil
+}
+
+// watchReloadSignal reloads the certificates on every signal until ctx is
+// done. A failed reload keeps serving the previous certificate.
+func watchReloadSignal(ctx context.Context, signals <-chan os.Signal, certificates *certificateReloader) {
+	log := logrus.WithField("component", "api")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-signals:
+			if certificates == nil {
+				log.Info("received SIGHUP, nothing to reload")
+				continue
+			}
+
+			if err := certificates.reload(); err != nil {
+				log.WithError(err).Error("unable to reload TLS certificate, keeping the previous one")
+				continue
+			}
+
+			log.Info("reloaded TLS certificate")
+		}
 	}
 }
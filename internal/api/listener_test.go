@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+)
+
+// slowHandler responds once release is closed, signalling on started when
+// the request has arrived.
+func slowHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("done"))
+	})
+}
+
+func startListenerForTest(t *testing.T, ctx context.Context, config *conf.GlobalConfiguration, handler http.Handler) (*API, string, <-chan error) {
+	api := &API{config: config, handler: handler}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- api.Serve(ctx, listener)
+	}()
+
+	return api, listener.Addr().String(), served
+}
+
+func TestListenerGracefulShutdown(t *testing.T) {
+	config := &conf.GlobalConfiguration{}
+	config.API.ShutdownTimeout = 5 * time.Second
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, served := startListenerForTest(t, ctx, config, slowHandler(started, release))
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		response <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	// the server keeps waiting for the active request
+	select {
+	case err := <-served:
+		t.Fatalf("server stopped before the active request completed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// and doesn't accept new connections in the meantime
+	_, err := net.DialTimeout("tcp", addr, time.Second)
+	require.Error(t, err)
+
+	close(release)
+
+	res := <-response
+	require.NoError(t, res.err)
+	require.Equal(t, "done", res.body)
+
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestListenerShutdownTimeout(t *testing.T) {
+	config := &conf.GlobalConfiguration{}
+	config.API.ShutdownTimeout = 100 * time.Millisecond
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, served := startListenerForTest(t, ctx, config, slowHandler(started, release))
+
+	go http.Get("http://" + addr + "/slow")
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-served:
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not give up waiting for the active request")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	config := &conf.GlobalConfiguration{}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	api, addr, served := startListenerForTest(t, context.Background(), config, slowHandler(started, release))
+
+	response := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			res.Body.Close()
+		}
+		response <- err
+	}()
+
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- api.Shutdown(context.Background())
+	}()
+
+	require.NoError(t, <-served)
+
+	close(release)
+	require.NoError(t, <-response)
+	require.NoError(t, <-shutdown)
+
+	// further calls are no-ops
+	require.NoError(t, api.Shutdown(context.Background()))
+}
+
+func writeTestCertificate(t *testing.T, dir string, serial int64) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestListenerTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, 1)
+
+	config := &conf.GlobalConfiguration{}
+	config.API.TLSCertFile = certFile
+	config.API.TLSKeyFile = keyFile
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	_, addr, served := startListenerForTest(t, ctx, config, handler)
+
+	servedSerial := func() int64 {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // #nosec G402 -- self-signed test certificate
+			},
+		}
+		res, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			return 0
+		}
+		defer res.Body.Close()
+
+		return res.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	require.Equal(t, int64(1), servedSerial())
+
+	// replace the certificate and reload it
+	writeTestCertificate(t, dir, 2)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	require.Eventually(t, func() bool {
+		return servedSerial() == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-served)
+}
+
+func TestListenerTLSReloadFailureKeepsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, 1)
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0600))
+	require.Error(t, reloader.reload())
+
+	certificate, err := reloader.getCertificate(nil)
+	require.NoError(t, err)
+
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, int64(1), parsed.SerialNumber.Int64())
+}
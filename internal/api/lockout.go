@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+// loginAttemptKeys returns the keys counting the failed logins of an email
+// address or phone number and of the client's IP address.
+func loginAttemptKeys(r *http.Request, aud, email, phone string) (accountKey, ipKey string) {
+	if email != "" {
+		accountKey = models.EmailLoginAttemptKey(aud, email)
+	} else {
+		accountKey = models.PhoneLoginAttemptKey(aud, phone)
+	}
+
+	return accountKey, models.IPLoginAttemptKey(utilities.GetClientIP(r))
+}
+
+// checkLoginLockout refuses the login while the account or the client's IP
+// address is locked. The account key doesn't depend on whether the account
+// exists, so locked nonexistent accounts can't be told apart from locked
+// existing ones.
+func (a *API) checkLoginLockout(ctx context.Context, w http.ResponseWriter, conn *storage.Connection, keys ...string) error {
+	config := a.config.Security.LoginLockout
+	if !config.Enabled || config.Mode != conf.LoginLockoutModeLock {
+		return nil
+	}
+
+	lockout, err := models.FindLoginLockout(conn.WithContext(ctx), keys...)
+	if err != nil {
+		return internalServerError("Database error checking login lockout").WithInternalError(err)
+	}
+
+	if lockout == nil {
+		return nil
+	}
+
+	retryAfter := time.Until(*lockout.LockedUntil)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return tooManyRequestsError(ErrorCodeAccountLocked, "Too many failed login attempts, try again later")
+}
+
+// recordFailedLogin counts a failed login of the account and of the client's
+// IP address. Depending on the mode, reaching the limit locks the account or
+// IP address, or delays the response. The user is nil for nonexistent
+// accounts.
+//
+// Failures to count are only logged, the login has failed either way.
+func (a *API) recordFailedLogin(ctx context.Context, r *http.Request, conn *storage.Connection, user *models.User, accountKey, ipKey string) {
+	config := a.config.Security.LoginLockout
+	if !config.Enabled {
+		return
+	}
+
+	log := observability.GetLogEntry(r).Entry
+
+	// excess counts the failures past the limit, -1 while it hasn't been
+	// reached
+	excess := -1
+	err := conn.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+		limits := []struct {
+			key         string
+			maxAttempts int
+		}{
+			{key: accountKey, maxAttempts: config.MaxAttempts},
+			{key: ipKey, maxAttempts: config.MaxAttemptsPerIP},
+		}
+
+		for _, limit := range limits {
+			if limit.maxAttempts <= 0 {
+				continue
+			}
+
+			attempt, terr := models.RecordFailedLogin(tx, limit.key, config.Duration)
+			if terr != nil {
+				return terr
+			}
+
+			if attempt.FailedCount < limit.maxAttempts {
+				continue
+			}
+
+			if config.Mode == conf.LoginLockoutModeDelay {
+				excess = max(excess, attempt.FailedCount-limit.maxAttempts)
+				continue
+			}
+
+			if terr := attempt.Lock(tx, config.Duration); terr != nil {
+				return terr
+			}
+
+			if user != nil {
+				if terr := models.NewAuditLogEntry(r, tx, user, models.UserLockedAction, "", map[string]interface{}{
+					"locked_until": attempt.LockedUntil,
+					"per_ip":       limit.key == ipKey,
+				}); terr != nil {
+					return terr
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("unable to record failed login")
+		return
+	}
+
+	if delay := loginLockoutDelay(config, excess); delay > 0 {
+		_ = utilities.Sleep(ctx, delay)
+	}
+}
+
+// loginLockoutDelay returns the delay of a failed login in the delay mode.
+// Every failure past the limit doubles the delay, up to MaxDelay.
+func loginLockoutDelay(config conf.LoginLockoutConfiguration, excess int) time.Duration {
+	if config.Mode != conf.LoginLockoutModeDelay || excess < 0 {
+		return 0
+	}
+
+	delay := config.Delay
+	for i := 0; i < excess && delay < config.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, config.MaxDelay)
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+)
+
+func TestLoginLockoutDelay(t *testing.T) {
+	config := conf.LoginLockoutConfiguration{
+		Enabled:  true,
+		Mode:     conf.LoginLockoutModeDelay,
+		Delay:    time.Second,
+		MaxDelay: 5 * time.Second,
+	}
+
+	require.Equal(t, time.Duration(0), loginLockoutDelay(config, -1))
+	require.Equal(t, time.Second, loginLockoutDelay(config, 0))
+	require.Equal(t, 2*time.Second, loginLockoutDelay(config, 1))
+	require.Equal(t, 4*time.Second, loginLockoutDelay(config, 2))
+	require.Equal(t, 5*time.Second, loginLockoutDelay(config, 3))
+	require.Equal(t, 5*time.Second, loginLockoutDelay(config, 1000))
+
+	// the lock mode doesn't delay responses
+	config.Mode = conf.LoginLockoutModeLock
+	require.Equal(t, time.Duration(0), loginLockoutDelay(config, 2))
+}
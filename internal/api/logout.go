@@ -48,17 +48,7 @@ func (a *API) Logout(w http.ResponseWriter, r *http.Request) error {
 			return terr
 		}
 
-		//exhaustive:ignore Default case is handled below.
-		switch scope {
-		case LogoutLocal:
-			return models.LogoutSession(tx, s.ID)
-
-		case LogoutOthers:
-			return models.LogoutAllExceptMe(tx, s.ID, u.ID)
-		}
-
-		// default mode, log out everywhere
-		return models.Logout(tx, u.ID)
+		return logoutScope(tx, u.ID, s.ID, scope)
 	})
 	if err != nil {
 		return internalServerError("Error logging out user").WithInternalError(err)
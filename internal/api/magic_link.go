@@ -41,7 +41,7 @@ func (p *MagicLinkParams) Validate() error {
 func (a *API) MagicLink(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	db := a.db.WithContext(ctx)
-	config := a.config
+	config := a.getConfig(ctx)
 
 	if !config.External.Email.Enabled {
 		return unprocessableEntityError(ErrorCodeEmailProviderDisabled, "Email logins are disabled")
@@ -75,7 +75,9 @@ func (a *API) MagicLink(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 	if user != nil {
-		isNewUser = !user.IsConfirmed()
+		// with signups disabled, existing users that haven't confirmed
+		// yet, such as invited users, are sent a magic link instead
+		isNewUser = !user.IsConfirmed() && !config.DisableSignup
 	}
 	if isNewUser {
 		// User either doesn't exist or hasn't completed the signup process.
@@ -138,13 +140,13 @@ func (a *API) MagicLink(w http.ResponseWriter, r *http.Request) error {
 		if terr := models.NewAuditLogEntry(r, tx, user, models.UserRecoveryRequestedAction, "", nil); terr != nil {
 			return terr
 		}
-		return a.sendMagicLink(r, tx, user, flowType)
+		return a.handleEmailSendLimit(r, "magiclink", a.sendMagicLink(r, tx, user, flowType))
 	})
 	if err != nil {
 		if errors.Is(err, MaxFrequencyLimitError) {
 			return tooManyRequestsError(ErrorCodeOverEmailSendRateLimit, generateFrequencyLimitErrorMessage(user.RecoverySentAt, config.SMTP.MaxFrequency))
 		}
-		return internalServerError("Error sending magic link").WithInternalError(err)
+		return mailerError("Error sending magic link", err)
 	}
 
 	return sendJSON(w, http.StatusOK, make(map[string]string))
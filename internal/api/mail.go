@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/hooks"
 	mail "github.com/supabase/auth/internal/mailer"
 
@@ -15,14 +16,32 @@ import (
 	"github.com/supabase/auth/internal/api/provider"
 	"github.com/supabase/auth/internal/crypto"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
 	"github.com/supabase/auth/internal/utilities"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 var (
 	MaxFrequencyLimitError error = errors.New("frequency limit reached")
+	EmailSendLimitError    error = errors.New("email send limit reached")
 )
 
+// mailerError converts a failure to send an email into an HTTP error. A
+// recipient that the mail server rejected is reported to the client, any
+// other failure is an internal error described by message.
+func mailerError(message string, err error) *HTTPError {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	if errors.Is(err, mail.ErrEmailUndeliverable) {
+		return unprocessableEntityError(ErrorCodeEmailAddressNotDeliverable, "Email could not be delivered to this address").WithInternalError(err)
+	}
+	return internalServerError(message).WithInternalError(err)
+}
+
 type GenerateLinkParams struct {
 	Type       string                 `json:"type"`
 	Email      string                 `json:"email"`
@@ -44,8 +63,8 @@ type GenerateLinkResponse struct {
 func (a *API) adminGenerateLink(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	db := a.db.WithContext(ctx)
-	config := a.config
-	mailer := a.Mailer()
+	config := a.getConfig(ctx)
+	mailer := a.Mailer(ctx)
 	adminUser := getAdminUser(ctx)
 	params := &GenerateLinkParams{}
 	if err := retrieveRequestParams(r, params); err != nil {
@@ -84,7 +103,7 @@ func (a *API) adminGenerateLink(w http.ResponseWriter, r *http.Request) error {
 
 	var url string
 	now := time.Now()
-	otp, err := crypto.GenerateOtp(config.Mailer.OtpLength)
+	otp, err := generateOtp(config.Mailer.OtpLength, config.Mailer.OtpAlphabet)
 	if err != nil {
 		// OTP generation must always succeed
 		panic(err)
@@ -153,7 +172,7 @@ func (a *API) adminGenerateLink(w http.ResponseWriter, r *http.Request) error {
 					return terr
 				}
 
-				user, terr = a.signupNewUser(tx, user)
+				user, terr = a.signupNewUser(r, tx, user)
 				if terr != nil {
 					return terr
 				}
@@ -198,7 +217,7 @@ func (a *API) adminGenerateLink(w http.ResponseWriter, r *http.Request) error {
 				// password here to generate a new user, use
 				// signupUser which is a model generated from
 				// SignupParams above
-				user, terr = a.signupNewUser(tx, signupUser)
+				user, terr = a.signupNewUser(r, tx, signupUser)
 				if terr != nil {
 					return terr
 				}
@@ -297,7 +316,7 @@ func (a *API) adminGenerateLink(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (a *API) sendConfirmation(r *http.Request, tx *storage.Connection, u *models.User, flowType models.FlowType) error {
-	config := a.config
+	config := a.getConfig(r.Context())
 	maxFrequency := config.SMTP.MaxFrequency
 	otpLength := config.Mailer.OtpLength
 
@@ -305,8 +324,11 @@ func (a *API) sendConfirmation(r *http.Request, tx *storage.Connection, u *model
 	if err := validateSentWithinFrequencyLimit(u.ConfirmationSentAt, maxFrequency); err != nil {
 		return err
 	}
+	if err := a.checkEmailSendLimit(tx, u.GetEmail(), mail.SignupVerification); err != nil {
+		return err
+	}
 	oldToken := u.ConfirmationToken
-	otp, err := crypto.GenerateOtp(otpLength)
+	otp, err := generateOtp(otpLength, config.Mailer.OtpAlphabet)
 	if err != nil {
 		// OTP generation must succeeed
 		panic(err)
@@ -334,11 +356,11 @@ func (a *API) sendConfirmation(r *http.Request, tx *storage.Connection, u *model
 }
 
 func (a *API) sendInvite(r *http.Request, tx *storage.Connection, u *models.User) error {
-	config := a.config
+	config := a.getConfig(r.Context())
 	otpLength := config.Mailer.OtpLength
 	var err error
 	oldToken := u.ConfirmationToken
-	otp, err := crypto.GenerateOtp(otpLength)
+	otp, err := generateOtp(otpLength, config.Mailer.OtpAlphabet)
 	if err != nil {
 		// OTP generation must succeed
 		panic(err)
@@ -366,16 +388,19 @@ func (a *API) sendInvite(r *http.Request, tx *storage.Connection, u *models.User
 }
 
 func (a *API) sendPasswordRecovery(r *http.Request, tx *storage.Connection, u *models.User, flowType models.FlowType) error {
-	config := a.config
+	config := a.getConfig(r.Context())
 	maxFrequency := config.SMTP.MaxFrequency
 	otpLength := config.Mailer.OtpLength
 	var err error
 	if err := validateSentWithinFrequencyLimit(u.RecoverySentAt, maxFrequency); err != nil {
 		return err
 	}
+	if err := a.checkEmailSendLimit(tx, u.GetEmail(), mail.RecoveryVerification); err != nil {
+		return err
+	}
 
 	oldToken := u.RecoveryToken
-	otp, err := crypto.GenerateOtp(otpLength)
+	otp, err := generateOtp(otpLength, config.Mailer.OtpAlphabet)
 	if err != nil {
 		// OTP generation must succeed
 		panic(err)
@@ -403,7 +428,7 @@ func (a *API) sendPasswordRecovery(r *http.Request, tx *storage.Connection, u *m
 }
 
 func (a *API) sendReauthenticationOtp(r *http.Request, tx *storage.Connection, u *models.User) error {
-	config := a.config
+	config := a.getConfig(r.Context())
 	maxFrequency := config.SMTP.MaxFrequency
 	otpLength := config.Mailer.OtpLength
 	var err error
@@ -413,7 +438,7 @@ func (a *API) sendReauthenticationOtp(r *http.Request, tx *storage.Connection, u
 	}
 
 	oldToken := u.ReauthenticationToken
-	otp, err := crypto.GenerateOtp(otpLength)
+	otp, err := generateOtp(otpLength, config.Mailer.OtpAlphabet)
 	if err != nil {
 		// OTP generation must succeed
 		panic(err)
@@ -440,7 +465,7 @@ func (a *API) sendReauthenticationOtp(r *http.Request, tx *storage.Connection, u
 }
 
 func (a *API) sendMagicLink(r *http.Request, tx *storage.Connection, u *models.User, flowType models.FlowType) error {
-	config := a.config
+	config := a.getConfig(r.Context())
 	otpLength := config.Mailer.OtpLength
 	maxFrequency := config.SMTP.MaxFrequency
 	var err error
@@ -449,9 +474,12 @@ func (a *API) sendMagicLink(r *http.Request, tx *storage.Connection, u *models.U
 	if err := validateSentWithinFrequencyLimit(u.RecoverySentAt, maxFrequency); err != nil {
 		return err
 	}
+	if err := a.checkEmailSendLimit(tx, u.GetEmail(), mail.MagicLinkVerification); err != nil {
+		return err
+	}
 
 	oldToken := u.RecoveryToken
-	otp, err := crypto.GenerateOtp(otpLength)
+	otp, err := generateOtp(otpLength, config.Mailer.OtpAlphabet)
 	if err != nil {
 		// OTP generation must succeed
 		panic(err)
@@ -481,14 +509,17 @@ func (a *API) sendMagicLink(r *http.Request, tx *storage.Connection, u *models.U
 
 // sendEmailChange sends out an email change token to the new email.
 func (a *API) sendEmailChange(r *http.Request, tx *storage.Connection, u *models.User, email string, flowType models.FlowType) error {
-	config := a.config
+	config := a.getConfig(r.Context())
 	otpLength := config.Mailer.OtpLength
 	var err error
 	if err := validateSentWithinFrequencyLimit(u.EmailChangeSentAt, config.SMTP.MaxFrequency); err != nil {
 		return err
 	}
+	if err := a.checkEmailSendLimit(tx, email, mail.EmailChangeVerification); err != nil {
+		return err
+	}
 
-	otpNew, err := crypto.GenerateOtp(otpLength)
+	otpNew, err := generateOtp(otpLength, config.Mailer.OtpAlphabet)
 	if err != nil {
 		// OTP generation must succeed
 		panic(err)
@@ -499,7 +530,7 @@ func (a *API) sendEmailChange(r *http.Request, tx *storage.Connection, u *models
 
 	otpCurrent := ""
 	if config.Mailer.SecureEmailChangeEnabled && u.GetEmail() != "" {
-		otpCurrent, err = crypto.GenerateOtp(otpLength)
+		otpCurrent, err = generateOtp(otpLength, config.Mailer.OtpAlphabet)
 		if err != nil {
 			// OTP generation must succeed
 			panic(err)
@@ -559,6 +590,15 @@ func validateEmail(email string) (string, error) {
 	return strings.ToLower(email), nil
 }
 
+// checkEmailDomain rejects email addresses with a domain that isn't allowed
+// to sign up.
+func (a *API) checkEmailDomain(email string) error {
+	if !a.config.Signup.IsEmailDomainAllowed(email) {
+		return unprocessableEntityError(ErrorCodeEmailDomainNotAllowed, "Signups from this email domain are not allowed")
+	}
+	return nil
+}
+
 func validateSentWithinFrequencyLimit(sentAt *time.Time, frequency time.Duration) error {
 	if sentAt != nil && sentAt.Add(frequency).After(time.Now()) {
 		return MaxFrequencyLimitError
@@ -566,13 +606,79 @@ func validateSentWithinFrequencyLimit(sentAt *time.Time, frequency time.Duration
 	return nil
 }
 
+// checkEmailSendLimit counts an email of emailType to the address against
+// the configured send limits. It returns EmailSendLimitError if the address
+// has received too many emails of that type.
+func (a *API) checkEmailSendLimit(tx *storage.Connection, email, emailType string) error {
+	limit := &a.config.Mailer.SendLimit
+	if !limit.Enabled() || email == "" {
+		return nil
+	}
+
+	ok, err := models.ReserveEmailSend(tx, email, emailType, limit.MaxPerHour, limit.MinInterval)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return EmailSendLimitError
+	}
+	return nil
+}
+
+// handleEmailSendLimit handles an email that wasn't sent because the address
+// reached its send limit. Endpoints that must not reveal whether an address
+// has an account drop the email silently and succeed as usual, the others
+// respond with 429. Any other error is returned unchanged.
+func (a *API) handleEmailSendLimit(r *http.Request, endpoint string, err error) error {
+	if !errors.Is(err, EmailSendLimitError) {
+		return err
+	}
+
+	if a.config.Mailer.SendLimit.IsSilent(endpoint) {
+		observability.GetLogEntry(r).Entry.WithField("endpoint", endpoint).Warn("email send limit reached, email not sent")
+		return nil
+	}
+
+	return tooManyRequestsError(ErrorCodeOverEmailSendRateLimit, "Too many emails have been sent to this address, please try again later")
+}
+
+var (
+	mailerSendsCounter      = observability.ObtainMetricCounter("gotrue_mailer_sends", "Number of emails sent by mailer and email type")
+	mailerSendErrorsCounter = observability.ObtainMetricCounter("gotrue_mailer_send_errors", "Number of emails that failed to send by mailer and email type")
+)
+
+// sendEmail sends the email and counts the outcome.
 func (a *API) sendEmail(r *http.Request, tx *storage.Connection, u *models.User, emailActionType, otp, otpNew, tokenHashWithPrefix string) error {
-	mailer := a.Mailer()
+	if err := a.requireFeature(r.Context(), conf.FeatureEmail); err != nil {
+		return err
+	}
+
+	config := a.getConfig(r.Context())
+	mailerName := "smtp"
+	if config.UsesSendEmailHook() {
+		mailerName = "send_email_hook"
+	}
+	attributes := metric.WithAttributes(
+		attribute.String("mailer", mailerName),
+		attribute.String("type", emailActionType),
+	)
+
+	mailerSendsCounter.Add(r.Context(), 1, attributes)
+	err := a.deliverEmail(r, tx, u, emailActionType, otp, otpNew, tokenHashWithPrefix)
+	if err != nil {
+		mailerSendErrorsCounter.Add(r.Context(), 1, attributes)
+	}
+
+	return err
+}
+
+func (a *API) deliverEmail(r *http.Request, tx *storage.Connection, u *models.User, emailActionType, otp, otpNew, tokenHashWithPrefix string) error {
 	ctx := r.Context()
-	config := a.config
+	config := a.getConfig(ctx)
+	mailer := a.Mailer(ctx)
 	referrerURL := utilities.GetReferrer(r, config)
 	externalURL := getExternalHost(ctx)
-	if config.Hook.SendEmail.Enabled {
+	if config.UsesSendEmailHook() {
 		emailData := mail.EmailData{
 			Token:           otp,
 			EmailActionType: emailActionType,
@@ -589,7 +695,7 @@ func (a *API) sendEmail(r *http.Request, tx *storage.Connection, u *models.User,
 			EmailData: emailData,
 		}
 		output := hooks.SendEmailOutput{}
-		return a.invokeHook(tx, r, &input, &output, a.config.Hook.SendEmail.URI)
+		return a.invokeHook(tx, r, &input, &output, config.Hook.SendEmail.URI)
 	}
 
 	switch emailActionType {
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/gobwas/glob"
 	"github.com/golang-jwt/jwt"
@@ -198,6 +199,80 @@ func (ts *MailTestSuite) TestGenerateLink() {
 	}
 }
 
+func (ts *MailTestSuite) TestEmailSendLimit() {
+	defer func(maxFrequency time.Duration, sendLimit conf.EmailSendLimitConfiguration) {
+		ts.Config.SMTP.MaxFrequency = maxFrequency
+		ts.Config.Mailer.SendLimit = sendLimit
+	}(ts.Config.SMTP.MaxFrequency, ts.Config.Mailer.SendLimit)
+
+	ts.Config.SMTP.MaxFrequency = 0
+	ts.Config.Mailer.SendLimit = conf.EmailSendLimitConfiguration{
+		MaxPerHour:      2,
+		SilentEndpoints: []string{"magiclink"},
+	}
+
+	// magic links for unconfirmed users are signups
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Update(u))
+
+	request := func(path string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"email": "test@example.com",
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "http://localhost"+path, &buffer)
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	recoverySentAt := func() *time.Time {
+		u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+		require.NoError(ts.T(), err)
+		return u.RecoverySentAt
+	}
+
+	for i := 0; i < 2; i++ {
+		require.Equal(ts.T(), http.StatusOK, request("/recover").Code)
+	}
+
+	// /recover reports that the limit has been reached
+	w := request("/recover")
+	require.Equal(ts.T(), http.StatusTooManyRequests, w.Code)
+	data := HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Equal(ts.T(), ErrorCodeOverEmailSendRateLimit, data.ErrorCode)
+
+	// magic links are limited separately
+	for i := 0; i < 2; i++ {
+		require.Equal(ts.T(), http.StatusOK, request("/magiclink").Code)
+	}
+	sentAt := recoverySentAt()
+	require.NotNil(ts.T(), sentAt)
+
+	// /magiclink drops the email silently so that it can't be used to find
+	// out whether the address has an account
+	require.Equal(ts.T(), http.StatusOK, request("/magiclink").Code)
+	require.True(ts.T(), sentAt.Equal(*recoverySentAt()), "no magic link should have been sent")
+
+	ts.Config.Mailer.SendLimit.SilentEndpoints = nil
+	require.Equal(ts.T(), http.StatusTooManyRequests, request("/magiclink").Code)
+
+	// the limit resets after an hour
+	require.NoError(ts.T(), ts.API.db.RawQuery("update "+models.EmailSendCounter{}.TableName()+" set window_start = now() - interval '61 minutes', last_sent_at = now() - interval '61 minutes'").Exec())
+	require.Equal(ts.T(), http.StatusOK, request("/recover").Code)
+
+	// a minimum interval applies on top of the hourly limit
+	ts.Config.Mailer.SendLimit.MinInterval = time.Minute
+	require.Equal(ts.T(), http.StatusTooManyRequests, request("/recover").Code)
+}
+
 func (ts *MailTestSuite) setURIAllowListMap(uris ...string) {
 	for _, uri := range uris {
 		g := glob.MustCompile(uri, '.', '/')
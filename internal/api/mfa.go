@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -27,6 +28,9 @@ type EnrollFactorParams struct {
 	FriendlyName string `json:"friendly_name"`
 	FactorType   string `json:"factor_type"`
 	Issuer       string `json:"issuer"`
+	// Phone is the phone number of a phone factor, by default the
+	// confirmed phone number of the user.
+	Phone string `json:"phone"`
 }
 
 type TOTPObject struct {
@@ -36,20 +40,31 @@ type TOTPObject struct {
 }
 
 type EnrollFactorResponse struct {
-	ID           uuid.UUID  `json:"id"`
-	Type         string     `json:"type"`
-	FriendlyName string     `json:"friendly_name"`
-	TOTP         TOTPObject `json:"totp,omitempty"`
+	ID           uuid.UUID   `json:"id"`
+	Type         string      `json:"type"`
+	FriendlyName string      `json:"friendly_name"`
+	TOTP         *TOTPObject `json:"totp,omitempty"`
+	Phone        string      `json:"phone,omitempty"`
 }
 
 type VerifyFactorParams struct {
 	ChallengeID uuid.UUID `json:"challenge_id"`
 	Code        string    `json:"code"`
+
+	// CredentialResponse is the response of the authenticator to the
+	// challenge of a WebAuthn factor.
+	CredentialResponse json.RawMessage `json:"credential_response,omitempty"`
+}
+
+type ChallengeFactorParams struct {
+	// Channel is how the code of a phone factor is sent, sms or whatsapp.
+	Channel string `json:"channel"`
 }
 
 type ChallengeFactorResponse struct {
-	ID        uuid.UUID `json:"id"`
-	ExpiresAt int64     `json:"expires_at"`
+	ID        uuid.UUID                `json:"id"`
+	ExpiresAt int64                    `json:"expires_at"`
+	WebAuthn  *WebAuthnChallengeObject `json:"webauthn,omitempty"`
 }
 
 type UnenrollFactorResponse struct {
@@ -65,7 +80,7 @@ func (a *API) EnrollFactor(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	user := getUser(ctx)
 	session := getSession(ctx)
-	config := a.config
+	config := a.getConfig(ctx)
 	db := a.db.WithContext(ctx)
 
 	if session == nil || user == nil {
@@ -77,8 +92,18 @@ func (a *API) EnrollFactor(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	if params.FactorType != models.TOTP {
-		return badRequestError(ErrorCodeValidationFailed, "factor_type needs to be totp")
+	switch params.FactorType {
+	case models.TOTP:
+	case models.WebAuthn:
+		if !config.WebAuthn.Enabled {
+			return badRequestError(ErrorCodeWebAuthnDisabled, "WebAuthn is disabled")
+		}
+	case models.Phone:
+		if !config.MFA.Phone.Enabled {
+			return badRequestError(ErrorCodeMFAPhoneDisabled, "MFA with phone factors is disabled")
+		}
+	default:
+		return badRequestError(ErrorCodeValidationFailed, "factor_type needs to be totp, webauthn or phone")
 	}
 
 	issuer := ""
@@ -114,8 +139,22 @@ func (a *API) EnrollFactor(w http.ResponseWriter, r *http.Request) error {
 		return forbiddenError(ErrorCodeTooManyEnrolledMFAFactors, "Maximum number of verified factors reached, unenroll to continue")
 	}
 
-	if numVerifiedFactors > 0 && !session.IsAAL2() {
-		return forbiddenError(ErrorCodeInsufficientAAL, "AAL2 required to enroll a new factor")
+	if params.FactorType == models.WebAuthn {
+		// the credential is registered with the first challenge
+		factor := models.NewFactor(user, params.FriendlyName, params.FactorType, models.FactorStateUnverified)
+		if err := a.createFactor(r, db, user, factor); err != nil {
+			return err
+		}
+
+		return sendJSON(w, http.StatusOK, &EnrollFactorResponse{
+			ID:           factor.ID,
+			Type:         models.WebAuthn,
+			FriendlyName: factor.FriendlyName,
+		})
+	}
+
+	if params.FactorType == models.Phone {
+		return a.enrollPhoneFactor(w, r, params)
 	}
 
 	key, err := totp.Generate(totp.GenerateOpts{
@@ -141,7 +180,26 @@ func (a *API) EnrollFactor(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	err = db.Transaction(func(tx *storage.Connection) error {
+	if err := a.createFactor(r, db, user, factor); err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, &EnrollFactorResponse{
+		ID:           factor.ID,
+		Type:         models.TOTP,
+		FriendlyName: factor.FriendlyName,
+		TOTP: &TOTPObject{
+			// See: https://css-tricks.com/probably-dont-base64-svg/
+			QRCode: buf.String(),
+			Secret: key.Secret(),
+			URI:    key.URL(),
+		},
+	})
+}
+
+// createFactor stores a new factor of the user.
+func (a *API) createFactor(r *http.Request, db *storage.Connection, user *models.User, factor *models.Factor) error {
+	return db.Transaction(func(tx *storage.Connection) error {
 		if terr := tx.Create(factor); terr != nil {
 			pgErr := utilities.NewPostgresError(terr)
 			if pgErr.IsUniqueConstraintViolated() {
@@ -150,28 +208,13 @@ func (a *API) EnrollFactor(w http.ResponseWriter, r *http.Request) error {
 			return terr
 
 		}
-		if terr := models.NewAuditLogEntry(r, tx, user, models.EnrollFactorAction, r.RemoteAddr, map[string]interface{}{
+		if terr := models.NewAuditLogEntry(r, tx, user, models.EnrollFactorAction, "", map[string]interface{}{
 			"factor_id": factor.ID,
 		}); terr != nil {
 			return terr
 		}
 		return nil
 	})
-	if err != nil {
-		return err
-	}
-
-	return sendJSON(w, http.StatusOK, &EnrollFactorResponse{
-		ID:           factor.ID,
-		Type:         models.TOTP,
-		FriendlyName: factor.FriendlyName,
-		TOTP: TOTPObject{
-			// See: https://css-tricks.com/probably-dont-base64-svg/
-			QRCode: buf.String(),
-			Secret: key.Secret(),
-			URI:    key.URL(),
-		},
-	})
 }
 
 func (a *API) ChallengeFactor(w http.ResponseWriter, r *http.Request) error {
@@ -181,14 +224,22 @@ func (a *API) ChallengeFactor(w http.ResponseWriter, r *http.Request) error {
 
 	user := getUser(ctx)
 	factor := getFactor(ctx)
-	ipAddress := utilities.GetIPAddress(r)
+	ipAddress := utilities.GetClientIP(r)
+
+	switch factor.FactorType {
+	case models.WebAuthn:
+		return a.challengeWebAuthnFactor(w, r)
+	case models.Phone:
+		return a.challengePhoneFactor(w, r)
+	}
+
 	challenge := models.NewChallenge(factor, ipAddress)
 
 	if err := db.Transaction(func(tx *storage.Connection) error {
 		if terr := tx.Create(challenge); terr != nil {
 			return terr
 		}
-		if terr := models.NewAuditLogEntry(r, tx, user, models.CreateChallengeAction, r.RemoteAddr, map[string]interface{}{
+		if terr := models.NewAuditLogEntry(r, tx, user, models.CreateChallengeAction, "", map[string]interface{}{
 			"factor_id":     factor.ID,
 			"factor_status": factor.Status,
 		}); terr != nil {
@@ -217,12 +268,19 @@ func (a *API) VerifyFactor(w http.ResponseWriter, r *http.Request) error {
 	if err := retrieveRequestParams(r, params); err != nil {
 		return err
 	}
-	currentIP := utilities.GetIPAddress(r)
+	currentIP := utilities.GetClientIP(r)
 
 	if !factor.IsOwnedBy(user) {
 		return internalServerError(InvalidFactorOwnerErrorMessage)
 	}
 
+	switch factor.FactorType {
+	case models.WebAuthn:
+		return a.verifyWebAuthnFactor(w, r, params)
+	case models.Phone:
+		return a.verifyPhoneFactor(w, r, params)
+	}
+
 	challenge, err := models.FindChallengeByID(db, params.ChallengeID)
 	if err != nil && models.IsNotFoundError(err) {
 		return notFoundError(ErrorCodeMFAFactorNotFound, "MFA factor with the provided challenge ID not found")
@@ -253,30 +311,8 @@ func (a *API) VerifyFactor(w http.ResponseWriter, r *http.Request) error {
 		Algorithm: otp.AlgorithmSHA1,
 	})
 
-	if config.Hook.MFAVerificationAttempt.Enabled {
-		input := hooks.MFAVerificationAttemptInput{
-			UserID:   user.ID,
-			FactorID: factor.ID,
-			Valid:    valid,
-		}
-
-		output := hooks.MFAVerificationAttemptOutput{}
-		err := a.invokeHook(nil, r, &input, &output, a.config.Hook.MFAVerificationAttempt.URI)
-		if err != nil {
-			return err
-		}
-
-		if output.Decision == hooks.HookRejection {
-			if err := models.Logout(db, user.ID); err != nil {
-				return err
-			}
-
-			if output.Message == "" {
-				output.Message = hooks.DefaultMFAHookRejectionMessage
-			}
-
-			return forbiddenError(ErrorCodeMFAVerificationRejected, output.Message)
-		}
+	if err := a.runMFAVerificationAttemptHook(r, db, user, factor, valid); err != nil {
+		return err
 	}
 
 	if !valid {
@@ -295,7 +331,7 @@ func (a *API) VerifyFactor(w http.ResponseWriter, r *http.Request) error {
 	var token *AccessTokenResponse
 	err = db.Transaction(func(tx *storage.Connection) error {
 		var terr error
-		if terr = models.NewAuditLogEntry(r, tx, user, models.VerifyFactorAction, r.RemoteAddr, map[string]interface{}{
+		if terr = models.NewAuditLogEntry(r, tx, user, models.VerifyFactorAction, "", map[string]interface{}{
 			"factor_id":    factor.ID,
 			"challenge_id": challenge.ID,
 		}); terr != nil {
@@ -350,6 +386,40 @@ func (a *API) VerifyFactor(w http.ResponseWriter, r *http.Request) error {
 
 }
 
+// runMFAVerificationAttemptHook tells the MFA verification attempt hook about
+// a verification of the factor, signing the user out everywhere when the
+// hook rejects it.
+func (a *API) runMFAVerificationAttemptHook(r *http.Request, db *storage.Connection, user *models.User, factor *models.Factor, valid bool) error {
+	if !a.config.Hook.MFAVerificationAttempt.Enabled {
+		return nil
+	}
+
+	input := hooks.MFAVerificationAttemptInput{
+		UserID:   user.ID,
+		FactorID: factor.ID,
+		Valid:    valid,
+	}
+
+	output := hooks.MFAVerificationAttemptOutput{}
+	if err := a.invokeHook(nil, r, &input, &output, a.config.Hook.MFAVerificationAttempt.URI); err != nil {
+		return err
+	}
+
+	if output.Decision == hooks.HookRejection {
+		if err := models.Logout(db, user.ID); err != nil {
+			return err
+		}
+
+		if output.Message == "" {
+			output.Message = hooks.DefaultMFAHookRejectionMessage
+		}
+
+		return forbiddenError(ErrorCodeMFAVerificationRejected, output.Message)
+	}
+
+	return nil
+}
+
 func (a *API) UnenrollFactor(w http.ResponseWriter, r *http.Request) error {
 	var err error
 	ctx := r.Context()
@@ -374,7 +444,7 @@ func (a *API) UnenrollFactor(w http.ResponseWriter, r *http.Request) error {
 		if terr := tx.Destroy(factor); terr != nil {
 			return terr
 		}
-		if terr = models.NewAuditLogEntry(r, tx, user, models.UnenrollFactorAction, r.RemoteAddr, map[string]interface{}{
+		if terr = models.NewAuditLogEntry(r, tx, user, models.UnenrollFactorAction, "", map[string]interface{}{
 			"factor_id":     factor.ID,
 			"factor_status": factor.Status,
 			"session_id":    session.ID,
@@ -384,6 +454,12 @@ func (a *API) UnenrollFactor(w http.ResponseWriter, r *http.Request) error {
 		if terr = factor.DowngradeSessionsToAAL1(tx); terr != nil {
 			return terr
 		}
+		if factor.IsVerified() && !hasOtherVerifiedFactor(user, factor) {
+			// recovery codes are useless without a verified factor
+			if terr = models.DeleteRecoveryCodes(tx, user.ID); terr != nil {
+				return terr
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -394,3 +470,12 @@ func (a *API) UnenrollFactor(w http.ResponseWriter, r *http.Request) error {
 		ID: factor.ID,
 	})
 }
+
+func hasOtherVerifiedFactor(user *models.User, factor *models.Factor) bool {
+	for _, f := range user.Factors {
+		if f.ID != factor.ID && f.IsVerified() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/supabase/auth/internal/api/sms_provider"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/metering"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+// enrollPhoneFactor creates an unverified phone factor, which is verified
+// with the code sent for its first challenge.
+func (a *API) enrollPhoneFactor(w http.ResponseWriter, r *http.Request, params *EnrollFactorParams) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	db := a.db.WithContext(ctx)
+
+	phone := params.Phone
+	if phone == "" {
+		if user.GetPhone() == "" || user.PhoneConfirmedAt == nil {
+			return badRequestError(ErrorCodeValidationFailed, "A phone number is required, as the user has no confirmed phone number")
+		}
+		phone = user.GetPhone()
+	}
+	phone, err := validatePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	for i := range user.Factors {
+		factor := &user.Factors[i]
+		if factor.FactorType != models.Phone || string(factor.Phone) != phone {
+			continue
+		}
+		if factor.IsVerified() {
+			return unprocessableEntityError(ErrorCodeMFAPhoneFactorExists, "A phone factor with this phone number already exists")
+		}
+		// enrolling the phone number again replaces the factor, for
+		// example when its code never arrived
+		if err := db.Destroy(factor); err != nil {
+			return internalServerError("Database error deleting factor").WithInternalError(err)
+		}
+	}
+
+	factor := models.NewPhoneFactor(user, params.FriendlyName, phone)
+	if err := a.createFactor(r, db, user, factor); err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, &EnrollFactorResponse{
+		ID:           factor.ID,
+		Type:         models.Phone,
+		FriendlyName: factor.FriendlyName,
+		Phone:        phone,
+	})
+}
+
+// challengePhoneFactor sends a code to the phone number of the factor with
+// the SMS provider.
+func (a *API) challengePhoneFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.config
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+	factor := getFactor(ctx)
+
+	if !config.MFA.Phone.Enabled {
+		return badRequestError(ErrorCodeMFAPhoneDisabled, "MFA with phone factors is disabled")
+	}
+	if err := a.requireFeature(ctx, conf.FeatureSMS); err != nil {
+		return err
+	}
+
+	params := &ChallengeFactorParams{}
+	body, err := getBodyBytes(r)
+	if err != nil {
+		return internalServerError("Could not read body into byte slice").WithInternalError(err)
+	}
+	if len(body) > 0 {
+		if err := retrieveRequestParams(r, params); err != nil {
+			return err
+		}
+	}
+	if params.Channel == "" {
+		params.Channel = sms_provider.SMSProvider
+	}
+	if !sms_provider.IsValidMessageChannel(params.Channel, &config.Sms) {
+		return badRequestError(ErrorCodeValidationFailed, InvalidChannelError)
+	}
+
+	// the limit is kept on the user, as enrolling the phone number again
+	// replaces the factor and its challenges
+	if sentAt := user.MFAPhoneChallengeSentAt; sentAt != nil && !sentAt.Add(config.MFA.Phone.MaxFrequency).Before(time.Now()) {
+		return tooManyRequestsError(ErrorCodeOverSMSSendRateLimit, generateFrequencyLimitErrorMessage(sentAt, config.MFA.Phone.MaxFrequency))
+	}
+
+	phone := string(factor.Phone)
+	challenge := models.NewChallenge(factor, utilities.GetClientIP(r))
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		otp, ok := config.Sms.GetTestOTP(phone, time.Now())
+		if !ok {
+			useHook := config.Hook.SendSMS.Enabled
+
+			var smsProvider sms_provider.SmsProvider
+			if !useHook {
+				var terr error
+				if smsProvider, terr = sms_provider.GetSmsProvider(*config); terr != nil {
+					return internalServerError("Failed to get SMS provider").WithInternalError(terr)
+				}
+			}
+
+			// Twilio Verify generates the codes it sends and checks
+			// them itself
+			var message string
+			if !config.Sms.IsTwilioVerifyProvider() || useHook {
+				var terr error
+				if otp, terr = generateOtp(config.Sms.OtpLength, config.Sms.OtpAlphabet); terr != nil {
+					return internalServerError("error generating otp").WithInternalError(terr)
+				}
+				if message, terr = generateSMSFromTemplate(config.MFA.Phone.SMSTemplate, otp); terr != nil {
+					return terr
+				}
+			}
+
+			if _, terr := a.sendSMS(r, tx, user, phone, message, otp, params.Channel, smsProvider, useHook); terr != nil {
+				return badRequestError(ErrorCodeSMSSendFailed, "Error sending MFA code: %v", terr).WithInternalError(terr)
+			}
+		}
+
+		if otp != "" {
+			challenge.OtpCode = crypto.GenerateTokenHash(phone, otp)
+		}
+		if terr := tx.Create(challenge); terr != nil {
+			return terr
+		}
+		if terr := user.SetMFAPhoneChallengeSentAt(tx, time.Now()); terr != nil {
+			return terr
+		}
+		return models.NewAuditLogEntry(r, tx, user, models.CreateChallengeAction, "", map[string]interface{}{
+			"factor_id":     factor.ID,
+			"factor_status": factor.Status,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, &ChallengeFactorResponse{
+		ID:        challenge.ID,
+		ExpiresAt: challenge.GetExpiryTime(config.MFA.ChallengeExpiryDuration).Unix(),
+	})
+}
+
+// verifyPhoneFactor verifies the code sent for the challenge of a phone
+// factor, which verifies the factor and upgrades the session to AAL2 like
+// with a TOTP factor.
+func (a *API) verifyPhoneFactor(w http.ResponseWriter, r *http.Request, params *VerifyFactorParams) error {
+	ctx := r.Context()
+	config := a.config
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+	factor := getFactor(ctx)
+
+	if !config.MFA.Phone.Enabled {
+		return badRequestError(ErrorCodeMFAPhoneDisabled, "MFA with phone factors is disabled")
+	}
+
+	challenge, err := models.FindChallengeByID(db, params.ChallengeID)
+	if err != nil && models.IsNotFoundError(err) {
+		return notFoundError(ErrorCodeMFAFactorNotFound, "MFA factor with the provided challenge ID not found")
+	} else if err != nil {
+		return internalServerError("Database error finding Challenge").WithInternalError(err)
+	}
+	if challenge.FactorID != factor.ID {
+		return notFoundError(ErrorCodeMFAFactorNotFound, "MFA factor with the provided challenge ID not found")
+	}
+
+	if challenge.VerifiedAt != nil || challenge.IPAddress != utilities.GetClientIP(r) {
+		return unprocessableEntityError(ErrorCodeMFAIPAddressMismatch, "Challenge and verify IP addresses mismatch")
+	}
+
+	if challenge.HasExpired(config.MFA.ChallengeExpiryDuration) {
+		if err := db.Destroy(challenge); err != nil {
+			return internalServerError("Database error deleting challenge").WithInternalError(err)
+		}
+		return unprocessableEntityError(ErrorCodeMFAChallengeExpired, "MFA challenge %v has expired, verify against another challenge or create a new challenge.", challenge.ID)
+	}
+
+	phone := string(factor.Phone)
+	// alphanumeric codes are upper case, but are accepted in any case
+	code := strings.ToUpper(strings.TrimSpace(params.Code))
+
+	var verr error
+	valid := false
+	if challenge.OtpCode != "" {
+		valid = subtle.ConstantTimeCompare([]byte(crypto.GenerateTokenHash(phone, code)), []byte(challenge.OtpCode)) == 1
+	} else if config.Sms.IsTwilioVerifyProvider() && code != "" {
+		smsProvider, err := sms_provider.GetSmsProvider(*config)
+		if err != nil {
+			return internalServerError("Failed to get SMS provider").WithInternalError(err)
+		}
+		verr = smsProvider.(*sms_provider.TwilioVerifyProvider).VerifyOTP(phone, code)
+		valid = verr == nil
+	}
+
+	if err := a.runMFAVerificationAttemptHook(r, db, user, factor, valid); err != nil {
+		return err
+	}
+
+	if !valid {
+		return unprocessableEntityError(ErrorCodeMFAVerificationFailed, "Invalid MFA phone code entered").WithInternalError(verr)
+	}
+
+	var token *AccessTokenResponse
+	err = db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		if terr = models.NewAuditLogEntry(r, tx, user, models.VerifyFactorAction, "", map[string]interface{}{
+			"factor_id":    factor.ID,
+			"challenge_id": challenge.ID,
+		}); terr != nil {
+			return terr
+		}
+		if terr = challenge.Verify(tx); terr != nil {
+			return terr
+		}
+		if !factor.IsVerified() {
+			if terr = factor.UpdateStatus(tx, models.FactorStateVerified); terr != nil {
+				return terr
+			}
+		}
+		if user, terr = models.FindUserByID(tx, user.ID); terr != nil {
+			return terr
+		}
+		if token, terr = a.updateMFASessionAndClaims(r, tx, user, models.PhoneSignIn, models.GrantParams{
+			FactorID: &factor.ID,
+		}); terr != nil {
+			return terr
+		}
+		if terr = a.setCookieTokens(config, token, false, w); terr != nil {
+			return internalServerError("Failed to set JWT cookie. %s", terr)
+		}
+		if terr = models.InvalidateSessionsWithAALLessThan(tx, user.ID, models.AAL2.String()); terr != nil {
+			return internalServerError("Failed to update sessions. %s", terr)
+		}
+		if terr = models.DeleteUnverifiedFactors(tx, user); terr != nil {
+			return internalServerError("Error removing unverified factors. %s", terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	metering.RecordLogin(string(models.MFACodeLoginAction), user.ID)
+
+	return sendJSON(w, http.StatusOK, token)
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
+	jwt "github.com/golang-jwt/jwt"
 
 	"database/sql"
 
@@ -212,6 +213,21 @@ func (ts *MFATestSuite) TestMultipleEnrollsCleanupExpiredFactors() {
 	require.Equal(ts.T(), 3, len(factors))
 }
 
+func (ts *MFATestSuite) TestEnrollFactorRequiresAAL2() {
+	f := ts.TestUser.Factors[0]
+	require.NoError(ts.T(), f.UpdateStatus(ts.API.db, models.FactorStateVerified))
+
+	token := ts.generateAAL1Token(ts.TestUser, &ts.TestSession.ID)
+	w := performEnrollFlow(ts, token, "second", models.TOTP, ts.TestDomain, http.StatusForbidden)
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeInsufficientAAL, data.ErrorCode)
+
+	require.NoError(ts.T(), ts.TestSession.UpdateAALAndAssociatedFactor(ts.API.db, models.AAL2, &f.ID))
+	performEnrollFlow(ts, token, "second", models.TOTP, ts.TestDomain, http.StatusOK)
+}
+
 func (ts *MFATestSuite) TestChallengeFactor() {
 	f := ts.TestUser.Factors[0]
 	token := ts.generateAAL1Token(ts.TestUser, &ts.TestSession.ID)
@@ -264,7 +280,7 @@ func (ts *MFATestSuite) TestMFAVerifyFactor() {
 			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/factors/%s/verify", f.ID), &buffer)
 			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-			testIPAddress := utilities.GetIPAddress(req)
+			testIPAddress := utilities.GetClientIP(req)
 			c := models.NewChallenge(f, testIPAddress)
 			require.NoError(ts.T(), ts.API.db.Create(c), "Error saving new test challenge")
 			if !v.validChallenge {
@@ -304,6 +320,105 @@ func (ts *MFATestSuite) TestMFAVerifyFactor() {
 	}
 }
 
+func (ts *MFATestSuite) TestPhoneFactor() {
+	ts.Config.MFA.Phone.Enabled = true
+	ts.Config.Sms.TestOTP = map[string]string{"15555550100": "123456"}
+	defer func() {
+		ts.Config.MFA.Phone.Enabled = false
+		ts.Config.Sms.TestOTP = nil
+	}()
+
+	token := ts.generateAAL1Token(ts.TestUser, &ts.TestSession.ID)
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(EnrollFactorParams{FactorType: models.Phone, Phone: "+1 555 555 0100"}))
+	w := ServeAuthenticatedRequest(ts, http.MethodPost, "http://localhost/factors/", token, buffer)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	enrollResp := EnrollFactorResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&enrollResp))
+	require.Equal(ts.T(), models.Phone, enrollResp.Type)
+	require.Equal(ts.T(), "15555550100", enrollResp.Phone)
+
+	w = performChallengeFlow(ts, enrollResp.ID, token)
+	challengeResp := ChallengeFactorResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&challengeResp))
+
+	// codes can only be sent once per MFA_PHONE_MAX_FREQUENCY
+	w = ServeAuthenticatedRequest(ts, http.MethodPost, fmt.Sprintf("http://localhost/factors/%s/challenge", enrollResp.ID), token, bytes.Buffer{})
+	require.Equal(ts.T(), http.StatusTooManyRequests, w.Code)
+
+	// even when the phone number is enrolled again
+	buffer.Reset()
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(EnrollFactorParams{FactorType: models.Phone, Phone: "+1 555 555 0100"}))
+	w = ServeAuthenticatedRequest(ts, http.MethodPost, "http://localhost/factors/", token, buffer)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	reenrollResp := EnrollFactorResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&reenrollResp))
+	require.NotEqual(ts.T(), enrollResp.ID, reenrollResp.ID)
+	w = ServeAuthenticatedRequest(ts, http.MethodPost, fmt.Sprintf("http://localhost/factors/%s/challenge", reenrollResp.ID), token, bytes.Buffer{})
+	require.Equal(ts.T(), http.StatusTooManyRequests, w.Code)
+
+	// the new factor is challenged once MFA_PHONE_MAX_FREQUENCY has passed
+	enrollResp = reenrollResp
+	user, err := models.FindUserByID(ts.API.db, ts.TestUser.ID)
+	require.NoError(ts.T(), err)
+	sentAt := user.MFAPhoneChallengeSentAt.Add(-time.Minute)
+	require.NoError(ts.T(), user.SetMFAPhoneChallengeSentAt(ts.API.db, sentAt))
+	w = performChallengeFlow(ts, enrollResp.ID, token)
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&challengeResp))
+
+	verify := func(code string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(VerifyFactorParams{ChallengeID: challengeResp.ID, Code: code}))
+		return ServeAuthenticatedRequest(ts, http.MethodPost, fmt.Sprintf("/factors/%s/verify", enrollResp.ID), token, buffer)
+	}
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, verify("654321").Code)
+
+	w = verify("123456")
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	data := AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+
+	factor, err := models.FindFactorByFactorID(ts.API.db, enrollResp.ID)
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), factor.IsVerified())
+
+	// the phone number can't be enrolled twice
+	buffer.Reset()
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(EnrollFactorParams{FactorType: models.Phone, Phone: "15555550100"}))
+	w = ServeAuthenticatedRequest(ts, http.MethodPost, "http://localhost/factors/", data.Token, buffer)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+}
+
+func (ts *MFATestSuite) TestRequireAAL2Audiences() {
+	ts.Config.MFA.RequireAAL2Audiences = []string{ts.Config.JWT.Aud}
+	defer func() {
+		ts.Config.MFA.RequireAAL2Audiences = nil
+	}()
+
+	token := ts.generateAAL1Token(ts.TestUser, &ts.TestSession.ID)
+
+	w := ServeAuthenticatedRequest(ts, http.MethodGet, "/user", token, bytes.Buffer{})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{"data": map[string]interface{}{"name": "bob"}}))
+	w = ServeAuthenticatedRequest(ts, http.MethodPut, "/user", token, buffer)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	w = ServeAuthenticatedRequest(ts, http.MethodGet, "/sessions", token, bytes.Buffer{})
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	// factors can still be verified
+	w = performChallengeFlow(ts, ts.TestUser.Factors[0].ID, token)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	require.NoError(ts.T(), ts.TestSession.UpdateAALAndAssociatedFactor(ts.API.db, models.AAL2, nil))
+	w = ServeAuthenticatedRequest(ts, http.MethodGet, "/sessions", token, bytes.Buffer{})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+}
+
 func (ts *MFATestSuite) TestUnenrollVerifiedFactor() {
 	cases := []struct {
 		desc             string
@@ -433,6 +548,112 @@ func (ts *MFATestSuite) TestMFAFollowedByPasswordSignIn() {
 	require.True(ts.T(), session.IsAAL2())
 }
 
+func (ts *MFATestSuite) TestRecoveryCodes() {
+	signUpResp := signUp(ts, "recovery@example.com", ts.TestPassword)
+
+	// a verified factor is required to generate recovery codes
+	var buffer bytes.Buffer
+	w := ServeAuthenticatedRequest(ts, http.MethodPost, "/factors/recovery_codes", signUpResp.Token, buffer)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+
+	resp := performEnrollAndVerify(ts, signUpResp.Token, true)
+	aal2Resp := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(resp.Body).Decode(aal2Resp))
+
+	w = ServeAuthenticatedRequest(ts, http.MethodPost, "/factors/recovery_codes", aal2Resp.Token, buffer)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	codesResp := &GenerateRecoveryCodesResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(codesResp))
+	require.Len(ts.T(), codesResp.Codes, models.RecoveryCodeCount)
+
+	// an AAL1 session is upgraded by a recovery code
+	token := passwordSignIn(ts, "recovery@example.com", ts.TestPassword)
+	w = performRecoveryCodeVerify(ts, token, codesResp.Codes[0])
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	data := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+
+	req := httptest.NewRequest(http.MethodPost, "/factors/recovery_codes/verify", nil)
+	ctx, err := ts.API.parseJWTClaims(data.Token, req)
+	require.NoError(ts.T(), err)
+	claims := getClaims(ctx)
+	require.Equal(ts.T(), models.AAL2.String(), claims.AuthenticatorAssuranceLevel)
+	methods := []string{}
+	for _, entry := range claims.AuthenticationMethodReference {
+		methods = append(methods, entry.Method)
+	}
+	require.Contains(ts.T(), methods, models.RecoveryCodeSignIn.String())
+
+	// a recovery code can only be used once
+	token = passwordSignIn(ts, "recovery@example.com", ts.TestPassword)
+	w = performRecoveryCodeVerify(ts, token, codesResp.Codes[0])
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+	httpErr := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(httpErr))
+	require.Equal(ts.T(), ErrorCodeMFAVerificationFailed, httpErr.ErrorCode)
+
+	w = ServeAuthenticatedRequest(ts, http.MethodGet, "/user", data.Token, buffer)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	user := &models.User{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(user))
+	require.NotNil(ts.T(), user.RecoveryCodesRemaining)
+	require.Equal(ts.T(), models.RecoveryCodeCount-1, *user.RecoveryCodesRemaining)
+}
+
+func (ts *MFATestSuite) TestAdminDeleteAllFactors() {
+	f := ts.TestUser.Factors[0]
+	require.NoError(ts.T(), f.UpdateStatus(ts.API.db, models.FactorStateVerified))
+	require.NoError(ts.T(), models.AddClaimToSession(ts.API.db, ts.TestSession.ID, models.TOTPSignIn))
+	require.NoError(ts.T(), ts.TestSession.UpdateAALAndAssociatedFactor(ts.API.db, models.AAL2, &f.ID))
+	_, err := models.GenerateRecoveryCodes(ts.API.db, ts.TestUser.ID)
+	require.NoError(ts.T(), err)
+
+	adminToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+
+	var buffer bytes.Buffer
+	w := ServeAuthenticatedRequest(ts, http.MethodDelete, fmt.Sprintf("/admin/users/%s/factors", ts.TestUser.ID), adminToken, buffer)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	factors, err := FindFactorsByUser(ts.API.db, ts.TestUser)
+	require.NoError(ts.T(), err)
+	require.Empty(ts.T(), factors)
+
+	remaining, err := models.CountRemainingRecoveryCodes(ts.API.db, ts.TestUser.ID)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 0, remaining)
+
+	session, err := models.FindSessionByID(ts.API.db, ts.TestSession.ID, false)
+	require.NoError(ts.T(), err)
+	require.False(ts.T(), session.IsAAL2())
+	require.Nil(ts.T(), session.FactorID)
+}
+
+func passwordSignIn(ts *MFATestSuite, email, password string) string {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    email,
+		"password": password,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=password", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	data := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	return data.Token
+}
+
+func performRecoveryCodeVerify(ts *MFATestSuite, token, code string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(VerifyRecoveryCodeParams{Code: code}))
+	return ServeAuthenticatedRequest(ts, http.MethodPost, "/factors/recovery_codes/verify", token, buffer)
+}
+
 func signUp(ts *MFATestSuite, email, password string) (signUpResp AccessTokenResponse) {
 	ts.API.config.Mailer.Autoconfirm = true
 	var buffer bytes.Buffer
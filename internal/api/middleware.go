@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -93,7 +94,7 @@ func (a *API) limitEmailOrPhoneSentHandler() middlewareHandler {
 
 	return func(w http.ResponseWriter, req *http.Request) (context.Context, error) {
 		c := req.Context()
-		config := a.config
+		config := a.getConfig(c)
 		shouldRateLimitEmail := config.External.Email.Enabled && !config.Mailer.Autoconfirm
 		shouldRateLimitPhone := config.External.Phone.Enabled && !config.Sms.Autoconfirm
 
@@ -152,7 +153,7 @@ func (a *API) requireAdminCredentials(w http.ResponseWriter, req *http.Request)
 
 func (a *API) requireEmailProvider(w http.ResponseWriter, req *http.Request) (context.Context, error) {
 	ctx := req.Context()
-	config := a.config
+	config := a.getConfig(ctx)
 
 	if !config.External.Email.Enabled {
 		return nil, badRequestError(ErrorCodeEmailProviderDisabled, "Email logins are disabled")
@@ -176,9 +177,14 @@ func (a *API) verifyCaptcha(w http.ResponseWriter, req *http.Request) (context.C
 		return ctx, nil
 	}
 
-	verificationResult, err := security.VerifyRequest(req, strings.TrimSpace(config.Security.Captcha.Secret), config.Security.Captcha.Provider)
+	verificationResult, err := security.VerifyRequest(req, &config.Security.Captcha)
 	if err != nil {
-		return nil, internalServerError("captcha verification process failed").WithInternalError(err)
+		if errors.Is(err, security.ErrMissingToken) {
+			return nil, badRequestError(ErrorCodeCaptchaFailed, "captcha protection: request disallowed (missing captcha_token)")
+		}
+		// fail closed: a provider that can't be reached must not let
+		// requests through
+		return nil, badRequestError(ErrorCodeCaptchaFailed, "captcha verification process failed").WithInternalError(err)
 	}
 
 	if !verificationResult.Success {
@@ -231,6 +237,22 @@ func (a *API) requireSAMLEnabled(w http.ResponseWriter, req *http.Request) (cont
 	return ctx, nil
 }
 
+func (a *API) requireDeviceAuthorizationEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if !a.config.DeviceAuthorization.Enabled {
+		return nil, notFoundError(ErrorCodeDeviceAuthorizationDisabled, "Device authorization is disabled")
+	}
+	return ctx, nil
+}
+
+func (a *API) requireWebAuthnEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if !a.config.WebAuthn.Enabled {
+		return nil, notFoundError(ErrorCodeWebAuthnDisabled, "WebAuthn is disabled")
+	}
+	return ctx, nil
+}
+
 func (a *API) requireManualLinkingEnabled(w http.ResponseWriter, req *http.Request) (context.Context, error) {
 	ctx := req.Context()
 	if !a.config.Security.ManualLinkingEnabled {
@@ -335,9 +357,20 @@ func (t *timeoutResponseWriter) finallyWrite(w http.ResponseWriter) {
 	}
 }
 
+// streamingPaths are served without timeoutMiddleware, as their responses
+// are streamed and can take longer than the maximum request duration.
+var streamingPaths = map[string]bool{
+	"/admin/users/export": true,
+}
+
 func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if streamingPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			ctx, cancel := context.WithTimeout(r.Context(), timeout)
 			defer cancel()
 
@@ -21,9 +21,8 @@ import (
 )
 
 const (
-	HCaptchaSecret         string = "0x0000000000000000000000000000000000000000"
-	CaptchaResponse        string = "10000000-aaaa-bbbb-cccc-000000000001"
-	TurnstileCaptchaSecret string = "1x0000000000000000000000000000000AA"
+	CaptchaSecret   string = "captcha-secret"
+	CaptchaResponse string = "10000000-aaaa-bbbb-cccc-000000000001"
 )
 
 type MiddlewareTestSuite struct {
@@ -45,144 +44,180 @@ func TestMiddlewareFunctions(t *testing.T) {
 	suite.Run(t, ts)
 }
 
-func (ts *MiddlewareTestSuite) TestVerifyCaptchaValid() {
-	ts.Config.Security.Captcha.Enabled = true
+// setupCaptchaServer starts a fake siteverify endpoint and points the captcha
+// configuration at it.
+func (ts *MiddlewareTestSuite) setupCaptchaServer(handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+	ts.Config.Security.Captcha = conf.CaptchaConfiguration{
+		Enabled:   true,
+		Provider:  "hcaptcha",
+		Secret:    CaptchaSecret,
+		Timeout:   time.Second,
+		VerifyURL: server.URL,
+	}
+	return server
+}
 
-	adminClaims := &AccessTokenClaims{
-		Role: "supabase_admin",
+func captchaSiteverifyHandler(t *testing.T, response map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, CaptchaSecret, r.PostForm.Get("secret"))
+		require.Equal(t, CaptchaResponse, r.PostForm.Get("response"))
+
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(response))
 	}
-	adminJwt, err := jwt.NewWithClaims(jwt.SigningMethodHS256, adminClaims).SignedString([]byte(ts.Config.JWT.Secret))
-	require.NoError(ts.T(), err)
-	cases := []struct {
-		desc             string
-		adminJwt         string
-		captcha_token    string
-		captcha_provider string
-	}{
-		{
-			"Valid captcha response",
-			"",
-			CaptchaResponse,
-			"hcaptcha",
-		},
-		{
-			"Valid captcha response",
-			"",
-			CaptchaResponse,
-			"turnstile",
-		},
-		{
-			"Ignore captcha if admin role is present",
-			adminJwt,
-			"",
-			"hcaptcha",
-		},
-		{
-			"Ignore captcha if admin role is present",
-			adminJwt,
-			"",
-			"turnstile",
+}
+
+func newCaptchaRequest(t *testing.T, target, captchaToken string) *http.Request {
+	var buffer bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "secret",
+		"gotrue_meta_security": map[string]interface{}{
+			"captcha_token": captchaToken,
 		},
-	}
-	for _, c := range cases {
-		ts.Config.Security.Captcha.Provider = c.captcha_provider
-		if c.captcha_provider == "turnstile" {
-			ts.Config.Security.Captcha.Secret = TurnstileCaptchaSecret
-		} else if c.captcha_provider == "hcaptcha" {
-			ts.Config.Security.Captcha.Secret = HCaptchaSecret
-		}
+	}))
+	req := httptest.NewRequest(http.MethodPost, target, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	return req.WithContext(context.Background())
+}
 
-		var buffer bytes.Buffer
-		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
-			"email":    "test@example.com",
-			"password": "secret",
-			"gotrue_meta_security": map[string]interface{}{
-				"captcha_token": c.captcha_token,
-			},
-		}))
-		req := httptest.NewRequest(http.MethodPost, "http://localhost", &buffer)
-		req.Header.Set("Content-Type", "application/json")
-		if c.adminJwt != "" {
-			req.Header.Set("Authorization", "Bearer "+c.adminJwt)
-		}
+func (ts *MiddlewareTestSuite) TestVerifyCaptchaValid() {
+	server := ts.setupCaptchaServer(captchaSiteverifyHandler(ts.T(), map[string]interface{}{
+		"success": true,
+	}))
+	defer server.Close()
 
-		beforeCtx := context.Background()
-		req = req.WithContext(beforeCtx)
+	req := newCaptchaRequest(ts.T(), "http://localhost/signup", CaptchaResponse)
+	expectedBody := newCaptchaRequest(ts.T(), "http://localhost/signup", CaptchaResponse)
+	beforeCtx := req.Context()
 
-		w := httptest.NewRecorder()
+	w := httptest.NewRecorder()
+	afterCtx, err := ts.API.verifyCaptcha(w, req)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), beforeCtx, afterCtx)
 
-		afterCtx, err := ts.API.verifyCaptcha(w, req)
-		require.NoError(ts.T(), err)
+	// the downstream handler must still be able to read the body
+	params := &SignupParams{}
+	require.NoError(ts.T(), retrieveRequestParams(req, params))
+	require.Equal(ts.T(), "test@example.com", params.Email)
+	require.Equal(ts.T(), "secret", params.Password)
 
-		body, err := io.ReadAll(req.Body)
-		require.NoError(ts.T(), err)
+	body, err := io.ReadAll(req.Body)
+	require.NoError(ts.T(), err)
+	expected, err := io.ReadAll(expectedBody.Body)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), expected, body)
+}
 
-		// re-initialize buffer
-		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
-			"email":    "test@example.com",
-			"password": "secret",
-			"gotrue_meta_security": map[string]interface{}{
-				"captcha_token": c.captcha_token,
-			},
-		}))
+func (ts *MiddlewareTestSuite) TestVerifyCaptchaInvalid() {
+	server := ts.setupCaptchaServer(captchaSiteverifyHandler(ts.T(), map[string]interface{}{
+		"success":     false,
+		"error-codes": []string{"invalid-input-response"},
+	}))
+	defer server.Close()
+
+	req := newCaptchaRequest(ts.T(), "http://localhost/signup", CaptchaResponse)
+	_, err := ts.API.verifyCaptcha(httptest.NewRecorder(), req)
+	require.Error(ts.T(), err)
+
+	httpErr, ok := err.(*HTTPError)
+	require.True(ts.T(), ok)
+	require.Equal(ts.T(), http.StatusBadRequest, httpErr.HTTPStatus)
+	require.Equal(ts.T(), ErrorCodeCaptchaFailed, httpErr.ErrorCode)
+	require.Equal(ts.T(), "captcha protection: request disallowed (invalid-input-response)", httpErr.Message)
+}
 
-		// check if body is the same
-		require.Equal(ts.T(), body, buffer.Bytes())
-		require.Equal(ts.T(), afterCtx, beforeCtx)
-	}
+func (ts *MiddlewareTestSuite) TestVerifyCaptchaMissingToken() {
+	server := ts.setupCaptchaServer(func(w http.ResponseWriter, r *http.Request) {
+		ts.Fail("the provider should not be called without a captcha token")
+	})
+	defer server.Close()
+
+	req := newCaptchaRequest(ts.T(), "http://localhost/recover", "")
+	_, err := ts.API.verifyCaptcha(httptest.NewRecorder(), req)
+	require.Error(ts.T(), err)
+
+	httpErr, ok := err.(*HTTPError)
+	require.True(ts.T(), ok)
+	require.Equal(ts.T(), http.StatusBadRequest, httpErr.HTTPStatus)
+	require.Equal(ts.T(), ErrorCodeCaptchaFailed, httpErr.ErrorCode)
 }
 
-func (ts *MiddlewareTestSuite) TestVerifyCaptchaInvalid() {
+func (ts *MiddlewareTestSuite) TestVerifyCaptchaTimeout() {
+	unblock := make(chan struct{})
+	server := ts.setupCaptchaServer(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	})
+	defer server.Close()
+	defer close(unblock)
+
+	ts.Config.Security.Captcha.Timeout = 50 * time.Millisecond
+
+	req := newCaptchaRequest(ts.T(), "http://localhost/otp", CaptchaResponse)
+	start := time.Now()
+	_, err := ts.API.verifyCaptcha(httptest.NewRecorder(), req)
+	require.Error(ts.T(), err)
+	require.Less(ts.T(), time.Since(start), 5*time.Second)
+
+	httpErr, ok := err.(*HTTPError)
+	require.True(ts.T(), ok)
+	require.Equal(ts.T(), http.StatusBadRequest, httpErr.HTTPStatus)
+	require.Equal(ts.T(), ErrorCodeCaptchaFailed, httpErr.ErrorCode)
+}
+
+func (ts *MiddlewareTestSuite) TestVerifyCaptchaExemptions() {
+	adminClaims := &AccessTokenClaims{
+		Role: "supabase_admin",
+	}
+	adminJwt, err := jwt.NewWithClaims(jwt.SigningMethodHS256, adminClaims).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+
+	server := ts.setupCaptchaServer(func(w http.ResponseWriter, r *http.Request) {
+		ts.Fail("the provider should not be called for exempt requests")
+	})
+	defer server.Close()
+
 	cases := []struct {
-		desc         string
-		captchaConf  *conf.CaptchaConfiguration
-		expectedCode int
-		expectedMsg  string
+		desc     string
+		target   string
+		adminJwt string
 	}{
 		{
-			"Captcha validation failed",
-			&conf.CaptchaConfiguration{
-				Enabled:  true,
-				Provider: "hcaptcha",
-				Secret:   "test",
-			},
-			http.StatusBadRequest,
-			"captcha protection: request disallowed (not-using-dummy-secret)",
+			desc:     "Admin role",
+			target:   "http://localhost/signup",
+			adminJwt: adminJwt,
 		},
 		{
-			"Captcha validation failed",
-			&conf.CaptchaConfiguration{
-				Enabled:  true,
-				Provider: "turnstile",
-				Secret:   "anothertest",
-			},
-			http.StatusBadRequest,
-			"captcha protection: request disallowed (invalid-input-secret)",
+			desc:   "Refresh token grant",
+			target: "http://localhost/token?grant_type=refresh_token",
+		},
+		{
+			desc:   "PKCE grant",
+			target: "http://localhost/token?grant_type=pkce",
 		},
 	}
+
 	for _, c := range cases {
 		ts.Run(c.desc, func() {
-			ts.Config.Security.Captcha = *c.captchaConf
-			var buffer bytes.Buffer
-			require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
-				"email":    "test@example.com",
-				"password": "secret",
-				"gotrue_meta_security": map[string]interface{}{
-					"captcha_token": CaptchaResponse,
-				},
-			}))
-			req := httptest.NewRequest(http.MethodPost, "http://localhost", &buffer)
-			req.Header.Set("Content-Type", "application/json")
-
-			req = req.WithContext(context.Background())
-
-			w := httptest.NewRecorder()
-
-			_, err := ts.API.verifyCaptcha(w, req)
-			require.Equal(ts.T(), c.expectedCode, err.(*HTTPError).HTTPStatus)
-			require.Equal(ts.T(), c.expectedMsg, err.(*HTTPError).Message)
+			req := newCaptchaRequest(ts.T(), c.target, "")
+			if c.adminJwt != "" {
+				req.Header.Set("Authorization", "Bearer "+c.adminJwt)
+			}
+			_, err := ts.API.verifyCaptcha(httptest.NewRecorder(), req)
+			require.NoError(ts.T(), err)
 		})
 	}
+
+	ts.Run("Password grant is not exempt", func() {
+		req := newCaptchaRequest(ts.T(), "http://localhost/token?grant_type=password", "")
+		_, err := ts.API.verifyCaptcha(httptest.NewRecorder(), req)
+		require.Error(ts.T(), err)
+	})
 }
 
 func (ts *MiddlewareTestSuite) TestLimitEmailOrPhoneSentHandler() {
@@ -338,6 +373,30 @@ func (ts *MiddlewareTestSuite) TestTimeoutMiddleware() {
 	require.NotNil(ts.T(), data["msg"])
 }
 
+func TestTimeoutMiddlewareStreaming(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/admin/users/export", nil)
+	w := httptest.NewRecorder()
+
+	timeoutHandler := timeoutMiddleware(time.Millisecond)
+
+	streamingHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte("first\n"))
+		require.NoError(t, err)
+		require.NoError(t, http.NewResponseController(w).Flush())
+
+		// streamed responses aren't cut off by the timeout
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, r.Context().Err())
+		_, err = w.Write([]byte("second\n"))
+		require.NoError(t, err)
+	})
+	timeoutHandler(streamingHandler).ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, w.Flushed)
+	require.Equal(t, "first\nsecond\n", w.Body.String())
+}
+
 func TestTimeoutResponseWriter(t *testing.T) {
 	// timeoutResponseWriter should exhitbit a similar behavior as http.ResponseWriter
 	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// defaultOAuthClientRole is the role of the access tokens of clients
+// registered without one.
+const defaultOAuthClientRole = "service_client"
+
+// oauthScopePattern matches a scope token, see RFC 6749 section 3.3.
+var oauthScopePattern = regexp.MustCompile(`^[\x21\x23-\x5b\x5d-\x7e]+$`)
+
+// OAuthClientCreateParams are the parameters for registering a client.
+// Scope is the space separated scopes the client may ask for.
+type OAuthClientCreateParams struct {
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Scope string `json:"scope"`
+}
+
+// OAuthClientResponse is a client, with its secret only when it was just
+// registered.
+type OAuthClientResponse struct {
+	*models.OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// OAuthClientsResponse lists the clients, the newest first.
+type OAuthClientsResponse struct {
+	Clients []*models.OAuthClient `json:"clients"`
+}
+
+// ClientCredentialsGrantParams are the parameters of the client credentials
+// grant, when the client doesn't authenticate with HTTP basic auth.
+type ClientCredentialsGrantParams struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Scope        string `json:"scope"`
+}
+
+// ClientCredentialsResponse is the access token of a client. There is no
+// refresh token, clients ask for a new access token instead.
+type ClientCredentialsResponse struct {
+	Token     string `json:"access_token"`
+	TokenType string `json:"token_type"`
+	ExpiresIn int    `json:"expires_in"`
+	ExpiresAt int64  `json:"expires_at"`
+	Scope     string `json:"scope,omitempty"`
+}
+
+// adminOAuthClientsList lists the registered clients.
+func (a *API) adminOAuthClientsList(w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(r.Context())
+
+	clients, err := models.FindOAuthClients(db)
+	if err != nil {
+		return internalServerError("Database error finding OAuth clients").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, OAuthClientsResponse{Clients: clients})
+}
+
+// adminOAuthClientCreate registers a client. Its secret is only returned
+// now.
+func (a *API) adminOAuthClientCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.config
+
+	params := &OAuthClientCreateParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		return badRequestError(ErrorCodeValidationFailed, "name is required")
+	}
+
+	if params.Role == "" {
+		params.Role = defaultOAuthClientRole
+	}
+	// the admin API is not for clients, which stick to their scopes
+	if isStringInSlice(params.Role, config.JWT.AdminRoles) {
+		return badRequestError(ErrorCodeValidationFailed, "OAuth clients can't have the admin role %q", params.Role)
+	}
+	// the sub claim of clients is their client ID, which policies written
+	// for users would take for a user ID
+	if params.Role == "authenticated" || params.Role == "anon" || params.Role == config.JWT.DefaultGroupName {
+		return badRequestError(ErrorCodeValidationFailed, "OAuth clients can't have the user role %q", params.Role)
+	}
+
+	scopes := strings.Fields(params.Scope)
+	for _, scope := range scopes {
+		if !oauthScopePattern.MatchString(scope) {
+			return badRequestError(ErrorCodeValidationFailed, "Invalid scope %q", scope)
+		}
+	}
+
+	client, secret, err := models.NewOAuthClient(params.Name, params.Role, scopes)
+	if err != nil {
+		return internalServerError("Error creating OAuth client").WithInternalError(err)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.Create(client); terr != nil {
+			return internalServerError("Database error saving OAuth client").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.OAuthClientCreatedAction, "", map[string]interface{}{
+			"client_id": client.ID,
+			"name":      client.Name,
+			"role":      client.Role,
+			"scope":     client.Scope,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusCreated, OAuthClientResponse{OAuthClient: client, ClientSecret: secret})
+}
+
+// adminOAuthClientDelete deletes a client, which can't get access tokens
+// anymore. The access tokens it already got are valid until they expire.
+func (a *API) adminOAuthClientDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	clientID, err := uuid.FromString(chi.URLParam(r, "client_id"))
+	if err != nil {
+		return notFoundError(ErrorCodeOAuthClientNotFound, "OAuth client not found")
+	}
+
+	client, err := models.FindOAuthClientByID(db, clientID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError(ErrorCodeOAuthClientNotFound, "OAuth client not found")
+		}
+		return internalServerError("Database error finding OAuth client").WithInternalError(err)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.Destroy(client); terr != nil {
+			return internalServerError("Database error deleting OAuth client").WithInternalError(terr)
+		}
+
+		return models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.OAuthClientDeletedAction, "", map[string]interface{}{
+			"client_id": client.ID,
+			"name":      client.Name,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+// ClientCredentialsGrant issues an access token to a client authenticating
+// with its secret, see RFC 6749 section 4.4. The token's subject is the
+// client ID and its scope the requested scopes, or else all the scopes of
+// the client.
+func (a *API) ClientCredentialsGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(ctx)
+	config := a.config
+
+	if !config.ClientCredentials.Enabled {
+		return oauthError("unsupported_grant_type", ErrorCodeValidationFailed, "")
+	}
+
+	params := &ClientCredentialsGrantParams{}
+	if isFormRequest(r) {
+		params.ClientID = r.PostFormValue("client_id")
+		params.ClientSecret = r.PostFormValue("client_secret")
+		params.Scope = r.PostFormValue("scope")
+	} else if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	// the credentials are form encoded before they are base64 encoded, see
+	// RFC 6749 section 2.3.1
+	if username, password, ok := r.BasicAuth(); ok {
+		var err error
+		if params.ClientID, err = url.QueryUnescape(username); err != nil {
+			return oauthError("invalid_client", ErrorCodeInvalidClient, "Invalid client credentials")
+		}
+		if params.ClientSecret, err = url.QueryUnescape(password); err != nil {
+			return oauthError("invalid_client", ErrorCodeInvalidClient, "Invalid client credentials")
+		}
+	}
+
+	clientID, err := uuid.FromString(params.ClientID)
+	if err != nil {
+		return oauthError("invalid_client", ErrorCodeInvalidClient, "Invalid client credentials")
+	}
+
+	client, err := models.FindOAuthClientByID(db, clientID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return oauthError("invalid_client", ErrorCodeInvalidClient, "Invalid client credentials")
+		}
+		return internalServerError("Database error finding OAuth client").WithInternalError(err)
+	}
+
+	if !client.Authenticate(params.ClientSecret) {
+		return oauthError("invalid_client", ErrorCodeInvalidClient, "Invalid client credentials")
+	}
+
+	scopes := strings.Fields(params.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes()
+	}
+	for _, scope := range scopes {
+		if !isStringInSlice(scope, client.Scopes()) {
+			return oauthError("invalid_scope", ErrorCodeValidationFailed, fmt.Sprintf("Scope %q is not allowed for this client", scope))
+		}
+	}
+	scope := strings.Join(scopes, " ")
+
+	issuedAt := a.Now().UTC()
+	expiresAt := issuedAt.Add(config.ClientCredentials.Exp)
+
+	claims := &AccessTokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   client.ID.String(),
+			Audience:  config.JWT.Aud,
+			IssuedAt:  issuedAt.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Issuer:    config.JWT.Issuer,
+		},
+		Role:     client.Role,
+		Scope:    scope,
+		ClientID: client.ID.String(),
+	}
+
+	token, err := a.signAccessToken(ctx, claims)
+	if err != nil {
+		return internalServerError("error generating jwt token").WithInternalError(err)
+	}
+
+	observability.LogEntrySetField(r, "client_id", client.ID)
+
+	w.Header().Set("Cache-Control", "no-store")
+	return sendJSON(w, http.StatusOK, &ClientCredentialsResponse{
+		Token:     token,
+		TokenType: "bearer",
+		ExpiresIn: int(config.ClientCredentials.Exp / time.Second),
+		ExpiresAt: expiresAt.Unix(),
+		Scope:     scope,
+	})
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+type OAuthClientsTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	token string
+}
+
+func TestOAuthClients(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &OAuthClientsTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *OAuthClientsTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+	ts.Config.ClientCredentials.Enabled = true
+	ts.Config.ClientCredentials.Exp = 5 * time.Minute
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+	ts.token = token
+}
+
+func (ts *OAuthClientsTestSuite) request(method, path string, body map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *OAuthClientsTestSuite) createClient(scope string) *OAuthClientResponse {
+	w := ts.request(http.MethodPost, "/admin/oauth/clients", map[string]interface{}{
+		"name":  "billing",
+		"scope": scope,
+	})
+	require.Equal(ts.T(), http.StatusCreated, w.Code, w.Body.String())
+
+	client := &OAuthClientResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(client))
+	require.NotEmpty(ts.T(), client.ClientSecret)
+	return client
+}
+
+func (ts *OAuthClientsTestSuite) grant(clientID, clientSecret, scope string, basic bool) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	if !basic {
+		form.Set("client_id", clientID)
+		form.Set("client_secret", clientSecret)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *OAuthClientsTestSuite) TestClientCredentialsGrant() {
+	client := ts.createClient("invoices:read invoices:write")
+	clientID := client.ID.String()
+
+	for _, basic := range []bool{true, false} {
+		w := ts.grant(clientID, client.ClientSecret, "", basic)
+		require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+		resp := &ClientCredentialsResponse{}
+		require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+		require.Equal(ts.T(), "invoices:read invoices:write", resp.Scope)
+		require.Equal(ts.T(), 300, resp.ExpiresIn)
+
+		token, err := ts.API.parseAccessToken(httptest.NewRequest(http.MethodGet, "/", nil).Context(), resp.Token)
+		require.NoError(ts.T(), err)
+		claims := token.Claims.(*AccessTokenClaims)
+		require.Equal(ts.T(), clientID, claims.Subject)
+		require.Equal(ts.T(), clientID, claims.ClientID)
+		require.Equal(ts.T(), "service_client", claims.Role)
+		require.Empty(ts.T(), claims.SessionId)
+	}
+
+	// a subset of the scopes can be asked for
+	w := ts.grant(clientID, client.ClientSecret, "invoices:read", true)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	resp := &ClientCredentialsResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	require.Equal(ts.T(), "invoices:read", resp.Scope)
+
+	w = ts.grant(clientID, client.ClientSecret, "invoices:delete", true)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_scope")
+
+	w = ts.grant(clientID, "wrong", "", true)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_client")
+
+	// deleted clients can't get tokens anymore
+	w = ts.request(http.MethodDelete, "/admin/oauth/clients/"+clientID, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	w = ts.grant(clientID, client.ClientSecret, "", true)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "invalid_client")
+}
+
+func (ts *OAuthClientsTestSuite) TestList() {
+	client := ts.createClient("")
+
+	w := ts.request(http.MethodGet, "/admin/oauth/clients", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	require.NotContains(ts.T(), w.Body.String(), client.ClientSecret)
+
+	resp := OAuthClientsResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(ts.T(), resp.Clients, 1)
+	require.Equal(ts.T(), client.ID, resp.Clients[0].ID)
+}
+
+func (ts *OAuthClientsTestSuite) TestCreateValidation() {
+	w := ts.request(http.MethodPost, "/admin/oauth/clients", map[string]interface{}{})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	w = ts.request(http.MethodPost, "/admin/oauth/clients", map[string]interface{}{
+		"name": "billing",
+		"role": "service_role",
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	// the roles of users are not for clients
+	for _, role := range []string{"authenticated", "anon"} {
+		w = ts.request(http.MethodPost, "/admin/oauth/clients", map[string]interface{}{
+			"name": "billing",
+			"role": role,
+		})
+		require.Equal(ts.T(), http.StatusBadRequest, w.Code, role)
+	}
+
+	w = ts.request(http.MethodPost, "/admin/oauth/clients", map[string]interface{}{
+		"name":  "billing",
+		"scope": `invoices:"read"`,
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+}
+
+func (ts *OAuthClientsTestSuite) TestDisabled() {
+	client := ts.createClient("")
+	ts.Config.ClientCredentials.Enabled = false
+
+	w := ts.grant(client.ID.String(), client.ClientSecret, "", true)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	require.Contains(ts.T(), w.Body.String(), "unsupported_grant_type")
+}
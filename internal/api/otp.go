@@ -3,11 +3,13 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 
 	"github.com/sethvargo/go-password/password"
 	"github.com/supabase/auth/internal/api/sms_provider"
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/storage"
 )
@@ -45,8 +47,8 @@ func (p *OtpParams) Validate() error {
 	return nil
 }
 
-func (p *SmsParams) Validate(smsProvider string) error {
-	if p.Phone != "" && !sms_provider.IsValidMessageChannel(p.Channel, smsProvider) {
+func (p *SmsParams) Validate(config *conf.SmsProviderConfiguration) error {
+	if p.Phone != "" && !sms_provider.IsValidMessageChannel(p.Channel, config) {
 		return badRequestError(ErrorCodeValidationFailed, InvalidChannelError)
 	}
 
@@ -102,7 +104,7 @@ type SmsOtpResponse struct {
 func (a *API) SmsOtp(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	db := a.db.WithContext(ctx)
-	config := a.config
+	config := a.getConfig(ctx)
 
 	if !config.External.Phone.Enabled {
 		return badRequestError(ErrorCodePhoneProviderDisabled, "Unsupported phone provider")
@@ -119,7 +121,7 @@ func (a *API) SmsOtp(w http.ResponseWriter, r *http.Request) error {
 		params.Channel = sms_provider.SMSProvider
 	}
 
-	if err := params.Validate(config.Sms.Provider); err != nil {
+	if err := params.Validate(&config.Sms); err != nil {
 		return err
 	}
 
@@ -134,7 +136,9 @@ func (a *API) SmsOtp(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 	if user != nil {
-		isNewUser = !user.IsPhoneConfirmed()
+		// with signups disabled, existing users that haven't confirmed
+		// yet are sent an otp instead
+		isNewUser = !user.IsPhoneConfirmed() && !config.DisableSignup
 	}
 	if isNewUser {
 		// User either doesn't exist or hasn't completed the signup process.
@@ -197,6 +201,9 @@ func (a *API) SmsOtp(w http.ResponseWriter, r *http.Request) error {
 		}
 		mID, serr := a.sendPhoneConfirmation(r, tx, user, params.Phone, phoneConfirmationOtp, smsProvider, params.Channel)
 		if serr != nil {
+			if isFeatureDisabledError(serr) || errors.Is(serr, MaxFrequencyLimitError) {
+				return serr
+			}
 			return badRequestError(ErrorCodeSMSSendFailed, "Error sending sms OTP: %v", serr).WithInternalError(serr)
 		}
 		messageID = mID
@@ -204,6 +211,9 @@ func (a *API) SmsOtp(w http.ResponseWriter, r *http.Request) error {
 	})
 
 	if err != nil {
+		if errors.Is(err, MaxFrequencyLimitError) {
+			return tooManyRequestsError(ErrorCodeOverSMSSendRateLimit, generateFrequencyLimitErrorMessage(user.ConfirmationSentAt, config.Sms.MaxFrequency))
+		}
 		return err
 	}
 
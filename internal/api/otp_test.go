@@ -228,6 +228,12 @@ func (ts *OtpTestSuite) TestOtp() {
 			data := make(map[string]interface{})
 			require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
 
+			// errors echo the generated request ID
+			if c.expected.code != http.StatusOK {
+				require.Equal(ts.T(), w.Header().Get(ts.Config.API.RequestIDHeader), data["error_id"])
+				delete(data, "error_id")
+			}
+
 			// response should be empty
 			assert.Equal(ts.T(), data, c.expected.response)
 		})
@@ -37,6 +37,17 @@ func addPaginationHeaders(w http.ResponseWriter, r *http.Request, p *models.Pagi
 	w.Header().Add("X-Total-Count", fmt.Sprintf("%v", p.Count))
 }
 
+// addCursorPaginationHeaders links the page after the cursor.
+func addCursorPaginationHeaders(w http.ResponseWriter, r *http.Request, cursor string) {
+	url, _ := url.ParseRequestURI(r.URL.String())
+	query := url.Query()
+	query.Del("page")
+	query.Set("cursor", cursor)
+	url.RawQuery = query.Encode()
+
+	w.Header().Add("Link", "<"+url.String()+">; rel=\"next\"")
+}
+
 func paginate(r *http.Request) (*models.Pagination, error) {
 	params := r.URL.Query()
 	queryPage := params.Get("page")
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 )
@@ -26,23 +27,33 @@ func (a *API) checkPasswordStrength(ctx context.Context, password string) error
 
 	var messages, reasons []string
 
-	if len(password) < config.Password.MinLength {
+	if utf8.RuneCountInString(password) < config.Password.MinLength {
 		reasons = append(reasons, "length")
 		messages = append(messages, fmt.Sprintf("Password should be at least %d characters.", config.Password.MinLength))
 	}
 
+	var missingCharacters []string
 	for _, characterSet := range config.Password.RequiredCharacters {
 		if characterSet != "" && !strings.ContainsAny(password, characterSet) {
-			reasons = append(reasons, "characters")
+			missingCharacters = append(missingCharacters, characterSet)
+		}
+	}
 
-			messages = append(messages, fmt.Sprintf("Password should contain at least one character of each: %s.", strings.Join(config.Password.RequiredCharacters, ", ")))
+	if len(missingCharacters) > 0 {
+		reasons = append(reasons, "characters")
 
-			break
-		}
+		messages = append(messages, fmt.Sprintf("Password should contain at least one character of each: %s.", strings.Join(missingCharacters, ", ")))
 	}
 
 	if config.Password.HIBP.Enabled {
-		pwned, err := a.hibpClient.Check(ctx, password)
+		checkCtx := ctx
+		if config.Password.HIBP.Timeout > 0 {
+			var cancel context.CancelFunc
+			checkCtx, cancel = context.WithTimeout(ctx, config.Password.HIBP.Timeout)
+			defer cancel()
+		}
+
+		pwned, err := a.hibpClient.Check(checkCtx, password)
 		if err != nil {
 			if config.Password.HIBP.FailClosed {
 				return internalServerError("Unable to perform password strength check with HaveIBeenPwned.org.").WithInternalError(err)
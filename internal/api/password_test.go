@@ -2,10 +2,18 @@ package api
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+	"github.com/supabase/hibp"
 )
 
 func TestPasswordStrengthChecks(t *testing.T) {
@@ -85,6 +93,19 @@ func TestPasswordStrengthChecks(t *testing.T) {
 			Password: "abc123",
 			Reasons:  nil,
 		},
+		{
+			// length is counted in characters, not bytes
+			MinLength: 6,
+			Password:  "äöüäö",
+			Reasons: []string{
+				"length",
+			},
+		},
+		{
+			MinLength: 6,
+			Password:  "äöüäöü",
+			Reasons:   nil,
+		},
 	}
 
 	for i, example := range examples {
@@ -105,3 +126,122 @@ func TestPasswordStrengthChecks(t *testing.T) {
 		}
 	}
 }
+
+func TestPasswordStrengthMessageListsMissingCharacters(t *testing.T) {
+	api := &API{
+		config: &conf.GlobalConfiguration{
+			Password: conf.PasswordConfiguration{
+				MinLength:          6,
+				RequiredCharacters: conf.PasswordRequiredCharacters{"abc", "ABC", "123"},
+			},
+		},
+	}
+
+	err := api.checkPasswordStrength(context.Background(), "aaaaaa")
+	require.Error(t, err)
+	require.Equal(t, "Password should contain at least one character of each: ABC, 123.", err.(*WeakPasswordError).Message)
+}
+
+// hibpRangeAPI mocks the Pwned Passwords range API, reporting a single
+// password as breached.
+type hibpRangeAPI struct {
+	pwned string
+	block bool
+}
+
+func (m *hibpRangeAPI) Do(req *http.Request) (*http.Response, error) {
+	if m.block {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+
+	sum := sha1.Sum([]byte(m.pwned))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	body := ""
+	if strings.HasSuffix(req.URL.Path, "/"+hash[:5]) {
+		body = hash[5:] + ":3\r\n"
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestPasswordStrengthHIBP(t *testing.T) {
+	examples := []struct {
+		desc       string
+		password   string
+		threshold  int
+		block      bool
+		failClosed bool
+
+		reasons []string
+		status  int
+	}{
+		{
+			desc:     "Pwned password",
+			password: "pwned-password",
+			reasons:  []string{"pwned"},
+		},
+		{
+			desc:     "Not pwned password",
+			password: "safe-password",
+		},
+		{
+			desc:      "Pwned password below the threshold",
+			password:  "pwned-password",
+			threshold: 5,
+		},
+		{
+			desc:     "Timeout failing open",
+			password: "pwned-password",
+			block:    true,
+		},
+		{
+			desc:       "Timeout failing closed",
+			password:   "pwned-password",
+			block:      true,
+			failClosed: true,
+			status:     http.StatusInternalServerError,
+		},
+	}
+
+	for _, example := range examples {
+		t.Run(example.desc, func(t *testing.T) {
+			api := &API{
+				config: &conf.GlobalConfiguration{
+					Password: conf.PasswordConfiguration{
+						MinLength: 6,
+						HIBP: conf.HIBPConfiguration{
+							Enabled:    true,
+							FailClosed: example.failClosed,
+							Timeout:    50 * time.Millisecond,
+						},
+					},
+				},
+				hibpClient: &hibp.PwnedClient{
+					HTTP: &utilities.HIBPRangeClient{
+						HTTP: &hibpRangeAPI{
+							pwned: "pwned-password",
+							block: example.block,
+						},
+						Threshold: example.threshold,
+					},
+				},
+			}
+
+			err := api.checkPasswordStrength(context.Background(), example.password)
+			switch {
+			case example.reasons != nil:
+				require.Equal(t, example.reasons, err.(*WeakPasswordError).Reasons)
+			case example.status != 0:
+				require.Equal(t, example.status, err.(*HTTPError).HTTPStatus)
+			default:
+				require.NoError(t, err)
+			}
+		})
+	}
+}
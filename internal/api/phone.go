@@ -8,17 +8,27 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/hooks"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/api/sms_provider"
 	"github.com/supabase/auth/internal/crypto"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 var e164Format = regexp.MustCompile("^[1-9][0-9]{1,14}$")
 
+var (
+	smsSendsCounter      = observability.ObtainMetricCounter("gotrue_sms_sends", "Number of SMS messages sent by provider")
+	smsSendErrorsCounter = observability.ObtainMetricCounter("gotrue_sms_send_errors", "Number of SMS messages that failed to send by provider")
+)
+
 const (
 	phoneConfirmationOtp     = "confirmation"
 	phoneReauthenticationOtp = "reauthentication"
@@ -37,32 +47,45 @@ func validateE164Format(phone string) bool {
 	return e164Format.MatchString(phone)
 }
 
-// formatPhoneNumber removes "+" and whitespaces in a phone number
+// phoneNumberSeparators are commonly used to group the digits of a phone
+// number, as in "+1 (555) 123-4567".
+var phoneNumberSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+// formatPhoneNumber removes "+" and separators such as whitespace, dashes and
+// parentheses in a phone number
 func formatPhoneNumber(phone string) string {
-	return strings.ReplaceAll(strings.TrimPrefix(phone, "+"), " ", "")
+	return phoneNumberSeparators.Replace(strings.TrimPrefix(strings.TrimSpace(phone), "+"))
 }
 
 // sendPhoneConfirmation sends an otp to the user's phone number
 func (a *API) sendPhoneConfirmation(r *http.Request, tx *storage.Connection, user *models.User, phone, otpType string, smsProvider sms_provider.SmsProvider, channel string) (string, error) {
 	config := a.config
 
+	if err := a.requireFeature(r.Context(), conf.FeatureSMS); err != nil {
+		return "", err
+	}
+
 	var token *string
 	var sentAt *time.Time
+	var oneTimeTokenType models.OneTimeTokenType
 
 	includeFields := []string{}
 	switch otpType {
 	case phoneChangeVerification:
 		token = &user.PhoneChangeToken
 		sentAt = user.PhoneChangeSentAt
+		oneTimeTokenType = models.PhoneChangeToken
 		user.PhoneChange = phone
 		includeFields = append(includeFields, "phone_change", "phone_change_token", "phone_change_sent_at")
 	case phoneConfirmationOtp:
 		token = &user.ConfirmationToken
 		sentAt = user.ConfirmationSentAt
+		oneTimeTokenType = models.ConfirmationToken
 		includeFields = append(includeFields, "confirmation_token", "confirmation_sent_at")
 	case phoneReauthenticationOtp:
 		token = &user.ReauthenticationToken
 		sentAt = user.ReauthenticationSentAt
+		oneTimeTokenType = models.ReauthenticationToken
 		includeFields = append(includeFields, "reauthentication_token", "reauthentication_sent_at")
 	default:
 		return "", internalServerError("invalid otp type")
@@ -84,39 +107,39 @@ func (a *API) sendPhoneConfirmation(r *http.Request, tx *storage.Connection, use
 		messageID = "test-otp"
 	}
 
-	if otp == "" { // not using test OTPs
-		otp, err = crypto.GenerateOtp(config.Sms.OtpLength)
-		if err != nil {
-			return "", internalServerError("error generating otp").WithInternalError(err)
-		}
+	// Hook should only be called if SMS autoconfirm is disabled, unless
+	// there is no provider to send with
+	useHook := config.Hook.SendSMS.Enabled && (!config.Sms.Autoconfirm || smsProvider == nil)
 
-		message, err := generateSMSFromTemplate(config.Sms.SMSTemplate, otp)
-		if err != nil {
-			return "", err
-		}
+	// Twilio Verify generates the codes it sends and checks them itself,
+	// so they are never known here
+	delegated := config.Sms.IsTwilioVerifyProvider() && !useHook
 
-		// Hook should only be called if SMS autoconfirm is disabled
-		if !config.Sms.Autoconfirm && config.Hook.SendSMS.Enabled {
-			input := hooks.SendSMSInput{
-				User: user,
-				SMS: hooks.SMS{
-					OTP: otp,
-				},
-			}
-			output := hooks.SendSMSOutput{}
-			err := a.invokeHook(tx, r, &input, &output, a.config.Hook.SendSMS.URI)
+	if otp == "" { // not using test OTPs
+		var message string
+		if !delegated {
+			otp, err = generateOtp(config.Sms.OtpLength, config.Sms.OtpAlphabet)
 			if err != nil {
-				return "", err
+				return "", internalServerError("error generating otp").WithInternalError(err)
 			}
-		} else {
-			messageID, err = smsProvider.SendMessage(phone, message, channel, otp)
+
+			smsTemplate := config.Sms.GetSMSTemplate(config.Localization.LanguageFallbacks(user.GetLanguage()))
+			message, err = generateSMSFromTemplate(smsTemplate, otp)
 			if err != nil {
-				return messageID, err
+				return "", err
 			}
 		}
+
+		messageID, err = a.sendSMS(r, tx, user, phone, message, otp, channel, smsProvider, useHook)
+		if err != nil {
+			return messageID, err
+		}
 	}
 
-	*token = crypto.GenerateTokenHash(phone, otp)
+	*token = ""
+	if otp != "" {
+		*token = crypto.GenerateTokenHash(phone, otp)
+	}
 
 	switch otpType {
 	case phoneConfirmationOtp:
@@ -131,6 +154,13 @@ func (a *API) sendPhoneConfirmation(r *http.Request, tx *storage.Connection, use
 		return messageID, errors.Wrap(err, "Database error updating user for phone")
 	}
 
+	if *token == "" {
+		if err := models.ClearOneTimeTokenForUser(tx, user.ID, oneTimeTokenType); err != nil {
+			return messageID, errors.Wrap(err, "Database error clearing one time token for phone")
+		}
+		return messageID, nil
+	}
+
 	switch otpType {
 	case phoneConfirmationOtp:
 		if err := models.CreateOneTimeToken(tx, user.ID, user.GetPhone(), user.ConfirmationToken, models.ConfirmationToken); err != nil {
@@ -149,6 +179,64 @@ func (a *API) sendPhoneConfirmation(r *http.Request, tx *storage.Connection, use
 	return messageID, nil
 }
 
+// sendSMS sends the message with the otp to the phone number, with the send
+// SMS hook or otherwise the SMS provider. It returns the ID of the message
+// at the provider.
+func (a *API) sendSMS(r *http.Request, tx *storage.Connection, user *models.User, phone, message, otp, channel string, smsProvider sms_provider.SmsProvider, useHook bool) (string, error) {
+	config := a.config
+
+	if useHook {
+		input := hooks.SendSMSInput{
+			User: user,
+			SMS: hooks.SMS{
+				OTP:     otp,
+				Phone:   phone,
+				Channel: channel,
+				Message: message,
+			},
+		}
+		output := hooks.SendSMSOutput{}
+		if err := a.invokeHook(tx, r, &input, &output, config.Hook.SendSMS.URI); err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+
+	if sender, ok := smsProvider.(sms_provider.Sender); ok {
+		log := observability.GetLogEntry(r).Entry
+
+		messageID, provider, err := sender.Send(phone, message, channel, otp, func(provider string, err error) {
+			smsSendsCounter.Add(r.Context(), 1, metric.WithAttributes(attribute.String("provider", provider)))
+			smsSendErrorsCounter.Add(r.Context(), 1, metric.WithAttributes(attribute.String("provider", provider)))
+			log.WithError(err).WithField("provider", provider).Warn("Sending SMS failed")
+		})
+		if err != nil {
+			return messageID, err
+		}
+		smsSendsCounter.Add(r.Context(), 1, metric.WithAttributes(attribute.String("provider", provider)))
+		recordSMSProvider(r, provider, messageID)
+		return messageID, nil
+	}
+
+	messageID, err := smsProvider.SendMessage(phone, message, channel, otp)
+	smsSendsCounter.Add(r.Context(), 1, metric.WithAttributes(attribute.String("provider", config.Sms.Provider)))
+	if err != nil {
+		smsSendErrorsCounter.Add(r.Context(), 1, metric.WithAttributes(attribute.String("provider", config.Sms.Provider)))
+		return messageID, err
+	}
+	recordSMSProvider(r, config.Sms.Provider, messageID)
+	return messageID, nil
+}
+
+// recordSMSProvider records the provider which sent an SMS, which may be a
+// fallback provider, on the log entry of the request.
+func recordSMSProvider(r *http.Request, provider, messageID string) {
+	observability.LogEntrySetFields(r, logrus.Fields{
+		"sms_provider":   provider,
+		"sms_message_id": messageID,
+	})
+}
+
 func generateSMSFromTemplate(SMSTemplate *template.Template, otp string) (string, error) {
 	var message bytes.Buffer
 	if err := SMSTemplate.Execute(&message, struct {
@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -29,13 +30,22 @@ type TestSmsProvider struct {
 	mock.Mock
 
 	SentMessages int
+	LastOTP      string
 }
 
 func (t *TestSmsProvider) SendMessage(phone, message, channel, otp string) (string, error) {
 	t.SentMessages += 1
+	t.LastOTP = otp
 	return "", nil
 }
 
+// FailingSmsProvider simulates an outage of the SMS provider.
+type FailingSmsProvider struct{}
+
+func (t *FailingSmsProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	return "", errors.New("provider unavailable")
+}
+
 func TestPhone(t *testing.T) {
 	api, config, err := setupAPIForTest()
 	require.NoError(t, err)
@@ -66,6 +76,143 @@ func (ts *PhoneTestSuite) TestValidateE164Format() {
 func (ts *PhoneTestSuite) TestFormatPhoneNumber() {
 	actual := formatPhoneNumber("+1 23456789 ")
 	assert.Equal(ts.T(), "123456789", actual)
+
+	actual = formatPhoneNumber("+1 (555) 123-4567")
+	assert.Equal(ts.T(), "15551234567", actual)
+
+	actual = formatPhoneNumber(" +44.20.7946.0958")
+	assert.Equal(ts.T(), "442079460958", actual)
+}
+
+func (ts *PhoneTestSuite) TestPhoneSignupVerifyAndSignIn() {
+	ts.Config.External.Phone.Enabled = true
+	ts.Config.Sms.Provider = "twilio"
+	ts.Config.Sms.Twilio.AccountSid = "test_account_sid"
+	ts.Config.Sms.Twilio.AuthToken = "test_auth_token"
+	ts.Config.Sms.Twilio.MessageServiceSid = "test_message_service_sid"
+	ts.Config.Sms.Autoconfirm = false
+	// test otps don't reach the sms provider
+	ts.Config.Sms.TestOTP = map[string]string{
+		"15551234567": "123456",
+	}
+	defer func() {
+		ts.Config.Sms.TestOTP = nil
+	}()
+
+	post := func(path string, body map[string]interface{}) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+		req := httptest.NewRequest(http.MethodPost, path, &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// the number is normalized to E.164 on signup
+	w := post("http://localhost/signup", map[string]interface{}{
+		"phone":    "+1 (555) 123-4567",
+		"password": "testpassword",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	u, err := models.FindUserByPhoneAndAudience(ts.API.db, "15551234567", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.False(ts.T(), u.IsPhoneConfirmed())
+
+	// the phone isn't confirmed yet
+	w = post("http://localhost/token?grant_type=password", map[string]interface{}{
+		"phone":    "+15551234567",
+		"password": "testpassword",
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+
+	w = post("http://localhost/verify", map[string]interface{}{
+		"type":  smsVerification,
+		"phone": "+15551234567",
+		"token": "123456",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	require.NotEmpty(ts.T(), token.Token)
+	require.NotEmpty(ts.T(), token.RefreshToken)
+
+	require.NoError(ts.T(), ts.API.db.Reload(u))
+	require.True(ts.T(), u.IsPhoneConfirmed())
+
+	w = post("http://localhost/token?grant_type=password", map[string]interface{}{
+		"phone":    "1-555-123-4567",
+		"password": "testpassword",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// signing up again doesn't create a second user with the number
+	post("http://localhost/signup", map[string]interface{}{
+		"phone":    "15551234567",
+		"password": "testpassword",
+	})
+	count, err := ts.API.db.Q().Where("phone = ? and aud = ?", "15551234567", ts.Config.JWT.Aud).Count(&models.User{})
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 1, count)
+}
+
+func (ts *PhoneTestSuite) TestPhoneOtpSignIn() {
+	sms, phoneEnabled := ts.Config.Sms, ts.Config.External.Phone.Enabled
+	defer func() {
+		ts.Config.Sms, ts.Config.External.Phone.Enabled = sms, phoneEnabled
+	}()
+
+	ts.Config.External.Phone.Enabled = true
+	ts.Config.Sms.Provider = "twilio"
+	ts.Config.Sms.Twilio.AccountSid = "test_account_sid"
+	ts.Config.Sms.Twilio.AuthToken = "test_auth_token"
+	ts.Config.Sms.Twilio.MessageServiceSid = "test_message_service_sid"
+	ts.Config.Sms.MaxFrequency = time.Minute
+	ts.Config.Sms.TestOTP = map[string]string{
+		"123456789": "123456",
+	}
+
+	u, err := models.FindUserByPhoneAndAudience(ts.API.db, "123456789", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.PhoneConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.UpdateOnly(u, "phone_confirmed_at"))
+
+	post := func(path string, body map[string]interface{}) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+		req := httptest.NewRequest(http.MethodPost, path, &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("http://localhost/otp", map[string]interface{}{
+		"phone": "123456789",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// a new code can't be sent right away
+	w = post("http://localhost/otp", map[string]interface{}{
+		"phone": "123456789",
+	})
+	require.Equal(ts.T(), http.StatusTooManyRequests, w.Code, w.Body.String())
+
+	w = post("http://localhost/verify", map[string]interface{}{
+		"type":  smsVerification,
+		"phone": "123456789",
+		"token": "123456",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	require.NotEmpty(ts.T(), token.Token)
+	require.NotEmpty(ts.T(), token.RefreshToken)
+	require.Equal(ts.T(), u.ID, token.User.ID)
 }
 
 func doTestSendPhoneConfirmation(ts *PhoneTestSuite, useTestOTP bool) {
@@ -262,6 +409,164 @@ func (ts *PhoneTestSuite) TestMissingSmsProviderConfig() {
 		}
 	}
 }
+func (ts *PhoneTestSuite) userUpdateRequest(u *models.User, body map[string]interface{}) *httptest.ResponseRecorder {
+	s, err := models.NewSession(u.ID, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(s))
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(http.MethodPut, "http://localhost/user", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	token, _, err := ts.API.generateAccessToken(req, ts.API.db, u, &s.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *PhoneTestSuite) TestPhoneChangeOTPRoundTrip() {
+	u, err := models.FindUserByPhoneAndAudience(ts.API.db, "123456789", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	provider := &TestSmsProvider{}
+	req := httptest.NewRequest(http.MethodPut, "/user", nil)
+	_, err = ts.API.sendPhoneConfirmation(req, ts.API.db, u, "234567890", phoneChangeVerification, provider, sms_provider.SMSProvider)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), 1, provider.SentMessages)
+	require.NotEmpty(ts.T(), provider.LastOTP)
+
+	// the number is only changed once the otp is verified
+	require.NoError(ts.T(), ts.API.db.Reload(u))
+	require.Equal(ts.T(), "123456789", u.GetPhone())
+	require.Equal(ts.T(), "234567890", u.PhoneChange)
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":  phoneChangeVerification,
+		"phone": "234567890",
+		"token": provider.LastOTP,
+	}))
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(ts.T(), ts.API.db.Reload(u))
+	require.Equal(ts.T(), "234567890", u.GetPhone())
+	require.Empty(ts.T(), u.PhoneChange)
+
+	identity, err := models.FindIdentityByIdAndProvider(ts.API.db, u.ID.String(), "phone")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "234567890", identity.IdentityData["phone"])
+}
+
+func (ts *PhoneTestSuite) TestSendPhoneConfirmationMetrics() {
+	metricsRegistry()
+
+	ts.Config.Sms.Provider = "twilio"
+	ts.Config.Sms.TestOTP = nil
+
+	u, err := models.FindUserByPhoneAndAudience(ts.API.db, "123456789", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	req := httptest.NewRequest(http.MethodPost, "/otp", nil)
+	_, err = ts.API.sendPhoneConfirmation(req, ts.API.db, u, "123456789", phoneConfirmationOtp, &TestSmsProvider{}, sms_provider.SMSProvider)
+	require.NoError(ts.T(), err)
+
+	u.ConfirmationSentAt = nil
+	_, err = ts.API.sendPhoneConfirmation(req, ts.API.db, u, "123456789", phoneConfirmationOtp, &FailingSmsProvider{}, sms_provider.SMSProvider)
+	require.Error(ts.T(), err)
+
+	metrics := scrapeMetrics(ts.T())
+	require.Regexp(ts.T(), `gotrue_sms_sends_total\{[^}]*provider="twilio"[^}]*\} [1-9]`, metrics)
+	require.Regexp(ts.T(), `gotrue_sms_send_errors_total\{[^}]*provider="twilio"[^}]*\} [1-9]`, metrics)
+}
+
+func (ts *PhoneTestSuite) TestPhoneChangeDuplicateNumber() {
+	u, err := models.FindUserByPhoneAndAudience(ts.API.db, "123456789", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	other, err := models.NewUser("234567890", "", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(other))
+
+	ts.Run("Requesting a number owned by another user", func() {
+		w := ts.userUpdateRequest(u, map[string]interface{}{
+			"phone": "234567890",
+		})
+		require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+		data := &HTTPError{}
+		require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+		require.Equal(ts.T(), ErrorCodePhoneExists, data.ErrorCode)
+	})
+
+	ts.Run("Verifying a number taken after the change was requested", func() {
+		provider := &TestSmsProvider{}
+		req := httptest.NewRequest(http.MethodPut, "/user", nil)
+		_, err := ts.API.sendPhoneConfirmation(req, ts.API.db, u, "345678901", phoneChangeVerification, provider, sms_provider.SMSProvider)
+		require.NoError(ts.T(), err)
+
+		other.Phone = "345678901"
+		require.NoError(ts.T(), ts.API.db.UpdateOnly(other, "phone"))
+
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"type":  phoneChangeVerification,
+			"phone": "345678901",
+			"token": provider.LastOTP,
+		}))
+		req = httptest.NewRequest(http.MethodPost, "http://localhost/verify", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+		require.NoError(ts.T(), ts.API.db.Reload(u))
+		require.Equal(ts.T(), "123456789", u.GetPhone())
+	})
+}
+
+func (ts *PhoneTestSuite) TestPhoneChangeResendIsRateLimited() {
+	u, err := models.FindUserByPhoneAndAudience(ts.API.db, "123456789", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	ts.Config.External.Phone.Enabled = true
+	ts.Config.Sms.Provider = "twilio"
+	ts.Config.Sms.Twilio.AccountSid = "test_account_sid"
+	ts.Config.Sms.Twilio.AuthToken = "test_auth_token"
+	ts.Config.Sms.Twilio.MessageServiceSid = "test_message_service_sid"
+	ts.Config.Sms.MaxFrequency = time.Minute
+	// test otps don't reach the sms provider
+	ts.Config.Sms.TestOTP = map[string]string{
+		"234567890": "123456",
+	}
+	defer func() {
+		ts.Config.Sms.TestOTP = nil
+	}()
+
+	w := ts.userUpdateRequest(u, map[string]interface{}{
+		"phone": "234567890",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(ts.T(), ts.API.db.Reload(u))
+	w = ts.userUpdateRequest(u, map[string]interface{}{
+		"phone": "234567890",
+	})
+	require.Equal(ts.T(), http.StatusTooManyRequests, w.Code, w.Body.String())
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeOverSMSSendRateLimit, data.ErrorCode)
+}
+
 func (ts *PhoneTestSuite) TestSendSMSHook() {
 	u, err := models.FindUserByPhoneAndAudience(ts.API.db, "123456789", ts.Config.JWT.Aud)
 	require.NoError(ts.T(), err)
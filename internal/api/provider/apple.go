@@ -2,12 +2,18 @@ package provider
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	jwt "github.com/golang-jwt/jwt"
 	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/conf"
 	"golang.org/x/oauth2"
@@ -15,10 +21,19 @@ import (
 
 const IssuerApple = "https://appleid.apple.com"
 
+// appleClientSecretExpiry is how long the generated client secrets are
+// valid. They are generated for each code exchange.
+const appleClientSecretExpiry = 5 * time.Minute
+
 // AppleProvider stores the custom config for apple provider
 type AppleProvider struct {
 	*oauth2.Config
 	oidc *oidc.Provider
+
+	// the client secrets are generated with the key when it's configured
+	teamID     string
+	keyID      string
+	privateKey *ecdsa.PrivateKey
 }
 
 type IsPrivateEmail bool
@@ -59,11 +74,19 @@ type appleUser struct {
 }
 
 // NewAppleProvider creates a Apple account provider.
-func NewAppleProvider(ctx context.Context, ext conf.OAuthProviderConfiguration) (OAuthProvider, error) {
+func NewAppleProvider(ctx context.Context, ext conf.AppleProviderConfiguration) (OAuthProvider, error) {
 	if err := ext.ValidateOAuth(); err != nil {
 		return nil, err
 	}
 
+	var privateKey *ecdsa.PrivateKey
+	if ext.HasKey() {
+		var err error
+		if privateKey, err = ParseApplePrivateKey(ext.PrivateKey); err != nil {
+			return nil, err
+		}
+	}
+
 	if ext.URL != "" {
 		logrus.Warn("Apple OAuth provider has URL config set which is ignored (check GOTRUE_EXTERNAL_APPLE_URL)")
 	}
@@ -84,17 +107,69 @@ func NewAppleProvider(ctx context.Context, ext conf.OAuthProviderConfiguration)
 			},
 			RedirectURL: ext.RedirectURI,
 		},
-		oidc: oidcProvider,
+		oidc:       oidcProvider,
+		teamID:     ext.TeamID,
+		keyID:      ext.KeyID,
+		privateKey: privateKey,
 	}, nil
 }
 
 // GetOAuthToken returns the apple provider access token
 func (p AppleProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
+	config := p.Config
+	if p.privateKey != nil {
+		secret, err := NewAppleClientSecret(p.teamID, p.keyID, p.ClientID, p.privateKey, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		withSecret := *p.Config
+		withSecret.ClientSecret = secret
+		config = &withSecret
+	}
+
 	opts := []oauth2.AuthCodeOption{
-		oauth2.SetAuthURLParam("client_id", p.ClientID),
-		oauth2.SetAuthURLParam("secret", p.ClientSecret),
+		oauth2.SetAuthURLParam("client_id", config.ClientID),
+		oauth2.SetAuthURLParam("secret", config.ClientSecret),
+	}
+	return config.Exchange(context.Background(), code, opts...)
+}
+
+// ParseApplePrivateKey parses the PEM encoded .p8 key of an Apple developer
+// account. Escaped newlines are accepted, as keys are often configured in a
+// single line.
+func ParseApplePrivateKey(value string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(value, `\n`, "\n")))
+	if block == nil {
+		return nil, errors.New("apple private key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
 	}
-	return p.Exchange(context.Background(), code, opts...)
+
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apple private key is not an ECDSA key")
+	}
+	return ecdsaKey, nil
+}
+
+// NewAppleClientSecret generates a client secret, a JWT signed with the key
+// of the Apple developer account as described in
+// https://developer.apple.com/documentation/accountorganizationaldatasharing/creating-a-client-secret
+func NewAppleClientSecret(teamID, keyID, clientID string, key *ecdsa.PrivateKey, now time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.StandardClaims{
+		Issuer:    teamID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(appleClientSecretExpiry).Unix(),
+		Audience:  IssuerApple,
+		Subject:   clientID,
+	})
+	token.Header["kid"] = keyID
+
+	return token.SignedString(key)
 }
 
 func (p AppleProvider) AuthCodeURL(state string, args ...oauth2.AuthCodeOption) string {
@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppleClientSecret(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	encoded := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	// keys configured in a single line have escaped newlines
+	parsed, err := ParseApplePrivateKey(strings.ReplaceAll(encoded, "\n", `\n`))
+	require.NoError(t, err)
+	require.True(t, key.Equal(parsed))
+
+	now := time.Now()
+	secret, err := NewAppleClientSecret("TEAM123456", "KEY1234567", "com.example.app", parsed, now)
+	require.NoError(t, err)
+
+	claims := &jwt.StandardClaims{}
+	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodES256.Name}}
+	token, err := p.ParseWithClaims(secret, claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "KEY1234567", token.Header["kid"])
+	require.Equal(t, "TEAM123456", claims.Issuer)
+	require.Equal(t, "com.example.app", claims.Subject)
+	require.Equal(t, IssuerApple, claims.Audience)
+	require.Equal(t, now.Unix(), claims.IssuedAt)
+	require.Equal(t, now.Add(appleClientSecretExpiry).Unix(), claims.ExpiresAt)
+}
+
+func TestParseApplePrivateKeyInvalid(t *testing.T) {
+	_, err := ParseApplePrivateKey("not a key")
+	require.Error(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	_, err = ParseApplePrivateKey(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	require.EqualError(t, err, "apple private key is not an ECDSA key")
+}
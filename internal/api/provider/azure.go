@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofrs/uuid"
 	"github.com/supabase/auth/internal/conf"
 	"golang.org/x/oauth2"
 )
@@ -39,7 +40,7 @@ type azureProvider struct {
 }
 
 var azureIssuerRegexp = regexp.MustCompile("^https://login[.]microsoftonline[.]com/([^/]+)/v2[.]0/?$")
-var azureCIAMIssuerRegexp = regexp.MustCompile("^https://[a-z0-9-]+[.]ciamlogin[.]com/([^/]+)/v2[.]0/?$")
+var azureCIAMIssuerRegexp = regexp.MustCompile("^https://([a-z0-9-]+)[.]ciamlogin[.]com/([^/]+)/v2[.]0/?$")
 
 func IsAzureIssuer(issuer string) bool {
 	return azureIssuerRegexp.MatchString(issuer)
@@ -50,7 +51,7 @@ func IsAzureCIAMIssuer(issuer string) bool {
 }
 
 // NewAzureProvider creates a Azure account provider.
-func NewAzureProvider(ext conf.OAuthProviderConfiguration, scopes string) (OAuthProvider, error) {
+func NewAzureProvider(ext conf.AzureProviderConfiguration, scopes string) (OAuthProvider, error) {
 	if err := ext.ValidateOAuth(); err != nil {
 		return nil, err
 	}
@@ -61,18 +62,19 @@ func NewAzureProvider(ext conf.OAuthProviderConfiguration, scopes string) (OAuth
 		oauthScopes = append(oauthScopes, strings.Split(scopes, ",")...)
 	}
 
-	authHost := chooseHost(ext.URL, defaultAzureAuthBase)
-	expectedIssuer := ""
+	base := ext.URL
+	if base == "" && ext.TenantID != "" {
+		base = "https://login.microsoftonline.com/" + ext.TenantID
+	}
 
-	if ext.URL != "" {
-		expectedIssuer = authHost + "/v2.0"
+	authHost := chooseHost(base, defaultAzureAuthBase)
+	expectedIssuer := ""
 
-		if !IsAzureIssuer(expectedIssuer) || !IsAzureCIAMIssuer(expectedIssuer) || expectedIssuer == IssuerAzureCommon || expectedIssuer == IssuerAzureOrganizations {
-			// in tests, the URL is a local server which should not
-			// be the expected issuer
-			// also, IssuerAzure (common) never actually issues any
-			// ID tokens so it needs to be ignored
-			expectedIssuer = ""
+	if base != "" {
+		var err error
+		expectedIssuer, err = azureExpectedIssuer(authHost+"/v2.0", ext.TenantID)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -91,6 +93,50 @@ func NewAzureProvider(ext conf.OAuthProviderConfiguration, scopes string) (OAuth
 	}, nil
 }
 
+// azureExpectedIssuer returns the issuer of the ID tokens of the
+// authorization endpoints at the issuer URL, or an empty string if they can
+// be issued by any tenant. ID tokens are issued with the ID of the tenant, so
+// tenantID is used when the URL names the tenant by its domain.
+func azureExpectedIssuer(issuer, tenantID string) (string, error) {
+	var tenant string
+	var ciam bool
+	if match := azureIssuerRegexp.FindStringSubmatch(issuer); match != nil {
+		tenant = match[1]
+	} else if match := azureCIAMIssuerRegexp.FindStringSubmatch(issuer); match != nil {
+		tenant = match[2]
+		ciam = true
+	} else {
+		// in tests, the URL is a local server which should not be the
+		// expected issuer
+		return "", nil
+	}
+
+	switch tenant {
+	case "consumers":
+		// personal accounts are issued by the microsoft.com tenant
+		return IssuerAzureMicrosoft, nil
+
+	case "common", "organizations":
+		// these endpoints never issue any ID tokens themselves
+		return "", nil
+	}
+
+	id, err := uuid.FromString(tenant)
+	if err != nil {
+		if id, err = uuid.FromString(tenantID); err != nil {
+			return "", fmt.Errorf("azure: the ID tokens of tenant %q are issued with its tenant ID, which must be configured", tenant)
+		}
+	}
+
+	if ciam {
+		// External ID tenants issue ID tokens from the subdomain of
+		// their tenant ID, whatever the subdomain of the URL
+		return "https://" + id.String() + ".ciamlogin.com/" + id.String() + "/v2.0", nil
+	}
+
+	return "https://login.microsoftonline.com/" + id.String() + "/v2.0", nil
+}
+
 func (g azureProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
 	return g.Exchange(context.Background(), code)
 }
@@ -117,6 +163,26 @@ func DetectAzureIDTokenIssuer(ctx context.Context, idToken string) (string, erro
 	return payload.Issuer, nil
 }
 
+// checkIssuer returns an error if ID tokens of the issuer are not accepted.
+func (g azureProvider) checkIssuer(issuer string) error {
+	// Allow basic Azure issuers, except when the expected issuer
+	// is configured to be the Azure CIAM issuer, allow CIAM
+	// issuers to pass.
+	if !IsAzureIssuer(issuer) && (IsAzureCIAMIssuer(g.ExpectedIssuer) && !IsAzureCIAMIssuer(issuer)) {
+		return fmt.Errorf("azure: ID token issuer not valid %q", issuer)
+	}
+
+	if g.ExpectedIssuer != "" && issuer != g.ExpectedIssuer {
+		// Since ExpectedIssuer was set, then the developer had
+		// setup GoTrue to use the tenant-specific
+		// authorization endpoint, which in-turn means that
+		// only those tenant's ID tokens will be accepted.
+		return fmt.Errorf("azure: ID token issuer %q does not match expected issuer %q", issuer, g.ExpectedIssuer)
+	}
+
+	return nil
+}
+
 func (g azureProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
 	idToken := tok.Extra("id_token")
 
@@ -126,19 +192,8 @@ func (g azureProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*Use
 			return nil, err
 		}
 
-		// Allow basic Azure issuers, except when the expected issuer
-		// is configured to be the Azure CIAM issuer, allow CIAM
-		// issuers to pass.
-		if !IsAzureIssuer(issuer) && (IsAzureCIAMIssuer(g.ExpectedIssuer) && !IsAzureCIAMIssuer(issuer)) {
-			return nil, fmt.Errorf("azure: ID token issuer not valid %q", issuer)
-		}
-
-		if g.ExpectedIssuer != "" && issuer != g.ExpectedIssuer {
-			// Since ExpectedIssuer was set, then the developer had
-			// setup GoTrue to use the tenant-specific
-			// authorization endpoint, which in-turn means that
-			// only those tenant's ID tokens will be accepted.
-			return nil, fmt.Errorf("azure: ID token issuer %q does not match expected issuer %q", issuer, g.ExpectedIssuer)
+		if err := g.checkIssuer(issuer); err != nil {
+			return nil, err
 		}
 
 		provider, err := oidc.NewProvider(ctx, issuer)
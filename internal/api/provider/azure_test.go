@@ -1,6 +1,18 @@
 package provider
 
-import "testing"
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+)
 
 func TestIsAzureIssuer(t *testing.T) {
 	positiveExamples := []string{
@@ -27,3 +39,153 @@ func TestIsAzureIssuer(t *testing.T) {
 		}
 	}
 }
+
+func TestAzureProviderTenant(t *testing.T) {
+	const tenant = "6f2e1a4c-1f5d-4b39-9c2a-3f0f8b1f6c7e"
+
+	examples := []struct {
+		tenantID string
+		url      string
+
+		authURL        string
+		expectedIssuer string
+		err            bool
+	}{
+		{
+			authURL: "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+		},
+		{
+			tenantID:       tenant,
+			authURL:        "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/authorize",
+			expectedIssuer: "https://login.microsoftonline.com/" + tenant + "/v2.0",
+		},
+		{
+			tenantID: "organizations",
+			authURL:  "https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize",
+		},
+		{
+			tenantID:       "consumers",
+			authURL:        "https://login.microsoftonline.com/consumers/oauth2/v2.0/authorize",
+			expectedIssuer: IssuerAzureMicrosoft,
+		},
+		{
+			// tokens are issued with the tenant ID, not the domain
+			tenantID: "contoso.onmicrosoft.com",
+			authURL:  "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/authorize",
+			err:      true,
+		},
+		{
+			tenantID:       tenant,
+			url:            "https://login.microsoftonline.com/contoso.onmicrosoft.com",
+			authURL:        "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/authorize",
+			expectedIssuer: "https://login.microsoftonline.com/" + tenant + "/v2.0",
+		},
+		{
+			tenantID:       "common",
+			url:            "https://login.microsoftonline.com/" + tenant + "/",
+			authURL:        "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/authorize",
+			expectedIssuer: "https://login.microsoftonline.com/" + tenant + "/v2.0",
+		},
+		{
+			// External ID tenants issue ID tokens from the subdomain
+			// of their tenant ID
+			url:            "https://contoso.ciamlogin.com/" + tenant,
+			authURL:        "https://contoso.ciamlogin.com/" + tenant + "/oauth2/v2.0/authorize",
+			expectedIssuer: "https://" + tenant + ".ciamlogin.com/" + tenant + "/v2.0",
+		},
+		{
+			tenantID:       tenant,
+			url:            "https://contoso.ciamlogin.com/contoso.onmicrosoft.com",
+			authURL:        "https://contoso.ciamlogin.com/contoso.onmicrosoft.com/oauth2/v2.0/authorize",
+			expectedIssuer: "https://" + tenant + ".ciamlogin.com/" + tenant + "/v2.0",
+		},
+		{
+			url:     "https://contoso.ciamlogin.com/contoso.onmicrosoft.com",
+			authURL: "https://contoso.ciamlogin.com/contoso.onmicrosoft.com/oauth2/v2.0/authorize",
+			err:     true,
+		},
+		{
+			url:     "http://localhost:1234",
+			authURL: "http://localhost:1234/oauth2/v2.0/authorize",
+		},
+	}
+
+	for _, example := range examples {
+		p, err := NewAzureProvider(conf.AzureProviderConfiguration{
+			OAuthProviderConfiguration: conf.OAuthProviderConfiguration{
+				Enabled:     true,
+				ClientID:    []string{"client"},
+				Secret:      "secret",
+				RedirectURI: "https://auth.example.com/callback",
+				URL:         example.url,
+			},
+			TenantID: example.tenantID,
+		}, "")
+		if example.err {
+			require.Error(t, err, example)
+			continue
+		}
+		require.NoError(t, err)
+
+		azure := p.(*azureProvider)
+		require.Equal(t, example.authURL, azure.Endpoint.AuthURL, example)
+		require.Equal(t, example.expectedIssuer, azure.ExpectedIssuer, example)
+	}
+}
+
+func TestAzureProviderCIAMIssuer(t *testing.T) {
+	const tenant = "6f2e1a4c-1f5d-4b39-9c2a-3f0f8b1f6c7e"
+
+	p, err := NewAzureProvider(conf.AzureProviderConfiguration{
+		OAuthProviderConfiguration: conf.OAuthProviderConfiguration{
+			Enabled:     true,
+			ClientID:    []string{"client"},
+			Secret:      "secret",
+			RedirectURI: "https://auth.example.com/callback",
+			URL:         "https://contoso.ciamlogin.com/contoso.onmicrosoft.com",
+		},
+		TenantID: tenant,
+	}, "")
+	require.NoError(t, err)
+
+	azure := p.(*azureProvider)
+	require.NoError(t, azure.checkIssuer("https://"+tenant+".ciamlogin.com/"+tenant+"/v2.0"))
+	require.Error(t, azure.checkIssuer("https://contoso.ciamlogin.com/"+tenant+"/v2.0"))
+	require.Error(t, azure.checkIssuer("https://login.microsoftonline.com/"+tenant+"/v2.0"))
+	require.Error(t, azure.checkIssuer("https://9188040d-6c67-4c5b-b112-36a304b66dad.ciamlogin.com/9188040d-6c67-4c5b-b112-36a304b66dad/v2.0"))
+}
+
+func TestParseAzureIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                IssuerAzureMicrosoft,
+		"aud":                "client",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"sub":                "pairwise-subject",
+		"oid":                "00000000-0000-0000-66f3-3332eca7ea81",
+		"tid":                "9188040d-6c67-4c5b-b112-36a304b66dad",
+		"name":               "Azure Test",
+		"preferred_username": "azure@example.com",
+		"email":              "azure@example.com",
+	}).SignedString(key)
+	require.NoError(t, err)
+
+	verifier := oidc.NewVerifier(IssuerAzureMicrosoft, &oidc.StaticKeySet{
+		PublicKeys: []crypto.PublicKey{&key.PublicKey},
+	}, &oidc.Config{ClientID: "client"})
+	token, err := verifier.Verify(context.Background(), idToken)
+	require.NoError(t, err)
+
+	_, data, err := parseAzureIDToken(token)
+	require.NoError(t, err)
+	require.Equal(t, "pairwise-subject", data.Metadata.Subject)
+	require.Equal(t, "azure@example.com", data.Metadata.PreferredUsername)
+	require.Equal(t, "Azure Test", data.Metadata.FullName)
+	require.Equal(t, map[string]any{
+		"oid":   "00000000-0000-0000-66f3-3332eca7ea81",
+		"tid":   "9188040d-6c67-4c5b-b112-36a304b66dad",
+		"email": "azure@example.com",
+	}, data.Metadata.CustomClaims)
+}
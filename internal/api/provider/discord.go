@@ -80,31 +80,15 @@ func (g discordProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*U
 		}}
 	}
 
-	var avatarURL string
-	extension := "png"
-	if u.Avatar == "" {
-		if intDiscriminator, err := strconv.Atoi(u.Discriminator); err != nil {
-			return nil, err
-		} else {
-			// https://discord.com/developers/docs/reference#image-formatting-cdn-endpoints:
-			// In the case of the Default User Avatar endpoint, the value for
-			// user_discriminator in the path should be the user's discriminator modulo 5
-			avatarURL = fmt.Sprintf("https://cdn.discordapp.com/embed/avatars/%d.%s", intDiscriminator%5, extension)
-		}
-	} else {
-		// https://discord.com/developers/docs/reference#image-formatting:
-		// "In the case of endpoints that support GIFs, the hash will begin with a_
-		// if it is available in GIF format."
-		if strings.HasPrefix(u.Avatar, "a_") {
-			extension = "gif"
-		}
-		avatarURL = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.%s", u.ID, u.Avatar, extension)
+	avatarURL, err := u.avatarURL()
+	if err != nil {
+		return nil, err
 	}
 
 	data.Metadata = &Claims{
 		Issuer:  g.APIPath,
 		Subject: u.ID,
-		Name:    fmt.Sprintf("%v#%v", u.Name, u.Discriminator),
+		Name:    u.tag(),
 		Picture: avatarURL,
 		CustomClaims: map[string]interface{}{
 			"global_name": u.GlobalName,
@@ -118,3 +102,52 @@ func (g discordProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*U
 
 	return data, nil
 }
+
+// hasDiscriminator reports whether the user still has a legacy username with
+// a discriminator, rather than a unique username.
+func (u discordUser) hasDiscriminator() bool {
+	return u.Discriminator != "" && u.Discriminator != "0"
+}
+
+// tag returns the name the user is known by on Discord.
+func (u discordUser) tag() string {
+	if !u.hasDiscriminator() {
+		return u.Name
+	}
+	return fmt.Sprintf("%v#%v", u.Name, u.Discriminator)
+}
+
+func (u discordUser) avatarURL() (string, error) {
+	extension := "png"
+
+	if u.Avatar != "" {
+		// https://discord.com/developers/docs/reference#image-formatting:
+		// "In the case of endpoints that support GIFs, the hash will begin with a_
+		// if it is available in GIF format."
+		if strings.HasPrefix(u.Avatar, "a_") {
+			extension = "gif"
+		}
+		return fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.%s", u.ID, u.Avatar, extension), nil
+	}
+
+	// https://discord.com/developers/docs/reference#image-formatting-cdn-endpoints:
+	// In the case of the Default User Avatar endpoint, the value for
+	// index parameter is (user_id >> 22) % 6 for users on the new
+	// username system, and the discriminator modulo 5 for legacy users
+	var index uint64
+	if u.hasDiscriminator() {
+		discriminator, err := strconv.ParseUint(u.Discriminator, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		index = discriminator % 5
+	} else {
+		id, err := strconv.ParseUint(u.ID, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		index = (id >> 22) % 6
+	}
+
+	return fmt.Sprintf("https://cdn.discordapp.com/embed/avatars/%d.%s", index, extension), nil
+}
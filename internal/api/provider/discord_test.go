@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscordUserAvatar(t *testing.T) {
+	examples := []struct {
+		user discordUser
+
+		tag       string
+		avatarURL string
+	}{
+		{
+			user:      discordUser{ID: "80351110224678912", Name: "nelly", Discriminator: "1337", Avatar: "8342729096ea3675442027381ff50dfe"},
+			tag:       "nelly#1337",
+			avatarURL: "https://cdn.discordapp.com/avatars/80351110224678912/8342729096ea3675442027381ff50dfe.png",
+		},
+		{
+			user:      discordUser{ID: "80351110224678912", Name: "nelly", Discriminator: "0", Avatar: "a_8342729096ea3675442027381ff50dfe"},
+			tag:       "nelly",
+			avatarURL: "https://cdn.discordapp.com/avatars/80351110224678912/a_8342729096ea3675442027381ff50dfe.gif",
+		},
+		{
+			user:      discordUser{ID: "80351110224678912", Name: "nelly", Discriminator: "1337"},
+			tag:       "nelly#1337",
+			avatarURL: "https://cdn.discordapp.com/embed/avatars/2.png",
+		},
+		{
+			user:      discordUser{ID: "80351110224678912", Name: "nelly", Discriminator: "0"},
+			tag:       "nelly",
+			avatarURL: "https://cdn.discordapp.com/embed/avatars/5.png",
+		},
+		{
+			user:      discordUser{ID: "80351110224678913", Name: "nelly"},
+			tag:       "nelly",
+			avatarURL: "https://cdn.discordapp.com/embed/avatars/5.png",
+		},
+	}
+
+	for _, example := range examples {
+		avatarURL, err := example.user.avatarURL()
+		require.NoError(t, err)
+		require.Equal(t, example.avatarURL, avatarURL)
+		require.Equal(t, example.tag, example.user.tag())
+	}
+}
@@ -2,7 +2,6 @@ package provider
 
 import (
 	"context"
-	"strings"
 
 	"github.com/supabase/auth/internal/conf"
 	"golang.org/x/oauth2"
@@ -17,7 +16,7 @@ const (
 )
 
 type figmaProvider struct {
-	*oauth2.Config
+	oauth2Provider
 	APIHost string
 }
 
@@ -30,44 +29,27 @@ type figmaUser struct {
 
 // NewFigmaProvider creates a Figma account provider.
 func NewFigmaProvider(ext conf.OAuthProviderConfiguration, scopes string) (OAuthProvider, error) {
-	if err := ext.ValidateOAuth(); err != nil {
-		return nil, err
-	}
-
 	authHost := chooseHost(ext.URL, defaultFigmaAuthBase)
 	apiHost := chooseHost(ext.URL, defaultFigmaAPIBase)
 
 	// Figma only provides the "file_read" scope.
-	oauthScopes := []string{
-		"file_read",
-	}
-
-	if scopes != "" {
-		oauthScopes = append(oauthScopes, strings.Split(scopes, ",")...)
+	base, err := newOAuth2Provider(ext, oauth2.Endpoint{
+		AuthURL:  authHost + "/oauth",
+		TokenURL: authHost + "/api/oauth/token",
+	}, apiHost+"/v1/me", []string{"file_read"}, scopes)
+	if err != nil {
+		return nil, err
 	}
 
 	return &figmaProvider{
-		Config: &oauth2.Config{
-			ClientID:     ext.ClientID[0],
-			ClientSecret: ext.Secret,
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  authHost + "/oauth",
-				TokenURL: authHost + "/api/oauth/token",
-			},
-			RedirectURL: ext.RedirectURI,
-			Scopes:      oauthScopes,
-		},
-		APIHost: apiHost,
+		oauth2Provider: base,
+		APIHost:        apiHost,
 	}, nil
 }
 
-func (p figmaProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
-	return p.Exchange(context.Background(), code)
-}
-
 func (p figmaProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
 	var u figmaUser
-	if err := makeRequest(ctx, tok, p.Config, p.APIHost+"/v1/me", &u); err != nil {
+	if err := p.fetchUserInfo(ctx, tok, &u); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/supabase/auth/internal/conf"
+	"golang.org/x/oauth2"
+)
+
+// genericOIDCProvider signs in with any OpenID Connect provider. The user is
+// read from the ID token, which is verified with the issuer's keys.
+type genericOIDCProvider struct {
+	*oauth2.Config
+	oidc *oidc.Provider
+}
+
+// NewGenericOIDCProvider creates an OpenID Connect provider by discovering
+// the configuration of the issuer.
+func NewGenericOIDCProvider(ctx context.Context, ext conf.OIDCProviderConfiguration, scopes string) (OAuthProvider, error) {
+	if err := ext.ValidateOAuth(); err != nil {
+		return nil, err
+	}
+
+	if ext.URL == "" {
+		return nil, errors.New("unable to find issuer URL for the OIDC provider")
+	}
+
+	oidcProvider, err := oidc.NewProvider(ctx, ext.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthScopes := append([]string{oidc.ScopeOpenID}, ext.Scopes...)
+	if scopes != "" {
+		oauthScopes = append(oauthScopes, strings.Split(scopes, ",")...)
+	}
+
+	return &genericOIDCProvider{
+		Config: &oauth2.Config{
+			ClientID:     ext.ClientID[0],
+			ClientSecret: ext.Secret,
+			Endpoint:     oidcProvider.Endpoint(),
+			RedirectURL:  ext.RedirectURI,
+			Scopes:       oauthScopes,
+		},
+		oidc: oidcProvider,
+	}, nil
+}
+
+func (p genericOIDCProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
+	return p.Exchange(context.Background(), code)
+}
+
+// GetUserData maps the standard claims of the ID token to the user. The ID
+// token must contain the email address, which the email scope asks for.
+func (p genericOIDCProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
+	idToken, ok := tok.Extra("id_token").(string)
+	if !ok || idToken == "" {
+		return nil, errors.New("provider: OIDC provider returned no ID token")
+	}
+
+	_, data, err := ParseIDToken(ctx, p.oidc, &oidc.Config{
+		ClientID: p.ClientID,
+	}, idToken, ParseIDTokenOptions{
+		AccessToken: tok.AccessToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
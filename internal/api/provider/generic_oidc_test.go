@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+)
+
+// newFakeIssuer starts an OpenID Connect issuer whose token endpoint returns
+// an ID token with the claims.
+func newFakeIssuer(t *testing.T, claims jwt.MapClaims) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                server.URL,
+			"authorization_endpoint":                server.URL + "/authorize",
+			"token_endpoint":                        server.URL + "/token",
+			"jwks_uri":                              server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		}))
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]interface{}{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		}))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "code", r.PostForm.Get("code"))
+
+		idClaims := jwt.MapClaims{
+			"iss": server.URL,
+			"aud": "client",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range claims {
+			idClaims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idClaims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		}))
+	})
+
+	return server
+}
+
+func TestGenericOIDCProvider(t *testing.T) {
+	server := newFakeIssuer(t, jwt.MapClaims{
+		"sub":                "user-id",
+		"email":              "user@example.com",
+		"email_verified":     true,
+		"name":               "Jane Doe",
+		"preferred_username": "jane",
+		"picture":            "https://example.com/jane.png",
+	})
+
+	ext := conf.OIDCProviderConfiguration{
+		OAuthProviderConfiguration: conf.OAuthProviderConfiguration{
+			Enabled:     true,
+			ClientID:    []string{"client"},
+			Secret:      "secret",
+			RedirectURI: "https://auth.example.com/callback",
+			URL:         server.URL,
+		},
+		Scopes: []string{"profile", "email"},
+	}
+
+	p, err := NewGenericOIDCProvider(context.Background(), ext, "groups")
+	require.NoError(t, err)
+
+	authURL, err := url.Parse(p.AuthCodeURL("state"))
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/authorize", authURL.Scheme+"://"+authURL.Host+authURL.Path)
+	require.Equal(t, "openid profile email groups", authURL.Query().Get("scope"))
+
+	tok, err := p.GetOAuthToken("code")
+	require.NoError(t, err)
+
+	data, err := p.GetUserData(context.Background(), tok)
+	require.NoError(t, err)
+	require.Equal(t, []Email{{Email: "user@example.com", Verified: true, Primary: true}}, data.Emails)
+	require.Equal(t, server.URL, data.Metadata.Issuer)
+	require.Equal(t, "user-id", data.Metadata.Subject)
+	require.Equal(t, "Jane Doe", data.Metadata.Name)
+	require.Equal(t, "jane", data.Metadata.PreferredUsername)
+	require.Equal(t, "https://example.com/jane.png", data.Metadata.Picture)
+}
+
+func TestGenericOIDCProviderRejectsForeignTokens(t *testing.T) {
+	server := newFakeIssuer(t, jwt.MapClaims{
+		"sub":   "user-id",
+		"email": "user@example.com",
+		"aud":   "another-client",
+	})
+
+	p, err := NewGenericOIDCProvider(context.Background(), conf.OIDCProviderConfiguration{
+		OAuthProviderConfiguration: conf.OAuthProviderConfiguration{
+			Enabled:     true,
+			ClientID:    []string{"client"},
+			Secret:      "secret",
+			RedirectURI: "https://auth.example.com/callback",
+			URL:         server.URL,
+		},
+	}, "")
+	require.NoError(t, err)
+
+	tok, err := p.GetOAuthToken("code")
+	require.NoError(t, err)
+
+	_, err = p.GetUserData(context.Background(), tok)
+	require.Error(t, err)
+}
+
+func TestGenericOIDCProviderRequiresIssuer(t *testing.T) {
+	_, err := NewGenericOIDCProvider(context.Background(), conf.OIDCProviderConfiguration{
+		OAuthProviderConfiguration: conf.OAuthProviderConfiguration{
+			Enabled:     true,
+			ClientID:    []string{"client"},
+			Secret:      "secret",
+			RedirectURI: "https://auth.example.com/callback",
+		},
+	}, "")
+	require.Error(t, err)
+}
@@ -21,7 +21,7 @@ type kakaoProvider struct {
 }
 
 type kakaoUser struct {
-	ID      int `json:"id"`
+	ID      int64 `json:"id"`
 	Account struct {
 		Profile struct {
 			Name            string `json:"nickname"`
@@ -58,15 +58,16 @@ func (p kakaoProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*Use
 
 	data.Metadata = &Claims{
 		Issuer:  p.APIHost,
-		Subject: strconv.Itoa(u.ID),
+		Subject: strconv.FormatInt(u.ID, 10),
 
 		Name:              u.Account.Profile.Name,
 		PreferredUsername: u.Account.Profile.Name,
+		Picture:           u.Account.Profile.ProfileImageURL,
 
 		// To be deprecated
 		AvatarURL:   u.Account.Profile.ProfileImageURL,
 		FullName:    u.Account.Profile.Name,
-		ProviderId:  strconv.Itoa(u.ID),
+		ProviderId:  strconv.FormatInt(u.ID, 10),
 		UserNameKey: u.Account.Profile.Name,
 	}
 	return data, nil
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/supabase/auth/internal/conf"
+)
+
+var (
+	// ErrLDAPUserNotFound is returned when no user in the directory has
+	// the email address.
+	ErrLDAPUserNotFound = errors.New("ldap: user not found")
+
+	// ErrLDAPInvalidCredentials is returned when the directory rejects
+	// the password of a user.
+	ErrLDAPInvalidCredentials = errors.New("ldap: invalid credentials")
+)
+
+// ldapConn is the part of an LDAP connection used to authenticate users.
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// LDAPUser is a user found in an LDAP directory.
+type LDAPUser struct {
+	DN     string
+	Email  string
+	Name   string
+	Groups []string
+}
+
+// LDAPDirectory checks the passwords of users against an LDAP directory.
+type LDAPDirectory struct {
+	config *conf.LDAPConfiguration
+	dial   func() (ldapConn, error)
+}
+
+// NewLDAPDirectory creates a directory connecting to the configured server.
+func NewLDAPDirectory(config *conf.LDAPConfiguration) *LDAPDirectory {
+	d := &LDAPDirectory{config: config}
+	d.dial = d.dialServer
+	return d
+}
+
+func (d *LDAPDirectory) dialServer() (ldapConn, error) {
+	conn, err := ldap.DialURL(d.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: d.config.Timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to LDAP server: %w", err)
+	}
+	conn.SetTimeout(d.config.Timeout)
+
+	if d.config.StartTLS {
+		u, err := url.Parse(d.config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error starting TLS with LDAP server: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// Authenticate looks up the user with the email address and checks their
+// password by binding as them.
+func (d *LDAPDirectory) Authenticate(email, password string) (*LDAPUser, error) {
+	// binding with an empty password is an unauthenticated bind, which
+	// servers accept for any DN
+	if password == "" {
+		return nil, ErrLDAPInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.config.BindDN != "" {
+		if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("error binding as LDAP service account: %w", err)
+		}
+	}
+
+	attributes := []string{"dn", d.config.EmailAttribute, d.config.NameAttribute, d.config.GroupAttribute}
+	req := ldap.NewSearchRequest(
+		d.config.UserBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // only needs to tell whether more than one user matches
+		int(d.config.Timeout.Seconds()),
+		false,
+		strings.Replace(d.config.UserFilter, "%s", ldap.EscapeFilter(email), 1),
+		attributes,
+		nil,
+	)
+
+	res, err := conn.Search(req)
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject):
+		return nil, ErrLDAPUserNotFound
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded):
+		return nil, fmt.Errorf("more than one LDAP user matches %q", email)
+	case err != nil:
+		return nil, fmt.Errorf("error searching LDAP users: %w", err)
+	}
+
+	switch len(res.Entries) {
+	case 0:
+		return nil, ErrLDAPUserNotFound
+	case 1:
+	default:
+		return nil, fmt.Errorf("more than one LDAP user matches %q", email)
+	}
+
+	entry := res.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPInvalidCredentials
+		}
+		return nil, fmt.Errorf("error binding as LDAP user: %w", err)
+	}
+
+	user := &LDAPUser{
+		DN:     entry.DN,
+		Email:  entry.GetAttributeValue(d.config.EmailAttribute),
+		Name:   entry.GetAttributeValue(d.config.NameAttribute),
+		Groups: entry.GetAttributeValues(d.config.GroupAttribute),
+	}
+	if user.Email == "" {
+		user.Email = email
+	}
+
+	return user, nil
+}
+
+// Role returns the role the groups of the user are mapped to by groupRoles,
+// a list of group:role pairs, or "" when none of them are. Groups are
+// matched by their common name regardless of case.
+func (u *LDAPUser) Role(groupRoles []string) string {
+	names := make(map[string]bool, len(u.Groups))
+	for _, group := range u.Groups {
+		names[strings.ToLower(ldapGroupName(group))] = true
+	}
+
+	for _, pair := range groupRoles {
+		group, role, ok := strings.Cut(pair, ":")
+		if ok && names[strings.ToLower(strings.TrimSpace(group))] {
+			return strings.TrimSpace(role)
+		}
+	}
+
+	return ""
+}
+
+// ldapGroupName returns the common name of a group, which memberOf values
+// give as a DN such as cn=admins,ou=groups,dc=example,dc=com.
+func ldapGroupName(group string) string {
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 {
+		return group
+	}
+
+	for _, attr := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+
+	return group
+}
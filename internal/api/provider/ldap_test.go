@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+)
+
+type fakeLDAPConn struct {
+	passwords map[string]string
+	entries   []*ldap.Entry
+	filters   []string
+	closed    bool
+}
+
+func (c *fakeLDAPConn) Bind(username, password string) error {
+	if p, ok := c.passwords[username]; ok && p == password {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, nil)
+}
+
+func (c *fakeLDAPConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.filters = append(c.filters, req.Filter)
+
+	res := &ldap.SearchResult{}
+	for _, entry := range c.entries {
+		if req.Filter == "(mail="+entry.GetAttributeValue("mail")+")" {
+			res.Entries = append(res.Entries, entry)
+		}
+	}
+	return res, nil
+}
+
+func (c *fakeLDAPConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestLDAPDirectoryAuthenticate(t *testing.T) {
+	config := &conf.LDAPConfiguration{
+		Enabled:        true,
+		URL:            "ldap://ldap.example.com",
+		BindDN:         "cn=auth,dc=example,dc=com",
+		BindPassword:   "service",
+		UserBaseDN:     "ou=people,dc=example,dc=com",
+		UserFilter:     "(mail=%s)",
+		EmailAttribute: "mail",
+		NameAttribute:  "displayName",
+		GroupAttribute: "memberOf",
+	}
+
+	conn := &fakeLDAPConn{
+		passwords: map[string]string{
+			"cn=auth,dc=example,dc=com":            "service",
+			"uid=jane,ou=people,dc=example,dc=com": "secret",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=jane,ou=people,dc=example,dc=com", map[string][]string{
+				"mail":        {"jane@example.com"},
+				"displayName": {"Jane Doe"},
+				"memberOf":    {"cn=admins,ou=groups,dc=example,dc=com"},
+			}),
+		},
+	}
+
+	d := NewLDAPDirectory(config)
+	d.dial = func() (ldapConn, error) {
+		return conn, nil
+	}
+
+	user, err := d.Authenticate("jane@example.com", "secret")
+	require.NoError(t, err)
+	require.Equal(t, &LDAPUser{
+		DN:     "uid=jane,ou=people,dc=example,dc=com",
+		Email:  "jane@example.com",
+		Name:   "Jane Doe",
+		Groups: []string{"cn=admins,ou=groups,dc=example,dc=com"},
+	}, user)
+	require.True(t, conn.closed)
+
+	_, err = d.Authenticate("jane@example.com", "wrong")
+	require.ErrorIs(t, err, ErrLDAPInvalidCredentials)
+
+	_, err = d.Authenticate("jane@example.com", "")
+	require.ErrorIs(t, err, ErrLDAPInvalidCredentials)
+
+	_, err = d.Authenticate("john@example.com", "secret")
+	require.ErrorIs(t, err, ErrLDAPUserNotFound)
+
+	// the email address is escaped in the filter
+	_, err = d.Authenticate("*)(uid=*", "secret")
+	require.ErrorIs(t, err, ErrLDAPUserNotFound)
+	require.Equal(t, `(mail=\2a\29\28uid=\2a)`, conn.filters[len(conn.filters)-1])
+
+	config.BindPassword = "wrong"
+	_, err = d.Authenticate("jane@example.com", "secret")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrLDAPInvalidCredentials)
+}
+
+func TestLDAPUserRole(t *testing.T) {
+	user := &LDAPUser{
+		Groups: []string{
+			"cn=Developers,ou=groups,dc=example,dc=com",
+			"cn=admins,ou=groups,dc=example,dc=com",
+		},
+	}
+
+	require.Equal(t, "admin", user.Role([]string{"admins:admin", "developers:developer"}))
+	require.Equal(t, "developer", user.Role([]string{"developers:developer", "admins:admin"}))
+	require.Equal(t, "", user.Role([]string{"support:support"}))
+	require.Equal(t, "", user.Role(nil))
+
+	// groups which aren't DNs are matched by their value
+	require.Equal(t, "support", (&LDAPUser{Groups: []string{"Support"}}).Role([]string{"support:support"}))
+}
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+	"golang.org/x/oauth2"
+)
+
+// LINE Login v2.1
+// Reference: https://developers.line.biz/en/docs/line-login/integrate-line-login/
+
+const (
+	defaultLineAuthBase = "access.line.me"
+	defaultLineAPIBase  = "api.line.me"
+)
+
+type lineProvider struct {
+	oauth2Provider
+	APIHost string
+}
+
+type lineUser struct {
+	UserID      string `json:"userId"`
+	DisplayName string `json:"displayName"`
+	PictureURL  string `json:"pictureUrl"`
+}
+
+// lineIDToken holds the claims of an ID token returned by the verify
+// endpoint.
+type lineIDToken struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email"`
+}
+
+// NewLineProvider creates a LINE account provider.
+func NewLineProvider(ext conf.OAuthProviderConfiguration, scopes string) (OAuthProvider, error) {
+	authHost := chooseHost(ext.URL, defaultLineAuthBase)
+	apiHost := chooseHost(ext.URL, defaultLineAPIBase)
+
+	// the email address is only in the ID token, and only for channels
+	// that were granted the email permission
+	base, err := newOAuth2Provider(ext, oauth2.Endpoint{
+		AuthURL:   authHost + "/oauth2/v2.1/authorize",
+		TokenURL:  apiHost + "/oauth2/v2.1/token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}, apiHost+"/v2/profile", []string{"profile", "openid", "email"}, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lineProvider{
+		oauth2Provider: base,
+		APIHost:        apiHost,
+	}, nil
+}
+
+func (p lineProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
+	var u lineUser
+	if err := p.fetchUserInfo(ctx, tok, &u); err != nil {
+		return nil, err
+	}
+
+	data := &UserProvidedData{}
+
+	// users can refuse to share their email address, and the ID token is
+	// missing without the openid scope
+	if idToken, ok := tok.Extra("id_token").(string); ok && idToken != "" {
+		claims, err := p.verifyIDToken(ctx, idToken)
+		if err != nil {
+			return nil, err
+		}
+		if claims.Subject != u.UserID {
+			return nil, errors.New("line: ID token is for another user")
+		}
+
+		if claims.Email != "" {
+			data.Emails = []Email{{
+				Email: claims.Email,
+				// LINE confirms email addresses with a code
+				// before they are registered
+				Verified: true,
+				Primary:  true,
+			}}
+		}
+	}
+
+	data.Metadata = &Claims{
+		Issuer:  p.APIHost,
+		Subject: u.UserID,
+		Name:    u.DisplayName,
+		Picture: u.PictureURL,
+
+		// To be deprecated
+		AvatarURL:  u.PictureURL,
+		FullName:   u.DisplayName,
+		ProviderId: u.UserID,
+	}
+
+	return data, nil
+}
+
+// verifyIDToken checks the ID token with LINE, which signs them with the
+// channel secret, and returns its claims.
+func (p lineProvider) verifyIDToken(ctx context.Context, idToken string) (*lineIDToken, error) {
+	form := url.Values{
+		"id_token":  {idToken},
+		"client_id": {p.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.APIHost+"/oauth2/v2.1/verify", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: defaultTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer utilities.SafeClose(res.Body)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, httpError(res.StatusCode, "%s", body)
+	}
+
+	var claims lineIDToken
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"golang.org/x/oauth2"
+)
+
+func TestLineUserData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v2/profile":
+			require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"userId":"U4af4980629","displayName":"LINE Test","pictureUrl":"https://profile.line-scdn.net/abcdefghijklmn"}`)
+		case "/oauth2/v2.1/verify":
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "client", r.PostForm.Get("client_id"))
+			switch r.PostForm.Get("id_token") {
+			case "with-email":
+				fmt.Fprint(w, `{"iss":"https://access.line.me","sub":"U4af4980629","aud":"client","email":"line@example.com"}`)
+			case "without-email":
+				fmt.Fprint(w, `{"iss":"https://access.line.me","sub":"U4af4980629","aud":"client"}`)
+			case "other-user":
+				fmt.Fprint(w, `{"iss":"https://access.line.me","sub":"U0000000000","aud":"client","email":"other@example.com"}`)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_request","error_description":"Invalid IdToken."}`)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewLineProvider(conf.OAuthProviderConfiguration{
+		Enabled:     true,
+		ClientID:    []string{"client"},
+		Secret:      "secret",
+		RedirectURI: "https://auth.example.com/callback",
+		URL:         server.URL,
+	}, "")
+	require.NoError(t, err)
+
+	token := func(idToken string) *oauth2.Token {
+		return (&oauth2.Token{AccessToken: "token"}).WithExtra(map[string]interface{}{"id_token": idToken})
+	}
+
+	data, err := p.GetUserData(context.Background(), token("with-email"))
+	require.NoError(t, err)
+	require.Equal(t, []Email{{Email: "line@example.com", Verified: true, Primary: true}}, data.Emails)
+	require.Equal(t, "U4af4980629", data.Metadata.Subject)
+	require.Equal(t, "LINE Test", data.Metadata.Name)
+	require.Equal(t, "https://profile.line-scdn.net/abcdefghijklmn", data.Metadata.Picture)
+
+	data, err = p.GetUserData(context.Background(), token("without-email"))
+	require.NoError(t, err)
+	require.Empty(t, data.Emails)
+
+	_, err = p.GetUserData(context.Background(), token("other-user"))
+	require.Error(t, err)
+
+	_, err = p.GetUserData(context.Background(), token("invalid"))
+	require.Error(t, err)
+}
@@ -37,11 +37,14 @@ type linkedinUser struct {
 }
 
 func (u *linkedinUser) getAvatarUrl() string {
-	avatarURL := ""
-	if len(u.AvatarURL.DisplayImage.Elements) > 0 {
-		avatarURL = u.AvatarURL.DisplayImage.Elements[0].Identifiers[0].Identifier
+	for _, element := range u.AvatarURL.DisplayImage.Elements {
+		for _, identifier := range element.Identifiers {
+			if identifier.Identifier != "" {
+				return identifier.Identifier
+			}
+		}
 	}
-	return avatarURL
+	return ""
 }
 
 type linkedinName struct {
@@ -101,10 +104,39 @@ func (g linkedinProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
 	return g.Exchange(context.Background(), code)
 }
 
+// GetName returns the name in the preferred locale of the member, or in any
+// locale if there's none in the preferred one.
 func GetName(name linkedinName) string {
+	localized, ok := name.Localized.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
 	key := name.PreferredLocale.Language + "_" + name.PreferredLocale.Country
-	myMap := name.Localized.(map[string]interface{})
-	return myMap[key].(string)
+	if value, ok := localized[key].(string); ok {
+		return value
+	}
+
+	// the first in order of the locales, so that the name is the same
+	// every time
+	var fallback, fallbackKey string
+	for key, value := range localized {
+		if value, ok := value.(string); ok && value != "" && (fallbackKey == "" || key < fallbackKey) {
+			fallback, fallbackKey = value, key
+		}
+	}
+	return fallback
+}
+
+// primaryEmail returns the email address of the member, if any. The response
+// lists no elements when the member has no email address.
+func (e *linkedinElements) primaryEmail() string {
+	for _, element := range e.Elements {
+		if element.HandleTilde.EmailAddress != "" {
+			return element.HandleTilde.EmailAddress
+		}
+	}
+	return ""
 }
 
 func (g linkedinProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
@@ -121,28 +153,30 @@ func (g linkedinProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*
 
 	data := &UserProvidedData{}
 
-	if e.Elements[0].HandleTilde.EmailAddress != "" {
+	email := e.primaryEmail()
+	if email != "" {
 		// linkedin only returns the primary email which is verified for the r_emailaddress scope.
 		data.Emails = []Email{{
-			Email:    e.Elements[0].HandleTilde.EmailAddress,
+			Email:    email,
 			Primary:  true,
 			Verified: true,
 		}}
 	}
 
 	avatarURL := u.getAvatarUrl()
+	name := strings.TrimSpace(GetName(u.FirstName) + " " + GetName(u.LastName))
 
 	data.Metadata = &Claims{
 		Issuer:        g.APIPath,
 		Subject:       u.ID,
-		Name:          strings.TrimSpace(GetName(u.FirstName) + " " + GetName(u.LastName)),
+		Name:          name,
 		Picture:       avatarURL,
-		Email:         e.Elements[0].HandleTilde.EmailAddress,
-		EmailVerified: true,
+		Email:         email,
+		EmailVerified: email != "",
 
 		// To be deprecated
 		AvatarURL:  avatarURL,
-		FullName:   strings.TrimSpace(GetName(u.FirstName) + " " + GetName(u.LastName)),
+		FullName:   name,
 		ProviderId: u.ID,
 	}
 	return data, nil
@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"golang.org/x/oauth2"
+)
+
+func linkedinTestUserData(t *testing.T, profile, emails string) *UserProvidedData {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v2/me":
+			fmt.Fprint(w, profile)
+		case "/v2/emailAddress":
+			fmt.Fprint(w, emails)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewLinkedinProvider(conf.OAuthProviderConfiguration{
+		Enabled:     true,
+		ClientID:    []string{"client"},
+		Secret:      "secret",
+		RedirectURI: "https://auth.example.com/callback",
+		URL:         server.URL,
+	}, "")
+	require.NoError(t, err)
+
+	data, err := p.GetUserData(context.Background(), &oauth2.Token{AccessToken: "token"})
+	require.NoError(t, err)
+	return data
+}
+
+func TestLinkedinUserData(t *testing.T) {
+	data := linkedinTestUserData(t, `{
+		"id": "linkedinTestId",
+		"firstName": {"localized": {"en_US": "Linkedin", "de_DE": "LinkedIn"}, "preferredLocale": {"country": "US", "language": "en"}},
+		"lastName": {"localized": {"de_DE": "Test"}, "preferredLocale": {"country": "US", "language": "en"}},
+		"profilePicture": {"displayImage~": {"elements": [
+			{"identifiers": []},
+			{"identifiers": [{"identifier": "https://media.licdn.com/avatar.png"}]}
+		]}}
+	}`, `{"elements": [{"handle": "urn:li:emailAddress:1", "handle~": {"emailAddress": "linkedin@example.com"}}]}`)
+
+	require.Equal(t, []Email{{Email: "linkedin@example.com", Verified: true, Primary: true}}, data.Emails)
+	require.Equal(t, "linkedinTestId", data.Metadata.Subject)
+	require.Equal(t, "Linkedin Test", data.Metadata.Name)
+	require.Equal(t, "https://media.licdn.com/avatar.png", data.Metadata.Picture)
+}
+
+func TestLinkedinUserDataSparse(t *testing.T) {
+	data := linkedinTestUserData(t, `{"id": "linkedinTestId"}`, `{"elements": []}`)
+
+	require.Empty(t, data.Emails)
+	require.Equal(t, "linkedinTestId", data.Metadata.Subject)
+	require.Empty(t, data.Metadata.Name)
+	require.Empty(t, data.Metadata.Picture)
+	require.False(t, data.Metadata.EmailVerified)
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+	"golang.org/x/oauth2"
+)
+
+// oauth2Provider implements the parts of OAuth 2.0 providers that only differ
+// in their URLs: the code exchange and the request of the user's profile.
+// Providers embed it and map the profile to UserProvidedData in GetUserData.
+type oauth2Provider struct {
+	*oauth2.Config
+
+	// UserInfoURL is the URL of the user's profile.
+	UserInfoURL string
+
+	// Header is sent with the profile request, for providers that need
+	// more than the access token, such as a client ID.
+	Header http.Header
+}
+
+// newOAuth2Provider creates the base of a provider with the configured client
+// and redirect URI. The scopes requested by the client are added to the
+// default scopes.
+func newOAuth2Provider(ext conf.OAuthProviderConfiguration, endpoint oauth2.Endpoint, userInfoURL string, defaultScopes []string, scopes string) (oauth2Provider, error) {
+	if err := ext.ValidateOAuth(); err != nil {
+		return oauth2Provider{}, err
+	}
+
+	oauthScopes := defaultScopes
+	if scopes != "" {
+		oauthScopes = append(oauthScopes, strings.Split(scopes, ",")...)
+	}
+
+	return oauth2Provider{
+		Config: &oauth2.Config{
+			ClientID:     ext.ClientID[0],
+			ClientSecret: ext.Secret,
+			Endpoint:     endpoint,
+			RedirectURL:  ext.RedirectURI,
+			Scopes:       oauthScopes,
+		},
+		UserInfoURL: userInfoURL,
+	}, nil
+}
+
+func (p oauth2Provider) GetOAuthToken(code string) (*oauth2.Token, error) {
+	return p.exchange(code)
+}
+
+// exchange exchanges the code for a token. Errors of the provider are
+// returned as an *HTTPError with the OAuth error and its description.
+func (p oauth2Provider) exchange(code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: defaultTimeout})
+
+	tok, err := p.Exchange(ctx, code, opts...)
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.Response != nil {
+			return nil, retrieveError(retrieveErr).WithInternalError(err)
+		}
+		return nil, err
+	}
+	return tok, nil
+}
+
+// fetchUserInfo requests the user's profile with the token and decodes it
+// into dst.
+func (p oauth2Provider) fetchUserInfo(ctx context.Context, tok *oauth2.Token, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.UserInfoURL, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range p.Header {
+		req.Header[key] = values
+	}
+
+	client := p.Client(ctx, tok)
+	client.Timeout = defaultTimeout
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer utilities.SafeClose(res.Body)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return httpError(res.StatusCode, "%s", body)
+	}
+
+	return json.Unmarshal(body, dst)
+}
+
+// retrieveError maps a failed code exchange to an HTTPError. Most providers
+// respond with an OAuth error, others only with a message.
+func retrieveError(err *oauth2.RetrieveError) *HTTPError {
+	if err.ErrorCode != "" {
+		if err.ErrorDescription != "" {
+			return httpError(err.Response.StatusCode, "%s: %s", err.ErrorCode, err.ErrorDescription)
+		}
+		return httpError(err.Response.StatusCode, "%s", err.ErrorCode)
+	}
+	return httpError(err.Response.StatusCode, "%s", err.Body)
+}
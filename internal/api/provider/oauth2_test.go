@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"golang.org/x/oauth2"
+)
+
+func TestOAuth2Provider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/token":
+			require.NoError(t, r.ParseForm())
+			switch r.PostForm.Get("code") {
+			case "code":
+				fmt.Fprint(w, `{"access_token":"token","token_type":"bearer"}`)
+			case "expired":
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant","error_description":"The code has expired"}`)
+			default:
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, "unavailable")
+			}
+		case "/helix/users":
+			if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Client-Id") != "client" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error":"Unauthorized","status":401}`)
+				return
+			}
+			fmt.Fprint(w, `{"data":[{"id":"1","login":"twitch","display_name":"Twitch","email":"twitch@example.com"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewTwitchProvider(conf.OAuthProviderConfiguration{
+		Enabled:     true,
+		ClientID:    []string{"client"},
+		Secret:      "secret",
+		RedirectURI: "https://auth.example.com/callback",
+		URL:         server.URL,
+	}, "channel:read:subscriptions")
+	require.NoError(t, err)
+
+	authURL, err := url.Parse(p.AuthCodeURL("state"))
+	require.NoError(t, err)
+	require.Equal(t, "user:read:email channel:read:subscriptions", authURL.Query().Get("scope"))
+
+	_, err = p.GetOAuthToken("expired")
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+	require.Equal(t, "invalid_grant: The code has expired", httpErr.Message)
+
+	_, err = p.GetOAuthToken("unavailable")
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusServiceUnavailable, httpErr.Code)
+	require.Equal(t, "unavailable", httpErr.Message)
+
+	tok, err := p.GetOAuthToken("code")
+	require.NoError(t, err)
+
+	data, err := p.GetUserData(context.Background(), tok)
+	require.NoError(t, err)
+	require.Equal(t, "1", data.Metadata.Subject)
+	require.Equal(t, []Email{{Email: "twitch@example.com", Verified: true, Primary: true}}, data.Emails)
+
+	_, err = p.GetUserData(context.Background(), &oauth2.Token{AccessToken: "revoked"})
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusUnauthorized, httpErr.Code)
+}
+
+func TestOAuth2ProviderValidates(t *testing.T) {
+	for _, newProvider := range []func(conf.OAuthProviderConfiguration, string) (OAuthProvider, error){
+		NewFigmaProvider,
+		NewSpotifyProvider,
+		NewTwitchProvider,
+		NewXProvider,
+	} {
+		_, err := newProvider(conf.OAuthProviderConfiguration{
+			ClientID: []string{"client"},
+			Secret:   "secret",
+		}, "")
+		require.Error(t, err)
+	}
+}
@@ -91,6 +91,14 @@ type OAuthProvider interface {
 	GetOAuthToken(string) (*oauth2.Token, error)
 }
 
+// PKCEOAuthProvider is an OAuth provider that requires PKCE (RFC 7636). The
+// code challenge of the verifier is sent with the authorization request, and
+// the verifier with the code.
+type PKCEOAuthProvider interface {
+	OAuthProvider
+	GetOAuthTokenWithVerifier(code, verifier string) (*oauth2.Token, error)
+}
+
 func chooseHost(base, defaultHost string) string {
 	if base == "" {
 		return "https://" + defaultHost
@@ -2,7 +2,6 @@ package provider
 
 import (
 	"context"
-	"strings"
 
 	"github.com/supabase/auth/internal/conf"
 	"golang.org/x/oauth2"
@@ -14,7 +13,7 @@ const (
 )
 
 type spotifyProvider struct {
-	*oauth2.Config
+	oauth2Provider
 	APIPath string
 }
 
@@ -33,43 +32,26 @@ type spotifyUserImage struct {
 
 // NewSpotifyProvider creates a Spotify account provider.
 func NewSpotifyProvider(ext conf.OAuthProviderConfiguration, scopes string) (OAuthProvider, error) {
-	if err := ext.ValidateOAuth(); err != nil {
-		return nil, err
-	}
-
 	apiPath := chooseHost(ext.URL, defaultSpotifyAPIBase)
 	authPath := chooseHost(ext.URL, defaultSpotifyAuthBase)
 
-	oauthScopes := []string{
-		"user-read-email",
-	}
-
-	if scopes != "" {
-		oauthScopes = append(oauthScopes, strings.Split(scopes, ",")...)
+	base, err := newOAuth2Provider(ext, oauth2.Endpoint{
+		AuthURL:  authPath + "/authorize",
+		TokenURL: authPath + "/api/token",
+	}, apiPath+"/me", []string{"user-read-email"}, scopes)
+	if err != nil {
+		return nil, err
 	}
 
 	return &spotifyProvider{
-		Config: &oauth2.Config{
-			ClientID:     ext.ClientID[0],
-			ClientSecret: ext.Secret,
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  authPath + "/authorize",
-				TokenURL: authPath + "/api/token",
-			},
-			Scopes:      oauthScopes,
-			RedirectURL: ext.RedirectURI,
-		},
-		APIPath: apiPath,
+		oauth2Provider: base,
+		APIPath:        apiPath,
 	}, nil
 }
 
-func (g spotifyProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
-	return g.Exchange(context.Background(), code)
-}
-
 func (g spotifyProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
 	var u spotifyUser
-	if err := makeRequest(ctx, tok, g.Config, g.APIPath+"/me", &u); err != nil {
+	if err := g.fetchUserInfo(ctx, tok, &u); err != nil {
 		return nil, err
 	}
 
@@ -2,16 +2,11 @@ package provider
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/supabase/auth/internal/conf"
-	"github.com/supabase/auth/internal/utilities"
 	"golang.org/x/oauth2"
 )
 
@@ -23,7 +18,7 @@ const (
 )
 
 type twitchProvider struct {
-	*oauth2.Config
+	oauth2Provider
 	APIHost string
 }
 
@@ -45,72 +40,29 @@ type twitchUsers struct {
 
 // NewTwitchProvider creates a Twitch account provider.
 func NewTwitchProvider(ext conf.OAuthProviderConfiguration, scopes string) (OAuthProvider, error) {
-	if err := ext.ValidateOAuth(); err != nil {
-		return nil, err
-	}
-
 	apiHost := chooseHost(ext.URL, defaultTwitchAPIBase)
 	authHost := chooseHost(ext.URL, defaultTwitchAuthBase)
 
-	oauthScopes := []string{
-		"user:read:email",
+	base, err := newOAuth2Provider(ext, oauth2.Endpoint{
+		AuthURL:  authHost + "/oauth2/authorize",
+		TokenURL: authHost + "/oauth2/token",
+	}, apiHost+"/helix/users", []string{"user:read:email"}, scopes)
+	if err != nil {
+		return nil, err
 	}
 
-	if scopes != "" {
-		oauthScopes = append(oauthScopes, strings.Split(scopes, ",")...)
-	}
+	// the Helix API needs the client ID too
+	base.Header = http.Header{"Client-Id": {base.ClientID}}
 
 	return &twitchProvider{
-		Config: &oauth2.Config{
-			ClientID:     ext.ClientID[0],
-			ClientSecret: ext.Secret,
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  authHost + "/oauth2/authorize",
-				TokenURL: authHost + "/oauth2/token",
-			},
-			RedirectURL: ext.RedirectURI,
-			Scopes:      oauthScopes,
-		},
-		APIHost: apiHost,
+		oauth2Provider: base,
+		APIHost:        apiHost,
 	}, nil
 }
 
-func (t twitchProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
-	return t.Exchange(context.Background(), code)
-}
-
 func (t twitchProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
 	var u twitchUsers
-
-	// Perform http request, because we neeed to set the Client-Id header
-	req, err := http.NewRequest("GET", t.APIHost+"/helix/users", nil)
-
-	if err != nil {
-		return nil, err
-	}
-
-	// set headers
-	req.Header.Set("Client-Id", t.Config.ClientID)
-	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
-
-	client := &http.Client{Timeout: defaultTimeout}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer utilities.SafeClose(resp.Body)
-
-	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
-		return nil, fmt.Errorf("a %v error occurred with retrieving user from twitch", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	err = json.Unmarshal(body, &u)
-	if err != nil {
+	if err := t.fetchUserInfo(ctx, tok, &u); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"context"
+	"strings"
+
+	"github.com/supabase/auth/internal/conf"
+	"golang.org/x/oauth2"
+)
+
+// X, formerly Twitter, with OAuth 2.0. See
+// https://developer.x.com/en/docs/authentication/oauth-2-0/authorization-code
+
+const (
+	defaultXAuthBase = "x.com"
+	defaultXAPIBase  = "api.x.com"
+)
+
+type xProvider struct {
+	oauth2Provider
+	APIHost string
+}
+
+type xUser struct {
+	Data struct {
+		ID              string `json:"id"`
+		Name            string `json:"name"`
+		UserName        string `json:"username"`
+		ProfileImageURL string `json:"profile_image_url"`
+		ConfirmedEmail  string `json:"confirmed_email"`
+	} `json:"data"`
+}
+
+// NewXProvider creates an X account provider using OAuth 2.0, which requires
+// PKCE.
+func NewXProvider(ext conf.OAuthProviderConfiguration, scopes string) (OAuthProvider, error) {
+	authHost := chooseHost(ext.URL, defaultXAuthBase)
+	apiHost := chooseHost(ext.URL, defaultXAPIBase)
+
+	// users.email is only granted to apps that ask for the users' email
+	// addresses in the developer portal
+	base, err := newOAuth2Provider(ext, oauth2.Endpoint{
+		AuthURL:   authHost + "/i/oauth2/authorize",
+		TokenURL:  apiHost + "/2/oauth2/token",
+		AuthStyle: oauth2.AuthStyleInHeader,
+	}, apiHost+"/2/users/me?user.fields=profile_image_url,confirmed_email", []string{"tweet.read", "users.read", "users.email"}, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xProvider{
+		oauth2Provider: base,
+		APIHost:        apiHost,
+	}, nil
+}
+
+func (p xProvider) GetOAuthTokenWithVerifier(code, verifier string) (*oauth2.Token, error) {
+	return p.exchange(code, oauth2.VerifierOption(verifier))
+}
+
+func (p xProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
+	var u xUser
+	if err := p.fetchUserInfo(ctx, tok, &u); err != nil {
+		return nil, err
+	}
+
+	data := &UserProvidedData{}
+
+	// the email address is missing when the app has no access to it or
+	// the account has no confirmed email address
+	if u.Data.ConfirmedEmail != "" {
+		data.Emails = []Email{{
+			Email:    u.Data.ConfirmedEmail,
+			Verified: true,
+			Primary:  true,
+		}}
+	}
+
+	// the default profile image is the small version
+	avatarURL := strings.Replace(u.Data.ProfileImageURL, "_normal.", "_400x400.", 1)
+
+	data.Metadata = &Claims{
+		Issuer:            p.APIHost,
+		Subject:           u.Data.ID,
+		Name:              u.Data.Name,
+		PreferredUsername: u.Data.UserName,
+		Picture:           avatarURL,
+		Email:             u.Data.ConfirmedEmail,
+		EmailVerified:     u.Data.ConfirmedEmail != "",
+
+		// To be deprecated
+		UserNameKey: u.Data.UserName,
+		FullName:    u.Data.Name,
+		AvatarURL:   avatarURL,
+		ProviderId:  u.Data.ID,
+	}
+
+	return data, nil
+}
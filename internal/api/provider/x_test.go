@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"golang.org/x/oauth2"
+)
+
+func TestXProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		switch r.URL.Path {
+		case "/2/oauth2/token":
+			require.Equal(t, "verifier", r.PostForm.Get("code_verifier"))
+			user, password, ok := r.BasicAuth()
+			require.True(t, ok)
+			require.Equal(t, "client", user)
+			require.Equal(t, "secret", password)
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token":"token","token_type":"bearer"}`)
+		case "/2/users/me":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"data":{"id":"1","name":"X Test","username":"xtest","profile_image_url":"https://pbs.twimg.com/profile_images/1/avatar_normal.jpg"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewXProvider(conf.OAuthProviderConfiguration{
+		Enabled:     true,
+		ClientID:    []string{"client"},
+		Secret:      "secret",
+		RedirectURI: "https://auth.example.com/callback",
+		URL:         server.URL,
+	}, "")
+	require.NoError(t, err)
+
+	authURL, err := url.Parse(p.AuthCodeURL("state", oauth2.S256ChallengeOption("verifier")))
+	require.NoError(t, err)
+	require.Equal(t, oauth2.S256ChallengeFromVerifier("verifier"), authURL.Query().Get("code_challenge"))
+
+	tok, err := p.(PKCEOAuthProvider).GetOAuthTokenWithVerifier("code", "verifier")
+	require.NoError(t, err)
+
+	data, err := p.GetUserData(context.Background(), tok)
+	require.NoError(t, err)
+
+	// accounts without a confirmed email address have none
+	require.Empty(t, data.Emails)
+	require.Equal(t, "1", data.Metadata.Subject)
+	require.Equal(t, "xtest", data.Metadata.PreferredUsername)
+	require.Equal(t, "https://pbs.twimg.com/profile_images/1/avatar_400x400.jpg", data.Metadata.Picture)
+}
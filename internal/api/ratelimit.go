@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+// withTrustedProxies lets utilities.GetClientIP find the client address
+// behind the proxies configured with API_TRUSTED_PROXIES and API_XFF_DEPTH.
+func (a *API) withTrustedProxies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := utilities.WithTrustedProxies(r.Context(), a.trustedProxies)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// limitRequestsByIP allows each client IP address limit requests to an
+// endpoint in every IP_RATE_LIMIT_WINDOW.
+func (a *API) limitRequestsByIP(endpoint string, limit int) middlewareHandler {
+	return func(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		ctx := r.Context()
+		config := a.config.IPRateLimit
+
+		if !config.Enabled || limit <= 0 {
+			return ctx, nil
+		}
+
+		key := endpoint + ":" + utilities.GetClientIP(r)
+
+		allowed, retryAfter, err := a.rateLimiter.Allow(ctx, key, limit, config.Window)
+		if err != nil {
+			// don't lock everyone out when the limiter is unavailable
+			observability.GetLogEntry(r).Entry.WithError(err).Error("unable to check the IP rate limit")
+			return ctx, nil
+		}
+
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			return ctx, tooManyRequestsError(ErrorCodeOverRequestRateLimit, "Request rate limit reached")
+		}
+
+		return ctx, nil
+	}
+}
+
+// limitAllRequestsByIP applies the global limit to every request, except
+// for the health check and the admin endpoints requested with valid admin
+// credentials.
+func (a *API) limitAllRequestsByIP() middlewareHandler {
+	limit := a.limitRequestsByIP("global", a.config.IPRateLimit.Global)
+
+	return func(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		path := r.URL.Path
+		if path == "/health" {
+			return r.Context(), nil
+		}
+		if path == "/admin" || strings.HasPrefix(path, "/admin/") {
+			if _, err := a.requireAdminCredentials(w, r); err == nil {
+				return r.Context(), nil
+			}
+		}
+
+		return limit(w, r)
+	}
+}
+
+// limitPasswordGrantsByIP limits only the password grant of /token, the
+// other grants are limited by the refresh token rate limit.
+func (a *API) limitPasswordGrantsByIP() middlewareHandler {
+	limit := a.limitRequestsByIP("token_password", a.config.IPRateLimit.TokenPassword)
+
+	return func(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		if r.URL.Query().Get("grant_type") != "password" {
+			return r.Context(), nil
+		}
+
+		return limit(w, r)
+	}
+}
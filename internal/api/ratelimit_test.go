@@ -0,0 +1,171 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+)
+
+func setupRateLimitedAPIForTest(t *testing.T, cb func(*conf.GlobalConfiguration)) *API {
+	config, err := conf.LoadGlobal(apiTestConfig)
+	require.NoError(t, err)
+
+	config.IPRateLimit.Enabled = true
+	config.IPRateLimit.Window = 500 * time.Millisecond
+	cb(config)
+	require.NoError(t, config.IPRateLimit.Validate())
+	require.NoError(t, config.API.Validate())
+
+	// the rate limited requests are answered without touching the database
+	return NewAPIWithVersion(config, nil, apiTestVersion)
+}
+
+func rateLimitTestRouter(api *API) *router {
+	ok := func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	r := newRouter()
+	r.UseBypass(api.withTrustedProxies)
+	r.Use(api.limitAllRequestsByIP())
+	r.Get("/health", ok)
+	r.Get("/settings", ok)
+	r.Get("/admin/users", ok)
+	r.With(api.limitRequestsByIP("otp", api.config.IPRateLimit.Otp)).Post("/otp", ok)
+	r.With(api.limitPasswordGrantsByIP()).Post("/token", ok)
+	return r
+}
+
+func sendFromIP(h http.Handler, method, path, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://localhost"+path, nil)
+	req.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestIPRateLimitEndpoint(t *testing.T) {
+	api := setupRateLimitedAPIForTest(t, func(c *conf.GlobalConfiguration) {
+		c.IPRateLimit.Otp = 3
+	})
+	h := rateLimitTestRouter(api)
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/otp", "192.0.2.1:1234", nil).Code)
+	}
+
+	w := sendFromIP(h, http.MethodPost, "/otp", "192.0.2.1:1234", nil)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+	require.Contains(t, w.Body.String(), string(ErrorCodeOverRequestRateLimit))
+
+	// other clients are not affected
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/otp", "192.0.2.2:1234", nil).Code)
+
+	// requests are allowed again once the window has ended
+	time.Sleep(api.config.IPRateLimit.Window)
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/otp", "192.0.2.1:1234", nil).Code)
+}
+
+func TestIPRateLimitGlobal(t *testing.T) {
+	api := setupRateLimitedAPIForTest(t, func(c *conf.GlobalConfiguration) {
+		c.IPRateLimit.Global = 2
+	})
+	h := rateLimitTestRouter(api)
+
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodGet, "/settings", "192.0.2.1:1234", nil).Code)
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/otp", "192.0.2.1:1234", nil).Code)
+	require.Equal(t, http.StatusTooManyRequests, sendFromIP(h, http.MethodGet, "/settings", "192.0.2.1:1234", nil).Code)
+
+	// the health check and admin endpoints with admin credentials are exempt
+	api.jwtKeyCache.set(&jwtKeySet{config: &api.config.JWT})
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{Role: "supabase_admin"}).SignedString([]byte(api.config.JWT.Secret))
+	require.NoError(t, err)
+	admin := map[string]string{"Authorization": "Bearer " + token}
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodGet, "/health", "192.0.2.1:1234", nil).Code)
+		require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodGet, "/admin/users", "192.0.2.1:1234", admin).Code)
+	}
+
+	// but not without them
+	require.Equal(t, http.StatusTooManyRequests, sendFromIP(h, http.MethodGet, "/admin/users", "192.0.2.1:1234", nil).Code)
+	user, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{Role: "authenticated"}).SignedString([]byte(api.config.JWT.Secret))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, sendFromIP(h, http.MethodGet, "/admin/users", "192.0.2.1:1234", map[string]string{"Authorization": "Bearer " + user}).Code)
+}
+
+func TestIPRateLimitPasswordGrant(t *testing.T) {
+	api := setupRateLimitedAPIForTest(t, func(c *conf.GlobalConfiguration) {
+		c.IPRateLimit.TokenPassword = 1
+	})
+	h := rateLimitTestRouter(api)
+
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/token?grant_type=password", "192.0.2.1:1234", nil).Code)
+	require.Equal(t, http.StatusTooManyRequests, sendFromIP(h, http.MethodPost, "/token?grant_type=password", "192.0.2.1:1234", nil).Code)
+
+	// other grants are not limited
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/token?grant_type=refresh_token", "192.0.2.1:1234", nil).Code)
+}
+
+func TestIPRateLimitDisabled(t *testing.T) {
+	api := setupRateLimitedAPIForTest(t, func(c *conf.GlobalConfiguration) {
+		c.IPRateLimit.Enabled = false
+		c.IPRateLimit.Otp = 1
+	})
+	h := rateLimitTestRouter(api)
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/otp", "192.0.2.1:1234", nil).Code)
+	}
+}
+
+func TestIPRateLimitWiring(t *testing.T) {
+	api := setupRateLimitedAPIForTest(t, func(c *conf.GlobalConfiguration) {
+		c.IPRateLimit.Global = 1
+	})
+
+	require.Equal(t, http.StatusOK, sendFromIP(api.handler, http.MethodGet, "/settings", "192.0.2.1:1234", nil).Code)
+
+	w := sendFromIP(api.handler, http.MethodGet, "/settings", "192.0.2.1:1234", nil)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestIPRateLimitTrustedProxy(t *testing.T) {
+	api := setupRateLimitedAPIForTest(t, func(c *conf.GlobalConfiguration) {
+		c.API.TrustedProxies = []string{"10.0.0.0/8"}
+		c.IPRateLimit.Otp = 1
+	})
+	h := rateLimitTestRouter(api)
+
+	proxy := "10.0.0.1:1234"
+
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/otp", proxy, map[string]string{"X-Forwarded-For": "203.0.113.1"}).Code)
+	require.Equal(t, http.StatusTooManyRequests, sendFromIP(h, http.MethodPost, "/otp", proxy, map[string]string{"X-Forwarded-For": "203.0.113.1"}).Code)
+
+	// clients behind the same proxy are limited separately
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/otp", proxy, map[string]string{"X-Forwarded-For": "203.0.113.2"}).Code)
+}
+
+func TestIPRateLimitSpoofedForwardedFor(t *testing.T) {
+	api := setupRateLimitedAPIForTest(t, func(c *conf.GlobalConfiguration) {
+		c.API.TrustedProxies = []string{"10.0.0.0/8"}
+		c.IPRateLimit.Otp = 1
+	})
+	h := rateLimitTestRouter(api)
+
+	require.Equal(t, http.StatusOK, sendFromIP(h, http.MethodPost, "/otp", "198.51.100.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.1"}).Code)
+
+	// an untrusted client can't escape the limit by changing the header
+	require.Equal(t, http.StatusTooManyRequests, sendFromIP(h, http.MethodPost, "/otp", "198.51.100.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.2"}).Code)
+}
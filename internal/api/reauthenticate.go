@@ -3,10 +3,12 @@ package api
 import (
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/supabase/auth/internal/api/sms_provider"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/mailer"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/storage"
 )
@@ -66,6 +68,9 @@ func (a *API) Reauthenticate(w http.ResponseWriter, r *http.Request) error {
 
 			return tooManyRequestsError(reason, "For security purposes, you can only request this once every 60 seconds")
 		}
+		if errors.Is(err, mailer.ErrEmailUndeliverable) {
+			return mailerError("Error sending reauthentication email", err)
+		}
 		return err
 	}
 
@@ -80,15 +85,20 @@ func (a *API) Reauthenticate(w http.ResponseWriter, r *http.Request) error {
 
 // verifyReauthentication checks if the nonce provided is valid
 func (a *API) verifyReauthentication(nonce string, tx *storage.Connection, config *conf.GlobalConfiguration, user *models.User) error {
-	if user.ReauthenticationToken == "" || user.ReauthenticationSentAt == nil {
+	// codes sent through Twilio Verify are never known, so users who are
+	// sent one have no token
+	hasToken := user.ReauthenticationToken != "" || (user.GetEmail() == "" && config.Sms.IsTwilioVerifyProvider())
+	if !hasToken || user.ReauthenticationSentAt == nil {
 		return unprocessableEntityError(ErrorCodeReauthenticationNotValid, InvalidNonceMessage)
 	}
 	var isValid bool
+	// alphanumeric OTPs are upper case, but are accepted in any case
+	nonce = strings.ToUpper(nonce)
 	if user.GetEmail() != "" {
 		tokenHash := crypto.GenerateTokenHash(user.GetEmail(), nonce)
-		isValid = isOtpValid(tokenHash, user.ReauthenticationToken, user.ReauthenticationSentAt, config.Mailer.OtpExp)
+		isValid = isOtpValid(tokenHash, user.ReauthenticationToken, user.ReauthenticationSentAt, config.Mailer.OtpExp, a.Now())
 	} else if user.GetPhone() != "" {
-		if config.Sms.IsTwilioVerifyProvider() {
+		if config.Sms.IsTwilioVerifyProvider() && user.ReauthenticationToken == "" {
 			smsProvider, _ := sms_provider.GetSmsProvider(*config)
 			if err := smsProvider.(*sms_provider.TwilioVerifyProvider).VerifyOTP(string(user.Phone), nonce); err != nil {
 				return forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalError(err)
@@ -96,7 +106,7 @@ func (a *API) verifyReauthentication(nonce string, tx *storage.Connection, confi
 			return nil
 		} else {
 			tokenHash := crypto.GenerateTokenHash(user.GetPhone(), nonce)
-			isValid = isOtpValid(tokenHash, user.ReauthenticationToken, user.ReauthenticationSentAt, config.Sms.OtpExp)
+			isValid = isOtpValid(tokenHash, user.ReauthenticationToken, user.ReauthenticationSentAt, config.Sms.OtpExp, a.Now())
 		}
 	} else {
 		return unprocessableEntityError(ErrorCodeReauthenticationNotValid, "Reauthentication requires an email or a phone number")
@@ -104,8 +114,10 @@ func (a *API) verifyReauthentication(nonce string, tx *storage.Connection, confi
 	if !isValid {
 		return unprocessableEntityError(ErrorCodeReauthenticationNotValid, InvalidNonceMessage)
 	}
-	if err := user.ConfirmReauthentication(tx); err != nil {
+	if confirmed, err := user.ConfirmReauthentication(tx); err != nil {
 		return internalServerError("Error during reauthentication").WithInternalError(err)
+	} else if !confirmed {
+		return unprocessableEntityError(ErrorCodeReauthenticationNotValid, InvalidNonceMessage)
 	}
 	return nil
 }
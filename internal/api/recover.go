@@ -64,13 +64,13 @@ func (a *API) Recover(w http.ResponseWriter, r *http.Request) error {
 		if terr := models.NewAuditLogEntry(r, tx, user, models.UserRecoveryRequestedAction, "", nil); terr != nil {
 			return terr
 		}
-		return a.sendPasswordRecovery(r, tx, user, flowType)
+		return a.handleEmailSendLimit(r, "recover", a.sendPasswordRecovery(r, tx, user, flowType))
 	})
 	if err != nil {
 		if errors.Is(err, MaxFrequencyLimitError) {
 			return tooManyRequestsError(ErrorCodeOverEmailSendRateLimit, "For security purposes, you can only request this once every 60 seconds")
 		}
-		return internalServerError("Unable to process request").WithInternalError(err)
+		return mailerError("Unable to process request", err)
 	}
 
 	return sendJSON(w, http.StatusOK, map[string]string{})
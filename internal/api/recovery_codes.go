@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/supabase/auth/internal/metering"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+type GenerateRecoveryCodesResponse struct {
+	Codes []string `json:"codes"`
+}
+
+type VerifyRecoveryCodeParams struct {
+	Code string `json:"code"`
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of the user with a new
+// set. The codes are only ever returned by this endpoint.
+func (a *API) GenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	db := a.db.WithContext(ctx)
+
+	hasVerifiedFactor := false
+	for _, factor := range user.Factors {
+		if factor.IsVerified() {
+			hasVerifiedFactor = true
+			break
+		}
+	}
+	if !hasVerifiedFactor {
+		return unprocessableEntityError(ErrorCodeMFAFactorNotFound, "A verified factor is required to generate recovery codes")
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		if codes, terr = models.GenerateRecoveryCodes(tx, user.ID); terr != nil {
+			return internalServerError("Database error generating recovery codes").WithInternalError(terr)
+		}
+		return models.NewAuditLogEntry(r, tx, user, models.GenerateRecoveryCodesAction, "", nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, &GenerateRecoveryCodesResponse{
+		Codes: codes,
+	})
+}
+
+// VerifyRecoveryCode consumes a recovery code and upgrades the session to
+// AAL2.
+func (a *API) VerifyRecoveryCode(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	config := a.config
+	db := a.db.WithContext(ctx)
+
+	params := &VerifyRecoveryCodeParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+	if params.Code == "" {
+		return badRequestError(ErrorCodeValidationFailed, "A recovery code is required")
+	}
+
+	var token *AccessTokenResponse
+	err := db.Transaction(func(tx *storage.Connection) error {
+		consumed, terr := models.ConsumeRecoveryCode(tx, user.ID, params.Code)
+		if terr != nil {
+			return internalServerError("Database error verifying recovery code").WithInternalError(terr)
+		} else if !consumed {
+			return unprocessableEntityError(ErrorCodeMFAVerificationFailed, "Invalid recovery code")
+		}
+		if terr = models.NewAuditLogEntry(r, tx, user, models.RecoveryCodeUsedAction, "", nil); terr != nil {
+			return terr
+		}
+		user, terr = models.FindUserByID(tx, user.ID)
+		if terr != nil {
+			return terr
+		}
+		token, terr = a.updateMFASessionAndClaims(r, tx, user, models.RecoveryCodeSignIn, models.GrantParams{})
+		if terr != nil {
+			return terr
+		}
+		if terr = a.setCookieTokens(config, token, false, w); terr != nil {
+			return internalServerError("Failed to set JWT cookie. %s", terr)
+		}
+		if terr = models.InvalidateSessionsWithAALLessThan(tx, user.ID, models.AAL2.String()); terr != nil {
+			return internalServerError("Failed to update sessions. %s", terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	metering.RecordLogin(string(models.MFACodeLoginAction), user.ID)
+
+	return sendJSON(w, http.StatusOK, token)
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"errors"
 	"net/http"
-	"time"
 
 	"github.com/supabase/auth/internal/api/sms_provider"
 	"github.com/supabase/auth/internal/conf"
@@ -95,12 +94,12 @@ func (a *API) Resend(w http.ResponseWriter, r *http.Request) error {
 	case mail.SignupVerification:
 		if user.IsConfirmed() {
 			// if the user's email is confirmed already, we don't need to send a confirmation email again
-			return sendJSON(w, http.StatusOK, map[string]string{})
+			return unprocessableEntityError(ErrorCodeEmailAlreadyConfirmed, "Email address is already confirmed")
 		}
 	case smsVerification:
 		if user.IsPhoneConfirmed() {
 			// if the user's phone is confirmed already, we don't need to send a confirmation sms again
-			return sendJSON(w, http.StatusOK, map[string]string{})
+			return unprocessableEntityError(ErrorCodePhoneAlreadyConfirmed, "Phone number is already confirmed")
 		}
 	case mail.EmailChangeVerification:
 		// do not resend if user doesn't have a new email address
@@ -122,7 +121,7 @@ func (a *API) Resend(w http.ResponseWriter, r *http.Request) error {
 				return terr
 			}
 			// PKCE not implemented yet
-			return a.sendConfirmation(r, tx, user, models.ImplicitFlow)
+			return a.handleEmailSendLimit(r, "resend", a.sendConfirmation(r, tx, user, models.ImplicitFlow))
 		case smsVerification:
 			if terr := models.NewAuditLogEntry(r, tx, user, models.UserRecoveryRequestedAction, "", nil); terr != nil {
 				return terr
@@ -137,7 +136,7 @@ func (a *API) Resend(w http.ResponseWriter, r *http.Request) error {
 			}
 			messageID = mID
 		case mail.EmailChangeVerification:
-			return a.sendEmailChange(r, tx, user, user.EmailChange, models.ImplicitFlow)
+			return a.handleEmailSendLimit(r, "resend", a.sendEmailChange(r, tx, user, user.EmailChange, models.ImplicitFlow))
 		case phoneChangeVerification:
 			smsProvider, terr := sms_provider.GetSmsProvider(*config)
 			if terr != nil {
@@ -154,14 +153,19 @@ func (a *API) Resend(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		if errors.Is(err, MaxFrequencyLimitError) {
 			reason := ErrorCodeOverEmailSendRateLimit
-			if params.Type == smsVerification || params.Type == phoneChangeVerification {
-				reason = ErrorCodeOverSMSSendRateLimit
+			sentAt, maxFrequency := user.ConfirmationSentAt, config.SMTP.MaxFrequency
+			switch params.Type {
+			case smsVerification:
+				reason, maxFrequency = ErrorCodeOverSMSSendRateLimit, config.Sms.MaxFrequency
+			case mail.EmailChangeVerification:
+				sentAt = user.EmailChangeSentAt
+			case phoneChangeVerification:
+				reason, sentAt, maxFrequency = ErrorCodeOverSMSSendRateLimit, user.PhoneChangeSentAt, config.Sms.MaxFrequency
 			}
 
-			until := time.Until(user.ConfirmationSentAt.Add(config.SMTP.MaxFrequency)) / time.Second
-			return tooManyRequestsError(reason, "For security purposes, you can only request this once every %d seconds.", until)
+			return tooManyRequestsError(reason, generateFrequencyLimitErrorMessage(sentAt, maxFrequency))
 		}
-		return internalServerError("Unable to process request").WithInternalError(err)
+		return mailerError("Unable to process request", err)
 	}
 
 	ret := map[string]any{}
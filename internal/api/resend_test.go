@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
 	mail "github.com/supabase/auth/internal/mailer"
 	"github.com/supabase/auth/internal/models"
 )
@@ -215,3 +216,112 @@ func (ts *ResendTestSuite) TestResendSuccess() {
 		})
 	}
 }
+
+func (ts *ResendTestSuite) resend(params map[string]interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(params))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/resend", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *ResendTestSuite) TestResendUnknownUser() {
+	w := ts.resend(map[string]interface{}{
+		"type":  "signup",
+		"email": "nobody@example.com",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+}
+
+func (ts *ResendTestSuite) TestResendAlreadyConfirmed() {
+	ts.Config.External.Phone.Enabled = true
+
+	now := time.Now()
+	u, err := models.NewUser("123456789", "foo@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	u.EmailConfirmedAt = &now
+	u.PhoneConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Create(u))
+
+	cases := []struct {
+		desc      string
+		params    map[string]interface{}
+		errorCode ErrorCode
+	}{
+		{
+			desc: "Confirmed email",
+			params: map[string]interface{}{
+				"type":  "signup",
+				"email": u.GetEmail(),
+			},
+			errorCode: ErrorCodeEmailAlreadyConfirmed,
+		},
+		{
+			desc: "Confirmed phone",
+			params: map[string]interface{}{
+				"type":  "sms",
+				"phone": u.GetPhone(),
+			},
+			errorCode: ErrorCodePhoneAlreadyConfirmed,
+		},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.desc, func() {
+			w := ts.resend(c.params)
+			require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+
+			data := &HTTPError{}
+			require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+			require.Equal(ts.T(), c.errorCode, data.ErrorCode)
+		})
+	}
+}
+
+func (ts *ResendTestSuite) TestResendInvalidatesPreviousLink() {
+	ts.Config.SMTP.MaxFrequency = time.Minute
+
+	sentAt := time.Now().Add(-2 * time.Minute)
+	u, err := models.NewUser("", "foo@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	u.ConfirmationToken = crypto.GenerateTokenHash(u.GetEmail(), "123456")
+	u.ConfirmationSentAt = &sentAt
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	require.NoError(ts.T(), models.CreateOneTimeToken(ts.API.db, u.ID, u.GetEmail(), u.ConfirmationToken, models.ConfirmationToken))
+
+	w := ts.resend(map[string]interface{}{
+		"type":  "signup",
+		"email": u.GetEmail(),
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	dbUser, err := models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.NotEqual(ts.T(), u.ConfirmationToken, dbUser.ConfirmationToken)
+
+	// the link from the first email no longer works
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":       "signup",
+		"token_hash": u.ConfirmationToken,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	// resending again right away is rate limited
+	w = ts.resend(map[string]interface{}{
+		"type":  "signup",
+		"email": u.GetEmail(),
+	})
+	require.Equal(ts.T(), http.StatusTooManyRequests, w.Code)
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeOverEmailSendRateLimit, data.ErrorCode)
+}
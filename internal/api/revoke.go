@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// RevokeParams are the parameters of a token revocation request, sent as a
+// form or as JSON. Scope is the scope of /logout, but defaults to the
+// session of the token.
+type RevokeParams struct {
+	Token         string `json:"token"`
+	TokenTypeHint string `json:"token_type_hint"`
+	Scope         string `json:"scope"`
+}
+
+// TokenRevoke revokes a refresh or access token, so that clients can log out
+// with the token alone. See: https://datatracker.ietf.org/doc/html/rfc7009
+//
+// Revoking a refresh token logs out its session, which also ends the access
+// tokens of the session. Access tokens can only be revoked when the denylist
+// is enabled. Tokens which aren't valid are ignored, as the client can't do
+// anything about them.
+func (a *API) TokenRevoke(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	params := &RevokeParams{}
+	if isFormRequest(r) {
+		params.Token = r.PostFormValue("token")
+		params.TokenTypeHint = r.PostFormValue("token_type_hint")
+		params.Scope = r.PostFormValue("scope")
+	} else if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.Token == "" {
+		return badRequestError(ErrorCodeValidationFailed, "token is required")
+	}
+
+	var scope LogoutBehavior
+	switch params.Scope {
+	case "", "local":
+		scope = LogoutLocal
+	case "global":
+		scope = LogoutGlobal
+	case "others":
+		scope = LogoutOthers
+	default:
+		return badRequestError(ErrorCodeValidationFailed, fmt.Sprintf("Unsupported logout scope %q", params.Scope))
+	}
+
+	var (
+		revoked bool
+		err     error
+	)
+	switch params.TokenTypeHint {
+	case "refresh_token":
+		revoked, err = a.revokeRefreshToken(r, db, params.Token, scope)
+		if err == nil && !revoked {
+			_, err = a.revokeAccessToken(r, db, params.Token, scope)
+		}
+	default:
+		revoked, err = a.revokeAccessToken(r, db, params.Token, scope)
+		if err == nil && !revoked {
+			_, err = a.revokeRefreshToken(r, db, params.Token, scope)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// revokeRefreshToken logs out the sessions of the scope of a refresh token.
+// It returns false when the token is not a valid refresh token.
+func (a *API) revokeRefreshToken(r *http.Request, db *storage.Connection, token string, scope LogoutBehavior) (bool, error) {
+	user, refreshToken, session, err := models.FindUserWithRefreshToken(db, token, false)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return false, nil
+		}
+		return false, internalServerError("Database error finding refresh token").WithInternalError(err)
+	}
+
+	if refreshToken.Revoked || session == nil {
+		return false, nil
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, user, models.TokenRevokedAction, "", map[string]interface{}{
+			"token_type": "refresh_token",
+			"scope":      scope,
+		}); terr != nil {
+			return terr
+		}
+
+		return logoutScope(tx, user.ID, session.ID, scope)
+	})
+	if err != nil {
+		return false, internalServerError("Error revoking refresh token").WithInternalError(err)
+	}
+
+	return true, nil
+}
+
+// revokeAccessToken denies an access token until it expires, and logs out
+// the other sessions of the scope. It returns false when the token is not a
+// valid access token.
+func (a *API) revokeAccessToken(r *http.Request, db *storage.Connection, token string, scope LogoutBehavior) (bool, error) {
+	parsed, err := a.parseAccessToken(r.Context(), token)
+	if err != nil {
+		return false, nil
+	}
+	claims := parsed.Claims.(*AccessTokenClaims)
+
+	sessionID, err := uuid.FromString(claims.SessionId)
+	if err != nil || claims.ExpiresAt == 0 {
+		// such as the anon and service role keys
+		return false, oauthError("unsupported_token_type", ErrorCodeValidationFailed, "Only access tokens of a session can be revoked")
+	}
+
+	if !a.config.JWT.DenylistEnabled {
+		return false, oauthError("unsupported_token_type", ErrorCodeValidationFailed, "Access tokens can't be revoked, revoke the refresh token instead")
+	}
+
+	userID, err := uuid.FromString(claims.Subject)
+	if err != nil {
+		return false, nil
+	}
+
+	user, err := models.FindUserByID(db, userID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return false, nil
+		}
+		return false, internalServerError("Database error finding user").WithInternalError(err)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := models.RevokeAccessToken(tx, token, &user.ID, time.Unix(claims.ExpiresAt, 0)); terr != nil {
+			return terr
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, user, models.TokenRevokedAction, "", map[string]interface{}{
+			"token_type": "access_token",
+			"scope":      scope,
+		}); terr != nil {
+			return terr
+		}
+
+		// the session itself stays logged in, only its token is denied
+		if scope == LogoutLocal {
+			return nil
+		}
+
+		return logoutScope(tx, user.ID, sessionID, scope)
+	})
+	if err != nil {
+		return false, internalServerError("Error revoking access token").WithInternalError(err)
+	}
+
+	return true, nil
+}
+
+// logoutScope logs out the sessions of the user in the scope, relative to
+// the session.
+func logoutScope(tx *storage.Connection, userID, sessionID uuid.UUID, scope LogoutBehavior) error {
+	//exhaustive:ignore Default case is handled below.
+	switch scope {
+	case LogoutLocal:
+		return models.LogoutSession(tx, sessionID)
+
+	case LogoutOthers:
+		return models.LogoutAllExceptMe(tx, sessionID, userID)
+	}
+
+	return models.Logout(tx, userID)
+}
+
+// requireUnrevokedToken rejects access tokens which were revoked, when the
+// denylist is enabled.
+func (a *API) requireUnrevokedToken(ctx context.Context, token string) error {
+	if !a.config.JWT.DenylistEnabled {
+		return nil
+	}
+
+	revoked, err := models.IsAccessTokenRevoked(a.db.WithContext(ctx), token)
+	if err != nil {
+		return internalServerError("Database error finding revoked access token").WithInternalError(err)
+	}
+	if revoked {
+		return forbiddenError(ErrorCodeTokenRevoked, "Access token was revoked")
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+type RevokeTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	user         *models.User
+	session      *models.Session
+	accessToken  string
+	refreshToken string
+}
+
+func TestRevoke(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &RevokeTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *RevokeTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+	ts.Config.JWT.DenylistEnabled = false
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	ts.user = u
+
+	refreshToken, err := models.GrantAuthenticatedUser(ts.API.db, u, models.GrantParams{})
+	require.NoError(ts.T(), err)
+	ts.refreshToken = refreshToken.Token
+
+	ts.session, err = models.FindSessionByID(ts.API.db, *refreshToken.SessionId, false)
+	require.NoError(ts.T(), err)
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", nil)
+	ts.accessToken, _, err = ts.API.generateAccessToken(req, ts.API.db, u, &ts.session.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+}
+
+func (ts *RevokeTestSuite) revoke(form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/token/revoke", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *RevokeTestSuite) getUser() int {
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Authorization", "Bearer "+ts.accessToken)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w.Code
+}
+
+func (ts *RevokeTestSuite) TestRevokeRefreshToken() {
+	other, err := models.GrantAuthenticatedUser(ts.API.db, ts.user, models.GrantParams{})
+	require.NoError(ts.T(), err)
+
+	w := ts.revoke(url.Values{"token": {ts.refreshToken}, "token_type_hint": {"refresh_token"}})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// the session is logged out, ending its access tokens too
+	_, err = models.FindSessionByID(ts.API.db, ts.session.ID, false)
+	require.True(ts.T(), models.IsNotFoundError(err))
+	require.Equal(ts.T(), http.StatusForbidden, ts.getUser())
+
+	// the other session is still logged in
+	_, err = models.FindSessionByID(ts.API.db, *other.SessionId, false)
+	require.NoError(ts.T(), err)
+
+	// revoking it again is not an error
+	w = ts.revoke(url.Values{"token": {ts.refreshToken}})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+}
+
+func (ts *RevokeTestSuite) TestRevokeRefreshTokenGlobal() {
+	other, err := models.GrantAuthenticatedUser(ts.API.db, ts.user, models.GrantParams{})
+	require.NoError(ts.T(), err)
+
+	w := ts.revoke(url.Values{"token": {ts.refreshToken}, "scope": {"global"}})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	_, err = models.FindSessionByID(ts.API.db, *other.SessionId, false)
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *RevokeTestSuite) TestRevokeAccessToken() {
+	w := ts.revoke(url.Values{"token": {ts.accessToken}})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+	require.Contains(ts.T(), w.Body.String(), "unsupported_token_type")
+
+	ts.Config.JWT.DenylistEnabled = true
+	require.Equal(ts.T(), http.StatusOK, ts.getUser())
+
+	w = ts.revoke(url.Values{"token": {ts.accessToken}, "token_type_hint": {"access_token"}})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.Equal(ts.T(), http.StatusForbidden, ts.getUser())
+
+	// the session can still refresh
+	_, err := models.FindSessionByID(ts.API.db, ts.session.ID, false)
+	require.NoError(ts.T(), err)
+}
+
+func (ts *RevokeTestSuite) TestRevokeInvalidToken() {
+	w := ts.revoke(url.Values{"token": {"not-a-token"}})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	w = ts.revoke(url.Values{})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+}
@@ -48,7 +48,7 @@ func (a *API) SAMLACS(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
 	db := a.db.WithContext(ctx)
-	config := a.config
+	config := a.getConfig(ctx)
 	log := observability.GetLogEntry(r).Entry
 
 	relayStateValue := r.FormValue("RelayState")
@@ -186,6 +186,10 @@ func (a *API) SAMLACS(w http.ResponseWriter, r *http.Request) error {
 		spAssertion,
 	}
 
+	if spAssertion.ID == "" {
+		return badRequestError(ErrorCodeValidationFailed, "SAML Assertion does not have an ID")
+	}
+
 	userID := assertion.UserID()
 	if userID == "" {
 		return badRequestError(ErrorCodeSAMLAssertionNoUserID, "SAML Assertion did not contain a persistent Subject Identifier attribute or Subject NameID uniquely identifying this user")
@@ -271,6 +275,15 @@ func (a *API) SAMLACS(w http.ResponseWriter, r *http.Request) error {
 		var terr error
 		var user *models.User
 
+		// assertions older than MaxIssueDelay are rejected when parsed, so
+		// they only need to be remembered until then
+		if terr := models.ConsumeSAMLAssertion(tx, ssoProvider.ID, spAssertion.ID, spAssertion.IssueInstant.Add(saml.MaxIssueDelay)); terr != nil {
+			if _, ok := terr.(models.SAMLAssertionReplayedError); ok {
+				return badRequestError(ErrorCodeSAMLAssertionReplayed, "SAML Assertion has already been used")
+			}
+			return internalServerError("Unable to record SAML Assertion").WithInternalError(terr)
+		}
+
 		// accounts potentially created via SAML can contain non-unique email addresses in the auth.users table
 		if user, terr = a.createAccountFromExternalIdentity(tx, r, &userProvidedData, "sso:"+ssoProvider.ID.String()); terr != nil {
 			return terr
@@ -0,0 +1,1597 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/sethvargo/go-password/password"
+	"github.com/supabase/auth/internal/api/provider"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// SCIM 2.0 provisioning, see RFC 7643 for the resources and RFC 7644 for the
+// protocol.
+
+const (
+	scimUserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// scimMaxResults is the largest number of users or groups listed at once.
+const scimMaxResults = 100
+
+// scimBanDuration is how long deactivated users are banned for, that is
+// until they are activated again.
+const scimBanDuration = 100 * 365 * 24 * time.Hour
+
+// scimFilterRegexp matches the filters of the form `attribute eq "value"`,
+// the only ones identity providers use to look up users and groups.
+var scimFilterRegexp = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// scimPathRegexp matches the paths of PATCH operations: an attribute, with an
+// optional filter on its values and an optional sub-attribute.
+var scimPathRegexp = regexp.MustCompile(`^([A-Za-z][\w]*)(?:\[([^\]]*)\])?(?:\.([A-Za-z][\w]*))?$`)
+
+// SCIMError is an error of the SCIM endpoints, which is sent in the format
+// of RFC 7644 section 3.12. ScimType details bad requests and conflicts.
+type SCIMError struct {
+	*HTTPError
+	ScimType string
+}
+
+func scimError(err *HTTPError, scimType string) *SCIMError {
+	return &SCIMError{HTTPError: err, ScimType: scimType}
+}
+
+type scimErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func (e *SCIMError) response() *scimErrorResponse {
+	return &scimErrorResponse{
+		Schemas:  []string{scimErrorSchema},
+		Status:   strconv.Itoa(e.HTTPStatus),
+		ScimType: e.ScimType,
+		Detail:   e.Message,
+	}
+}
+
+// scimHandler sends the errors of fn as SCIM errors.
+func scimHandler(fn apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		err := fn(w, r)
+		if err == nil {
+			return nil
+		}
+
+		var scimErr *SCIMError
+		if errors.As(err, &scimErr) {
+			return scimErr
+		}
+
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			return scimError(httpErr, "")
+		}
+
+		return scimError(internalServerError("Unexpected failure").WithInternalError(err), "")
+	}
+}
+
+func sendSCIM(w http.ResponseWriter, status int, obj interface{}) error {
+	w.Header().Set("Content-Type", "application/scim+json")
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Error encoding json response: %v", obj))
+	}
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
+
+func readSCIMBody(r *http.Request, dst interface{}) error {
+	body, err := getBodyBytes(r)
+	if err != nil {
+		return internalServerError("Could not read body").WithInternalError(err)
+	}
+
+	if err := json.Unmarshal(body, dst); err != nil {
+		return scimError(badRequestError(ErrorCodeBadJSON, "Could not parse request body as JSON: %v", err), "invalidSyntax")
+	}
+
+	return nil
+}
+
+func (a *API) requireSCIMEnabled(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	if !a.getConfig(r.Context()).SCIM.Enabled {
+		return nil, scimError(notFoundError(ErrorCodeSCIMDisabled, "SCIM is disabled"), "")
+	}
+	return r.Context(), nil
+}
+
+// requireSCIMCredentials checks the bearer token the identity provider
+// authenticates with.
+func (a *API) requireSCIMCredentials(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	config := a.getConfig(r.Context())
+	matches := bearerRegexp.FindStringSubmatch(r.Header.Get("Authorization"))
+	if len(matches) != 2 || subtle.ConstantTimeCompare([]byte(matches[1]), []byte(config.SCIM.Token)) != 1 {
+		return nil, scimError(httpError(http.StatusUnauthorized, ErrorCodeNoAuthorization, "Invalid SCIM bearer token"), "")
+	}
+	return r.Context(), nil
+}
+
+// scimActor is the actor of the audit log entries of SCIM requests.
+func scimActor() *models.User {
+	return &models.User{Email: storage.NullString("scim")}
+}
+
+// scimBool is a boolean that can also be sent as a string, as Microsoft
+// Entra ID does in PATCH requests.
+type scimBool bool
+
+func (b *scimBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case bool:
+		*b = scimBool(v)
+	case string:
+		parsed, err := strconv.ParseBool(strings.ToLower(v))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*b = scimBool(parsed)
+	case nil:
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %v", v)
+	}
+	return nil
+}
+
+// SCIMName is the name of a SCIM user.
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMMultiValue is a value of a multi-valued attribute, such as the email
+// addresses of a user or the members of a group.
+type SCIMMultiValue struct {
+	Value   string   `json:"value"`
+	Display string   `json:"display,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	Primary scimBool `json:"primary,omitempty"`
+}
+
+// SCIMMeta holds the metadata of a SCIM resource.
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// SCIMUser is a user as represented by SCIM.
+type SCIMUser struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *SCIMName        `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []SCIMMultiValue `json:"emails,omitempty"`
+	Active      bool             `json:"active"`
+	Groups      []SCIMMultiValue `json:"groups,omitempty"`
+	Meta        SCIMMeta         `json:"meta"`
+}
+
+// SCIMUserParams are the attributes of a user the identity provider sets.
+// Active is nil when the user's status doesn't change.
+type SCIMUserParams struct {
+	ExternalID  string           `json:"externalId"`
+	UserName    string           `json:"userName"`
+	Name        SCIMName         `json:"name"`
+	DisplayName string           `json:"displayName"`
+	Emails      []SCIMMultiValue `json:"emails"`
+	Active      *scimBool        `json:"active"`
+	Password    string           `json:"password"`
+}
+
+// email returns the primary email address of the user, falling back to the
+// first one and then to the user name.
+func (p *SCIMUserParams) email() string {
+	for _, e := range p.Emails {
+		if e.Primary && e.Value != "" {
+			return e.Value
+		}
+	}
+	for _, e := range p.Emails {
+		if e.Value != "" {
+			return e.Value
+		}
+	}
+	if strings.Contains(p.UserName, "@") {
+		return p.UserName
+	}
+	return ""
+}
+
+// SCIMGroup is a group as represented by SCIM.
+type SCIMGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []SCIMMultiValue `json:"members,omitempty"`
+	Meta        SCIMMeta         `json:"meta"`
+}
+
+// SCIMGroupParams are the attributes of a group the identity provider sets.
+type SCIMGroupParams struct {
+	ExternalID  *string          `json:"externalId"`
+	DisplayName *string          `json:"displayName"`
+	Members     []SCIMMultiValue `json:"members"`
+}
+
+func (p *SCIMGroupParams) externalID() string {
+	if p.ExternalID == nil {
+		return ""
+	}
+	return *p.ExternalID
+}
+
+// SCIMListResponse is a page of users or groups.
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// SCIMPatchParams are the operations of a PATCH request.
+type SCIMPatchParams struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMPatchOperation is an operation of a PATCH request. Op is add, replace
+// or remove, in any case.
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// scimAttributes are the SCIM attributes of a user kept in the user's
+// app_metadata, under the scim key.
+type scimAttributes struct {
+	ExternalID    string   `json:"external_id,omitempty"`
+	UserName      string   `json:"user_name,omitempty"`
+	DisplayName   string   `json:"display_name,omitempty"`
+	GivenName     string   `json:"given_name,omitempty"`
+	FamilyName    string   `json:"family_name,omitempty"`
+	FormattedName string   `json:"formatted_name,omitempty"`
+	Groups        []string `json:"groups,omitempty"`
+
+	// Deactivated is set when the user is banned because the identity
+	// provider deactivated them, so that activating them again only lifts
+	// these bans.
+	Deactivated bool `json:"deactivated,omitempty"`
+}
+
+// isSCIMProvisioned reports whether the user was created through SCIM. Only
+// these users are changed through SCIM.
+func isSCIMProvisioned(user *models.User) bool {
+	_, ok := user.AppMetaData["scim"]
+	return ok
+}
+
+// scimUserNameConflict is the error of a userName another user has.
+func scimUserNameConflict() *SCIMError {
+	return scimError(conflictError("A user with this userName already exists"), "uniqueness")
+}
+
+func getSCIMAttributes(user *models.User) (*scimAttributes, error) {
+	attrs := &scimAttributes{}
+	if value, ok := user.AppMetaData["scim"]; ok && value != nil {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, attrs); err != nil {
+			return nil, err
+		}
+	}
+	return attrs, nil
+}
+
+func setSCIMAttributes(tx *storage.Connection, user *models.User, attrs *scimAttributes) error {
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return user.UpdateAppMetaData(tx, map[string]interface{}{
+		"scim": value,
+	})
+}
+
+func (a *API) scimLocation(resourceType, id string) string {
+	return strings.TrimSuffix(a.config.API.ExternalURL, "/") + "/scim/v2/" + resourceType + "/" + id
+}
+
+func (a *API) scimUser(tx *storage.Connection, user *models.User) (*SCIMUser, error) {
+	attrs, err := getSCIMAttributes(user)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := models.FindSCIMGroupsByUser(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &SCIMUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          user.ID.String(),
+		ExternalID:  attrs.ExternalID,
+		UserName:    attrs.UserName,
+		DisplayName: attrs.DisplayName,
+		Active:      !user.IsBanned(),
+		Meta: SCIMMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     a.scimLocation("Users", user.ID.String()),
+		},
+	}
+
+	if resp.UserName == "" {
+		resp.UserName = user.GetEmail()
+	}
+
+	if attrs.GivenName != "" || attrs.FamilyName != "" || attrs.FormattedName != "" {
+		resp.Name = &SCIMName{
+			Formatted:  attrs.FormattedName,
+			GivenName:  attrs.GivenName,
+			FamilyName: attrs.FamilyName,
+		}
+	}
+
+	if email := user.GetEmail(); email != "" {
+		resp.Emails = []SCIMMultiValue{{Value: email, Type: "work", Primary: true}}
+	}
+
+	for _, g := range groups {
+		resp.Groups = append(resp.Groups, SCIMMultiValue{Value: g.ID.String(), Display: g.DisplayName})
+	}
+
+	return resp, nil
+}
+
+func (a *API) scimGroup(group *models.SCIMGroup, memberIDs []uuid.UUID) *SCIMGroup {
+	resp := &SCIMGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          group.ID.String(),
+		ExternalID:  group.ExternalID.String(),
+		DisplayName: group.DisplayName,
+		Meta: SCIMMeta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     a.scimLocation("Groups", group.ID.String()),
+		},
+	}
+
+	for _, id := range memberIDs {
+		resp.Members = append(resp.Members, SCIMMultiValue{Value: id.String()})
+	}
+
+	return resp
+}
+
+func newSCIMListResponse(total, startIndex, itemsPerPage int, resources interface{}) *SCIMListResponse {
+	return &SCIMListResponse{
+		Schemas:      []string{scimListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: itemsPerPage,
+		Resources:    resources,
+	}
+}
+
+// parseSCIMFilter parses a filter of the form `attribute eq "value"` on one
+// of the attributes, given in lower case. An empty filter returns nil.
+func parseSCIMFilter(filter string, attributes ...string) (*models.SCIMFilter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+
+	matches := scimFilterRegexp.FindStringSubmatch(filter)
+	if matches == nil {
+		return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Only filters of the form 'attribute eq \"value\"' are supported"), "invalidFilter")
+	}
+
+	attribute := strings.ToLower(matches[1])
+	if !slices.Contains(attributes, attribute) {
+		return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Filtering on %s is not supported", matches[1]), "invalidFilter")
+	}
+
+	value, err := strconv.Unquote(matches[2])
+	if err != nil {
+		return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Invalid filter value %s", matches[2]), "invalidFilter")
+	}
+
+	return &models.SCIMFilter{Attribute: attribute, Value: value}, nil
+}
+
+// parseSCIMPagination returns the 1-based index of the first result and the
+// number of results of a list request.
+func parseSCIMPagination(query url.Values) (int, int, error) {
+	startIndex, count := 1, scimMaxResults
+
+	if value := query.Get("startIndex"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, 0, scimError(badRequestError(ErrorCodeValidationFailed, "startIndex must be an integer"), "invalidValue")
+		}
+		startIndex = max(n, 1)
+	}
+
+	if value := query.Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, 0, scimError(badRequestError(ErrorCodeValidationFailed, "count must be an integer"), "invalidValue")
+		}
+		count = min(max(n, 0), scimMaxResults)
+	}
+
+	return startIndex, count, nil
+}
+
+// scimResourceID returns the ID of the user or group in the URL. IDs that
+// aren't UUIDs can't exist.
+func scimResourceID(r *http.Request, notFound *HTTPError) (uuid.UUID, error) {
+	id, err := uuid.FromString(chi.URLParam(r, "id"))
+	if err != nil {
+		return uuid.Nil, scimError(notFound, "")
+	}
+	return id, nil
+}
+
+func (a *API) scimFindUser(r *http.Request, tx *storage.Connection) (*models.User, error) {
+	notFound := notFoundError(ErrorCodeUserNotFound, "User not found")
+
+	id, err := scimResourceID(r, notFound)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := models.FindUserByID(tx, id)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, scimError(notFound, "")
+		}
+		return nil, internalServerError("Database error loading user").WithInternalError(err)
+	}
+
+	if user.Aud != a.requestAud(r.Context(), r) || user.DeletedAt != nil {
+		return nil, scimError(notFound, "")
+	}
+
+	return user, nil
+}
+
+// scimFindProvisionedUser finds a user who was created through SCIM, users
+// who weren't are only listed.
+func (a *API) scimFindProvisionedUser(r *http.Request, tx *storage.Connection) (*models.User, error) {
+	user, err := a.scimFindUser(r, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSCIMProvisioned(user) {
+		return nil, scimError(forbiddenError(ErrorCodeSCIMUserNotProvisioned, "User wasn't provisioned through SCIM"), "")
+	}
+
+	return user, nil
+}
+
+func (a *API) scimFindGroup(r *http.Request, tx *storage.Connection) (*models.SCIMGroup, error) {
+	notFound := notFoundError(ErrorCodeSCIMGroupNotFound, "Group not found")
+
+	id, err := scimResourceID(r, notFound)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := models.FindSCIMGroupByID(tx, id)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, scimError(notFound, "")
+		}
+		return nil, internalServerError("Database error loading group").WithInternalError(err)
+	}
+
+	return group, nil
+}
+
+// scimServiceProviderConfig describes the SCIM features that are supported.
+func (a *API) scimServiceProviderConfig(w http.ResponseWriter, r *http.Request) error {
+	unsupported := map[string]interface{}{"supported": false}
+
+	return sendSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas": []string{scimServiceProviderConfigSchema},
+		"patch":   map[string]interface{}{"supported": true},
+		"bulk": map[string]interface{}{
+			"supported":      false,
+			"maxOperations":  0,
+			"maxPayloadSize": 0,
+		},
+		"filter": map[string]interface{}{
+			"supported":  true,
+			"maxResults": scimMaxResults,
+		},
+		"changePassword": map[string]interface{}{"supported": true},
+		"sort":           unsupported,
+		"etag":           unsupported,
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "Authentication with the SCIM token of the server",
+			"primary":     true,
+		}},
+	})
+}
+
+// scimUsersList lists the users, optionally filtered by userName,
+// externalId, id or email address.
+func (a *API) scimUsersList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	query := r.URL.Query()
+
+	filter, err := parseSCIMFilter(query.Get("filter"), "id", "username", "externalid", "emails", "emails.value")
+	if err != nil {
+		return err
+	}
+
+	startIndex, count, err := parseSCIMPagination(query)
+	if err != nil {
+		return err
+	}
+
+	users, total, err := models.FindSCIMUsers(db, a.requestAud(ctx, r), filter, startIndex-1, count)
+	if err != nil {
+		return internalServerError("Database error finding users").WithInternalError(err)
+	}
+
+	resources := make([]*SCIMUser, 0, len(users))
+	for _, user := range users {
+		resource, err := a.scimUser(db, user)
+		if err != nil {
+			return internalServerError("Database error loading user").WithInternalError(err)
+		}
+		resources = append(resources, resource)
+	}
+
+	return sendSCIM(w, http.StatusOK, newSCIMListResponse(total, startIndex, len(resources), resources))
+}
+
+func (a *API) scimUserGet(w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(r.Context())
+
+	user, err := a.scimFindUser(r, db)
+	if err != nil {
+		return err
+	}
+
+	resource, err := a.scimUser(db, user)
+	if err != nil {
+		return internalServerError("Database error loading user").WithInternalError(err)
+	}
+
+	return sendSCIM(w, http.StatusOK, resource)
+}
+
+// scimUserCreate provisions a user. Identity providers have verified the
+// email address of the user, so it is confirmed right away.
+func (a *API) scimUserCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.getConfig(ctx)
+	aud := a.requestAud(ctx, r)
+
+	params := &SCIMUserParams{}
+	if err := readSCIMBody(r, params); err != nil {
+		return err
+	}
+
+	if params.UserName == "" {
+		return scimError(badRequestError(ErrorCodeValidationFailed, "userName is required"), "invalidValue")
+	}
+
+	email, err := a.scimValidateEmail(params)
+	if err != nil {
+		return err
+	}
+
+	if user, err := models.IsDuplicatedEmail(db, email, aud, nil); err != nil {
+		return internalServerError("Database error checking email").WithInternalError(err)
+	} else if user != nil {
+		return scimError(conflictError(DuplicateEmailMsg), "uniqueness")
+	}
+
+	pw := params.Password
+	if pw == "" {
+		pw, err = password.Generate(64, 10, 0, false, true)
+		if err != nil {
+			return internalServerError("Error generating password").WithInternalError(err)
+		}
+	} else if err := a.checkPasswordStrength(ctx, pw); err != nil {
+		return scimError(badRequestError(ErrorCodeWeakPassword, "%s", err.Error()), "invalidValue")
+	}
+
+	user, err := models.NewUser("", email, pw, aud, nil)
+	if err != nil {
+		return internalServerError("Error creating user").WithInternalError(err)
+	}
+
+	user.AppMetaData = map[string]interface{}{
+		"provider":  "email",
+		"providers": []string{"email"},
+	}
+
+	var resource *SCIMUser
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.Create(user); terr != nil {
+			return terr
+		}
+
+		identity, terr := a.createNewIdentity(tx, user, "email", structs.Map(provider.Claims{
+			Subject:       user.ID.String(),
+			Email:         user.GetEmail(),
+			EmailVerified: true,
+		}))
+		if terr != nil {
+			return terr
+		}
+		user.Identities = []models.Identity{*identity}
+
+		if terr := user.SetRole(tx, config.JWT.DefaultGroupName); terr != nil {
+			return terr
+		}
+
+		if terr := user.Confirm(tx); terr != nil {
+			return terr
+		}
+
+		if terr := a.scimUpdateUser(tx, user, params); terr != nil {
+			return terr
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, scimActor(), models.UserSignedUpAction, "", map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+			"provider":   "scim",
+		}); terr != nil {
+			return terr
+		}
+
+		resource, terr = a.scimUser(tx, user)
+		return terr
+	})
+	if err != nil {
+		if models.IsDuplicatedSCIMUserNameError(err) {
+			return scimUserNameConflict()
+		}
+		return internalServerError("Database error creating user").WithInternalError(err)
+	}
+
+	a.notifyWebhook(r, db, conf.WebhookSignupEvent, user)
+
+	w.Header().Set("Location", resource.Meta.Location)
+	return sendSCIM(w, http.StatusCreated, resource)
+}
+
+// scimUserReplace replaces the attributes of a user.
+func (a *API) scimUserReplace(w http.ResponseWriter, r *http.Request) error {
+	params := &SCIMUserParams{}
+	if err := readSCIMBody(r, params); err != nil {
+		return err
+	}
+
+	return a.scimUserModify(w, r, func(*SCIMUserParams) (*SCIMUserParams, error) {
+		return params, nil
+	})
+}
+
+// scimUserPatch applies PATCH operations to the attributes of a user.
+func (a *API) scimUserPatch(w http.ResponseWriter, r *http.Request) error {
+	params := &SCIMPatchParams{}
+	if err := readSCIMBody(r, params); err != nil {
+		return err
+	}
+
+	return a.scimUserModify(w, r, func(current *SCIMUserParams) (*SCIMUserParams, error) {
+		data, err := json.Marshal(current)
+		if err != nil {
+			return nil, err
+		}
+		var resource map[string]interface{}
+		if err := json.Unmarshal(data, &resource); err != nil {
+			return nil, err
+		}
+
+		for _, op := range params.Operations {
+			if err := applySCIMPatchOperation(resource, op); err != nil {
+				return nil, err
+			}
+		}
+
+		data, err = json.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		patched := &SCIMUserParams{}
+		if err := json.Unmarshal(data, patched); err != nil {
+			return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Invalid attribute value: %v", err), "invalidValue")
+		}
+		return patched, nil
+	})
+}
+
+// scimUserModify updates a user with the attributes returned by modify,
+// which is given the current attributes.
+func (a *API) scimUserModify(w http.ResponseWriter, r *http.Request, modify func(*SCIMUserParams) (*SCIMUserParams, error)) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.getConfig(ctx)
+
+	user, err := a.scimFindProvisionedUser(r, db)
+	if err != nil {
+		return err
+	}
+
+	current, err := a.scimUser(db, user)
+	if err != nil {
+		return internalServerError("Database error loading user").WithInternalError(err)
+	}
+	active := scimBool(current.Active)
+	currentParams := &SCIMUserParams{
+		ExternalID:  current.ExternalID,
+		UserName:    current.UserName,
+		DisplayName: current.DisplayName,
+		Emails:      current.Emails,
+		Active:      &active,
+	}
+	if current.Name != nil {
+		currentParams.Name = *current.Name
+	}
+	params, err := modify(currentParams)
+	if err != nil {
+		return err
+	}
+
+	if params.UserName == "" {
+		return scimError(badRequestError(ErrorCodeValidationFailed, "userName is required"), "invalidValue")
+	}
+
+	email, err := a.scimValidateEmail(params)
+	if err != nil {
+		return err
+	}
+
+	if params.Password != "" {
+		if err := a.checkPasswordStrength(ctx, params.Password); err != nil {
+			return scimError(badRequestError(ErrorCodeWeakPassword, "%s", err.Error()), "invalidValue")
+		}
+		if err := user.SetPassword(ctx, params.Password, config.Security.DBEncryption.Encrypt, config.Security.DBEncryption.EncryptionKeyID, config.Security.DBEncryption.EncryptionKey); err != nil {
+			return internalServerError("Error hashing password").WithInternalError(err)
+		}
+	}
+
+	var resource *SCIMUser
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if email != user.GetEmail() {
+			if terr := a.scimSetEmail(tx, user, email); terr != nil {
+				return terr
+			}
+		}
+
+		if params.Password != "" {
+			if terr := user.UpdatePassword(tx, nil); terr != nil {
+				return terr
+			}
+		}
+
+		if terr := a.scimUpdateUser(tx, user, params); terr != nil {
+			return terr
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, scimActor(), models.UserModifiedAction, "", map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+			"provider":   "scim",
+		}); terr != nil {
+			return terr
+		}
+
+		var terr error
+		resource, terr = a.scimUser(tx, user)
+		return terr
+	})
+	if err != nil {
+		var scimErr *SCIMError
+		if errors.As(err, &scimErr) {
+			return scimErr
+		}
+		if models.IsDuplicatedSCIMUserNameError(err) {
+			return scimUserNameConflict()
+		}
+		return internalServerError("Database error updating user").WithInternalError(err)
+	}
+
+	a.notifyWebhook(r, db, conf.WebhookUserUpdatedEvent, user)
+
+	return sendSCIM(w, http.StatusOK, resource)
+}
+
+// scimUserDelete deprovisions a user.
+func (a *API) scimUserDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	var user *models.User
+	err := db.Transaction(func(tx *storage.Connection) error {
+		var terr error
+		user, terr = a.scimFindProvisionedUser(r, tx)
+		if terr != nil {
+			return terr
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, scimActor(), models.UserDeletedAction, "", map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+			"provider":   "scim",
+		}); terr != nil {
+			return terr
+		}
+
+		return tx.Destroy(user)
+	})
+	if err != nil {
+		var scimErr *SCIMError
+		if errors.As(err, &scimErr) {
+			return scimErr
+		}
+		return internalServerError("Database error deleting user").WithInternalError(err)
+	}
+
+	a.notifyWebhook(r, db, conf.WebhookUserDeletedEvent, user)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) scimValidateEmail(params *SCIMUserParams) (string, error) {
+	email := params.email()
+	if email == "" {
+		return "", scimError(badRequestError(ErrorCodeValidationFailed, "An email address is required, either in emails or as userName"), "invalidValue")
+	}
+
+	email, err := validateEmail(email)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			return "", scimError(httpErr, "invalidValue")
+		}
+		return "", err
+	}
+
+	return email, nil
+}
+
+// scimSetEmail changes the email address of the user and of their email
+// identity.
+func (a *API) scimSetEmail(tx *storage.Connection, user *models.User, email string) error {
+	if duplicate, err := models.IsDuplicatedEmail(tx, email, user.Aud, user); err != nil {
+		return err
+	} else if duplicate != nil {
+		return scimError(conflictError(DuplicateEmailMsg), "uniqueness")
+	}
+
+	identity, err := models.FindIdentityByIdAndProvider(tx, user.ID.String(), "email")
+	if err != nil && !models.IsNotFoundError(err) {
+		return err
+	}
+
+	if identity == nil {
+		identity, err = a.createNewIdentity(tx, user, "email", structs.Map(provider.Claims{
+			Subject:       user.ID.String(),
+			Email:         email,
+			EmailVerified: true,
+		}))
+		if err != nil {
+			return err
+		}
+		user.Identities = append(user.Identities, *identity)
+	} else if err := identity.UpdateIdentityData(tx, map[string]interface{}{
+		"email":          email,
+		"email_verified": true,
+	}); err != nil {
+		return err
+	}
+
+	if err := user.SetEmail(tx, email); err != nil {
+		return err
+	}
+
+	return user.Confirm(tx)
+}
+
+// scimUpdateUser keeps the SCIM attributes of the user in their app_metadata
+// and bans deactivated users, signing them out. Activating users only lifts
+// the bans of deactivating them, not those of the admin API.
+func (a *API) scimUpdateUser(tx *storage.Connection, user *models.User, params *SCIMUserParams) error {
+	attrs, err := getSCIMAttributes(user)
+	if err != nil {
+		return err
+	}
+
+	attrs.ExternalID = params.ExternalID
+	attrs.UserName = params.UserName
+	attrs.DisplayName = params.DisplayName
+	attrs.GivenName = params.Name.GivenName
+	attrs.FamilyName = params.Name.FamilyName
+	attrs.FormattedName = params.Name.Formatted
+
+	if params.Active != nil {
+		switch active := bool(*params.Active); {
+		case !active && !user.IsBanned():
+			if err := user.Ban(tx, scimBanDuration); err != nil {
+				return err
+			}
+			if err := models.Logout(tx, user.ID); err != nil {
+				return err
+			}
+			attrs.Deactivated = true
+
+		case active && attrs.Deactivated:
+			if err := user.Ban(tx, 0); err != nil {
+				return err
+			}
+			attrs.Deactivated = false
+		}
+	}
+
+	return setSCIMAttributes(tx, user, attrs)
+}
+
+// scimSyncGroups keeps the names of the groups of the users in their
+// app_metadata.
+func scimSyncGroups(tx *storage.Connection, userIDs []uuid.UUID) error {
+	for _, userID := range userIDs {
+		user, err := models.FindUserByID(tx, userID)
+		if err != nil {
+			if models.IsNotFoundError(err) {
+				continue
+			}
+			return err
+		}
+
+		groups, err := models.FindSCIMGroupsByUser(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		attrs, err := getSCIMAttributes(user)
+		if err != nil {
+			return err
+		}
+
+		attrs.Groups = nil
+		for _, g := range groups {
+			attrs.Groups = append(attrs.Groups, g.DisplayName)
+		}
+
+		if err := setSCIMAttributes(tx, user, attrs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applySCIMPatchOperation applies a PATCH operation to a resource decoded
+// into a map, see RFC 7644 section 3.5.2. Operations without a path set the
+// attributes of their value, which may be paths themselves.
+func applySCIMPatchOperation(resource map[string]interface{}, op SCIMPatchOperation) error {
+	opName := strings.ToLower(op.Op)
+	switch opName {
+	case "add", "replace", "remove":
+	default:
+		return scimError(badRequestError(ErrorCodeValidationFailed, "Unsupported PATCH operation %q", op.Op), "invalidSyntax")
+	}
+
+	var value interface{}
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return scimError(badRequestError(ErrorCodeBadJSON, "Invalid PATCH operation value: %v", err), "invalidSyntax")
+		}
+	}
+
+	if op.Path == "" {
+		values, ok := value.(map[string]interface{})
+		if opName == "remove" || !ok {
+			return scimError(badRequestError(ErrorCodeValidationFailed, "PATCH operations without a path need an object value"), "noTarget")
+		}
+		for path, v := range values {
+			if err := applySCIMPatchPath(resource, path, opName, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return applySCIMPatchPath(resource, op.Path, opName, value)
+}
+
+func applySCIMPatchPath(resource map[string]interface{}, path, op string, value interface{}) error {
+	// attributes can be prefixed with the URN of their schema
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		path = path[strings.LastIndex(path, ":")+1:]
+	}
+
+	matches := scimPathRegexp.FindStringSubmatch(path)
+	if matches == nil {
+		return scimError(badRequestError(ErrorCodeValidationFailed, "Invalid PATCH path %q", path), "invalidPath")
+	}
+	attribute, valueFilter, subAttribute := matches[1], matches[2], matches[3]
+	key := scimKey(resource, attribute)
+
+	if valueFilter == "" {
+		if subAttribute != "" {
+			complexValue, _ := resource[key].(map[string]interface{})
+			if complexValue == nil {
+				complexValue = make(map[string]interface{})
+			}
+			if err := applySCIMPatchPath(complexValue, subAttribute, op, value); err != nil {
+				return err
+			}
+			resource[key] = complexValue
+			return nil
+		}
+
+		switch op {
+		case "remove":
+			delete(resource, key)
+		case "add":
+			existing, isList := resource[key].([]interface{})
+			added, addsList := value.([]interface{})
+			if isList && addsList {
+				resource[key] = append(existing, added...)
+			} else {
+				resource[key] = value
+			}
+		default:
+			resource[key] = value
+		}
+		return nil
+	}
+
+	filter, err := parseSCIMFilter(valueFilter, "value", "type", "primary", "display")
+	if err != nil {
+		return scimError(badRequestError(ErrorCodeValidationFailed, "Invalid PATCH path %q", path), "invalidPath")
+	}
+
+	values, _ := resource[key].([]interface{})
+	updated := make([]interface{}, 0, len(values))
+	matched := false
+	for _, v := range values {
+		item, ok := v.(map[string]interface{})
+		if !ok || !strings.EqualFold(fmt.Sprint(item[scimKey(item, filter.Attribute)]), filter.Value) {
+			updated = append(updated, v)
+			continue
+		}
+
+		matched = true
+		switch {
+		case op == "remove" && subAttribute == "":
+			continue
+		case op == "remove":
+			delete(item, scimKey(item, subAttribute))
+		case subAttribute == "":
+			if replacement, ok := value.(map[string]interface{}); ok {
+				item = replacement
+			}
+		default:
+			item[scimKey(item, subAttribute)] = value
+		}
+		updated = append(updated, item)
+	}
+
+	if !matched && op != "remove" {
+		item := map[string]interface{}{filter.Attribute: filter.Value}
+		if subAttribute != "" {
+			item[subAttribute] = value
+		} else if replacement, ok := value.(map[string]interface{}); ok {
+			item = replacement
+		}
+		updated = append(updated, item)
+	}
+
+	resource[key] = updated
+	return nil
+}
+
+// scimKey returns the key of the attribute in the resource, as attribute
+// names are case insensitive.
+func scimKey(resource map[string]interface{}, attribute string) string {
+	for key := range resource {
+		if strings.EqualFold(key, attribute) {
+			return key
+		}
+	}
+	return attribute
+}
+
+// scimGroupsList lists the groups, optionally filtered by displayName,
+// externalId or id. Members are left out with excludedAttributes=members.
+func (a *API) scimGroupsList(w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(r.Context())
+	query := r.URL.Query()
+
+	filter, err := parseSCIMFilter(query.Get("filter"), "id", "displayname", "externalid")
+	if err != nil {
+		return err
+	}
+
+	startIndex, count, err := parseSCIMPagination(query)
+	if err != nil {
+		return err
+	}
+
+	withMembers := !slices.ContainsFunc(strings.Split(query.Get("excludedAttributes"), ","), func(attribute string) bool {
+		return strings.EqualFold(strings.TrimSpace(attribute), "members")
+	})
+
+	groups, total, err := models.FindSCIMGroups(db, filter, startIndex-1, count)
+	if err != nil {
+		return internalServerError("Database error finding groups").WithInternalError(err)
+	}
+
+	resources := make([]*SCIMGroup, 0, len(groups))
+	for _, group := range groups {
+		var memberIDs []uuid.UUID
+		if withMembers {
+			memberIDs, err = group.MemberIDs(db)
+			if err != nil {
+				return internalServerError("Database error loading group members").WithInternalError(err)
+			}
+		}
+		resources = append(resources, a.scimGroup(group, memberIDs))
+	}
+
+	return sendSCIM(w, http.StatusOK, newSCIMListResponse(total, startIndex, len(resources), resources))
+}
+
+func (a *API) scimGroupGet(w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(r.Context())
+
+	group, err := a.scimFindGroup(r, db)
+	if err != nil {
+		return err
+	}
+
+	memberIDs, err := group.MemberIDs(db)
+	if err != nil {
+		return internalServerError("Database error loading group members").WithInternalError(err)
+	}
+
+	return sendSCIM(w, http.StatusOK, a.scimGroup(group, memberIDs))
+}
+
+func (a *API) scimGroupCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+
+	params := &SCIMGroupParams{}
+	if err := readSCIMBody(r, params); err != nil {
+		return err
+	}
+
+	if params.DisplayName == nil || *params.DisplayName == "" {
+		return scimError(badRequestError(ErrorCodeValidationFailed, "displayName is required"), "invalidValue")
+	}
+
+	group, err := models.NewSCIMGroup(*params.DisplayName, params.externalID())
+	if err != nil {
+		return internalServerError("Error creating group").WithInternalError(err)
+	}
+
+	var resource *SCIMGroup
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := a.scimCheckGroupName(tx, group); terr != nil {
+			return terr
+		}
+
+		if terr := tx.Create(group); terr != nil {
+			return terr
+		}
+
+		memberIDs, terr := a.scimSetMembers(r, tx, group, nil, params.Members, false)
+		if terr != nil {
+			return terr
+		}
+
+		resource = a.scimGroup(group, memberIDs)
+		return nil
+	})
+	if err != nil {
+		var scimErr *SCIMError
+		if errors.As(err, &scimErr) {
+			return scimErr
+		}
+		return internalServerError("Database error creating group").WithInternalError(err)
+	}
+
+	w.Header().Set("Location", resource.Meta.Location)
+	return sendSCIM(w, http.StatusCreated, resource)
+}
+
+// scimGroupReplace replaces the name and the members of a group.
+func (a *API) scimGroupReplace(w http.ResponseWriter, r *http.Request) error {
+	params := &SCIMGroupParams{}
+	if err := readSCIMBody(r, params); err != nil {
+		return err
+	}
+
+	if params.DisplayName == nil || *params.DisplayName == "" {
+		return scimError(badRequestError(ErrorCodeValidationFailed, "displayName is required"), "invalidValue")
+	}
+
+	return a.scimGroupModify(w, r, func(group *models.SCIMGroup, members []uuid.UUID) ([]uuid.UUID, error) {
+		group.DisplayName = *params.DisplayName
+		group.ExternalID = storage.NullString(params.externalID())
+
+		return scimMemberIDs(params.Members)
+	})
+}
+
+// scimGroupPatch applies PATCH operations to the name and the members of a
+// group.
+func (a *API) scimGroupPatch(w http.ResponseWriter, r *http.Request) error {
+	params := &SCIMPatchParams{}
+	if err := readSCIMBody(r, params); err != nil {
+		return err
+	}
+
+	return a.scimGroupModify(w, r, func(group *models.SCIMGroup, members []uuid.UUID) ([]uuid.UUID, error) {
+		for _, op := range params.Operations {
+			var err error
+			members, err = applySCIMGroupPatchOperation(group, members, op)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return members, nil
+	})
+}
+
+// scimGroupModify updates a group with modify, which changes the group and
+// returns its new members.
+func (a *API) scimGroupModify(w http.ResponseWriter, r *http.Request, modify func(*models.SCIMGroup, []uuid.UUID) ([]uuid.UUID, error)) error {
+	db := a.db.WithContext(r.Context())
+
+	var resource *SCIMGroup
+	err := db.Transaction(func(tx *storage.Connection) error {
+		group, terr := a.scimFindGroup(r, tx)
+		if terr != nil {
+			return terr
+		}
+
+		members, terr := group.MemberIDs(tx)
+		if terr != nil {
+			return terr
+		}
+
+		displayName := group.DisplayName
+		updated, terr := modify(group, slices.Clone(members))
+		if terr != nil {
+			return terr
+		}
+
+		if group.DisplayName == "" {
+			return scimError(badRequestError(ErrorCodeValidationFailed, "displayName is required"), "invalidValue")
+		}
+
+		renamed := group.DisplayName != displayName
+		if renamed {
+			if terr := a.scimCheckGroupName(tx, group); terr != nil {
+				return terr
+			}
+		}
+
+		if terr := tx.UpdateOnly(group, "display_name", "external_id"); terr != nil {
+			return terr
+		}
+
+		updated, terr = a.scimSetMembers(r, tx, group, members, scimMemberValues(updated), renamed)
+		if terr != nil {
+			return terr
+		}
+
+		resource = a.scimGroup(group, updated)
+		return nil
+	})
+	if err != nil {
+		var scimErr *SCIMError
+		if errors.As(err, &scimErr) {
+			return scimErr
+		}
+		return internalServerError("Database error updating group").WithInternalError(err)
+	}
+
+	return sendSCIM(w, http.StatusOK, resource)
+}
+
+func (a *API) scimGroupDelete(w http.ResponseWriter, r *http.Request) error {
+	db := a.db.WithContext(r.Context())
+
+	err := db.Transaction(func(tx *storage.Connection) error {
+		group, terr := a.scimFindGroup(r, tx)
+		if terr != nil {
+			return terr
+		}
+
+		members, terr := group.MemberIDs(tx)
+		if terr != nil {
+			return terr
+		}
+
+		if terr := tx.Destroy(group); terr != nil {
+			return terr
+		}
+
+		return scimSyncGroups(tx, members)
+	})
+	if err != nil {
+		var scimErr *SCIMError
+		if errors.As(err, &scimErr) {
+			return scimErr
+		}
+		return internalServerError("Database error deleting group").WithInternalError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// scimCheckGroupName returns a conflict if another group has the name of the
+// group.
+func (a *API) scimCheckGroupName(tx *storage.Connection, group *models.SCIMGroup) error {
+	existing, err := models.FindSCIMGroupByDisplayName(tx, group.DisplayName)
+	if err != nil && !models.IsNotFoundError(err) {
+		return err
+	}
+	if existing != nil && existing.ID != group.ID {
+		return scimError(conflictError("A group with this displayName already exists"), "uniqueness")
+	}
+	return nil
+}
+
+// scimSetMembers changes the members of the group from current to values,
+// and updates the groups in the app_metadata of the users added or removed,
+// or of all members if the group was renamed. It returns the new members.
+func (a *API) scimSetMembers(r *http.Request, tx *storage.Connection, group *models.SCIMGroup, current []uuid.UUID, values []SCIMMultiValue, renamed bool) ([]uuid.UUID, error) {
+	members, err := scimMemberIDs(values)
+	if err != nil {
+		return nil, err
+	}
+
+	var added, removed []uuid.UUID
+	for _, id := range members {
+		if !slices.Contains(current, id) {
+			added = append(added, id)
+		}
+	}
+	for _, id := range current {
+		if !slices.Contains(members, id) {
+			removed = append(removed, id)
+		}
+	}
+
+	aud := a.requestAud(r.Context(), r)
+	for _, id := range added {
+		user, err := models.FindUserByID(tx, id)
+		if err != nil && !models.IsNotFoundError(err) {
+			return nil, err
+		}
+		if user == nil || user.Aud != aud || user.DeletedAt != nil {
+			return nil, scimError(badRequestError(ErrorCodeUserNotFound, "User %s not found", id), "invalidValue")
+		}
+		if !isSCIMProvisioned(user) {
+			return nil, scimError(badRequestError(ErrorCodeSCIMUserNotProvisioned, "User %s wasn't provisioned through SCIM", id), "invalidValue")
+		}
+	}
+
+	if err := group.AddMembers(tx, added); err != nil {
+		return nil, err
+	}
+	if err := group.RemoveMembers(tx, removed); err != nil {
+		return nil, err
+	}
+
+	synced := slices.Concat(added, removed)
+	if renamed {
+		synced = slices.Concat(members, removed)
+	}
+	if err := scimSyncGroups(tx, synced); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// scimMemberIDs returns the user IDs of the members, without duplicates.
+func scimMemberIDs(values []SCIMMultiValue) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, 0, len(values))
+	for _, v := range values {
+		id, err := uuid.FromString(v.Value)
+		if err != nil {
+			return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Invalid member %q", v.Value), "invalidValue")
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func scimMemberValues(ids []uuid.UUID) []SCIMMultiValue {
+	values := make([]SCIMMultiValue, len(ids))
+	for i, id := range ids {
+		values[i] = SCIMMultiValue{Value: id.String()}
+	}
+	return values
+}
+
+// applySCIMGroupPatchOperation applies a PATCH operation to the group and
+// returns its new members. Operations can set displayName and externalId,
+// add, remove or replace members, and remove a member with the path
+// members[value eq "id"].
+func applySCIMGroupPatchOperation(group *models.SCIMGroup, members []uuid.UUID, op SCIMPatchOperation) ([]uuid.UUID, error) {
+	opName := strings.ToLower(op.Op)
+	switch opName {
+	case "add", "replace", "remove":
+	default:
+		return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Unsupported PATCH operation %q", op.Op), "invalidSyntax")
+	}
+
+	path := op.Path
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		path = path[strings.LastIndex(path, ":")+1:]
+	}
+
+	if path == "" {
+		if opName == "remove" {
+			return nil, scimError(badRequestError(ErrorCodeValidationFailed, "PATCH operations without a path need an object value"), "noTarget")
+		}
+
+		params := &SCIMGroupParams{}
+		if err := json.Unmarshal(op.Value, params); err != nil {
+			return nil, scimError(badRequestError(ErrorCodeBadJSON, "Invalid PATCH operation value: %v", err), "invalidValue")
+		}
+		if params.DisplayName != nil {
+			group.DisplayName = *params.DisplayName
+		}
+		if params.ExternalID != nil {
+			group.ExternalID = storage.NullString(*params.ExternalID)
+		}
+		if params.Members == nil {
+			return members, nil
+		}
+		return patchSCIMMembers(members, opName, params.Members)
+	}
+
+	matches := scimPathRegexp.FindStringSubmatch(path)
+	if matches == nil || matches[3] != "" {
+		return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Invalid PATCH path %q", op.Path), "invalidPath")
+	}
+
+	switch attribute, valueFilter := strings.ToLower(matches[1]), matches[2]; {
+	case attribute == "displayname" && valueFilter == "":
+		var displayName string
+		if opName == "remove" || json.Unmarshal(op.Value, &displayName) != nil {
+			return nil, scimError(badRequestError(ErrorCodeValidationFailed, "displayName must be a string"), "invalidValue")
+		}
+		group.DisplayName = displayName
+
+	case attribute == "externalid" && valueFilter == "":
+		var externalID string
+		if opName != "remove" && json.Unmarshal(op.Value, &externalID) != nil {
+			return nil, scimError(badRequestError(ErrorCodeValidationFailed, "externalId must be a string"), "invalidValue")
+		}
+		group.ExternalID = storage.NullString(externalID)
+
+	case attribute == "members" && valueFilter != "":
+		filter, err := parseSCIMFilter(valueFilter, "value")
+		if err != nil || opName != "remove" {
+			return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Members can only be removed with the path %q", `members[value eq "id"]`), "invalidPath")
+		}
+		return patchSCIMMembers(members, opName, []SCIMMultiValue{{Value: filter.Value}})
+
+	case attribute == "members":
+		var values []SCIMMultiValue
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return nil, scimError(badRequestError(ErrorCodeBadJSON, "Invalid members: %v", err), "invalidValue")
+			}
+		}
+		if opName == "remove" && len(values) == 0 {
+			return []uuid.UUID{}, nil
+		}
+		return patchSCIMMembers(members, opName, values)
+
+	default:
+		return nil, scimError(badRequestError(ErrorCodeValidationFailed, "Unsupported PATCH path %q", op.Path), "invalidPath")
+	}
+
+	return members, nil
+}
+
+func patchSCIMMembers(members []uuid.UUID, op string, values []SCIMMultiValue) ([]uuid.UUID, error) {
+	ids, err := scimMemberIDs(values)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "add":
+		for _, id := range ids {
+			if !slices.Contains(members, id) {
+				members = append(members, id)
+			}
+		}
+		return members, nil
+
+	case "remove":
+		return slices.DeleteFunc(members, func(id uuid.UUID) bool {
+			return slices.Contains(ids, id)
+		}), nil
+
+	default:
+		return ids, nil
+	}
+}
@@ -0,0 +1,477 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+const scimTestToken = "scim-test-token-0123456789abcdefghij"
+
+type SCIMTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+}
+
+func TestSCIM(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &SCIMTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *SCIMTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	ts.Config.SCIM = conf.SCIMConfiguration{
+		Enabled: true,
+		Token:   scimTestToken,
+	}
+}
+
+func (ts *SCIMTestSuite) TearDownTest() {
+	ts.Config.SCIM = conf.SCIMConfiguration{}
+}
+
+func (ts *SCIMTestSuite) request(method, path string, body interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if body != nil {
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Content-Type", "application/scim+json")
+	req.Header.Set("Authorization", "Bearer "+scimTestToken)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *SCIMTestSuite) createUser(userName string) *SCIMUser {
+	w := ts.request(http.MethodPost, "/scim/v2/Users", map[string]interface{}{
+		"schemas":    []string{scimUserSchema},
+		"userName":   userName,
+		"externalId": "ext-" + userName,
+		"name": map[string]interface{}{
+			"givenName":  "Jane",
+			"familyName": "Doe",
+		},
+		"emails": []map[string]interface{}{
+			{"value": userName, "type": "work", "primary": true},
+		},
+		"active": true,
+	})
+	require.Equal(ts.T(), http.StatusCreated, w.Code, w.Body.String())
+
+	user := &SCIMUser{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(user))
+	return user
+}
+
+func (ts *SCIMTestSuite) findUser(id string) *models.User {
+	user, err := models.FindUserByID(ts.API.db, uuid.FromStringOrNil(id))
+	require.NoError(ts.T(), err)
+	return user
+}
+
+func (ts *SCIMTestSuite) TestDisabled() {
+	ts.Config.SCIM.Enabled = false
+
+	w := ts.request(http.MethodGet, "/scim/v2/Users", nil)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+}
+
+func (ts *SCIMTestSuite) TestInvalidToken() {
+	req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+	require.Equal(ts.T(), "application/scim+json", w.Header().Get("Content-Type"))
+
+	var resp scimErrorResponse
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(ts.T(), []string{scimErrorSchema}, resp.Schemas)
+	require.Equal(ts.T(), "401", resp.Status)
+}
+
+func (ts *SCIMTestSuite) TestCreateUser() {
+	created := ts.createUser("jane@example.com")
+	require.Equal(ts.T(), "jane@example.com", created.UserName)
+	require.Equal(ts.T(), "ext-jane@example.com", created.ExternalID)
+	require.True(ts.T(), created.Active)
+	require.Equal(ts.T(), &SCIMName{GivenName: "Jane", FamilyName: "Doe"}, created.Name)
+
+	user := ts.findUser(created.ID)
+	require.Equal(ts.T(), "jane@example.com", user.GetEmail())
+	require.True(ts.T(), user.IsConfirmed())
+	require.Equal(ts.T(), map[string]interface{}{
+		"external_id": "ext-jane@example.com",
+		"user_name":   "jane@example.com",
+		"given_name":  "Jane",
+		"family_name": "Doe",
+	}, user.AppMetaData["scim"])
+
+	// the user name must be unique
+	w := ts.request(http.MethodPost, "/scim/v2/Users", map[string]interface{}{
+		"userName": "JANE@example.com",
+		"emails": []map[string]interface{}{
+			{"value": "janet@example.com", "primary": true},
+		},
+	})
+	require.Equal(ts.T(), http.StatusConflict, w.Code, w.Body.String())
+
+	var resp scimErrorResponse
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(ts.T(), "uniqueness", resp.ScimType)
+}
+
+func (ts *SCIMTestSuite) TestListUsers() {
+	for i := 0; i < 3; i++ {
+		ts.createUser(fmt.Sprintf("user%d@example.com", i))
+	}
+
+	w := ts.request(http.MethodGet, `/scim/v2/Users?filter=userName+eq+"USER1@example.com"`, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		TotalResults int        `json:"totalResults"`
+		Resources    []SCIMUser `json:"Resources"`
+	}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(ts.T(), 1, resp.TotalResults)
+	require.Equal(ts.T(), "user1@example.com", resp.Resources[0].UserName)
+
+	w = ts.request(http.MethodGet, "/scim/v2/Users?startIndex=2&count=1", nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(ts.T(), 3, resp.TotalResults)
+	require.Len(ts.T(), resp.Resources, 1)
+	require.Equal(ts.T(), "user1@example.com", resp.Resources[0].UserName)
+
+	w = ts.request(http.MethodGet, `/scim/v2/Users?filter=title+eq+"CEO"`, nil)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func (ts *SCIMTestSuite) TestDeactivateUser() {
+	created := ts.createUser("jane@example.com")
+
+	s, err := models.NewSession(uuid.FromStringOrNil(created.ID), nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(s))
+
+	// as sent by Microsoft Entra ID
+	w := ts.request(http.MethodPatch, "/scim/v2/Users/"+created.ID, map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "Replace", "path": "active", "value": "False"},
+			{"op": "Replace", "path": "name.givenName", "value": "Janet"},
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	patched := &SCIMUser{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(patched))
+	require.False(ts.T(), patched.Active)
+	require.Equal(ts.T(), "Janet", patched.Name.GivenName)
+
+	user := ts.findUser(created.ID)
+	require.True(ts.T(), user.IsBanned())
+
+	_, err = models.FindSessionByID(ts.API.db, s.ID, false)
+	require.True(ts.T(), models.IsNotFoundError(err))
+
+	// as sent by Okta
+	w = ts.request(http.MethodPatch, "/scim/v2/Users/"+created.ID, map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "replace", "value": map[string]interface{}{"active": true}},
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.False(ts.T(), ts.findUser(created.ID).IsBanned())
+}
+
+func (ts *SCIMTestSuite) TestReplaceUser() {
+	created := ts.createUser("jane@example.com")
+
+	w := ts.request(http.MethodPut, "/scim/v2/Users/"+created.ID, map[string]interface{}{
+		"schemas":  []string{scimUserSchema},
+		"userName": "jane.doe@example.com",
+		"emails": []map[string]interface{}{
+			{"value": "jane.doe@example.com", "primary": true},
+		},
+		"displayName": "Jane Doe",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	user := ts.findUser(created.ID)
+	require.Equal(ts.T(), "jane.doe@example.com", user.GetEmail())
+	require.False(ts.T(), user.IsBanned())
+
+	identity, err := models.FindIdentityByIdAndProvider(ts.API.db, user.ID.String(), "email")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "jane.doe@example.com", identity.IdentityData["email"])
+}
+
+func (ts *SCIMTestSuite) TestActivateBannedUser() {
+	created := ts.createUser("jane@example.com")
+
+	user := ts.findUser(created.ID)
+	require.NoError(ts.T(), user.Ban(ts.API.db, time.Hour))
+
+	// bans set through the admin API aren't lifted
+	for _, active := range []bool{false, true} {
+		w := ts.request(http.MethodPatch, "/scim/v2/Users/"+created.ID, map[string]interface{}{
+			"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+			"Operations": []map[string]interface{}{
+				{"op": "replace", "path": "active", "value": active},
+			},
+		})
+		require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+		require.True(ts.T(), ts.findUser(created.ID).IsBanned())
+	}
+}
+
+func (ts *SCIMTestSuite) TestRenameUserToTakenUserName() {
+	ts.createUser("jane@example.com")
+	john := ts.createUser("john@example.com")
+
+	w := ts.request(http.MethodPut, "/scim/v2/Users/"+john.ID, map[string]interface{}{
+		"schemas":  []string{scimUserSchema},
+		"userName": "Jane@example.com",
+		"emails": []map[string]interface{}{
+			{"value": "john@example.com", "primary": true},
+		},
+	})
+	require.Equal(ts.T(), http.StatusConflict, w.Code, w.Body.String())
+
+	var resp scimErrorResponse
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(ts.T(), "uniqueness", resp.ScimType)
+}
+
+func (ts *SCIMTestSuite) TestUserNotProvisioned() {
+	user, err := models.NewUser("", "jane@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(user))
+
+	// the user is listed, but can't be changed
+	w := ts.request(http.MethodGet, "/scim/v2/Users/"+user.ID.String(), nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	w = ts.request(http.MethodPatch, "/scim/v2/Users/"+user.ID.String(), map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "replace", "path": "active", "value": false},
+		},
+	})
+	require.Equal(ts.T(), http.StatusForbidden, w.Code, w.Body.String())
+	require.False(ts.T(), ts.findUser(user.ID.String()).IsBanned())
+
+	w = ts.request(http.MethodDelete, "/scim/v2/Users/"+user.ID.String(), nil)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code, w.Body.String())
+
+	w = ts.request(http.MethodPost, "/scim/v2/Groups", map[string]interface{}{
+		"schemas":     []string{scimGroupSchema},
+		"displayName": "Engineering",
+		"members": []map[string]interface{}{
+			{"value": user.ID.String()},
+		},
+	})
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func (ts *SCIMTestSuite) TestDeleteUser() {
+	created := ts.createUser("jane@example.com")
+
+	w := ts.request(http.MethodDelete, "/scim/v2/Users/"+created.ID, nil)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code, w.Body.String())
+
+	w = ts.request(http.MethodGet, "/scim/v2/Users/"+created.ID, nil)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code, w.Body.String())
+}
+
+func (ts *SCIMTestSuite) TestGroups() {
+	jane := ts.createUser("jane@example.com")
+	john := ts.createUser("john@example.com")
+
+	w := ts.request(http.MethodPost, "/scim/v2/Groups", map[string]interface{}{
+		"schemas":     []string{scimGroupSchema},
+		"displayName": "Engineering",
+		"members":     []map[string]interface{}{{"value": jane.ID}},
+	})
+	require.Equal(ts.T(), http.StatusCreated, w.Code, w.Body.String())
+
+	group := &SCIMGroup{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(group))
+	require.Equal(ts.T(), []SCIMMultiValue{{Value: jane.ID}}, group.Members)
+
+	scimGroups := func(id string) interface{} {
+		attrs, err := getSCIMAttributes(ts.findUser(id))
+		require.NoError(ts.T(), err)
+		return attrs.Groups
+	}
+	require.Equal(ts.T(), []string{"Engineering"}, scimGroups(jane.ID))
+
+	// the names of groups are unique
+	w = ts.request(http.MethodPost, "/scim/v2/Groups", map[string]interface{}{
+		"displayName": "engineering",
+	})
+	require.Equal(ts.T(), http.StatusConflict, w.Code, w.Body.String())
+
+	w = ts.request(http.MethodPatch, "/scim/v2/Groups/"+group.ID, map[string]interface{}{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []map[string]interface{}{
+			{"op": "add", "path": "members", "value": []map[string]interface{}{{"value": john.ID}}},
+			{"op": "remove", "path": fmt.Sprintf(`members[value eq "%s"]`, jane.ID)},
+			{"op": "replace", "path": "displayName", "value": "Platform"},
+		},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(group))
+	require.Equal(ts.T(), "Platform", group.DisplayName)
+	require.Equal(ts.T(), []SCIMMultiValue{{Value: john.ID}}, group.Members)
+
+	require.Nil(ts.T(), scimGroups(jane.ID))
+	require.Equal(ts.T(), []string{"Platform"}, scimGroups(john.ID))
+
+	w = ts.request(http.MethodGet, `/scim/v2/Groups?filter=displayName+eq+"Platform"&excludedAttributes=members`, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		TotalResults int         `json:"totalResults"`
+		Resources    []SCIMGroup `json:"Resources"`
+	}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(ts.T(), 1, resp.TotalResults)
+	require.Empty(ts.T(), resp.Resources[0].Members)
+
+	w = ts.request(http.MethodDelete, "/scim/v2/Groups/"+group.ID, nil)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code, w.Body.String())
+	require.Nil(ts.T(), scimGroups(john.ID))
+}
+
+func TestParseSCIMFilter(t *testing.T) {
+	filter, err := parseSCIMFilter(`userName Eq "jane@example.com"`, "username")
+	require.NoError(t, err)
+	require.Equal(t, &models.SCIMFilter{Attribute: "username", Value: "jane@example.com"}, filter)
+
+	filter, err = parseSCIMFilter(`externalId eq "a \"quoted\" id"`, "externalid")
+	require.NoError(t, err)
+	require.Equal(t, `a "quoted" id`, filter.Value)
+
+	filter, err = parseSCIMFilter("", "username")
+	require.NoError(t, err)
+	require.Nil(t, filter)
+
+	for _, invalid := range []string{
+		`userName sw "jane"`,
+		`userName eq "jane" and active eq true`,
+		`title eq "CEO"`,
+	} {
+		_, err := parseSCIMFilter(invalid, "username")
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestApplySCIMPatchOperation(t *testing.T) {
+	resource := map[string]interface{}{
+		"userName": "jane@example.com",
+		"name":     map[string]interface{}{"givenName": "Jane"},
+		"emails": []interface{}{
+			map[string]interface{}{"value": "jane@example.com", "type": "work", "primary": true},
+		},
+		"active": true,
+	}
+
+	for _, op := range []SCIMPatchOperation{
+		{Op: "Replace", Path: "emails[type eq \"work\"].value", Value: json.RawMessage(`"jane.doe@example.com"`)},
+		{Op: "replace", Path: "name.familyName", Value: json.RawMessage(`"Doe"`)},
+		{Op: "add", Path: "urn:ietf:params:scim:schemas:core:2.0:User:displayName", Value: json.RawMessage(`"Jane Doe"`)},
+		{Op: "replace", Value: json.RawMessage(`{"active": "False", "name.givenName": "Janet"}`)},
+		{Op: "remove", Path: "userName"},
+	} {
+		require.NoError(t, applySCIMPatchOperation(resource, op))
+	}
+
+	require.Equal(t, map[string]interface{}{
+		"name": map[string]interface{}{"givenName": "Janet", "familyName": "Doe"},
+		"emails": []interface{}{
+			map[string]interface{}{"value": "jane.doe@example.com", "type": "work", "primary": true},
+		},
+		"displayName": "Jane Doe",
+		"active":      "False",
+	}, resource)
+
+	data, err := json.Marshal(resource)
+	require.NoError(t, err)
+	var params SCIMUserParams
+	require.NoError(t, json.Unmarshal(data, &params))
+	require.False(t, bool(*params.Active))
+	require.Equal(t, "jane.doe@example.com", params.email())
+
+	require.Error(t, applySCIMPatchOperation(resource, SCIMPatchOperation{Op: "move", Path: "active"}))
+	require.Error(t, applySCIMPatchOperation(resource, SCIMPatchOperation{Op: "remove"}))
+	require.Error(t, applySCIMPatchOperation(resource, SCIMPatchOperation{Op: "add", Path: "emails[", Value: json.RawMessage(`""`)}))
+}
+
+func TestApplySCIMGroupPatchOperation(t *testing.T) {
+	jane, john := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	group := &models.SCIMGroup{DisplayName: "Engineering"}
+
+	members, err := applySCIMGroupPatchOperation(group, []uuid.UUID{jane}, SCIMPatchOperation{
+		Op:    "Add",
+		Path:  "members",
+		Value: json.RawMessage(fmt.Sprintf(`[{"value": "%s"}, {"value": "%s"}]`, jane, john)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{jane, john}, members)
+
+	members, err = applySCIMGroupPatchOperation(group, members, SCIMPatchOperation{
+		Op:    "Remove",
+		Path:  "members",
+		Value: json.RawMessage(fmt.Sprintf(`[{"value": "%s"}]`, jane)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{john}, members)
+
+	members, err = applySCIMGroupPatchOperation(group, members, SCIMPatchOperation{
+		Op:    "replace",
+		Value: json.RawMessage(`{"id": "ignored", "displayName": "Platform", "externalId": "okta-1"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{john}, members)
+	require.Equal(t, "Platform", group.DisplayName)
+	require.Equal(t, "okta-1", group.ExternalID.String())
+
+	members, err = applySCIMGroupPatchOperation(group, members, SCIMPatchOperation{Op: "remove", Path: "members"})
+	require.NoError(t, err)
+	require.Empty(t, members)
+
+	_, err = applySCIMGroupPatchOperation(group, members, SCIMPatchOperation{
+		Op:    "add",
+		Path:  "members",
+		Value: json.RawMessage(`[{"value": "not-a-uuid"}]`),
+	})
+	require.Error(t, err)
+}
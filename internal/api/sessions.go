@@ -0,0 +1,242 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// SessionResponse is a session of a user, which is a device they are
+// signed in on. LastUsedAt is when the session was last refreshed.
+type SessionResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	NotAfter   *time.Time `json:"not_after,omitempty"`
+	UserAgent  *string    `json:"user_agent,omitempty"`
+	IP         *string    `json:"ip,omitempty"`
+	AAL        string     `json:"aal"`
+	Tag        *string    `json:"tag,omitempty"`
+	Current    bool       `json:"current,omitempty"`
+}
+
+// SessionsResponse lists the active sessions of a user, the newest first.
+type SessionsResponse struct {
+	Sessions []*SessionResponse `json:"sessions"`
+}
+
+// activeSessions returns the sessions of the user which haven't expired or
+// timed out.
+func (a *API) activeSessions(db *storage.Connection, userID uuid.UUID) ([]*models.Session, error) {
+	config := a.config
+
+	sessions, err := models.FindUserSessions(db, userID)
+	if err != nil {
+		return nil, internalServerError("Database error finding sessions").WithInternalError(err)
+	}
+
+	now := a.Now()
+	active := make([]*models.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.CheckValidity(now, nil, config.Sessions.Timebox, config.Sessions.InactivityTimeout) == models.SessionValid {
+			active = append(active, session)
+		}
+	}
+
+	return active, nil
+}
+
+// revokeOtherSessions logs the user out of their sessions other than the
+// new session, so that a login signs them out of their other devices. With
+// session tags only the sessions with the same tag are revoked.
+func (a *API) revokeOtherSessions(tx *storage.Connection, userID, sessionID uuid.UUID) error {
+	tags := a.config.Sessions.Tags
+
+	session, err := models.FindSessionByID(tx, sessionID, false)
+	if err != nil {
+		return internalServerError("Database error finding session").WithInternalError(err)
+	}
+	tag := session.DetermineTag(tags)
+
+	sessions, err := models.FindAllSessionsForUser(tx, userID, false)
+	if err != nil {
+		return internalServerError("Database error finding sessions").WithInternalError(err)
+	}
+
+	for _, s := range sessions {
+		if s.ID == sessionID || s.DetermineTag(tags) != tag {
+			continue
+		}
+		if err := models.LogoutSession(tx, s.ID); err != nil {
+			return internalServerError("Error revoking session").WithInternalError(err)
+		}
+	}
+
+	return nil
+}
+
+func newSessionResponse(session *models.Session) *SessionResponse {
+	return &SessionResponse{
+		ID:         session.ID,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastRefreshedAt(nil),
+		NotAfter:   session.NotAfter,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		AAL:        session.GetAAL(),
+		Tag:        session.Tag,
+	}
+}
+
+// UserSessions lists the active sessions of the user, marking the one the
+// request is made with.
+func (a *API) UserSessions(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	current := getSession(ctx)
+
+	sessions, err := a.activeSessions(a.db.WithContext(ctx), user.ID)
+	if err != nil {
+		return err
+	}
+
+	resp := SessionsResponse{Sessions: make([]*SessionResponse, 0, len(sessions))}
+	for _, session := range sessions {
+		sessionResp := newSessionResponse(session)
+		sessionResp.Current = current != nil && current.ID == session.ID
+		resp.Sessions = append(resp.Sessions, sessionResp)
+	}
+
+	return sendJSON(w, http.StatusOK, resp)
+}
+
+// UserRevokeSession signs the user out of one of their sessions, such as a
+// lost device. Revoking the current session is the same as a local logout.
+func (a *API) UserRevokeSession(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	config := a.config
+	user := getUser(ctx)
+	current := getSession(ctx)
+
+	session, err := findUserSession(db, user.ID, chi.URLParam(r, "session_id"))
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, user, models.SessionRevokedAction, "", map[string]interface{}{
+			"session_id": session.ID,
+		}); terr != nil {
+			return terr
+		}
+
+		return models.LogoutSession(tx, session.ID)
+	})
+	if err != nil {
+		return internalServerError("Error revoking session").WithInternalError(err)
+	}
+
+	if current != nil && current.ID == session.ID {
+		a.clearCookieTokens(config, w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// adminUserSessions lists the active sessions of a user.
+func (a *API) adminUserSessions(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+
+	sessions, err := a.activeSessions(a.db.WithContext(ctx), user.ID)
+	if err != nil {
+		return err
+	}
+
+	resp := SessionsResponse{Sessions: make([]*SessionResponse, 0, len(sessions))}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, newSessionResponse(session))
+	}
+
+	return sendJSON(w, http.StatusOK, resp)
+}
+
+// adminUserRevokeSessions logs a user out of all their sessions. Their
+// access tokens stop working right away, and can no longer be refreshed.
+func (a *API) adminUserRevokeSessions(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+
+	err := a.db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.SessionRevokedAction, "", map[string]interface{}{
+			"user_id": user.ID,
+		}); terr != nil {
+			return terr
+		}
+
+		return models.Logout(tx, user.ID)
+	})
+	if err != nil {
+		return internalServerError("Error revoking sessions").WithInternalError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// adminUserRevokeSession logs a user out of one of their sessions.
+func (a *API) adminUserRevokeSession(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+
+	session, err := findUserSession(db, user.ID, chi.URLParam(r, "session_id"))
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.SessionRevokedAction, "", map[string]interface{}{
+			"user_id":    user.ID,
+			"session_id": session.ID,
+		}); terr != nil {
+			return terr
+		}
+
+		return models.LogoutSession(tx, session.ID)
+	})
+	if err != nil {
+		return internalServerError("Error revoking session").WithInternalError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// findUserSession finds the session with the ID, which must belong to the
+// user.
+func findUserSession(db *storage.Connection, userID uuid.UUID, id string) (*models.Session, error) {
+	sessionID, err := uuid.FromString(id)
+	if err != nil {
+		return nil, notFoundError(ErrorCodeSessionNotFound, "Session not found")
+	}
+
+	session, err := models.FindSessionByID(db, sessionID, false)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, notFoundError(ErrorCodeSessionNotFound, "Session not found")
+		}
+		return nil, internalServerError("Database error finding session").WithInternalError(err)
+	}
+
+	if session.UserID != userID {
+		return nil, notFoundError(ErrorCodeSessionNotFound, "Session not found")
+	}
+
+	return session, nil
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+type SessionsTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+
+	adminToken string
+	user       *models.User
+}
+
+func TestSessions(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &SessionsTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *SessionsTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	adminToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessTokenClaims{
+		Role: "supabase_admin",
+	}).SignedString([]byte(ts.Config.JWT.Secret))
+	require.NoError(ts.T(), err)
+	ts.adminToken = adminToken
+
+	u, err := models.NewUser("", "test@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	ts.user = u
+}
+
+// signIn creates a session of the user from the user agent, returning its
+// access token.
+func (ts *SessionsTestSuite) signIn(userAgent string) (*models.Session, string) {
+	refreshToken, err := models.GrantAuthenticatedUser(ts.API.db, ts.user, models.GrantParams{
+		UserAgent: userAgent,
+	})
+	require.NoError(ts.T(), err)
+
+	session, err := models.FindSessionByID(ts.API.db, *refreshToken.SessionId, false)
+	require.NoError(ts.T(), err)
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", nil)
+	token, _, err := ts.API.generateAccessToken(req, ts.API.db, ts.user, &session.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+
+	return session, token
+}
+
+func (ts *SessionsTestSuite) request(method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *SessionsTestSuite) TestAdminRevokeSession() {
+	laptop, laptopToken := ts.signIn("laptop")
+	phone, phoneToken := ts.signIn("phone")
+
+	path := fmt.Sprintf("/admin/users/%s/sessions", ts.user.ID)
+	w := ts.request(http.MethodGet, path, ts.adminToken)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := SessionsResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(ts.T(), resp.Sessions, 2)
+	for _, session := range resp.Sessions {
+		require.Contains(ts.T(), []string{"laptop", "phone"}, *session.UserAgent)
+	}
+
+	w = ts.request(http.MethodDelete, path+"/"+laptop.ID.String(), ts.adminToken)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code, w.Body.String())
+
+	// the access token of the session stops working right away
+	require.Equal(ts.T(), http.StatusForbidden, ts.request(http.MethodGet, "/user", laptopToken).Code)
+	require.Equal(ts.T(), http.StatusOK, ts.request(http.MethodGet, "/user", phoneToken).Code)
+
+	w = ts.request(http.MethodDelete, path+"/"+laptop.ID.String(), ts.adminToken)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+
+	w = ts.request(http.MethodDelete, path, ts.adminToken)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code, w.Body.String())
+
+	_, err := models.FindSessionByID(ts.API.db, phone.ID, false)
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *SessionsTestSuite) TestAdminRevokeSessionOfOtherUser() {
+	session, _ := ts.signIn("laptop")
+
+	other, err := models.NewUser("", "other@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(other))
+
+	w := ts.request(http.MethodDelete, fmt.Sprintf("/admin/users/%s/sessions/%s", other.ID, session.ID), ts.adminToken)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+}
+
+func (ts *SessionsTestSuite) TestUserRevokeSession() {
+	laptop, laptopToken := ts.signIn("laptop")
+	phone, phoneToken := ts.signIn("phone")
+
+	w := ts.request(http.MethodGet, "/sessions", laptopToken)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := SessionsResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(ts.T(), resp.Sessions, 2)
+	for _, session := range resp.Sessions {
+		require.Equal(ts.T(), session.ID == laptop.ID, session.Current)
+	}
+
+	// sign out the lost phone from the laptop
+	w = ts.request(http.MethodDelete, "/sessions/"+phone.ID.String(), laptopToken)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code, w.Body.String())
+	require.Equal(ts.T(), http.StatusForbidden, ts.request(http.MethodGet, "/user", phoneToken).Code)
+
+	w = ts.request(http.MethodGet, "/sessions", laptopToken)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(ts.T(), resp.Sessions, 1)
+	require.True(ts.T(), resp.Sessions[0].Current)
+}
+
+func (ts *SessionsTestSuite) TestUserRevokeSessionOfOtherUser() {
+	_, token := ts.signIn("laptop")
+
+	other, err := models.NewUser("", "other@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(other))
+	refreshToken, err := models.GrantAuthenticatedUser(ts.API.db, other, models.GrantParams{})
+	require.NoError(ts.T(), err)
+
+	w := ts.request(http.MethodDelete, "/sessions/"+refreshToken.SessionId.String(), token)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+
+	_, err = models.FindSessionByID(ts.API.db, *refreshToken.SessionId, false)
+	require.NoError(ts.T(), err)
+}
+
+func (ts *SessionsTestSuite) TestSinglePerUserRevokesOtherSessions() {
+	ts.API.config.Sessions.SinglePerUser = true
+	defer func() {
+		ts.API.config.Sessions.SinglePerUser = false
+	}()
+
+	_, laptopToken := ts.signIn("laptop")
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", nil)
+	_, err := ts.API.issueRefreshToken(req, ts.API.db, ts.user, models.PasswordGrant, models.GrantParams{})
+	require.NoError(ts.T(), err)
+
+	// the older session is revoked by the new login
+	require.Equal(ts.T(), http.StatusForbidden, ts.request(http.MethodGet, "/user", laptopToken).Code)
+
+	sessions, err := models.FindUserSessions(ts.API.db, ts.user.ID)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), sessions, 1)
+}
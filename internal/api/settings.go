@@ -1,6 +1,10 @@
 package api
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/supabase/auth/internal/conf"
+)
 
 type ProviderSettings struct {
 	AnonymousUsers bool `json:"anonymous_users"`
@@ -16,13 +20,16 @@ type ProviderSettings struct {
 	Google         bool `json:"google"`
 	Keycloak       bool `json:"keycloak"`
 	Kakao          bool `json:"kakao"`
+	Line           bool `json:"line"`
 	Linkedin       bool `json:"linkedin"`
 	LinkedinOIDC   bool `json:"linkedin_oidc"`
 	Notion         bool `json:"notion"`
+	OIDC           bool `json:"oidc"`
 	Spotify        bool `json:"spotify"`
 	Slack          bool `json:"slack"`
 	SlackOIDC      bool `json:"slack_oidc"`
 	WorkOS         bool `json:"workos"`
+	X              bool `json:"x"`
 	Twitch         bool `json:"twitch"`
 	Twitter        bool `json:"twitter"`
 	Email          bool `json:"email"`
@@ -30,20 +37,46 @@ type ProviderSettings struct {
 	Zoom           bool `json:"zoom"`
 }
 
+// ExternalProviderSettings tells frontends who can sign in with an enabled
+// external provider, so that they can hide buttons that won't work.
+type ExternalProviderSettings struct {
+	AllowSignup   bool     `json:"allow_signup"`
+	EmailDomains  []string `json:"email_domains,omitempty"`
+	EmailOptional bool     `json:"email_optional"`
+}
+
+// CaptchaSettings tells frontends which captcha widget to render.
+type CaptchaSettings struct {
+	Enabled  bool   `json:"enabled"`
+	Provider string `json:"provider,omitempty"`
+	SiteKey  string `json:"site_key,omitempty"`
+}
+
+// Settings is the public view of the configuration. It must only contain
+// values that are safe to show to anyone, never secrets.
 type Settings struct {
 	ExternalProviders ProviderSettings `json:"external"`
-	DisableSignup     bool             `json:"disable_signup"`
-	MailerAutoconfirm bool             `json:"mailer_autoconfirm"`
-	PhoneAutoconfirm  bool             `json:"phone_autoconfirm"`
-	SmsProvider       string           `json:"sms_provider"`
-	MFAEnabled        bool             `json:"mfa_enabled"`
-	SAMLEnabled       bool             `json:"saml_enabled"`
+
+	// ExternalProviderSettings holds the settings of each enabled OAuth
+	// provider.
+	ExternalProviderSettings map[string]ExternalProviderSettings `json:"external_providers"`
+
+	DisableSignup     bool            `json:"disable_signup"`
+	MailerAutoconfirm bool            `json:"mailer_autoconfirm"`
+	PhoneAutoconfirm  bool            `json:"phone_autoconfirm"`
+	SmsProvider       string          `json:"sms_provider"`
+	MFAEnabled        bool            `json:"mfa_enabled"`
+	SAMLEnabled       bool            `json:"saml_enabled"`
+	WebAuthnEnabled   bool            `json:"webauthn_enabled"`
+	Captcha           CaptchaSettings `json:"captcha"`
 }
 
 func (a *API) Settings(w http.ResponseWriter, r *http.Request) error {
-	config := a.config
+	return sendJSON(w, http.StatusOK, newSettings(a.getConfig(r.Context())))
+}
 
-	return sendJSON(w, http.StatusOK, &Settings{
+func newSettings(config *conf.GlobalConfiguration) *Settings {
+	settings := &Settings{
 		ExternalProviders: ProviderSettings{
 			AnonymousUsers: config.External.AnonymousUsers.Enabled,
 			Apple:          config.External.Apple.Enabled,
@@ -58,15 +91,18 @@ func (a *API) Settings(w http.ResponseWriter, r *http.Request) error {
 			Google:         config.External.Google.Enabled,
 			Kakao:          config.External.Kakao.Enabled,
 			Keycloak:       config.External.Keycloak.Enabled,
+			Line:           config.External.Line.Enabled,
 			Linkedin:       config.External.Linkedin.Enabled,
 			LinkedinOIDC:   config.External.LinkedinOIDC.Enabled,
 			Notion:         config.External.Notion.Enabled,
+			OIDC:           config.External.OIDC.Enabled,
 			Spotify:        config.External.Spotify.Enabled,
 			Slack:          config.External.Slack.Enabled,
 			SlackOIDC:      config.External.SlackOIDC.Enabled,
 			Twitch:         config.External.Twitch.Enabled,
 			Twitter:        config.External.Twitter.Enabled,
 			WorkOS:         config.External.WorkOS.Enabled,
+			X:              config.External.X.Enabled,
 			Email:          config.External.Email.Enabled,
 			Phone:          config.External.Phone.Enabled,
 			Zoom:           config.External.Zoom.Enabled,
@@ -77,5 +113,30 @@ func (a *API) Settings(w http.ResponseWriter, r *http.Request) error {
 		SmsProvider:       config.Sms.Provider,
 		MFAEnabled:        config.MFA.Enabled,
 		SAMLEnabled:       config.SAML.Enabled,
-	})
+		WebAuthnEnabled:   config.WebAuthn.Enabled,
+	}
+
+	settings.ExternalProviderSettings = make(map[string]ExternalProviderSettings)
+	for _, name := range conf.OAuthProviderNames {
+		providerConfig := config.External.OAuthProvider(name)
+		if !providerConfig.Enabled {
+			continue
+		}
+
+		settings.ExternalProviderSettings[name] = ExternalProviderSettings{
+			AllowSignup:   providerConfig.AllowSignup && !config.DisableSignup,
+			EmailDomains:  providerConfig.EmailDomains,
+			EmailOptional: providerConfig.EmailOptional,
+		}
+	}
+
+	if config.Security.Captcha.Enabled {
+		settings.Captcha = CaptchaSettings{
+			Enabled:  true,
+			Provider: config.Security.Captcha.Provider,
+			SiteKey:  config.Security.Captcha.SiteKey,
+		}
+	}
+
+	return settings
 }
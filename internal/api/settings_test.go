@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
 )
 
 func TestSettings_DefaultProviders(t *testing.T) {
@@ -71,3 +74,80 @@ func TestSettings_EmailDisabled(t *testing.T) {
 	p := resp.ExternalProviders
 	require.False(t, p.Email)
 }
+
+func TestSettings_Captcha(t *testing.T) {
+	config, err := conf.LoadGlobal(apiTestConfig)
+	require.NoError(t, err)
+
+	require.Equal(t, CaptchaSettings{}, newSettings(config).Captcha)
+
+	config.Security.Captcha = conf.CaptchaConfiguration{
+		Enabled:  true,
+		Provider: "turnstile",
+		Secret:   "captcha-secret",
+		SiteKey:  "captcha-site-key",
+	}
+	require.Equal(t, CaptchaSettings{
+		Enabled:  true,
+		Provider: "turnstile",
+		SiteKey:  "captcha-site-key",
+	}, newSettings(config).Captcha)
+}
+
+func TestSettings_NoSecrets(t *testing.T) {
+	// no field of the public settings may be named like a secret
+	var check func(typ reflect.Type, path string)
+	check = func(typ reflect.Type, path string) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			name := strings.ToLower(field.Name + " " + field.Tag.Get("json"))
+			for _, secret := range []string{"secret", "password", "pass", "jwt", "token", "private"} {
+				require.NotContains(t, name, secret, "settings field %s%s looks like a secret", path, field.Name)
+			}
+			if field.Type.Kind() == reflect.Struct {
+				check(field.Type, path+field.Name+".")
+			}
+		}
+	}
+	check(reflect.TypeOf(Settings{}), "")
+
+	// and no secret of the configuration may end up in the response
+	config, err := conf.LoadGlobal(apiTestConfig)
+	require.NoError(t, err)
+
+	const secret = "do-not-leak-this-value"
+	config.JWT.Secret = secret
+	config.SMTP.Pass = secret
+	config.Security.Captcha.Enabled = true
+	config.Security.Captcha.Secret = secret
+	config.External.Github.Secret = secret
+	config.External.Google.Secret = secret
+	config.External.Apple.Secret = secret
+	config.Sms.Twilio.AuthToken = secret
+
+	body, err := json.Marshal(newSettings(config))
+	require.NoError(t, err)
+	require.NotContains(t, string(body), secret)
+}
+
+func TestSettings_ExternalProviderSettings(t *testing.T) {
+	config, err := conf.LoadGlobal(apiTestConfig)
+	require.NoError(t, err)
+
+	config.External.Github.AllowSignup = false
+	config.External.Github.EmailDomains = []string{"example.com"}
+	config.External.Apple.Enabled = false
+
+	settings := newSettings(config).ExternalProviderSettings
+	require.Equal(t, ExternalProviderSettings{
+		AllowSignup:  false,
+		EmailDomains: []string{"example.com"},
+	}, settings["github"])
+	require.Equal(t, ExternalProviderSettings{AllowSignup: true}, settings["gitlab"])
+	require.NotContains(t, settings, "apple")
+
+	// signups are disabled for every provider when they are disabled for
+	// the instance
+	config.DisableSignup = true
+	require.False(t, newSettings(config).ExternalProviderSettings["gitlab"].AllowSignup)
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/fatih/structs"
@@ -10,9 +11,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/supabase/auth/internal/api/provider"
 	"github.com/supabase/auth/internal/api/sms_provider"
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/metering"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	xlanguage "golang.org/x/text/language"
 )
 
 // SignupParams are the parameters the Signup endpoint accepts
@@ -26,10 +32,11 @@ type SignupParams struct {
 	Channel             string                 `json:"channel"`
 	CodeChallengeMethod string                 `json:"code_challenge_method"`
 	CodeChallenge       string                 `json:"code_challenge"`
+	Language            string                 `json:"language,omitempty"`
 }
 
 func (a *API) validateSignupParams(ctx context.Context, p *SignupParams) error {
-	config := a.config
+	config := a.getConfig(ctx)
 
 	if p.Password == "" {
 		return badRequestError(ErrorCodeValidationFailed, "Signup requires a valid password")
@@ -41,7 +48,7 @@ func (a *API) validateSignupParams(ctx context.Context, p *SignupParams) error {
 	if p.Email != "" && p.Phone != "" {
 		return badRequestError(ErrorCodeValidationFailed, "Only an email address or phone number should be provided on signup.")
 	}
-	if p.Provider == "phone" && !sms_provider.IsValidMessageChannel(p.Channel, config.Sms.Provider) {
+	if p.Provider == "phone" && !sms_provider.IsValidMessageChannel(p.Channel, &config.Sms) {
 		return badRequestError(ErrorCodeValidationFailed, InvalidChannelError)
 	}
 	// PKCE not needed as phone signups already return access token in body
@@ -71,6 +78,28 @@ func (p *SignupParams) ConfigureDefaults() {
 	}
 }
 
+// setLanguage stores the preferred language of the user in their metadata
+// so that emails and SMS messages can be localized. An explicit language in
+// the metadata wins over the language param, which wins over the
+// Accept-Language header.
+func (p *SignupParams) setLanguage(r *http.Request) {
+	if language, ok := p.Data["language"].(string); ok && language != "" {
+		return
+	}
+
+	language := strings.TrimSpace(p.Language)
+	if language == "" {
+		tags, _, err := xlanguage.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+		if err == nil && len(tags) > 0 && tags[0] != xlanguage.Und {
+			language = tags[0].String()
+		}
+	}
+
+	if language != "" {
+		p.Data["language"] = language
+	}
+}
+
 func (params *SignupParams) ToUserModel(isSSOUser bool) (user *models.User, err error) {
 	switch params.Provider {
 	case "email":
@@ -108,19 +137,24 @@ func (params *SignupParams) ToUserModel(isSSOUser bool) (user *models.User, err
 // Signup is the endpoint for registering a new user
 func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	config := a.config
+	config := a.getConfig(ctx)
 	db := a.db.WithContext(ctx)
 
 	if config.DisableSignup {
 		return unprocessableEntityError(ErrorCodeSignupDisabled, "Signups not allowed for this instance")
 	}
 
+	if err := a.requireFeature(ctx, conf.FeatureSignup); err != nil {
+		return err
+	}
+
 	params := &SignupParams{}
 	if err := retrieveRequestParams(r, params); err != nil {
 		return err
 	}
 
 	params.ConfigureDefaults()
+	params.setLanguage(r)
 
 	if err := a.validateSignupParams(ctx, params); err != nil {
 		return err
@@ -145,6 +179,9 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err
 		}
+		if err := a.checkEmailDomain(params.Email); err != nil {
+			return err
+		}
 		user, err = models.IsDuplicatedEmail(db, params.Email, params.Aud, nil)
 	case "phone":
 		if !config.External.Phone.Enabled {
@@ -192,7 +229,7 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 			}
 			// do not update the user because we can't be sure of their claimed identity
 		} else {
-			user, terr = a.signupNewUser(tx, signupUser)
+			user, terr = a.signupNewUser(r, tx, signupUser)
 			if terr != nil {
 				return terr
 			}
@@ -243,11 +280,11 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 						return terr
 					}
 				}
-				if terr = a.sendConfirmation(r, tx, user, flowType); terr != nil {
+				if terr = a.handleEmailSendLimit(r, "signup", a.sendConfirmation(r, tx, user, flowType)); terr != nil {
 					if errors.Is(terr, MaxFrequencyLimitError) {
 						return tooManyRequestsError(ErrorCodeOverEmailSendRateLimit, generateFrequencyLimitErrorMessage(user.ConfirmationSentAt, config.SMTP.MaxFrequency))
 					}
-					return internalServerError("Error sending confirmation mail").WithInternalError(terr)
+					return mailerError("Error sending confirmation mail", terr)
 				}
 			}
 		} else if params.Provider == "phone" && !user.IsPhoneConfirmed() {
@@ -272,6 +309,9 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 					return internalServerError("Unable to get SMS provider").WithInternalError(terr)
 				}
 				if _, terr := a.sendPhoneConfirmation(r, tx, user, params.Phone, phoneConfirmationOtp, smsProvider, params.Channel); terr != nil {
+					if isFeatureDisabledError(terr) {
+						return terr
+					}
 					return unprocessableEntityError(ErrorCodeSMSSendFailed, "Error sending confirmation sms: %v", terr).WithInternalError(terr)
 				}
 			}
@@ -322,6 +362,7 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 			}); terr != nil {
 				return terr
 			}
+			a.notifyWebhook(r, tx, conf.WebhookLoginEvent, user)
 			token, terr = a.issueRefreshToken(r, tx, user, models.PasswordGrant, grantParams)
 
 			if terr != nil {
@@ -380,8 +421,14 @@ func sanitizeUser(u *models.User, params *SignupParams) (*models.User, error) {
 	return u, nil
 }
 
-func (a *API) signupNewUser(conn *storage.Connection, user *models.User) (*models.User, error) {
-	config := a.config
+var signupsCounter = observability.ObtainMetricCounter("gotrue_signups", "Number of users signed up by provider")
+
+func (a *API) signupNewUser(r *http.Request, conn *storage.Connection, user *models.User) (*models.User, error) {
+	config := a.getConfig(r.Context())
+
+	if err := a.validateSignupWebhook(r, user); err != nil {
+		return nil, err
+	}
 
 	err := conn.Transaction(func(tx *storage.Connection) error {
 		var terr error
@@ -404,5 +451,19 @@ func (a *API) signupNewUser(conn *storage.Connection, user *models.User) (*model
 		return nil, internalServerError("Database error loading user after sign-up").WithInternalError(err)
 	}
 
+	signupsCounter.Add(conn.Context(), 1, metric.WithAttributes(attribute.String("provider", signupProvider(user))))
+	a.notifyWebhook(r, conn, conf.WebhookSignupEvent, user)
+
 	return user, nil
 }
+
+// signupProvider returns the provider a new user signed up with, for metrics.
+func signupProvider(user *models.User) string {
+	if user.IsAnonymous {
+		return "anonymous"
+	}
+	if provider, ok := user.AppMetaData["provider"].(string); ok && provider != "" {
+		return provider
+	}
+	return "unknown"
+}
@@ -74,6 +74,180 @@ func (ts *SignupTestSuite) TestSignup() {
 	assert.Equal(ts.T(), []interface{}{"email"}, data.AppMetaData["providers"])
 }
 
+func (ts *SignupTestSuite) TestSignupDisabled() {
+	ts.Config.DisableSignup = true
+	defer func() {
+		ts.Config.DisableSignup = false
+	}()
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "test123",
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/signup", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeSignupDisabled, data.ErrorCode)
+}
+
+func (ts *SignupTestSuite) TestSignupLanguage() {
+	cases := []struct {
+		desc           string
+		body           map[string]interface{}
+		acceptLanguage string
+		expected       interface{}
+	}{
+		{
+			desc:     "Language param",
+			body:     map[string]interface{}{"language": "pt-BR"},
+			expected: "pt-BR",
+		},
+		{
+			desc:           "Accept-Language header",
+			acceptLanguage: "de-AT;q=0.8, fr-CH, en;q=0.5",
+			expected:       "fr-CH",
+		},
+		{
+			desc:           "Language param takes precedence over header",
+			body:           map[string]interface{}{"language": "es"},
+			acceptLanguage: "de",
+			expected:       "es",
+		},
+		{
+			desc: "Language in metadata takes precedence",
+			body: map[string]interface{}{
+				"language": "es",
+				"data":     map[string]interface{}{"language": "it"},
+			},
+			acceptLanguage: "de",
+			expected:       "it",
+		},
+		{
+			desc:     "No language",
+			expected: nil,
+		},
+	}
+
+	for i, c := range cases {
+		ts.Run(c.desc, func() {
+			body := map[string]interface{}{
+				"email":    fmt.Sprintf("language%d@example.com", i),
+				"password": "test123",
+			}
+			for k, v := range c.body {
+				body[k] = v
+			}
+
+			var buffer bytes.Buffer
+			require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+			req := httptest.NewRequest(http.MethodPost, "/signup", &buffer)
+			req.Header.Set("Content-Type", "application/json")
+			if c.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", c.acceptLanguage)
+			}
+
+			w := httptest.NewRecorder()
+			ts.API.handler.ServeHTTP(w, req)
+			require.Equal(ts.T(), http.StatusOK, w.Code)
+
+			data := models.User{}
+			require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+			require.Equal(ts.T(), c.expected, data.UserMetaData["language"])
+		})
+	}
+}
+
+// TestSignupEmailDomainRestrictions tests the email domain allow and block
+// lists on /signup
+func (ts *SignupTestSuite) TestSignupEmailDomainRestrictions() {
+	defer func() {
+		ts.Config.Signup = conf.SignupConfiguration{}
+	}()
+
+	cases := []struct {
+		desc      string
+		allowList []string
+		blockList []string
+		email     string
+		code      int
+	}{
+		{
+			desc:      "allow list only, allowed",
+			allowList: []string{"ourcompany.com"},
+			email:     "user+signup@OurCompany.com",
+			code:      http.StatusOK,
+		},
+		{
+			desc:      "allow list only, rejected",
+			allowList: []string{"ourcompany.com"},
+			email:     "user@example.com",
+			code:      http.StatusUnprocessableEntity,
+		},
+		{
+			desc:      "block list only, allowed",
+			blockList: []string{"mailinator.com"},
+			email:     "user@example.com",
+			code:      http.StatusOK,
+		},
+		{
+			desc:      "block list only, rejected",
+			blockList: []string{"mailinator.com"},
+			email:     "user@mailinator.com",
+			code:      http.StatusUnprocessableEntity,
+		},
+		{
+			desc:      "both lists, allowed subdomain",
+			allowList: []string{"*.corp.example.com"},
+			blockList: []string{"guest.corp.example.com"},
+			email:     "user@eu.corp.example.com",
+			code:      http.StatusOK,
+		},
+		{
+			desc:      "both lists, blocked subdomain",
+			allowList: []string{"*.corp.example.com"},
+			blockList: []string{"guest.corp.example.com"},
+			email:     "user@guest.corp.example.com",
+			code:      http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.desc, func() {
+			ts.Config.Signup = conf.SignupConfiguration{
+				EmailDomainAllowList: c.allowList,
+				EmailDomainBlockList: c.blockList,
+			}
+
+			var buffer bytes.Buffer
+			require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+				"email":    c.email,
+				"password": "test123",
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/signup", &buffer)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			ts.API.handler.ServeHTTP(w, req)
+			require.Equal(ts.T(), c.code, w.Code, w.Body.String())
+
+			if c.code != http.StatusOK {
+				data := &HTTPError{}
+				require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+				require.Equal(ts.T(), ErrorCodeEmailDomainNotAllowed, data.ErrorCode)
+			}
+		})
+	}
+}
+
 // TestSignupTwice checks to make sure the same email cannot be registered twice
 func (ts *SignupTestSuite) TestSignupTwice() {
 	// Request body
@@ -0,0 +1,55 @@
+package sms_provider
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// FailoverProvider sends with each of its providers in turn until one of
+// them succeeds, so that the outage of a provider doesn't stop all SMS. Only
+// the providers which can't be reached or fail with a server error are
+// failed over, as the others would reject the message too.
+type FailoverProvider struct {
+	Names     []string
+	Providers []SmsProvider
+}
+
+func (t *FailoverProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	messageID, _, err := t.Send(phone, message, channel, otp, nil)
+	return messageID, err
+}
+
+func (t *FailoverProvider) Send(phone, message, channel, otp string, onError func(provider string, err error)) (string, string, error) {
+	var errs []error
+	for i, provider := range t.Providers {
+		messageID, err := provider.SendMessage(phone, message, channel, otp)
+		if err == nil {
+			return messageID, t.Names[i], nil
+		}
+
+		if onError != nil {
+			onError(t.Names[i], err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.Names[i], err))
+		if !shouldFailover(err) {
+			break
+		}
+	}
+
+	return "", "", errors.Join(errs...)
+}
+
+// shouldFailover reports whether the error of a provider is a network error
+// or a server error of the provider.
+func shouldFailover(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
@@ -40,7 +40,10 @@ type MessagebirdErrResponse struct {
 }
 
 func (t MessagebirdErrResponse) Error() string {
-	return t.Errors[0].Description
+	if len(t.Errors) == 0 {
+		return "messagebird error: unknown error"
+	}
+	return fmt.Sprintf("messagebird error: %s (code: %d)", t.Errors[0].Description, t.Errors[0].Code)
 }
 
 // Creates a SmsProvider with the Messagebird Config
@@ -86,15 +89,15 @@ func (t *MessagebirdProvider) SendSms(phone string, message string) (string, err
 	if err != nil {
 		return "", err
 	}
+	defer utilities.SafeClose(res.Body)
 
-	if res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusUnprocessableEntity {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
 		resp := &MessagebirdErrResponse{}
-		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
-			return "", err
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil || len(resp.Errors) == 0 {
+			return "", withStatusCode(res.StatusCode, fmt.Errorf("messagebird error: unexpected status code %d", res.StatusCode))
 		}
-		return "", resp
+		return "", withStatusCode(res.StatusCode, resp)
 	}
-	defer utilities.SafeClose(res.Body)
 
 	// validate sms status
 	resp := &MessagebirdResponse{}
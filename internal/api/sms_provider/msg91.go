@@ -1,25 +1,24 @@
 package sms_provider
 
 import (
-
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 
-
-	"strings"  // Add this import for the "strings" package
-	"io" 
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/utilities"
 )
 
 const (
-	defaultMsg91ApiBase = "https://control.msg91.com/api/v5/flow"
+	defaultMsg91ApiBase         = "https://control.msg91.com/api/v5/flow"
+	defaultMsg91WhatsappApiBase = "https://api.msg91.com/api/v5/whatsapp/whatsapp-outbound-message/bulk/"
 )
 
 type Msg91Provider struct {
-	Config  *conf.Msg91ProviderConfiguration
-	APIPath string
+	Config          *conf.Msg91ProviderConfiguration
+	APIPath         string
+	WhatsappAPIPath string
 }
 
 type Msg91Response struct {
@@ -27,6 +26,14 @@ type Msg91Response struct {
 	Type    string `json:"type"`
 }
 
+// See: https://docs.msg91.com/whatsapp/send-template
+type Msg91WhatsappResponse struct {
+	Status   string          `json:"status"`
+	HasError bool            `json:"hasError"`
+	Data     json.RawMessage `json:"data"`
+	Errors   json.RawMessage `json:"errors"`
+}
+
 // NewMsg91Provider creates a new SmsProvider for Msg91.
 func NewMsg91Provider(config conf.Msg91ProviderConfiguration) (SmsProvider, error) {
 	if err := config.Validate(); err != nil {
@@ -34,8 +41,9 @@ func NewMsg91Provider(config conf.Msg91ProviderConfiguration) (SmsProvider, erro
 	}
 
 	return &Msg91Provider{
-		Config:  &config,
-		APIPath: defaultMsg91ApiBase,
+		Config:          &config,
+		APIPath:         defaultMsg91ApiBase,
+		WhatsappAPIPath: defaultMsg91WhatsappApiBase,
 	}, nil
 }
 
@@ -43,53 +51,115 @@ func NewMsg91Provider(config conf.Msg91ProviderConfiguration) (SmsProvider, erro
 func (t *Msg91Provider) SendMessage(phone, message, channel, otp string) (string, error) {
 	switch channel {
 	case SMSProvider:
-		return t.SendSms(phone, message,otp)
+		return t.SendSms(phone, message, otp)
+	case WhatsappProvider:
+		return t.SendWhatsapp(phone, otp)
 	default:
 		return "", fmt.Errorf("msg91: channel type %q is not supported", channel)
 	}
 }
 
+// SendSms sends the OTP with the Msg91 flow of the template, which contains
+// the message.
 func (t *Msg91Provider) SendSms(phone, message, otp string) (string, error) {
-  
+	payload, err := json.Marshal(map[string]interface{}{
+		"template_id": t.Config.TemplateId,
+		"recipients": []map[string]string{
+			{"mobiles": phone, "otp": otp},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
 
-	payload := strings.NewReader(fmt.Sprintf("{\"template_id\":\"%s\",\"recipients\":[{\"mobiles\":\"%s\",\"otp\":\"%s\"}]}", t.Config.TemplateId, phone, otp))
+	var resp Msg91Response
+	status, err := t.post(t.APIPath, payload, &resp)
+	if err != nil {
+		return "", err
+	}
 
+	if resp.Type != "success" {
+		return resp.Message, withStatusCode(status, fmt.Errorf("msg91 error: expected \"success\" but got %q with message %q (code: %v)", resp.Type, resp.Message, status))
+	}
 
+	return resp.Message, nil
+}
 
-	client := &http.Client{Timeout: defaultTimeout}
+// SendWhatsapp sends the OTP with the approved WhatsApp authentication
+// template, whose body and copy code button take the OTP.
+func (t *Msg91Provider) SendWhatsapp(phone, otp string) (string, error) {
+	if t.Config.WhatsappTemplateName == "" {
+		return "", fmt.Errorf("msg91: channel type %q is not configured", WhatsappProvider)
+	}
 
-    req, err := http.NewRequest("POST", t.APIPath, payload)
-    if err != nil {
-        return "", fmt.Errorf("msg91 error: unable to create request %w", err)
-    }
+	payload, err := json.Marshal(map[string]interface{}{
+		"integrated_number": t.Config.WhatsappNumber,
+		"content_type":      "template",
+		"payload": map[string]interface{}{
+			"messaging_product": "whatsapp",
+			"type":              "template",
+			"template": map[string]interface{}{
+				"name": t.Config.WhatsappTemplateName,
+				"language": map[string]string{
+					"code":   t.Config.WhatsappTemplateLanguage,
+					"policy": "deterministic",
+				},
+				"to_and_components": []map[string]interface{}{
+					{
+						"to": []string{phone},
+						"components": map[string]interface{}{
+							"body_1": map[string]string{
+								"type":  "text",
+								"value": otp,
+							},
+							"button_1": map[string]string{
+								"subtype": "url",
+								"type":    "text",
+								"value":   otp,
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
 
+	var resp Msg91WhatsappResponse
+	status, err := t.post(t.WhatsappAPIPath, payload, &resp)
+	if err != nil {
+		return "", err
+	}
 
-	req.Header.Add("accept", "application/json")
-    req.Header.Add("content-type", "application/json")
-    req.Header.Add("authkey", t.Config.AuthKey)
+	if resp.Status != "success" || resp.HasError {
+		return "", withStatusCode(status, fmt.Errorf("msg91 error: expected \"success\" but got %q with errors %s (code: %v)", resp.Status, resp.Errors, status))
+	}
 
-    res, err := client.Do(req)
-    if err != nil {
-        return "", fmt.Errorf("msg91 error: failed to execute request %w", err)
-    }
-    defer utilities.SafeClose(res.Body)
+	return string(resp.Data), nil
+}
 
-    body, err := io.ReadAll(res.Body)
-    if err != nil {
-        return "", fmt.Errorf("msg91 error: failed to read response body: %w", err)
-    }
+func (t *Msg91Provider) post(path string, payload []byte, resp interface{}) (int, error) {
+	client := &http.Client{Timeout: defaultTimeout}
 
-    fmt.Println(string(body)) // Assuming you want to print the response body
+	req, err := http.NewRequest("POST", path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("msg91 error: unable to create request %w", err)
+	}
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("content-type", "application/json")
+	req.Header.Add("authkey", t.Config.AuthKey)
 
-    var resp Msg91Response
-    if err := json.Unmarshal(body, &resp); err != nil {
-        return "", fmt.Errorf("msg91 error: failed to unmarshal JSON response body (status code %v): %w", res.StatusCode, err)
-    }
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("msg91 error: failed to execute request %w", err)
+	}
+	defer utilities.SafeClose(res.Body)
 
-    if resp.Type != "success" {
-        return resp.Message, fmt.Errorf("msg91 error: expected \"success\" but got %q with message %q (code: %v)", resp.Type, resp.Message, res.StatusCode)
-    }
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return res.StatusCode, withStatusCode(res.StatusCode, fmt.Errorf("msg91 error: failed to unmarshal JSON response body (status code %v): %w", res.StatusCode, err))
+	}
 
-    return resp.Message, nil
+	return res.StatusCode, nil
 }
-
@@ -0,0 +1,59 @@
+package sms_provider
+
+import (
+	"errors"
+	"strings"
+)
+
+// SmsRoute sends the SMS to the numbers starting with Prefix, a country
+// calling code or a longer prefix, with Provider.
+type SmsRoute struct {
+	Prefix   string
+	Name     string
+	Provider SmsProvider
+}
+
+// RoutingProvider sends each SMS with the provider of the route matching its
+// number, and the others with the default provider.
+type RoutingProvider struct {
+	Routes      []SmsRoute
+	Default     SmsProvider
+	DefaultName string
+}
+
+func (t *RoutingProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	messageID, _, err := t.Send(phone, message, channel, otp, nil)
+	return messageID, err
+}
+
+func (t *RoutingProvider) Send(phone, message, channel, otp string, onError func(provider string, err error)) (string, string, error) {
+	provider, name := t.route(phone)
+	if provider == nil {
+		return "", "", errors.New("no sms provider for the phone number")
+	}
+
+	if sender, ok := provider.(Sender); ok {
+		return sender.Send(phone, message, channel, otp, onError)
+	}
+
+	messageID, err := provider.SendMessage(phone, message, channel, otp)
+	if err != nil {
+		if onError != nil {
+			onError(name, err)
+		}
+		return "", "", err
+	}
+
+	return messageID, name, nil
+}
+
+// route returns the provider of a number, which is formatted without a +.
+func (t *RoutingProvider) route(phone string) (SmsProvider, string) {
+	for _, route := range t.Routes {
+		if strings.HasPrefix(phone, route.Prefix) {
+			return route.Provider, route.Name
+		}
+	}
+
+	return t.Default, t.DefaultName
+}
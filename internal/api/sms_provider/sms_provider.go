@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/supabase/auth/internal/conf"
@@ -29,8 +31,103 @@ type SmsProvider interface {
 	SendMessage(phone, message, channel, otp string) (string, error)
 }
 
+// StatusError is returned by the providers when their API responds with an
+// unsuccessful status code.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// withStatusCode adds the status code of a response to its error, unless the
+// status code is successful.
+func withStatusCode(statusCode int, err error) error {
+	if err == nil || (statusCode >= 200 && statusCode <= 299) {
+		return err
+	}
+	return &StatusError{StatusCode: statusCode, Err: err}
+}
+
+// Sender is implemented by the providers that send with one of several
+// providers. Send returns the message ID and the name of the provider which
+// sent the message, and calls onError, which may be nil, with each provider
+// that fails.
+type Sender interface {
+	Send(phone, message, channel, otp string, onError func(provider string, err error)) (string, string, error)
+}
+
+// GetSmsProvider returns the configured provider, which falls back to the
+// fallback providers when there are any, and routes SMS to the providers of
+// their country when there are any.
+//
+// There is no provider when none is configured and SMS are sent by the Send
+// SMS hook.
 func GetSmsProvider(config conf.GlobalConfiguration) (SmsProvider, error) {
-	switch name := config.Sms.Provider; name {
+	if config.Sms.Provider == "" && config.Hook.SendSMS.Enabled {
+		return nil, nil
+	}
+
+	provider, err := getDefaultSmsProvider(config)
+	if err != nil || len(config.Sms.CountryProviders) == 0 {
+		return provider, err
+	}
+
+	router := &RoutingProvider{
+		Default:     provider,
+		DefaultName: config.Sms.Provider,
+	}
+	for prefix, name := range config.Sms.CountryProviders {
+		routed, err := newSmsProvider(name, config)
+		if err != nil {
+			return nil, err
+		}
+		router.Routes = append(router.Routes, SmsRoute{
+			Prefix:   strings.TrimPrefix(strings.TrimSpace(prefix), "+"),
+			Name:     name,
+			Provider: routed,
+		})
+	}
+
+	// the longest prefix matching a number wins
+	sort.Slice(router.Routes, func(i, j int) bool {
+		if len(router.Routes[i].Prefix) != len(router.Routes[j].Prefix) {
+			return len(router.Routes[i].Prefix) > len(router.Routes[j].Prefix)
+		}
+		return router.Routes[i].Prefix < router.Routes[j].Prefix
+	})
+
+	return router, nil
+}
+
+// getDefaultSmsProvider returns the provider of the numbers which aren't
+// routed to the provider of their country.
+func getDefaultSmsProvider(config conf.GlobalConfiguration) (SmsProvider, error) {
+	if len(config.Sms.FallbackProviders) == 0 {
+		return newSmsProvider(config.Sms.Provider, config)
+	}
+
+	failover := &FailoverProvider{}
+	for _, name := range append([]string{config.Sms.Provider}, config.Sms.FallbackProviders...) {
+		provider, err := newSmsProvider(name, config)
+		if err != nil {
+			return nil, err
+		}
+		failover.Names = append(failover.Names, name)
+		failover.Providers = append(failover.Providers, provider)
+	}
+
+	return failover, nil
+}
+
+func newSmsProvider(name string, config conf.GlobalConfiguration) (SmsProvider, error) {
+	switch name {
 	case "twilio":
 		return NewTwilioProvider(config.Sms.Twilio)
 	case "messagebird":
@@ -43,17 +140,26 @@ func GetSmsProvider(config conf.GlobalConfiguration) (SmsProvider, error) {
 		return NewTwilioVerifyProvider(config.Sms.TwilioVerify)
 	case "msg91":
 		return NewMsg91Provider(config.Sms.Msg91)
+	case "sns":
+		return NewSnsProvider(config.Sms.Sns)
 	default:
 		return nil, fmt.Errorf("sms Provider %s could not be found", name)
 	}
 }
 
-func IsValidMessageChannel(channel string, smsProvider string) bool {
+func IsValidMessageChannel(channel string, config *conf.SmsProviderConfiguration) bool {
 	switch channel {
 	case SMSProvider:
 		return true
 	case WhatsappProvider:
-		return smsProvider == "twilio" || smsProvider == "twilio_verify"
+		switch config.Provider {
+		case "twilio", "twilio_verify":
+			return true
+		case "msg91":
+			return config.Msg91.WhatsappTemplateName != ""
+		default:
+			return false
+		}
 	default:
 		return false
 	}
@@ -2,11 +2,14 @@ package sms_provider
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -57,10 +60,19 @@ func TestSmsProvider(t *testing.T) {
 					ApiKey: "test_api_key",
 					Sender: "test_sender",
 				},
+				Sns: conf.SnsProviderConfiguration{
+					AccessKeyID:     "test_access_key_id",
+					SecretAccessKey: "test_secret_access_key",
+					Region:          "us-east-1",
+					SenderID:        "test_sender",
+					SMSType:         "Transactional",
+				},
 				Msg91: conf.Msg91ProviderConfiguration{
-					AuthKey:  "test_auth_key",
-					SenderId: "test_sender_id",
-					// DltTemplateId: "test_dlt_template_id",
+					AuthKey:                  "test_auth_key",
+					TemplateId:               "test_template_id",
+					WhatsappNumber:           "919999999999",
+					WhatsappTemplateName:     "test_whatsapp_template",
+					WhatsappTemplateLanguage: "en",
 				},
 			},
 		},
@@ -132,11 +144,14 @@ func (ts *SmsProviderTestSuite) TestTwilioSendSms() {
 				Status:   500,
 			}),
 			OTP: "123456",
-			ExpectedError: &twilioErrResponse{
-				Code:     500,
-				Message:  "Internal server error",
-				MoreInfo: "error",
-				Status:   500,
+			ExpectedError: &StatusError{
+				StatusCode: 500,
+				Err: &twilioErrResponse{
+					Code:     500,
+					Message:  "Internal server error",
+					MoreInfo: "error",
+					Status:   500,
+				},
 			},
 		},
 	}
@@ -205,6 +220,167 @@ func (ts *SmsProviderTestSuite) TestVonageSendSms() {
 	require.NoError(ts.T(), err)
 }
 
+func (ts *SmsProviderTestSuite) TestMessagebirdSendSmsError() {
+	defer gock.Off()
+	provider, err := NewMessagebirdProvider(ts.Config.Sms.Messagebird)
+	require.NoError(ts.T(), err)
+
+	messagebirdProvider, ok := provider.(*MessagebirdProvider)
+	require.Equal(ts.T(), true, ok)
+
+	gock.New(messagebirdProvider.APIPath).Post("").Reply(422).JSON(MessagebirdErrResponse{
+		Errors: []MessagebirdError{
+			{Code: 21, Description: "Bad request", Parameter: "recipients"},
+		},
+	})
+
+	_, err = messagebirdProvider.SendSms("123456789", "This is the sms code: 123456")
+	require.Equal(ts.T(), &StatusError{
+		StatusCode: 422,
+		Err: &MessagebirdErrResponse{
+			Errors: []MessagebirdError{
+				{Code: 21, Description: "Bad request", Parameter: "recipients"},
+			},
+		},
+	}, err)
+
+	gock.New(messagebirdProvider.APIPath).Post("").Reply(503).BodyString("Service Unavailable")
+
+	_, err = messagebirdProvider.SendSms("123456789", "This is the sms code: 123456")
+	require.EqualError(ts.T(), err, "messagebird error: unexpected status code 503")
+}
+
+func (ts *SmsProviderTestSuite) TestVonageSendSmsError() {
+	defer gock.Off()
+	provider, err := NewVonageProvider(ts.Config.Sms.Vonage)
+	require.NoError(ts.T(), err)
+
+	vonageProvider, ok := provider.(*VonageProvider)
+	require.Equal(ts.T(), true, ok)
+
+	gock.New(vonageProvider.APIPath).Post("").Reply(200).JSON(VonageResponse{
+		Messages: []VonageResponseMessage{
+			{Status: "4", ErrorText: "Bad Credentials", MessageID: "id"},
+		},
+	})
+
+	messageID, err := vonageProvider.SendSms("123456789", "This is the sms code: 123456")
+	require.Equal(ts.T(), "id", messageID)
+	require.Equal(ts.T(), &VonageErrResponse{VonageResponseMessage{Status: "4", ErrorText: "Bad Credentials", MessageID: "id"}}, err)
+}
+
+func (ts *SmsProviderTestSuite) TestVonageSendSignedSms() {
+	defer gock.Off()
+	config := ts.Config.Sms.Vonage
+	config.ApiSecret = ""
+	config.SignatureSecret = "test_signature_secret"
+
+	provider, err := NewVonageProvider(config)
+	require.NoError(ts.T(), err)
+
+	vonageProvider, ok := provider.(*VonageProvider)
+	require.Equal(ts.T(), true, ok)
+
+	gock.New(vonageProvider.APIPath).Post("").AddMatcher(func(r *http.Request, _ *gock.Request) (bool, error) {
+		if err := r.ParseForm(); err != nil {
+			return false, err
+		}
+		if r.PostForm.Has("api_secret") {
+			return false, nil
+		}
+
+		signed := url.Values{}
+		for key, values := range r.PostForm {
+			signed[key] = values
+		}
+		timestamp, err := strconv.ParseInt(signed.Get("timestamp"), 10, 64)
+		if err != nil {
+			return false, err
+		}
+		signVonageRequest(signed, config.SignatureSecret, time.Unix(timestamp, 0))
+		return signed.Get("sig") == r.PostForm.Get("sig"), nil
+	}).Reply(200).JSON(VonageResponse{
+		Messages: []VonageResponseMessage{
+			{Status: "0"},
+		},
+	})
+
+	_, err = vonageProvider.SendSms("123456789", "This is the sms code: 123456")
+	require.NoError(ts.T(), err)
+}
+
+func TestSignVonageRequest(t *testing.T) {
+	body := url.Values{
+		"api_key": {"key"},
+		"to":      {"123456789"},
+		"text":    {"a=b&c"},
+	}
+	signVonageRequest(body, "secret", time.Unix(1700000000, 0))
+
+	require.Equal(t, "1700000000", body.Get("timestamp"))
+	// md5("&api_key=key&text=a_b_c&timestamp=1700000000&to=123456789secret")
+	require.Equal(t, "72d6d136e78eafb696d879a02929927e", body.Get("sig"))
+}
+
+func (ts *SmsProviderTestSuite) TestSnsSendSms() {
+	defer gock.Off()
+	provider, err := NewSnsProvider(ts.Config.Sms.Sns)
+	require.NoError(ts.T(), err)
+
+	snsProvider, ok := provider.(*SnsProvider)
+	require.Equal(ts.T(), true, ok)
+
+	phone := "123456789"
+	message := "This is the sms code: 123456"
+	body := url.Values{
+		"Action":                         {"Publish"},
+		"Version":                        {"2010-03-31"},
+		"PhoneNumber":                    {"+" + phone},
+		"Message":                        {message},
+		"MessageAttributes.entry.1.Name": {"AWS.SNS.SMS.SMSType"},
+		"MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"MessageAttributes.entry.1.Value.StringValue": {"Transactional"},
+		"MessageAttributes.entry.2.Name":              {"AWS.SNS.SMS.SenderID"},
+		"MessageAttributes.entry.2.Value.DataType":    {"String"},
+		"MessageAttributes.entry.2.Value.StringValue": {"test_sender"},
+	}
+
+	gock.New(snsProvider.APIPath).Post("").
+		MatchHeader("Authorization", "^AWS4-HMAC-SHA256 Credential=test_access_key_id/[0-9]{8}/us-east-1/sns/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=[0-9a-f]{64}$").
+		BodyString(body.Encode()).
+		Reply(200).BodyString(`<PublishResponse xmlns="https://sns.amazonaws.com/doc/2010-03-31/"><PublishResult><MessageId>94f20ce6-13c5-43a0-9a9e-ca52d816e90b</MessageId></PublishResult></PublishResponse>`)
+
+	messageID, err := snsProvider.SendSms(phone, message)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "94f20ce6-13c5-43a0-9a9e-ca52d816e90b", messageID)
+
+	gock.New(snsProvider.APIPath).Post("").
+		Reply(403).BodyString(`<ErrorResponse><Error><Type>Sender</Type><Code>InvalidClientTokenId</Code><Message>The security token included in the request is invalid.</Message></Error></ErrorResponse>`)
+
+	_, err = snsProvider.SendSms(phone, message)
+	require.Equal(ts.T(), &StatusError{
+		StatusCode: 403,
+		Err: &SnsErrResponse{
+			Type:    "Sender",
+			Code:    "InvalidClientTokenId",
+			Message: "The security token included in the request is invalid.",
+		},
+	}, err)
+}
+
+// Example from the Signature Version 4 test suite of AWS.
+func TestSignAWSRequest(t *testing.T) {
+	payload := "Param1=value1"
+	r, err := http.NewRequest("POST", "https://example.amazonaws.com/", strings.NewReader(payload))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	signAWSRequest(r, []byte(payload), "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	require.Equal(t, "20150830T123600Z", r.Header.Get("X-Amz-Date"))
+	require.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a", r.Header.Get("Authorization"))
+}
+
 func (ts *SmsProviderTestSuite) TestTextLocalSendSms() {
 	defer gock.Off()
 	provider, err := NewTextlocalProvider(ts.Config.Sms.Textlocal)
@@ -276,11 +452,14 @@ func (ts *SmsProviderTestSuite) TestTwilioVerifySendSms() {
 				MoreInfo: "error",
 				Status:   500,
 			}),
-			ExpectedError: &twilioErrResponse{
-				Code:     500,
-				Message:  "Internal server error",
-				MoreInfo: "error",
-				Status:   500,
+			ExpectedError: &StatusError{
+				StatusCode: 500,
+				Err: &twilioErrResponse{
+					Code:     500,
+					Message:  "Internal server error",
+					MoreInfo: "error",
+					Status:   500,
+				},
 			},
 		},
 	}
@@ -293,33 +472,336 @@ func (ts *SmsProviderTestSuite) TestTwilioVerifySendSms() {
 	}
 }
 
+func (ts *SmsProviderTestSuite) TestTwilioVerifyVerifyOTP() {
+	defer gock.Off()
+	provider, err := NewTwilioVerifyProvider(ts.Config.Sms.TwilioVerify)
+	require.NoError(ts.T(), err)
+
+	twilioVerifyProvider, ok := provider.(*TwilioVerifyProvider)
+	require.Equal(ts.T(), true, ok)
+
+	phone := "123456789"
+	checkPath := verifyServiceApiBase + twilioVerifyProvider.Config.MessageServiceSid + "/VerificationCheck"
+
+	cases := []struct {
+		Desc          string
+		Code          string
+		Response      VerificationCheckResponse
+		ExpectedError bool
+	}{
+		{
+			Desc:     "Approved code",
+			Code:     "123456",
+			Response: VerificationCheckResponse{To: "+" + phone, Status: "approved", Valid: true},
+		},
+		{
+			Desc:          "Wrong code",
+			Code:          "654321",
+			Response:      VerificationCheckResponse{To: "+" + phone, Status: "pending", Valid: false},
+			ExpectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		ts.Run(c.Desc, func() {
+			body := url.Values{
+				"To":   {"+" + phone},
+				"Code": {c.Code},
+			}
+			gock.New(checkPath).Post("").
+				MatchHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(twilioVerifyProvider.Config.AccountSid+":"+twilioVerifyProvider.Config.AuthToken))).
+				MatchType("url").BodyString(body.Encode()).
+				Reply(200).JSON(c.Response)
+
+			err := twilioVerifyProvider.VerifyOTP(phone, c.Code)
+			if c.ExpectedError {
+				require.Error(ts.T(), err)
+			} else {
+				require.NoError(ts.T(), err)
+			}
+		})
+	}
+}
+
 func (ts *SmsProviderTestSuite) TestMsg91SendSms() {
 	defer gock.Off()
 
 	provider, err := NewMsg91Provider(ts.Config.Sms.Msg91)
 	require.NoError(ts.T(), err)
 
-	msg91Provider, _ := provider.(*Msg91Provider)
+	msg91Provider, ok := provider.(*Msg91Provider)
+	require.Equal(ts.T(), true, ok)
 
 	phone := "123456789"
 	message := "This is the sms code: 123456"
 
-	body := url.Values{
-		"authkey":  {msg91Provider.Config.AuthKey},
-		"sender":   {msg91Provider.Config.SenderId},
-		"mobiles":  {phone},
-		"message":  {message},
-		"route":    {strconv.Itoa(4)},
-		"response": {"json"},
-	}
-	// if msg91Provider.Config.DltTemplateId != nil && *msg91Provider.Config.DltTemplateId != "" {
-	// 	body.Set("DLT_TE_ID", *msg91Provider.Config.DltTemplateId)
-	// }
+	gock.New(msg91Provider.APIPath).Post("").
+		MatchHeader("authkey", msg91Provider.Config.AuthKey).
+		MatchType("json").
+		JSON(map[string]interface{}{
+			"template_id": "test_template_id",
+			"recipients": []map[string]string{
+				{"mobiles": phone, "otp": "123456"},
+			},
+		}).
+		Reply(200).JSON(Msg91Response{
+		Type:    "success",
+		Message: "request-id",
+	})
 
-	gock.New(msg91Provider.APIPath).Post("").MatchType("url").BodyString(body.Encode()).Reply(200).JSON(Msg91Response{
-		Type: "success",
+	messageID, err := msg91Provider.SendMessage(phone, message, SMSProvider, "123456")
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "request-id", messageID)
+}
+
+func (ts *SmsProviderTestSuite) TestMsg91SendWhatsapp() {
+	defer gock.Off()
+
+	provider, err := NewMsg91Provider(ts.Config.Sms.Msg91)
+	require.NoError(ts.T(), err)
+
+	msg91Provider, ok := provider.(*Msg91Provider)
+	require.Equal(ts.T(), true, ok)
+
+	phone := "123456789"
+	otp := "123456"
+
+	gock.New(msg91Provider.WhatsappAPIPath).Post("").
+		MatchHeader("authkey", msg91Provider.Config.AuthKey).
+		MatchType("json").
+		JSON(map[string]interface{}{
+			"integrated_number": "919999999999",
+			"content_type":      "template",
+			"payload": map[string]interface{}{
+				"messaging_product": "whatsapp",
+				"type":              "template",
+				"template": map[string]interface{}{
+					"name": "test_whatsapp_template",
+					"language": map[string]string{
+						"code":   "en",
+						"policy": "deterministic",
+					},
+					"to_and_components": []map[string]interface{}{
+						{
+							"to": []string{phone},
+							"components": map[string]interface{}{
+								"body_1":   map[string]string{"type": "text", "value": otp},
+								"button_1": map[string]string{"subtype": "url", "type": "text", "value": otp},
+							},
+						},
+					},
+				},
+			},
+		}).
+		Reply(200).JSON(map[string]interface{}{
+		"status":   "success",
+		"hasError": false,
+		"data":     "Message submitted successfully",
 	})
 
-	_, err = msg91Provider.SendSms(phone, message)
+	_, err = msg91Provider.SendMessage(phone, "", WhatsappProvider, otp)
 	require.NoError(ts.T(), err)
+
+	gock.New(msg91Provider.WhatsappAPIPath).Post("").
+		Reply(400).JSON(map[string]interface{}{
+		"status":   "fail",
+		"hasError": true,
+		"errors":   "Template not found",
+	})
+
+	_, err = msg91Provider.SendMessage(phone, "", WhatsappProvider, otp)
+	require.Error(ts.T(), err)
+}
+
+func TestIsValidMessageChannel(t *testing.T) {
+	config := &conf.SmsProviderConfiguration{Provider: "msg91"}
+	require.True(t, IsValidMessageChannel(SMSProvider, config))
+	require.False(t, IsValidMessageChannel(WhatsappProvider, config))
+
+	config.Msg91.WhatsappTemplateName = "otp"
+	require.True(t, IsValidMessageChannel(WhatsappProvider, config))
+
+	config = &conf.SmsProviderConfiguration{Provider: "vonage"}
+	require.False(t, IsValidMessageChannel(WhatsappProvider, config))
+
+	config = &conf.SmsProviderConfiguration{Provider: "twilio"}
+	require.True(t, IsValidMessageChannel(WhatsappProvider, config))
+	require.False(t, IsValidMessageChannel("email", config))
+}
+
+type fakeSmsProvider struct {
+	messageID string
+	err       error
+	sent      int
+}
+
+func (t *fakeSmsProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	t.sent++
+	return t.messageID, t.err
+}
+
+func TestFailoverProvider(t *testing.T) {
+	primary := &fakeSmsProvider{err: &url.Error{Op: "Post", URL: "https://api.twilio.com", Err: errors.New("timeout")}}
+	secondary := &fakeSmsProvider{messageID: "secondary-id"}
+	tertiary := &fakeSmsProvider{messageID: "tertiary-id"}
+
+	failover := &FailoverProvider{
+		Names:     []string{"twilio", "vonage", "sns"},
+		Providers: []SmsProvider{primary, secondary, tertiary},
+	}
+
+	var failed []string
+	messageID, provider, err := failover.Send("123456789", "message", SMSProvider, "123456", func(provider string, err error) {
+		failed = append(failed, provider)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "secondary-id", messageID)
+	require.Equal(t, "vonage", provider)
+	require.Equal(t, []string{"twilio"}, failed)
+	require.Equal(t, 0, tertiary.sent)
+
+	secondary.err = &StatusError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("unavailable")}
+	tertiary.err = &StatusError{StatusCode: http.StatusBadGateway, Err: errors.New("bad gateway")}
+	_, err = failover.SendMessage("123456789", "message", SMSProvider, "123456")
+	require.EqualError(t, err, "twilio: Post \"https://api.twilio.com\": timeout\nvonage: unavailable\nsns: bad gateway")
+
+	// the other providers would reject the message too
+	primary.sent, secondary.sent, tertiary.sent = 0, 0, 0
+	primary.err = &StatusError{StatusCode: http.StatusBadRequest, Err: errors.New("invalid number")}
+	_, err = failover.SendMessage("123456789", "message", SMSProvider, "123456")
+	require.EqualError(t, err, "twilio: invalid number")
+	require.Equal(t, 0, secondary.sent)
+
+	primary.err = errors.New("twilio error: message undelivered")
+	_, err = failover.SendMessage("123456789", "message", SMSProvider, "123456")
+	require.EqualError(t, err, "twilio: twilio error: message undelivered")
+	require.Equal(t, 0, secondary.sent)
+}
+
+func TestGetSmsProviderWithFallbacks(t *testing.T) {
+	config := conf.GlobalConfiguration{
+		Sms: conf.SmsProviderConfiguration{
+			Provider:          "twilio",
+			FallbackProviders: []string{"vonage"},
+			Twilio: conf.TwilioProviderConfiguration{
+				AccountSid:        "test_account_sid",
+				AuthToken:         "test_auth_token",
+				MessageServiceSid: "test_message_service_id",
+			},
+			Vonage: conf.VonageProviderConfiguration{
+				ApiKey:    "test_api_key",
+				ApiSecret: "test_api_secret",
+				From:      "test_from",
+			},
+		},
+	}
+
+	provider, err := GetSmsProvider(config)
+	require.NoError(t, err)
+	failover, ok := provider.(*FailoverProvider)
+	require.True(t, ok)
+	require.Equal(t, []string{"twilio", "vonage"}, failover.Names)
+
+	// fallback providers must be configured too
+	config.Sms.Vonage = conf.VonageProviderConfiguration{}
+	_, err = GetSmsProvider(config)
+	require.Error(t, err)
+}
+
+func TestRoutingProvider(t *testing.T) {
+	india := &fakeSmsProvider{messageID: "india-id"}
+	uk := &fakeSmsProvider{messageID: "uk-id"}
+	jersey := &fakeSmsProvider{messageID: "jersey-id"}
+	other := &fakeSmsProvider{messageID: "other-id"}
+
+	router := &RoutingProvider{
+		Routes: []SmsRoute{
+			{Prefix: "447700", Name: "sns", Provider: jersey},
+			{Prefix: "91", Name: "msg91", Provider: india},
+			{Prefix: "44", Name: "vonage", Provider: uk},
+		},
+		Default:     other,
+		DefaultName: "twilio",
+	}
+
+	messageID, provider, err := router.Send("919876543210", "message", SMSProvider, "123456", nil)
+	require.NoError(t, err)
+	require.Equal(t, "india-id", messageID)
+	require.Equal(t, "msg91", provider)
+
+	// the longest prefix wins
+	_, provider, err = router.Send("447700900123", "message", SMSProvider, "123456", nil)
+	require.NoError(t, err)
+	require.Equal(t, "sns", provider)
+
+	_, provider, err = router.Send("442079460000", "message", SMSProvider, "123456", nil)
+	require.NoError(t, err)
+	require.Equal(t, "vonage", provider)
+
+	messageID, provider, err = router.Send("15555550100", "message", SMSProvider, "123456", nil)
+	require.NoError(t, err)
+	require.Equal(t, "other-id", messageID)
+	require.Equal(t, "twilio", provider)
+
+	var failed []string
+	india.err = errors.New("timeout")
+	_, _, err = router.Send("919876543210", "message", SMSProvider, "123456", func(provider string, err error) {
+		failed = append(failed, provider)
+	})
+	require.Error(t, err)
+	require.Equal(t, []string{"msg91"}, failed)
+	require.Equal(t, 1, other.sent)
+}
+
+func TestGetSmsProviderWithCountryProviders(t *testing.T) {
+	config := conf.GlobalConfiguration{
+		Sms: conf.SmsProviderConfiguration{
+			Provider:          "twilio",
+			FallbackProviders: []string{"vonage"},
+			CountryProviders:  map[string]string{"+91": "msg91", "1": "vonage"},
+			Twilio: conf.TwilioProviderConfiguration{
+				AccountSid:        "test_account_sid",
+				AuthToken:         "test_auth_token",
+				MessageServiceSid: "test_message_service_id",
+			},
+			Vonage: conf.VonageProviderConfiguration{
+				ApiKey:    "test_api_key",
+				ApiSecret: "test_api_secret",
+				From:      "test_from",
+			},
+			Msg91: conf.Msg91ProviderConfiguration{
+				AuthKey:    "test_auth_key",
+				TemplateId: "test_template_id",
+			},
+		},
+	}
+
+	provider, err := GetSmsProvider(config)
+	require.NoError(t, err)
+	router, ok := provider.(*RoutingProvider)
+	require.True(t, ok)
+	require.Equal(t, "twilio", router.DefaultName)
+	require.IsType(t, &FailoverProvider{}, router.Default)
+	require.Len(t, router.Routes, 2)
+	require.Equal(t, "91", router.Routes[0].Prefix)
+	require.Equal(t, "msg91", router.Routes[0].Name)
+	require.Equal(t, "1", router.Routes[1].Prefix)
+
+	// routed providers must be configured too
+	config.Sms.Msg91 = conf.Msg91ProviderConfiguration{}
+	_, err = GetSmsProvider(config)
+	require.Error(t, err)
+}
+
+func TestGetSmsProviderWithSendSMSHook(t *testing.T) {
+	config := conf.GlobalConfiguration{}
+	config.Hook.SendSMS.Enabled = true
+
+	provider, err := GetSmsProvider(config)
+	require.NoError(t, err)
+	require.Nil(t, provider)
+
+	config.Hook.SendSMS.Enabled = false
+	_, err = GetSmsProvider(config)
+	require.Error(t, err)
 }
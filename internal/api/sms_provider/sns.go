@@ -0,0 +1,177 @@
+package sms_provider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+const (
+	snsApiVersion = "2010-03-31"
+)
+
+type SnsProvider struct {
+	Config  *conf.SnsProviderConfiguration
+	APIPath string
+}
+
+// See: https://docs.aws.amazon.com/sns/latest/api/API_Publish.html
+type SnsPublishResponse struct {
+	MessageID string `xml:"PublishResult>MessageId"`
+}
+
+type SnsErrResponse struct {
+	Type    string `xml:"Error>Type"`
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func (t SnsErrResponse) Error() string {
+	return fmt.Sprintf("sns error: %s (code: %s)", t.Message, t.Code)
+}
+
+// Creates a SmsProvider with the SNS Config
+func NewSnsProvider(config conf.SnsProviderConfiguration) (SmsProvider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	apiPath := "https://sns." + config.Region + ".amazonaws.com/"
+	return &SnsProvider{
+		Config:  &config,
+		APIPath: apiPath,
+	}, nil
+}
+
+func (t *SnsProvider) SendMessage(phone, message, channel, otp string) (string, error) {
+	switch channel {
+	case SMSProvider:
+		return t.SendSms(phone, message)
+	default:
+		return "", fmt.Errorf("channel type %q is not supported for SNS", channel)
+	}
+}
+
+// Send an SMS containing the OTP with the Publish action of SNS
+func (t *SnsProvider) SendSms(phone, message string) (string, error) {
+	smsType := t.Config.SMSType
+	if smsType == "" {
+		smsType = "Transactional"
+	}
+
+	body := url.Values{
+		"Action":                         {"Publish"},
+		"Version":                        {snsApiVersion},
+		"PhoneNumber":                    {"+" + phone},
+		"Message":                        {message},
+		"MessageAttributes.entry.1.Name": {"AWS.SNS.SMS.SMSType"},
+		"MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"MessageAttributes.entry.1.Value.StringValue": {smsType},
+	}
+	if t.Config.SenderID != "" {
+		body.Set("MessageAttributes.entry.2.Name", "AWS.SNS.SMS.SenderID")
+		body.Set("MessageAttributes.entry.2.Value.DataType", "String")
+		body.Set("MessageAttributes.entry.2.Value.StringValue", t.Config.SenderID)
+	}
+	payload := body.Encode()
+
+	client := &http.Client{Timeout: defaultTimeout}
+	r, err := http.NewRequest("POST", t.APIPath, strings.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if t.Config.SessionToken != "" {
+		r.Header.Add("X-Amz-Security-Token", t.Config.SessionToken)
+	}
+	signAWSRequest(r, []byte(payload), t.Config.AccessKeyID, t.Config.SecretAccessKey, t.Config.Region, "sns", time.Now())
+
+	res, err := client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer utilities.SafeClose(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		resp := &SnsErrResponse{}
+		if err := xml.NewDecoder(res.Body).Decode(resp); err != nil || resp.Code == "" {
+			return "", withStatusCode(res.StatusCode, fmt.Errorf("sns error: unexpected status code %d", res.StatusCode))
+		}
+		return "", withStatusCode(res.StatusCode, resp)
+	}
+
+	resp := &SnsPublishResponse{}
+	if err := xml.NewDecoder(res.Body).Decode(resp); err != nil {
+		return "", err
+	}
+
+	return resp.MessageID, nil
+}
+
+// signAWSRequest adds the Signature Version 4 authorization of a request to
+// an AWS service, signing its host and all of its headers. See:
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func signAWSRequest(r *http.Request, payload []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": r.URL.Host}
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		r.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -83,7 +83,7 @@ func (t *TextlocalProvider) SendSms(phone string, message string) (string, error
 	resp := &TextlocalResponse{}
 	derr := json.NewDecoder(res.Body).Decode(resp)
 	if derr != nil {
-		return "", derr
+		return "", withStatusCode(res.StatusCode, derr)
 	}
 
 	messageID := ""
@@ -94,10 +94,10 @@ func (t *TextlocalProvider) SendSms(phone string, message string) (string, error
 		}
 
 		if len(resp.Errors) > 0 && resp.Errors[0].Code == textLocalTemplateErrorCode {
-			return messageID, fmt.Errorf("textlocal error: %v (code: %v) template message: %s", resp.Errors[0].Message, resp.Errors[0].Code, message)
+			return messageID, withStatusCode(res.StatusCode, fmt.Errorf("textlocal error: %v (code: %v) template message: %s", resp.Errors[0].Message, resp.Errors[0].Code, message))
 		}
 
-		return messageID, fmt.Errorf("textlocal error: %v (code: %v) message %s", resp.Errors[0].Message, resp.Errors[0].Code, messageID)
+		return messageID, withStatusCode(res.StatusCode, fmt.Errorf("textlocal error: %v (code: %v) message %s", resp.Errors[0].Message, resp.Errors[0].Code, messageID))
 	}
 
 	return messageID, nil
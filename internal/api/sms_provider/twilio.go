@@ -119,9 +119,9 @@ func (t *TwilioProvider) SendSms(phone, message, channel, otp string) (string, e
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
 		resp := &twilioErrResponse{}
 		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
-			return "", err
+			return "", withStatusCode(res.StatusCode, err)
 		}
-		return "", resp
+		return "", withStatusCode(res.StatusCode, resp)
 	}
 	// validate sms status
 	resp := &SmsStatus{}
@@ -85,9 +85,9 @@ func (t *TwilioVerifyProvider) SendSms(phone, message, channel string) (string,
 	if !(res.StatusCode == http.StatusOK || res.StatusCode == http.StatusCreated) {
 		resp := &twilioErrResponse{}
 		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
-			return "", err
+			return "", withStatusCode(res.StatusCode, err)
 		}
-		return "", resp
+		return "", withStatusCode(res.StatusCode, resp)
 	}
 
 	resp := &VerificationResponse{}
@@ -1,12 +1,17 @@
 package sms_provider
 
 import (
+	"crypto/md5" //#nosec G501 -- Vonage signatures use MD5.
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/utilities"
@@ -32,6 +37,15 @@ type VonageResponse struct {
 	Messages []VonageResponseMessage `json:"messages"`
 }
 
+// VonageErrResponse is a message Vonage refused to send.
+type VonageErrResponse struct {
+	VonageResponseMessage
+}
+
+func (t VonageErrResponse) Error() string {
+	return fmt.Sprintf("vonage error: %v (status: %v) for message %s", t.ErrorText, t.Status, t.MessageID)
+}
+
 // Creates a SmsProvider with the Vonage Config
 func NewVonageProvider(config conf.VonageProviderConfiguration) (SmsProvider, error) {
 	if err := config.Validate(); err != nil {
@@ -57,11 +71,10 @@ func (t *VonageProvider) SendMessage(phone, message, channel, otp string) (strin
 // Send an SMS containing the OTP with Vonage's API
 func (t *VonageProvider) SendSms(phone string, message string) (string, error) {
 	body := url.Values{
-		"from":       {t.Config.From},
-		"to":         {phone},
-		"text":       {message},
-		"api_key":    {t.Config.ApiKey},
-		"api_secret": {t.Config.ApiSecret},
+		"from":    {t.Config.From},
+		"to":      {phone},
+		"text":    {message},
+		"api_key": {t.Config.ApiKey},
 	}
 
 	isMessageContainUnicode := !utf8string.NewString(message).IsASCII()
@@ -69,6 +82,12 @@ func (t *VonageProvider) SendSms(phone string, message string) (string, error) {
 		body.Set("type", "unicode")
 	}
 
+	if t.Config.SignatureSecret != "" {
+		signVonageRequest(body, t.Config.SignatureSecret, time.Now())
+	} else {
+		body.Set("api_secret", t.Config.ApiSecret)
+	}
+
 	client := &http.Client{Timeout: defaultTimeout}
 	r, err := http.NewRequest("POST", t.APIPath, strings.NewReader(body.Encode()))
 	if err != nil {
@@ -82,6 +101,10 @@ func (t *VonageProvider) SendSms(phone string, message string) (string, error) {
 	}
 	defer utilities.SafeClose(res.Body)
 
+	if res.StatusCode != http.StatusOK {
+		return "", withStatusCode(res.StatusCode, fmt.Errorf("vonage error: unexpected status code %d", res.StatusCode))
+	}
+
 	resp := &VonageResponse{}
 	derr := json.NewDecoder(res.Body).Decode(resp)
 	if derr != nil {
@@ -94,8 +117,35 @@ func (t *VonageProvider) SendSms(phone string, message string) (string, error) {
 
 	// A status of zero indicates success; a non-zero value means something went wrong.
 	if resp.Messages[0].Status != "0" {
-		return resp.Messages[0].MessageID, fmt.Errorf("vonage error: %v (status: %v) for message %s", resp.Messages[0].ErrorText, resp.Messages[0].Status, resp.Messages[0].MessageID)
+		return resp.Messages[0].MessageID, &VonageErrResponse{resp.Messages[0]}
 	}
 
 	return resp.Messages[0].MessageID, nil
 }
+
+// signVonageRequest signs the parameters of a request with the md5hash
+// method, so that the API secret isn't sent. See:
+// https://developer.vonage.com/en/getting-started/concepts/signing-messages
+func signVonageRequest(body url.Values, secret string, now time.Time) {
+	body.Del("sig")
+	body.Set("timestamp", strconv.FormatInt(now.Unix(), 10))
+
+	keys := make([]string, 0, len(body))
+	for key := range body {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// & and = in values are replaced so they can't be confused with the
+	// separators
+	replacer := strings.NewReplacer("&", "_", "=", "_")
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString("&" + key + "=" + replacer.Replace(body.Get(key)))
+	}
+	b.WriteString(secret)
+
+	sum := md5.Sum([]byte(b.String())) //#nosec G401 -- Vonage signatures use MD5.
+	body.Set("sig", hex.EncodeToString(sum[:]))
+}
@@ -258,6 +258,13 @@ func (a *API) adminSSOProvidersCreate(w http.ResponseWriter, r *http.Request) er
 			return terr
 		}
 
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.SSOProviderCreatedAction, "", map[string]interface{}{
+			"sso_provider_id": provider.ID,
+			"entity_id":       provider.SAMLProvider.EntityID,
+		}); terr != nil {
+			return terr
+		}
+
 		return tx.Eager().Load(provider)
 	}); err != nil {
 		return err
@@ -392,6 +399,13 @@ func (a *API) adminSSOProvidersUpdate(w http.ResponseWriter, r *http.Request) er
 				}
 			}
 
+			if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.SSOProviderUpdatedAction, "", map[string]interface{}{
+				"sso_provider_id": provider.ID,
+				"entity_id":       provider.SAMLProvider.EntityID,
+			}); terr != nil {
+				return terr
+			}
+
 			return tx.Eager().Load(provider)
 		}); err != nil {
 			return unprocessableEntityError(ErrorCodeConflict, "Updating SSO provider failed, likely due to a conflict. Try again?").WithInternalError(err)
@@ -409,6 +423,13 @@ func (a *API) adminSSOProvidersDelete(w http.ResponseWriter, r *http.Request) er
 	provider := getSSOProvider(ctx)
 
 	if err := db.Transaction(func(tx *storage.Connection) error {
+		if terr := models.NewAuditLogEntry(r, tx, getAdminUser(ctx), models.SSOProviderDeletedAction, "", map[string]interface{}{
+			"sso_provider_id": provider.ID,
+			"entity_id":       provider.SAMLProvider.EntityID,
+		}); terr != nil {
+			return terr
+		}
+
 		return tx.Eager().Destroy(provider)
 	}); err != nil {
 		return err
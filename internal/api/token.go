@@ -20,6 +20,8 @@ import (
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 // AccessTokenClaims is a struct thats used for JWT claims
@@ -34,6 +36,10 @@ type AccessTokenClaims struct {
 	AuthenticationMethodReference []models.AMREntry      `json:"amr,omitempty"`
 	SessionId                     string                 `json:"session_id,omitempty"`
 	IsAnonymous                   bool                   `json:"is_anonymous"`
+
+	// Scope and ClientID are only set on the tokens of OAuth clients.
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
 }
 
 // AccessTokenResponse represents an OAuth2 success response
@@ -77,22 +83,39 @@ type PKCEGrantParams struct {
 const useCookieHeader = "x-use-cookie"
 const InvalidLoginMessage = "Invalid login credentials"
 
+var tokenGrantsCounter = observability.ObtainMetricCounter("gotrue_token_grants", "Number of token requests by grant type and outcome")
+
 // Token is the endpoint for OAuth access token requests
 func (a *API) Token(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	grantType := r.FormValue("grant_type")
+
+	var err error
 	switch grantType {
 	case "password":
-		return a.ResourceOwnerPasswordGrant(ctx, w, r)
+		err = a.ResourceOwnerPasswordGrant(ctx, w, r)
 	case "refresh_token":
-		return a.RefreshTokenGrant(ctx, w, r)
+		err = a.RefreshTokenGrant(ctx, w, r)
 	case "id_token":
-		return a.IdTokenGrant(ctx, w, r)
+		err = a.IdTokenGrant(ctx, w, r)
 	case "pkce":
-		return a.PKCE(ctx, w, r)
+		err = a.PKCE(ctx, w, r)
+	case deviceCodeGrantType, deviceCodeGrantTypeShort:
+		err = a.DeviceCodeGrant(ctx, w, r)
+	case "client_credentials":
+		err = a.ClientCredentialsGrant(ctx, w, r)
+	case "webauthn":
+		err = a.WebAuthnGrant(ctx, w, r)
 	default:
-		return oauthError("unsupported_grant_type", "")
+		return oauthError("unsupported_grant_type", ErrorCodeValidationFailed, "")
 	}
+
+	tokenGrantsCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("grant_type", grantType),
+		attribute.Bool("success", err == nil),
+	))
+
+	return err
 }
 
 // ResourceOwnerPasswordGrant implements the password grant type flow
@@ -105,7 +128,11 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 	}
 
 	aud := a.requestAud(ctx, r)
-	config := a.config
+	config := a.getConfig(ctx)
+
+	if err := a.requireFeature(ctx, conf.FeaturePasswordLogin); err != nil {
+		return err
+	}
 
 	if params.Email != "" && params.Phone != "" {
 		return badRequestError(ErrorCodeValidationFailed, "Only an email address or phone number should be provided on login.")
@@ -122,36 +149,59 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 		if !config.External.Email.Enabled {
 			return unprocessableEntityError(ErrorCodeEmailProviderDisabled, "Email logins are disabled")
 		}
-		user, err = models.FindUserByEmailAndAudience(db, params.Email, aud)
 	} else if params.Phone != "" {
 		provider = "phone"
 		if !config.External.Phone.Enabled {
 			return unprocessableEntityError(ErrorCodePhoneProviderDisabled, "Phone logins are disabled")
 		}
 		params.Phone = formatPhoneNumber(params.Phone)
-		user, err = models.FindUserByPhoneAndAudience(db, params.Phone, aud)
 	} else {
-		return oauthError("invalid_grant", InvalidLoginMessage)
+		return oauthError("invalid_grant", ErrorCodeInvalidCredentials, InvalidLoginMessage)
 	}
 
-	if err != nil {
-		if models.IsNotFoundError(err) {
-			return oauthError("invalid_grant", InvalidLoginMessage)
+	// locked accounts are refused before looking them up
+	accountKey, ipKey := loginAttemptKeys(r, aud, params.Email, params.Phone)
+	if err := a.checkLoginLockout(ctx, w, db, accountKey, ipKey); err != nil {
+		return err
+	}
+
+	// the password of users in the LDAP directory is checked by the
+	// directory, which also keeps its own password policy
+	if params.Email != "" && config.LDAP.Enabled {
+		if user, err = a.ldapLogin(ctx, r, db, params.Email, params.Password, aud, accountKey, ipKey); err != nil {
+			return err
+		}
+		if user != nil {
+			provider = "ldap"
 		}
-		return internalServerError("Database error querying schema").WithInternalError(err)
 	}
 
-	if user.IsBanned() {
-		return oauthError("invalid_grant", InvalidLoginMessage)
+	if user == nil {
+		if params.Email != "" {
+			user, err = models.FindUserByEmailAndAudience(db, params.Email, aud)
+		} else {
+			user, err = models.FindUserByPhoneAndAudience(db, params.Phone, aud)
+		}
 	}
 
-	isValidPassword, shouldReEncrypt, err := user.Authenticate(ctx, params.Password, config.Security.DBEncryption.DecryptionKeys, config.Security.DBEncryption.Encrypt, config.Security.DBEncryption.EncryptionKeyID)
 	if err != nil {
-		return err
+		if models.IsNotFoundError(err) {
+			a.recordFailedLogin(ctx, r, db, nil, accountKey, ipKey)
+			return oauthError("invalid_grant", ErrorCodeInvalidCredentials, InvalidLoginMessage)
+		}
+		return internalServerError("Database error querying schema").WithInternalError(err)
+	}
+
+	isValidPassword, shouldUpdatePassword := provider == "ldap", false
+	if provider != "ldap" {
+		isValidPassword, shouldUpdatePassword, err = user.Authenticate(ctx, params.Password, config.Security.DBEncryption.DecryptionKeys, config.Security.DBEncryption.Encrypt, config.Security.DBEncryption.EncryptionKeyID)
+		if err != nil {
+			return err
+		}
 	}
 
 	var weakPasswordError *WeakPasswordError
-	if isValidPassword {
+	if isValidPassword && provider != "ldap" {
 		if err := a.checkPasswordStrength(ctx, params.Password); err != nil {
 			if wpe, ok := err.(*WeakPasswordError); ok {
 				weakPasswordError = wpe
@@ -159,20 +209,6 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 				observability.GetLogEntry(r).Entry.WithError(err).Warn("Password strength check on sign-in failed")
 			}
 		}
-
-		if shouldReEncrypt {
-			if err := user.SetPassword(ctx, params.Password, true, config.Security.DBEncryption.EncryptionKeyID, config.Security.DBEncryption.EncryptionKey); err != nil {
-				return err
-			}
-
-			// directly change this in the database without
-			// calling user.UpdatePassword() because this
-			// is not a password change, just encryption
-			// change in the database
-			if err := db.UpdateOnly(user, "encrypted_password"); err != nil {
-				return err
-			}
-		}
 	}
 
 	if config.Hook.PasswordVerificationAttempt.Enabled {
@@ -195,27 +231,58 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 					return err
 				}
 			}
-			return oauthError("invalid_grant", InvalidLoginMessage)
+			a.recordFailedLogin(ctx, r, db, user, accountKey, ipKey)
+			return oauthError("invalid_grant", ErrorCodeInvalidCredentials, InvalidLoginMessage)
 		}
 	}
 	if !isValidPassword {
-		return oauthError("invalid_grant", InvalidLoginMessage)
+		a.recordFailedLogin(ctx, r, db, user, accountKey, ipKey)
+		return oauthError("invalid_grant", ErrorCodeInvalidCredentials, InvalidLoginMessage)
+	}
+
+	// bans are only revealed to those who know the password
+	if user.IsBanned() {
+		return forbiddenError(ErrorCodeUserBanned, "User is banned")
 	}
 
 	if params.Email != "" && !user.IsConfirmed() {
-		return oauthError("invalid_grant", "Email not confirmed")
+		return oauthError("invalid_grant", ErrorCodeEmailNotConfirmed, "Email not confirmed")
 	} else if params.Phone != "" && !user.IsPhoneConfirmed() {
-		return oauthError("invalid_grant", "Phone not confirmed")
+		return oauthError("invalid_grant", ErrorCodePhoneNotConfirmed, "Phone not confirmed")
+	}
+
+	if shouldUpdatePassword {
+		// hashing is slow, so the password is hashed again before the
+		// transaction and only saved within it
+		if err := user.SetPassword(ctx, params.Password, config.Security.DBEncryption.Encrypt, config.Security.DBEncryption.EncryptionKeyID, config.Security.DBEncryption.EncryptionKey); err != nil {
+			observability.GetLogEntry(r).Entry.WithError(err).Warn("Unable to rehash password on sign-in")
+			shouldUpdatePassword = false
+		}
 	}
 
 	var token *AccessTokenResponse
 	err = db.Transaction(func(tx *storage.Connection) error {
 		var terr error
+		if shouldUpdatePassword {
+			// directly change this in the database without
+			// calling user.UpdatePassword() because this
+			// is not a password change, just a change of how
+			// it is hashed or encrypted in the database
+			if terr = tx.UpdateOnly(user, "encrypted_password"); terr != nil {
+				return internalServerError("Database error updating password").WithInternalError(terr)
+			}
+		}
 		if terr = models.NewAuditLogEntry(r, tx, user, models.LoginAction, "", map[string]interface{}{
 			"provider": provider,
 		}); terr != nil {
 			return terr
 		}
+		if config.Security.LoginLockout.Enabled {
+			if terr = models.ClearLoginAttempts(tx, accountKey); terr != nil {
+				return internalServerError("Database error clearing failed logins").WithInternalError(terr)
+			}
+		}
+		a.notifyWebhook(r, tx, conf.WebhookLoginEvent, user)
 		token, terr = a.issueRefreshToken(r, tx, user, models.PasswordGrant, grantParams)
 		if terr != nil {
 			return terr
@@ -270,6 +337,9 @@ func (a *API) PKCE(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return err
 	}
+	if user.IsBanned() {
+		return forbiddenError(ErrorCodeUserBanned, "User is banned")
+	}
 	if err := flowState.VerifyPKCE(params.CodeVerifier); err != nil {
 		return badRequestError(ErrorBadCodeVerifier, err.Error())
 	}
@@ -286,9 +356,10 @@ func (a *API) PKCE(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		}); terr != nil {
 			return terr
 		}
+		a.notifyWebhook(r, tx, conf.WebhookLoginEvent, user)
 		token, terr = a.issueRefreshToken(r, tx, user, authMethod, grantParams)
 		if terr != nil {
-			return oauthError("server_error", terr.Error())
+			return oauthError("server_error", ErrorCodeUnexpectedFailure, terr.Error())
 		}
 		token.ProviderAccessToken = flowState.ProviderAccessToken
 		// Because not all providers give out a refresh token
@@ -345,7 +416,7 @@ func (a *API) generateAccessToken(r *http.Request, tx *storage.Connection, user
 		IsAnonymous:                   user.IsAnonymous,
 	}
 
-	var token *jwt.Token
+	var tokenClaims jwt.Claims = claims
 	if config.Hook.CustomAccessToken.Enabled {
 		input := hooks.CustomAccessTokenInput{
 			UserID:               user.ID,
@@ -359,23 +430,10 @@ func (a *API) generateAccessToken(r *http.Request, tx *storage.Connection, user
 		if err != nil {
 			return "", 0, err
 		}
-		goTrueClaims := jwt.MapClaims(output.Claims)
-
-		token = jwt.NewWithClaims(jwt.SigningMethodHS256, goTrueClaims)
-
-	} else {
-		token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	}
-
-	if config.JWT.KeyID != "" {
-		if token.Header == nil {
-			token.Header = make(map[string]interface{})
-		}
-
-		token.Header["kid"] = config.JWT.KeyID
+		tokenClaims = jwt.MapClaims(output.Claims)
 	}
 
-	signed, err := token.SignedString([]byte(config.JWT.Secret))
+	signed, err := a.signAccessToken(r.Context(), tokenClaims)
 	if err != nil {
 		return "", 0, err
 	}
@@ -406,6 +464,28 @@ func (a *API) issueRefreshToken(r *http.Request, conn *storage.Connection, user
 			return terr
 		}
 
+		if grantParams.FactorID != nil {
+			// signing in with a factor, such as a passkey, gives the
+			// session the assurance level of the factor
+			session, terr := models.FindSessionByID(tx, *refreshToken.SessionId, false)
+			if terr != nil {
+				return terr
+			}
+			aal, _, terr := session.CalculateAALAndAMR(user)
+			if terr != nil {
+				return terr
+			}
+			if terr := session.UpdateAALAndAssociatedFactor(tx, aal, grantParams.FactorID); terr != nil {
+				return terr
+			}
+		}
+
+		if config.Sessions.SinglePerUser {
+			if terr = a.revokeOtherSessions(tx, user.ID, *refreshToken.SessionId); terr != nil {
+				return terr
+			}
+		}
+
 		tokenString, expiresAt, terr = a.generateAccessToken(r, tx, user, refreshToken.SessionId, authenticationMethod)
 		if terr != nil {
 			// Account for Hook Error
@@ -472,7 +552,7 @@ func (a *API) updateMFASessionAndClaims(r *http.Request, tx *storage.Connection,
 			return err
 		}
 
-		tokenString, expiresAt, terr = a.generateAccessToken(r, tx, user, &session.ID, models.TOTPSignIn)
+		tokenString, expiresAt, terr = a.generateAccessToken(r, tx, user, &session.ID, authenticationMethod)
 		if terr != nil {
 			httpErr, ok := terr.(*HTTPError)
 			if ok {
@@ -563,7 +643,6 @@ func validateTokenClaims(outputClaims map[string]interface{}) error {
 
 		for _, desc := range result.Errors() {
 			errorMessages += fmt.Sprintf("- %s\n", desc)
-			fmt.Printf("- %s\n", desc)
 		}
 		return fmt.Errorf("output claims do not conform to the expected schema: \n%s", errorMessages)
 
@@ -34,7 +34,7 @@ func (p *IdTokenGrantParams) getProvider(ctx context.Context, config *conf.Globa
 
 	switch true {
 	case p.Provider == "apple" || p.Issuer == provider.IssuerApple:
-		cfg = &config.External.Apple
+		cfg = &config.External.Apple.OAuthProviderConfiguration
 		providerType = "apple"
 		issuer = provider.IssuerApple
 		acceptableClientIDs = append(acceptableClientIDs, config.External.Apple.ClientID...)
@@ -58,7 +58,7 @@ func (p *IdTokenGrantParams) getProvider(ctx context.Context, config *conf.Globa
 			}
 			issuer = detectedIssuer
 		}
-		cfg = &config.External.Azure
+		cfg = &config.External.Azure.OAuthProviderConfiguration
 		providerType = "azure"
 		acceptableClientIDs = append(acceptableClientIDs, config.External.Azure.ClientID...)
 
@@ -74,6 +74,12 @@ func (p *IdTokenGrantParams) getProvider(ctx context.Context, config *conf.Globa
 		issuer = config.External.Keycloak.URL
 		acceptableClientIDs = append(acceptableClientIDs, config.External.Keycloak.ClientID...)
 
+	case p.Provider == "oidc" || (config.External.OIDC.Enabled && config.External.OIDC.URL != "" && p.Issuer == config.External.OIDC.URL):
+		cfg = &config.External.OIDC.OAuthProviderConfiguration
+		providerType = "oidc"
+		issuer = config.External.OIDC.URL
+		acceptableClientIDs = append(acceptableClientIDs, config.External.OIDC.ClientID...)
+
 	case p.Provider == "kakao" || p.Issuer == provider.IssuerKakao:
 		cfg = &config.External.Kakao
 		providerType = "kakao"
@@ -129,11 +135,11 @@ func (a *API) IdTokenGrant(ctx context.Context, w http.ResponseWriter, r *http.R
 	}
 
 	if params.IdToken == "" {
-		return oauthError("invalid request", "id_token required")
+		return oauthError("invalid request", ErrorCodeValidationFailed, "id_token required")
 	}
 
 	if params.Provider == "" && (params.ClientID == "" || params.Issuer == "") {
-		return oauthError("invalid request", "provider or client_id and issuer required")
+		return oauthError("invalid request", ErrorCodeValidationFailed, "provider or client_id and issuer required")
 	}
 
 	oidcProvider, skipNonceCheck, providerType, acceptableClientIDs, err := params.getProvider(ctx, config, r)
@@ -141,12 +147,16 @@ func (a *API) IdTokenGrant(ctx context.Context, w http.ResponseWriter, r *http.R
 		return err
 	}
 
+	if err := a.requireFeature(ctx, conf.ExternalProviderFeature(providerType)); err != nil {
+		return err
+	}
+
 	idToken, userData, err := provider.ParseIDToken(ctx, oidcProvider, nil, params.IdToken, provider.ParseIDTokenOptions{
 		SkipAccessTokenCheck: params.AccessToken == "",
 		AccessToken:          params.AccessToken,
 	})
 	if err != nil {
-		return oauthError("invalid request", "Bad ID token").WithInternalError(err)
+		return oauthError("invalid request", ErrorCodeBadJWT, "Bad ID token").WithInternalError(err)
 	}
 
 	userData.Metadata.EmailVerified = false
@@ -162,7 +172,7 @@ func (a *API) IdTokenGrant(ctx context.Context, w http.ResponseWriter, r *http.R
 	}
 
 	if idToken.Subject == "" {
-		return oauthError("invalid request", "Missing sub claim in id_token")
+		return oauthError("invalid request", ErrorCodeBadJWT, "Missing sub claim in id_token")
 	}
 
 	correctAudience := false
@@ -184,7 +194,7 @@ func (a *API) IdTokenGrant(ctx context.Context, w http.ResponseWriter, r *http.R
 	}
 
 	if !correctAudience {
-		return oauthError("invalid request", fmt.Sprintf("Unacceptable audience in id_token: %v", idToken.Audience))
+		return oauthError("invalid request", ErrorCodeUnexpectedAudience, fmt.Sprintf("Unacceptable audience in id_token: %v", idToken.Audience))
 	}
 
 	if !skipNonceCheck {
@@ -192,12 +202,12 @@ func (a *API) IdTokenGrant(ctx context.Context, w http.ResponseWriter, r *http.R
 		paramsHasNonce := params.Nonce != ""
 
 		if tokenHasNonce != paramsHasNonce {
-			return oauthError("invalid request", "Passed nonce and nonce in id_token should either both exist or not.")
+			return oauthError("invalid request", ErrorCodeValidationFailed, "Passed nonce and nonce in id_token should either both exist or not.")
 		} else if tokenHasNonce && paramsHasNonce {
 			// verify nonce to mitigate replay attacks
 			hash := fmt.Sprintf("%x", sha256.Sum256([]byte(params.Nonce)))
 			if hash != idToken.Nonce {
-				return oauthError("invalid nonce", "Nonces mismatch")
+				return oauthError("invalid nonce", ErrorCodeValidationFailed, "Nonces mismatch")
 			}
 		}
 	}
@@ -237,7 +247,7 @@ func (a *API) IdTokenGrant(ctx context.Context, w http.ResponseWriter, r *http.R
 		case *storage.CommitWithError:
 			return err
 		default:
-			return oauthError("server_error", "Internal Server Error").WithInternalError(err)
+			return oauthError("server_error", ErrorCodeUnexpectedFailure, "Internal Server Error").WithInternalError(err)
 		}
 	}
 
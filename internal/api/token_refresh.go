@@ -30,7 +30,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 	}
 
 	if params.RefreshToken == "" {
-		return oauthError("invalid_request", "refresh_token required")
+		return oauthError("invalid_request", ErrorCodeValidationFailed, "refresh_token required")
 	}
 
 	// A 5 second retry loop is used to make sure that refresh token
@@ -47,13 +47,13 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 		user, token, session, err := models.FindUserWithRefreshToken(db, params.RefreshToken, false)
 		if err != nil {
 			if models.IsNotFoundError(err) {
-				return oauthError("invalid_grant", "Invalid Refresh Token: Refresh Token Not Found")
+				return oauthError("invalid_grant", ErrorCodeRefreshTokenNotFound, "Invalid Refresh Token: Refresh Token Not Found")
 			}
 			return internalServerError(err.Error())
 		}
 
 		if user.IsBanned() {
-			return oauthError("invalid_grant", "Invalid Refresh Token: User Banned")
+			return forbiddenError(ErrorCodeUserBanned, "User is banned")
 		}
 
 		if session != nil {
@@ -64,10 +64,10 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 				// do nothing
 
 			case models.SessionTimedOut:
-				return oauthError("invalid_grant", "Invalid Refresh Token: Session Expired (Inactivity)")
+				return oauthError("invalid_grant", ErrorCodeSessionExpired, "Invalid Refresh Token: Session Expired (Inactivity)")
 
 			default:
-				return oauthError("invalid_grant", "Invalid Refresh Token: Session Expired")
+				return oauthError("invalid_grant", ErrorCodeSessionExpired, "Invalid Refresh Token: Session Expired")
 			}
 		}
 
@@ -147,7 +147,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 					if s.LastRefreshedAt(nil).After(session.LastRefreshedAt(&token.UpdatedAt)) {
 						// session is not the most
 						// recently active one
-						return oauthError("invalid_grant", "Invalid Refresh Token: Session Expired (Revoked by Newer Login)")
+						return oauthError("invalid_grant", ErrorCodeSessionExpired, "Invalid Refresh Token: Session Expired (Revoked by Newer Login)")
 					}
 				}
 
@@ -192,7 +192,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 							}
 						}
 
-						return storage.NewCommitWithError(oauthError("invalid_grant", "Invalid Refresh Token: Already Used").WithInternalMessage("Possible abuse attempt: %v", token.ID))
+						return storage.NewCommitWithError(oauthError("invalid_grant", ErrorCodeRefreshTokenAlreadyUsed, "Invalid Refresh Token: Already Used").WithInternalMessage("Possible abuse attempt: %v", token.ID))
 					}
 				}
 			}
@@ -229,7 +229,7 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 				session.UserAgent = nil
 			}
 
-			ipAddress := utilities.GetIPAddress(r)
+			ipAddress := utilities.GetClientIP(r)
 			if ipAddress != "" {
 				session.IP = &ipAddress
 			} else {
@@ -259,7 +259,9 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 				// refresh token and session row were likely locked, so
 				// we need to wait a moment before retrying the whole
 				// process anew
-				time.Sleep(time.Duration(10+mathRand.Intn(20)) * time.Millisecond) // #nosec
+				if err := utilities.Sleep(ctx, time.Duration(10+mathRand.Intn(20))*time.Millisecond); err != nil { // #nosec
+					return err
+				}
 				continue
 			} else {
 				return err
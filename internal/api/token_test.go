@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
@@ -18,6 +19,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
 	"github.com/supabase/auth/internal/models"
 )
 
@@ -296,7 +298,247 @@ func (ts *TokenTestSuite) TestTokenPasswordGrantFailure() {
 
 	w := httptest.NewRecorder()
 	ts.API.handler.ServeHTTP(w, req)
+	assert.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	httpErr := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(httpErr))
+	require.Equal(ts.T(), ErrorCodeUserBanned, httpErr.ErrorCode)
+
+	// the ban isn't revealed without the password
+	w = ts.passwordGrant(u.GetEmail(), "wrong password")
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	data := &OAuthError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), "invalid_grant", data.Err)
+	require.Equal(ts.T(), ErrorCodeInvalidCredentials, data.ErrorCode)
+
+	w = ts.passwordGrant("unknown@example.com", "password")
 	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	data = &OAuthError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeInvalidCredentials, data.ErrorCode)
+}
+
+func (ts *TokenTestSuite) passwordGrant(email, password string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    email,
+		"password": password,
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=password", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *TokenTestSuite) TestTokenPasswordGrantLDAPUnavailable() {
+	defer func(config conf.LDAPConfiguration) {
+		ts.Config.LDAP = config
+	}(ts.Config.LDAP)
+	ts.Config.LDAP = conf.LDAPConfiguration{
+		Enabled:    true,
+		URL:        "ldap://127.0.0.1:1",
+		UserFilter: "(mail=%s)",
+		Timeout:    time.Second,
+	}
+
+	// users without a directory entry log in with their local password
+	w := ts.passwordGrant("test@example.com", "password")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	u, err := models.NewUser("", "directory@example.com", "", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	identity, err := models.NewIdentity(u, "ldap", map[string]interface{}{
+		"sub":   "cn=directory,dc=example,dc=com",
+		"email": "directory@example.com",
+	})
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(identity))
+
+	w = ts.passwordGrant("directory@example.com", "password")
+	require.Equal(ts.T(), http.StatusInternalServerError, w.Code, w.Body.String())
+}
+
+func (ts *TokenTestSuite) TestTokenPasswordGrantRehash() {
+	cases := []struct {
+		desc     string
+		hash     string
+		password string
+	}{
+		{
+			desc:     "argon2id",
+			hash:     "$argon2id$v=19$m=32,t=3,p=2$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk",
+			password: "test",
+		},
+		{
+			desc:     "scrypt",
+			hash:     "$scrypt$ln=4,r=8,p=1$YzJGc2RITmhiSFE$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68",
+			password: "test",
+		},
+		{
+			desc:     "firebase scrypt",
+			hash:     "$fbscrypt$v=1,n=14,r=8,p=1,ss=Bw==,sk=jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==",
+			password: "user1password",
+		},
+	}
+
+	for i, c := range cases {
+		ts.Run(c.desc, func() {
+			email := fmt.Sprintf("rehash-%d@example.com", i)
+			u, err := models.NewUserWithPasswordHash("", email, c.hash, ts.Config.JWT.Aud, nil)
+			require.NoError(ts.T(), err)
+			now := time.Now()
+			u.EmailConfirmedAt = &now
+			require.NoError(ts.T(), ts.API.db.Create(u))
+
+			// a failed login never rewrites the hash
+			w := ts.passwordGrant(email, "wrong-password")
+			require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+			u, err = models.FindUserByID(ts.API.db, u.ID)
+			require.NoError(ts.T(), err)
+			require.Equal(ts.T(), c.hash, u.EncryptedPassword)
+
+			w = ts.passwordGrant(email, c.password)
+			require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+			u, err = models.FindUserByID(ts.API.db, u.ID)
+			require.NoError(ts.T(), err)
+			require.True(ts.T(), strings.HasPrefix(u.EncryptedPassword, "$2a$"), u.EncryptedPassword)
+			require.False(ts.T(), crypto.NeedsRehash(u.EncryptedPassword))
+			require.NoError(ts.T(), crypto.CompareHashAndPassword(context.Background(), u.EncryptedPassword, c.password))
+
+			// hashes with the configured algorithm are kept
+			hash := u.EncryptedPassword
+			w = ts.passwordGrant(email, c.password)
+			require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+			u, err = models.FindUserByID(ts.API.db, u.ID)
+			require.NoError(ts.T(), err)
+			require.Equal(ts.T(), hash, u.EncryptedPassword)
+		})
+	}
+}
+
+func (ts *TokenTestSuite) TestTokenPasswordGrantRehashToArgon2id() {
+	defer func(params crypto.PasswordHashParams) {
+		crypto.PasswordHashing = params
+	}(crypto.PasswordHashing)
+	crypto.PasswordHashing = crypto.PasswordHashParams{
+		Algorithm:         crypto.Argon2idHashAlgorithm,
+		Argon2Memory:      64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	}
+
+	hash := ts.User.EncryptedPassword
+	require.True(ts.T(), strings.HasPrefix(hash, "$2a$"))
+
+	// unconfirmed users can't sign in, so their hash is kept
+	u, err := models.NewUser("", "unconfirmed@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(u))
+	w := ts.passwordGrant("unconfirmed@example.com", "password")
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+	unconfirmed, err := models.FindUserByID(ts.API.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), u.EncryptedPassword, unconfirmed.EncryptedPassword)
+
+	w = ts.passwordGrant(ts.User.GetEmail(), "password")
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	u, err = models.FindUserByID(ts.API.db, ts.User.ID)
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), strings.HasPrefix(u.EncryptedPassword, "$argon2id$"), u.EncryptedPassword)
+	require.NoError(ts.T(), crypto.CompareHashAndPassword(context.Background(), u.EncryptedPassword, "password"))
+}
+
+func (ts *TokenTestSuite) TestTokenPasswordGrantLockout() {
+	ts.Config.Security.LoginLockout = conf.LoginLockoutConfiguration{
+		Enabled:     true,
+		Mode:        conf.LoginLockoutModeLock,
+		MaxAttempts: 3,
+		Duration:    time.Minute,
+	}
+	defer func() {
+		ts.Config.Security.LoginLockout = conf.LoginLockoutConfiguration{}
+	}()
+
+	for i := 0; i < 3; i++ {
+		require.Equal(ts.T(), http.StatusBadRequest, ts.passwordGrant("test@example.com", "wrong").Code)
+	}
+
+	// the correct password is refused while the account is locked
+	w := ts.passwordGrant("test@example.com", "password")
+	require.Equal(ts.T(), http.StatusTooManyRequests, w.Code)
+	require.Contains(ts.T(), w.Body.String(), string(ErrorCodeAccountLocked))
+	require.NotEmpty(ts.T(), w.Header().Get("Retry-After"))
+
+	// the lockout was recorded in the audit log
+	entries, err := models.FindAuditLogEntries(ts.API.db, &models.AuditLogFilter{Action: models.UserLockedAction}, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), entries, 1)
+
+	// locked nonexistent accounts get the same response
+	for i := 0; i < 3; i++ {
+		require.Equal(ts.T(), http.StatusBadRequest, ts.passwordGrant("nobody@example.com", "wrong").Code)
+	}
+	nonexistent := ts.passwordGrant("nobody@example.com", "wrong")
+	require.Equal(ts.T(), w.Code, nonexistent.Code)
+
+	var locked, lockedNonexistent HTTPError
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&locked))
+	require.NoError(ts.T(), json.NewDecoder(nonexistent.Body).Decode(&lockedNonexistent))
+	require.Equal(ts.T(), locked.ErrorCode, lockedNonexistent.ErrorCode)
+	require.Equal(ts.T(), locked.Message, lockedNonexistent.Message)
+
+	// logins are accepted again once the cooldown has ended
+	require.NoError(ts.T(), ts.API.db.RawQuery("update "+models.LoginAttempt{}.TableName()+" set locked_until = now() - interval '1 second'").Exec())
+	require.Equal(ts.T(), http.StatusOK, ts.passwordGrant("test@example.com", "password").Code)
+}
+
+func (ts *TokenTestSuite) TestTokenPasswordGrantSuccessClearsFailures() {
+	ts.Config.Security.LoginLockout = conf.LoginLockoutConfiguration{
+		Enabled:     true,
+		Mode:        conf.LoginLockoutModeLock,
+		MaxAttempts: 3,
+		Duration:    time.Minute,
+	}
+	defer func() {
+		ts.Config.Security.LoginLockout = conf.LoginLockoutConfiguration{}
+	}()
+
+	for i := 0; i < 2; i++ {
+		require.Equal(ts.T(), http.StatusBadRequest, ts.passwordGrant("test@example.com", "wrong").Code)
+	}
+	require.Equal(ts.T(), http.StatusOK, ts.passwordGrant("test@example.com", "password").Code)
+
+	// the earlier failures no longer count towards the limit
+	for i := 0; i < 2; i++ {
+		require.Equal(ts.T(), http.StatusBadRequest, ts.passwordGrant("test@example.com", "wrong").Code)
+	}
+	require.Equal(ts.T(), http.StatusOK, ts.passwordGrant("test@example.com", "password").Code)
+}
+
+func (ts *TokenTestSuite) TestTokenPasswordGrantLockoutPerIP() {
+	ts.Config.Security.LoginLockout = conf.LoginLockoutConfiguration{
+		Enabled:          true,
+		Mode:             conf.LoginLockoutModeLock,
+		MaxAttemptsPerIP: 2,
+		Duration:         time.Minute,
+	}
+	defer func() {
+		ts.Config.Security.LoginLockout = conf.LoginLockoutConfiguration{}
+	}()
+
+	require.Equal(ts.T(), http.StatusBadRequest, ts.passwordGrant("first@example.com", "wrong").Code)
+	require.Equal(ts.T(), http.StatusBadRequest, ts.passwordGrant("second@example.com", "wrong").Code)
+
+	// every account is refused from the locked address
+	require.Equal(ts.T(), http.StatusTooManyRequests, ts.passwordGrant("test@example.com", "password").Code)
 }
 
 func (ts *TokenTestSuite) TestTokenPKCEGrantFailure() {
@@ -348,6 +590,31 @@ func (ts *TokenTestSuite) TestTokenPKCEGrantFailure() {
 	}
 }
 
+func (ts *TokenTestSuite) TestTokenPKCEGrantBannedUser() {
+	u := ts.createBannedUser()
+
+	codeVerifier := "4a9505b9-0857-42bb-ab3c-098b4d28ddc2"
+	codeChallenge := sha256.Sum256([]byte(codeVerifier))
+	challenge := base64.RawURLEncoding.EncodeToString(codeChallenge[:])
+	flowState := models.NewFlowState("github", challenge, models.SHA256, models.OAuth, &u.ID)
+	require.NoError(ts.T(), ts.API.db.Create(flowState))
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"code_verifier": codeVerifier,
+		"auth_code":     flowState.AuthCode,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token?grant_type=pkce", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeUserBanned, data.ErrorCode)
+}
+
 func (ts *TokenTestSuite) TestTokenRefreshTokenGrantFailure() {
 	_ = ts.createBannedUser()
 
@@ -361,7 +628,11 @@ func (ts *TokenTestSuite) TestTokenRefreshTokenGrantFailure() {
 
 	w := httptest.NewRecorder()
 	ts.API.handler.ServeHTTP(w, req)
-	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), ErrorCodeUserBanned, data.ErrorCode)
 }
 
 func (ts *TokenTestSuite) TestRefreshTokenReuseRevocation() {
@@ -430,11 +701,13 @@ func (ts *TokenTestSuite) TestRefreshTokenReuseRevocation() {
 	var response struct {
 		Error            string `json:"error"`
 		ErrorDescription string `json:"error_description"`
+		ErrorCode        string `json:"error_code"`
 	}
 
 	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&response))
 	require.Equal(ts.T(), response.Error, "invalid_grant")
 	require.Equal(ts.T(), response.ErrorDescription, "Invalid Refresh Token: Already Used")
+	require.Equal(ts.T(), ErrorCodeRefreshTokenAlreadyUsed, response.ErrorCode)
 
 	// ensure that the refresh tokens are marked as revoked in the database
 	for _, refreshToken := range refreshTokens {
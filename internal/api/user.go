@@ -8,6 +8,7 @@ import (
 
 	"github.com/gofrs/uuid"
 	"github.com/supabase/auth/internal/api/sms_provider"
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/models"
 	"github.com/supabase/auth/internal/storage"
 )
@@ -43,7 +44,7 @@ func (a *API) validateUserUpdateParams(ctx context.Context, p *UserUpdateParams)
 		if p.Channel == "" {
 			p.Channel = sms_provider.SMSProvider
 		}
-		if !sms_provider.IsValidMessageChannel(p.Channel, config.Sms.Provider) {
+		if !sms_provider.IsValidMessageChannel(p.Channel, &config.Sms) {
 			return badRequestError(ErrorCodeValidationFailed, InvalidChannelError)
 		}
 	}
@@ -71,6 +72,10 @@ func (a *API) UserGet(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	user := getUser(ctx)
+	if err := user.LoadRecoveryCodesRemaining(a.db.WithContext(ctx)); err != nil {
+		return internalServerError("Database error loading recovery codes").WithInternalError(err)
+	}
+
 	return sendJSON(w, http.StatusOK, user)
 }
 
@@ -137,6 +142,7 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	requireNonce := false
 	if params.Password != nil {
 		if config.Security.UpdatePasswordRequireReauthentication {
 			now := time.Now()
@@ -145,9 +151,7 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 				if len(params.Nonce) == 0 {
 					return badRequestError(ErrorCodeReauthenticationNeeded, "Password update requires reauthentication")
 				}
-				if err := a.verifyReauthentication(params.Nonce, db, config, user); err != nil {
-					return err
-				}
+				requireNonce = true
 			}
 		}
 
@@ -182,6 +186,14 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 				sessionID = &session.ID
 			}
 
+			// the nonce is consumed in the same transaction so that it
+			// stays valid if the update fails
+			if requireNonce {
+				if terr = a.verifyReauthentication(params.Nonce, tx, config, user); terr != nil {
+					return terr
+				}
+			}
+
 			if terr = user.UpdatePassword(tx, sessionID); terr != nil {
 				return internalServerError("Error during password storage").WithInternalError(terr)
 			}
@@ -212,11 +224,11 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 				}
 
 			}
-			if terr = a.sendEmailChange(r, tx, user, params.Email, flowType); terr != nil {
+			if terr = a.handleEmailSendLimit(r, "user", a.sendEmailChange(r, tx, user, params.Email, flowType)); terr != nil {
 				if errors.Is(terr, MaxFrequencyLimitError) {
 					return tooManyRequestsError(ErrorCodeOverEmailSendRateLimit, generateFrequencyLimitErrorMessage(user.EmailChangeSentAt, config.SMTP.MaxFrequency))
 				}
-				return internalServerError("Error sending change email").WithInternalError(terr)
+				return mailerError("Error sending change email", terr)
 			}
 		}
 
@@ -235,6 +247,12 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 					return internalServerError("Error finding SMS provider").WithInternalError(terr)
 				}
 				if _, terr := a.sendPhoneConfirmation(r, tx, user, params.Phone, phoneChangeVerification, smsProvider, params.Channel); terr != nil {
+					if errors.Is(terr, MaxFrequencyLimitError) {
+						return tooManyRequestsError(ErrorCodeOverSMSSendRateLimit, generateFrequencyLimitErrorMessage(user.PhoneChangeSentAt, config.Sms.MaxFrequency))
+					}
+					if isFeatureDisabledError(terr) {
+						return terr
+					}
 					return internalServerError("Error sending phone change otp").WithInternalError(terr)
 				}
 			}
@@ -243,6 +261,7 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 		if terr = models.NewAuditLogEntry(r, tx, user, models.UserModifiedAction, "", nil); terr != nil {
 			return internalServerError("Error recording audit log entry").WithInternalError(terr)
 		}
+		a.notifyWebhook(r, tx, conf.WebhookUserUpdatedEvent, user)
 
 		return nil
 	})
@@ -318,6 +318,57 @@ func (ts *UserTestSuite) TestUserUpdatePassword() {
 	}
 }
 
+func (ts *UserTestSuite) TestUserUpdatePasswordWithReauthenticationNonce() {
+	ts.Config.Security.UpdatePasswordRequireReauthentication = true
+
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	r, err := models.GrantAuthenticatedUser(ts.API.db, u, models.GrantParams{})
+	require.NoError(ts.T(), err)
+
+	session, err := models.FindSessionByID(ts.API.db, *r.SessionId, false)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.RawQuery(
+		"update "+session.TableName()+" set created_at = ? where id = ?",
+		time.Now().Add(-24*time.Hour),
+		session.ID).Exec(),
+	)
+
+	nonce := "123456"
+	now := time.Now()
+	u.ReauthenticationToken = crypto.GenerateTokenHash(u.GetEmail(), nonce)
+	u.ReauthenticationSentAt = &now
+	require.NoError(ts.T(), ts.API.db.UpdateOnly(u, "reauthentication_token", "reauthentication_sent_at"))
+
+	token := ts.generateToken(u, r.SessionId)
+	updatePassword := func(password string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]string{"password": password, "nonce": nonce}))
+
+		req := httptest.NewRequest(http.MethodPut, "http://localhost/user", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := updatePassword("newpassword123")
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	// the nonce is single use
+	w = updatePassword("newpassword456")
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+
+	u, err = models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	isAuthenticated, _, err := u.Authenticate(context.Background(), "newpassword123", ts.API.config.Security.DBEncryption.DecryptionKeys, ts.API.config.Security.DBEncryption.Encrypt, ts.API.config.Security.DBEncryption.EncryptionKeyID)
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), isAuthenticated)
+}
+
 func (ts *UserTestSuite) TestUserUpdatePasswordNoReauthenticationRequired() {
 	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
 	require.NoError(ts.T(), err)
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"net/http"
 	"net/url"
@@ -13,6 +14,7 @@ import (
 	"github.com/sethvargo/go-password/password"
 	"github.com/supabase/auth/internal/api/provider"
 	"github.com/supabase/auth/internal/api/sms_provider"
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/crypto"
 	mail "github.com/supabase/auth/internal/mailer"
 	"github.com/supabase/auth/internal/models"
@@ -24,6 +26,8 @@ import (
 const (
 	smsVerification         = "sms"
 	phoneChangeVerification = "phone_change"
+	// emailOtpVerification is accepted as an alias of the "email" type
+	emailOtpVerification = "email_otp"
 	// includes signupVerification and magicLinkVerification
 )
 
@@ -43,6 +47,10 @@ type VerifyParams struct {
 	Email      string `json:"email"`
 	Phone      string `json:"phone"`
 	RedirectTo string `json:"redirect_to"`
+
+	// Password is only accepted when verifying an invite, so that the
+	// invitee can choose their password as part of accepting it.
+	Password string `json:"password"`
 }
 
 func (p *VerifyParams) Validate(r *http.Request) error {
@@ -50,6 +58,9 @@ func (p *VerifyParams) Validate(r *http.Request) error {
 	if p.Type == "" {
 		return badRequestError(ErrorCodeValidationFailed, "Verify requires a verification type")
 	}
+	if p.Type == emailOtpVerification {
+		p.Type = mail.EmailOTPVerification
+	}
 	switch r.Method {
 	case http.MethodGet:
 		if p.Token == "" {
@@ -58,10 +69,15 @@ func (p *VerifyParams) Validate(r *http.Request) error {
 		// TODO: deprecate the token query param from GET /verify and use token_hash instead (breaking change)
 		p.TokenHash = p.Token
 	case http.MethodPost:
+		if p.Password != "" && p.Type != mail.InviteVerification {
+			return badRequestError(ErrorCodeValidationFailed, "A password can only be provided when verifying an invite")
+		}
 		if (p.Token == "" && p.TokenHash == "") || (p.Token != "" && p.TokenHash != "") {
 			return badRequestError(ErrorCodeValidationFailed, "Verify requires either a token or a token hash")
 		}
 		if p.Token != "" {
+			// alphanumeric OTPs are upper case, but are accepted in any case
+			p.Token = strings.ToUpper(p.Token)
 			if isPhoneOtpVerification(p) {
 				p.Phone, err = validatePhone(p.Phone)
 				if err != nil {
@@ -95,7 +111,7 @@ func (a *API) Verify(w http.ResponseWriter, r *http.Request) error {
 	case http.MethodGet:
 		params.Token = r.FormValue("token")
 		params.Type = r.FormValue("type")
-		params.RedirectTo = utilities.GetReferrer(r, a.config)
+		params.RedirectTo = utilities.GetReferrer(r, a.getConfig(r.Context()))
 		if err := params.Validate(r); err != nil {
 			return err
 		}
@@ -148,7 +164,7 @@ func (a *API) verifyGet(w http.ResponseWriter, r *http.Request, params *VerifyPa
 		}
 		switch params.Type {
 		case mail.SignupVerification, mail.InviteVerification:
-			user, terr = a.signupVerify(r, ctx, tx, user)
+			user, terr = a.signupVerify(r, ctx, tx, user, "")
 		case mail.RecoveryVerification, mail.MagicLinkVerification:
 			user, terr = a.recoverVerify(r, tx, user)
 		case mail.EmailChangeVerification:
@@ -238,6 +254,14 @@ func (a *API) verifyPost(w http.ResponseWriter, r *http.Request, params *VerifyP
 
 	grantParams.FillGrantParams(r)
 
+	if params.Password != "" {
+		// checked outside of the transaction as it may reach out to
+		// HaveIBeenPwned.org
+		if err := a.checkPasswordStrength(ctx, params.Password); err != nil {
+			return err
+		}
+	}
+
 	err := db.Transaction(func(tx *storage.Connection) error {
 		var terr error
 		aud := a.requestAud(ctx, r)
@@ -245,7 +269,7 @@ func (a *API) verifyPost(w http.ResponseWriter, r *http.Request, params *VerifyP
 		if isUsingTokenHash(params) {
 			user, terr = a.verifyTokenHash(tx, params)
 		} else {
-			user, terr = a.verifyUserAndToken(tx, params, aud)
+			user, terr = a.verifyUserAndToken(ctx, tx, params, aud)
 		}
 		if terr != nil {
 			return terr
@@ -253,7 +277,7 @@ func (a *API) verifyPost(w http.ResponseWriter, r *http.Request, params *VerifyP
 
 		switch params.Type {
 		case mail.SignupVerification, mail.InviteVerification:
-			user, terr = a.signupVerify(r, ctx, tx, user)
+			user, terr = a.signupVerify(r, ctx, tx, user, params.Password)
 		case mail.RecoveryVerification, mail.MagicLinkVerification:
 			user, terr = a.recoverVerify(r, tx, user)
 		case mail.EmailChangeVerification:
@@ -303,19 +327,30 @@ func (a *API) verifyPost(w http.ResponseWriter, r *http.Request, params *VerifyP
 	return sendJSON(w, http.StatusOK, token)
 }
 
-func (a *API) signupVerify(r *http.Request, ctx context.Context, conn *storage.Connection, user *models.User) (*models.User, error) {
+// signupVerify confirms a user. Invited users without a password get the
+// provided password, or a random one if the invitee did not choose one.
+func (a *API) signupVerify(r *http.Request, ctx context.Context, conn *storage.Connection, user *models.User, newPassword string) (*models.User, error) {
 	config := a.config
 
+	if user.InvitedAt != nil {
+		if err := a.checkEmailDomain(user.GetEmail()); err != nil {
+			return nil, err
+		}
+	}
+
 	if user.EncryptedPassword == "" && user.InvitedAt != nil {
-		// sign them up with temporary password, and require application
-		// to present the user with a password set form
-		password, err := password.Generate(64, 10, 0, false, true)
-		if err != nil {
-			// password generation must succeed
-			panic(err)
+		if newPassword == "" {
+			// sign them up with temporary password, and require application
+			// to present the user with a password set form
+			var err error
+			newPassword, err = password.Generate(64, 10, 0, false, true)
+			if err != nil {
+				// password generation must succeed
+				panic(err)
+			}
 		}
 
-		if err := user.SetPassword(ctx, password, config.Security.DBEncryption.Encrypt, config.Security.DBEncryption.EncryptionKeyID, config.Security.DBEncryption.EncryptionKey); err != nil {
+		if err := user.SetPassword(ctx, newPassword, config.Security.DBEncryption.Encrypt, config.Security.DBEncryption.EncryptionKeyID, config.Security.DBEncryption.EncryptionKey); err != nil {
 			return nil, err
 		}
 	}
@@ -361,6 +396,7 @@ func (a *API) recoverVerify(r *http.Request, conn *storage.Connection, user *mod
 			if terr = models.NewAuditLogEntry(r, tx, user, models.LoginAction, "", nil); terr != nil {
 				return terr
 			}
+			a.notifyWebhook(r, tx, conf.WebhookLoginEvent, user)
 		}
 		return nil
 	})
@@ -383,9 +419,17 @@ func (a *API) smsVerify(r *http.Request, conn *storage.Connection, user *models.
 				return internalServerError("Error confirming user").WithInternalError(terr)
 			}
 		} else if params.Type == phoneChangeVerification {
+			// the new number may have been taken by another user since the
+			// change was requested
+			if exists, terr := models.IsDuplicatedPhone(tx, user.PhoneChange, user.Aud); terr != nil {
+				return internalServerError("Database error checking phone").WithInternalError(terr)
+			} else if exists {
+				return unprocessableEntityError(ErrorCodePhoneExists, DuplicatePhoneMsg)
+			}
 			if terr := models.NewAuditLogEntry(r, tx, user, models.UserModifiedAction, "", nil); terr != nil {
 				return terr
 			}
+			a.notifyWebhook(r, tx, conf.WebhookUserUpdatedEvent, user)
 			if identity, terr := models.FindIdentityByIdAndProvider(tx, user.ID.String(), "phone"); terr != nil {
 				if !models.IsNotFoundError(terr) {
 					return terr
@@ -393,14 +437,14 @@ func (a *API) smsVerify(r *http.Request, conn *storage.Connection, user *models.
 				// confirming the phone change should create a new phone identity if the user doesn't have one
 				if _, terr = a.createNewIdentity(tx, user, "phone", structs.Map(provider.Claims{
 					Subject:       user.ID.String(),
-					Phone:         params.Phone,
+					Phone:         user.PhoneChange,
 					PhoneVerified: true,
 				})); terr != nil {
 					return terr
 				}
 			} else {
 				if terr := identity.UpdateIdentityData(tx, map[string]interface{}{
-					"phone":          params.Phone,
+					"phone":          user.PhoneChange,
 					"phone_verified": true,
 				}); terr != nil {
 					return terr
@@ -447,9 +491,11 @@ func (a *API) prepErrorRedirectURL(err *HTTPError, r *http.Request, rurl string,
 		q.Set("error", str)
 	}
 	hq.Set("error_code", strconv.Itoa(err.HTTPStatus))
+	hq.Set("error_reason", err.code())
 	hq.Set("error_description", err.Message)
 
 	q.Set("error_code", strconv.Itoa(err.HTTPStatus))
+	q.Set("error_reason", err.code())
 	q.Set("error_description", err.Message)
 	if flowType == models.PKCEFlow {
 		// Additionally, may override existing error query param if set to PKCE.
@@ -489,6 +535,14 @@ func (a *API) prepPKCERedirectURL(rurl, code string) (string, error) {
 
 func (a *API) emailChangeVerify(r *http.Request, conn *storage.Connection, params *VerifyParams, user *models.User) (*models.User, error) {
 	config := a.config
+
+	// the new email may have been taken by another user since the change
+	// was requested
+	if duplicateUser, err := models.IsDuplicatedEmail(conn, user.EmailChange, user.Aud, user); err != nil {
+		return nil, internalServerError("Database error checking email").WithInternalError(err)
+	} else if duplicateUser != nil {
+		return nil, unprocessableEntityError(ErrorCodeEmailExists, DuplicateEmailMsg)
+	}
 	if config.Mailer.SecureEmailChangeEnabled && user.EmailChangeConfirmStatus == zeroConfirmation && user.GetEmail() != "" {
 		err := conn.Transaction(func(tx *storage.Connection) error {
 			currentOTT, terr := models.FindOneTimeToken(tx, params.TokenHash, models.EmailChangeTokenCurrent)
@@ -530,6 +584,7 @@ func (a *API) emailChangeVerify(r *http.Request, conn *storage.Connection, param
 		if terr := models.NewAuditLogEntry(r, tx, user, models.UserModifiedAction, "", nil); terr != nil {
 			return terr
 		}
+		a.notifyWebhook(r, tx, conf.WebhookUserUpdatedEvent, user)
 
 		if identity, terr := models.FindIdentityByIdAndProvider(tx, user.ID.String(), "email"); terr != nil {
 			if !models.IsNotFoundError(terr) {
@@ -612,13 +667,13 @@ func (a *API) verifyTokenHash(conn *storage.Connection, params *VerifyParams) (*
 			sentAt = user.RecoverySentAt
 			params.Type = "magiclink"
 		}
-		isExpired = isOtpExpired(sentAt, config.Mailer.OtpExp)
+		isExpired = isOtpExpired(sentAt, config.Mailer.OtpExp, a.Now())
 	case mail.SignupVerification, mail.InviteVerification:
-		isExpired = isOtpExpired(user.ConfirmationSentAt, config.Mailer.OtpExp)
+		isExpired = isOtpExpired(user.ConfirmationSentAt, config.Mailer.OtpExp, a.Now())
 	case mail.RecoveryVerification, mail.MagicLinkVerification:
-		isExpired = isOtpExpired(user.RecoverySentAt, config.Mailer.OtpExp)
+		isExpired = isOtpExpired(user.RecoverySentAt, config.Mailer.OtpExp, a.Now())
 	case mail.EmailChangeVerification:
-		isExpired = isOtpExpired(user.EmailChangeSentAt, config.Mailer.OtpExp)
+		isExpired = isOtpExpired(user.EmailChangeSentAt, config.Mailer.OtpExp, a.Now())
 	}
 
 	if isExpired {
@@ -629,8 +684,8 @@ func (a *API) verifyTokenHash(conn *storage.Connection, params *VerifyParams) (*
 }
 
 // verifyUserAndToken verifies the token associated to the user based on the verify type
-func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams, aud string) (*models.User, error) {
-	config := a.config
+func (a *API) verifyUserAndToken(ctx context.Context, conn *storage.Connection, params *VerifyParams, aud string) (*models.User, error) {
+	config := a.getConfig(ctx)
 
 	var user *models.User
 	var err error
@@ -651,7 +706,7 @@ func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams,
 
 	if err != nil {
 		if models.IsNotFoundError(err) {
-			return nil, forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalError(err)
+			return nil, forbiddenError(ErrorCodeOTPInvalid, "Token has expired or is invalid").WithInternalError(err)
 		}
 		return nil, internalServerError("Database error finding user").WithInternalError(err)
 	}
@@ -660,28 +715,30 @@ func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams,
 		return nil, forbiddenError(ErrorCodeUserBanned, "User is banned")
 	}
 
-	var isValid bool
+	var status otpStatus
+	now := a.Now()
 
 	smsProvider, _ := sms_provider.GetSmsProvider(*config)
 	switch params.Type {
 	case mail.EmailOTPVerification:
 		// if the type is emailOTPVerification, we'll check both the confirmation_token and recovery_token columns
-		if isOtpValid(tokenHash, user.ConfirmationToken, user.ConfirmationSentAt, config.Mailer.OtpExp) {
-			isValid = true
+		confirmation := checkOtp(tokenHash, user.ConfirmationToken, user.ConfirmationSentAt, config.Mailer.OtpExp, now)
+		recovery := checkOtp(tokenHash, user.RecoveryToken, user.RecoverySentAt, config.Mailer.OtpExp, now)
+		if confirmation == otpValid {
 			params.Type = mail.SignupVerification
-		} else if isOtpValid(tokenHash, user.RecoveryToken, user.RecoverySentAt, config.Mailer.OtpExp) {
-			isValid = true
+		} else if recovery == otpValid {
 			params.Type = mail.MagicLinkVerification
-		} else {
-			isValid = false
 		}
+		status = max(confirmation, recovery)
 	case mail.SignupVerification, mail.InviteVerification:
-		isValid = isOtpValid(tokenHash, user.ConfirmationToken, user.ConfirmationSentAt, config.Mailer.OtpExp)
+		status = checkOtp(tokenHash, user.ConfirmationToken, user.ConfirmationSentAt, config.Mailer.OtpExp, now)
 	case mail.RecoveryVerification, mail.MagicLinkVerification:
-		isValid = isOtpValid(tokenHash, user.RecoveryToken, user.RecoverySentAt, config.Mailer.OtpExp)
+		status = checkOtp(tokenHash, user.RecoveryToken, user.RecoverySentAt, config.Mailer.OtpExp, now)
 	case mail.EmailChangeVerification:
-		isValid = isOtpValid(tokenHash, user.EmailChangeTokenCurrent, user.EmailChangeSentAt, config.Mailer.OtpExp) ||
-			isOtpValid(tokenHash, user.EmailChangeTokenNew, user.EmailChangeSentAt, config.Mailer.OtpExp)
+		status = max(
+			checkOtp(tokenHash, user.EmailChangeTokenCurrent, user.EmailChangeSentAt, config.Mailer.OtpExp, now),
+			checkOtp(tokenHash, user.EmailChangeTokenNew, user.EmailChangeSentAt, config.Mailer.OtpExp, now),
+		)
 	case phoneChangeVerification, smsVerification:
 		phone := params.Phone
 		sentAt := user.ConfirmationSentAt
@@ -691,7 +748,10 @@ func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams,
 			sentAt = user.PhoneChangeSentAt
 			expectedToken = user.PhoneChangeToken
 		}
-		if config.Sms.IsTwilioVerifyProvider() {
+		// codes sent through Twilio Verify are checked by Twilio, the
+		// others such as test OTPs or codes sent by the Send SMS hook
+		// are checked against their hash
+		if config.Sms.IsTwilioVerifyProvider() && expectedToken == "" {
 			if testOTP, ok := config.Sms.GetTestOTP(params.Phone, time.Now()); ok {
 				if params.Token == testOTP {
 					return user, nil
@@ -702,25 +762,128 @@ func (a *API) verifyUserAndToken(conn *storage.Connection, params *VerifyParams,
 			}
 			return user, nil
 		}
-		isValid = isOtpValid(tokenHash, expectedToken, sentAt, config.Sms.OtpExp)
+		status = checkOtp(tokenHash, expectedToken, sentAt, config.Sms.OtpExp, now)
 	}
 
-	if !isValid {
-		return nil, forbiddenError(ErrorCodeOTPExpired, "Token has expired or is invalid").WithInternalMessage("token has expired or is invalid")
+	switch status {
+	case otpValid:
+		if err := a.clearWrongOtps(ctx, conn, user, params.Type); err != nil {
+			return nil, err
+		}
+		return user, nil
+	case otpExpired:
+		return nil, forbiddenError(ErrorCodeOTPExpired, "Token has expired").WithInternalMessage("token has expired")
+	default:
+		return nil, a.recordWrongOtp(ctx, conn, user, params.Type)
 	}
-	return user, nil
 }
 
-// isOtpValid checks the actual otp sent against the expected otp and ensures that it's within the valid window
-func isOtpValid(actual, expected string, sentAt *time.Time, otpExp uint) bool {
+// otpAttemptLimit returns the key counting the wrong OTPs of the
+// verification type and how many are allowed.
+func (a *API) otpAttemptLimit(ctx context.Context, user *models.User, verificationType string) (key string, maxAttempts int, window time.Duration) {
+	config := a.getConfig(ctx)
+
+	if verificationType == smsVerification || verificationType == phoneChangeVerification {
+		return models.OtpAttemptKey(user.ID, "phone"), config.Sms.OtpMaxAttempts, time.Duration(config.Sms.OtpExp) * time.Second
+	}
+	return models.OtpAttemptKey(user.ID, "email"), config.Mailer.OtpMaxAttempts, time.Duration(config.Mailer.OtpExp) * time.Second
+}
+
+// recordWrongOtp counts a wrong OTP entered for the user and returns the
+// error to respond with. Once the limit is reached the user's OTPs sent
+// through the same channel are invalidated, so that they can't be guessed.
+// The count is committed although the verification fails.
+func (a *API) recordWrongOtp(ctx context.Context, tx *storage.Connection, user *models.User, verificationType string) error {
+	invalid := forbiddenError(ErrorCodeOTPInvalid, "Token has expired or is invalid").WithInternalMessage("token is invalid")
+
+	key, maxAttempts, window := a.otpAttemptLimit(ctx, user, verificationType)
+	if maxAttempts == 0 {
+		return invalid
+	}
+
+	attempt, err := models.RecordFailedLogin(tx, key, window)
+	if err != nil {
+		return internalServerError("Database error counting wrong OTP").WithInternalError(err)
+	}
+
+	if attempt.FailedCount < maxAttempts {
+		return storage.NewCommitWithError(invalid)
+	}
+
+	if verificationType == smsVerification || verificationType == phoneChangeVerification {
+		err = user.InvalidatePhoneOtps(tx)
+	} else {
+		err = user.InvalidateEmailOtps(tx)
+	}
+	if err != nil {
+		return internalServerError("Database error invalidating OTPs").WithInternalError(err)
+	}
+
+	if err := models.ClearLoginAttempts(tx, key); err != nil {
+		return internalServerError("Database error counting wrong OTP").WithInternalError(err)
+	}
+
+	return storage.NewCommitWithError(forbiddenError(ErrorCodeOTPAttemptsExceeded, "Too many wrong attempts, request a new token").WithInternalMessage("OTPs invalidated after %d wrong attempts", attempt.FailedCount))
+}
+
+// clearWrongOtps forgets the wrong OTPs entered for the user once the right
+// one is entered.
+func (a *API) clearWrongOtps(ctx context.Context, tx *storage.Connection, user *models.User, verificationType string) error {
+	key, maxAttempts, _ := a.otpAttemptLimit(ctx, user, verificationType)
+	if maxAttempts == 0 {
+		return nil
+	}
+
+	if err := models.ClearLoginAttempts(tx, key); err != nil {
+		return internalServerError("Database error counting wrong OTP").WithInternalError(err)
+	}
+
+	return nil
+}
+
+// otpStatus is the outcome of checking an OTP, ordered so that the best
+// outcome of several checks is their max.
+type otpStatus int
+
+const (
+	otpInvalid otpStatus = iota
+	otpExpired
+	otpValid
+)
+
+// checkOtp checks the hash of the OTP sent against the expected hash and
+// whether it's within the valid window. The hashes are compared in constant
+// time. Only the hash is compared, so OTPs sent before a change of their
+// length or alphabet keep working.
+func checkOtp(actual, expected string, sentAt *time.Time, otpExp uint, now time.Time) otpStatus {
 	if expected == "" || sentAt == nil {
-		return false
+		return otpInvalid
 	}
-	return !isOtpExpired(sentAt, otpExp) && ((actual == expected) || ("pkce_"+actual == expected))
+	if subtle.ConstantTimeCompare([]byte(actual), []byte(expected)) != 1 &&
+		subtle.ConstantTimeCompare([]byte("pkce_"+actual), []byte(expected)) != 1 {
+		return otpInvalid
+	}
+	if isOtpExpired(sentAt, otpExp, now) {
+		return otpExpired
+	}
+	return otpValid
+}
+
+// isOtpValid checks the actual otp sent against the expected otp and ensures that it's within the valid window
+func isOtpValid(actual, expected string, sentAt *time.Time, otpExp uint, now time.Time) bool {
+	return checkOtp(actual, expected, sentAt, otpExp, now) == otpValid
+}
+
+func isOtpExpired(sentAt *time.Time, otpExp uint, now time.Time) bool {
+	return now.After(sentAt.Add(time.Second * time.Duration(otpExp)))
 }
 
-func isOtpExpired(sentAt *time.Time, otpExp uint) bool {
-	return time.Now().After(sentAt.Add(time.Second * time.Duration(otpExp)))
+// generateOtp generates a random OTP of length characters out of alphabet.
+func generateOtp(length int, alphabet conf.OtpAlphabet) (string, error) {
+	if alphabet == conf.OtpAlphabetAlphanumeric {
+		return crypto.GenerateAlphanumericOtp(length)
+	}
+	return crypto.GenerateOtp(length)
 }
 
 // isPhoneOtpVerification checks if the verification came from a phone otp
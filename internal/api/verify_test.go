@@ -18,6 +18,7 @@ import (
 	"github.com/stretchr/testify/suite"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/hooks"
 	"github.com/supabase/auth/internal/models"
 )
 
@@ -277,6 +278,99 @@ func (ts *VerifyTestSuite) TestVerifySecureEmailChange() {
 	}
 }
 
+func (ts *VerifyTestSuite) requestEmailChange(u *models.User, email string) {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email": email,
+	}))
+
+	req := httptest.NewRequest(http.MethodPut, "http://localhost/user", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	session, err := models.NewSession(u.ID, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(session))
+
+	token, _, err := ts.API.generateAccessToken(req, ts.API.db, u, &session.ID, models.PasswordGrant)
+	require.NoError(ts.T(), err)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+}
+
+func (ts *VerifyTestSuite) TestVerifyEmailChangeSingleConfirmation() {
+	ts.Config.Mailer.SecureEmailChangeEnabled = false
+	defer func() {
+		ts.Config.Mailer.SecureEmailChangeEnabled = true
+	}()
+
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	ts.requestEmailChange(u, "single@example.com")
+
+	// the email is not changed until the new address is confirmed
+	require.NoError(ts.T(), ts.API.db.Reload(u))
+	require.Equal(ts.T(), "test@example.com", u.GetEmail())
+	require.Equal(ts.T(), "single@example.com", u.EmailChange)
+	require.Empty(ts.T(), u.EmailChangeTokenCurrent)
+	require.NotEmpty(ts.T(), u.EmailChangeTokenNew)
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":       mail.EmailChangeVerification,
+		"token_hash": u.EmailChangeTokenNew,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	require.NoError(ts.T(), ts.API.db.Reload(u))
+	require.Equal(ts.T(), "single@example.com", u.GetEmail())
+	require.Empty(ts.T(), u.EmailChange)
+}
+
+func (ts *VerifyTestSuite) TestVerifyEmailChangeTakenByAnotherUser() {
+	ts.Config.Mailer.SecureEmailChangeEnabled = false
+	defer func() {
+		ts.Config.Mailer.SecureEmailChangeEnabled = true
+	}()
+
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	ts.requestEmailChange(u, "taken@example.com")
+	require.NoError(ts.T(), ts.API.db.Reload(u))
+
+	// another user claims the new address before the change is confirmed
+	other, err := models.NewUser("", "taken@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.db.Create(other))
+	_, err = ts.API.createNewIdentity(ts.API.db, other, "email", map[string]interface{}{
+		"sub":   other.ID.String(),
+		"email": "taken@example.com",
+	})
+	require.NoError(ts.T(), err)
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":       mail.EmailChangeVerification,
+		"token_hash": u.EmailChangeTokenNew,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	require.NoError(ts.T(), ts.API.db.Reload(u))
+	require.Equal(ts.T(), "test@example.com", u.GetEmail())
+}
+
 func (ts *VerifyTestSuite) TestExpiredConfirmationToken() {
 	// verify variant testing not necessary in this test as it's testing
 	// the ConfirmationSentAt behavior, not the ConfirmationToken behavior
@@ -305,6 +399,7 @@ func (ts *VerifyTestSuite) TestExpiredConfirmationToken() {
 	f, err := url.ParseQuery(rurl.Fragment)
 	require.NoError(ts.T(), err)
 	assert.Equal(ts.T(), "403", f.Get("error_code"))
+	assert.Equal(ts.T(), ErrorCodeOTPExpired, f.Get("error_reason"))
 	assert.Equal(ts.T(), "Email link is invalid or has expired", f.Get("error_description"))
 	assert.Equal(ts.T(), "access_denied", f.Get("error"))
 }
@@ -313,7 +408,7 @@ func (ts *VerifyTestSuite) TestInvalidOtp() {
 	u, err := models.FindUserByPhoneAndAudience(ts.API.db, "12345678", ts.Config.JWT.Aud)
 	require.NoError(ts.T(), err)
 	sentTime := time.Now().Add(-48 * time.Hour)
-	u.ConfirmationToken = "123456"
+	u.ConfirmationToken = crypto.GenerateTokenHash(u.GetPhone(), "123456")
 	u.ConfirmationSentAt = &sentTime
 	u.PhoneChange = "22222222"
 	u.PhoneChangeToken = "123456"
@@ -323,13 +418,15 @@ func (ts *VerifyTestSuite) TestInvalidOtp() {
 	require.NoError(ts.T(), models.CreateOneTimeToken(ts.API.db, u.ID, u.PhoneChange, u.PhoneChangeToken, models.PhoneChangeToken))
 
 	type ResponseBody struct {
-		Code int    `json:"code"`
-		Msg  string `json:"msg"`
+		Code      int    `json:"code"`
+		ErrorCode string `json:"error_code"`
+		Msg       string `json:"msg"`
 	}
 
 	expectedResponse := ResponseBody{
-		Code: http.StatusForbidden,
-		Msg:  "Token has expired or is invalid",
+		Code:      http.StatusForbidden,
+		ErrorCode: ErrorCodeOTPInvalid,
+		Msg:       "Token has expired or is invalid",
 	}
 
 	cases := []struct {
@@ -343,10 +440,14 @@ func (ts *VerifyTestSuite) TestInvalidOtp() {
 			sentTime: time.Now().Add(-48 * time.Hour),
 			body: map[string]interface{}{
 				"type":  smsVerification,
-				"token": u.ConfirmationToken,
+				"token": "123456",
 				"phone": u.GetPhone(),
 			},
-			expected: expectedResponse,
+			expected: ResponseBody{
+				Code:      http.StatusForbidden,
+				ErrorCode: ErrorCodeOTPExpired,
+				Msg:       "Token has expired",
+			},
 		},
 		{
 			desc:     "Invalid SMS OTP",
@@ -406,12 +507,169 @@ func (ts *VerifyTestSuite) TestInvalidOtp() {
 			err = json.Unmarshal(b, &resp)
 			require.NoError(ts.T(), err)
 			assert.Equal(ts.T(), c.expected.Code, resp.Code)
+			assert.Equal(ts.T(), c.expected.ErrorCode, resp.ErrorCode)
 			assert.Equal(ts.T(), c.expected.Msg, resp.Msg)
 
 		})
 	}
 }
 
+// sendEmailOtp requests an email OTP for test@example.com, once confirmed,
+// and returns the OTP that was sent.
+func (ts *VerifyTestSuite) sendEmailOtp() string {
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	u.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Update(u))
+
+	var payloads []hooks.SendEmailInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload hooks.SendEmailInput
+		require.NoError(ts.T(), json.NewDecoder(r.Body).Decode(&payload))
+		payloads = append(payloads, payload)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	mailer, hook := ts.Config.Mailer, ts.Config.Hook.SendEmail
+	defer func() {
+		ts.Config.Mailer, ts.Config.Hook.SendEmail = mailer, hook
+	}()
+	ts.Config.Mailer.Provider = "hook"
+	ts.Config.Hook.SendEmail = conf.ExtensibilityPointConfiguration{
+		Enabled:         true,
+		URI:             server.URL,
+		HTTPHookSecrets: conf.HTTPHookSecrets{"v1,whsec_aWxpa2VzdXBhYmFzZXZlcnltdWNoYW5kaWhvcGV5b3Vkb3Rvbw=="},
+	}
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email": "test@example.com",
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/otp", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	require.Len(ts.T(), payloads, 1)
+	require.NotEmpty(ts.T(), payloads[0].EmailData.Token)
+	return payloads[0].EmailData.Token
+}
+
+func (ts *VerifyTestSuite) verifyEmailOtp(otp string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"type":  mail.EmailOTPVerification,
+		"email": "test@example.com",
+		"token": otp,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *VerifyTestSuite) requireVerifyError(w *httptest.ResponseRecorder, errorCode ErrorCode) {
+	require.Equal(ts.T(), http.StatusForbidden, w.Code, w.Body.String())
+
+	data := &HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(data))
+	require.Equal(ts.T(), errorCode, data.ErrorCode)
+}
+
+// wrongOtp returns an OTP other than otp.
+func wrongOtp(otp string) string {
+	if otp == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func (ts *VerifyTestSuite) TestVerifyEmailOtpCode() {
+	otp := ts.sendEmailOtp()
+
+	// only the hash of the OTP is stored
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), crypto.GenerateTokenHash("test@example.com", otp), u.RecoveryToken)
+	require.NotContains(ts.T(), u.RecoveryToken, otp)
+
+	ott, err := models.FindOneTimeToken(ts.API.db, u.RecoveryToken, models.RecoveryToken)
+	require.NoError(ts.T(), err)
+	require.NotEqual(ts.T(), otp, ott.TokenHash)
+
+	// wrong attempts below the limit don't invalidate the OTP
+	for i := 1; i < ts.Config.Mailer.OtpMaxAttempts; i++ {
+		ts.requireVerifyError(ts.verifyEmailOtp(wrongOtp(otp)), ErrorCodeOTPInvalid)
+	}
+
+	w := ts.verifyEmailOtp(otp)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	resp := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	require.NotEmpty(ts.T(), resp.Token)
+
+	// the wrong attempts are forgotten once the OTP was entered
+	var attempts []models.LoginAttempt
+	require.NoError(ts.T(), ts.API.db.Where("key = ?", models.OtpAttemptKey(u.ID, "email")).All(&attempts))
+	require.Empty(ts.T(), attempts)
+
+	// the OTP can only be used once
+	ts.requireVerifyError(ts.verifyEmailOtp(otp), ErrorCodeOTPInvalid)
+}
+
+func (ts *VerifyTestSuite) TestVerifyEmailOtpBannedUser() {
+	otp := ts.sendEmailOtp()
+
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), u.Ban(ts.API.db, time.Hour))
+
+	ts.requireVerifyError(ts.verifyEmailOtp(otp), ErrorCodeUserBanned)
+}
+
+func (ts *VerifyTestSuite) TestVerifyEmailOtpAttemptsExceeded() {
+	otp := ts.sendEmailOtp()
+
+	for i := 1; i < ts.Config.Mailer.OtpMaxAttempts; i++ {
+		ts.requireVerifyError(ts.verifyEmailOtp(wrongOtp(otp)), ErrorCodeOTPInvalid)
+	}
+	ts.requireVerifyError(ts.verifyEmailOtp(wrongOtp(otp)), ErrorCodeOTPAttemptsExceeded)
+
+	// the OTP and the link with it are invalidated
+	ts.requireVerifyError(ts.verifyEmailOtp(otp), ErrorCodeOTPInvalid)
+
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.Empty(ts.T(), u.RecoveryToken)
+
+	_, err = models.FindOneTimeToken(ts.API.db, crypto.GenerateTokenHash("test@example.com", otp), models.RecoveryToken)
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *VerifyTestSuite) TestVerifyEmailOtpAttemptsUnlimited() {
+	maxAttempts := ts.Config.Mailer.OtpMaxAttempts
+	defer func() {
+		ts.Config.Mailer.OtpMaxAttempts = maxAttempts
+	}()
+	ts.Config.Mailer.OtpMaxAttempts = 0
+
+	otp := ts.sendEmailOtp()
+	for i := 0; i < maxAttempts+1; i++ {
+		ts.requireVerifyError(ts.verifyEmailOtp(wrongOtp(otp)), ErrorCodeOTPInvalid)
+	}
+
+	w := ts.verifyEmailOtp(otp)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+}
+
 func (ts *VerifyTestSuite) TestExpiredRecoveryToken() {
 	// verify variant testing not necessary in this test as it's testing
 	// the RecoverySentAt behavior, not the RecoveryToken behavior
@@ -435,6 +693,40 @@ func (ts *VerifyTestSuite) TestExpiredRecoveryToken() {
 	assert.Equal(ts.T(), http.StatusSeeOther, w.Code, w.Body.String())
 }
 
+func (ts *VerifyTestSuite) TestVerifyMagicLinkSingleUse() {
+	u, err := models.FindUserByEmailAndAudience(ts.API.db, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	tokenHash := crypto.GenerateTokenHash(u.GetEmail(), "123456")
+	sentTime := time.Now()
+	u.RecoveryToken = tokenHash
+	u.RecoverySentAt = &sentTime
+	require.NoError(ts.T(), ts.API.db.Update(u))
+	require.NoError(ts.T(), models.CreateOneTimeToken(ts.API.db, u.ID, u.GetEmail(), u.RecoveryToken, models.RecoveryToken))
+
+	reqURL := fmt.Sprintf("http://localhost/verify?type=%s&token=%s", mail.MagicLinkVerification, tokenHash)
+
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, reqURL, nil))
+	require.Equal(ts.T(), http.StatusSeeOther, w.Code)
+	rurl, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(ts.T(), err)
+	f, err := url.ParseQuery(rurl.Fragment)
+	require.NoError(ts.T(), err)
+	require.NotEmpty(ts.T(), f.Get("access_token"))
+
+	// the link can't be used again
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, reqURL, nil))
+	require.Equal(ts.T(), http.StatusSeeOther, w.Code)
+	rurl, err = url.Parse(w.Header().Get("Location"))
+	require.NoError(ts.T(), err)
+	f, err = url.ParseQuery(rurl.Fragment)
+	require.NoError(ts.T(), err)
+	require.Empty(ts.T(), f.Get("access_token"))
+	require.Equal(ts.T(), ErrorCodeOTPExpired, f.Get("error_reason"))
+}
+
 func (ts *VerifyTestSuite) TestVerifyPermitedCustomUri() {
 	// verify variant testing not necessary in this test as it's testing
 	// the redirect URL behavior, not the RecoveryToken behavior
@@ -809,6 +1101,7 @@ func (ts *VerifyTestSuite) TestVerifyBannedUser() {
 			f, err := url.ParseQuery(rurl.Fragment)
 			require.NoError(ts.T(), err)
 			assert.Equal(ts.T(), "403", f.Get("error_code"))
+			assert.Equal(ts.T(), ErrorCodeUserBanned, f.Get("error_reason"))
 		})
 	}
 }
@@ -845,6 +1138,19 @@ func (ts *VerifyTestSuite) TestVerifyValidOtp() {
 				tokenHash: crypto.GenerateTokenHash(u.GetPhone(), "123456"),
 			},
 		},
+		{
+			desc:     "Valid alphanumeric SMS OTP in lower case",
+			sentTime: time.Now(),
+			body: map[string]interface{}{
+				"type":  smsVerification,
+				"token": "abc234",
+				"phone": u.GetPhone(),
+			},
+			expected: expected{
+				code:      http.StatusOK,
+				tokenHash: crypto.GenerateTokenHash(u.GetPhone(), "ABC234"),
+			},
+		},
 		{
 			desc:     "Valid Confirmation OTP",
 			sentTime: time.Now(),
@@ -884,6 +1190,19 @@ func (ts *VerifyTestSuite) TestVerifyValidOtp() {
 				tokenHash: crypto.GenerateTokenHash(u.GetEmail(), "123456"),
 			},
 		},
+		{
+			desc:     "Valid Email OTP with the email_otp type",
+			sentTime: time.Now(),
+			body: map[string]interface{}{
+				"type":  emailOtpVerification,
+				"token": "123456",
+				"email": u.GetEmail(),
+			},
+			expected: expected{
+				code:      http.StatusOK,
+				tokenHash: crypto.GenerateTokenHash(u.GetEmail(), "123456"),
+			},
+		},
 		{
 			desc:     "Valid Email Change OTP",
 			sentTime: time.Now(),
@@ -1115,7 +1434,7 @@ func (ts *VerifyTestSuite) TestPrepRedirectURL() {
 
 func (ts *VerifyTestSuite) TestPrepErrorRedirectURL() {
 	const DefaultError = "Invalid redirect URL"
-	redirectError := fmt.Sprintf("error=invalid_request&error_code=400&error_description=%s", url.QueryEscape(DefaultError))
+	redirectError := fmt.Sprintf("error=invalid_request&error_code=400&error_description=%s&error_reason=validation_failed", url.QueryEscape(DefaultError))
 
 	cases := []struct {
 		desc     string
@@ -1237,3 +1556,32 @@ func (ts *VerifyTestSuite) TestVerifyValidateParams() {
 		})
 	}
 }
+
+func TestCheckOtp(t *testing.T) {
+	sentAt := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	hash := crypto.GenerateTokenHash("12345678", "123456")
+
+	cases := []struct {
+		desc     string
+		actual   string
+		expected string
+		now      time.Time
+		status   otpStatus
+	}{
+		{"valid", hash, hash, sentAt.Add(time.Minute), otpValid},
+		{"valid pkce", hash, "pkce_" + hash, sentAt.Add(time.Minute), otpValid},
+		{"valid at the end of the window", hash, hash, sentAt.Add(2 * time.Minute), otpValid},
+		{"expired just after the window", hash, hash, sentAt.Add(2*time.Minute + time.Nanosecond), otpExpired},
+		{"wrong", crypto.GenerateTokenHash("12345678", "654321"), hash, sentAt.Add(time.Minute), otpInvalid},
+		{"wrong and expired", crypto.GenerateTokenHash("12345678", "654321"), hash, sentAt.Add(time.Hour), otpInvalid},
+		{"none sent", hash, "", sentAt.Add(time.Minute), otpInvalid},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			require.Equal(t, c.status, checkOtp(c.actual, c.expected, &sentAt, 120, c.now))
+		})
+	}
+
+	require.Equal(t, otpInvalid, checkOtp(hash, hash, nil, 120, sentAt))
+}
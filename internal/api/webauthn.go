@@ -0,0 +1,459 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/supabase/auth/internal/api/webauthn"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/metering"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+// WebAuthnChallengeObject holds the options of the WebAuthn ceremony of a
+// challenge, which the client passes to navigator.credentials.create() when
+// the type is create, and to navigator.credentials.get() when it is get.
+type WebAuthnChallengeObject struct {
+	Type              string      `json:"type"`
+	CredentialOptions interface{} `json:"credential_options"`
+}
+
+// WebAuthnChallengeResponse is the challenge of a sign in with a passkey.
+type WebAuthnChallengeResponse struct {
+	ID                uuid.UUID                `json:"id"`
+	ExpiresAt         int64                    `json:"expires_at"`
+	CredentialOptions *webauthn.RequestOptions `json:"credential_options"`
+}
+
+// WebAuthnGrantParams are the parameters the WebAuthnGrant method accepts
+type WebAuthnGrantParams struct {
+	ChallengeID        uuid.UUID       `json:"challenge_id"`
+	CredentialResponse json.RawMessage `json:"credential_response"`
+}
+
+// webAuthnRelyingParty returns the relying party credentials are registered
+// for, which defaults to the site URL.
+func (a *API) webAuthnRelyingParty(ctx context.Context) (*webauthn.RelyingParty, error) {
+	config := a.config.WebAuthn
+
+	siteURL, err := url.ParseRequestURI(a.getConfig(ctx).SiteURL)
+	if err != nil {
+		return nil, internalServerError("site url is improperly formatted").WithInternalError(err)
+	}
+
+	rp := &webauthn.RelyingParty{
+		ID:   config.RPID,
+		Name: config.RPDisplayName,
+	}
+	if rp.ID == "" {
+		rp.ID = siteURL.Hostname()
+	}
+	if rp.Name == "" {
+		rp.Name = rp.ID
+	}
+	for _, origin := range config.RPOrigins {
+		rp.Origins = append(rp.Origins, strings.TrimSuffix(origin, "/"))
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{siteURL.Scheme + "://" + siteURL.Host}
+	}
+
+	return rp, nil
+}
+
+func (a *API) webAuthnChallengeTimeout() time.Duration {
+	return time.Duration(a.config.MFA.ChallengeExpiryDuration * float64(time.Second))
+}
+
+// webAuthnCredential returns the stored credential of a factor in the form
+// it is verified in.
+func webAuthnCredential(c *models.WebAuthnCredential) (*webauthn.Credential, error) {
+	id, err := base64.RawURLEncoding.DecodeString(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := base64.RawURLEncoding.DecodeString(c.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.Credential{
+		ID:        id,
+		PublicKey: publicKey,
+		SignCount: c.SignCount,
+	}, nil
+}
+
+// challengeWebAuthnFactor starts the registration of the credential of an
+// unverified WebAuthn factor, or a verification with the credential of a
+// verified one.
+func (a *API) challengeWebAuthnFactor(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.config
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+	factor := getFactor(ctx)
+
+	if !config.WebAuthn.Enabled {
+		return badRequestError(ErrorCodeWebAuthnDisabled, "WebAuthn is disabled")
+	}
+
+	rp, err := a.webAuthnRelyingParty(ctx)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := models.NewWebAuthnChallenge(factor, webauthn.NewChallenge(), utilities.GetClientIP(r))
+	if err != nil {
+		return internalServerError("Error creating challenge").WithInternalError(err)
+	}
+
+	timeout := a.webAuthnChallengeTimeout()
+	object := &WebAuthnChallengeObject{}
+	if factor.IsVerified() && factor.WebAuthnCredential != nil {
+		object.Type = "get"
+		object.CredentialOptions = rp.RequestOptions(challenge.Challenge, []webauthn.CredentialDescriptor{
+			webauthn.NewCredentialDescriptor(factor.WebAuthnCredential.ID, factor.WebAuthnCredential.Transports),
+		}, webauthn.UserVerificationPreferred, timeout)
+	} else {
+		var exclude []webauthn.CredentialDescriptor
+		for _, f := range user.Factors {
+			if f.WebAuthnCredential != nil {
+				exclude = append(exclude, webauthn.NewCredentialDescriptor(f.WebAuthnCredential.ID, f.WebAuthnCredential.Transports))
+			}
+		}
+
+		name := user.GetEmail()
+		if name == "" {
+			name = user.GetPhone()
+		}
+		displayName := factor.FriendlyName
+		if displayName == "" {
+			displayName = name
+		}
+
+		object.Type = "create"
+		object.CredentialOptions = rp.CreationOptions(challenge.Challenge, user.ID.Bytes(), name, displayName, exclude, timeout)
+	}
+
+	err = db.Transaction(func(tx *storage.Connection) error {
+		if terr := tx.Create(challenge); terr != nil {
+			return terr
+		}
+		return models.NewAuditLogEntry(r, tx, user, models.CreateChallengeAction, "", map[string]interface{}{
+			"factor_id":     factor.ID,
+			"factor_status": factor.Status,
+		})
+	})
+	if err != nil {
+		return internalServerError("Database error creating challenge").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, &ChallengeFactorResponse{
+		ID:        challenge.ID,
+		ExpiresAt: challenge.GetExpiryTime(config.MFA.ChallengeExpiryDuration).Unix(),
+		WebAuthn:  object,
+	})
+}
+
+// verifyWebAuthnFactor verifies the response of the authenticator to the
+// challenge of a WebAuthn factor, registering its credential when the factor
+// is unverified. The session is upgraded to AAL2 like with a TOTP factor.
+func (a *API) verifyWebAuthnFactor(w http.ResponseWriter, r *http.Request, params *VerifyFactorParams) error {
+	ctx := r.Context()
+	config := a.config
+	db := a.db.WithContext(ctx)
+	user := getUser(ctx)
+	factor := getFactor(ctx)
+
+	if !config.WebAuthn.Enabled {
+		return badRequestError(ErrorCodeWebAuthnDisabled, "WebAuthn is disabled")
+	}
+
+	rp, err := a.webAuthnRelyingParty(ctx)
+	if err != nil {
+		return err
+	}
+
+	// challenges are used up by failed verifications too, so those commit
+	// and return verifyErr afterwards
+	var verifyErr error
+	var token *AccessTokenResponse
+	err = db.Transaction(func(tx *storage.Connection) error {
+		challenge, terr := models.FindWebAuthnChallengeByID(tx, params.ChallengeID)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				return notFoundError(ErrorCodeMFAFactorNotFound, "MFA factor with the provided challenge ID not found")
+			}
+			return internalServerError("Database error finding challenge").WithInternalError(terr)
+		}
+		if challenge.FactorID == nil || *challenge.FactorID != factor.ID {
+			return notFoundError(ErrorCodeMFAFactorNotFound, "MFA factor with the provided challenge ID not found")
+		}
+		if terr := tx.Destroy(challenge); terr != nil {
+			return internalServerError("Database error deleting challenge").WithInternalError(terr)
+		}
+
+		if challenge.IPAddress != utilities.GetClientIP(r) {
+			verifyErr = unprocessableEntityError(ErrorCodeMFAIPAddressMismatch, "Challenge and verify IP addresses mismatch")
+			return nil
+		}
+		if challenge.HasExpired(a.Now(), config.MFA.ChallengeExpiryDuration) {
+			verifyErr = unprocessableEntityError(ErrorCodeMFAChallengeExpired, "MFA challenge %v has expired, verify against another challenge or create a new challenge.", challenge.ID)
+			return nil
+		}
+
+		if !factor.IsVerified() {
+			resp := &webauthn.RegistrationResponse{}
+			if err := json.Unmarshal(params.CredentialResponse, resp); err != nil {
+				verifyErr = badRequestError(ErrorCodeValidationFailed, "credential_response is not a valid WebAuthn registration response")
+				return nil
+			}
+			credential, err := rp.VerifyRegistration(resp, challenge.Challenge, false)
+			if err != nil {
+				verifyErr = unprocessableEntityError(ErrorCodeMFAVerificationFailed, "Invalid WebAuthn credential").WithInternalError(err)
+				return nil
+			}
+
+			credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+			if _, terr := models.FindFactorByWebAuthnCredentialID(tx, credentialID); terr == nil {
+				verifyErr = unprocessableEntityError(ErrorCodeMFAVerificationFailed, "The WebAuthn credential is already registered")
+				return nil
+			} else if !models.IsNotFoundError(terr) {
+				return internalServerError("Database error finding factor").WithInternalError(terr)
+			}
+
+			if terr := factor.SetWebAuthnCredential(tx, &models.WebAuthnCredential{
+				ID:         credentialID,
+				PublicKey:  base64.RawURLEncoding.EncodeToString(credential.PublicKey),
+				SignCount:  credential.SignCount,
+				AAGUID:     base64.RawURLEncoding.EncodeToString(credential.AAGUID),
+				Transports: resp.Response.Transports,
+			}); terr != nil {
+				return internalServerError("Database error updating factor").WithInternalError(terr)
+			}
+			if terr := factor.UpdateStatus(tx, models.FactorStateVerified); terr != nil {
+				return terr
+			}
+		} else {
+			resp := &webauthn.AuthenticationResponse{}
+			if err := json.Unmarshal(params.CredentialResponse, resp); err != nil {
+				verifyErr = badRequestError(ErrorCodeValidationFailed, "credential_response is not a valid WebAuthn authentication response")
+				return nil
+			}
+			credential, err := webAuthnCredential(factor.WebAuthnCredential)
+			if err != nil {
+				return internalServerError("Invalid WebAuthn credential of factor").WithInternalError(err)
+			}
+			signCount, err := rp.VerifyAuthentication(resp, challenge.Challenge, credential, false)
+			if err != nil {
+				verifyErr = unprocessableEntityError(ErrorCodeMFAVerificationFailed, "Invalid WebAuthn credential").WithInternalError(err)
+				return nil
+			}
+			if terr := factor.UpdateWebAuthnSignCount(tx, signCount); terr != nil {
+				return internalServerError("Database error updating factor").WithInternalError(terr)
+			}
+		}
+
+		if terr = models.NewAuditLogEntry(r, tx, user, models.VerifyFactorAction, "", map[string]interface{}{
+			"factor_id":    factor.ID,
+			"challenge_id": challenge.ID,
+		}); terr != nil {
+			return terr
+		}
+		if user, terr = models.FindUserByID(tx, user.ID); terr != nil {
+			return terr
+		}
+		if token, terr = a.updateMFASessionAndClaims(r, tx, user, models.WebAuthnSignIn, models.GrantParams{
+			FactorID: &factor.ID,
+		}); terr != nil {
+			return terr
+		}
+		if terr = a.setCookieTokens(config, token, false, w); terr != nil {
+			return internalServerError("Failed to set JWT cookie. %s", terr)
+		}
+		if terr = models.InvalidateSessionsWithAALLessThan(tx, user.ID, models.AAL2.String()); terr != nil {
+			return internalServerError("Failed to update sessions. %s", terr)
+		}
+		if terr = models.DeleteUnverifiedFactors(tx, user); terr != nil {
+			return internalServerError("Error removing unverified factors. %s", terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if verifyErr != nil {
+		return verifyErr
+	}
+	metering.RecordLogin(string(models.MFACodeLoginAction), user.ID)
+
+	return sendJSON(w, http.StatusOK, token)
+}
+
+// WebAuthnChallenge starts a sign in with a passkey. The user picks one of
+// their passkeys for the site, so the user isn't known until the response
+// is verified with WebAuthnGrant.
+func (a *API) WebAuthnChallenge(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.config
+	db := a.db.WithContext(ctx)
+
+	rp, err := a.webAuthnRelyingParty(ctx)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := models.NewWebAuthnChallenge(nil, webauthn.NewChallenge(), utilities.GetClientIP(r))
+	if err != nil {
+		return internalServerError("Error creating challenge").WithInternalError(err)
+	}
+	if err := db.Create(challenge); err != nil {
+		return internalServerError("Database error creating challenge").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, &WebAuthnChallengeResponse{
+		ID:                challenge.ID,
+		ExpiresAt:         challenge.GetExpiryTime(config.MFA.ChallengeExpiryDuration).Unix(),
+		CredentialOptions: rp.RequestOptions(challenge.Challenge, nil, webauthn.UserVerificationRequired, a.webAuthnChallengeTimeout()),
+	})
+}
+
+// WebAuthnGrant implements the webauthn grant type, which signs in with a
+// passkey: the verified WebAuthn factor of the user. The user must have been
+// verified by the authenticator, so the session has AAL2 right away.
+func (a *API) WebAuthnGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	config := a.config
+	db := a.db.WithContext(ctx)
+
+	if !config.WebAuthn.Enabled {
+		return oauthError("unsupported_grant_type", ErrorCodeWebAuthnDisabled, "")
+	}
+
+	rp, err := a.webAuthnRelyingParty(ctx)
+	if err != nil {
+		return err
+	}
+
+	var grantParams models.GrantParams
+	grantParams.FillGrantParams(r)
+
+	params := &WebAuthnGrantParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	resp := &webauthn.AuthenticationResponse{}
+	if params.ChallengeID == uuid.Nil || len(params.CredentialResponse) == 0 {
+		return oauthError("invalid_request", ErrorCodeValidationFailed, "challenge_id and credential_response are required")
+	}
+	if err := json.Unmarshal(params.CredentialResponse, resp); err != nil {
+		return oauthError("invalid_request", ErrorCodeValidationFailed, "credential_response is not a valid WebAuthn authentication response")
+	}
+	credentialID, err := resp.CredentialID()
+	if err != nil {
+		return oauthError("invalid_request", ErrorCodeValidationFailed, "credential_response has an invalid credential ID")
+	}
+
+	// challenges are used up by failed sign ins too, so those commit and
+	// return grantErr afterwards
+	var grantErr error
+	var user *models.User
+	var token *AccessTokenResponse
+	err = db.Transaction(func(tx *storage.Connection) error {
+		challenge, terr := models.FindWebAuthnChallengeByID(tx, params.ChallengeID)
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				grantErr = oauthError("invalid_grant", ErrorCodeMFAChallengeExpired, "WebAuthn challenge is invalid or has expired")
+				return nil
+			}
+			return internalServerError("Database error finding challenge").WithInternalError(terr)
+		}
+		if challenge.FactorID != nil {
+			grantErr = oauthError("invalid_grant", ErrorCodeMFAChallengeExpired, "WebAuthn challenge is invalid or has expired")
+			return nil
+		}
+		if terr := tx.Destroy(challenge); terr != nil {
+			return internalServerError("Database error deleting challenge").WithInternalError(terr)
+		}
+		if challenge.HasExpired(a.Now(), config.MFA.ChallengeExpiryDuration) {
+			grantErr = oauthError("invalid_grant", ErrorCodeMFAChallengeExpired, "WebAuthn challenge is invalid or has expired")
+			return nil
+		}
+		if challenge.IPAddress != utilities.GetClientIP(r) {
+			grantErr = oauthError("invalid_grant", ErrorCodeMFAIPAddressMismatch, "Challenge and sign in IP addresses mismatch")
+			return nil
+		}
+
+		factor, terr := models.FindFactorByWebAuthnCredentialID(tx, base64.RawURLEncoding.EncodeToString(credentialID))
+		if terr != nil {
+			if models.IsNotFoundError(terr) {
+				grantErr = oauthError("invalid_grant", ErrorCodeInvalidCredentials, InvalidLoginMessage)
+				return nil
+			}
+			return internalServerError("Database error finding factor").WithInternalError(terr)
+		}
+
+		// passkeys return the user ID they were registered with
+		if userHandle, err := resp.UserHandle(); err != nil || (len(userHandle) > 0 && !bytes.Equal(userHandle, factor.UserID.Bytes())) {
+			grantErr = oauthError("invalid_grant", ErrorCodeInvalidCredentials, InvalidLoginMessage)
+			return nil
+		}
+
+		credential, terr := webAuthnCredential(factor.WebAuthnCredential)
+		if terr != nil {
+			return internalServerError("Invalid WebAuthn credential of factor").WithInternalError(terr)
+		}
+		signCount, verr := rp.VerifyAuthentication(resp, challenge.Challenge, credential, true)
+		if verr != nil {
+			grantErr = oauthError("invalid_grant", ErrorCodeInvalidCredentials, InvalidLoginMessage).WithInternalError(verr)
+			return nil
+		}
+		if terr := factor.UpdateWebAuthnSignCount(tx, signCount); terr != nil {
+			return internalServerError("Database error updating factor").WithInternalError(terr)
+		}
+
+		if user, terr = models.FindUserByID(tx, factor.UserID); terr != nil {
+			return internalServerError("Database error finding user").WithInternalError(terr)
+		}
+		if user.IsBanned() {
+			grantErr = forbiddenError(ErrorCodeUserBanned, "User is banned")
+			return nil
+		}
+
+		if terr := models.NewAuditLogEntry(r, tx, user, models.LoginAction, "", map[string]interface{}{
+			"provider_type": models.WebAuthnSignIn.String(),
+			"factor_id":     factor.ID,
+		}); terr != nil {
+			return terr
+		}
+		a.notifyWebhook(r, tx, conf.WebhookLoginEvent, user)
+
+		grantParams.FactorID = &factor.ID
+		if token, terr = a.issueRefreshToken(r, tx, user, models.WebAuthnSignIn, grantParams); terr != nil {
+			return terr
+		}
+		if terr = a.setCookieTokens(config, token, false, w); terr != nil {
+			return internalServerError("Failed to set JWT cookie. %s", terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if grantErr != nil {
+		return grantErr
+	}
+	metering.RecordLogin(models.WebAuthnSignIn.String(), user.ID)
+
+	return sendJSON(w, http.StatusOK, token)
+}
@@ -0,0 +1,171 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Attestation statement formats. See:
+// https://www.w3.org/TR/webauthn-2/#sctn-defined-attestation-formats
+const (
+	AttestationFormatNone   = "none"
+	AttestationFormatPacked = "packed"
+)
+
+// oidAAGUID is the certificate extension with the AAGUID of the
+// authenticators an attestation certificate is for.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// verifyAttestation verifies the attestation statement of the attestation
+// object over the authenticator data and the client data of a registration.
+// Only the none and packed formats are supported. Packed certificates are
+// not chained to the roots of their vendors, as authenticators aren't
+// restricted to known models.
+func verifyAttestation(attestation map[interface{}]interface{}, authData, clientDataJSON []byte, credential *Credential) error {
+	format, ok := attestation["fmt"].(string)
+	if !ok {
+		return errors.New("webauthn: attestation object has no format")
+	}
+	statement, ok := attestation["attStmt"].(map[interface{}]interface{})
+	if !ok {
+		return errors.New("webauthn: attestation object has no attestation statement")
+	}
+
+	switch format {
+	case AttestationFormatNone:
+		if len(statement) != 0 {
+			return errors.New("webauthn: none attestation statement is not empty")
+		}
+		return nil
+
+	case AttestationFormatPacked:
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		signed := append(authData[:len(authData):len(authData)], clientDataHash[:]...)
+		return verifyPackedAttestation(statement, signed, credential)
+
+	default:
+		return fmt.Errorf("webauthn: attestation format %q is not supported", format)
+	}
+}
+
+// verifyPackedAttestation verifies a packed attestation statement, signed by
+// the credential itself or by the key of an attestation certificate. See:
+// https://www.w3.org/TR/webauthn-2/#sctn-packed-attestation
+func verifyPackedAttestation(statement map[interface{}]interface{}, signed []byte, credential *Credential) error {
+	alg, ok := statement["alg"].(int64)
+	if !ok {
+		return errors.New("webauthn: packed attestation statement has no algorithm")
+	}
+	signature, ok := statement["sig"].([]byte)
+	if !ok {
+		return errors.New("webauthn: packed attestation statement has no signature")
+	}
+	if _, ok := statement["ecdaaKeyId"]; ok {
+		return errors.New("webauthn: ECDAA attestation is not supported")
+	}
+
+	x5c, ok := statement["x5c"]
+	if !ok {
+		// self attestation, signed by the credential
+		key, _, err := parsePublicKey(credential.PublicKey)
+		if err != nil {
+			return err
+		}
+		if int64(key.Algorithm) != alg {
+			return errors.New("webauthn: packed self attestation algorithm doesn't match the credential")
+		}
+		if !key.verify(signed, signature) {
+			return errors.New("webauthn: packed attestation signature is invalid")
+		}
+		return nil
+	}
+
+	chain, ok := x5c.([]interface{})
+	if !ok || len(chain) == 0 {
+		return errors.New("webauthn: packed attestation statement has no certificate")
+	}
+	der, ok := chain[0].([]byte)
+	if !ok {
+		return errors.New("webauthn: packed attestation certificate is not a byte string")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("webauthn: packed attestation certificate is invalid: %w", err)
+	}
+	if err := verifyPackedCertificate(cert, credential); err != nil {
+		return err
+	}
+
+	key, err := certificateKey(cert, int(alg))
+	if err != nil {
+		return err
+	}
+	if !key.verify(signed, signature) {
+		return errors.New("webauthn: packed attestation signature is invalid")
+	}
+
+	return nil
+}
+
+// verifyPackedCertificate checks the requirements of packed attestation
+// certificates. See:
+// https://www.w3.org/TR/webauthn-2/#sctn-packed-attestation-cert-requirements
+func verifyPackedCertificate(cert *x509.Certificate, credential *Credential) error {
+	if cert.Version != 3 {
+		return errors.New("webauthn: packed attestation certificate must be version 3")
+	}
+	if !slices.Contains(cert.Subject.OrganizationalUnit, "Authenticator Attestation") {
+		return errors.New("webauthn: packed attestation certificate is not for authenticator attestation")
+	}
+	if len(cert.Subject.Country) == 0 || len(cert.Subject.Organization) == 0 || cert.Subject.CommonName == "" {
+		return errors.New("webauthn: packed attestation certificate subject is incomplete")
+	}
+	if !cert.BasicConstraintsValid || cert.IsCA {
+		return errors.New("webauthn: packed attestation certificate must not be a CA")
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		var aaguid []byte
+		if ext.Critical {
+			return errors.New("webauthn: packed attestation certificate AAGUID extension must not be critical")
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &aaguid); err != nil || !bytes.Equal(aaguid, credential.AAGUID) {
+			return errors.New("webauthn: packed attestation certificate is for another authenticator")
+		}
+	}
+
+	return nil
+}
+
+// certificateKey returns the public key of the certificate, which must be of
+// the algorithm.
+func certificateKey(cert *x509.Certificate, alg int) (*publicKey, error) {
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if alg == AlgorithmES256 && pub.Curve == elliptic.P256() {
+			return &publicKey{Algorithm: alg, Key: cert.PublicKey}, nil
+		}
+	case ed25519.PublicKey:
+		if alg == AlgorithmEdDSA {
+			return &publicKey{Algorithm: alg, Key: cert.PublicKey}, nil
+		}
+	case *rsa.PublicKey:
+		if alg == AlgorithmRS256 {
+			return &publicKey{Algorithm: alg, Key: cert.PublicKey}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("webauthn: packed attestation certificate key doesn't match the algorithm %d", alg)
+}
@@ -0,0 +1,156 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxCBORDepth limits the nesting of decoded CBOR items, which authenticator
+// data never comes close to.
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("webauthn: CBOR data is truncated")
+
+// decodeCBOR decodes the first CBOR item of the data, returning the bytes
+// after it. It supports the subset of CBOR used by attestation objects and
+// COSE keys: integers, byte and text strings, arrays, maps, booleans and
+// null. Integers are returned as int64, maps as map[interface{}]interface{}.
+// See: https://www.rfc-editor.org/rfc/rfc8949
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("webauthn: CBOR data is nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22:
+			return nil, data[1:], nil
+		default:
+			return nil, nil, fmt.Errorf("webauthn: CBOR simple value %d is not supported", info)
+		}
+	}
+
+	arg, rest, err := decodeCBORArgument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("webauthn: CBOR integer is too large")
+		}
+		return int64(arg), rest, nil
+
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("webauthn: CBOR integer is too large")
+		}
+		return -1 - int64(arg), rest, nil
+
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		if major == 2 {
+			return rest[:arg], rest[arg:], nil
+		}
+		return string(rest[:arg]), rest[arg:], nil
+
+	case 4:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+
+	case 5:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("webauthn: CBOR map keys must be integers or text strings")
+			}
+			if value, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, rest, nil
+
+	default:
+		return nil, nil, fmt.Errorf("webauthn: CBOR major type %d is not supported", major)
+	}
+}
+
+// decodeCBORArgument decodes the argument of the item's initial byte, which
+// is its value, length or number of items. Indefinite lengths are not
+// supported.
+func decodeCBORArgument(data []byte) (uint64, []byte, error) {
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, nil, errors.New("webauthn: CBOR indefinite lengths are not supported")
+	}
+
+	if len(data) < size {
+		return 0, nil, errCBORTruncated
+	}
+
+	var arg uint64
+	switch size {
+	case 1:
+		arg = uint64(data[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		arg = binary.BigEndian.Uint64(data)
+	}
+
+	return arg, data[size:], nil
+}
@@ -0,0 +1,140 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms of the credential public keys. See:
+// https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	AlgorithmES256 = -7
+	AlgorithmEdDSA = -8
+	AlgorithmRS256 = -257
+)
+
+// Algorithms are the COSE algorithms credentials may use, the preferred one
+// first.
+var Algorithms = []int{AlgorithmES256, AlgorithmEdDSA, AlgorithmRS256}
+
+// COSE key parameters. See:
+// https://www.rfc-editor.org/rfc/rfc9053
+const (
+	coseKeyType      = 1
+	coseKeyAlgorithm = 3
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// publicKey is the public key of a credential with its COSE algorithm.
+type publicKey struct {
+	Algorithm int
+	Key       crypto.PublicKey
+}
+
+// parsePublicKey parses a COSE_Key of an ES256, EdDSA or RS256 credential,
+// returning the bytes after it.
+func parsePublicKey(data []byte) (*publicKey, []byte, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, errors.New("webauthn: credential public key is not a COSE key")
+	}
+
+	intParam := func(label int64) (int64, bool) {
+		v, ok := params[label].(int64)
+		return v, ok
+	}
+	bytesParam := func(label int64) []byte {
+		v, _ := params[label].([]byte)
+		return v
+	}
+
+	keyType, _ := intParam(coseKeyType)
+	alg, ok := intParam(coseKeyAlgorithm)
+	if !ok {
+		return nil, nil, errors.New("webauthn: credential public key has no algorithm")
+	}
+
+	key := &publicKey{Algorithm: int(alg)}
+	switch {
+	case keyType == coseKeyTypeEC2 && alg == AlgorithmES256:
+		if crv, _ := intParam(-1); crv != coseCurveP256 {
+			return nil, nil, errors.New("webauthn: ES256 credential public key must use the P-256 curve")
+		}
+		x, y := bytesParam(-2), bytesParam(-3)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, nil, errors.New("webauthn: ES256 credential public key has invalid coordinates")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, nil, errors.New("webauthn: ES256 credential public key is not on the curve")
+		}
+		key.Key = pub
+
+	case keyType == coseKeyTypeOKP && alg == AlgorithmEdDSA:
+		if crv, _ := intParam(-1); crv != coseCurveEd25519 {
+			return nil, nil, errors.New("webauthn: EdDSA credential public key must use the Ed25519 curve")
+		}
+		x := bytesParam(-2)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, nil, errors.New("webauthn: EdDSA credential public key has an invalid size")
+		}
+		key.Key = ed25519.PublicKey(x)
+
+	case keyType == coseKeyTypeRSA && alg == AlgorithmRS256:
+		n, e := bytesParam(-1), bytesParam(-2)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, nil, errors.New("webauthn: RS256 credential public key has an invalid exponent")
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if pub.N.BitLen() < 2048 {
+			return nil, nil, errors.New("webauthn: RS256 credential public key must be at least RSA 2048")
+		}
+		key.Key = pub
+
+	default:
+		return nil, nil, fmt.Errorf("webauthn: credential public key type %d with algorithm %d is not supported", keyType, alg)
+	}
+
+	return key, rest, nil
+}
+
+// verify checks the signature of the data.
+func (k *publicKey) verify(data, signature []byte) bool {
+	switch pub := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(pub, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	}
+
+	return false
+}
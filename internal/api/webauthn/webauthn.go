@@ -0,0 +1,416 @@
+// Package webauthn verifies the registration and authentication ceremonies
+// of WebAuthn credentials, such as passkeys and security keys. Attestation
+// statements of the none and packed formats are verified, and the other
+// formats are rejected. See: https://www.w3.org/TR/webauthn-2/
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// User verification requirements of a ceremony.
+const (
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+)
+
+const publicKeyCredentialType = "public-key"
+
+// Flags of the authenticator data.
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+// RelyingParty is the site credentials are registered for. Its ID is the
+// domain of the site, and Origins are the origins of the pages allowed to
+// run the ceremonies, such as https://example.com.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+	AAGUID    []byte
+}
+
+// CredentialDescriptor identifies a credential in the options of a ceremony.
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// NewCredentialDescriptor returns the descriptor of the base64url encoded
+// credential ID.
+func NewCredentialDescriptor(id string, transports []string) CredentialDescriptor {
+	return CredentialDescriptor{
+		Type:       publicKeyCredentialType,
+		ID:         id,
+		Transports: transports,
+	}
+}
+
+// CreationOptions are the options of navigator.credentials.create() in their
+// JSON form, which PublicKeyCredential.parseCreationOptionsFromJSON() takes.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// RequestOptions are the options of navigator.credentials.get() in their
+// JSON form, which PublicKeyCredential.parseRequestOptionsFromJSON() takes.
+// Without allowed credentials, the user picks one of their passkeys.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the JSON form of the PublicKeyCredential returned
+// by navigator.credentials.create().
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AuthenticationResponse is the JSON form of the PublicKeyCredential
+// returned by navigator.credentials.get().
+type AuthenticationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// NewChallenge returns a random challenge, base64url encoded.
+func NewChallenge() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err.Error()) // rand should never fail
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CreationOptions returns the options registering a credential of the user,
+// whose ID is an opaque handle returned when they sign in with it. Existing
+// credentials of the user are excluded, so that an authenticator isn't
+// registered twice.
+func (rp *RelyingParty) CreationOptions(challenge string, userID []byte, userName, displayName string, exclude []CredentialDescriptor, timeout time.Duration) *CreationOptions {
+	params := make([]CredentialParameters, 0, len(Algorithms))
+	for _, alg := range Algorithms {
+		params = append(params, CredentialParameters{Type: publicKeyCredentialType, Alg: alg})
+	}
+
+	return &CreationOptions{
+		Challenge: challenge,
+		RP: RelyingPartyEntity{
+			ID:   rp.ID,
+			Name: rp.Name,
+		},
+		User: UserEntity{
+			ID:          base64.RawURLEncoding.EncodeToString(userID),
+			Name:        userName,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			// discoverable credentials can also sign in without a
+			// password, as passkeys
+			ResidentKey:      "preferred",
+			UserVerification: UserVerificationPreferred,
+		},
+		// browsers return none statements, or packed ones of
+		// authenticators attesting themselves, so that authenticators with
+		// the formats which aren't supported can still be registered
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options signing in with one of the allowed
+// credentials, or with any passkey of the site when none are given.
+func (rp *RelyingParty) RequestOptions(challenge string, allow []CredentialDescriptor, userVerification string, timeout time.Duration) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// VerifyRegistration verifies the response to the challenge of a
+// registration ceremony and its attestation statement, returning the new
+// credential.
+func (rp *RelyingParty) VerifyRegistration(resp *RegistrationResponse, challenge string, requireUserVerification bool) (*Credential, error) {
+	if resp.Type != publicKeyCredentialType {
+		return nil, fmt.Errorf("webauthn: credential type %q is not supported", resp.Type)
+	}
+
+	clientDataJSON, err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	attestationObject, err := decodeBase64URL(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, errors.New("webauthn: attestation object is not base64url encoded")
+	}
+	item, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: attestation object is not a map")
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+
+	data, err := rp.verifyAuthenticatorData(authData, requireUserVerification)
+	if err != nil {
+		return nil, err
+	}
+	if data.credential == nil {
+		return nil, errors.New("webauthn: authenticator data has no attested credential")
+	}
+
+	if rawID, err := decodeBase64URL(resp.RawID); err != nil || !bytes.Equal(rawID, data.credential.ID) {
+		return nil, errors.New("webauthn: credential ID doesn't match the authenticator data")
+	}
+
+	if err := verifyAttestation(attestation, authData, clientDataJSON, data.credential); err != nil {
+		return nil, err
+	}
+
+	return data.credential, nil
+}
+
+// VerifyAuthentication verifies the response to the challenge of an
+// authentication ceremony with the credential, returning its new signature
+// counter.
+func (rp *RelyingParty) VerifyAuthentication(resp *AuthenticationResponse, challenge string, credential *Credential, requireUserVerification bool) (uint32, error) {
+	if resp.Type != publicKeyCredentialType {
+		return 0, fmt.Errorf("webauthn: credential type %q is not supported", resp.Type)
+	}
+
+	if id, err := resp.CredentialID(); err != nil || !bytes.Equal(id, credential.ID) {
+		return 0, errors.New("webauthn: response is not from the credential")
+	}
+
+	clientDataJSON, err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := decodeBase64URL(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, errors.New("webauthn: authenticator data is not base64url encoded")
+	}
+	data, err := rp.verifyAuthenticatorData(authData, requireUserVerification)
+	if err != nil {
+		return 0, err
+	}
+
+	signature, err := decodeBase64URL(resp.Response.Signature)
+	if err != nil {
+		return 0, errors.New("webauthn: signature is not base64url encoded")
+	}
+	key, _, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if !key.verify(append(authData[:len(authData):len(authData)], clientDataHash[:]...), signature) {
+		return 0, errors.New("webauthn: signature is invalid")
+	}
+
+	// a counter that doesn't increase means the authenticator was cloned,
+	// unless the authenticator doesn't count at all
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		return 0, errors.New("webauthn: signature counter didn't increase, the authenticator may be cloned")
+	}
+
+	return data.signCount, nil
+}
+
+// CredentialID returns the ID of the credential of the response.
+func (r *AuthenticationResponse) CredentialID() ([]byte, error) {
+	id := r.RawID
+	if id == "" {
+		id = r.ID
+	}
+	return decodeBase64URL(id)
+}
+
+// UserHandle returns the user ID the credential was registered with, which
+// passkeys return on sign in.
+func (r *AuthenticationResponse) UserHandle() ([]byte, error) {
+	return decodeBase64URL(r.Response.UserHandle)
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyClientData checks the client data of a ceremony, returning it
+// decoded from base64url.
+func (rp *RelyingParty) verifyClientData(encoded, ceremony, challenge string) ([]byte, error) {
+	data, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, errors.New("webauthn: client data is not base64url encoded")
+	}
+
+	var c clientData
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("webauthn: client data is not valid JSON: %w", err)
+	}
+
+	if c.Type != ceremony {
+		return nil, fmt.Errorf("webauthn: client data has the type %q instead of %q", c.Type, ceremony)
+	}
+
+	got, err := decodeBase64URL(c.Challenge)
+	if err != nil {
+		return nil, errors.New("webauthn: client data challenge is not base64url encoded")
+	}
+	want, err := decodeBase64URL(challenge)
+	if err != nil || !bytes.Equal(got, want) {
+		return nil, errors.New("webauthn: client data challenge doesn't match")
+	}
+
+	for _, origin := range rp.Origins {
+		if c.Origin == origin {
+			return data, nil
+		}
+	}
+
+	return nil, fmt.Errorf("webauthn: origin %q is not allowed", c.Origin)
+}
+
+type authenticatorData struct {
+	signCount  uint32
+	credential *Credential
+}
+
+// verifyAuthenticatorData checks that the authenticator data is for the
+// relying party and that the user was present, and verified if required.
+// See: https://www.w3.org/TR/webauthn-2/#sctn-authenticator-data
+func (rp *RelyingParty) verifyAuthenticatorData(data []byte, requireUserVerification bool) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data is too short")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return nil, errors.New("webauthn: authenticator data is for another relying party")
+	}
+
+	flags := data[32]
+	if flags&flagUserPresent == 0 {
+		return nil, errors.New("webauthn: user was not present")
+	}
+	if requireUserVerification && flags&flagUserVerified == 0 {
+		return nil, errors.New("webauthn: user was not verified")
+	}
+
+	result := &authenticatorData{
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if flags&flagAttestedCredData != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data is too short")
+		}
+		aaguid := rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return nil, errors.New("webauthn: attested credential data is too short")
+		}
+		id := rest[:idLength]
+		rest = rest[idLength:]
+
+		_, after, err := parsePublicKey(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		result.credential = &Credential{
+			ID:        bytes.Clone(id),
+			PublicKey: bytes.Clone(rest[:len(rest)-len(after)]),
+			SignCount: result.signCount,
+			AAGUID:    bytes.Clone(aaguid),
+		}
+	}
+
+	return result, nil
+}
+
+// decodeBase64URL decodes base64url, with or without padding.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
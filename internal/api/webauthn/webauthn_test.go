@@ -0,0 +1,218 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/api/webauthn/webauthntest"
+)
+
+func register(t *testing.T, a *webauthntest.Authenticator, rp *RelyingParty, challenge, origin string) *RegistrationResponse {
+	resp := &RegistrationResponse{}
+	require.NoError(t, json.Unmarshal(a.Register(t, rp.ID, challenge, origin), resp))
+	return resp
+}
+
+func authenticate(t *testing.T, a *webauthntest.Authenticator, rp *RelyingParty, challenge, origin string, flags byte) *AuthenticationResponse {
+	resp := &AuthenticationResponse{}
+	require.NoError(t, json.Unmarshal(a.Authenticate(t, rp.ID, challenge, origin, flags), resp))
+	return resp
+}
+
+func TestRegistrationAndAuthentication(t *testing.T) {
+	rp := &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+	a := webauthntest.NewAuthenticator(t, []byte("credential-id"))
+	a.UserHandle = []byte("user")
+
+	challenge := NewChallenge()
+	options := rp.CreationOptions(challenge, []byte("user"), "jane@example.com", "Jane", nil, time.Minute)
+	require.Equal(t, "example.com", options.RP.ID)
+	require.Equal(t, "dXNlcg", options.User.ID)
+	require.Equal(t, int64(60000), options.Timeout)
+
+	credential, err := rp.VerifyRegistration(register(t, a, rp, challenge, "https://example.com"), challenge, false)
+	require.NoError(t, err)
+	require.Equal(t, a.ID, credential.ID)
+	require.Equal(t, a.COSEKey(), credential.PublicKey)
+
+	_, err = rp.VerifyRegistration(register(t, a, rp, challenge, "https://example.com"), NewChallenge(), false)
+	require.Error(t, err)
+	_, err = rp.VerifyRegistration(register(t, a, rp, challenge, "https://evil.example"), challenge, false)
+	require.Error(t, err)
+	_, err = (&RelyingParty{ID: "other.com", Origins: rp.Origins}).VerifyRegistration(register(t, a, rp, challenge, "https://example.com"), challenge, false)
+	require.Error(t, err)
+
+	challenge = NewChallenge()
+	resp := authenticate(t, a, rp, challenge, "https://example.com", flagUserPresent|flagUserVerified)
+	signCount, err := rp.VerifyAuthentication(resp, challenge, credential, true)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), signCount)
+	credential.SignCount = signCount
+
+	userHandle, err := resp.UserHandle()
+	require.NoError(t, err)
+	require.Equal(t, []byte("user"), userHandle)
+
+	// replaying the response doesn't increase the counter
+	_, err = rp.VerifyAuthentication(resp, challenge, credential, true)
+	require.Error(t, err)
+
+	challenge = NewChallenge()
+	resp = authenticate(t, a, rp, challenge, "https://example.com", flagUserPresent)
+	_, err = rp.VerifyAuthentication(resp, challenge, credential, true)
+	require.Error(t, err, "user verification is required")
+	_, err = rp.VerifyAuthentication(resp, challenge, credential, false)
+	require.NoError(t, err)
+
+	challenge = NewChallenge()
+	resp = authenticate(t, a, rp, challenge, "https://example.com", flagUserPresent)
+	resp.Response.Signature = base64.RawURLEncoding.EncodeToString([]byte("invalid"))
+	_, err = rp.VerifyAuthentication(resp, challenge, credential, false)
+	require.Error(t, err)
+}
+
+// attestationCert returns a packed attestation certificate of the key,
+// for the authenticators with the AAGUID unless it's nil.
+func attestationCert(t *testing.T, key *ecdsa.PrivateKey, subject pkix.Name, aaguid []byte) []byte {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+	if aaguid != nil {
+		value, err := asn1.Marshal(aaguid)
+		require.NoError(t, err)
+		template.ExtraExtensions = []pkix.Extension{{Id: oidAAGUID, Value: value}}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der
+}
+
+func TestPackedAttestation(t *testing.T) {
+	rp := &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+	attestationKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	subject := pkix.Name{
+		Country:            []string{"US"},
+		Organization:       []string{"Example Vendor"},
+		OrganizationalUnit: []string{"Authenticator Attestation"},
+		CommonName:         "Example Authenticator",
+	}
+
+	a := webauthntest.NewAuthenticator(t, []byte("credential-id"))
+	a.Format = AttestationFormatPacked
+	challenge := NewChallenge()
+	credential, err := rp.VerifyRegistration(register(t, a, rp, challenge, "https://example.com"), challenge, false)
+	require.NoError(t, err, "self attestation")
+	require.Equal(t, a.COSEKey(), credential.PublicKey)
+
+	a.AttestationKey = attestationKey
+	a.AttestationCert = attestationCert(t, attestationKey, subject, make([]byte, 16))
+	_, err = rp.VerifyRegistration(register(t, a, rp, challenge, "https://example.com"), challenge, false)
+	require.NoError(t, err, "certificate attestation")
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherSubject := subject
+	otherSubject.OrganizationalUnit = []string{"Other"}
+
+	for desc, tampered := range map[string]func(a *webauthntest.Authenticator){
+		"certificate of another key": func(a *webauthntest.Authenticator) {
+			a.AttestationCert = attestationCert(t, otherKey, subject, nil)
+		},
+		"certificate not for attestation": func(a *webauthntest.Authenticator) {
+			a.AttestationCert = attestationCert(t, attestationKey, otherSubject, nil)
+		},
+		"certificate of another authenticator": func(a *webauthntest.Authenticator) {
+			a.AttestationCert = attestationCert(t, attestationKey, subject, []byte("other-aaguid-000"))
+		},
+		"certificate that isn't DER": func(a *webauthntest.Authenticator) {
+			a.AttestationCert = []byte("not a certificate")
+		},
+	} {
+		b := *a
+		tampered(&b)
+		_, err = rp.VerifyRegistration(register(t, &b, rp, challenge, "https://example.com"), challenge, false)
+		require.Error(t, err, desc)
+	}
+
+	a.AttestationCert = nil
+	for desc, statement := range map[string]func(t testing.TB, signed []byte) []webauthntest.CBORPair{
+		"signature of other data": func(t testing.TB, signed []byte) []webauthntest.CBORPair {
+			return a.Statement(t, append(signed, 0))
+		},
+		"other algorithm": func(t testing.TB, signed []byte) []webauthntest.CBORPair {
+			statement := a.Statement(t, signed)
+			statement[0] = webauthntest.CBORPair{"alg", AlgorithmRS256}
+			return statement
+		},
+		"no signature": func(t testing.TB, signed []byte) []webauthntest.CBORPair {
+			return a.Statement(t, signed)[:1]
+		},
+		"ECDAA": func(t testing.TB, signed []byte) []webauthntest.CBORPair {
+			return append(a.Statement(t, signed), webauthntest.CBORPair{"ecdaaKeyId", []byte("key")})
+		},
+	} {
+		resp := &RegistrationResponse{}
+		require.NoError(t, json.Unmarshal(a.RegisterWithStatement(t, rp.ID, challenge, "https://example.com", AttestationFormatPacked, statement), resp))
+		_, err = rp.VerifyRegistration(resp, challenge, false)
+		require.Error(t, err, desc)
+	}
+
+	// none statements are empty, and the other formats are not supported
+	for format, statement := range map[string]func(t testing.TB, signed []byte) []webauthntest.CBORPair{
+		AttestationFormatNone: a.Statement,
+		"fido-u2f":            a.Statement,
+	} {
+		resp := &RegistrationResponse{}
+		require.NoError(t, json.Unmarshal(a.RegisterWithStatement(t, rp.ID, challenge, "https://example.com", format, statement), resp))
+		_, err = rp.VerifyRegistration(resp, challenge, false)
+		require.Error(t, err, format)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	data := webauthntest.EncodeCBOR([]webauthntest.CBORPair{{1, 1}, {3, -8}, {-1, 6}, {-2, []byte(pub)}})
+	key, rest, err := parsePublicKey(append(data, 0xa0))
+	require.NoError(t, err)
+	require.Equal(t, AlgorithmEdDSA, key.Algorithm)
+	require.Equal(t, ed25519.PublicKey(pub), key.Key)
+	require.Equal(t, []byte{0xa0}, rest)
+
+	_, _, err = parsePublicKey(webauthntest.EncodeCBOR([]webauthntest.CBORPair{{1, 2}, {3, -7}, {-1, 1}, {-2, make([]byte, 32)}, {-3, make([]byte, 32)}}))
+	require.Error(t, err, "point is not on the curve")
+
+	_, _, err = parsePublicKey(webauthntest.EncodeCBOR([]webauthntest.CBORPair{{1, 2}, {3, -35}}))
+	require.Error(t, err, "ES384 is not supported")
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	v, rest, err := decodeCBOR([]byte{0x82, 0x18, 0x64, 0x39, 0x01, 0x00, 0xf5})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{int64(100), int64(-257)}, v)
+	require.Equal(t, []byte{0xf5}, rest)
+
+	_, _, err = decodeCBOR([]byte{0x5a, 0xff, 0xff, 0xff, 0xff})
+	require.Error(t, err, "byte string longer than the data")
+
+	_, _, err = decodeCBOR([]byte{0x9f})
+	require.Error(t, err, "indefinite length")
+}
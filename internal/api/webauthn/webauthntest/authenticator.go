@@ -0,0 +1,224 @@
+// Package webauthntest provides a fake WebAuthn authenticator for tests of
+// the registration and authentication ceremonies.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Flags of the authenticator data.
+const (
+	FlagUserPresent      = 0x01
+	FlagUserVerified     = 0x04
+	FlagAttestedCredData = 0x40
+)
+
+// CBORPair is the key and value of a map entry for EncodeCBOR, which keeps
+// the order of the keys.
+type CBORPair [2]interface{}
+
+func encodeCBORHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg < 1<<8:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg < 1<<16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+}
+
+// EncodeCBOR encodes integers, byte strings, text strings and arrays and
+// maps of them.
+func EncodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return encodeCBORHead(1, uint64(-1-v))
+		}
+		return encodeCBORHead(0, uint64(v))
+	case []byte:
+		return append(encodeCBORHead(2, uint64(len(v))), v...)
+	case string:
+		return append(encodeCBORHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		data := encodeCBORHead(4, uint64(len(v)))
+		for _, item := range v {
+			data = append(data, EncodeCBOR(item)...)
+		}
+		return data
+	case []CBORPair:
+		data := encodeCBORHead(5, uint64(len(v)))
+		for _, pair := range v {
+			data = append(data, EncodeCBOR(pair[0])...)
+			data = append(data, EncodeCBOR(pair[1])...)
+		}
+		return data
+	}
+	panic("unsupported type")
+}
+
+// Authenticator is a fake authenticator with one ES256 credential. SignCount
+// is increased by every authentication.
+type Authenticator struct {
+	Key       *ecdsa.PrivateKey
+	ID        []byte
+	SignCount uint32
+
+	// UserHandle is returned by authentications, as passkeys do.
+	UserHandle []byte
+
+	// Format is the format of the attestation statement of registrations,
+	// none when empty. Packed statements are signed by the credential, or
+	// by AttestationKey when AttestationCert is set.
+	Format          string
+	AttestationKey  *ecdsa.PrivateKey
+	AttestationCert []byte
+}
+
+// NewAuthenticator returns an authenticator with a new credential.
+func NewAuthenticator(t testing.TB, id []byte) *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &Authenticator{Key: key, ID: id}
+}
+
+// COSEKey returns the public key of the credential in its COSE form.
+func (a *Authenticator) COSEKey() []byte {
+	return EncodeCBOR([]CBORPair{
+		{1, 2},
+		{3, -7},
+		{-1, 1},
+		{-2, a.Key.X.FillBytes(make([]byte, 32))},
+		{-3, a.Key.Y.FillBytes(make([]byte, 32))},
+	})
+}
+
+func (a *Authenticator) authData(rpID string, flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.SignCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.ID)))
+		data = append(data, a.ID...)
+		data = append(data, a.COSEKey()...)
+	}
+	return data
+}
+
+// ClientDataJSON returns the client data the browser passes to the
+// authenticator in a ceremony, webauthn.create or webauthn.get.
+func ClientDataJSON(t testing.TB, ceremony, challenge, origin string) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    origin,
+	})
+	require.NoError(t, err)
+	return data
+}
+
+func marshal(t testing.TB, v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
+
+// Register returns the JSON form of the response to
+// navigator.credentials.create() with an attestation of the credential in
+// the authenticator's format.
+func (a *Authenticator) Register(t testing.TB, rpID, challenge, origin string) json.RawMessage {
+	format := a.Format
+	if format == "" {
+		format = "none"
+	}
+	return a.RegisterWithStatement(t, rpID, challenge, origin, format, a.Statement)
+}
+
+// RegisterWithStatement returns the JSON form of the response to
+// navigator.credentials.create() with the attestation statement of the
+// format returned by statement for the signed data, the authenticator data
+// followed by the hash of the client data.
+func (a *Authenticator) RegisterWithStatement(t testing.TB, rpID, challenge, origin, format string, statement func(t testing.TB, signed []byte) []CBORPair) json.RawMessage {
+	authData := a.authData(rpID, FlagUserPresent|FlagUserVerified|FlagAttestedCredData, true)
+	clientData := ClientDataJSON(t, "webauthn.create", challenge, origin)
+	clientDataHash := sha256.Sum256(clientData)
+
+	attestationObject := EncodeCBOR([]CBORPair{
+		{"fmt", format},
+		{"attStmt", statement(t, append(authData[:len(authData):len(authData)], clientDataHash[:]...))},
+		{"authData", authData},
+	})
+
+	return marshal(t, map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.ID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.ID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+		},
+	})
+}
+
+// Statement returns the attestation statement of the signed data in the
+// authenticator's format.
+func (a *Authenticator) Statement(t testing.TB, signed []byte) []CBORPair {
+	if a.Format != "packed" {
+		return []CBORPair{}
+	}
+
+	key := a.Key
+	if a.AttestationCert != nil {
+		key = a.AttestationKey
+	}
+	digest := sha256.Sum256(signed)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+
+	statement := []CBORPair{{"alg", -7}, {"sig", signature}}
+	if a.AttestationCert != nil {
+		statement = append(statement, CBORPair{"x5c", []interface{}{a.AttestationCert}})
+	}
+	return statement
+}
+
+// Authenticate increases the sign count and returns the JSON form of the
+// response to navigator.credentials.get() signed with the credential.
+func (a *Authenticator) Authenticate(t testing.TB, rpID, challenge, origin string, flags byte) json.RawMessage {
+	a.SignCount++
+	authData := a.authData(rpID, flags, false)
+	clientData := ClientDataJSON(t, "webauthn.get", challenge, origin)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.Key, digest[:])
+	require.NoError(t, err)
+
+	response := map[string]interface{}{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+		"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+		"signature":         base64.RawURLEncoding.EncodeToString(signature),
+	}
+	if a.UserHandle != nil {
+		response["userHandle"] = base64.RawURLEncoding.EncodeToString(a.UserHandle)
+	}
+
+	return marshal(t, map[string]interface{}{
+		"id":       base64.RawURLEncoding.EncodeToString(a.ID),
+		"rawId":    base64.RawURLEncoding.EncodeToString(a.ID),
+		"type":     "public-key",
+		"response": response,
+	})
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/supabase/auth/internal/api/webauthn"
+	"github.com/supabase/auth/internal/api/webauthn/webauthntest"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+type WebAuthnTestSuite struct {
+	suite.Suite
+	API    *API
+	Config *conf.GlobalConfiguration
+}
+
+func TestWebAuthn(t *testing.T) {
+	api, config, err := setupAPIForTest()
+	require.NoError(t, err)
+
+	ts := &WebAuthnTestSuite{
+		API:    api,
+		Config: config,
+	}
+	defer api.db.Close()
+
+	suite.Run(t, ts)
+}
+
+func (ts *WebAuthnTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	ts.Config.SiteURL = "https://example.com/app"
+	ts.Config.WebAuthn = conf.WebAuthnConfiguration{Enabled: true}
+}
+
+func (ts *WebAuthnTestSuite) TestRelyingPartyDefaults() {
+	rp, err := ts.API.webAuthnRelyingParty(httptest.NewRequest(http.MethodPost, "/", nil).Context())
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "example.com", rp.ID)
+	require.Equal(ts.T(), "example.com", rp.Name)
+	require.Equal(ts.T(), []string{"https://example.com"}, rp.Origins)
+
+	ts.Config.WebAuthn.RPID = "auth.example.com"
+	ts.Config.WebAuthn.RPOrigins = []string{"https://auth.example.com/"}
+	rp, err = ts.API.webAuthnRelyingParty(httptest.NewRequest(http.MethodPost, "/", nil).Context())
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), "auth.example.com", rp.ID)
+	require.Equal(ts.T(), []string{"https://auth.example.com"}, rp.Origins)
+}
+
+func (ts *WebAuthnTestSuite) TestDisabled() {
+	ts.Config.WebAuthn.Enabled = false
+
+	req := httptest.NewRequest(http.MethodPost, "/webauthn/challenge", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/token?grant_type=webauthn", bytes.NewBufferString("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+}
+
+func (ts *WebAuthnTestSuite) challenge() *WebAuthnChallengeResponse {
+	req := httptest.NewRequest(http.MethodPost, "/webauthn/challenge", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	resp := &WebAuthnChallengeResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(resp))
+	return resp
+}
+
+func (ts *WebAuthnTestSuite) TestSignInChallenge() {
+	resp := ts.challenge()
+	require.Equal(ts.T(), "example.com", resp.CredentialOptions.RPID)
+	require.Equal(ts.T(), webauthn.UserVerificationRequired, resp.CredentialOptions.UserVerification)
+	require.Empty(ts.T(), resp.CredentialOptions.AllowCredentials)
+
+	challenge, err := models.FindWebAuthnChallengeByID(ts.API.db, resp.ID)
+	require.NoError(ts.T(), err)
+	require.Nil(ts.T(), challenge.FactorID)
+	require.Equal(ts.T(), resp.CredentialOptions.Challenge, challenge.Challenge)
+}
+
+func (ts *WebAuthnTestSuite) TestGrantWithUnknownCredential() {
+	resp := ts.challenge()
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"challenge_id": resp.ID,
+		"credential_response": map[string]interface{}{
+			"id":    "dW5rbm93bg",
+			"rawId": "dW5rbm93bg",
+			"type":  "public-key",
+			"response": map[string]interface{}{
+				"clientDataJSON":    "e30",
+				"authenticatorData": "AA",
+				"signature":         "AA",
+			},
+		},
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=webauthn", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	data := map[string]interface{}{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Equal(ts.T(), "invalid_grant", data["error"])
+
+	// the challenge is used up by the failed sign in
+	_, err := models.FindWebAuthnChallengeByID(ts.API.db, resp.ID)
+	require.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *WebAuthnTestSuite) request(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(method, path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *WebAuthnTestSuite) grant(challengeID uuid.UUID, credentialResponse json.RawMessage) *httptest.ResponseRecorder {
+	return ts.request(http.MethodPost, "/token?grant_type=webauthn", "", WebAuthnGrantParams{
+		ChallengeID:        challengeID,
+		CredentialResponse: credentialResponse,
+	})
+}
+
+// registerPasskey signs in a new user with a password and registers the
+// credential of the authenticator as their WebAuthn factor.
+func (ts *WebAuthnTestSuite) registerPasskey(a *webauthntest.Authenticator) (*models.User, *models.Factor) {
+	user, err := models.NewUser("", "passkey@example.com", "password", ts.Config.JWT.Aud, nil)
+	require.NoError(ts.T(), err)
+	now := time.Now()
+	user.EmailConfirmedAt = &now
+	require.NoError(ts.T(), ts.API.db.Create(user))
+	a.UserHandle = user.ID.Bytes()
+
+	w := ts.request(http.MethodPost, "/token?grant_type=password", "", map[string]string{
+		"email":    "passkey@example.com",
+		"password": "password",
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	session := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(session))
+
+	w = ts.request(http.MethodPost, "/factors", session.Token, EnrollFactorParams{FactorType: models.WebAuthn})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	enrolled := &EnrollFactorResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(enrolled))
+
+	w = ts.request(http.MethodPost, fmt.Sprintf("/factors/%s/challenge", enrolled.ID), session.Token, ChallengeFactorParams{})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	var challenge struct {
+		ID       uuid.UUID `json:"id"`
+		WebAuthn struct {
+			Type              string                   `json:"type"`
+			CredentialOptions webauthn.CreationOptions `json:"credential_options"`
+		} `json:"webauthn"`
+	}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&challenge))
+	require.Equal(ts.T(), "create", challenge.WebAuthn.Type)
+	require.Equal(ts.T(), "example.com", challenge.WebAuthn.CredentialOptions.RP.ID)
+	require.Equal(ts.T(), base64.RawURLEncoding.EncodeToString(user.ID.Bytes()), challenge.WebAuthn.CredentialOptions.User.ID)
+
+	// the response to another challenge isn't accepted
+	w = ts.request(http.MethodPost, fmt.Sprintf("/factors/%s/verify", enrolled.ID), session.Token, VerifyFactorParams{
+		ChallengeID:        challenge.ID,
+		CredentialResponse: a.Register(ts.T(), "example.com", webauthn.NewChallenge(), "https://example.com"),
+	})
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	w = ts.request(http.MethodPost, fmt.Sprintf("/factors/%s/challenge", enrolled.ID), session.Token, ChallengeFactorParams{})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&challenge))
+
+	w = ts.request(http.MethodPost, fmt.Sprintf("/factors/%s/verify", enrolled.ID), session.Token, VerifyFactorParams{
+		ChallengeID:        challenge.ID,
+		CredentialResponse: a.Register(ts.T(), "example.com", challenge.WebAuthn.CredentialOptions.Challenge, "https://example.com"),
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	require.NotEmpty(ts.T(), token.Token)
+
+	factor, err := models.FindFactorByWebAuthnCredentialID(ts.API.db, base64.RawURLEncoding.EncodeToString(a.ID))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), enrolled.ID, factor.ID)
+	require.True(ts.T(), factor.IsVerified())
+	require.Equal(ts.T(), base64.RawURLEncoding.EncodeToString(a.COSEKey()), factor.WebAuthnCredential.PublicKey)
+
+	return user, factor
+}
+
+func (ts *WebAuthnTestSuite) TestRegisterAndSignIn() {
+	a := webauthntest.NewAuthenticator(ts.T(), []byte("passkey"))
+	user, factor := ts.registerPasskey(a)
+
+	challenge := ts.challenge()
+	w := ts.grant(challenge.ID, a.Authenticate(ts.T(), "example.com", challenge.CredentialOptions.Challenge, "https://example.com", webauthntest.FlagUserPresent|webauthntest.FlagUserVerified))
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	require.Equal(ts.T(), user.ID, token.User.ID)
+
+	factor, err := models.FindFactorByFactorID(ts.API.db, factor.ID)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), uint32(1), factor.WebAuthnCredential.SignCount)
+
+	// passkeys sign in only when the user is verified
+	challenge = ts.challenge()
+	w = ts.grant(challenge.ID, a.Authenticate(ts.T(), "example.com", challenge.CredentialOptions.Challenge, "https://example.com", webauthntest.FlagUserPresent))
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func (ts *WebAuthnTestSuite) TestSignInReplay() {
+	a := webauthntest.NewAuthenticator(ts.T(), []byte("passkey"))
+	_, factor := ts.registerPasskey(a)
+
+	challenge := ts.challenge()
+	response := a.Authenticate(ts.T(), "example.com", challenge.CredentialOptions.Challenge, "https://example.com", webauthntest.FlagUserPresent|webauthntest.FlagUserVerified)
+	w := ts.grant(challenge.ID, response)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// the challenge was used up
+	w = ts.grant(challenge.ID, response)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+
+	// the response was signed for another challenge
+	w = ts.grant(ts.challenge().ID, response)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+
+	// cloned authenticators don't increase the sign count
+	a.SignCount = 0
+	challenge = ts.challenge()
+	w = ts.grant(challenge.ID, a.Authenticate(ts.T(), "example.com", challenge.CredentialOptions.Challenge, "https://example.com", webauthntest.FlagUserPresent|webauthntest.FlagUserVerified))
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code, w.Body.String())
+	data := map[string]interface{}{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Equal(ts.T(), "invalid_grant", data["error"])
+
+	factor, err := models.FindFactorByFactorID(ts.API.db, factor.ID)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), uint32(1), factor.WebAuthnCredential.SignCount)
+}
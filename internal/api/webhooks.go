@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
+	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/utilities"
+)
+
+// webhookBackoff is the delay before the first retry of an event delivery.
+// It doubles with every further attempt.
+var webhookBackoff = time.Second
+
+// webhookResponseLimit bounds the response bodies read from the webhook.
+const webhookResponseLimit = 64 * 1024
+
+// WebhookPayload is the body posted to the webhook. ID stays the same
+// across retries so that receivers can ignore repeated deliveries.
+type WebhookPayload struct {
+	ID         string       `json:"id"`
+	Event      string       `json:"event"`
+	OccurredAt time.Time    `json:"occurred_at"`
+	User       *models.User `json:"user"`
+}
+
+// WebhookSignupResponse is the optional body of a successful response to
+// the validate-signup event.
+type WebhookSignupResponse struct {
+	// AppMetadata is merged into the new user's app_metadata.
+	AppMetadata map[string]interface{} `json:"app_metadata"`
+}
+
+// webhookError is returned when the webhook responds with a non-2xx status.
+type webhookError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d", e.StatusCode)
+}
+
+// isRejection reports whether the webhook deliberately refused the event,
+// as opposed to failing to process it.
+func (e *webhookError) isRejection() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+func newWebhookPayload(event string, user *models.User) *WebhookPayload {
+	return &WebhookPayload{
+		ID:         uuid.Must(uuid.NewV4()).String(),
+		Event:      event,
+		OccurredAt: time.Now().UTC(),
+		User:       user,
+	}
+}
+
+// validateSignupWebhook lets the webhook reject a signup or add to the new
+// user's app_metadata before the user is created. A 4xx response rejects
+// the signup, other failures reject it unless WEBHOOK_SIGNUP_FAIL_OPEN is
+// set.
+func (a *API) validateSignupWebhook(r *http.Request, user *models.User) error {
+	config := a.config.Webhook
+	if !config.HasEvent(conf.WebhookValidateSignupEvent) {
+		return nil
+	}
+
+	log := observability.GetLogEntry(r).Entry.WithField("component", "webhook")
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.SignupTimeout)
+	defer cancel()
+
+	body, err := postWebhook(ctx, config, newWebhookPayload(conf.WebhookValidateSignupEvent, user))
+	if err != nil {
+		if whErr, ok := err.(*webhookError); ok && whErr.isRejection() {
+			message := whErr.Message
+			if message == "" {
+				message = "Signup was rejected"
+			}
+			return forbiddenError(ErrorCodeSignupRejected, message)
+		}
+
+		if config.SignupFailOpen {
+			log.WithError(err).Warn("validate-signup webhook failed, allowing the signup")
+			return nil
+		}
+
+		return internalServerError("Unable to validate the signup").WithInternalError(err)
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var response WebhookSignupResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		if config.SignupFailOpen {
+			log.WithError(err).Warn("invalid validate-signup webhook response, allowing the signup")
+			return nil
+		}
+
+		return internalServerError("Unable to validate the signup").WithInternalError(err)
+	}
+
+	if len(response.AppMetadata) > 0 && user.AppMetaData == nil {
+		user.AppMetaData = make(map[string]interface{})
+	}
+	for key, value := range response.AppMetadata {
+		// the providers are managed by auth
+		if key == "provider" || key == "providers" {
+			continue
+		}
+		user.AppMetaData[key] = value
+	}
+
+	return nil
+}
+
+// notifyWebhook sends the event to the webhook in the background once the
+// transaction of conn is committed, events of rolled back transactions are
+// dropped. Failed deliveries are retried with exponential backoff.
+func (a *API) notifyWebhook(r *http.Request, conn *storage.Connection, event string, user *models.User) {
+	config := a.config.Webhook
+	if !config.HasEvent(event) {
+		return
+	}
+
+	log := observability.GetLogEntry(r).Entry.WithField("component", "webhook")
+
+	// the payload is encoded right away, the user may change afterwards
+	payload := newWebhookPayload(event, user)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.WithError(err).Error("unable to encode webhook payload")
+		return
+	}
+
+	log = log.WithFields(logrus.Fields{
+		"event":    event,
+		"event_id": payload.ID,
+	})
+
+	conn.AfterCommit(func() {
+		deliverWebhook(a.backgroundContext(), log, config, payload.ID, body)
+	})
+}
+
+// deliverWebhook sends the event in the background. Retries stop once ctx is
+// done, so that shutdown doesn't wait for their backoff.
+func deliverWebhook(ctx context.Context, log *logrus.Entry, config conf.WebhookConfiguration, id string, body []byte) {
+	// shutdown waits for pending deliveries
+	cleanupWaitGroup.Add(1)
+	go func() {
+		defer cleanupWaitGroup.Done()
+
+		backoff := webhookBackoff
+		for attempt := 0; ; attempt++ {
+			// an attempt in progress isn't cancelled by shutdown
+			attemptCtx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+			_, err := sendWebhook(attemptCtx, config, id, body)
+			cancel()
+
+			if err == nil {
+				return
+			}
+
+			if attempt >= config.MaxRetries {
+				log.WithError(err).Error("webhook delivery failed, giving up")
+				return
+			}
+
+			log.WithError(err).WithField("attempt", attempt+1).Warn("webhook delivery failed, retrying")
+			if err := utilities.Sleep(ctx, backoff); err != nil {
+				log.WithError(err).Error("webhook delivery cancelled, giving up")
+				return
+			}
+			backoff *= 2
+		}
+	}()
+}
+
+func postWebhook(ctx context.Context, config conf.WebhookConfiguration, payload *WebhookPayload) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return sendWebhook(ctx, config, payload.ID, body)
+}
+
+// sendWebhook posts the body signed with the standard webhooks scheme and
+// returns the response body.
+func sendWebhook(ctx context.Context, config conf.WebhookConfiguration, id string, body []byte) ([]byte, error) {
+	msgID, err := uuid.FromString(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	signatures, err := crypto.GenerateSignatures(config.Secrets, msgID, now, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("webhook-id", id)
+	req.Header.Set("webhook-timestamp", fmt.Sprintf("%d", now.Unix()))
+	req.Header.Set("webhook-signature", strings.Join(signatures, ", "))
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	response, err := io.ReadAll(io.LimitReader(rsp.Body, webhookResponseLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		whErr := &webhookError{StatusCode: rsp.StatusCode}
+
+		var message struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(response, &message) == nil {
+			whErr.Message = message.Message
+		}
+
+		return nil, whErr
+	}
+
+	return response, nil
+}
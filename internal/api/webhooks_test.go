@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	standardwebhooks "github.com/standard-webhooks/standard-webhooks/libraries/go"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/storage"
+)
+
+const webhookTestSecret = "whsec_aWxpa2VzdXBhYmFzZXZlcnltdWNoYW5kaWhvcGV5b3Vkb3Rvbw=="
+
+// webhookReceiver records the events it receives after verifying their
+// signatures.
+type webhookReceiver struct {
+	t       *testing.T
+	respond func(n int, w http.ResponseWriter)
+
+	mutex    sync.Mutex
+	payloads []WebhookPayload
+	ids      []string
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wh, err := standardwebhooks.NewWebhook(webhookTestSecret)
+	require.NoError(rc.t, err)
+
+	body, err := io.ReadAll(r.Body)
+	require.NoError(rc.t, err)
+	if err := wh.Verify(body, r.Header); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var payload WebhookPayload
+	require.NoError(rc.t, json.Unmarshal(body, &payload))
+
+	rc.mutex.Lock()
+	n := len(rc.payloads)
+	rc.payloads = append(rc.payloads, payload)
+	rc.ids = append(rc.ids, r.Header.Get("webhook-id"))
+	rc.mutex.Unlock()
+
+	if rc.respond != nil {
+		rc.respond(n, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rc *webhookReceiver) received() ([]WebhookPayload, []string) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	return append([]WebhookPayload(nil), rc.payloads...), append([]string(nil), rc.ids...)
+}
+
+func setupWebhookForTest(t *testing.T, respond func(n int, w http.ResponseWriter), cb func(*conf.WebhookConfiguration)) (*API, *webhookReceiver) {
+	webhookBackoff = time.Millisecond
+
+	receiver := &webhookReceiver{t: t, respond: respond}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	config := &conf.GlobalConfiguration{
+		Webhook: conf.WebhookConfiguration{
+			URL:           server.URL,
+			Secrets:       conf.HTTPHookSecrets{"v1," + webhookTestSecret},
+			Events:        []string{conf.WebhookValidateSignupEvent, conf.WebhookLoginEvent, conf.WebhookUserDeletedEvent},
+			Timeout:       time.Second,
+			MaxRetries:    2,
+			SignupTimeout: time.Second,
+		},
+	}
+	if cb != nil {
+		cb(&config.Webhook)
+	}
+	require.NoError(t, config.Webhook.Validate())
+
+	return &API{config: config}, receiver
+}
+
+// noTransaction is a connection outside of transactions, events are sent
+// right away.
+var noTransaction = &storage.Connection{Connection: &pop.Connection{}}
+
+func newWebhookTestUser(t *testing.T) *models.User {
+	user, err := models.NewUser("", "webhook@example.com", "", "authenticated", nil)
+	require.NoError(t, err)
+	user.AppMetaData = map[string]interface{}{
+		"provider":  "email",
+		"providers": []string{"email"},
+	}
+	return user
+}
+
+func TestValidateSignupWebhookAllows(t *testing.T) {
+	api, receiver := setupWebhookForTest(t, nil, nil)
+	user := newWebhookTestUser(t)
+
+	require.NoError(t, api.validateSignupWebhook(httptest.NewRequest(http.MethodPost, "/signup", nil), user))
+
+	payloads, ids := receiver.received()
+	require.Len(t, payloads, 1)
+	require.Equal(t, conf.WebhookValidateSignupEvent, payloads[0].Event)
+	require.Equal(t, "webhook@example.com", payloads[0].User.GetEmail())
+	require.Equal(t, ids[0], payloads[0].ID)
+}
+
+func TestValidateSignupWebhookAugments(t *testing.T) {
+	api, _ := setupWebhookForTest(t, func(n int, w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"app_metadata": {"plan": "trial", "provider": "forged"}}`))
+	}, nil)
+	user := newWebhookTestUser(t)
+
+	require.NoError(t, api.validateSignupWebhook(httptest.NewRequest(http.MethodPost, "/signup", nil), user))
+	require.Equal(t, "trial", user.AppMetaData["plan"])
+	// the provider can't be changed by the webhook
+	require.Equal(t, "email", user.AppMetaData["provider"])
+}
+
+func TestValidateSignupWebhookVetoes(t *testing.T) {
+	api, _ := setupWebhookForTest(t, func(n int, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Signups from this domain are not allowed"}`))
+	}, nil)
+
+	err := api.validateSignupWebhook(httptest.NewRequest(http.MethodPost, "/signup", nil), newWebhookTestUser(t))
+	require.Error(t, err)
+
+	httpErr, ok := err.(*HTTPError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusForbidden, httpErr.HTTPStatus)
+	require.Equal(t, ErrorCodeSignupRejected, httpErr.ErrorCode)
+	require.Equal(t, "Signups from this domain are not allowed", httpErr.Message)
+}
+
+func TestValidateSignupWebhookFailure(t *testing.T) {
+	failing := func(n int, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	t.Run("FailClosed", func(t *testing.T) {
+		api, _ := setupWebhookForTest(t, failing, nil)
+
+		err := api.validateSignupWebhook(httptest.NewRequest(http.MethodPost, "/signup", nil), newWebhookTestUser(t))
+		require.Error(t, err)
+		require.Equal(t, http.StatusInternalServerError, err.(*HTTPError).HTTPStatus)
+	})
+
+	t.Run("FailOpen", func(t *testing.T) {
+		api, _ := setupWebhookForTest(t, failing, func(c *conf.WebhookConfiguration) {
+			c.SignupFailOpen = true
+		})
+
+		require.NoError(t, api.validateSignupWebhook(httptest.NewRequest(http.MethodPost, "/signup", nil), newWebhookTestUser(t)))
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		api, _ := setupWebhookForTest(t, func(n int, w http.ResponseWriter) {
+			time.Sleep(300 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		}, func(c *conf.WebhookConfiguration) {
+			c.SignupTimeout = 50 * time.Millisecond
+		})
+
+		start := time.Now()
+		err := api.validateSignupWebhook(httptest.NewRequest(http.MethodPost, "/signup", nil), newWebhookTestUser(t))
+		require.Error(t, err)
+		require.Less(t, time.Since(start), 250*time.Millisecond)
+	})
+}
+
+func TestValidateSignupWebhookNotSubscribed(t *testing.T) {
+	api, receiver := setupWebhookForTest(t, nil, func(c *conf.WebhookConfiguration) {
+		c.Events = []string{conf.WebhookLoginEvent}
+	})
+
+	require.NoError(t, api.validateSignupWebhook(httptest.NewRequest(http.MethodPost, "/signup", nil), newWebhookTestUser(t)))
+
+	payloads, _ := receiver.received()
+	require.Empty(t, payloads)
+}
+
+func TestNotifyWebhookRetries(t *testing.T) {
+	api, receiver := setupWebhookForTest(t, func(n int, w http.ResponseWriter) {
+		if n < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}, nil)
+
+	api.notifyWebhook(httptest.NewRequest(http.MethodPost, "/token", nil), noTransaction, conf.WebhookLoginEvent, newWebhookTestUser(t))
+
+	require.Eventually(t, func() bool {
+		payloads, _ := receiver.received()
+		return len(payloads) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// all attempts carry the same event ID
+	payloads, ids := receiver.received()
+	for i := range payloads {
+		require.Equal(t, conf.WebhookLoginEvent, payloads[i].Event)
+		require.Equal(t, payloads[0].ID, payloads[i].ID)
+		require.Equal(t, payloads[0].ID, ids[i])
+	}
+
+	// no more attempts after a successful delivery
+	time.Sleep(50 * time.Millisecond)
+	payloads, _ = receiver.received()
+	require.Len(t, payloads, 3)
+}
+
+func TestNotifyWebhookGivesUp(t *testing.T) {
+	api, receiver := setupWebhookForTest(t, func(n int, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}, nil)
+
+	api.notifyWebhook(httptest.NewRequest(http.MethodDelete, "/admin/users", nil), noTransaction, conf.WebhookUserDeletedEvent, newWebhookTestUser(t))
+
+	require.Eventually(t, func() bool {
+		payloads, _ := receiver.received()
+		return len(payloads) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	payloads, _ := receiver.received()
+	require.Len(t, payloads, 3)
+}
+
+func TestNotifyWebhookStopsRetryingOnShutdown(t *testing.T) {
+	api, receiver := setupWebhookForTest(t, func(n int, w http.ResponseWriter) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}, nil)
+	webhookBackoff = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	api.baseContext = ctx
+
+	api.notifyWebhook(httptest.NewRequest(http.MethodPost, "/token", nil), noTransaction, conf.WebhookLoginEvent, newWebhookTestUser(t))
+
+	require.Eventually(t, func() bool {
+		payloads, _ := receiver.received()
+		return len(payloads) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the delivery waits for its retry until the server shuts down
+	cancel()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	WaitForCleanup(waitCtx)
+	require.NoError(t, waitCtx.Err())
+
+	payloads, _ := receiver.received()
+	require.Len(t, payloads, 1)
+}
+
+func TestWebhookSignatureMismatch(t *testing.T) {
+	api, receiver := setupWebhookForTest(t, nil, func(c *conf.WebhookConfiguration) {
+		c.Secrets = conf.HTTPHookSecrets{"v1,whsec_b3RoZXJzZWNyZXRvdGhlcnNlY3JldG90aGVyc2VjcmV0"}
+		c.MaxRetries = 0
+	})
+
+	err := api.validateSignupWebhook(httptest.NewRequest(http.MethodPost, "/signup", nil), newWebhookTestUser(t))
+	require.Error(t, err)
+
+	// the receiver rejects payloads it can't verify
+	payloads, _ := receiver.received()
+	require.Empty(t, payloads)
+}
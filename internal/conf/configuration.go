@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"regexp"
@@ -21,6 +22,8 @@ const defaultMinPasswordLength int = 6
 const defaultChallengeExpiryDuration float64 = 300
 const defaultFactorExpiryDuration time.Duration = 300 * time.Second
 const defaultFlowStateExpiryDuration time.Duration = 300 * time.Second
+const defaultStateExpiryDuration time.Duration = 5 * time.Minute
+const defaultCleanupBatchSize int = 100
 
 // See: https://www.postgresql.org/docs/7.0/syntax525.htm
 var postgresNamesRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
@@ -61,6 +64,106 @@ type OAuthProviderConfiguration struct {
 	ApiURL         string   `json:"api_url" split_words:"true"`
 	Enabled        bool     `json:"enabled"`
 	SkipNonceCheck bool     `json:"skip_nonce_check" split_words:"true"`
+
+	// AllowSignup, when false, only lets users that already exist sign in
+	// with the provider.
+	AllowSignup bool `json:"allow_signup" split_words:"true" default:"true"`
+
+	// EmailDomains, when not empty, lists the only domains of verified email
+	// addresses the provider can sign in with. Entries like
+	// "*.corp.example.com" match any subdomain of corp.example.com.
+	EmailDomains []string `json:"email_domains" split_words:"true"`
+
+	// EmailOptional lets users sign in with the provider even if it gives
+	// no email address, which they can add to their account later.
+	EmailOptional bool `json:"email_optional" split_words:"true"`
+}
+
+// AppleProviderConfiguration configures Sign in with Apple. Apple's client
+// secrets are JWTs that expire after at most 6 months. Instead of a secret,
+// the key of the Apple developer account can be configured, which the
+// secrets are generated with as they are needed.
+type AppleProviderConfiguration struct {
+	OAuthProviderConfiguration
+
+	TeamID string `json:"team_id" envconfig:"TEAM_ID"`
+	KeyID  string `json:"key_id" envconfig:"KEY_ID"`
+
+	// PrivateKey is the PEM encoded .p8 key downloaded from Apple.
+	PrivateKey string `json:"private_key" split_words:"true"`
+}
+
+// HasKey reports whether the client secrets are generated with the key.
+func (c *AppleProviderConfiguration) HasKey() bool {
+	return c.PrivateKey != ""
+}
+
+// ValidateOAuth checks the configuration like
+// OAuthProviderConfiguration.ValidateOAuth, except that a key can be
+// configured instead of the secret.
+func (c *AppleProviderConfiguration) ValidateOAuth() error {
+	if !c.HasKey() {
+		return c.OAuthProviderConfiguration.ValidateOAuth()
+	}
+
+	if !c.Enabled {
+		return errors.New("provider is not enabled")
+	}
+	if len(c.ClientID) == 0 {
+		return errors.New("missing OAuth client ID")
+	}
+	if c.RedirectURI == "" {
+		return errors.New("missing redirect URI")
+	}
+	if c.TeamID == "" {
+		return errors.New("missing Apple team ID")
+	}
+	if c.KeyID == "" {
+		return errors.New("missing Apple key ID")
+	}
+	return nil
+}
+
+// AzureProviderConfiguration configures signing in with Microsoft accounts.
+type AzureProviderConfiguration struct {
+	OAuthProviderConfiguration
+
+	// TenantID restricts signing in to the users of a tenant, unless it's
+	// common, organizations or consumers. URL takes precedence over it,
+	// but the ID is still needed when URL names the tenant by its domain.
+	TenantID string `json:"tenant_id" split_words:"true"`
+}
+
+// OIDCProviderConfiguration configures signing in with any OpenID
+// Connect provider, such as Okta, Auth0 or Keycloak. URL is the issuer, whose
+// endpoints and keys are discovered.
+type OIDCProviderConfiguration struct {
+	OAuthProviderConfiguration
+
+	// Scopes are requested in addition to openid.
+	Scopes []string `json:"scopes" default:"profile,email"`
+}
+
+// IsEmailDomainAllowed reports whether the provider can sign in with the
+// email address.
+func (c *OAuthProviderConfiguration) IsEmailDomainAllowed(email string) bool {
+	if len(c.EmailDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	for _, pattern := range c.EmailDomains {
+		if matchEmailDomain(pattern, domain) {
+			return true
+		}
+	}
+
+	return false
 }
 
 type AnonymousProviderConfiguration struct {
@@ -84,9 +187,51 @@ type DBConfiguration struct {
 	HealthCheckPeriod time.Duration `json:"health_check_period" split_words:"true"`
 	MigrationsPath    string        `json:"migrations_path" split_words:"true" default:"./migrations"`
 	CleanupEnabled    bool          `json:"cleanup_enabled" split_words:"true" default:"false"`
+
+	// QueryTimeout bounds how long a single statement runs, so that a stuck
+	// database can't hold up every request. It is disabled when it is 0.
+	QueryTimeout time.Duration `json:"query_timeout" split_words:"true"`
+
+	// Automigrate applies pending migrations when the server is started
+	// without a command. Disable it to run `migrate up` separately.
+	Automigrate bool `json:"automigrate" default:"true"`
+
+	// AuditLogRetention is how long audit log entries are kept when
+	// cleanup is enabled. Entries are kept forever when it is 0.
+	AuditLogRetention time.Duration `json:"audit_log_retention" split_words:"true"`
+
+	// CleanupInterval is how often the server removes all stale rows in the
+	// background, independently of CleanupEnabled. The background cleanup
+	// is disabled when it is 0, such as when `gotrue cleanup` runs from cron.
+	CleanupInterval time.Duration `json:"cleanup_interval" split_words:"true"`
+
+	// CleanupBatchSize is the maximum number of rows deleted by a single
+	// cleanup statement, which bounds how long its locks are held.
+	CleanupBatchSize int `json:"cleanup_batch_size" split_words:"true"`
+
+	// CleanupRevokedTokenRetention is how long revoked refresh tokens are
+	// kept, so that the reuse of a recently revoked token can be detected.
+	CleanupRevokedTokenRetention time.Duration `json:"cleanup_revoked_token_retention" split_words:"true" default:"24h"`
+
+	// CleanupUnconfirmedUsersAfter is how long users who signed up without
+	// confirming their email or phone are kept. They are kept forever when
+	// it is 0.
+	CleanupUnconfirmedUsersAfter time.Duration `json:"cleanup_unconfirmed_users_after" split_words:"true"`
 }
 
 func (c *DBConfiguration) Validate() error {
+	if c.MaxPoolSize < 0 || c.MaxIdlePoolSize < 0 {
+		return errors.New("DB_MAX_POOL_SIZE and DB_MAX_IDLE_POOL_SIZE can't be negative")
+	}
+
+	if c.ConnMaxLifetime < 0 || c.ConnMaxIdleTime < 0 || c.HealthCheckPeriod < 0 || c.QueryTimeout < 0 {
+		return errors.New("DB_CONN_MAX_LIFETIME, DB_CONN_MAX_IDLE_TIME, DB_HEALTH_CHECK_PERIOD and DB_QUERY_TIMEOUT can't be negative")
+	}
+
+	if c.CleanupInterval < 0 || c.CleanupBatchSize < 0 || c.CleanupRevokedTokenRetention < 0 || c.CleanupUnconfirmedUsersAfter < 0 {
+		return errors.New("DB_CLEANUP_INTERVAL, DB_CLEANUP_BATCH_SIZE, DB_CLEANUP_REVOKED_TOKEN_RETENTION and DB_CLEANUP_UNCONFIRMED_USERS_AFTER can't be negative")
+	}
+
 	return nil
 }
 
@@ -100,6 +245,76 @@ type JWTConfiguration struct {
 	DefaultGroupName string   `json:"default_group_name" split_words:"true"`
 	Issuer           string   `json:"issuer"`
 	KeyID            string   `json:"key_id" split_words:"true"`
+
+	// SigningKey is a PEM encoded RSA, P-256 or Ed25519 private key which
+	// signs the access tokens instead of Secret.
+	SigningKey string  `json:"-" split_words:"true"`
+	Key        *JWTKey `json:"-" ignored:"true"`
+
+	// VerificationKeys are PEM encoded keys which signed access tokens
+	// earlier, and VerificationSecrets secrets which did, which are still
+	// accepted after the key or secret is rotated.
+	VerificationKeys    string    `json:"-" split_words:"true"`
+	VerificationSecrets []string  `json:"-" split_words:"true"`
+	VerifyingKeys       []*JWTKey `json:"-" ignored:"true"`
+
+	// KeysCacheTTL is how long the keys generated through the admin API are
+	// cached. Keys are published for JWTKeyPublishPeriod before they can
+	// sign, so that every server knows them by then.
+	KeysCacheTTL time.Duration `json:"keys_cache_ttl" split_words:"true" default:"1m"`
+
+	// DenylistEnabled lets access tokens be revoked before they expire, at
+	// the cost of looking up every access token in the denylist.
+	DenylistEnabled bool `json:"denylist_enabled" split_words:"true"`
+}
+
+// JWTKeyPublishPeriod is how long a key generated through the admin API is
+// published before it can be promoted to sign the access tokens, as long as
+// the JSON Web Key Set is cached.
+const JWTKeyPublishPeriod = 10 * time.Minute
+
+func (c *JWTConfiguration) Validate() error {
+	if c.SigningKey != "" {
+		if _, err := ParseJWTKey(c.SigningKey, c.KeyID); err != nil {
+			return err
+		}
+	}
+
+	if c.VerificationKeys != "" {
+		if _, err := ParseJWTKeys(c.VerificationKeys); err != nil {
+			return err
+		}
+	}
+
+	if c.KeysCacheTTL < 0 || c.KeysCacheTTL >= JWTKeyPublishPeriod {
+		return fmt.Errorf("JWT_KEYS_CACHE_TTL must be between 0 and %s", JWTKeyPublishPeriod)
+	}
+
+	return nil
+}
+
+// PopulateFields parses the signing and verification keys.
+func (c *JWTConfiguration) PopulateFields() error {
+	c.Key = nil
+	c.VerifyingKeys = nil
+
+	if c.SigningKey != "" {
+		key, err := ParseJWTKey(c.SigningKey, c.KeyID)
+		if err != nil {
+			return err
+		}
+		c.Key = key
+	}
+
+	if c.VerificationKeys != "" {
+		keys, err := ParseJWTKeys(c.VerificationKeys)
+		if err != nil {
+			return err
+		}
+		c.VerifyingKeys = keys
+	}
+
+	return nil
 }
 
 // MFAConfiguration holds all the MFA related Configuration
@@ -110,15 +325,66 @@ type MFAConfiguration struct {
 	RateLimitChallengeAndVerify float64       `split_words:"true" default:"15"`
 	MaxEnrolledFactors          float64       `split_words:"true" default:"10"`
 	MaxVerifiedFactors          int           `split_words:"true" default:"10"`
+
+	// RequireAAL2Audiences are the audiences whose users need an AAL2
+	// session to manage their account.
+	RequireAAL2Audiences []string `json:"require_aal2_audiences" envconfig:"REQUIRE_AAL2_AUDIENCES"`
+
+	Phone MFAPhoneConfiguration `json:"phone"`
+}
+
+// RequiresAAL2 tells whether the users of the audience need an AAL2 session.
+func (c *MFAConfiguration) RequiresAAL2(aud string) bool {
+	for _, required := range c.RequireAAL2Audiences {
+		if required == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// MFAPhoneConfiguration configures phone factors, whose codes are sent with
+// the SMS provider or the send SMS hook.
+type MFAPhoneConfiguration struct {
+	Enabled      bool          `json:"enabled"`
+	Template     string        `json:"template"`
+	MaxFrequency time.Duration `json:"max_frequency" split_words:"true" default:"60s"`
+
+	SMSTemplate *template.Template `json:"-"`
 }
 
 type APIConfiguration struct {
 	Host               string
 	Port               string `envconfig:"PORT" default:"8081"`
 	Endpoint           string
-	RequestIDHeader    string        `envconfig:"REQUEST_ID_HEADER"`
+	RequestIDHeader    string        `envconfig:"REQUEST_ID_HEADER" default:"X-Request-ID"`
 	ExternalURL        string        `json:"external_url" envconfig:"API_EXTERNAL_URL" required:"true"`
 	MaxRequestDuration time.Duration `json:"max_request_duration" split_words:"true" default:"10s"`
+
+	// TLSCertFile and TLSKeyFile enable serving HTTPS. They are reloaded
+	// on SIGHUP.
+	TLSCertFile string `json:"tls_cert_file" split_words:"true"`
+	TLSKeyFile  string `json:"tls_key_file" split_words:"true"`
+
+	// ShutdownTimeout is how long active requests may take to complete
+	// when the server shuts down.
+	ShutdownTimeout time.Duration `json:"shutdown_timeout" split_words:"true" default:"60s"`
+
+	// TrustedProxies lists the addresses or networks of the proxies in
+	// front of the server. The client address is taken from the
+	// X-Forwarded-For header only as far as it was added by them.
+	TrustedProxies []string `json:"trusted_proxies" split_words:"true"`
+
+	// XFFDepth is the number of proxies in front of the server that are
+	// trusted regardless of their address, for proxies without fixed
+	// addresses.
+	XFFDepth int `json:"xff_depth" split_words:"true"`
+
+	// InstanceConfigCacheTTL is how long the configuration overrides of an
+	// instance are cached. Updates through the admin API invalidate the
+	// cache of the server handling them, the other servers pick them up
+	// once it expires.
+	InstanceConfigCacheTTL time.Duration `json:"instance_config_cache_ttl" split_words:"true" default:"1m"`
 }
 
 func (a *APIConfiguration) Validate() error {
@@ -127,9 +393,70 @@ func (a *APIConfiguration) Validate() error {
 		return err
 	}
 
+	if (a.TLSCertFile == "") != (a.TLSKeyFile == "") {
+		return errors.New("both API_TLS_CERT_FILE and API_TLS_KEY_FILE must be set to enable TLS")
+	}
+
+	if a.ShutdownTimeout < 0 {
+		return errors.New("API_SHUTDOWN_TIMEOUT can't be negative")
+	}
+
+	if a.XFFDepth < 0 {
+		return errors.New("API_XFF_DEPTH can't be negative")
+	}
+
+	if a.InstanceConfigCacheTTL < 0 {
+		return errors.New("API_INSTANCE_CONFIG_CACHE_TTL can't be negative")
+	}
+
+	if _, err := a.TrustedProxyNetworks(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// TLSEnabled reports whether the API is served over HTTPS.
+func (a *APIConfiguration) TLSEnabled() bool {
+	return a.TLSCertFile != "" && a.TLSKeyFile != ""
+}
+
+// TrustedProxyNetworks parses TrustedProxies. Plain addresses are treated
+// as networks containing only that address.
+func (a *APIConfiguration) TrustedProxyNetworks() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, proxy := range a.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %q: %w", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
 type SessionsConfiguration struct {
 	Timebox           *time.Duration `json:"timebox"`
 	InactivityTimeout *time.Duration `json:"inactivity_timeout,omitempty" split_words:"true"`
@@ -139,12 +466,12 @@ type SessionsConfiguration struct {
 }
 
 func (c *SessionsConfiguration) Validate() error {
-	if c.Timebox == nil {
-		return nil
+	if c.Timebox != nil && *c.Timebox <= time.Duration(0) {
+		return fmt.Errorf("conf: session timebox duration must be positive when set, was %v", (*c.Timebox).String())
 	}
 
-	if *c.Timebox <= time.Duration(0) {
-		return fmt.Errorf("conf: session timebox duration must be positive when set, was %v", (*c.Timebox).String())
+	if c.InactivityTimeout != nil && *c.InactivityTimeout < time.Duration(0) {
+		return fmt.Errorf("conf: session inactivity timeout must not be negative, was %v", (*c.InactivityTimeout).String())
 	}
 
 	return nil
@@ -194,6 +521,12 @@ type HIBPConfiguration struct {
 
 	UserAgent string `json:"user_agent" split_words:"true" default:"https://github.com/supabase/gotrue"`
 
+	// Threshold is the number of times a password needs to have been seen
+	// in breaches to be rejected.
+	Threshold int           `json:"threshold" default:"1"`
+	Timeout   time.Duration `json:"timeout" default:"5s"`
+	CacheTTL  time.Duration `json:"cache_ttl" split_words:"true" default:"5m"`
+
 	Bloom HIBPBloomConfiguration `json:"bloom"`
 }
 
@@ -203,6 +536,150 @@ type PasswordConfiguration struct {
 	RequiredCharacters PasswordRequiredCharacters `json:"required_characters" split_words:"true"`
 
 	HIBP HIBPConfiguration `json:"hibp"`
+
+	// HashAlgorithm is the algorithm of new password hashes, bcrypt or
+	// argon2id. Passwords hashed with another algorithm or cost are rehashed
+	// when users sign in.
+	HashAlgorithm string `json:"hash_algorithm" split_words:"true" default:"bcrypt"`
+	BcryptCost    int    `json:"bcrypt_cost" split_words:"true" default:"10"`
+
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32 `json:"argon2_memory" split_words:"true" default:"19456"`
+	Argon2Iterations  uint32 `json:"argon2_iterations" split_words:"true" default:"2"`
+	Argon2Parallelism uint8  `json:"argon2_parallelism" split_words:"true" default:"1"`
+}
+
+func (c *PasswordConfiguration) Validate() error {
+	switch c.HashAlgorithm {
+	case "bcrypt":
+		// bcrypt.MinCost and bcrypt.MaxCost
+		if c.BcryptCost < 4 || c.BcryptCost > 31 {
+			return errors.New("PASSWORD_BCRYPT_COST must be between 4 and 31")
+		}
+
+	case "argon2id":
+		// crypto.MaxArgon2Iterations, crypto.MaxArgon2Parallelism and
+		// crypto.MaxArgon2Memory
+		if c.Argon2Iterations < 1 || c.Argon2Iterations > 32 {
+			return errors.New("PASSWORD_ARGON2_ITERATIONS must be between 1 and 32")
+		}
+		if c.Argon2Parallelism < 1 || c.Argon2Parallelism > 16 {
+			return errors.New("PASSWORD_ARGON2_PARALLELISM must be between 1 and 16")
+		}
+		if c.Argon2Memory < 8*uint32(c.Argon2Parallelism) {
+			return errors.New("PASSWORD_ARGON2_MEMORY must be at least 8 KiB per thread of PASSWORD_ARGON2_PARALLELISM")
+		}
+		if c.Argon2Memory > 1024*1024 {
+			return errors.New("PASSWORD_ARGON2_MEMORY must be at most 1048576 KiB")
+		}
+
+	default:
+		return fmt.Errorf("PASSWORD_HASH_ALGORITHM must be bcrypt or argon2id, not %q", c.HashAlgorithm)
+	}
+
+	return nil
+}
+
+// SignupConfiguration restricts the email addresses that can be used to
+// create new accounts.
+type SignupConfiguration struct {
+	// EmailDomainAllowList, when not empty, lists the only email domains
+	// that can sign up.
+	EmailDomainAllowList []string `json:"email_domain_allow_list" split_words:"true"`
+	EmailDomainBlockList []string `json:"email_domain_block_list" split_words:"true"`
+}
+
+// IsEmailDomainAllowed reports whether an account can be created with the
+// email address. Domains are matched case-insensitively and entries like
+// "*.corp.example.com" match any subdomain of corp.example.com. The block
+// list takes precedence over the allow list.
+func (c *SignupConfiguration) IsEmailDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	for _, pattern := range c.EmailDomainBlockList {
+		if matchEmailDomain(pattern, domain) {
+			return false
+		}
+	}
+
+	if len(c.EmailDomainAllowList) == 0 {
+		return true
+	}
+
+	for _, pattern := range c.EmailDomainAllowList {
+		if matchEmailDomain(pattern, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchEmailDomain(pattern, domain string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(domain, pattern[1:])
+	}
+	return domain == pattern
+}
+
+// LocalizationConfiguration controls which language emails and SMS messages
+// are sent in.
+type LocalizationConfiguration struct {
+	DefaultLanguage string `json:"default_language" split_words:"true" default:"en"`
+}
+
+// LanguageFallbacks returns the languages to look for, in order, when
+// localizing content for language. Regional variants fall back to their base
+// language and finally to the default language, so "pt-BR" results in
+// "pt-BR", "pt", "en".
+func (c *LocalizationConfiguration) LanguageFallbacks(language string) []string {
+	var languages []string
+
+	add := func(language string) {
+		language = strings.ReplaceAll(strings.TrimSpace(language), "_", "-")
+		for language != "" {
+			found := false
+			for _, existing := range languages {
+				if strings.EqualFold(existing, language) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				languages = append(languages, language)
+			}
+
+			i := strings.LastIndex(language, "-")
+			if i < 0 {
+				break
+			}
+			language = language[:i]
+		}
+	}
+
+	add(language)
+	add(c.DefaultLanguage)
+
+	return languages
+}
+
+// LookupLocalized returns the value for the first of languages that has one
+// in values. Languages are matched case-insensitively.
+func LookupLocalized(values map[string]string, languages []string) (string, bool) {
+	for _, language := range languages {
+		for key, value := range values {
+			if strings.EqualFold(key, language) && value != "" {
+				return value, true
+			}
+		}
+	}
+
+	return "", false
 }
 
 // GlobalConfiguration holds all the configuration that applies to all instances.
@@ -225,18 +702,22 @@ type GlobalConfiguration struct {
 	RateLimitAnonymousUsers float64 `split_words:"true" default:"30"`
 	RateLimitOtp            float64 `split_words:"true" default:"30"`
 
+	IPRateLimit IPRateLimitConfiguration `json:"ip_rate_limit" split_words:"true"`
+
 	SiteURL         string   `json:"site_url" split_words:"true" required:"true"`
 	URIAllowList    []string `json:"uri_allow_list" split_words:"true"`
 	URIAllowListMap map[string]glob.Glob
-	Password        PasswordConfiguration    `json:"password"`
-	JWT             JWTConfiguration         `json:"jwt"`
-	Mailer          MailerConfiguration      `json:"mailer"`
-	Sms             SmsProviderConfiguration `json:"sms"`
-	DisableSignup   bool                     `json:"disable_signup" split_words:"true"`
-	Hook            HookConfiguration        `json:"hook" split_words:"true"`
-	Security        SecurityConfiguration    `json:"security"`
-	Sessions        SessionsConfiguration    `json:"sessions"`
-	MFA             MFAConfiguration         `json:"MFA"`
+	Password        PasswordConfiguration     `json:"password"`
+	JWT             JWTConfiguration          `json:"jwt"`
+	Mailer          MailerConfiguration       `json:"mailer"`
+	Sms             SmsProviderConfiguration  `json:"sms"`
+	DisableSignup   bool                      `json:"disable_signup" split_words:"true"`
+	Signup          SignupConfiguration       `json:"signup"`
+	Localization    LocalizationConfiguration `json:"localization"`
+	Hook            HookConfiguration         `json:"hook" split_words:"true"`
+	Security        SecurityConfiguration     `json:"security"`
+	Sessions        SessionsConfiguration     `json:"sessions"`
+	MFA             MFAConfiguration          `json:"MFA"`
 	Cookie          struct {
 		Key      string `json:"key"`
 		Domain   string `json:"domain"`
@@ -244,13 +725,89 @@ type GlobalConfiguration struct {
 	} `json:"cookies"`
 	SAML SAMLConfiguration `json:"saml"`
 	CORS CORSConfiguration `json:"cors"`
+
+	Webhook WebhookConfiguration `json:"webhook"`
+
+	DeviceAuthorization DeviceAuthorizationConfiguration `json:"device_authorization" split_words:"true"`
+
+	ClientCredentials ClientCredentialsConfiguration `json:"client_credentials" split_words:"true"`
+
+	FeatureFlags FeatureFlagsConfiguration `json:"feature_flags" split_words:"true"`
+
+	SCIM SCIMConfiguration `json:"scim"`
+
+	LDAP LDAPConfiguration `json:"ldap"`
+
+	WebAuthn WebAuthnConfiguration `json:"webauthn"`
+}
+
+// IPRateLimitConfiguration limits the requests each client IP address can
+// make within a window. Each limit is a number of requests per window, 0
+// disables it.
+type IPRateLimitConfiguration struct {
+	Enabled bool          `json:"enabled"`
+	Window  time.Duration `json:"window" default:"5m"`
+
+	Global        int `json:"global" default:"300"`
+	TokenPassword int `json:"token_password" split_words:"true" default:"30"`
+	Signup        int `json:"signup" default:"30"`
+	Recover       int `json:"recover" default:"30"`
+	Verify        int `json:"verify" default:"30"`
+	Otp           int `json:"otp" default:"30"`
+	Device        int `json:"device" default:"30"`
+}
+
+func (c *IPRateLimitConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Window <= 0 {
+		return errors.New("IP_RATE_LIMIT_WINDOW must be positive")
+	}
+
+	for _, limit := range []int{c.Global, c.TokenPassword, c.Signup, c.Recover, c.Verify, c.Otp, c.Device} {
+		if limit < 0 {
+			return errors.New("IP rate limits can't be negative")
+		}
+	}
+
+	return nil
 }
 
 type CORSConfiguration struct {
-	AllowedHeaders []string `json:"allowed_headers" split_words:"true"`
+	// AllowedOrigins restricts the origins browsers may make requests
+	// from. An origin may contain one wildcard, as in
+	// https://*.example.com. When empty any origin is allowed.
+	AllowedOrigins []string      `json:"allowed_origins" split_words:"true"`
+	AllowedHeaders []string      `json:"allowed_headers" split_words:"true"`
+	ExposedHeaders []string      `json:"exposed_headers" split_words:"true"`
+	MaxAge         time.Duration `json:"max_age" split_words:"true"`
+}
+
+func (c *CORSConfiguration) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("CORS allowed origin %q can contain only one wildcard", origin)
+		}
+	}
+
+	if c.MaxAge < 0 {
+		return errors.New("CORS_MAX_AGE can't be negative")
+	}
+
+	return nil
 }
 
 func (c *CORSConfiguration) AllAllowedHeaders(defaults []string) []string {
+	return mergeHeaders(defaults, c.AllowedHeaders)
+}
+
+func (c *CORSConfiguration) AllExposedHeaders(defaults []string) []string {
+	return mergeHeaders(defaults, c.ExposedHeaders)
+}
+
+func mergeHeaders(defaults, extra []string) []string {
 	set := make(map[string]bool)
 	for _, header := range defaults {
 		set[header] = true
@@ -259,7 +816,7 @@ func (c *CORSConfiguration) AllAllowedHeaders(defaults []string) []string {
 	var result []string
 	result = append(result, defaults...)
 
-	for _, header := range c.AllowedHeaders {
+	for _, header := range extra {
 		if !set[header] {
 			result = append(result, header)
 		}
@@ -270,6 +827,17 @@ func (c *CORSConfiguration) AllAllowedHeaders(defaults []string) []string {
 	return result
 }
 
+// LocalizedEmailContentConfiguration holds per-language subjects or template
+// URLs for emails, keyed by language tag.
+type LocalizedEmailContentConfiguration struct {
+	Invite           map[string]string `json:"invite"`
+	Confirmation     map[string]string `json:"confirmation"`
+	Recovery         map[string]string `json:"recovery"`
+	EmailChange      map[string]string `json:"email_change" split_words:"true"`
+	MagicLink        map[string]string `json:"magic_link" split_words:"true"`
+	Reauthentication map[string]string `json:"reauthentication"`
+}
+
 // EmailContentConfiguration holds the configuration for emails, both subjects and template URLs.
 type EmailContentConfiguration struct {
 	Invite           string `json:"invite"`
@@ -282,8 +850,8 @@ type EmailContentConfiguration struct {
 
 type ProviderConfiguration struct {
 	AnonymousUsers          AnonymousProviderConfiguration `json:"anonymous_users" split_words:"true"`
-	Apple                   OAuthProviderConfiguration     `json:"apple"`
-	Azure                   OAuthProviderConfiguration     `json:"azure"`
+	Apple                   AppleProviderConfiguration     `json:"apple"`
+	Azure                   AzureProviderConfiguration     `json:"azure"`
 	Bitbucket               OAuthProviderConfiguration     `json:"bitbucket"`
 	Discord                 OAuthProviderConfiguration     `json:"discord"`
 	Facebook                OAuthProviderConfiguration     `json:"facebook"`
@@ -294,7 +862,9 @@ type ProviderConfiguration struct {
 	Google                  OAuthProviderConfiguration     `json:"google"`
 	Kakao                   OAuthProviderConfiguration     `json:"kakao"`
 	Notion                  OAuthProviderConfiguration     `json:"notion"`
+	OIDC                    OIDCProviderConfiguration      `json:"oidc"`
 	Keycloak                OAuthProviderConfiguration     `json:"keycloak"`
+	Line                    OAuthProviderConfiguration     `json:"line"`
 	Linkedin                OAuthProviderConfiguration     `json:"linkedin"`
 	LinkedinOIDC            OAuthProviderConfiguration     `json:"linkedin_oidc" envconfig:"LINKEDIN_OIDC"`
 	Spotify                 OAuthProviderConfiguration     `json:"spotify"`
@@ -303,6 +873,7 @@ type ProviderConfiguration struct {
 	Twitter                 OAuthProviderConfiguration     `json:"twitter"`
 	Twitch                  OAuthProviderConfiguration     `json:"twitch"`
 	WorkOS                  OAuthProviderConfiguration     `json:"workos"`
+	X                       OAuthProviderConfiguration     `json:"x"`
 	Email                   EmailProviderConfiguration     `json:"email"`
 	Phone                   PhoneProviderConfiguration     `json:"phone"`
 	Zoom                    OAuthProviderConfiguration     `json:"zoom"`
@@ -310,6 +881,10 @@ type ProviderConfiguration struct {
 	RedirectURL             string                         `json:"redirect_url"`
 	AllowedIdTokenIssuers   []string                       `json:"allowed_id_token_issuers" split_words:"true"`
 	FlowStateExpiryDuration time.Duration                  `json:"flow_state_expiry_duration" split_words:"true"`
+
+	// StateExpiryDuration is how long the state of an OAuth flow is valid,
+	// that is how long the user has to sign in with the provider.
+	StateExpiryDuration time.Duration `json:"state_expiry_duration" split_words:"true"`
 }
 
 type SMTPConfiguration struct {
@@ -320,9 +895,16 @@ type SMTPConfiguration struct {
 	Pass         string        `json:"pass,omitempty"`
 	AdminEmail   string        `json:"admin_email" split_words:"true"`
 	SenderName   string        `json:"sender_name" split_words:"true"`
+
+	// MaxRetries is how often sending is retried after a transient failure,
+	// such as a 4xx reply or a network timeout.
+	MaxRetries int `json:"max_retries" split_words:"true" default:"3"`
 }
 
 func (c *SMTPConfiguration) Validate() error {
+	if c.MaxRetries < 0 {
+		return errors.New("smtp max retries can't be negative")
+	}
 	return nil
 }
 
@@ -334,26 +916,249 @@ type MailerConfiguration struct {
 	Templates EmailContentConfiguration `json:"templates"`
 	URLPaths  EmailContentConfiguration `json:"url_paths"`
 
+	// LocalizedSubjects and LocalizedTemplates take precedence over Subjects
+	// and Templates for users with a matching language.
+	LocalizedSubjects  LocalizedEmailContentConfiguration `json:"localized_subjects" split_words:"true"`
+	LocalizedTemplates LocalizedEmailContentConfiguration `json:"localized_templates" split_words:"true"`
+
+	// TemplateCacheTTL controls how long remote and file templates are
+	// cached before they are loaded again.
+	TemplateCacheTTL time.Duration `json:"template_cache_ttl" split_words:"true" default:"5m"`
+
+	// Timeout bounds sending a single email over SMTP, retries included.
+	Timeout time.Duration `json:"timeout" default:"30s"`
+
 	SecureEmailChangeEnabled bool `json:"secure_email_change_enabled" split_words:"true" default:"true"`
 
-	OtpExp    uint `json:"otp_exp" split_words:"true"`
-	OtpLength int  `json:"otp_length" split_words:"true"`
+	OtpExp      uint        `json:"otp_exp" split_words:"true"`
+	OtpLength   int         `json:"otp_length" split_words:"true"`
+	OtpAlphabet OtpAlphabet `json:"otp_alphabet" split_words:"true"`
+
+	// OtpMaxAttempts is how many wrong OTPs can be entered for a user before
+	// the OTPs and links sent to them are invalidated. 0 disables the limit.
+	OtpMaxAttempts int `json:"otp_max_attempts" split_words:"true" default:"5"`
+
+	// SendLimit limits how many emails of each type an address receives.
+	SendLimit EmailSendLimitConfiguration `json:"send_limit" split_words:"true"`
+
+	// Provider selects how emails are delivered: "smtp" or "hook" to deliver
+	// every email with the send email hook. When it isn't set, emails are
+	// delivered with the send email hook if it's enabled and over SMTP
+	// otherwise.
+	Provider string `json:"provider"`
+}
+
+func (c *MailerConfiguration) Validate() error {
+	if err := c.SendLimit.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.OtpAlphabet.Validate(); err != nil {
+		return fmt.Errorf("MAILER_OTP_ALPHABET is invalid: %w", err)
+	}
+
+	if c.OtpMaxAttempts < 0 {
+		return errors.New("MAILER_OTP_MAX_ATTEMPTS can't be negative")
+	}
+
+	switch c.Provider {
+	case "", "smtp", "hook":
+		return nil
+	default:
+		return fmt.Errorf("unsupported mailer provider: %s", c.Provider)
+	}
+}
+
+// EmailSendLimitConfiguration limits the confirmation, recovery, magic link
+// and email change emails sent to an address, so that nobody can flood an
+// address they don't own with emails.
+type EmailSendLimitConfiguration struct {
+	// MaxPerHour is the number of emails of each type an address receives
+	// per hour. Zero, the default, disables the limit.
+	MaxPerHour int `json:"max_per_hour" split_words:"true"`
+
+	// MinInterval is the time that must pass between two emails of the
+	// same type to an address.
+	MinInterval time.Duration `json:"min_interval" split_words:"true"`
+
+	// SilentEndpoints respond as if the email was sent when a limit is
+	// reached, so that they don't reveal whether an address has an account.
+	// Other endpoints respond with 429 Too Many Requests.
+	SilentEndpoints []string `json:"silent_endpoints" split_words:"true" default:"signup,magiclink"`
+}
+
+func (c *EmailSendLimitConfiguration) Validate() error {
+	if c.MaxPerHour < 0 {
+		return errors.New("email send limit: max per hour can't be negative")
+	}
+	if c.MinInterval < 0 {
+		return errors.New("email send limit: min interval can't be negative")
+	}
+	return nil
+}
+
+// Enabled reports whether any limit is configured.
+func (c *EmailSendLimitConfiguration) Enabled() bool {
+	return c.MaxPerHour > 0 || c.MinInterval > 0
+}
+
+// IsSilent reports whether endpoint drops emails over the limit silently.
+func (c *EmailSendLimitConfiguration) IsSilent(endpoint string) bool {
+	for _, e := range c.SilentEndpoints {
+		if strings.EqualFold(strings.TrimSpace(e), endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retention is how long a counter affects whether an email can be sent.
+func (c *EmailSendLimitConfiguration) Retention() time.Duration {
+	if c.MinInterval > time.Hour {
+		return c.MinInterval
+	}
+	return time.Hour
+}
+
+// validateHTTPHookURL requires https, except for local development.
+func validateHTTPHookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+	case "http":
+		hostname := u.Hostname()
+		if hostname != "localhost" && hostname != "127.0.0.1" && hostname != "::1" && hostname != "host.docker.internal" {
+			return fmt.Errorf("only localhost, 127.0.0.1, and ::1 are supported with http")
+		}
+	default:
+		return fmt.Errorf("url must use https")
+	}
+
+	return nil
+}
+
+// Events sent to the webhook.
+const (
+	WebhookValidateSignupEvent = "validate-signup"
+	WebhookSignupEvent         = "signup"
+	WebhookLoginEvent          = "login"
+	WebhookUserUpdatedEvent    = "user.updated"
+	WebhookUserDeletedEvent    = "user.deleted"
+)
+
+// WebhookConfiguration configures the webhook notified of signups, logins
+// and changes to users.
+type WebhookConfiguration struct {
+	URL        string          `json:"url"`
+	Secrets    HTTPHookSecrets `json:"secrets" envconfig:"secrets"`
+	Events     []string        `json:"events"`
+	Timeout    time.Duration   `json:"timeout" default:"5s"`
+	MaxRetries int             `json:"max_retries" split_words:"true" default:"3"`
+
+	// SignupTimeout bounds the validate-signup request, which blocks the
+	// signup and is not retried.
+	SignupTimeout time.Duration `json:"signup_timeout" split_words:"true" default:"2s"`
+	// SignupFailOpen lets signups proceed when the validate-signup request
+	// fails instead of rejecting them.
+	SignupFailOpen bool `json:"signup_fail_open" split_words:"true"`
+}
+
+func (c *WebhookConfiguration) Validate() error {
+	if c.URL == "" {
+		return nil
+	}
+
+	if err := validateHTTPHookURL(c.URL); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+
+	if len(c.Secrets) == 0 {
+		return errors.New("webhook: at least one secret is required")
+	}
+	if err := validateHTTPHookSecrets(c.Secrets); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+
+	for _, event := range c.Events {
+		switch event {
+		case WebhookValidateSignupEvent, WebhookSignupEvent, WebhookLoginEvent, WebhookUserUpdatedEvent, WebhookUserDeletedEvent:
+		default:
+			return fmt.Errorf("webhook: unknown event %q", event)
+		}
+	}
+
+	if c.Timeout <= 0 || c.SignupTimeout <= 0 {
+		return errors.New("webhook: timeouts must be positive durations")
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("webhook: max retries can't be negative")
+	}
+
+	return nil
+}
+
+// HasEvent reports whether the event is sent to the webhook.
+func (c *WebhookConfiguration) HasEvent(event string) bool {
+	if c.URL == "" {
+		return false
+	}
+
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
 }
 
 type PhoneProviderConfiguration struct {
 	Enabled bool `json:"enabled" default:"false"`
 }
 
+// OtpAlphabet selects the characters of OTPs.
+type OtpAlphabet string
+
+const (
+	// OtpAlphabetDigits OTPs only have digits, the default.
+	OtpAlphabetDigits OtpAlphabet = "digits"
+	// OtpAlphabetAlphanumeric OTPs have upper case letters and digits,
+	// without the easily confused ones.
+	OtpAlphabetAlphanumeric OtpAlphabet = "alphanumeric"
+)
+
+func (a OtpAlphabet) Validate() error {
+	switch a {
+	case "", OtpAlphabetDigits, OtpAlphabetAlphanumeric:
+		return nil
+	default:
+		return fmt.Errorf("unsupported OTP alphabet %q, use %q or %q", a, OtpAlphabetDigits, OtpAlphabetAlphanumeric)
+	}
+}
+
 type SmsProviderConfiguration struct {
-	Autoconfirm       bool               `json:"autoconfirm"`
-	MaxFrequency      time.Duration      `json:"max_frequency" split_words:"true"`
-	OtpExp            uint               `json:"otp_exp" split_words:"true"`
-	OtpLength         int                `json:"otp_length" split_words:"true"`
-	Provider          string             `json:"provider"`
-	Template          string             `json:"template"`
-	TestOTP           map[string]string  `json:"test_otp" split_words:"true"`
-	TestOTPValidUntil Time               `json:"test_otp_valid_until" split_words:"true"`
-	SMSTemplate       *template.Template `json:"-"`
+	Autoconfirm    bool          `json:"autoconfirm"`
+	MaxFrequency   time.Duration `json:"max_frequency" split_words:"true"`
+	OtpExp         uint          `json:"otp_exp" split_words:"true"`
+	OtpLength      int           `json:"otp_length" split_words:"true"`
+	OtpAlphabet    OtpAlphabet   `json:"otp_alphabet" split_words:"true"`
+	OtpMaxAttempts int           `json:"otp_max_attempts" split_words:"true" default:"5"`
+	Provider       string        `json:"provider"`
+	// FallbackProviders are tried in order when sending with Provider fails.
+	FallbackProviders []string `json:"fallback_providers" split_words:"true"`
+	// CountryProviders maps country calling codes, or longer prefixes of
+	// phone numbers, to the provider sending to them instead of Provider.
+	CountryProviders      map[string]string             `json:"country_providers" split_words:"true"`
+	Template              string                        `json:"template"`
+	LocalizedTemplates    map[string]string             `json:"localized_templates" split_words:"true"`
+	TestOTP               map[string]string             `json:"test_otp" split_words:"true"`
+	TestOTPValidUntil     Time                          `json:"test_otp_valid_until" split_words:"true"`
+	SMSTemplate           *template.Template            `json:"-"`
+	LocalizedSMSTemplates map[string]*template.Template `json:"-"`
 
 	Twilio       TwilioProviderConfiguration       `json:"twilio"`
 	TwilioVerify TwilioVerifyProviderConfiguration `json:"twilio_verify" split_words:"true"`
@@ -361,6 +1166,73 @@ type SmsProviderConfiguration struct {
 	Textlocal    TextlocalProviderConfiguration    `json:"textlocal"`
 	Vonage       VonageProviderConfiguration       `json:"vonage"`
 	Msg91        Msg91ProviderConfiguration        `json:"msg91"`
+	Sns          SnsProviderConfiguration          `json:"sns"`
+}
+
+func (c *SmsProviderConfiguration) Validate() error {
+	if err := c.OtpAlphabet.Validate(); err != nil {
+		return fmt.Errorf("SMS_OTP_ALPHABET is invalid: %w", err)
+	}
+
+	if c.OtpMaxAttempts < 0 {
+		return errors.New("SMS_OTP_MAX_ATTEMPTS can't be negative")
+	}
+
+	if len(c.FallbackProviders) > 0 {
+		// Twilio Verify checks the otps it sends itself, so another
+		// provider can't take over
+		if c.IsTwilioVerifyProvider() {
+			return errors.New("SMS_FALLBACK_PROVIDERS can't be used with the twilio_verify provider")
+		}
+
+		seen := map[string]bool{c.Provider: true}
+		for _, provider := range c.FallbackProviders {
+			if !isRoutableSmsProvider(provider) {
+				return fmt.Errorf("SMS_FALLBACK_PROVIDERS contains the unsupported provider %q", provider)
+			}
+			if seen[provider] {
+				return fmt.Errorf("SMS_FALLBACK_PROVIDERS contains the provider %q more than once", provider)
+			}
+			seen[provider] = true
+		}
+	}
+
+	if len(c.CountryProviders) > 0 {
+		if c.Provider == "" {
+			return errors.New("SMS_COUNTRY_PROVIDERS requires SMS_PROVIDER for the other countries")
+		}
+		if c.IsTwilioVerifyProvider() {
+			return errors.New("SMS_COUNTRY_PROVIDERS can't be used with the twilio_verify provider")
+		}
+
+		for prefix, provider := range c.CountryProviders {
+			if !smsCountryPrefixRegexp.MatchString(strings.TrimSpace(prefix)) {
+				return fmt.Errorf("SMS_COUNTRY_PROVIDERS contains the invalid country code %q", prefix)
+			}
+			if !isRoutableSmsProvider(provider) {
+				return fmt.Errorf("SMS_COUNTRY_PROVIDERS contains the unsupported provider %q", provider)
+			}
+		}
+	}
+
+	return nil
+}
+
+var smsCountryPrefixRegexp = regexp.MustCompile(`^\+?[1-9][0-9]{0,5}$`)
+
+// awsRegionRegexp matches AWS region names such as us-east-1 or
+// us-gov-west-1, which are part of the host name of the SNS endpoint.
+var awsRegionRegexp = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// isRoutableSmsProvider tells whether SMS can be sent with the provider
+// instead of another one.
+func isRoutableSmsProvider(provider string) bool {
+	switch provider {
+	case "twilio", "messagebird", "textlocal", "vonage", "msg91", "sns":
+		return true
+	default:
+		return false
+	}
 }
 
 func (c *SmsProviderConfiguration) GetTestOTP(phone string, now time.Time) (string, bool) {
@@ -372,6 +1244,30 @@ func (c *SmsProviderConfiguration) GetTestOTP(phone string, now time.Time) (stri
 	return "", false
 }
 
+func (c *SmsProviderConfiguration) parseLocalizedTemplates() error {
+	c.LocalizedSMSTemplates = make(map[string]*template.Template, len(c.LocalizedTemplates))
+	for language, localized := range c.LocalizedTemplates {
+		parsed, err := template.New(language).Parse(localized)
+		if err != nil {
+			return fmt.Errorf("invalid %s SMS template: %w", language, err)
+		}
+		c.LocalizedSMSTemplates[strings.ToLower(language)] = parsed
+	}
+	return nil
+}
+
+// GetSMSTemplate returns the template for the first of languages that has a
+// localized SMS template, or the default template otherwise.
+func (c *SmsProviderConfiguration) GetSMSTemplate(languages []string) *template.Template {
+	for _, language := range languages {
+		if localized, ok := c.LocalizedSMSTemplates[strings.ToLower(language)]; ok {
+			return localized
+		}
+	}
+
+	return c.SMSTemplate
+}
+
 type TwilioProviderConfiguration struct {
 	AccountSid        string `json:"account_sid" split_words:"true"`
 	AuthToken         string `json:"auth_token" split_words:"true"`
@@ -396,20 +1292,51 @@ type TextlocalProviderConfiguration struct {
 }
 
 type Msg91ProviderConfiguration struct {
-	AuthKey       string  `json:"auth_key" split_words:"true"`
-	TemplateId      string  `json:"template_id" split_words:"true"`
+	AuthKey    string `json:"auth_key" split_words:"true"`
+	TemplateId string `json:"template_id" split_words:"true"`
+
+	// WhatsappNumber is the integrated number WhatsApp messages are sent
+	// from with the authentication template named WhatsappTemplateName.
+	WhatsappNumber           string `json:"whatsapp_number" split_words:"true"`
+	WhatsappTemplateName     string `json:"whatsapp_template_name" split_words:"true"`
+	WhatsappTemplateLanguage string `json:"whatsapp_template_language" split_words:"true" default:"en"`
+}
+
+// SnsProviderConfiguration configures sending SMS through Amazon SNS.
+// SnsProviderConfiguration has the static credentials SMS are sent with, as
+// the credentials of the environment, such as an instance role, aren't used.
+type SnsProviderConfiguration struct {
+	AccessKeyID     string `json:"access_key_id" split_words:"true"`
+	SecretAccessKey string `json:"secret_access_key" split_words:"true"`
+	// SessionToken is only needed with temporary credentials.
+	SessionToken string `json:"session_token" split_words:"true"`
+	Region       string `json:"region"`
+	// SenderID is the name messages are sent from, where supported.
+	SenderID string `json:"sender_id" split_words:"true"`
+	// SMSType is either Transactional or Promotional.
+	SMSType string `json:"sms_type" split_words:"true" default:"Transactional"`
 }
 
 type VonageProviderConfiguration struct {
 	ApiKey    string `json:"api_key" split_words:"true"`
 	ApiSecret string `json:"api_secret" split_words:"true"`
 	From      string `json:"from" split_words:"true"`
+
+	// SignatureSecret signs requests instead of sending the API secret.
+	SignatureSecret string `json:"signature_secret" split_words:"true"`
 }
 
 type CaptchaConfiguration struct {
-	Enabled  bool   `json:"enabled" default:"false"`
-	Provider string `json:"provider" default:"hcaptcha"`
-	Secret   string `json:"provider_secret"`
+	Enabled  bool          `json:"enabled" default:"false"`
+	Provider string        `json:"provider" default:"hcaptcha"`
+	Secret   string        `json:"provider_secret"`
+	Timeout  time.Duration `json:"timeout" default:"10s"`
+
+	// SiteKey is the public key frontends use to render the captcha widget.
+	SiteKey string `json:"site_key" split_words:"true"`
+
+	// VerifyURL overrides the provider's siteverify endpoint.
+	VerifyURL string `json:"verify_url" split_words:"true"`
 }
 
 func (c *CaptchaConfiguration) Validate() error {
@@ -427,6 +1354,244 @@ func (c *CaptchaConfiguration) Validate() error {
 		return errors.New("captcha provider secret is empty")
 	}
 
+	if c.Timeout <= 0 {
+		return errors.New("captcha timeout must be a positive duration")
+	}
+
+	return nil
+}
+
+const (
+	LoginLockoutModeLock  = "lock"
+	LoginLockoutModeDelay = "delay"
+)
+
+// LoginLockoutConfiguration slows down password guessing. Failed password
+// logins are counted per email address or phone number and per client IP
+// address. Once MaxAttempts (or MaxAttemptsPerIP) failures happen within
+// Duration, the "lock" mode refuses further attempts for Duration, while the
+// "delay" mode holds back the responses to failed attempts, doubling the
+// delay with every further failure up to MaxDelay.
+type LoginLockoutConfiguration struct {
+	Enabled          bool          `json:"enabled" default:"false"`
+	Mode             string        `json:"mode" default:"lock"`
+	MaxAttempts      int           `json:"max_attempts" split_words:"true" default:"5"`
+	MaxAttemptsPerIP int           `json:"max_attempts_per_ip" split_words:"true" default:"50"`
+	Duration         time.Duration `json:"duration" default:"15m"`
+	Delay            time.Duration `json:"delay" default:"1s"`
+	MaxDelay         time.Duration `json:"max_delay" split_words:"true" default:"30s"`
+}
+
+func (c *LoginLockoutConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Mode != LoginLockoutModeLock && c.Mode != LoginLockoutModeDelay {
+		return fmt.Errorf("unsupported login lockout mode: %s", c.Mode)
+	}
+
+	if c.MaxAttempts < 0 || c.MaxAttemptsPerIP < 0 {
+		return errors.New("login lockout attempts can't be negative")
+	}
+
+	if c.Duration <= 0 {
+		return errors.New("login lockout duration must be a positive duration")
+	}
+
+	if c.Mode == LoginLockoutModeDelay && (c.Delay <= 0 || c.MaxDelay < c.Delay) {
+		return errors.New("login lockout delay must be positive and not exceed the max delay")
+	}
+
+	return nil
+}
+
+// DeviceAuthorizationConfiguration configures the OAuth device authorization
+// grant (RFC 8628), which lets clients without a browser, such as CLIs and
+// TVs, log users in through a browser on another device.
+type DeviceAuthorizationConfiguration struct {
+	Enabled bool `json:"enabled" default:"false"`
+
+	// CodeExpiry is how long the user has to enter the user code.
+	CodeExpiry time.Duration `json:"code_expiry" split_words:"true" default:"10m"`
+
+	// PollInterval is the minimum time between two token requests of a
+	// client. Clients polling faster are told to slow down.
+	PollInterval time.Duration `json:"poll_interval" split_words:"true" default:"5s"`
+
+	// VerificationURI is the page users enter the user code on. Defaults to
+	// SiteURL with the path /device.
+	VerificationURI string `json:"verification_uri" split_words:"true"`
+}
+
+func (c *DeviceAuthorizationConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.CodeExpiry <= 0 {
+		return errors.New("DEVICE_AUTHORIZATION_CODE_EXPIRY must be a positive duration")
+	}
+
+	if c.PollInterval < time.Second {
+		return errors.New("DEVICE_AUTHORIZATION_POLL_INTERVAL must be at least one second")
+	}
+
+	if c.VerificationURI != "" {
+		if _, err := url.ParseRequestURI(c.VerificationURI); err != nil {
+			return fmt.Errorf("DEVICE_AUTHORIZATION_VERIFICATION_URI is invalid: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ClientCredentialsConfiguration configures the OAuth client credentials
+// grant, which issues access tokens to the confidential clients registered
+// through the admin API, such as backend services.
+type ClientCredentialsConfiguration struct {
+	Enabled bool `json:"enabled" default:"false"`
+
+	// Exp is how long the access tokens of clients are valid. They can't be
+	// refreshed, clients ask for a new one instead.
+	Exp time.Duration `json:"exp" default:"5m"`
+}
+
+func (c *ClientCredentialsConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Exp <= 0 {
+		return errors.New("CLIENT_CREDENTIALS_EXP must be a positive duration")
+	}
+
+	return nil
+}
+
+// SCIMConfiguration configures the SCIM 2.0 endpoints, which identity
+// providers such as Okta and Microsoft Entra ID use to provision and
+// deprovision users.
+type SCIMConfiguration struct {
+	Enabled bool `json:"enabled" default:"false"`
+
+	// Token is the bearer token the identity provider authenticates with.
+	Token string `json:"-"`
+}
+
+func (c *SCIMConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if len(c.Token) < 32 {
+		return errors.New("SCIM_TOKEN must be at least 32 characters long")
+	}
+
+	return nil
+}
+
+// WebAuthnConfiguration configures WebAuthn credentials, such as passkeys
+// and security keys, which users enroll as MFA factors and can sign in with
+// instead of a password.
+type WebAuthnConfiguration struct {
+	Enabled bool `json:"enabled" default:"false"`
+
+	// RPID is the domain credentials are registered for. Credentials can't
+	// be used once it changes. Defaults to the host of SiteURL.
+	RPID string `json:"rp_id" envconfig:"RP_ID"`
+
+	// RPDisplayName is the name of the site authenticators show. Defaults
+	// to RPID.
+	RPDisplayName string `json:"rp_display_name" envconfig:"RP_DISPLAY_NAME"`
+
+	// RPOrigins are the origins of the pages which may register and use
+	// credentials, such as https://example.com. Defaults to the origin of
+	// SiteURL.
+	RPOrigins []string `json:"rp_origins" envconfig:"RP_ORIGINS"`
+}
+
+func (c *WebAuthnConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if strings.ContainsAny(c.RPID, "/:") {
+		return errors.New("WEBAUTHN_RP_ID must be a domain, such as example.com")
+	}
+
+	for _, origin := range c.RPOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("WEBAUTHN_RP_ORIGINS entry %q must be an origin, such as https://example.com", origin)
+		}
+	}
+
+	return nil
+}
+
+// LDAPConfiguration configures the validation of email and password logins
+// against an LDAP directory such as Active Directory. Users are looked up with
+// the service account and their password checked by binding as them.
+type LDAPConfiguration struct {
+	Enabled bool `json:"enabled" default:"false"`
+
+	// URL is the ldap:// or ldaps:// URL of the directory server.
+	URL      string `json:"url"`
+	StartTLS bool   `json:"start_tls" split_words:"true"`
+
+	// BindDN and BindPassword are the credentials of the service account
+	// users are looked up with. Without them the lookup is anonymous.
+	BindDN       string `json:"bind_dn" split_words:"true"`
+	BindPassword string `json:"-" split_words:"true"`
+
+	UserBaseDN string `json:"user_base_dn" split_words:"true"`
+
+	// UserFilter finds a user by the email address they log in with, which
+	// replaces %s once escaped.
+	UserFilter string `json:"user_filter" split_words:"true" default:"(mail=%s)"`
+
+	EmailAttribute string `json:"email_attribute" split_words:"true" default:"mail"`
+	NameAttribute  string `json:"name_attribute" split_words:"true" default:"displayName"`
+	GroupAttribute string `json:"group_attribute" split_words:"true" default:"memberOf"`
+
+	// GroupRoles maps the common names of groups to the roles of their
+	// members, as group:role pairs. The first group in the list a user is a
+	// member of gives them their role.
+	GroupRoles []string `json:"group_roles" split_words:"true"`
+
+	Timeout time.Duration `json:"timeout" default:"5s"`
+}
+
+func (c *LDAPConfiguration) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return errors.New("LDAP_URL must be an ldap:// or ldaps:// URL")
+	}
+
+	if c.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("LDAP_START_TLS can't be used with an ldaps:// URL")
+	}
+
+	if c.UserBaseDN == "" {
+		return errors.New("LDAP_USER_BASE_DN is required")
+	}
+
+	if strings.Count(c.UserFilter, "%s") != 1 {
+		return errors.New("LDAP_USER_FILTER must contain %s exactly once")
+	}
+
+	for _, pair := range c.GroupRoles {
+		group, role, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(group) == "" || strings.TrimSpace(role) == "" {
+			return fmt.Errorf("LDAP_GROUP_ROLES entry %q must be of the form group:role", pair)
+		}
+	}
+
 	return nil
 }
 
@@ -486,6 +1651,7 @@ type SecurityConfiguration struct {
 	ManualLinkingEnabled                  bool                 `json:"manual_linking_enabled" split_words:"true" default:"false"`
 
 	DBEncryption DatabaseEncryptionConfiguration `json:"database_encryption" split_words:"true"`
+	LoginLockout LoginLockoutConfiguration       `json:"login_lockout" split_words:"true"`
 }
 
 func (c *SecurityConfiguration) Validate() error {
@@ -497,6 +1663,10 @@ func (c *SecurityConfiguration) Validate() error {
 		return err
 	}
 
+	if err := c.LoginLockout.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -648,6 +1818,10 @@ func LoadGlobal(filename string) (*GlobalConfiguration, error) {
 		return nil, err
 	}
 
+	if err := config.JWT.PopulateFields(); err != nil {
+		return nil, err
+	}
+
 	if config.Hook.PasswordVerificationAttempt.Enabled {
 		if err := config.Hook.PasswordVerificationAttempt.PopulateExtensibilityPoint(); err != nil {
 			return nil, err
@@ -695,6 +1869,26 @@ func LoadGlobal(filename string) (*GlobalConfiguration, error) {
 			return nil, err
 		}
 		config.Sms.SMSTemplate = template
+
+		if err := config.Sms.parseLocalizedTemplates(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MFA.Phone.Enabled {
+		if config.Sms.Provider == "" && !config.Hook.SendSMS.Enabled {
+			return nil, errors.New("MFA_PHONE_ENABLED requires SMS_PROVIDER or the send SMS hook")
+		}
+
+		phoneTemplate := config.MFA.Phone.Template
+		if phoneTemplate == "" {
+			phoneTemplate = "Your code is {{ .Code }}"
+		}
+		template, err := template.New("").Parse(phoneTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MFA_PHONE_TEMPLATE: %w", err)
+		}
+		config.MFA.Phone.SMSTemplate = template
 	}
 	return config, nil
 }
@@ -754,7 +1948,7 @@ func (config *GlobalConfiguration) ApplyDefaults() error {
 		config.Sms.OtpExp = 60
 	}
 
-	if config.Sms.OtpLength == 0 || config.Sms.OtpLength < 6 || config.Sms.OtpLength > 10 {
+	if config.Sms.OtpLength == 0 || config.Sms.OtpLength < 4 || config.Sms.OtpLength > 10 {
 		// 6-digit otp by default
 		config.Sms.OtpLength = 6
 	}
@@ -763,7 +1957,8 @@ func (config *GlobalConfiguration) ApplyDefaults() error {
 		formatTestOtps := make(map[string]string)
 		for phone, otp := range config.Sms.TestOTP {
 			phone = strings.ReplaceAll(strings.TrimPrefix(phone, "+"), " ", "")
-			formatTestOtps[phone] = otp
+			// OTPs are verified case-insensitively
+			formatTestOtps[phone] = strings.ToUpper(otp)
 		}
 		config.Sms.TestOTP = formatTestOtps
 	}
@@ -809,6 +2004,14 @@ func (config *GlobalConfiguration) ApplyDefaults() error {
 		config.External.FlowStateExpiryDuration = defaultFlowStateExpiryDuration
 	}
 
+	if config.External.StateExpiryDuration <= 0 {
+		config.External.StateExpiryDuration = defaultStateExpiryDuration
+	}
+
+	if config.DB.CleanupBatchSize == 0 {
+		config.DB.CleanupBatchSize = defaultCleanupBatchSize
+	}
+
 	if len(config.External.AllowedIdTokenIssuers) == 0 {
 		config.External.AllowedIdTokenIssuers = append(config.External.AllowedIdTokenIssuers, "https://appleid.apple.com", "https://accounts.google.com")
 	}
@@ -823,13 +2026,27 @@ func (c *GlobalConfiguration) Validate() error {
 	}{
 		&c.API,
 		&c.DB,
+		&c.JWT,
 		&c.Tracing,
 		&c.Metrics,
 		&c.SMTP,
+		&c.Mailer,
+		&c.Sms,
 		&c.SAML,
 		&c.Security,
 		&c.Sessions,
 		&c.Hook,
+		&c.Logging,
+		&c.CORS,
+		&c.IPRateLimit,
+		&c.Webhook,
+		&c.DeviceAuthorization,
+		&c.ClientCredentials,
+		&c.Password,
+		&c.FeatureFlags,
+		&c.SCIM,
+		&c.LDAP,
+		&c.WebAuthn,
 	}
 
 	for _, validatable := range validatables {
@@ -838,9 +2055,26 @@ func (c *GlobalConfiguration) Validate() error {
 		}
 	}
 
+	if c.Mailer.Provider == "hook" && !c.Hook.SendEmail.Enabled {
+		return errors.New("mailer provider hook requires the send email hook to be enabled")
+	}
+
 	return nil
 }
 
+// UsesSendEmailHook reports whether emails are delivered with the send email
+// hook instead of the mailer.
+func (c *GlobalConfiguration) UsesSendEmailHook() bool {
+	switch c.Mailer.Provider {
+	case "hook":
+		return true
+	case "":
+		return c.Hook.SendEmail.Enabled
+	default:
+		return false
+	}
+}
+
 func (o *OAuthProviderConfiguration) ValidateOAuth() error {
 	if !o.Enabled {
 		return errors.New("provider is not enabled")
@@ -913,6 +2147,28 @@ func (t *Msg91ProviderConfiguration) Validate() error {
 	if t.TemplateId == "" {
 		return errors.New("missing Msg91 template Id")
 	}
+	if t.WhatsappTemplateName != "" && t.WhatsappNumber == "" {
+		return errors.New("missing Msg91 WhatsApp number")
+	}
+	return nil
+}
+
+func (t *SnsProviderConfiguration) Validate() error {
+	if t.AccessKeyID == "" {
+		return errors.New("missing SNS access key ID")
+	}
+	if t.SecretAccessKey == "" {
+		return errors.New("missing SNS secret access key")
+	}
+	if t.Region == "" {
+		return errors.New("missing SNS region")
+	}
+	if !awsRegionRegexp.MatchString(t.Region) {
+		return fmt.Errorf("SNS region must be an AWS region such as us-east-1, not %q", t.Region)
+	}
+	if t.SMSType != "" && t.SMSType != "Transactional" && t.SMSType != "Promotional" {
+		return fmt.Errorf("SNS SMS type must be Transactional or Promotional, not %q", t.SMSType)
+	}
 	return nil
 }
 
@@ -920,8 +2176,8 @@ func (t *VonageProviderConfiguration) Validate() error {
 	if t.ApiKey == "" {
 		return errors.New("missing Vonage API key")
 	}
-	if t.ApiSecret == "" {
-		return errors.New("missing Vonage API secret")
+	if t.ApiSecret == "" && t.SignatureSecret == "" {
+		return errors.New("missing Vonage API secret or signature secret")
 	}
 	if t.From == "" {
 		return errors.New("missing Vonage 'from' parameter")
@@ -0,0 +1,96 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Feature is a feature that the feature flags can disable.
+type Feature string
+
+const (
+	FeatureSignup        Feature = "signup"
+	FeaturePasswordLogin Feature = "password_login"
+	FeatureSMS           Feature = "sms"
+	FeatureEmail         Feature = "email"
+)
+
+// ExternalProviderFeature is the feature of signing in with the external
+// provider name.
+func ExternalProviderFeature(name string) Feature {
+	return Feature("external:" + strings.ToLower(name))
+}
+
+// FeatureFlags switch off features at runtime, for example to stop signups
+// or SMS sends during an incident. In maintenance mode all of the features
+// are disabled and only reads, token refreshes and logouts are served.
+type FeatureFlags struct {
+	Maintenance          bool `json:"maintenance"`
+	DisableSignup        bool `json:"disable_signup" split_words:"true"`
+	DisablePasswordLogin bool `json:"disable_password_login" split_words:"true"`
+	DisableSMS           bool `json:"disable_sms" envconfig:"DISABLE_SMS"`
+	DisableEmail         bool `json:"disable_email" split_words:"true"`
+
+	// DisabledProviders lists the external providers that can't be signed
+	// in with, e.g. "github".
+	DisabledProviders []string `json:"disabled_providers" split_words:"true"`
+}
+
+// Validate checks the flags.
+func (f *FeatureFlags) Validate() error {
+	for _, name := range f.DisabledProviders {
+		if (&ProviderConfiguration{}).OAuthProvider(name) == nil {
+			return fmt.Errorf("external provider %q is not supported", name)
+		}
+	}
+
+	return nil
+}
+
+// IsDisabled reports whether the feature is disabled.
+func (f *FeatureFlags) IsDisabled(feature Feature) bool {
+	if f.Maintenance {
+		return true
+	}
+
+	switch feature {
+	case FeatureSignup:
+		return f.DisableSignup
+	case FeaturePasswordLogin:
+		return f.DisablePasswordLogin
+	case FeatureSMS:
+		return f.DisableSMS
+	case FeatureEmail:
+		return f.DisableEmail
+	}
+
+	return slices.ContainsFunc(f.DisabledProviders, func(name string) bool {
+		return ExternalProviderFeature(name) == feature
+	})
+}
+
+// FeatureFlagsConfiguration holds the default feature flags, used until an
+// admin changes them with PUT /admin/settings/flags.
+type FeatureFlagsConfiguration struct {
+	FeatureFlags
+
+	// CacheTTL is how long the flags are cached. Changes through the admin
+	// API take effect immediately on the server handling them, the other
+	// servers pick them up once the cache expires.
+	CacheTTL time.Duration `json:"cache_ttl" split_words:"true" default:"5s"`
+}
+
+func (c *FeatureFlagsConfiguration) Validate() error {
+	if c.CacheTTL < 0 {
+		return errors.New("FEATURE_FLAGS_CACHE_TTL can't be negative")
+	}
+
+	if err := c.FeatureFlags.Validate(); err != nil {
+		return fmt.Errorf("FEATURE_FLAGS_DISABLED_PROVIDERS is invalid: %w", err)
+	}
+
+	return nil
+}
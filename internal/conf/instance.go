@@ -0,0 +1,230 @@
+package conf
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// InstanceOverrides are the settings an instance, identified by the JWT
+// audience of its requests, overrides on top of the global configuration.
+// Unset fields keep the global value.
+type InstanceOverrides struct {
+	SiteURL       *string          `json:"site_url,omitempty"`
+	DisableSignup *bool            `json:"disable_signup,omitempty"`
+	Mailer        *MailerOverrides `json:"mailer,omitempty"`
+
+	// External is keyed by the provider name, e.g. "github".
+	External map[string]*OAuthProviderOverrides `json:"external,omitempty"`
+}
+
+// MailerOverrides overrides the mailer settings. Empty subjects and
+// templates keep the global value.
+type MailerOverrides struct {
+	Autoconfirm *bool                     `json:"autoconfirm,omitempty"`
+	Subjects    EmailContentConfiguration `json:"subjects"`
+	Templates   EmailContentConfiguration `json:"templates"`
+}
+
+// OAuthProviderOverrides overrides the settings of an external provider.
+// Empty fields keep the global value.
+type OAuthProviderOverrides struct {
+	Enabled     *bool    `json:"enabled,omitempty"`
+	ClientID    []string `json:"client_id,omitempty"`
+	Secret      string   `json:"secret,omitempty"`
+	RedirectURI string   `json:"redirect_uri,omitempty"`
+}
+
+// Validate checks the overrides.
+func (o *InstanceOverrides) Validate() error {
+	if o.SiteURL != nil {
+		if _, err := url.ParseRequestURI(*o.SiteURL); err != nil {
+			return fmt.Errorf("site_url is invalid: %w", err)
+		}
+	}
+
+	for name, provider := range o.External {
+		if provider == nil {
+			return fmt.Errorf("external provider %q has no settings", name)
+		}
+
+		if (&ProviderConfiguration{}).OAuthProvider(name) == nil {
+			return fmt.Errorf("external provider %q is not supported", name)
+		}
+
+		if provider.RedirectURI != "" {
+			if _, err := url.ParseRequestURI(provider.RedirectURI); err != nil {
+				return fmt.Errorf("redirect_uri of external provider %q is invalid: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Secrets returns the secrets of the external providers, keyed by the
+// provider name.
+func (o *InstanceOverrides) Secrets() map[string]string {
+	secrets := make(map[string]string)
+	for name, provider := range o.External {
+		if provider != nil && provider.Secret != "" {
+			secrets[name] = provider.Secret
+		}
+	}
+
+	return secrets
+}
+
+// Apply returns a copy of the base configuration with the overrides applied.
+// The base configuration isn't modified.
+func (o *InstanceOverrides) Apply(base *GlobalConfiguration) *GlobalConfiguration {
+	config := *base
+
+	if o.SiteURL != nil {
+		config.SiteURL = *o.SiteURL
+	}
+
+	if o.DisableSignup != nil {
+		config.DisableSignup = *o.DisableSignup
+	}
+
+	if o.Mailer != nil {
+		if o.Mailer.Autoconfirm != nil {
+			config.Mailer.Autoconfirm = *o.Mailer.Autoconfirm
+		}
+		config.Mailer.Subjects = o.Mailer.Subjects.mergeOver(config.Mailer.Subjects)
+		config.Mailer.Templates = o.Mailer.Templates.mergeOver(config.Mailer.Templates)
+	}
+
+	for name, overrides := range o.External {
+		provider := config.External.OAuthProvider(name)
+		if provider == nil || overrides == nil {
+			continue
+		}
+
+		if overrides.Enabled != nil {
+			provider.Enabled = *overrides.Enabled
+		}
+		if len(overrides.ClientID) > 0 {
+			provider.ClientID = overrides.ClientID
+		}
+		if overrides.Secret != "" {
+			provider.Secret = overrides.Secret
+		}
+		if overrides.RedirectURI != "" {
+			provider.RedirectURI = overrides.RedirectURI
+		}
+	}
+
+	return &config
+}
+
+// mergeOver returns base with the non-empty contents of c.
+func (c EmailContentConfiguration) mergeOver(base EmailContentConfiguration) EmailContentConfiguration {
+	for _, field := range []struct {
+		value string
+		into  *string
+	}{
+		{c.Invite, &base.Invite},
+		{c.Confirmation, &base.Confirmation},
+		{c.Recovery, &base.Recovery},
+		{c.EmailChange, &base.EmailChange},
+		{c.MagicLink, &base.MagicLink},
+		{c.Reauthentication, &base.Reauthentication},
+	} {
+		if field.value != "" {
+			*field.into = field.value
+		}
+	}
+
+	return base
+}
+
+// OAuthProviderNames lists the names of the OAuth providers OAuthProvider
+// returns the configuration of.
+var OAuthProviderNames = []string{
+	"apple",
+	"azure",
+	"bitbucket",
+	"discord",
+	"facebook",
+	"figma",
+	"fly",
+	"github",
+	"gitlab",
+	"google",
+	"kakao",
+	"keycloak",
+	"line",
+	"linkedin",
+	"linkedin_oidc",
+	"notion",
+	"oidc",
+	"spotify",
+	"slack",
+	"slack_oidc",
+	"twitch",
+	"twitter",
+	"workos",
+	"x",
+	"zoom",
+}
+
+// OAuthProvider returns the configuration of the named OAuth provider, or nil
+// if there is no such provider.
+func (c *ProviderConfiguration) OAuthProvider(name string) *OAuthProviderConfiguration {
+	switch strings.ToLower(name) {
+	case "apple":
+		return &c.Apple.OAuthProviderConfiguration
+	case "azure":
+		return &c.Azure.OAuthProviderConfiguration
+	case "bitbucket":
+		return &c.Bitbucket
+	case "discord":
+		return &c.Discord
+	case "facebook":
+		return &c.Facebook
+	case "figma":
+		return &c.Figma
+	case "fly":
+		return &c.Fly
+	case "github":
+		return &c.Github
+	case "gitlab":
+		return &c.Gitlab
+	case "google":
+		return &c.Google
+	case "kakao":
+		return &c.Kakao
+	case "keycloak":
+		return &c.Keycloak
+	case "line":
+		return &c.Line
+	case "linkedin":
+		return &c.Linkedin
+	case "linkedin_oidc":
+		return &c.LinkedinOIDC
+	case "notion":
+		return &c.Notion
+	case "oidc":
+		return &c.OIDC.OAuthProviderConfiguration
+	case "spotify":
+		return &c.Spotify
+	case "slack":
+		return &c.Slack
+	case "slack_oidc":
+		return &c.SlackOIDC
+	case "twitch":
+		return &c.Twitch
+	case "twitter":
+		return &c.Twitter
+	case "workos":
+		return &c.WorkOS
+	case "x":
+		return &c.X
+	case "zoom":
+		return &c.Zoom
+	default:
+		return nil
+	}
+}
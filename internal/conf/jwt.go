@@ -0,0 +1,257 @@
+package conf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWTKey is an asymmetric key signing JWTs, whose public key is published
+// so that the JWTs can be verified without the secret. Keys which only
+// verify JWTs signed earlier have no private key.
+type JWTKey struct {
+	KeyID      string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// JWK is the public key of a JWTKey as a JSON Web Key. See:
+// https://datatracker.ietf.org/doc/html/rfc7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// ParseJWTKey parses a PEM encoded private key, which is an RSA key of at
+// least 2048 bits signing with RS256, a P-256 key signing with ES256 or an
+// Ed25519 key signing with EdDSA. The key ID defaults to the thumbprint of
+// the key.
+func ParseJWTKey(pemKey, keyID string) (*JWTKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("JWT signing key is not PEM encoded")
+	}
+
+	key, err := parseJWTKeyBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	if key.PrivateKey == nil {
+		return nil, errors.New("JWT signing key is not a private key")
+	}
+
+	if keyID != "" {
+		key.KeyID = keyID
+	}
+
+	return key, nil
+}
+
+// ParseJWTKeys parses PEM encoded public or private keys, one after the
+// other, whose key IDs are their thumbprints.
+func ParseJWTKeys(pemKeys string) ([]*JWTKey, error) {
+	var keys []*JWTKey
+
+	rest := []byte(pemKeys)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		key, err := parseJWTKeyBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 || len(bytes.TrimSpace(rest)) > 0 {
+		return nil, errors.New("JWT verification keys are not PEM encoded")
+	}
+
+	return keys, nil
+}
+
+// MarshalPrivateKey returns the private key in PKCS #8 form.
+func (k *JWTKey) MarshalPrivateKey() ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+}
+
+// GenerateJWTKey generates a private key signing with the algorithm.
+func GenerateJWTKey(algorithm string) (*JWTKey, error) {
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+	switch algorithm {
+	case "RS256":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("JWT signing algorithm %q is not supported", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJWTKey(privateKey)
+}
+
+// NewJWTKey returns the key of a private key in the form returned by
+// x509.ParsePKCS8PrivateKey, whose key ID is its thumbprint.
+func NewJWTKey(privateKey interface{}) (*JWTKey, error) {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("JWT signing key has the unsupported type %T", privateKey)
+	}
+
+	key, err := newJWTKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.PrivateKey = signer
+
+	return key, nil
+}
+
+// newJWTKey returns the key of a public key, whose key ID is its thumbprint.
+func newJWTKey(publicKey crypto.PublicKey) (*JWTKey, error) {
+	key := &JWTKey{PublicKey: publicKey}
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("JWT signing key must be at least RSA 2048")
+		}
+		key.Algorithm = "RS256"
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("JWT signing key must use the P-256 curve")
+		}
+		key.Algorithm = "ES256"
+	case ed25519.PublicKey:
+		key.Algorithm = "EdDSA"
+	default:
+		return nil, fmt.Errorf("JWT signing key has the unsupported type %T", publicKey)
+	}
+
+	key.KeyID = key.Thumbprint()
+
+	return key, nil
+}
+
+func parseJWTKeyBlock(block *pem.Block) (*JWTKey, error) {
+	var (
+		parsed interface{}
+		err    error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key is not valid: %w", err)
+		}
+		return newJWTKey(parsed)
+	default:
+		return nil, fmt.Errorf("JWT key has the unsupported PEM type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("JWT key is not valid: %w", err)
+	}
+
+	return NewJWTKey(parsed)
+}
+
+// JWK returns the public key as a JSON Web Key.
+func (k *JWTKey) JWK() JWK {
+	jwk := JWK{
+		KeyID:     k.KeyID,
+		Use:       "sig",
+		Algorithm: k.Algorithm,
+	}
+
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// the coordinates are padded to the size of the curve
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+// Thumbprint returns the JWK thumbprint of the key. See:
+// https://datatracker.ietf.org/doc/html/rfc7638
+func (k *JWTKey) Thumbprint() string {
+	jwk := k.JWK()
+
+	// only the required members, in lexicographic order
+	var members interface{}
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+
+	// marshaling these structs of strings can't fail
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,116 @@
+package conf
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJWTKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+
+	examples := []struct {
+		block     *pem.Block
+		algorithm string
+		keyType   string
+	}{
+		{&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, "RS256", "RSA"},
+		{&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, "ES256", "EC"},
+		{&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}, "EdDSA", "OKP"},
+	}
+
+	for _, example := range examples {
+		key, err := ParseJWTKey(string(pem.EncodeToMemory(example.block)), "")
+		require.NoError(t, err)
+		require.Equal(t, example.algorithm, key.Algorithm)
+		require.Equal(t, key.Thumbprint(), key.KeyID)
+
+		jwk := key.JWK()
+		require.Equal(t, example.keyType, jwk.KeyType)
+		require.Equal(t, example.algorithm, jwk.Algorithm)
+		require.Equal(t, "sig", jwk.Use)
+	}
+
+	key, err := ParseJWTKey(string(pem.EncodeToMemory(examples[0].block)), "key-1")
+	require.NoError(t, err)
+	require.Equal(t, "key-1", key.KeyID)
+
+	_, err = ParseJWTKey("not a key", "")
+	require.Error(t, err)
+
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	_, err = ParseJWTKey(string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(smallKey)})), "")
+	require.Error(t, err)
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	p384DER, err := x509.MarshalECPrivateKey(p384Key)
+	require.NoError(t, err)
+	_, err = ParseJWTKey(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: p384DER})), "")
+	require.Error(t, err)
+}
+
+func TestJWTKeyThumbprint(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc7638#section-3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.NoError(t, err)
+
+	key := &JWTKey{
+		Algorithm: "RS256",
+		PublicKey: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537},
+	}
+	require.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", key.Thumbprint())
+}
+
+func TestParseJWTKeys(t *testing.T) {
+	rsaKey, err := GenerateJWTKey("RS256")
+	require.NoError(t, err)
+	rsaDER, err := x509.MarshalPKIXPublicKey(rsaKey.PublicKey)
+	require.NoError(t, err)
+	edKey, err := GenerateJWTKey("EdDSA")
+	require.NoError(t, err)
+	edDER, err := edKey.MarshalPrivateKey()
+	require.NoError(t, err)
+
+	pemKeys := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDER})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}))
+
+	keys, err := ParseJWTKeys(pemKeys)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, rsaKey.KeyID, keys[0].KeyID)
+	require.Nil(t, keys[0].PrivateKey)
+	require.Equal(t, edKey.KeyID, keys[1].KeyID)
+
+	_, err = ParseJWTKeys(pemKeys + "garbage")
+	require.Error(t, err)
+
+	_, err = ParseJWTKeys("")
+	require.Error(t, err)
+
+	// a public key can't sign
+	_, err = ParseJWTKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDER})), "")
+	require.Error(t, err)
+
+	_, err = GenerateJWTKey("HS256")
+	require.Error(t, err)
+}
@@ -1,5 +1,7 @@
 package conf
 
+import "fmt"
+
 type LoggingConfig struct {
 	Level            string                 `mapstructure:"log_level" json:"log_level"`
 	File             string                 `mapstructure:"log_file" json:"log_file"`
@@ -8,4 +10,16 @@ type LoggingConfig struct {
 	TSFormat         string                 `mapstructure:"ts_format" json:"ts_format"`
 	Fields           map[string]interface{} `mapstructure:"fields" json:"fields"`
 	SQL              string                 `mapstructure:"sql" json:"sql"`
+
+	// Format is either "json" (the default) or "text".
+	Format string `mapstructure:"log_format" json:"log_format" default:"json"`
+}
+
+func (c *LoggingConfig) Validate() error {
+	switch c.Format {
+	case "", "json", "text":
+		return nil
+	default:
+		return fmt.Errorf("unsupported log format: %s", c.Format)
+	}
 }
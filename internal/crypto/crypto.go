@@ -47,6 +47,26 @@ func GenerateOtp(digits int) (string, error) {
 	otp := fmt.Sprintf(expr, val.String())
 	return otp, nil
 }
+
+// OtpAlphanumericCharacters are the characters of alphanumeric OTPs, the
+// upper case letters and digits without the easily confused 0, O, 1, I and L.
+const OtpAlphanumericCharacters = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// GenerateAlphanumericOtp generates a random otp of length characters out of
+// OtpAlphanumericCharacters
+func GenerateAlphanumericOtp(length int) (string, error) {
+	otp := make([]byte, length)
+	max := big.NewInt(int64(len(OtpAlphanumericCharacters)))
+	for i := range otp {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.WithMessage(err, "Error generating otp")
+		}
+		otp[i] = OtpAlphanumericCharacters[n.Int64()]
+	}
+	return string(otp), nil
+}
+
 func GenerateTokenHash(emailOrPhone, otp string) string {
 	return fmt.Sprintf("%x", sha256.Sum224([]byte(emailOrPhone+otp)))
 }
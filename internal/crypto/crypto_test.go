@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/gofrs/uuid"
@@ -32,3 +33,44 @@ func TestEncryptedString(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []byte("data"), decrypted)
 }
+
+func TestGenerateOtp(t *testing.T) {
+	counts := make(map[rune]int)
+	for i := 0; i < 1000; i++ {
+		otp, err := GenerateOtp(8)
+		assert.NoError(t, err)
+		assert.Len(t, otp, 8)
+		for _, c := range otp {
+			counts[c]++
+		}
+	}
+
+	// 8000 digits, each of the 10 is expected 800 times
+	assert.Len(t, counts, 10)
+	for c, count := range counts {
+		assert.True(t, c >= '0' && c <= '9')
+		assert.InDelta(t, 800, count, 200, "digit %c", c)
+	}
+}
+
+func TestGenerateAlphanumericOtp(t *testing.T) {
+	counts := make(map[rune]int)
+	for i := 0; i < 1000; i++ {
+		otp, err := GenerateAlphanumericOtp(6)
+		assert.NoError(t, err)
+		assert.Len(t, otp, 6)
+		for _, c := range otp {
+			counts[c]++
+		}
+	}
+
+	// 6000 characters, each of the 31 is expected about 194 times
+	assert.Len(t, counts, len(OtpAlphanumericCharacters))
+	for c, count := range counts {
+		assert.True(t, strings.ContainsRune(OtpAlphanumericCharacters, c))
+		assert.InDelta(t, 194, count, 100, "character %c", c)
+	}
+	for _, c := range "01ILO" {
+		assert.NotContains(t, OtpAlphanumericCharacters, string(c))
+	}
+}
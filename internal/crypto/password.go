@@ -2,6 +2,9 @@ package crypto
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
 	"errors"
@@ -16,6 +19,7 @@ import (
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
 )
 
 type HashCost = int
@@ -39,6 +43,97 @@ const (
 // GenerateHashFromPassword.
 var PasswordHashCost = DefaultHashCost
 
+const (
+	BcryptHashAlgorithm   = "bcrypt"
+	Argon2idHashAlgorithm = "argon2id"
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Maximum argon2 and scrypt parameters accepted in password hashes, so that
+// verifying a hash can't exhaust the memory or CPU of the server.
+const (
+	// MaxArgon2Memory is in KiB (1 GiB).
+	MaxArgon2Memory      = 1024 * 1024
+	MaxArgon2Iterations  = 32
+	MaxArgon2Parallelism = 16
+
+	// maxScryptLogN bounds the N parameter of scrypt hashes, as verifying
+	// one takes 128 * N * r bytes of memory.
+	maxScryptLogN        = 20
+	maxScryptRounds      = 32
+	maxScryptParallelism = 16
+	maxScryptMemory      = 1024 * 1024 * 1024
+)
+
+// PasswordHashParams are the algorithm and cost of new password hashes.
+type PasswordHashParams struct {
+	Algorithm  string
+	BcryptCost int
+
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+// DefaultPasswordHashParams follow the OWASP recommendations for argon2id.
+var DefaultPasswordHashParams = PasswordHashParams{
+	Algorithm:         BcryptHashAlgorithm,
+	BcryptCost:        bcrypt.DefaultCost,
+	Argon2Memory:      19 * 1024,
+	Argon2Iterations:  2,
+	Argon2Parallelism: 1,
+}
+
+// PasswordHashing is the algorithm and cost of all new hashes generated
+// with GenerateFromPassword. Passwords hashed otherwise are rehashed when
+// users sign in.
+var PasswordHashing = DefaultPasswordHashParams
+
+// Validate returns an error if new password hashes can't be generated with
+// the parameters.
+func (p PasswordHashParams) Validate() error {
+	switch p.Algorithm {
+	case BcryptHashAlgorithm:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("crypto: bcrypt cost %d must be between %d and %d", p.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+
+	case Argon2idHashAlgorithm:
+		if p.Argon2Iterations < 1 || p.Argon2Iterations > MaxArgon2Iterations {
+			return fmt.Errorf("crypto: argon2 iterations %d must be between 1 and %d", p.Argon2Iterations, MaxArgon2Iterations)
+		}
+		if p.Argon2Parallelism < 1 || p.Argon2Parallelism > MaxArgon2Parallelism {
+			return fmt.Errorf("crypto: argon2 parallelism %d must be between 1 and %d", p.Argon2Parallelism, MaxArgon2Parallelism)
+		}
+		if p.Argon2Memory < 8*uint32(p.Argon2Parallelism) || p.Argon2Memory > MaxArgon2Memory {
+			return fmt.Errorf("crypto: argon2 memory %d KiB must be between 8 KiB per thread and %d KiB", p.Argon2Memory, MaxArgon2Memory)
+		}
+
+	default:
+		return fmt.Errorf("crypto: unsupported password hash algorithm %q", p.Algorithm)
+	}
+
+	return nil
+}
+
+func (p PasswordHashParams) effective() (PasswordHashParams, error) {
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+
+	if PasswordHashCost == QuickHashCost {
+		p.BcryptCost = bcrypt.MinCost
+		p.Argon2Memory = 64
+		p.Argon2Iterations = 1
+		p.Argon2Parallelism = 1
+	}
+
+	return p, nil
+}
+
 var (
 	generateFromPasswordSubmittedCounter = observability.ObtainMetricCounter("gotrue_generate_from_password_submitted", "Number of submitted GenerateFromPassword hashing attempts")
 	generateFromPasswordCompletedCounter = observability.ObtainMetricCounter("gotrue_generate_from_password_completed", "Number of completed GenerateFromPassword hashing attempts")
@@ -49,16 +144,39 @@ var (
 	compareHashAndPasswordCompletedCounter = observability.ObtainMetricCounter("gotrue_compare_hash_and_password_completed", "Number of completed CompareHashAndPassword hashing attempts")
 )
 
-var ErrArgon2MismatchedHashAndPassword = errors.New("crypto: argon2 hash and password mismatch")
+var (
+	ErrArgon2MismatchedHashAndPassword = errors.New("crypto: argon2 hash and password mismatch")
+	ErrScryptMismatchedHashAndPassword = errors.New("crypto: scrypt hash and password mismatch")
+)
 
 // argon2HashRegexp https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md#argon2-encoding
 var argon2HashRegexp = regexp.MustCompile("^[$](?P<alg>argon2(d|i|id))[$]v=(?P<v>(16|19))[$]m=(?P<m>[0-9]+),t=(?P<t>[0-9]+),p=(?P<p>[0-9]+)(,keyid=(?P<keyid>[^,]+))?(,data=(?P<data>[^$]+))?[$](?P<salt>[^$]+)[$](?P<hash>.+)$")
 
-func compareHashAndPasswordArgon2(ctx context.Context, hash, password string) error {
+// scryptHashRegexp matches scrypt hashes in the PHC string format, where ln
+// is the base 2 logarithm of the N parameter.
+var scryptHashRegexp = regexp.MustCompile("^[$]scrypt[$]ln=(?P<ln>[0-9]+),r=(?P<r>[0-9]+),p=(?P<p>[0-9]+)[$](?P<salt>[^$]+)[$](?P<hash>.+)$")
+
+// firebaseScryptHashRegexp matches hashes of Firebase's modified scrypt, which
+// combine the salt and hash of an exported user with the hash parameters of
+// the exporting project: n is the mem_cost, r the rounds, ss the base64 salt
+// separator and sk the base64 signer key.
+var firebaseScryptHashRegexp = regexp.MustCompile("^[$]fbscrypt[$]v=(?P<v>1),n=(?P<n>[0-9]+),r=(?P<r>[0-9]+),p=(?P<p>[0-9]+),ss=(?P<ss>[^,$]+),sk=(?P<sk>[^,$]+)[$](?P<salt>[^$]+)[$](?P<hash>.+)$")
+
+type argon2Hash struct {
+	alg     string
+	v       string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	rawHash []byte
+}
+
+func parseArgon2Hash(hash string) (*argon2Hash, error) {
 	submatch := argon2HashRegexp.FindStringSubmatchIndex(hash)
 
 	if submatch == nil {
-		return errors.New("crypto: incorrect argon2 hash format")
+		return nil, errors.New("crypto: incorrect argon2 hash format")
 	}
 
 	alg := string(argon2HashRegexp.ExpandString(nil, "$alg", hash, submatch))
@@ -72,56 +190,77 @@ func compareHashAndPasswordArgon2(ctx context.Context, hash, password string) er
 	hashB64 := string(argon2HashRegexp.ExpandString(nil, "$hash", hash, submatch))
 
 	if alg != "argon2i" && alg != "argon2id" {
-		return fmt.Errorf("crypto: argon2 hash uses unsupported algorithm %q only argon2i and argon2id supported", alg)
+		return nil, fmt.Errorf("crypto: argon2 hash uses unsupported algorithm %q only argon2i and argon2id supported", alg)
 	}
 
 	if v != "19" {
-		return fmt.Errorf("crypto: argon2 hash uses unsupported version %q only %d is supported", v, argon2.Version)
+		return nil, fmt.Errorf("crypto: argon2 hash uses unsupported version %q only %d is supported", v, argon2.Version)
 	}
 
 	if data != "" {
-		return fmt.Errorf("crypto: argon2 hashes with the data parameter not supported")
+		return nil, fmt.Errorf("crypto: argon2 hashes with the data parameter not supported")
 	}
 
 	if keyid != "" {
-		return fmt.Errorf("crypto: argon2 hashes with the keyid parameter not supported")
+		return nil, fmt.Errorf("crypto: argon2 hashes with the keyid parameter not supported")
 	}
 
 	memory, err := strconv.ParseUint(m, 10, 32)
 	if err != nil {
-		return fmt.Errorf("crypto: argon2 hash has invalid m parameter %q %w", m, err)
+		return nil, fmt.Errorf("crypto: argon2 hash has invalid m parameter %q %w", m, err)
 	}
 
 	time, err := strconv.ParseUint(t, 10, 32)
 	if err != nil {
-		return fmt.Errorf("crypto: argon2 hash has invalid t parameter %q %w", t, err)
+		return nil, fmt.Errorf("crypto: argon2 hash has invalid t parameter %q %w", t, err)
 	}
 
 	threads, err := strconv.ParseUint(p, 10, 8)
 	if err != nil {
-		return fmt.Errorf("crypto: argon2 hash has invalid p parameter %q %w", p, err)
+		return nil, fmt.Errorf("crypto: argon2 hash has invalid p parameter %q %w", p, err)
+	}
+
+	if time < 1 || time > MaxArgon2Iterations || threads < 1 || threads > MaxArgon2Parallelism || memory < 8*threads || memory > MaxArgon2Memory {
+		return nil, fmt.Errorf("crypto: argon2 hash has out of range parameters m=%d,t=%d,p=%d", memory, time, threads)
 	}
 
 	rawHash, err := base64.RawStdEncoding.DecodeString(hashB64)
 	if err != nil {
-		return fmt.Errorf("crypto: argon2 hash has invalid base64 in the hash section %w", err)
+		return nil, fmt.Errorf("crypto: argon2 hash has invalid base64 in the hash section %w", err)
 	}
 
 	salt, err := base64.RawStdEncoding.DecodeString(saltB64)
 	if err != nil {
-		return fmt.Errorf("crypto: argon2 hash has invalid base64 in the salt section %w", err)
+		return nil, fmt.Errorf("crypto: argon2 hash has invalid base64 in the salt section %w", err)
+	}
+
+	return &argon2Hash{
+		alg:     alg,
+		v:       v,
+		memory:  uint32(memory),
+		time:    uint32(time),
+		threads: uint8(threads),
+		salt:    salt,
+		rawHash: rawHash,
+	}, nil
+}
+
+func compareHashAndPasswordArgon2(ctx context.Context, hash, password string) error {
+	h, err := parseArgon2Hash(hash)
+	if err != nil {
+		return err
 	}
 
 	var match bool
 	var derivedKey []byte
 
 	attributes := []attribute.KeyValue{
-		attribute.String("alg", alg),
-		attribute.String("v", v),
-		attribute.Int64("m", int64(memory)),
-		attribute.Int64("t", int64(time)),
-		attribute.Int("p", int(threads)),
-		attribute.Int("len", len(rawHash)),
+		attribute.String("alg", h.alg),
+		attribute.String("v", h.v),
+		attribute.Int64("m", int64(h.memory)),
+		attribute.Int64("t", int64(h.time)),
+		attribute.Int("p", int(h.threads)),
+		attribute.Int("len", len(h.rawHash)),
 	}
 
 	compareHashAndPasswordSubmittedCounter.Add(ctx, 1, metric.WithAttributes(attributes...))
@@ -134,15 +273,16 @@ func compareHashAndPasswordArgon2(ctx context.Context, hash, password string) er
 		compareHashAndPasswordCompletedCounter.Add(ctx, 1, metric.WithAttributes(attributes...))
 	}()
 
-	switch alg {
+	// the m parameter is already in KiB, as argon2 expects
+	switch h.alg {
 	case "argon2i":
-		derivedKey = argon2.Key([]byte(password), salt, uint32(time), uint32(memory)*1024, uint8(threads), uint32(len(rawHash)))
+		derivedKey = argon2.Key([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.rawHash)))
 
 	case "argon2id":
-		derivedKey = argon2.IDKey([]byte(password), salt, uint32(time), uint32(memory)*1024, uint8(threads), uint32(len(rawHash)))
+		derivedKey = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.rawHash)))
 	}
 
-	match = subtle.ConstantTimeCompare(derivedKey, rawHash) == 0
+	match = subtle.ConstantTimeCompare(derivedKey, h.rawHash) == 1
 
 	if !match {
 		return ErrArgon2MismatchedHashAndPassword
@@ -151,14 +291,205 @@ func compareHashAndPasswordArgon2(ctx context.Context, hash, password string) er
 	return nil
 }
 
+type scryptHash struct {
+	alg     string
+	logN    uint64
+	r       int
+	p       int
+	salt    []byte
+	rawHash []byte
+
+	// only set for Firebase's modified scrypt
+	saltSeparator []byte
+	signerKey     []byte
+}
+
+func parseScryptParameters(alg, ln, r, p string) (*scryptHash, error) {
+	logN, err := strconv.ParseUint(ln, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: %s hash has invalid N parameter %q %w", alg, ln, err)
+	}
+
+	rounds, err := strconv.ParseUint(r, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: %s hash has invalid r parameter %q %w", alg, r, err)
+	}
+
+	threads, err := strconv.ParseUint(p, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: %s hash has invalid p parameter %q %w", alg, p, err)
+	}
+
+	if logN < 1 || logN > maxScryptLogN || rounds < 1 || rounds > maxScryptRounds || threads < 1 || threads > maxScryptParallelism || (128*rounds)<<logN > maxScryptMemory {
+		return nil, fmt.Errorf("crypto: %s hash has out of range parameters N=2^%d,r=%d,p=%d", alg, logN, rounds, threads)
+	}
+
+	return &scryptHash{
+		alg:  alg,
+		logN: logN,
+		r:    int(rounds),
+		p:    int(threads),
+	}, nil
+}
+
+func parseScryptHash(hash string) (*scryptHash, error) {
+	submatch := scryptHashRegexp.FindStringSubmatchIndex(hash)
+
+	if submatch == nil {
+		return nil, errors.New("crypto: incorrect scrypt hash format")
+	}
+
+	h, err := parseScryptParameters(
+		"scrypt",
+		string(scryptHashRegexp.ExpandString(nil, "$ln", hash, submatch)),
+		string(scryptHashRegexp.ExpandString(nil, "$r", hash, submatch)),
+		string(scryptHashRegexp.ExpandString(nil, "$p", hash, submatch)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.salt, err = base64.RawStdEncoding.DecodeString(string(scryptHashRegexp.ExpandString(nil, "$salt", hash, submatch))); err != nil {
+		return nil, fmt.Errorf("crypto: scrypt hash has invalid base64 in the salt section %w", err)
+	}
+
+	if h.rawHash, err = base64.RawStdEncoding.DecodeString(string(scryptHashRegexp.ExpandString(nil, "$hash", hash, submatch))); err != nil {
+		return nil, fmt.Errorf("crypto: scrypt hash has invalid base64 in the hash section %w", err)
+	}
+
+	return h, nil
+}
+
+func parseFirebaseScryptHash(hash string) (*scryptHash, error) {
+	submatch := firebaseScryptHashRegexp.FindStringSubmatchIndex(hash)
+
+	if submatch == nil {
+		return nil, errors.New("crypto: incorrect firebase scrypt hash format")
+	}
+
+	h, err := parseScryptParameters(
+		"firebase scrypt",
+		string(firebaseScryptHashRegexp.ExpandString(nil, "$n", hash, submatch)),
+		string(firebaseScryptHashRegexp.ExpandString(nil, "$r", hash, submatch)),
+		string(firebaseScryptHashRegexp.ExpandString(nil, "$p", hash, submatch)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// firebase exports padded base64
+	sections := []struct {
+		name  string
+		value *[]byte
+	}{
+		{"ss", &h.saltSeparator},
+		{"sk", &h.signerKey},
+		{"salt", &h.salt},
+		{"hash", &h.rawHash},
+	}
+
+	for _, section := range sections {
+		*section.value, err = base64.StdEncoding.DecodeString(string(firebaseScryptHashRegexp.ExpandString(nil, "$"+section.name, hash, submatch)))
+		if err != nil {
+			return nil, fmt.Errorf("crypto: firebase scrypt hash has invalid base64 in the %s section %w", section.name, err)
+		}
+	}
+
+	if len(h.rawHash) == 0 || len(h.signerKey) == 0 {
+		return nil, errors.New("crypto: firebase scrypt hash has an empty hash or signer key")
+	}
+
+	return h, nil
+}
+
+// firebaseScrypt derives an AES-256 key from the password with scrypt and
+// returns the signer key encrypted with it, which is what Firebase stores.
+func firebaseScrypt(password []byte, h *scryptHash) ([]byte, error) {
+	salt := make([]byte, 0, len(h.salt)+len(h.saltSeparator))
+	salt = append(salt, h.salt...)
+	salt = append(salt, h.saltSeparator...)
+
+	key, err := scrypt.Key(password, salt, 1<<h.logN, h.r, h.p, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	derivedKey := make([]byte, len(h.signerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(derivedKey, h.signerKey)
+
+	return derivedKey, nil
+}
+
+func compareHashAndPasswordScrypt(ctx context.Context, hash, password string) error {
+	var h *scryptHash
+	var err error
+
+	if strings.HasPrefix(hash, "$fbscrypt$") {
+		h, err = parseFirebaseScryptHash(hash)
+	} else {
+		h, err = parseScryptHash(hash)
+	}
+	if err != nil {
+		return err
+	}
+
+	var match bool
+	var derivedKey []byte
+
+	attributes := []attribute.KeyValue{
+		attribute.String("alg", h.alg),
+		attribute.Int64("ln", int64(h.logN)),
+		attribute.Int("r", h.r),
+		attribute.Int("p", h.p),
+		attribute.Int("len", len(h.rawHash)),
+	}
+
+	compareHashAndPasswordSubmittedCounter.Add(ctx, 1, metric.WithAttributes(attributes...))
+	defer func() {
+		attributes = append(attributes, attribute.Bool(
+			"match",
+			match,
+		))
+
+		compareHashAndPasswordCompletedCounter.Add(ctx, 1, metric.WithAttributes(attributes...))
+	}()
+
+	if h.signerKey != nil {
+		derivedKey, err = firebaseScrypt([]byte(password), h)
+	} else {
+		derivedKey, err = scrypt.Key([]byte(password), h.salt, 1<<h.logN, h.r, h.p, len(h.rawHash))
+	}
+	if err != nil {
+		return fmt.Errorf("crypto: %s hash could not be computed %w", h.alg, err)
+	}
+
+	match = subtle.ConstantTimeCompare(derivedKey, h.rawHash) == 1
+
+	if !match {
+		return ErrScryptMismatchedHashAndPassword
+	}
+
+	return nil
+}
+
 // CompareHashAndPassword compares the hash and
-// password, returns nil if equal otherwise an error. Context can be used to
-// cancel the hashing if the algorithm supports it.
+// password, returns nil if equal otherwise an error. The algorithm is detected
+// from the prefix of the hash. Context can be used to cancel the hashing if
+// the algorithm supports it.
 func CompareHashAndPassword(ctx context.Context, hash, password string) error {
 	if strings.HasPrefix(hash, "$argon2") {
 		return compareHashAndPasswordArgon2(ctx, hash, password)
 	}
 
+	if strings.HasPrefix(hash, "$scrypt$") || strings.HasPrefix(hash, "$fbscrypt$") {
+		return compareHashAndPasswordScrypt(ctx, hash, password)
+	}
+
 	// assume bcrypt
 	hashCost, err := bcrypt.Cost([]byte(hash))
 	if err != nil {
@@ -185,36 +516,119 @@ func CompareHashAndPassword(ctx context.Context, hash, password string) error {
 	return err
 }
 
+// ValidatePasswordHash checks that hash is a well-formed bcrypt, argon2,
+// scrypt or Firebase scrypt hash that CompareHashAndPassword is able to
+// verify. It is used to reject pre-hashed passwords that would otherwise make
+// an account unusable.
+func ValidatePasswordHash(hash string) error {
+	var err error
+
+	switch {
+	case strings.HasPrefix(hash, "$argon2"):
+		_, err = parseArgon2Hash(hash)
+
+	case strings.HasPrefix(hash, "$scrypt$"):
+		_, err = parseScryptHash(hash)
+
+	case strings.HasPrefix(hash, "$fbscrypt$"):
+		_, err = parseFirebaseScryptHash(hash)
+
+	default:
+		if _, err = bcrypt.Cost([]byte(hash)); err != nil {
+			err = fmt.Errorf("crypto: unsupported password hash format %w", err)
+		}
+	}
+
+	return err
+}
+
+// NeedsRehash reports whether a hash that CompareHashAndPassword verified
+// was generated with another algorithm or cost than PasswordHashing, so that
+// it should be replaced with a hash from GenerateFromPassword.
+func NeedsRehash(hash string) bool {
+	params, err := PasswordHashing.effective()
+	if err != nil {
+		// no hash could replace it
+		return false
+	}
+
+	switch params.Algorithm {
+	case Argon2idHashAlgorithm:
+		h, err := parseArgon2Hash(hash)
+		if err != nil {
+			return true
+		}
+
+		return h.alg != "argon2id" || h.memory != params.Argon2Memory || h.time != params.Argon2Iterations || h.threads != params.Argon2Parallelism
+
+	default:
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return true
+		}
+
+		return cost != params.BcryptCost
+	}
+}
+
 // GenerateFromPassword generates a password hash from a
-// password, using PasswordHashCost. Context can be used to cancel the hashing
-// if the algorithm supports it.
+// password, using PasswordHashing and PasswordHashCost. Context can be used to
+// cancel the hashing if the algorithm supports it.
 func GenerateFromPassword(ctx context.Context, password string) (string, error) {
-	var hashCost int
-
 	if len(password) > MaxPasswordLength {
 		return "", fmt.Errorf("password cannot be longer than %d characters", MaxPasswordLength)
 	}
 
-	switch PasswordHashCost {
-	case QuickHashCost:
-		hashCost = bcrypt.MinCost
+	params, err := PasswordHashing.effective()
+	if err != nil {
+		return "", err
+	}
 
-	default:
-		hashCost = bcrypt.DefaultCost
+	if params.Algorithm == Argon2idHashAlgorithm {
+		return generateFromPasswordArgon2id(ctx, password, params)
 	}
 
 	attributes := []attribute.KeyValue{
 		attribute.String("alg", "bcrypt"),
-		attribute.Int("bcrypt_cost", hashCost),
+		attribute.Int("bcrypt_cost", params.BcryptCost),
 	}
 
 	generateFromPasswordSubmittedCounter.Add(ctx, 1, metric.WithAttributes(attributes...))
 	defer generateFromPasswordCompletedCounter.Add(ctx, 1, metric.WithAttributes(attributes...))
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), hashCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
 	if err != nil {
 		return "", err
 	}
 
 	return string(hash), nil
 }
+
+func generateFromPasswordArgon2id(ctx context.Context, password string, params PasswordHashParams) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	attributes := []attribute.KeyValue{
+		attribute.String("alg", Argon2idHashAlgorithm),
+		attribute.Int64("m", int64(params.Argon2Memory)),
+		attribute.Int64("t", int64(params.Argon2Iterations)),
+		attribute.Int("p", int(params.Argon2Parallelism)),
+	}
+
+	generateFromPasswordSubmittedCounter.Add(ctx, 1, metric.WithAttributes(attributes...))
+	defer generateFromPasswordCompletedCounter.Add(ctx, 1, metric.WithAttributes(attributes...))
+
+	key := argon2.IDKey([]byte(password), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Argon2Memory,
+		params.Argon2Iterations,
+		params.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
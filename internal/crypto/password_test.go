@@ -17,5 +17,122 @@ func TestArgon2(t *testing.T) {
 
 	for _, example := range examples {
 		assert.NoError(t, CompareHashAndPassword(context.Background(), example, "test"))
+		assert.ErrorIs(t, CompareHashAndPassword(context.Background(), example, "wrong"), ErrArgon2MismatchedHashAndPassword)
+	}
+}
+
+func TestScrypt(t *testing.T) {
+	// hashes `test` with N=16, r=8, p=1
+	hash := "$scrypt$ln=4,r=8,p=1$YzJGc2RITmhiSFE$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68"
+
+	assert.NoError(t, CompareHashAndPassword(context.Background(), hash, "test"))
+	assert.ErrorIs(t, CompareHashAndPassword(context.Background(), hash, "wrong"), ErrScryptMismatchedHashAndPassword)
+}
+
+func TestFirebaseScrypt(t *testing.T) {
+	// the example of https://github.com/firebase/scrypt
+	hash := "$fbscrypt$v=1,n=14,r=8,p=1,ss=Bw==,sk=jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ=="
+
+	assert.NoError(t, CompareHashAndPassword(context.Background(), hash, "user1password"))
+	assert.ErrorIs(t, CompareHashAndPassword(context.Background(), hash, "user2password"), ErrScryptMismatchedHashAndPassword)
+}
+
+func TestGenerateFromPassword(t *testing.T) {
+	defer func(params PasswordHashParams) {
+		PasswordHashing = params
+	}(PasswordHashing)
+
+	PasswordHashing = PasswordHashParams{
+		Algorithm:         Argon2idHashAlgorithm,
+		Argon2Memory:      64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	}
+
+	hash, err := GenerateFromPassword(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=64,t=1,p=1\$`, hash)
+	assert.NoError(t, CompareHashAndPassword(context.Background(), hash, "test"))
+	assert.Error(t, CompareHashAndPassword(context.Background(), hash, "wrong"))
+	assert.False(t, NeedsRehash(hash))
+
+	PasswordHashing.Argon2Iterations = 2
+	assert.True(t, NeedsRehash(hash))
+
+	PasswordHashing = PasswordHashParams{
+		Algorithm:  BcryptHashAlgorithm,
+		BcryptCost: 5,
+	}
+	assert.True(t, NeedsRehash(hash))
+
+	hash, err = GenerateFromPassword(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.NoError(t, CompareHashAndPassword(context.Background(), hash, "test"))
+	assert.False(t, NeedsRehash(hash))
+
+	PasswordHashing.BcryptCost = 6
+	assert.True(t, NeedsRehash(hash))
+}
+
+func TestGenerateFromPasswordInvalidParams(t *testing.T) {
+	defer func(params PasswordHashParams) {
+		PasswordHashing = params
+	}(PasswordHashing)
+
+	hash, err := GenerateFromPassword(context.Background(), "test")
+	assert.NoError(t, err)
+
+	invalid := []PasswordHashParams{
+		{},
+		{Algorithm: BcryptHashAlgorithm},
+		{Algorithm: Argon2idHashAlgorithm},
+		{Algorithm: Argon2idHashAlgorithm, Argon2Memory: 4 * 1024 * 1024, Argon2Iterations: 2, Argon2Parallelism: 1},
+		{Algorithm: Argon2idHashAlgorithm, Argon2Memory: 19 * 1024, Argon2Iterations: 1000, Argon2Parallelism: 1},
+	}
+
+	for _, params := range invalid {
+		PasswordHashing = params
+		assert.Error(t, params.Validate())
+
+		_, err := GenerateFromPassword(context.Background(), "test")
+		assert.Error(t, err)
+		assert.False(t, NeedsRehash(hash))
+	}
+}
+
+func TestValidatePasswordHash(t *testing.T) {
+	hash, err := GenerateFromPassword(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.NoError(t, ValidatePasswordHash(hash))
+
+	valid := []string{
+		"$argon2id$v=19$m=32,t=3,p=2$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk",
+		"$scrypt$ln=4,r=8,p=1$YzJGc2RITmhiSFE$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68",
+		"$fbscrypt$v=1,n=14,r=8,p=1,ss=Bw==,sk=jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==",
+	}
+
+	for _, example := range valid {
+		assert.NoError(t, ValidatePasswordHash(example), example)
+	}
+
+	invalid := []string{
+		"",
+		"test",
+		"$2a$10$tooshort",
+		"$argon2d$v=19$m=32,t=3,p=2$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk",
+		"$argon2id$v=19$m=0,t=3,p=2$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk",
+		"$argon2id$v=19$m=4194304,t=3,p=2$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk",
+		"$argon2id$v=19$m=32,t=1000000,p=2$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk",
+		"$argon2id$v=19$m=8192,t=3,p=255$SFVpOWJ0eXhjRzVkdGN1RQ$RXnb8rh7LaDcn07xsssqqulZYXOM/EUCEFMVcAcyYVk",
+		"$scrypt$ln=40,r=8,p=1$YzJGc2RITmhiSFE$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68",
+		"$scrypt$ln=4,r=60000,p=1$YzJGc2RITmhiSFE$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68",
+		"$scrypt$ln=4,r=8,p=200$YzJGc2RITmhiSFE$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68",
+		"$scrypt$ln=20,r=32,p=1$YzJGc2RITmhiSFE$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68",
+		"$scrypt$ln=4,r=8,p=1$not base64$RcP6pUeGJyNHMXxxqOhtGL/SFlo/ouW/ms0egSEfR68",
+		"$fbscrypt$v=1,n=14,r=8,p=1,ss=Bw==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==",
+	}
+
+	for _, example := range invalid {
+		assert.Error(t, ValidatePasswordHash(example), example)
 	}
 }
@@ -35,6 +35,12 @@ type HookOutput interface {
 // TODO(joel): Move this to phone package
 type SMS struct {
 	OTP string `json:"otp,omitempty"`
+	// Phone is the number the SMS is sent to, which is the new number of
+	// phone changes.
+	Phone   string `json:"phone,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	// Message is the SMS template filled in with the OTP.
+	Message string `json:"message,omitempty"`
 }
 
 // #nosec
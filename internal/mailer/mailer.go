@@ -4,12 +4,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	texttemplate "text/template"
 
-	"github.com/gofrs/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/models"
-	"github.com/supabase/mailme"
 	"gopkg.in/gomail.v2"
 )
 
@@ -44,16 +44,7 @@ type EmailData struct {
 
 // NewMailer returns a new gotrue mailer
 func NewMailer(globalConfig *conf.GlobalConfiguration) Mailer {
-	mail := gomail.NewMessage()
-
-	mail.SetHeaders(map[string][]string{
-		// Make the emails explicitly set to be HTML formatted (to cover older email clients)
-		"Content-Type": {"text/html; charset=utf-8"},
-		// so that messages are not grouped under each other
-		"Message-ID": {fmt.Sprintf("<%s@gotrue-mailer>", uuid.Must(uuid.NewV4()).String())},
-	})
-
-	from := mail.FormatAddress(globalConfig.SMTP.AdminEmail, globalConfig.SMTP.SenderName)
+	from := gomail.NewMessage().FormatAddress(globalConfig.SMTP.AdminEmail, globalConfig.SMTP.SenderName)
 	u, _ := url.ParseRequestURI(globalConfig.API.ExternalURL)
 
 	var mailClient MailClient
@@ -61,15 +52,15 @@ func NewMailer(globalConfig *conf.GlobalConfiguration) Mailer {
 		logrus.Infof("Noop mail client being used for %v", globalConfig.SiteURL)
 		mailClient = &noopMailClient{}
 	} else {
-		mailClient = &mailme.Mailer{
-			Host:      globalConfig.SMTP.Host,
-			Port:      globalConfig.SMTP.Port,
-			User:      globalConfig.SMTP.User,
-			Pass:      globalConfig.SMTP.Pass,
-			LocalName: u.Hostname(),
-			From:      from,
-			BaseURL:   globalConfig.SiteURL,
-			Logger:    logrus.StandardLogger(),
+		mailClient = &smtpMailClient{
+			Host:       globalConfig.SMTP.Host,
+			Port:       globalConfig.SMTP.Port,
+			User:       globalConfig.SMTP.User,
+			Pass:       globalConfig.SMTP.Pass,
+			LocalName:  u.Hostname(),
+			From:       from,
+			MaxRetries: globalConfig.SMTP.MaxRetries,
+			Timeout:    globalConfig.Mailer.Timeout,
 		}
 	}
 
@@ -80,6 +71,54 @@ func NewMailer(globalConfig *conf.GlobalConfiguration) Mailer {
 	}
 }
 
+// ValidateTemplates checks the configured subjects and email templates so
+// that mistakes show up when the server starts rather than when the first
+// email is sent. Templates on the filesystem must load and parse. Remote
+// templates may be temporarily unavailable, so failing to fetch them is only
+// logged; they are loaded into the template cache otherwise.
+func ValidateTemplates(globalConfig *conf.GlobalConfiguration) error {
+	mailerConfig := globalConfig.Mailer
+
+	subjects := map[string]string{
+		"invite":           mailerConfig.Subjects.Invite,
+		"confirmation":     mailerConfig.Subjects.Confirmation,
+		"recovery":         mailerConfig.Subjects.Recovery,
+		"email_change":     mailerConfig.Subjects.EmailChange,
+		"magic_link":       mailerConfig.Subjects.MagicLink,
+		"reauthentication": mailerConfig.Subjects.Reauthentication,
+	}
+	for name, subject := range subjects {
+		if _, err := texttemplate.New(name).Parse(subject); err != nil {
+			return fmt.Errorf("mailer: invalid %s subject template: %w", name, err)
+		}
+	}
+
+	bodies := map[string]string{
+		"invite":           mailerConfig.Templates.Invite,
+		"confirmation":     mailerConfig.Templates.Confirmation,
+		"recovery":         mailerConfig.Templates.Recovery,
+		"email_change":     mailerConfig.Templates.EmailChange,
+		"magic_link":       mailerConfig.Templates.MagicLink,
+		"reauthentication": mailerConfig.Templates.Reauthentication,
+	}
+	for name, location := range bodies {
+		if location == "" {
+			continue
+		}
+
+		location = resolveTemplateLocation(globalConfig.SiteURL, location)
+		if _, err := templates.Get(location, mailerConfig.TemplateCacheTTL); err != nil {
+			if strings.HasPrefix(location, "file://") {
+				return fmt.Errorf("mailer: invalid %s template: %w", name, err)
+			}
+
+			logrus.WithError(err).WithField("template", location).Warnf("unable to load %s email template, the default will be used until it is available", name)
+		}
+	}
+
+	return nil
+}
+
 func withDefault(value, defaultValue string) string {
 	if value == "" {
 		return defaultValue
@@ -1,12 +1,13 @@
 package mailer
 
 import (
+	"context"
 	"errors"
 )
 
 type noopMailClient struct{}
 
-func (m *noopMailClient) Mail(to, subjectTemplate, templateURL, defaultTemplate string, templateData map[string]interface{}) error {
+func (m *noopMailClient) Mail(ctx context.Context, to, subject, body string) error {
 	if to == "" {
 		return errors.New("to field cannot be empty")
 	}
@@ -0,0 +1,356 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/supabase/auth/internal/utilities"
+	"gopkg.in/gomail.v2"
+)
+
+// ErrEmailUndeliverable is returned when the mail server permanently rejects
+// the recipient of an email.
+var ErrEmailUndeliverable = errors.New("email could not be delivered to this address")
+
+const (
+	// DefaultSMTPTimeout bounds sending a single email, retries included,
+	// when no timeout is configured.
+	DefaultSMTPTimeout = 30 * time.Second
+
+	// smtpIdleTimeout is how long a pooled connection may stay unused.
+	// Servers commonly drop idle clients after a minute.
+	smtpIdleTimeout = 30 * time.Second
+
+	// smtpMaxIdleConnections limits the pooled connections per server.
+	smtpMaxIdleConnections = 4
+)
+
+// smtpRetryBackoff is the delay before the first retry of a transient
+// failure. It doubles with every further attempt.
+var smtpRetryBackoff = time.Second
+
+type smtpMailClient struct {
+	Host      string
+	Port      int
+	User      string
+	Pass      string
+	LocalName string
+	From      string
+
+	// MaxRetries is how often transient failures are retried.
+	MaxRetries int
+	// Timeout bounds the whole send, including retries.
+	Timeout time.Duration
+}
+
+// Mail sends the email, retrying transient failures until the timeout. The
+// retries stop when ctx is done.
+func (m *smtpMailClient) Mail(ctx context.Context, to, subject, body string) error {
+	mail := gomail.NewMessage()
+	mail.SetHeaders(map[string][]string{
+		"From":    {m.From},
+		"To":      {to},
+		"Subject": {subject},
+		// so that messages are not grouped under each other
+		"Message-ID": {fmt.Sprintf("<%s@gotrue-mailer>", uuid.Must(uuid.NewV4()).String())},
+	})
+	mail.SetBody("text/html", body)
+
+	var message bytes.Buffer
+	if _, err := mail.WriteTo(&message); err != nil {
+		return err
+	}
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSMTPTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	backoff := smtpRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := m.send(deadline, to, message.Bytes())
+		if err == nil {
+			return nil
+		}
+
+		if !isTransientSMTPError(err) || attempt >= m.MaxRetries || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+
+		logrus.WithError(err).WithField("attempt", attempt+1).Warn("transient error sending email, retrying")
+		if err := utilities.Sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (m *smtpMailClient) send(deadline time.Time, to string, message []byte) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+
+	key := m.poolKey()
+	conn := smtpConnections.get(key, deadline)
+	if conn == nil {
+		if conn, err = m.dial(deadline); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.deliver(from.Address, to, message); err != nil {
+		conn.close()
+		return err
+	}
+
+	smtpConnections.put(key, conn)
+	return nil
+}
+
+func (m *smtpMailClient) poolKey() string {
+	return fmt.Sprintf("%s|%d|%s|%s", m.Host, m.Port, m.User, m.LocalName)
+}
+
+func (m *smtpMailClient) dial(deadline time.Time) (*smtpConn, error) {
+	address := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	dialer := &net.Dialer{Deadline: deadline}
+	tlsConfig := &tls.Config{ServerName: m.Host}
+
+	var conn net.Conn
+	var err error
+	// port 465 expects TLS from the start, the others upgrade with STARTTLS
+	implicitTLS := m.Port == 465
+	if implicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &smtpConn{conn: conn, client: client}
+
+	if m.LocalName != "" {
+		if err := client.Hello(m.LocalName); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+
+	if !implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				c.close()
+				return nil, err
+			}
+		}
+	}
+
+	if m.User != "" {
+		if ok, mechanisms := client.Extension("AUTH"); ok {
+			var auth smtp.Auth
+			if strings.Contains(mechanisms, "CRAM-MD5") {
+				auth = smtp.CRAMMD5Auth(m.User, m.Pass)
+			} else if strings.Contains(mechanisms, "LOGIN") && !strings.Contains(mechanisms, "PLAIN") {
+				auth = &loginAuth{username: m.User, password: m.Pass, host: m.Host}
+			} else {
+				auth = smtp.PlainAuth("", m.User, m.Pass, m.Host)
+			}
+
+			if err := client.Auth(auth); err != nil {
+				c.close()
+				return nil, err
+			}
+		}
+	}
+
+	return c, nil
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func (c *smtpConn) deliver(from, to string, message []byte) error {
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+
+	if err := c.client.Rcpt(to); err != nil {
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+			return fmt.Errorf("%w: %v", ErrEmailUndeliverable, err)
+		}
+		return err
+	}
+
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func (c *smtpConn) close() {
+	c.conn.Close()
+}
+
+// smtpPool keeps connections to SMTP servers open between emails. Mailers
+// are created for each request, so the pool is shared.
+type smtpPool struct {
+	mutex sync.Mutex
+	idle  map[string][]*smtpConn
+}
+
+var smtpConnections = &smtpPool{
+	idle: make(map[string][]*smtpConn),
+}
+
+// get returns a pooled connection that is still usable, or nil if there is
+// none and a new connection has to be dialed.
+func (p *smtpPool) get(key string, deadline time.Time) *smtpConn {
+	for {
+		p.mutex.Lock()
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mutex.Unlock()
+			return nil
+		}
+		c := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+		p.mutex.Unlock()
+
+		if time.Since(c.lastUsed) > smtpIdleTimeout {
+			c.close()
+			continue
+		}
+
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			c.close()
+			continue
+		}
+
+		// the server may have closed the connection in the meantime
+		if err := c.client.Reset(); err != nil {
+			c.close()
+			continue
+		}
+
+		return c
+	}
+}
+
+func (p *smtpPool) put(key string, c *smtpConn) {
+	c.lastUsed = time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.idle[key]) >= smtpMaxIdleConnections {
+		c.close()
+		return
+	}
+	p.idle[key] = append(p.idle[key], c)
+}
+
+// isTransientSMTPError reports whether sending may succeed when retried:
+// 4xx replies from the server and network failures are, 5xx replies are not.
+func isTransientSMTPError(err error) bool {
+	if errors.Is(err, ErrEmailUndeliverable) {
+		return false
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which some
+// servers offer instead of PLAIN.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		advertised := false
+		for _, mechanism := range server.Auth {
+			if mechanism == "LOGIN" {
+				advertised = true
+				break
+			}
+		}
+		if !advertised {
+			return "", nil, errors.New("unencrypted connection")
+		}
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch {
+	case bytes.Equal(fromServer, []byte("Username:")):
+		return []byte(a.username), nil
+	case bytes.Equal(fromServer, []byte("Password:")):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
@@ -0,0 +1,285 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer is a minimal SMTP server whose replies to RCPT and DATA
+// can be scripted by tests.
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	// rcpt returns the reply to the n-th RCPT command, starting at 0.
+	rcpt func(n int) string
+	// stall, when set, delays the reply to DATA until it is closed.
+	stall chan struct{}
+
+	mutex       sync.Mutex
+	conns       []net.Conn
+	rcptCount   int
+	messages    []string
+	connections int
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	smtpRetryBackoff = time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeSMTPServer{listener: listener}
+	go s.serve()
+
+	t.Cleanup(func() {
+		listener.Close()
+		s.closeConnections()
+	})
+
+	return s
+}
+
+func (s *fakeSMTPServer) client(t *testing.T) *smtpMailClient {
+	host, port, err := net.SplitHostPort(s.listener.Addr().String())
+	require.NoError(t, err)
+
+	p, err := net.LookupPort("tcp", port)
+	require.NoError(t, err)
+
+	return &smtpMailClient{
+		Host:       host,
+		Port:       p,
+		LocalName:  "localhost",
+		From:       "Admin <admin@example.com>",
+		MaxRetries: 3,
+		Timeout:    5 * time.Second,
+	}
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.conns = append(s.conns, conn)
+		s.connections++
+		s.mutex.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) closeConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+
+	if !reply("220 localhost ESMTP") {
+		return
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250-localhost\r\n250 8BITMIME")
+		case strings.HasPrefix(command, "HELO"), strings.HasPrefix(command, "MAIL"), command == "RSET", command == "NOOP":
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT"):
+			s.mutex.Lock()
+			n := s.rcptCount
+			s.rcptCount++
+			s.mutex.Unlock()
+
+			if s.rcpt != nil {
+				reply(s.rcpt(n))
+			} else {
+				reply("250 OK")
+			}
+		case command == "DATA":
+			reply("354 Start mail input")
+
+			var message strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+
+			if s.stall != nil {
+				<-s.stall
+			}
+
+			s.mutex.Lock()
+			s.messages = append(s.messages, message.String())
+			s.mutex.Unlock()
+
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("500 Unknown command")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) stats() (connections, rcpts, messages int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.connections, s.rcptCount, len(s.messages)
+}
+
+func TestSMTPMailClientReusesConnections(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	client := server.client(t)
+
+	require.NoError(t, client.Mail(context.Background(), "first@example.com", "First", "<p>first</p>"))
+	require.NoError(t, client.Mail(context.Background(), "second@example.com", "Second", "<p>second</p>"))
+
+	connections, _, messages := server.stats()
+	require.Equal(t, 1, connections)
+	require.Equal(t, 2, messages)
+	require.Contains(t, server.messages[0], "Subject: First")
+	require.Contains(t, server.messages[1], "To: second@example.com")
+}
+
+func TestSMTPMailClientRedialsStaleConnections(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	client := server.client(t)
+
+	require.NoError(t, client.Mail(context.Background(), "first@example.com", "First", "<p>first</p>"))
+
+	// the server drops the idle connection
+	server.closeConnections()
+
+	require.NoError(t, client.Mail(context.Background(), "second@example.com", "Second", "<p>second</p>"))
+
+	connections, _, messages := server.stats()
+	require.Equal(t, 2, connections)
+	require.Equal(t, 2, messages)
+}
+
+func TestSMTPMailClientRetriesTransientErrors(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.rcpt = func(n int) string {
+		if n < 2 {
+			return "450 Mailbox temporarily unavailable"
+		}
+		return "250 OK"
+	}
+
+	require.NoError(t, server.client(t).Mail(context.Background(), "test@example.com", "Subject", "<p>body</p>"))
+
+	_, rcpts, messages := server.stats()
+	require.Equal(t, 3, rcpts)
+	require.Equal(t, 1, messages)
+}
+
+func TestSMTPMailClientGivesUpAfterMaxRetries(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.rcpt = func(n int) string {
+		return "451 Try again later"
+	}
+
+	client := server.client(t)
+	client.MaxRetries = 2
+
+	err := client.Mail(context.Background(), "test@example.com", "Subject", "<p>body</p>")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrEmailUndeliverable))
+
+	_, rcpts, messages := server.stats()
+	require.Equal(t, 3, rcpts)
+	require.Equal(t, 0, messages)
+}
+
+func TestSMTPMailClientStopsRetryingWhenCancelled(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.rcpt = func(n int) string {
+		return "451 Try again later"
+	}
+	smtpRetryBackoff = time.Minute
+
+	client := server.client(t)
+	client.Timeout = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.Mail(ctx, "test@example.com", "Subject", "<p>body</p>")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	_, rcpts, _ := server.stats()
+	require.Equal(t, 1, rcpts)
+}
+
+func TestSMTPMailClientUndeliverable(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.rcpt = func(n int) string {
+		return "550 No such user here"
+	}
+
+	err := server.client(t).Mail(context.Background(), "nobody@example.com", "Subject", "<p>body</p>")
+	require.ErrorIs(t, err, ErrEmailUndeliverable)
+
+	// permanent failures are not retried
+	_, rcpts, messages := server.stats()
+	require.Equal(t, 1, rcpts)
+	require.Equal(t, 0, messages)
+}
+
+func TestSMTPMailClientTimeout(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.stall = make(chan struct{})
+	defer close(server.stall)
+
+	client := server.client(t)
+	client.Timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	err := client.Mail(context.Background(), "test@example.com", "Subject", "<p>body</p>")
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 2*time.Second)
+
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), "expected a timeout, got %v", err)
+}
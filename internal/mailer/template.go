@@ -1,18 +1,26 @@
 package mailer
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"html/template"
 	"net/http"
 	"net/url"
 	"strings"
+	texttemplate "text/template"
+	"time"
 
 	"github.com/badoux/checkmail"
+	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/models"
+	"github.com/supabase/auth/internal/observability"
 )
 
+// MailClient delivers an already rendered email.
 type MailClient interface {
-	Mail(string, string, string, string, map[string]interface{}) error
+	Mail(ctx context.Context, to, subject, body string) error
 }
 
 // TemplateMailer will send mail and use templates from the site for easy mail styling
@@ -81,6 +89,84 @@ const defaultReauthenticateMail = `<h2>Confirm reauthentication</h2>
 
 <p>Enter the code: {{ .Token }}</p>`
 
+// localized returns the entry of localized matching the user's language, or
+// fallback if there is none.
+func (m *TemplateMailer) localized(user *models.User, localized map[string]string, fallback string) string {
+	languages := m.Config.Localization.LanguageFallbacks(user.GetLanguage())
+	if value, ok := conf.LookupLocalized(localized, languages); ok {
+		return value
+	}
+	return fallback
+}
+
+// mail renders the subject and body templates with data and hands the result
+// to the mail client. A custom body template that can't be loaded or rendered
+// is logged to log and replaced with defaultTemplate.
+func (m *TemplateMailer) mail(ctx context.Context, log logrus.FieldLogger, to, subjectTemplate, templateLocation, defaultTemplate string, data map[string]interface{}) error {
+	subject, err := renderSubject(subjectTemplate, data)
+	if err != nil {
+		return err
+	}
+
+	var body string
+	if templateLocation != "" {
+		location := resolveTemplateLocation(m.SiteURL, templateLocation)
+		body, err = renderTemplateAt(location, m.Config.Mailer.TemplateCacheTTL, data)
+		if err != nil {
+			log.WithError(err).WithField("template", location).Warn("unable to use custom email template, falling back to the default")
+		}
+	}
+
+	if templateLocation == "" || err != nil {
+		if body, err = renderBody(defaultTemplate, data); err != nil {
+			return err
+		}
+	}
+
+	return m.Mailer.Mail(ctx, to, subject, body)
+}
+
+func renderSubject(subjectTemplate string, data map[string]interface{}) (string, error) {
+	tmpl, err := texttemplate.New("subject").Parse(subjectTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func renderBody(bodyTemplate string, data map[string]interface{}) (string, error) {
+	tmpl, err := template.New("body").Parse(bodyTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	return executeTemplate(tmpl, data)
+}
+
+func renderTemplateAt(location string, ttl time.Duration, data map[string]interface{}) (string, error) {
+	tmpl, err := templates.Get(location, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	return executeTemplate(tmpl, data)
+}
+
+func executeTemplate(tmpl *template.Template, data map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
 // ValidateEmail returns nil if the email is valid,
 // otherwise an error indicating the reason it is invalid
 func (m TemplateMailer) ValidateEmail(email string) error {
@@ -109,10 +195,12 @@ func (m *TemplateMailer) InviteMail(r *http.Request, user *models.User, otp, ref
 		"RedirectTo":      referrerURL,
 	}
 
-	return m.Mailer.Mail(
+	return m.mail(
+		r.Context(),
+		observability.GetLogEntry(r).Entry,
 		user.GetEmail(),
-		withDefault(m.Config.Mailer.Subjects.Invite, "You have been invited"),
-		m.Config.Mailer.Templates.Invite,
+		withDefault(m.localized(user, m.Config.Mailer.LocalizedSubjects.Invite, m.Config.Mailer.Subjects.Invite), "You have been invited"),
+		m.localized(user, m.Config.Mailer.LocalizedTemplates.Invite, m.Config.Mailer.Templates.Invite),
 		defaultInviteMail,
 		data,
 	)
@@ -139,10 +227,12 @@ func (m *TemplateMailer) ConfirmationMail(r *http.Request, user *models.User, ot
 		"RedirectTo":      referrerURL,
 	}
 
-	return m.Mailer.Mail(
+	return m.mail(
+		r.Context(),
+		observability.GetLogEntry(r).Entry,
 		user.GetEmail(),
-		withDefault(m.Config.Mailer.Subjects.Confirmation, "Confirm Your Email"),
-		m.Config.Mailer.Templates.Confirmation,
+		withDefault(m.localized(user, m.Config.Mailer.LocalizedSubjects.Confirmation, m.Config.Mailer.Subjects.Confirmation), "Confirm Your Email"),
+		m.localized(user, m.Config.Mailer.LocalizedTemplates.Confirmation, m.Config.Mailer.Templates.Confirmation),
 		defaultConfirmationMail,
 		data,
 	)
@@ -157,10 +247,12 @@ func (m *TemplateMailer) ReauthenticateMail(r *http.Request, user *models.User,
 		"Data":    user.UserMetaData,
 	}
 
-	return m.Mailer.Mail(
+	return m.mail(
+		r.Context(),
+		observability.GetLogEntry(r).Entry,
 		user.GetEmail(),
-		withDefault(m.Config.Mailer.Subjects.Reauthentication, "Confirm reauthentication"),
-		m.Config.Mailer.Templates.Reauthentication,
+		withDefault(m.localized(user, m.Config.Mailer.LocalizedSubjects.Reauthentication, m.Config.Mailer.Subjects.Reauthentication), "Confirm reauthentication"),
+		m.localized(user, m.Config.Mailer.LocalizedTemplates.Reauthentication, m.Config.Mailer.Templates.Reauthentication),
 		defaultReauthenticateMail,
 		data,
 	)
@@ -180,8 +272,8 @@ func (m *TemplateMailer) EmailChangeMail(r *http.Request, user *models.User, otp
 			Address:   user.EmailChange,
 			Otp:       otpNew,
 			TokenHash: user.EmailChangeTokenNew,
-			Subject:   withDefault(m.Config.Mailer.Subjects.EmailChange, "Confirm Email Change"),
-			Template:  m.Config.Mailer.Templates.EmailChange,
+			Subject:   withDefault(m.localized(user, m.Config.Mailer.LocalizedSubjects.EmailChange, m.Config.Mailer.Subjects.EmailChange), "Confirm Email Change"),
+			Template:  m.localized(user, m.Config.Mailer.LocalizedTemplates.EmailChange, m.Config.Mailer.Templates.EmailChange),
 		},
 	}
 
@@ -191,8 +283,8 @@ func (m *TemplateMailer) EmailChangeMail(r *http.Request, user *models.User, otp
 			Address:   currentEmail,
 			Otp:       otpCurrent,
 			TokenHash: user.EmailChangeTokenCurrent,
-			Subject:   withDefault(m.Config.Mailer.Subjects.Confirmation, "Confirm Email Address"),
-			Template:  m.Config.Mailer.Templates.EmailChange,
+			Subject:   withDefault(m.localized(user, m.Config.Mailer.LocalizedSubjects.Confirmation, m.Config.Mailer.Subjects.Confirmation), "Confirm Email Address"),
+			Template:  m.localized(user, m.Config.Mailer.LocalizedTemplates.EmailChange, m.Config.Mailer.Templates.EmailChange),
 		})
 	}
 
@@ -209,7 +301,7 @@ func (m *TemplateMailer) EmailChangeMail(r *http.Request, user *models.User, otp
 		if err != nil {
 			return err
 		}
-		go func(address, token, tokenHash, template string) {
+		go func(address, token, tokenHash, subject, template string) {
 			data := map[string]interface{}{
 				"SiteURL":         m.Config.SiteURL,
 				"ConfirmationURL": externalURL.ResolveReference(path).String(),
@@ -221,14 +313,16 @@ func (m *TemplateMailer) EmailChangeMail(r *http.Request, user *models.User, otp
 				"Data":            user.UserMetaData,
 				"RedirectTo":      referrerURL,
 			}
-			errors <- m.Mailer.Mail(
+			errors <- m.mail(
+				r.Context(),
+				observability.GetLogEntry(r).Entry,
 				address,
-				withDefault(m.Config.Mailer.Subjects.EmailChange, "Confirm Email Change"),
+				subject,
 				template,
 				defaultEmailChangeMail,
 				data,
 			)
-		}(email.Address, email.Otp, email.TokenHash, email.Template)
+		}(email.Address, email.Otp, email.TokenHash, email.Subject, email.Template)
 	}
 
 	for i := 0; i < len(emails); i++ {
@@ -261,10 +355,12 @@ func (m *TemplateMailer) RecoveryMail(r *http.Request, user *models.User, otp, r
 		"RedirectTo":      referrerURL,
 	}
 
-	return m.Mailer.Mail(
+	return m.mail(
+		r.Context(),
+		observability.GetLogEntry(r).Entry,
 		user.GetEmail(),
-		withDefault(m.Config.Mailer.Subjects.Recovery, "Reset Your Password"),
-		m.Config.Mailer.Templates.Recovery,
+		withDefault(m.localized(user, m.Config.Mailer.LocalizedSubjects.Recovery, m.Config.Mailer.Subjects.Recovery), "Reset Your Password"),
+		m.localized(user, m.Config.Mailer.LocalizedTemplates.Recovery, m.Config.Mailer.Templates.Recovery),
 		defaultRecoveryMail,
 		data,
 	)
@@ -291,18 +387,22 @@ func (m *TemplateMailer) MagicLinkMail(r *http.Request, user *models.User, otp,
 		"RedirectTo":      referrerURL,
 	}
 
-	return m.Mailer.Mail(
+	return m.mail(
+		r.Context(),
+		observability.GetLogEntry(r).Entry,
 		user.GetEmail(),
-		withDefault(m.Config.Mailer.Subjects.MagicLink, "Your Magic Link"),
-		m.Config.Mailer.Templates.MagicLink,
+		withDefault(m.localized(user, m.Config.Mailer.LocalizedSubjects.MagicLink, m.Config.Mailer.Subjects.MagicLink), "Your Magic Link"),
+		m.localized(user, m.Config.Mailer.LocalizedTemplates.MagicLink, m.Config.Mailer.Templates.MagicLink),
 		defaultMagicLinkMail,
 		data,
 	)
 }
 
 // Send can be used to send one-off emails to users
-func (m TemplateMailer) Send(user *models.User, subject, body string, data map[string]interface{}) error {
-	return m.Mailer.Mail(
+func (m *TemplateMailer) Send(user *models.User, subject, body string, data map[string]interface{}) error {
+	return m.mail(
+		context.Background(),
+		logrus.StandardLogger(),
 		user.GetEmail(),
 		subject,
 		"",
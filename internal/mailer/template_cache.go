@@ -0,0 +1,119 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTemplateSize limits how much of a remote template is read.
+const maxTemplateSize = 1 << 20
+
+type cachedTemplate struct {
+	tmpl      *template.Template
+	expiresAt time.Time
+}
+
+// templateCache holds parsed email templates keyed by their resolved
+// location. Mailers are created for each request, so the cache is shared.
+type templateCache struct {
+	mutex     sync.Mutex
+	templates map[string]*cachedTemplate
+	client    *http.Client
+}
+
+var templates = newTemplateCache()
+
+func newTemplateCache() *templateCache {
+	return &templateCache{
+		templates: make(map[string]*cachedTemplate),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Get returns the parsed template at location, loading it again once the
+// cached copy is older than ttl. A ttl of zero disables caching.
+func (c *templateCache) Get(location string, ttl time.Duration) (*template.Template, error) {
+	c.mutex.Lock()
+	cached, ok := c.templates[location]
+	c.mutex.Unlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.tmpl, nil
+	}
+
+	body, err := c.load(location)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(location).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		c.mutex.Lock()
+		c.templates[location] = &cachedTemplate{
+			tmpl:      tmpl,
+			expiresAt: time.Now().Add(ttl),
+		}
+		c.mutex.Unlock()
+	}
+
+	return tmpl, nil
+}
+
+func (c *templateCache) load(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "file":
+		body, err := os.ReadFile(u.Path)
+		if err != nil {
+			return "", err
+		}
+		return string(body), nil
+
+	case "http", "https":
+		resp, err := c.client.Get(location)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected status code %d when fetching template", resp.StatusCode)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxTemplateSize))
+		if err != nil {
+			return "", err
+		}
+		return string(body), nil
+	}
+
+	return "", errors.New("template location must be a file:// or http(s):// URL")
+}
+
+// resolveTemplateLocation turns a configured template into a location the
+// cache can load. Paths without a scheme are relative to the site URL.
+func resolveTemplateLocation(siteURL, location string) string {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "file://") {
+		return location
+	}
+
+	return siteURL + location
+}
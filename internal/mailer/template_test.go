@@ -0,0 +1,401 @@
+package mailer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/models"
+)
+
+type sentMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type recordingMailClient struct {
+	sent []sentMail
+}
+
+func (c *recordingMailClient) Mail(ctx context.Context, to, subject, body string) error {
+	c.sent = append(c.sent, sentMail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+func newTestTemplateMailer(t *testing.T) (*TemplateMailer, *recordingMailClient) {
+	// every test starts with an empty cache
+	templates = newTemplateCache()
+
+	client := &recordingMailClient{}
+	return &TemplateMailer{
+		SiteURL: "https://example.com",
+		Config: &conf.GlobalConfiguration{
+			SiteURL: "https://example.com",
+			Mailer: conf.MailerConfiguration{
+				TemplateCacheTTL:         time.Minute,
+				SecureEmailChangeEnabled: true,
+			},
+		},
+		Mailer: client,
+	}, client
+}
+
+func newTestUser(t *testing.T) *models.User {
+	user, err := models.NewUser("", "test@example.com", "", "authenticated", map[string]interface{}{
+		"name": "Test User",
+	})
+	require.NoError(t, err)
+
+	user.ConfirmationToken = "confirmation-token-hash"
+	user.RecoveryToken = "recovery-token-hash"
+	user.EmailChange = "new@example.com"
+	user.EmailChangeTokenNew = "email-change-new-hash"
+	user.EmailChangeTokenCurrent = "email-change-current-hash"
+
+	return user
+}
+
+func writeTemplateFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "template.html")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return "file://" + path
+}
+
+func TestTemplateMailerRendersTemplates(t *testing.T) {
+	externalURL, err := url.ParseRequestURI("https://auth.example.com")
+	require.NoError(t, err)
+
+	const customTemplate = `<p>{{ .Email }} {{ .Token }} {{ .SiteURL }} {{ .Data.name }}</p><a href="{{ .ConfirmationURL }}">link</a>`
+
+	cases := []struct {
+		desc             string
+		configure        func(c *conf.MailerConfiguration, location string)
+		send             func(m *TemplateMailer, user *models.User) error
+		expectedSubject  string
+		expectedTokenURL string
+	}{
+		{
+			desc: "Confirmation",
+			configure: func(c *conf.MailerConfiguration, location string) {
+				c.Templates.Confirmation = location
+				c.Subjects.Confirmation = "Welcome {{ .Email }}"
+			},
+			send: func(m *TemplateMailer, user *models.User) error {
+				return m.ConfirmationMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "", externalURL)
+			},
+			expectedSubject:  "Welcome test@example.com",
+			expectedTokenURL: "token=confirmation-token-hash&amp;type=signup",
+		},
+		{
+			desc: "Recovery",
+			configure: func(c *conf.MailerConfiguration, location string) {
+				c.Templates.Recovery = location
+				c.Subjects.Recovery = "Reset for {{ .Email }}"
+			},
+			send: func(m *TemplateMailer, user *models.User) error {
+				return m.RecoveryMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "", externalURL)
+			},
+			expectedSubject:  "Reset for test@example.com",
+			expectedTokenURL: "token=recovery-token-hash&amp;type=recovery",
+		},
+		{
+			desc: "Invite",
+			configure: func(c *conf.MailerConfiguration, location string) {
+				c.Templates.Invite = location
+				c.Subjects.Invite = "Join {{ .SiteURL }}"
+			},
+			send: func(m *TemplateMailer, user *models.User) error {
+				return m.InviteMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "", externalURL)
+			},
+			expectedSubject:  "Join https://example.com",
+			expectedTokenURL: "token=confirmation-token-hash&amp;type=invite",
+		},
+		{
+			desc: "Magic link",
+			configure: func(c *conf.MailerConfiguration, location string) {
+				c.Templates.MagicLink = location
+				c.Subjects.MagicLink = "Log in as {{ .Data.name }}"
+			},
+			send: func(m *TemplateMailer, user *models.User) error {
+				return m.MagicLinkMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "", externalURL)
+			},
+			expectedSubject:  "Log in as Test User",
+			expectedTokenURL: "token=recovery-token-hash&amp;type=magiclink",
+		},
+		{
+			desc: "Email change",
+			configure: func(c *conf.MailerConfiguration, location string) {
+				c.Templates.EmailChange = location
+				c.Subjects.EmailChange = "Change {{ .Email }} to {{ .NewEmail }}"
+				// only send to the new address
+				c.SecureEmailChangeEnabled = false
+			},
+			send: func(m *TemplateMailer, user *models.User) error {
+				return m.EmailChangeMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "654321", "", externalURL)
+			},
+			expectedSubject:  "Change test@example.com to new@example.com",
+			expectedTokenURL: "token=email-change-new-hash&amp;type=email_change",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			m, client := newTestTemplateMailer(t)
+			c.configure(&m.Config.Mailer, writeTemplateFile(t, customTemplate))
+
+			require.NoError(t, c.send(m, newTestUser(t)))
+			require.Len(t, client.sent, 1)
+
+			sent := client.sent[0]
+			require.Equal(t, c.expectedSubject, sent.Subject)
+			require.Contains(t, sent.Body, "123456")
+			require.Contains(t, sent.Body, "https://example.com")
+			require.Contains(t, sent.Body, "Test User")
+			require.Contains(t, sent.Body, c.expectedTokenURL)
+		})
+	}
+}
+
+func TestTemplateMailerDefaultTemplates(t *testing.T) {
+	externalURL, err := url.ParseRequestURI("https://auth.example.com")
+	require.NoError(t, err)
+
+	m, client := newTestTemplateMailer(t)
+	user := newTestUser(t)
+
+	require.NoError(t, m.ConfirmationMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "111111", "", externalURL))
+	require.NoError(t, m.RecoveryMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "222222", "", externalURL))
+
+	require.Len(t, client.sent, 2)
+	require.Equal(t, "Confirm Your Email", client.sent[0].Subject)
+	require.Contains(t, client.sent[0].Body, "Confirm your email")
+	require.Contains(t, client.sent[0].Body, "111111")
+	require.Equal(t, "Reset Your Password", client.sent[1].Subject)
+	require.Contains(t, client.sent[1].Body, "Reset password")
+	require.Contains(t, client.sent[1].Body, "222222")
+}
+
+func TestTemplateMailerRemoteTemplateCache(t *testing.T) {
+	externalURL, err := url.ParseRequestURI("https://auth.example.com")
+	require.NoError(t, err)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(`<p>Remote {{ .Token }}</p>`))
+	}))
+	defer server.Close()
+
+	m, client := newTestTemplateMailer(t)
+	m.Config.Mailer.Templates.Recovery = server.URL + "/recovery.html"
+	user := newTestUser(t)
+
+	require.NoError(t, m.RecoveryMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "", externalURL))
+	require.NoError(t, m.RecoveryMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "654321", "", externalURL))
+
+	require.Len(t, client.sent, 2)
+	require.Equal(t, "<p>Remote 123456</p>", client.sent[0].Body)
+	require.Equal(t, "<p>Remote 654321</p>", client.sent[1].Body)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests), "template should be served from the cache")
+
+	// without a TTL the template is fetched for every email
+	m.Config.Mailer.TemplateCacheTTL = 0
+	templates = newTemplateCache()
+	require.NoError(t, m.RecoveryMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "", externalURL))
+	require.NoError(t, m.RecoveryMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "", externalURL))
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestTemplateMailerFallback(t *testing.T) {
+	externalURL, err := url.ParseRequestURI("https://auth.example.com")
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		desc     string
+		location func(t *testing.T) string
+	}{
+		{
+			desc: "Remote template unavailable",
+			location: func(t *testing.T) string {
+				return server.URL + "/confirmation.html"
+			},
+		},
+		{
+			desc: "Missing file",
+			location: func(t *testing.T) string {
+				return "file://" + filepath.Join(t.TempDir(), "missing.html")
+			},
+		},
+		{
+			desc: "Template does not parse",
+			location: func(t *testing.T) string {
+				return writeTemplateFile(t, `<p>{{ .Token </p>`)
+			},
+		},
+		{
+			desc: "Template does not execute",
+			location: func(t *testing.T) string {
+				return writeTemplateFile(t, `<p>{{ .Token.Missing }}</p>`)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			m, client := newTestTemplateMailer(t)
+			m.Config.Mailer.Templates.Confirmation = c.location(t)
+
+			require.NoError(t, m.ConfirmationMail(httptest.NewRequest(http.MethodPost, "/", nil), newTestUser(t), "123456", "", externalURL))
+			require.Len(t, client.sent, 1)
+			require.Contains(t, client.sent[0].Body, "Confirm your email")
+			require.Contains(t, client.sent[0].Body, "123456")
+		})
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		desc      string
+		configure func(t *testing.T, c *conf.MailerConfiguration)
+		valid     bool
+	}{
+		{
+			desc:      "No custom templates",
+			configure: func(t *testing.T, c *conf.MailerConfiguration) {},
+			valid:     true,
+		},
+		{
+			desc: "Valid file template",
+			configure: func(t *testing.T, c *conf.MailerConfiguration) {
+				c.Templates.Invite = writeTemplateFile(t, `<p>{{ .ConfirmationURL }}</p>`)
+			},
+			valid: true,
+		},
+		{
+			desc: "Unavailable remote template",
+			configure: func(t *testing.T, c *conf.MailerConfiguration) {
+				c.Templates.Recovery = server.URL + "/recovery.html"
+			},
+			valid: true,
+		},
+		{
+			desc: "Missing file template",
+			configure: func(t *testing.T, c *conf.MailerConfiguration) {
+				c.Templates.MagicLink = "file://" + filepath.Join(t.TempDir(), "missing.html")
+			},
+			valid: false,
+		},
+		{
+			desc: "Invalid file template",
+			configure: func(t *testing.T, c *conf.MailerConfiguration) {
+				c.Templates.EmailChange = writeTemplateFile(t, `{{ if }}`)
+			},
+			valid: false,
+		},
+		{
+			desc: "Invalid subject",
+			configure: func(t *testing.T, c *conf.MailerConfiguration) {
+				c.Subjects.Confirmation = "Welcome {{ .Email"
+			},
+			valid: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			m, _ := newTestTemplateMailer(t)
+			c.configure(t, &m.Config.Mailer)
+
+			err := ValidateTemplates(m.Config)
+			if c.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestTemplateMailerLocalizedTemplates(t *testing.T) {
+	externalURL, err := url.ParseRequestURI("https://auth.example.com")
+	require.NoError(t, err)
+
+	cases := []struct {
+		desc            string
+		language        string
+		expectedSubject string
+		expectedBody    string
+	}{
+		{
+			desc:            "Exact match",
+			language:        "pt-BR",
+			expectedSubject: "Redefinir senha",
+			expectedBody:    "<p>pt-BR 123456</p>",
+		},
+		{
+			desc:            "Prefix fallback",
+			language:        "pt-PT",
+			expectedSubject: "Redefinir a palavra-passe",
+			expectedBody:    "<p>pt 123456</p>",
+		},
+		{
+			desc:            "Default language fallback",
+			language:        "fr",
+			expectedSubject: "Reset your password",
+			expectedBody:    "<p>en 123456</p>",
+		},
+		{
+			desc:            "No language",
+			language:        "",
+			expectedSubject: "Reset your password",
+			expectedBody:    "<p>en 123456</p>",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			m, client := newTestTemplateMailer(t)
+			m.Config.Localization.DefaultLanguage = "en"
+			m.Config.Mailer.Subjects.Recovery = "Unlocalized subject"
+			m.Config.Mailer.LocalizedSubjects.Recovery = map[string]string{
+				"en":    "Reset your password",
+				"pt":    "Redefinir a palavra-passe",
+				"pt-BR": "Redefinir senha",
+			}
+			m.Config.Mailer.LocalizedTemplates.Recovery = map[string]string{
+				"en":    writeTemplateFile(t, `<p>en {{ .Token }}</p>`),
+				"pt":    writeTemplateFile(t, `<p>pt {{ .Token }}</p>`),
+				"pt-BR": writeTemplateFile(t, `<p>pt-BR {{ .Token }}</p>`),
+			}
+
+			user := newTestUser(t)
+			if c.language != "" {
+				user.UserMetaData["language"] = c.language
+			}
+
+			require.NoError(t, m.RecoveryMail(httptest.NewRequest(http.MethodPost, "/", nil), user, "123456", "", externalURL))
+			require.Len(t, client.sent, 1)
+			require.Equal(t, c.expectedSubject, client.sent[0].Subject)
+			require.Equal(t, c.expectedBody, client.sent[0].Body)
+		})
+	}
+}
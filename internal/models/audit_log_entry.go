@@ -11,6 +11,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/supabase/auth/internal/observability"
 	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/utilities"
 )
 
 type AuditAction string
@@ -23,6 +24,7 @@ const (
 	UserSignedUpAction              AuditAction = "user_signedup"
 	UserInvitedAction               AuditAction = "user_invited"
 	UserDeletedAction               AuditAction = "user_deleted"
+	UsersImportedAction             AuditAction = "users_imported"
 	UserModifiedAction              AuditAction = "user_modified"
 	UserRecoveryRequestedAction     AuditAction = "user_recovery_requested"
 	UserReauthenticateAction        AuditAction = "user_reauthenticate_requested"
@@ -38,9 +40,28 @@ const (
 	VerifyFactorAction              AuditAction = "verification_attempted"
 	DeleteFactorAction              AuditAction = "factor_deleted"
 	DeleteRecoveryCodesAction       AuditAction = "recovery_codes_deleted"
+	RecoveryCodeUsedAction          AuditAction = "recovery_code_used"
 	UpdateFactorAction              AuditAction = "factor_updated"
 	MFACodeLoginAction              AuditAction = "mfa_code_login"
 	IdentityUnlinkAction            AuditAction = "identity_unlinked"
+	IdentityLinkAction              AuditAction = "identity_linked"
+	UserMergedAction                AuditAction = "user_merged"
+	UserViewedAction                AuditAction = "user_viewed"
+	SSOProviderCreatedAction        AuditAction = "sso_provider_created"
+	SSOProviderUpdatedAction        AuditAction = "sso_provider_updated"
+	SSOProviderDeletedAction        AuditAction = "sso_provider_deleted"
+	UserLockedAction                AuditAction = "user_locked"
+	UserUnlockedAction              AuditAction = "user_unlocked"
+	InstanceConfigUpdatedAction     AuditAction = "instance_config_updated"
+	DeviceApprovedAction            AuditAction = "device_approved"
+	FeatureFlagsUpdatedAction       AuditAction = "feature_flags_updated"
+	UsersExportedAction             AuditAction = "users_exported"
+	JWTKeyCreatedAction             AuditAction = "jwt_key_created"
+	JWTKeyPromotedAction            AuditAction = "jwt_key_promoted"
+	JWTKeyDeletedAction             AuditAction = "jwt_key_deleted"
+	OAuthClientCreatedAction        AuditAction = "oauth_client_created"
+	OAuthClientDeletedAction        AuditAction = "oauth_client_deleted"
+	SessionRevokedAction            AuditAction = "session_revoked"
 
 	account       auditLogType = "account"
 	team          auditLogType = "team"
@@ -57,6 +78,7 @@ var ActionLogTypeMap = map[AuditAction]auditLogType{
 	UserSignedUpAction:              team,
 	UserInvitedAction:               team,
 	UserDeletedAction:               team,
+	UsersImportedAction:             team,
 	TokenRevokedAction:              token,
 	TokenRefreshedAction:            token,
 	UserModifiedAction:              user,
@@ -73,6 +95,25 @@ var ActionLogTypeMap = map[AuditAction]auditLogType{
 	UpdateFactorAction:              factor,
 	MFACodeLoginAction:              factor,
 	DeleteRecoveryCodesAction:       recoveryCodes,
+	RecoveryCodeUsedAction:          recoveryCodes,
+	SSOProviderCreatedAction:        team,
+	SSOProviderUpdatedAction:        team,
+	SSOProviderDeletedAction:        team,
+	UserLockedAction:                account,
+	UserUnlockedAction:              user,
+	InstanceConfigUpdatedAction:     team,
+	DeviceApprovedAction:            account,
+	IdentityLinkAction:              team,
+	UserMergedAction:                team,
+	UserViewedAction:                team,
+	FeatureFlagsUpdatedAction:       team,
+	UsersExportedAction:             team,
+	JWTKeyCreatedAction:             team,
+	JWTKeyPromotedAction:            team,
+	JWTKeyDeletedAction:             team,
+	OAuthClientCreatedAction:        team,
+	OAuthClientDeletedAction:        team,
+	SessionRevokedAction:            account,
 }
 
 // AuditLogEntry is the database model for audit log entries.
@@ -124,27 +165,88 @@ func NewAuditLogEntry(r *http.Request, tx *storage.Connection, actor *User, acti
 		l.Payload["traits"] = traits
 	}
 
-	if err := tx.Create(&l); err != nil {
-		return errors.Wrap(err, "Database error creating audit log entry")
+	if ipAddress == "" {
+		l.IPAddress = utilities.GetClientIP(r)
+	} else {
+		l.IPAddress = ipAddress
+	}
+
+	if err := createAuditLogEntry(tx, &l); err != nil {
+		if _, ok := err.(auditLogRollbackError); ok {
+			return errors.Wrap(err, "Database error creating audit log entry")
+		}
+
+		// audit logging is best-effort and must not fail the request
+		observability.GetLogEntry(r).Entry.WithError(err).WithField("action", action).Error("unable to record audit log entry")
 	}
 
 	return nil
 }
 
-func FindAuditLogEntries(tx *storage.Connection, filterColumns []string, filterValue string, pageParams *Pagination) ([]*AuditLogEntry, error) {
+// auditLogRollbackError means the transaction could not be recovered after a
+// failed audit log insert and can no longer be used.
+type auditLogRollbackError struct {
+	error
+}
+
+// createAuditLogEntry inserts the entry behind a savepoint when running in a
+// transaction, as a failed insert would otherwise abort the whole transaction
+// in PostgreSQL.
+func createAuditLogEntry(tx *storage.Connection, l *AuditLogEntry) error {
+	if tx.TX == nil {
+		return tx.Create(l)
+	}
+
+	if err := tx.RawQuery("savepoint audit_log_entry").Exec(); err != nil {
+		return auditLogRollbackError{err}
+	}
+
+	if err := tx.Create(l); err != nil {
+		if rerr := tx.RawQuery("rollback to savepoint audit_log_entry").Exec(); rerr != nil {
+			return auditLogRollbackError{rerr}
+		}
+
+		return err
+	}
+
+	if err := tx.RawQuery("release savepoint audit_log_entry").Exec(); err != nil {
+		return auditLogRollbackError{err}
+	}
+
+	return nil
+}
+
+// AuditLogFilter narrows down the entries returned by FindAuditLogEntries.
+// Zero values are ignored.
+type AuditLogFilter struct {
+	// Columns are payload fields matched case-insensitively against Value.
+	Columns []string
+	Value   string
+
+	ActorID *uuid.UUID
+	Action  AuditAction
+	From    *time.Time
+	To      *time.Time
+}
+
+func FindAuditLogEntries(tx *storage.Connection, filter *AuditLogFilter, pageParams *Pagination) ([]*AuditLogEntry, error) {
 	q := tx.Q().Order("created_at desc").Where("instance_id = ?", uuid.Nil)
 
-	if len(filterColumns) > 0 && filterValue != "" {
-		lf := "%" + filterValue + "%"
+	if filter == nil {
+		filter = &AuditLogFilter{}
+	}
+
+	if len(filter.Columns) > 0 && filter.Value != "" {
+		lf := "%" + filter.Value + "%"
 
 		builder := bytes.NewBufferString("(")
-		values := make([]interface{}, len(filterColumns))
+		values := make([]interface{}, len(filter.Columns))
 
-		for idx, col := range filterColumns {
+		for idx, col := range filter.Columns {
 			builder.WriteString(fmt.Sprintf("payload->>'%s' ILIKE ?", col))
 			values[idx] = lf
 
-			if idx+1 < len(filterColumns) {
+			if idx+1 < len(filter.Columns) {
 				builder.WriteString(" OR ")
 			}
 		}
@@ -153,6 +255,22 @@ func FindAuditLogEntries(tx *storage.Connection, filterColumns []string, filterV
 		q = q.Where(builder.String(), values...)
 	}
 
+	if filter.ActorID != nil {
+		q = q.Where("payload->>'actor_id' = ?", filter.ActorID.String())
+	}
+
+	if filter.Action != "" {
+		q = q.Where("payload->>'action' = ?", string(filter.Action))
+	}
+
+	if filter.From != nil {
+		q = q.Where("created_at >= ?", *filter.From)
+	}
+
+	if filter.To != nil {
+		q = q.Where("created_at <= ?", *filter.To)
+	}
+
 	logs := []*AuditLogEntry{}
 	var err error
 	if pageParams != nil {
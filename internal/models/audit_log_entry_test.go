@@ -0,0 +1,46 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/storage"
+	"github.com/supabase/auth/internal/storage/test"
+)
+
+func TestAuditLogEntryIsBestEffort(t *testing.T) {
+	globalConfig, err := conf.LoadGlobal(modelsTestConfig)
+	require.NoError(t, err)
+	conn, err := test.SetupDBConnection(globalConfig)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, TruncateAll(conn))
+
+	u, err := NewUser("", "audit@example.com", "", globalConfig.JWT.Aud, nil)
+	require.NoError(t, err)
+	require.NoError(t, conn.Create(u))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+
+	err = conn.Transaction(func(tx *storage.Connection) error {
+		// channels can't be serialized to JSON so this entry is never written
+		if terr := NewAuditLogEntry(req, tx, u, UserModifiedAction, "", map[string]interface{}{
+			"invalid": make(chan int),
+		}); terr != nil {
+			return terr
+		}
+
+		return NewAuditLogEntry(req, tx, u, UserModifiedAction, "", nil)
+	})
+	require.NoError(t, err)
+
+	logs, err := FindAuditLogEntries(conn, &AuditLogFilter{ActorID: &u.ID, Action: UserModifiedAction}, nil)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	require.Equal(t, "127.0.0.1", logs[0].IPAddress)
+}
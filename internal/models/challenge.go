@@ -14,7 +14,10 @@ type Challenge struct {
 	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
 	VerifiedAt *time.Time `json:"verified_at,omitempty" db:"verified_at"`
 	IPAddress  string     `json:"ip_address" db:"ip_address"`
-	Factor     *Factor    `json:"factor,omitempty" belongs_to:"factor"`
+	// OtpCode is the hash of the code sent for the challenge of a phone
+	// factor, or empty when the SMS provider checks the codes itself.
+	OtpCode string  `json:"-" db:"otp_code"`
+	Factor  *Factor `json:"factor,omitempty" belongs_to:"factor"`
 }
 
 func (Challenge) TableName() string {
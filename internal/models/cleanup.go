@@ -6,7 +6,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/metric"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"go.opentelemetry.io/otel/attribute"
 
@@ -15,16 +17,24 @@ import (
 	"github.com/supabase/auth/internal/storage"
 )
 
+// cleanupStatement deletes or updates at most limit stale rows of table.
+type cleanupStatement struct {
+	table string
+	query string
+	limit int
+}
+
 type Cleanup struct {
-	cleanupStatements []string
+	cleanupStatements []cleanupStatement
 
 	// cleanupNext holds an atomically incrementing value that determines which of
 	// the cleanupStatements will be run next.
 	cleanupNext uint32
 
-	// cleanupAffectedRows tracks an OpenTelemetry metric on the total number of
-	// cleaned up rows.
-	cleanupAffectedRows atomic.Int64
+	// cleanupAffectedRows tracks an OpenTelemetry metric on the number of
+	// cleaned up rows per table.
+	cleanupAffectedRowsMutex sync.Mutex
+	cleanupAffectedRows      map[string]int64
 }
 
 func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
@@ -36,47 +46,109 @@ func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
 	tableMFAChallenges := Challenge{}.TableName()
 	tableMFAFactors := Factor{}.TableName()
 
-	c := &Cleanup{}
+	c := &Cleanup{
+		cleanupAffectedRows: make(map[string]int64),
+	}
+
+	batchSize := max(1, config.DB.CleanupBatchSize)
+
+	// sessions are deleted in smaller batches so that cascades don't
+	// overwork the database
+	sessionBatchSize := max(1, batchSize/10)
+
+	add := func(table string, limit int, format string, args ...interface{}) {
+		c.cleanupStatements = append(c.cleanupStatements, cleanupStatement{
+			table: table,
+			query: fmt.Sprintf(format, append(args, limit)...),
+			limit: limit,
+		})
+	}
 
 	// These statements intentionally use SELECT ... FOR UPDATE SKIP LOCKED
 	// as this makes sure that only rows that are not being used in another
 	// transaction are deleted. These deletes are thus very quick and
 	// efficient, as they don't wait on other transactions.
-	c.cleanupStatements = append(c.cleanupStatements,
-		fmt.Sprintf("delete from %q where id in (select id from %q where revoked is true and updated_at < now() - interval '24 hours' limit 100 for update skip locked);", tableRefreshTokens, tableRefreshTokens),
-		fmt.Sprintf("update %q set revoked = true, updated_at = now() where id in (select %q.id from %q join %q on %q.session_id = %q.id where %q.not_after < now() - interval '24 hours' and %q.revoked is false limit 100 for update skip locked);", tableRefreshTokens, tableRefreshTokens, tableRefreshTokens, tableSessions, tableRefreshTokens, tableSessions, tableSessions, tableRefreshTokens),
-		// sessions are deleted after 72 hours to allow refresh tokens
-		// to be deleted piecemeal; 10 at once so that cascades don't
-		// overwork the database
-		fmt.Sprintf("delete from %q where id in (select id from %q where not_after < now() - interval '72 hours' limit 10 for update skip locked);", tableSessions, tableSessions),
-		fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit 100 for update skip locked);", tableRelayStates, tableRelayStates),
-		fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit 100 for update skip locked);", tableFlowStates, tableFlowStates),
-		fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit 100 for update skip locked);", tableMFAChallenges, tableMFAChallenges),
-		fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' and status = 'unverified' limit 100 for update skip locked);", tableMFAFactors, tableMFAFactors),
-	)
+	revokedTokenRetentionSeconds := int(config.DB.CleanupRevokedTokenRetention.Seconds())
+	add(tableRefreshTokens, batchSize, "delete from %q where id in (select id from %q where revoked is true and updated_at < now() - interval '%d seconds' limit %d for update skip locked);", tableRefreshTokens, tableRefreshTokens, revokedTokenRetentionSeconds)
+	add(tableRefreshTokens, batchSize, "update %q set revoked = true, updated_at = now() where id in (select %q.id from %q join %q on %q.session_id = %q.id where %q.not_after < now() - interval '24 hours' and %q.revoked is false limit %d for update skip locked);", tableRefreshTokens, tableRefreshTokens, tableRefreshTokens, tableSessions, tableRefreshTokens, tableSessions, tableSessions, tableRefreshTokens)
+	// sessions are deleted after 72 hours to allow refresh tokens to be
+	// deleted piecemeal
+	add(tableSessions, sessionBatchSize, "delete from %q where id in (select id from %q where not_after < now() - interval '72 hours' limit %d for update skip locked);", tableSessions, tableSessions)
+	add(tableRelayStates, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit %d for update skip locked);", tableRelayStates, tableRelayStates)
+	add(tableFlowStates, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit %d for update skip locked);", tableFlowStates, tableFlowStates)
+	add(tableMFAChallenges, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit %d for update skip locked);", tableMFAChallenges, tableMFAChallenges)
+
+	tableWebAuthnChallenges := WebAuthnChallenge{}.TableName()
+	add(tableWebAuthnChallenges, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '24 hours' limit %d for update skip locked);", tableWebAuthnChallenges, tableWebAuthnChallenges)
+
+	// unverified factors are deleted once they can no longer be verified
+	factorExpirySeconds := int(config.MFA.FactorExpiryDuration.Seconds())
+	add(tableMFAFactors, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '%d seconds' and status = 'unverified' limit %d for update skip locked);", tableMFAFactors, tableMFAFactors, factorExpirySeconds)
+
+	// one time tokens are deleted a day after the longest OTP expiry, when
+	// they can no longer be verified
+	tableOneTimeTokens := OneTimeToken{}.TableName()
+	oneTimeTokenRetentionSeconds := int(max(config.Mailer.OtpExp, config.Sms.OtpExp)) + int((24 * time.Hour).Seconds())
+	add(tableOneTimeTokens, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '%d seconds' limit %d for update skip locked);", tableOneTimeTokens, tableOneTimeTokens, oneTimeTokenRetentionSeconds)
+
+	// email send counters are deleted once they no longer limit anything
+	tableEmailSendCounters := EmailSendCounter{}.TableName()
+	emailSendRetentionSeconds := int(config.Mailer.SendLimit.Retention().Seconds())
+	add(tableEmailSendCounters, batchSize, "delete from %q where id in (select id from %q where last_sent_at < now() - interval '%d seconds' limit %d for update skip locked);", tableEmailSendCounters, tableEmailSendCounters, emailSendRetentionSeconds)
+
+	// failed logins are deleted once they are no longer counted and no
+	// longer lock anything
+	tableLoginAttempts := LoginAttempt{}.TableName()
+	loginAttemptRetentionSeconds := int(max(24*time.Hour, config.Security.LoginLockout.Duration).Seconds())
+	add(tableLoginAttempts, batchSize, "delete from %q where id in (select id from %q where last_failed_at < now() - interval '%d seconds' and (locked_until is null or locked_until < now()) limit %d for update skip locked);", tableLoginAttempts, tableLoginAttempts, loginAttemptRetentionSeconds)
+
+	// device codes are deleted a day after they expired
+	tableDeviceCodes := DeviceCode{}.TableName()
+	add(tableDeviceCodes, batchSize, "delete from %q where id in (select id from %q where expires_at < now() - interval '24 hours' limit %d for update skip locked);", tableDeviceCodes, tableDeviceCodes)
+
+	// revoked access tokens are deleted once they expired anyway
+	tableRevokedAccessTokens := RevokedAccessToken{}.TableName()
+	add(tableRevokedAccessTokens, batchSize, "delete from %q where id in (select id from %q where expires_at < now() limit %d for update skip locked);", tableRevokedAccessTokens, tableRevokedAccessTokens)
+
+	// consumed SAML assertions are deleted once they could no longer be
+	// replayed anyway
+	tableSAMLConsumedAssertions := SAMLConsumedAssertion{}.TableName()
+	add(tableSAMLConsumedAssertions, batchSize, "delete from %q where id in (select id from %q where expires_at < now() limit %d for update skip locked);", tableSAMLConsumedAssertions, tableSAMLConsumedAssertions)
 
 	if config.External.AnonymousUsers.Enabled {
 		// delete anonymous users older than 30 days
-		c.cleanupStatements = append(c.cleanupStatements,
-			fmt.Sprintf("delete from %q where id in (select id from %q where created_at < now() - interval '30 days' and is_anonymous is true limit 100 for update skip locked);", tableUsers, tableUsers),
-		)
+		add(tableUsers, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '30 days' and is_anonymous is true limit %d for update skip locked);", tableUsers, tableUsers)
+	}
+
+	if config.DB.CleanupUnconfirmedUsersAfter > 0 {
+		// delete users who signed up but never confirmed their email or
+		// phone, leaving invited, SSO and soft deleted users alone
+		unconfirmedSeconds := int(config.DB.CleanupUnconfirmedUsersAfter.Seconds())
+		add(tableUsers, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '%d seconds' and email_confirmed_at is null and phone_confirmed_at is null and invited_at is null and last_sign_in_at is null and is_anonymous is false and is_sso_user is false and deleted_at is null limit %d for update skip locked);", tableUsers, tableUsers, unconfirmedSeconds)
+	}
+
+	if config.DB.AuditLogRetention > 0 {
+		tableAuditLogEntries := AuditLogEntry{}.TableName()
+		retentionSeconds := int(config.DB.AuditLogRetention.Seconds())
+
+		add(tableAuditLogEntries, batchSize, "delete from %q where id in (select id from %q where created_at < now() - interval '%d seconds' limit %d for update skip locked);", tableAuditLogEntries, tableAuditLogEntries, retentionSeconds)
 	}
 
 	if config.Sessions.Timebox != nil {
 		timeboxSeconds := int((*config.Sessions.Timebox).Seconds())
 
-		c.cleanupStatements = append(c.cleanupStatements, fmt.Sprintf("delete from %q where id in (select id from %q where created_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked);", tableSessions, tableSessions, timeboxSeconds))
+		add(tableSessions, batchSize, "delete from %q where id in (select id from %q where created_at + interval '%d seconds' < now() - interval '24 hours' limit %d for update skip locked);", tableSessions, tableSessions, timeboxSeconds)
 	}
 
 	if config.Sessions.InactivityTimeout != nil {
 		inactivitySeconds := int((*config.Sessions.InactivityTimeout).Seconds())
 
 		// delete sessions with a refreshed_at column
-		c.cleanupStatements = append(c.cleanupStatements, fmt.Sprintf("delete from %q where id in (select id from %q where refreshed_at is not null and refreshed_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked);", tableSessions, tableSessions, inactivitySeconds))
+		add(tableSessions, batchSize, "delete from %q where id in (select id from %q where refreshed_at is not null and refreshed_at + interval '%d seconds' < now() - interval '24 hours' limit %d for update skip locked);", tableSessions, tableSessions, inactivitySeconds)
 
 		// delete sessions without a refreshed_at column by looking for
 		// unrevoked refresh_tokens
-		c.cleanupStatements = append(c.cleanupStatements, fmt.Sprintf("delete from %q where id in (select %q.id as id from %q, %q where %q.session_id = %q.id and %q.refreshed_at is null and %q.revoked is false and %q.updated_at + interval '%d seconds' < now() - interval '24 hours' limit 100 for update skip locked)", tableSessions, tableSessions, tableSessions, tableRefreshTokens, tableRefreshTokens, tableSessions, tableSessions, tableRefreshTokens, tableRefreshTokens, inactivitySeconds))
+		add(tableSessions, batchSize, "delete from %q where id in (select %q.id as id from %q, %q where %q.session_id = %q.id and %q.refreshed_at is null and %q.revoked is false and %q.updated_at + interval '%d seconds' < now() - interval '24 hours' limit %d for update skip locked)", tableSessions, tableSessions, tableSessions, tableRefreshTokens, tableRefreshTokens, tableSessions, tableSessions, tableRefreshTokens, tableRefreshTokens, inactivitySeconds)
 	}
 
 	meter := otel.Meter("gotrue")
@@ -85,7 +157,12 @@ func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
 		"gotrue_cleanup_affected_rows",
 		metric.WithDescription("Number of affected rows from cleaning up stale entities"),
 		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
-			o.Observe(c.cleanupAffectedRows.Load())
+			c.cleanupAffectedRowsMutex.Lock()
+			defer c.cleanupAffectedRowsMutex.Unlock()
+
+			for table, count := range c.cleanupAffectedRows {
+				o.Observe(count, metric.WithAttributes(attribute.String("table", table)))
+			}
 			return nil
 		}),
 	)
@@ -97,6 +174,17 @@ func NewCleanup(config *conf.GlobalConfiguration) *Cleanup {
 	return c
 }
 
+func (c *Cleanup) recordAffectedRows(table string, count int) {
+	if count == 0 {
+		return
+	}
+
+	c.cleanupAffectedRowsMutex.Lock()
+	defer c.cleanupAffectedRowsMutex.Unlock()
+
+	c.cleanupAffectedRows[table] += int64(count)
+}
+
 // Cleanup removes stale entities in the database. You can call it on each
 // request or as a periodic background job. It does quick lockless updates or
 // deletes, has an execution timeout and acquire timeout so that cleanups do
@@ -110,11 +198,11 @@ func (c *Cleanup) Clean(db *storage.Connection) (int, error) {
 	affectedRows := 0
 	defer span.SetAttributes(attribute.Int64("gotrue.cleanup.affected_rows", int64(affectedRows)))
 
-	if err := db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
-		nextIndex := atomic.AddUint32(&c.cleanupNext, 1) % uint32(len(c.cleanupStatements))
-		statement := c.cleanupStatements[nextIndex]
+	nextIndex := atomic.AddUint32(&c.cleanupNext, 1) % uint32(len(c.cleanupStatements))
+	statement := c.cleanupStatements[nextIndex]
 
-		count, terr := tx.RawQuery(statement).ExecWithCount()
+	if err := db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+		count, terr := tx.RawQuery(statement.query).ExecWithCount()
 		if terr != nil {
 			return terr
 		}
@@ -125,7 +213,46 @@ func (c *Cleanup) Clean(db *storage.Connection) (int, error) {
 	}); err != nil {
 		return affectedRows, err
 	}
-	c.cleanupAffectedRows.Add(int64(affectedRows))
+	c.recordAffectedRows(statement.table, affectedRows)
+
+	return affectedRows, nil
+}
+
+// CleanAll removes all stale entities in the database, such as for a
+// periodic background job or a cron job. Every statement is repeated until it
+// affects less rows than its batch size, and each batch is committed in its
+// own transaction so that no lock is held for long. It returns the number of
+// affected rows per table, also when an error or the end of the connection's
+// context stops it early.
+func (c *Cleanup) CleanAll(db *storage.Connection) (map[string]int, error) {
+	ctx, span := observability.Tracer("gotrue").Start(db.Context(), "database-cleanup-all")
+	defer span.End()
+
+	affectedRows := make(map[string]int)
+
+	for _, statement := range c.cleanupStatements {
+		for {
+			if err := ctx.Err(); err != nil {
+				return affectedRows, err
+			}
+
+			count := 0
+			if err := db.WithContext(ctx).Transaction(func(tx *storage.Connection) error {
+				var terr error
+				count, terr = tx.RawQuery(statement.query).ExecWithCount()
+				return terr
+			}); err != nil {
+				return affectedRows, err
+			}
+
+			affectedRows[statement.table] += count
+			c.recordAffectedRows(statement.table, count)
+
+			if count < statement.limit {
+				break
+			}
+		}
+	}
 
 	return affectedRows, nil
 }
@@ -1,12 +1,15 @@
 package models
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/stretchr/testify/require"
 
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/storage"
 	"github.com/supabase/auth/internal/storage/test"
 )
 
@@ -21,6 +24,7 @@ func TestCleanup(t *testing.T) {
 	globalConfig.Sessions.Timebox = &timebox
 	globalConfig.Sessions.InactivityTimeout = &inactivityTimeout
 	globalConfig.External.AnonymousUsers.Enabled = true
+	globalConfig.DB.AuditLogRetention = 90 * 24 * time.Hour
 
 	cleanup := NewCleanup(globalConfig)
 
@@ -29,3 +33,132 @@ func TestCleanup(t *testing.T) {
 		require.NoError(t, err)
 	}
 }
+
+// backdate moves the column of the row with the id into the past by age.
+func backdate(t *testing.T, conn *storage.Connection, table, column string, id interface{}, age time.Duration) {
+	require.NoError(t, conn.RawQuery(fmt.Sprintf("update %q set %s = now() - interval '%d seconds' where id = ?", table, column, int(age.Seconds())), id).Exec())
+}
+
+func TestCleanAll(t *testing.T) {
+	globalConfig, err := conf.LoadGlobal(modelsTestConfig)
+	require.NoError(t, err)
+	conn, err := test.SetupDBConnection(globalConfig)
+	require.NoError(t, err)
+	require.NoError(t, TruncateAll(conn))
+
+	globalConfig.DB.CleanupBatchSize = 2
+	globalConfig.DB.CleanupRevokedTokenRetention = 7 * 24 * time.Hour
+	globalConfig.DB.CleanupUnconfirmedUsersAfter = 30 * 24 * time.Hour
+
+	cleanup := NewCleanup(globalConfig)
+
+	newUser := func(email string) *User {
+		u, err := NewUser("", email, "secret", "test", nil)
+		require.NoError(t, err)
+		require.NoError(t, conn.Create(u))
+		return u
+	}
+
+	// unconfirmed users are deleted after 30 days, confirmed ones never
+	confirmed := newUser("confirmed@example.com")
+	require.NoError(t, confirmed.Confirm(conn))
+	backdate(t, conn, User{}.TableName(), "created_at", confirmed.ID, 60*24*time.Hour)
+	recent := newUser("recent@example.com")
+	backdate(t, conn, User{}.TableName(), "created_at", recent.ID, 24*time.Hour)
+	var stale []uuid.UUID
+	for i := 0; i < 3; i++ {
+		u := newUser(fmt.Sprintf("stale%d@example.com", i))
+		backdate(t, conn, User{}.TableName(), "created_at", u.ID, 60*24*time.Hour)
+		stale = append(stale, u.ID)
+	}
+
+	// revoked refresh tokens are deleted after 7 days, in batches of 2
+	var live, revoked []*RefreshToken
+	for i := 0; i < 5; i++ {
+		token, err := GrantAuthenticatedUser(conn, confirmed, GrantParams{})
+		require.NoError(t, err)
+		require.NoError(t, conn.RawQuery(fmt.Sprintf("update %q set revoked = true where id = ?", RefreshToken{}.TableName()), token.ID).Exec())
+		backdate(t, conn, RefreshToken{}.TableName(), "updated_at", token.ID, 8*24*time.Hour)
+		revoked = append(revoked, token)
+	}
+	for _, age := range []time.Duration{0, 30 * 24 * time.Hour} {
+		token, err := GrantAuthenticatedUser(conn, confirmed, GrantParams{})
+		require.NoError(t, err)
+		backdate(t, conn, RefreshToken{}.TableName(), "updated_at", token.ID, age)
+		live = append(live, token)
+	}
+	recentlyRevoked, err := GrantAuthenticatedUser(conn, confirmed, GrantParams{})
+	require.NoError(t, err)
+	require.NoError(t, conn.RawQuery(fmt.Sprintf("update %q set revoked = true where id = ?", RefreshToken{}.TableName()), recentlyRevoked.ID).Exec())
+	live = append(live, recentlyRevoked)
+
+	// flow states are deleted after a day
+	expiredFlowState := NewFlowState("github", "codechallenge", SHA256, OAuth, nil)
+	require.NoError(t, conn.Create(expiredFlowState))
+	backdate(t, conn, FlowState{}.TableName(), "created_at", expiredFlowState.ID, 25*time.Hour)
+	liveFlowState := NewFlowState("github", "codechallenge", SHA256, OAuth, nil)
+	require.NoError(t, conn.Create(liveFlowState))
+
+	// one time tokens are deleted a day after they expired
+	require.NoError(t, CreateOneTimeToken(conn, confirmed.ID, confirmed.GetEmail(), "expired", RecoveryToken))
+	expiredToken, err := FindOneTimeToken(conn, "expired", RecoveryToken)
+	require.NoError(t, err)
+	backdate(t, conn, OneTimeToken{}.TableName(), "created_at", expiredToken.ID, time.Duration(globalConfig.Mailer.OtpExp)*time.Second+25*time.Hour)
+	require.NoError(t, CreateOneTimeToken(conn, confirmed.ID, confirmed.GetEmail(), "live", ConfirmationToken))
+
+	// unverified factors are deleted once they expired
+	expiredFactor := NewFactor(confirmed, "expired", TOTP, FactorStateUnverified)
+	require.NoError(t, conn.Create(expiredFactor))
+	backdate(t, conn, Factor{}.TableName(), "created_at", expiredFactor.ID, 25*time.Hour)
+	verifiedFactor := NewFactor(confirmed, "verified", TOTP, FactorStateVerified)
+	require.NoError(t, conn.Create(verifiedFactor))
+	backdate(t, conn, Factor{}.TableName(), "created_at", verifiedFactor.ID, 25*time.Hour)
+
+	affectedRows, err := cleanup.CleanAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, 5, affectedRows[RefreshToken{}.TableName()])
+	require.Equal(t, 1, affectedRows[FlowState{}.TableName()])
+	require.Equal(t, 1, affectedRows[OneTimeToken{}.TableName()])
+	require.Equal(t, 1, affectedRows[Factor{}.TableName()])
+	require.Equal(t, 3, affectedRows[User{}.TableName()])
+
+	for _, token := range revoked {
+		_, err := FindTokenBySessionID(conn, token.SessionId)
+		require.Error(t, err)
+	}
+	for _, token := range live {
+		_, err := FindTokenBySessionID(conn, token.SessionId)
+		require.NoError(t, err)
+	}
+
+	for _, id := range stale {
+		_, err := FindUserByID(conn, id)
+		require.True(t, IsNotFoundError(err))
+	}
+	for _, id := range []uuid.UUID{confirmed.ID, recent.ID} {
+		_, err := FindUserByID(conn, id)
+		require.NoError(t, err)
+	}
+
+	_, err = FindFlowStateByID(conn, expiredFlowState.ID.String())
+	require.True(t, IsNotFoundError(err))
+	_, err = FindFlowStateByID(conn, liveFlowState.ID.String())
+	require.NoError(t, err)
+
+	_, err = FindOneTimeToken(conn, "expired", RecoveryToken)
+	require.True(t, IsNotFoundError(err))
+	_, err = FindOneTimeToken(conn, "live", ConfirmationToken)
+	require.NoError(t, err)
+
+	_, err = FindFactorByFactorID(conn, expiredFactor.ID)
+	require.True(t, IsNotFoundError(err))
+	_, err = FindFactorByFactorID(conn, verifiedFactor.ID)
+	require.NoError(t, err)
+
+	// a second run has nothing left to do
+	affectedRows, err = cleanup.CleanAll(conn)
+	require.NoError(t, err)
+	for table, count := range affectedRows {
+		require.Zero(t, count, table)
+	}
+}
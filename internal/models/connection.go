@@ -47,8 +47,22 @@ func TruncateAll(conn *storage.Connection) error {
 			(&pop.Model{Value: SSODomain{}}).TableName(),
 			(&pop.Model{Value: SAMLProvider{}}).TableName(),
 			(&pop.Model{Value: SAMLRelayState{}}).TableName(),
+			(&pop.Model{Value: SAMLConsumedAssertion{}}).TableName(),
 			(&pop.Model{Value: FlowState{}}).TableName(),
 			(&pop.Model{Value: OneTimeToken{}}).TableName(),
+			(&pop.Model{Value: UserSoftDeletion{}}).TableName(),
+			(&pop.Model{Value: RecoveryCode{}}).TableName(),
+			(&pop.Model{Value: EmailSendCounter{}}).TableName(),
+			(&pop.Model{Value: LoginAttempt{}}).TableName(),
+			(&pop.Model{Value: InstanceConfig{}}).TableName(),
+			(&pop.Model{Value: DeviceCode{}}).TableName(),
+			(&pop.Model{Value: FeatureFlagSettings{}}).TableName(),
+			(&pop.Model{Value: SCIMGroupMember{}}).TableName(),
+			(&pop.Model{Value: SCIMGroup{}}).TableName(),
+			(&pop.Model{Value: JWTKey{}}).TableName(),
+			(&pop.Model{Value: RevokedAccessToken{}}).TableName(),
+			(&pop.Model{Value: OAuthClient{}}).TableName(),
+			(&pop.Model{Value: WebAuthnChallenge{}}).TableName(),
 		}
 
 		for _, tableName := range tables {
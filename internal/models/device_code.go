@@ -0,0 +1,172 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// userCodeAlphabet leaves out vowels and digits, so user codes can't be
+// misread (0 and O, 1 and I) and don't spell words.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength is the number of characters of a user code, shown split in
+// two halves as in WDJB-MJHT.
+const userCodeLength = 8
+
+// DeviceCode is a pending authorization of the OAuth device flow. The client
+// polls for tokens with the device code, which is only stored hashed, while
+// the user approves the short user code in a browser.
+type DeviceCode struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	DeviceCodeHash string     `json:"-" db:"device_code_hash"`
+	UserCode       string     `json:"user_code" db:"user_code"`
+	UserID         *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+
+	// PollInterval is the minimum number of seconds between two polls.
+	PollInterval int        `json:"poll_interval" db:"poll_interval"`
+	LastPolledAt *time.Time `json:"last_polled_at,omitempty" db:"last_polled_at"`
+
+	ApprovedAt *time.Time `json:"approved_at,omitempty" db:"approved_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+func (DeviceCode) TableName() string {
+	return "device_codes"
+}
+
+// NewDeviceCode creates an authorization for the device code and user code.
+func NewDeviceCode(deviceCode, userCode string, pollInterval time.Duration, expiresAt time.Time) (*DeviceCode, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating unique id")
+	}
+
+	return &DeviceCode{
+		ID:             id,
+		DeviceCodeHash: HashDeviceCode(deviceCode),
+		UserCode:       userCode,
+		PollInterval:   int(pollInterval.Seconds()),
+		ExpiresAt:      expiresAt,
+	}, nil
+}
+
+// HashDeviceCode returns the hash the device code is stored as.
+func HashDeviceCode(deviceCode string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(deviceCode)))
+}
+
+// GenerateUserCode returns a random user code, such as WDJB-MJHT.
+func GenerateUserCode() (string, error) {
+	var b strings.Builder
+	alphabetSize := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", errors.Wrap(err, "error generating user code")
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+
+	return b.String(), nil
+}
+
+// NormalizeUserCode converts a user code as typed by a user, in any case and
+// with or without separators, into the stored form. It returns an empty
+// string for codes that can't be valid.
+func NormalizeUserCode(userCode string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(userCode) {
+		switch {
+		case c == '-' || c == ' ':
+			continue
+		case !strings.ContainsRune(userCodeAlphabet, c):
+			return ""
+		}
+		b.WriteRune(c)
+	}
+
+	code := b.String()
+	if len(code) != userCodeLength {
+		return ""
+	}
+
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// IsExpired reports whether the authorization can no longer be approved or
+// exchanged at now.
+func (d *DeviceCode) IsExpired(now time.Time) bool {
+	return !now.Before(d.ExpiresAt)
+}
+
+// IsApproved reports whether a user has approved the authorization.
+func (d *DeviceCode) IsApproved() bool {
+	return d.UserID != nil && d.ApprovedAt != nil
+}
+
+// Approve associates the authorization with the user.
+func (d *DeviceCode) Approve(tx *storage.Connection, user *User, now time.Time) error {
+	d.UserID = &user.ID
+	d.ApprovedAt = &now
+	return tx.UpdateOnly(d, "user_id", "approved_at")
+}
+
+// RecordPoll records a poll at now. Polls that come sooner than the poll
+// interval after the previous one lengthen the interval by five seconds, as
+// clients are required to do when told to slow down. It reports whether the
+// poll was too early.
+func (d *DeviceCode) RecordPoll(tx *storage.Connection, now time.Time) (bool, error) {
+	tooEarly := d.LastPolledAt != nil && now.Before(d.LastPolledAt.Add(time.Duration(d.PollInterval)*time.Second))
+	if tooEarly {
+		d.PollInterval += 5
+	}
+	d.LastPolledAt = &now
+
+	return tooEarly, tx.UpdateOnly(d, "poll_interval", "last_polled_at")
+}
+
+// FindDeviceCodeForUpdate finds and locks the authorization of a device
+// code, so that concurrent polls can't exchange it twice.
+func FindDeviceCodeForUpdate(tx *storage.Connection, deviceCode string) (*DeviceCode, error) {
+	tableName := (&pop.Model{Value: DeviceCode{}}).TableName()
+
+	d := &DeviceCode{}
+	if err := tx.RawQuery("select * from "+tableName+" where device_code_hash = ? limit 1 for update", HashDeviceCode(deviceCode)).First(d); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, DeviceCodeNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding device code")
+	}
+
+	return d, nil
+}
+
+// FindDeviceCodeByUserCode finds the authorization of a normalized user
+// code.
+func FindDeviceCodeByUserCode(tx *storage.Connection, userCode string) (*DeviceCode, error) {
+	d := &DeviceCode{}
+	if err := tx.Q().Where("user_code = ?", userCode).First(d); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, DeviceCodeNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding device code")
+	}
+
+	return d, nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateUserCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		userCode, err := GenerateUserCode()
+		require.NoError(t, err)
+		require.Len(t, userCode, userCodeLength+1)
+		require.Equal(t, userCode, NormalizeUserCode(userCode))
+		require.False(t, strings.ContainsAny(userCode, "0O1I"), userCode)
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	cases := []struct {
+		userCode string
+		expected string
+	}{
+		{userCode: "WDJB-MJHT", expected: "WDJB-MJHT"},
+		{userCode: "wdjbmjht", expected: "WDJB-MJHT"},
+		{userCode: " wdjb mjht ", expected: "WDJB-MJHT"},
+		{userCode: "WDJB-MJH", expected: ""},
+		{userCode: "WDJB-MJHTX", expected: ""},
+		{userCode: "WDJB-MJH0", expected: ""},
+		{userCode: "WDJB-MJHO", expected: ""},
+		{userCode: "", expected: ""},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.expected, NormalizeUserCode(c.userCode), c.userCode)
+	}
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// EmailSendCounter counts the emails of one type sent to an address within
+// the current hour. Counters are stored in the database so that limits hold
+// across restarts and instances.
+type EmailSendCounter struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	Email     string    `json:"email" db:"email"`
+	EmailType string    `json:"email_type" db:"email_type"`
+
+	WindowStart time.Time `json:"window_start" db:"window_start"`
+	SendCount   int       `json:"send_count" db:"send_count"`
+	LastSentAt  time.Time `json:"last_sent_at" db:"last_sent_at"`
+}
+
+func (EmailSendCounter) TableName() string {
+	return "email_send_counters"
+}
+
+// ReserveEmailSend counts an email of emailType to the address if sending it
+// stays within maxPerHour emails per hour and minInterval between emails. A
+// limit of zero is not enforced. It returns false without counting the email
+// if a limit has been reached.
+//
+// The check and the increment happen in a single statement, so concurrent
+// requests on different instances can't exceed the limits.
+func ReserveEmailSend(tx *storage.Connection, email, emailType string, maxPerHour int, minInterval time.Duration) (bool, error) {
+	tableName := (&pop.Model{Value: EmailSendCounter{}}).TableName()
+
+	count, err := tx.RawQuery(
+		"insert into "+tableName+" as c (id, email, email_type, window_start, send_count, last_sent_at) values (?, ?, ?, now(), 1, now()) "+
+			"on conflict (email, email_type) do update set "+
+			"window_start = case when c.window_start <= now() - interval '1 hour' then now() else c.window_start end, "+
+			"send_count = case when c.window_start <= now() - interval '1 hour' then 1 else c.send_count + 1 end, "+
+			"last_sent_at = now() "+
+			"where (?::integer = 0 or c.window_start <= now() - interval '1 hour' or c.send_count < ?::integer) "+
+			"and c.last_sent_at <= now() - make_interval(secs => ?::double precision)",
+		uuid.Must(uuid.NewV4()),
+		strings.ToLower(email),
+		emailType,
+		maxPerHour,
+		maxPerHour,
+		minInterval.Seconds(),
+	).ExecWithCount()
+	if err != nil {
+		return false, errors.Wrap(err, "error counting email send")
+	}
+
+	return count > 0, nil
+}
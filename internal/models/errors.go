@@ -27,6 +27,16 @@ func IsNotFoundError(err error) bool {
 		return true
 	case OneTimeTokenNotFoundError, *OneTimeTokenNotFoundError:
 		return true
+	case InstanceConfigNotFoundError, *InstanceConfigNotFoundError:
+		return true
+	case DeviceCodeNotFoundError, *DeviceCodeNotFoundError:
+		return true
+	case SCIMGroupNotFoundError, *SCIMGroupNotFoundError:
+		return true
+	case JWTKeyNotFoundError, *JWTKeyNotFoundError:
+		return true
+	case OAuthClientNotFoundError, *OAuthClientNotFoundError:
+		return true
 	}
 	return false
 }
@@ -123,3 +133,47 @@ type UserEmailUniqueConflictError struct{}
 func (e UserEmailUniqueConflictError) Error() string {
 	return "User email unique constraint violated"
 }
+
+// InstanceConfigNotFoundError represents when an instance has no
+// configuration overrides.
+type InstanceConfigNotFoundError struct{}
+
+func (e InstanceConfigNotFoundError) Error() string {
+	return "Instance config not found"
+}
+
+// DeviceCodeNotFoundError represents when a device or user code is not found.
+type DeviceCodeNotFoundError struct{}
+
+func (e DeviceCodeNotFoundError) Error() string {
+	return "Device code not found"
+}
+
+// SCIMGroupNotFoundError represents when a SCIM group is not found.
+type SCIMGroupNotFoundError struct{}
+
+func (e SCIMGroupNotFoundError) Error() string {
+	return "SCIM group not found"
+}
+
+// JWTKeyNotFoundError represents when a JWT signing key is not found.
+type JWTKeyNotFoundError struct{}
+
+func (e JWTKeyNotFoundError) Error() string {
+	return "JWT key not found"
+}
+
+// OAuthClientNotFoundError represents when an OAuth client is not found.
+type OAuthClientNotFoundError struct{}
+
+func (e OAuthClientNotFoundError) Error() string {
+	return "OAuth client not found"
+}
+
+// SAMLAssertionReplayedError represents when a SAML assertion has already
+// been used to sign in.
+type SAMLAssertionReplayedError struct{}
+
+func (e SAMLAssertionReplayedError) Error() string {
+	return "SAML assertion has already been used"
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -30,7 +32,11 @@ func (factorState FactorState) String() string {
 	return ""
 }
 
-const TOTP = "totp"
+const (
+	TOTP     = "totp"
+	WebAuthn = "webauthn"
+	Phone    = "phone"
+)
 
 type AuthenticationMethod int
 
@@ -47,6 +53,10 @@ const (
 	EmailChange
 	TokenRefresh
 	Anonymous
+	RecoveryCodeSignIn
+	DeviceCodeGrant
+	WebAuthnSignIn
+	PhoneSignIn
 )
 
 func (authMethod AuthenticationMethod) String() string {
@@ -75,6 +85,14 @@ func (authMethod AuthenticationMethod) String() string {
 		return "token_refresh"
 	case Anonymous:
 		return "anonymous"
+	case RecoveryCodeSignIn:
+		return "recovery_code"
+	case DeviceCodeGrant:
+		return "device_code"
+	case WebAuthnSignIn:
+		return "webauthn"
+	case PhoneSignIn:
+		return "phone"
 	}
 	return ""
 }
@@ -106,6 +124,14 @@ func ParseAuthenticationMethod(authMethod string) (AuthenticationMethod, error)
 		return EmailChange, nil
 	case "token_refresh":
 		return TokenRefresh, nil
+	case "recovery_code":
+		return RecoveryCodeSignIn, nil
+	case "device_code":
+		return DeviceCodeGrant, nil
+	case "webauthn":
+		return WebAuthnSignIn, nil
+	case "phone":
+		return PhoneSignIn, nil
 	}
 	return 0, fmt.Errorf("unsupported authentication method %q", authMethod)
 }
@@ -121,6 +147,43 @@ type Factor struct {
 	Secret       string      `json:"-" db:"secret"`
 	FactorType   string      `json:"factor_type" db:"factor_type"`
 	Challenge    []Challenge `json:"-" has_many:"challenges"`
+
+	// WebAuthnCredential is the credential of a verified WebAuthn factor,
+	// found by its ID when the user signs in with it.
+	WebAuthnCredential   *WebAuthnCredential `json:"-" db:"web_authn_credential"`
+	WebAuthnCredentialID *string             `json:"-" db:"web_authn_credential_id"`
+
+	// Phone is the phone number the codes of a phone factor are sent to.
+	Phone storage.NullString `json:"phone,omitempty" db:"phone"`
+}
+
+// WebAuthnCredential is a public key credential, such as a passkey, with
+// its ID and public key base64url encoded.
+type WebAuthnCredential struct {
+	ID         string   `json:"id"`
+	PublicKey  string   `json:"public_key"`
+	SignCount  uint32   `json:"sign_count"`
+	AAGUID     string   `json:"aaguid,omitempty"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+func (c WebAuthnCredential) Value() (driver.Value, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (c *WebAuthnCredential) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case []byte:
+		return json.Unmarshal(v, c)
+	default:
+		return errors.New("invalid data type for WebAuthnCredential")
+	}
 }
 
 func (Factor) TableName() string {
@@ -179,6 +242,40 @@ func FindFactorByFactorID(conn *storage.Connection, factorID uuid.UUID) (*Factor
 	return &factor, nil
 }
 
+// FindFactorByWebAuthnCredentialID finds the verified WebAuthn factor of
+// the credential.
+func FindFactorByWebAuthnCredentialID(conn *storage.Connection, credentialID string) (*Factor, error) {
+	var factor Factor
+	if err := conn.Q().Where("web_authn_credential_id = ? and status = ?", credentialID, FactorStateVerified.String()).First(&factor); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, FactorNotFoundError{}
+		}
+		return nil, err
+	}
+	return &factor, nil
+}
+
+// NewPhoneFactor creates a phone factor with the phone number.
+func NewPhoneFactor(user *User, friendlyName string, phone string) *Factor {
+	factor := NewFactor(user, friendlyName, Phone, FactorStateUnverified)
+	factor.Phone = storage.NullString(phone)
+	return factor
+}
+
+// SetWebAuthnCredential stores the credential of a WebAuthn factor.
+func (f *Factor) SetWebAuthnCredential(tx *storage.Connection, credential *WebAuthnCredential) error {
+	f.WebAuthnCredential = credential
+	f.WebAuthnCredentialID = &credential.ID
+	return tx.UpdateOnly(f, "web_authn_credential", "web_authn_credential_id", "updated_at")
+}
+
+// UpdateWebAuthnSignCount records the signature counter of the last use of
+// the credential.
+func (f *Factor) UpdateWebAuthnSignCount(tx *storage.Connection, signCount uint32) error {
+	f.WebAuthnCredential.SignCount = signCount
+	return tx.UpdateOnly(f, "web_authn_credential", "updated_at")
+}
+
 func DeleteUnverifiedFactors(tx *storage.Connection, user *User) error {
 	if err := tx.RawQuery("DELETE FROM "+(&pop.Model{Value: Factor{}}).TableName()+" WHERE user_id = ? and status = ?", user.ID, FactorStateUnverified.String()).Exec(); err != nil {
 		return err
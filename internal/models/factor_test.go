@@ -2,6 +2,9 @@ package models
 
 import (
 	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/gofrs/uuid"
@@ -72,3 +75,63 @@ func (ts *FactorTestSuite) TestEncodedFactorDoesNotLeakSecret() {
 	json.Unmarshal(encodedFactor, &decodedFactor)
 	require.Equal(ts.T(), decodedFactor.Secret, "")
 }
+
+func (ts *FactorTestSuite) TestRecoveryCodes() {
+	userID := ts.TestFactor.UserID
+
+	codes, err := GenerateRecoveryCodes(ts.db, userID)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), codes, RecoveryCodeCount)
+
+	remaining, err := CountRemainingRecoveryCodes(ts.db, userID)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), RecoveryCodeCount, remaining)
+
+	// codes are accepted regardless of case and dashes, but only once
+	ok, err := ConsumeRecoveryCode(ts.db, userID, strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")))
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), ok)
+
+	ok, err = ConsumeRecoveryCode(ts.db, userID, codes[0])
+	require.NoError(ts.T(), err)
+	require.False(ts.T(), ok)
+
+	remaining, err = CountRemainingRecoveryCodes(ts.db, userID)
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), RecoveryCodeCount-1, remaining)
+
+	// regenerating invalidates the previous codes
+	_, err = GenerateRecoveryCodes(ts.db, userID)
+	require.NoError(ts.T(), err)
+
+	ok, err = ConsumeRecoveryCode(ts.db, userID, codes[1])
+	require.NoError(ts.T(), err)
+	require.False(ts.T(), ok)
+}
+
+func (ts *FactorTestSuite) TestRecoveryCodeConcurrentUse() {
+	userID := ts.TestFactor.UserID
+
+	codes, err := GenerateRecoveryCodes(ts.db, userID)
+	require.NoError(ts.T(), err)
+
+	var wg sync.WaitGroup
+	var successes atomic.Int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := ts.db.Transaction(func(tx *storage.Connection) error {
+				ok, terr := ConsumeRecoveryCode(tx, userID, codes[0])
+				if ok {
+					successes.Add(1)
+				}
+				return terr
+			})
+			require.NoError(ts.T(), err)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(ts.T(), int32(1), successes.Load())
+}
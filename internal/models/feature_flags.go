@@ -0,0 +1,89 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// featureFlagSettingsID is the ID of the only row of the feature_flags
+// table.
+const featureFlagSettingsID = 1
+
+// FeatureFlagSettings holds the feature flags set through the admin API,
+// which replace the configured defaults.
+type FeatureFlagSettings struct {
+	ID        int          `json:"-" db:"id"`
+	Flags     FeatureFlags `json:"flags" db:"flags"`
+	UpdatedAt time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+func (FeatureFlagSettings) TableName() string {
+	return "feature_flags"
+}
+
+// FeatureFlags stores conf.FeatureFlags as JSON.
+type FeatureFlags conf.FeatureFlags
+
+func (f *FeatureFlags) Scan(src interface{}) error {
+	var source []byte
+	switch v := src.(type) {
+	case string:
+		source = []byte(v)
+	case []byte:
+		source = v
+	case nil:
+		source = []byte("{}")
+	default:
+		return errors.New("invalid data type for FeatureFlags")
+	}
+
+	return json.Unmarshal(source, f)
+}
+
+func (f FeatureFlags) Value() (driver.Value, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// FindFeatureFlags returns the feature flags set through the admin API, or
+// nil if they were never set.
+func FindFeatureFlags(tx *storage.Connection) (*FeatureFlagSettings, error) {
+	settings := &FeatureFlagSettings{}
+	if err := tx.Q().Where("id = ?", featureFlagSettingsID).First(settings); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "error finding feature flags")
+	}
+
+	return settings, nil
+}
+
+// SaveFeatureFlags replaces the feature flags.
+func SaveFeatureFlags(tx *storage.Connection, flags conf.FeatureFlags) (*FeatureFlagSettings, error) {
+	tableName := (&pop.Model{Value: FeatureFlagSettings{}}).TableName()
+
+	settings := &FeatureFlagSettings{}
+	if err := tx.RawQuery(
+		"insert into "+tableName+" (id, flags, updated_at) values (?, ?, now()) "+
+			"on conflict (id) do update set flags = excluded.flags, updated_at = excluded.updated_at "+
+			"returning *",
+		featureFlagSettingsID,
+		FeatureFlags(flags),
+	).First(settings); err != nil {
+		return nil, errors.Wrap(err, "error saving feature flags")
+	}
+
+	return settings, nil
+}
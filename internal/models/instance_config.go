@@ -0,0 +1,191 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// InstanceConfig holds the configuration overrides of the instance whose
+// requests have the audience Aud. The external provider secrets are kept
+// apart from the other overrides and are always encrypted.
+type InstanceConfig struct {
+	ID  uuid.UUID `json:"id" db:"id"`
+	Aud string    `json:"aud" db:"aud"`
+
+	Overrides        InstanceOverrides `json:"overrides" db:"overrides"`
+	EncryptedSecrets *string           `json:"-" db:"encrypted_secrets"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (InstanceConfig) TableName() string {
+	return "instance_configs"
+}
+
+// InstanceOverrides stores conf.InstanceOverrides as JSON.
+type InstanceOverrides conf.InstanceOverrides
+
+func (o *InstanceOverrides) Scan(src interface{}) error {
+	var source []byte
+	switch v := src.(type) {
+	case string:
+		source = []byte(v)
+	case []byte:
+		source = v
+	case nil:
+		source = []byte("{}")
+	default:
+		return errors.New("invalid data type for InstanceOverrides")
+	}
+
+	return json.Unmarshal(source, o)
+}
+
+func (o InstanceOverrides) Value() (driver.Value, error) {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// NewInstanceConfig creates the configuration of an instance without
+// overrides.
+func NewInstanceConfig(aud string) (*InstanceConfig, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating unique id")
+	}
+
+	return &InstanceConfig{
+		ID:  id,
+		Aud: aud,
+	}, nil
+}
+
+// SetOverrides replaces the overrides. The external provider secrets are
+// encrypted with the configured encryption key, so they can't be set unless
+// database encryption is enabled.
+func (c *InstanceConfig) SetOverrides(overrides conf.InstanceOverrides, encryption conf.DatabaseEncryptionConfiguration) error {
+	secrets := overrides.Secrets()
+
+	stored := InstanceOverrides(overrides)
+	stored.External = make(map[string]*conf.OAuthProviderOverrides, len(overrides.External))
+	for name, provider := range overrides.External {
+		if provider == nil {
+			continue
+		}
+		withoutSecret := *provider
+		withoutSecret.Secret = ""
+		stored.External[name] = &withoutSecret
+	}
+	if len(stored.External) == 0 {
+		stored.External = nil
+	}
+
+	c.Overrides = stored
+	c.EncryptedSecrets = nil
+
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	if !encryption.Encrypt {
+		return errors.New("database encryption must be enabled to store external provider secrets")
+	}
+
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	es, err := crypto.NewEncryptedString(c.ID.String(), data, encryption.EncryptionKeyID, encryption.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	encrypted := es.String()
+	c.EncryptedSecrets = &encrypted
+
+	return nil
+}
+
+// GetOverrides returns the overrides with the decrypted external provider
+// secrets.
+func (c *InstanceConfig) GetOverrides(decryptionKeys map[string]string) (*conf.InstanceOverrides, error) {
+	overrides := conf.InstanceOverrides(c.Overrides)
+
+	external := make(map[string]*conf.OAuthProviderOverrides, len(overrides.External))
+	for name, provider := range overrides.External {
+		if provider == nil {
+			continue
+		}
+		copied := *provider
+		external[name] = &copied
+	}
+
+	if c.EncryptedSecrets != nil {
+		es := crypto.ParseEncryptedString(*c.EncryptedSecrets)
+		if es == nil {
+			return nil, errors.New("instance config secrets are not encrypted")
+		}
+
+		data, err := es.Decrypt(c.ID.String(), decryptionKeys)
+		if err != nil {
+			return nil, errors.Wrap(err, "error decrypting instance config secrets")
+		}
+
+		var secrets map[string]string
+		if err := json.Unmarshal(data, &secrets); err != nil {
+			return nil, err
+		}
+
+		for name, secret := range secrets {
+			if external[name] == nil {
+				external[name] = &conf.OAuthProviderOverrides{}
+			}
+			external[name].Secret = secret
+		}
+	}
+
+	overrides.External = nil
+	if len(external) > 0 {
+		overrides.External = external
+	}
+
+	return &overrides, nil
+}
+
+// FindInstanceConfigByAud finds the configuration of the instance with the
+// audience aud.
+func FindInstanceConfigByAud(tx *storage.Connection, aud string) (*InstanceConfig, error) {
+	config := &InstanceConfig{}
+	if err := tx.Q().Where("aud = ?", aud).First(config); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, InstanceConfigNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding instance config")
+	}
+
+	return config, nil
+}
+
+// FindInstanceConfigs returns the configurations of all instances.
+func FindInstanceConfigs(tx *storage.Connection) ([]*InstanceConfig, error) {
+	configs := []*InstanceConfig{}
+	if err := tx.Q().All(&configs); err != nil {
+		return nil, errors.Wrap(err, "error finding instance configs")
+	}
+
+	return configs, nil
+}
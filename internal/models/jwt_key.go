@@ -0,0 +1,130 @@
+package models
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// JWTKey is a key signing the access tokens which was generated through the
+// admin API. Keys only verify the access tokens until they are promoted, the
+// most recently promoted key signs them. The private key is always
+// encrypted.
+type JWTKey struct {
+	ID         uuid.UUID  `json:"-" db:"id"`
+	KeyID      string     `json:"kid" db:"key_id"`
+	Algorithm  string     `json:"alg" db:"algorithm"`
+	PrivateKey string     `json:"-" db:"private_key"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	PromotedAt *time.Time `json:"promoted_at,omitempty" db:"promoted_at"`
+}
+
+func (JWTKey) TableName() string {
+	return "jwt_keys"
+}
+
+// NewJWTKey generates a key signing with the algorithm, whose private key is
+// encrypted with the configured encryption key.
+func NewJWTKey(algorithm string, encryption conf.DatabaseEncryptionConfiguration) (*JWTKey, error) {
+	if !encryption.Encrypt {
+		return nil, errors.New("database encryption must be enabled to store JWT signing keys")
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating unique id")
+	}
+
+	key, err := conf.GenerateJWTKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	es, err := crypto.NewEncryptedString(id.String(), der, encryption.EncryptionKeyID, encryption.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTKey{
+		ID:         id,
+		KeyID:      key.KeyID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: es.String(),
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// Key returns the decrypted key.
+func (k *JWTKey) Key(decryptionKeys map[string]string) (*conf.JWTKey, error) {
+	es := crypto.ParseEncryptedString(k.PrivateKey)
+	if es == nil {
+		return nil, errors.New("JWT signing key is not encrypted")
+	}
+
+	der, err := es.Decrypt(k.ID.String(), decryptionKeys)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting JWT signing key")
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing JWT signing key")
+	}
+
+	return conf.NewJWTKey(privateKey)
+}
+
+// Promote makes the key sign the access tokens.
+func (k *JWTKey) Promote(tx *storage.Connection, now time.Time) error {
+	k.PromotedAt = &now
+	return tx.UpdateOnly(k, "promoted_at")
+}
+
+// SigningJWTKey returns the key signing the access tokens among keys, the
+// most recently promoted one, or nil when none was promoted.
+func SigningJWTKey(keys []*JWTKey) *JWTKey {
+	var signing *JWTKey
+	for _, key := range keys {
+		if key.PromotedAt != nil && (signing == nil || key.PromotedAt.After(*signing.PromotedAt)) {
+			signing = key
+		}
+	}
+
+	return signing
+}
+
+// FindJWTKeys returns the keys generated through the admin API, the newest
+// first.
+func FindJWTKeys(tx *storage.Connection) ([]*JWTKey, error) {
+	keys := []*JWTKey{}
+	if err := tx.Q().Order("created_at desc").All(&keys); err != nil {
+		return nil, errors.Wrap(err, "error finding JWT keys")
+	}
+
+	return keys, nil
+}
+
+// FindJWTKeyByKeyID finds the key generated through the admin API with the
+// key ID.
+func FindJWTKeyByKeyID(tx *storage.Connection, keyID string) (*JWTKey, error) {
+	key := &JWTKey{}
+	if err := tx.Q().Where("key_id = ?", keyID).First(key); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, JWTKeyNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding JWT key")
+	}
+
+	return key, nil
+}
@@ -92,12 +92,14 @@ func DetermineAccountLinking(tx *storage.Connection, config *conf.GlobalConfigur
 	candidateLinkingDomain := GetAccountLinkingDomain(providerName)
 	if len(verifiedEmails) == 0 {
 		// if there are no verified emails, we always decide to create a new account
-		user, terr := IsDuplicatedEmail(tx, candidateEmail.Email, aud, nil)
-		if terr != nil {
-			return AccountLinkingResult{}, terr
-		}
-		if user != nil {
-			candidateEmail.Email = ""
+		if candidateEmail.Email != "" {
+			user, terr := IsDuplicatedEmail(tx, candidateEmail.Email, aud, nil)
+			if terr != nil {
+				return AccountLinkingResult{}, terr
+			}
+			if user != nil {
+				candidateEmail.Email = ""
+			}
 		}
 		return AccountLinkingResult{
 			Decision:       CreateAccount,
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// LoginAttempt counts the failed password logins for a key, which is an
+// email address, phone number or client IP address. The keys don't refer to
+// users, so that nonexistent accounts are counted and locked the same way
+// as existing ones.
+type LoginAttempt struct {
+	ID  uuid.UUID `json:"id" db:"id"`
+	Key string    `json:"key" db:"key"`
+
+	FailedCount  int        `json:"failed_count" db:"failed_count"`
+	LastFailedAt time.Time  `json:"last_failed_at" db:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
+
+// EmailLoginAttemptKey is the key counting failed logins with an email
+// address.
+func EmailLoginAttemptKey(aud, email string) string {
+	return "email:" + aud + ":" + strings.ToLower(email)
+}
+
+// PhoneLoginAttemptKey is the key counting failed logins with a phone
+// number.
+func PhoneLoginAttemptKey(aud, phone string) string {
+	return "phone:" + aud + ":" + phone
+}
+
+// IPLoginAttemptKey is the key counting failed logins from a client IP
+// address.
+func IPLoginAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// OtpAttemptKey is the key counting the wrong OTPs entered for the OTPs sent
+// to a user through channel, "email" or "phone".
+func OtpAttemptKey(userID uuid.UUID, channel string) string {
+	return "otp:" + channel + ":" + userID.String()
+}
+
+// RecordFailedLogin counts a failed login for key and returns the updated
+// count. Failures are counted from scratch once the last one is older than
+// window.
+func RecordFailedLogin(tx *storage.Connection, key string, window time.Duration) (*LoginAttempt, error) {
+	tableName := (&pop.Model{Value: LoginAttempt{}}).TableName()
+
+	attempt := &LoginAttempt{}
+	if err := tx.RawQuery(
+		"insert into "+tableName+" as a (id, key, failed_count, last_failed_at) values (?, ?, 1, now()) "+
+			"on conflict (key) do update set "+
+			"failed_count = case when a.last_failed_at <= now() - make_interval(secs => ?::double precision) then 1 else a.failed_count + 1 end, "+
+			"last_failed_at = now() "+
+			"returning *",
+		uuid.Must(uuid.NewV4()),
+		key,
+		window.Seconds(),
+	).First(attempt); err != nil {
+		return nil, errors.Wrap(err, "error counting failed login")
+	}
+
+	return attempt, nil
+}
+
+// Lock refuses logins for the attempt's key for duration and starts counting
+// failures from scratch.
+func (a *LoginAttempt) Lock(tx *storage.Connection, duration time.Duration) error {
+	lockedUntil := time.Now().Add(duration)
+	a.LockedUntil = &lockedUntil
+	a.FailedCount = 0
+
+	return tx.UpdateOnly(a, "locked_until", "failed_count")
+}
+
+// FindLoginLockout returns the lockout of the given keys that ends last, or
+// nil if none of them is locked.
+func FindLoginLockout(tx *storage.Connection, keys ...string) (*LoginAttempt, error) {
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	attempt := &LoginAttempt{}
+	if err := tx.Q().Where("key in (?) and locked_until > now()", args...).Order("locked_until desc").First(attempt); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "error finding login lockout")
+	}
+
+	return attempt, nil
+}
+
+// ClearLoginAttempts removes the failed logins and lockouts of the keys.
+func ClearLoginAttempts(tx *storage.Connection, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	tableName := (&pop.Model{Value: LoginAttempt{}}).TableName()
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+	if err := tx.RawQuery("delete from "+tableName+" where key in ("+placeholders+")", args...).Exec(); err != nil {
+		return errors.Wrap(err, "error clearing login attempts")
+	}
+
+	return nil
+}
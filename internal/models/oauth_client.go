@@ -0,0 +1,89 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// OAuthClient is a confidential client, such as a backend service, which
+// gets access tokens of its own with the client credentials grant. The
+// client secret is only stored hashed, and Scope is the space separated
+// scopes the client may ask for.
+type OAuthClient struct {
+	ID               uuid.UUID `json:"client_id" db:"id"`
+	ClientSecretHash string    `json:"-" db:"client_secret_hash"`
+	Name             string    `json:"name" db:"name"`
+	Role             string    `json:"role" db:"role"`
+	Scope            string    `json:"scope" db:"scope"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// NewOAuthClient creates a client with a random secret, which is returned
+// as it can't be recovered from the client later.
+func NewOAuthClient(name, role string, scopes []string) (*OAuthClient, string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error generating unique id")
+	}
+
+	secret := crypto.SecureToken(32)
+
+	return &OAuthClient{
+		ID:               id,
+		ClientSecretHash: hashClientSecret(secret),
+		Name:             name,
+		Role:             role,
+		Scope:            strings.Join(scopes, " "),
+	}, secret, nil
+}
+
+func hashClientSecret(secret string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(secret)))
+}
+
+// Authenticate reports whether secret is the secret of the client.
+func (c *OAuthClient) Authenticate(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashClientSecret(secret)), []byte(c.ClientSecretHash)) == 1
+}
+
+// Scopes returns the scopes the client may ask for.
+func (c *OAuthClient) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// FindOAuthClients returns all the clients, the newest first.
+func FindOAuthClients(tx *storage.Connection) ([]*OAuthClient, error) {
+	clients := []*OAuthClient{}
+	if err := tx.Q().Order("created_at desc").All(&clients); err != nil {
+		return nil, errors.Wrap(err, "error finding OAuth clients")
+	}
+
+	return clients, nil
+}
+
+// FindOAuthClientByID finds the client with the client ID.
+func FindOAuthClientByID(tx *storage.Connection, id uuid.UUID) (*OAuthClient, error) {
+	client := &OAuthClient{}
+	if err := tx.Find(client, id); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, OAuthClientNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding OAuth client")
+	}
+
+	return client, nil
+}
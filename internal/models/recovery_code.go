@@ -0,0 +1,106 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/crypto"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated at once.
+const RecoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RecoveryCode is a single use code that upgrades a session to AAL2 when the
+// user has lost access to their factors. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`
+	CodeHash  string     `json:"-" db:"code_hash"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// hashRecoveryCode ignores case, whitespace and dashes so that codes can be
+// entered as displayed or not.
+func hashRecoveryCode(userID uuid.UUID, code string) string {
+	code = strings.ToLower(code)
+	code = strings.Join(strings.FieldsFunc(code, func(r rune) bool {
+		return r == '-' || r == ' ' || r == '\t'
+	}), "")
+
+	return crypto.GenerateTokenHash(userID.String(), code)
+}
+
+func generateRecoveryCode() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // rand should never fail
+	}
+
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+	return code[:5] + "-" + code[5:]
+}
+
+// GenerateRecoveryCodes replaces all recovery codes of the user with a new
+// set, returning the codes in plaintext.
+func GenerateRecoveryCodes(tx *storage.Connection, userID uuid.UUID) ([]string, error) {
+	if err := DeleteRecoveryCodes(tx, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	for len(codes) < RecoveryCodeCount {
+		code := generateRecoveryCode()
+		if err := tx.Create(&RecoveryCode{
+			ID:       uuid.Must(uuid.NewV4()),
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(userID, code),
+		}); err != nil {
+			return nil, errors.Wrap(err, "error creating recovery code")
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// ConsumeRecoveryCode marks a matching unused recovery code as used. The
+// update is a single statement, so concurrent requests with the same code
+// can't both succeed.
+func ConsumeRecoveryCode(tx *storage.Connection, userID uuid.UUID, code string) (bool, error) {
+	count, err := tx.RawQuery(
+		"update "+(&pop.Model{Value: RecoveryCode{}}).TableName()+" set used_at = now() where user_id = ? and code_hash = ? and used_at is null",
+		userID, hashRecoveryCode(userID, code),
+	).ExecWithCount()
+	if err != nil {
+		return false, errors.Wrap(err, "error consuming recovery code")
+	}
+
+	return count == 1, nil
+}
+
+// CountRemainingRecoveryCodes returns the number of unused recovery codes.
+func CountRemainingRecoveryCodes(tx *storage.Connection, userID uuid.UUID) (int, error) {
+	count, err := tx.Q().Where("user_id = ? and used_at is null", userID).Count(&RecoveryCode{})
+	if err != nil {
+		return 0, errors.Wrap(err, "error counting recovery codes")
+	}
+
+	return count, nil
+}
+
+func DeleteRecoveryCodes(tx *storage.Connection, userID uuid.UUID) error {
+	return tx.Q().Where("user_id = ?", userID).Delete(RecoveryCode{})
+}
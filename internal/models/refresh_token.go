@@ -50,7 +50,7 @@ type GrantParams struct {
 
 func (g *GrantParams) FillGrantParams(r *http.Request) {
 	g.UserAgent = r.Header.Get("User-Agent")
-	g.IP = utilities.GetIPAddress(r)
+	g.IP = utilities.GetClientIP(r)
 }
 
 // GrantAuthenticatedUser creates a refresh token for the provided user.
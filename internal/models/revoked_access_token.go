@@ -0,0 +1,59 @@
+package models
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// RevokedAccessToken denies an access token which was revoked before it
+// expired. Only the hash of the token is stored, and only until it expires.
+type RevokedAccessToken struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	UserID    *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+func (RevokedAccessToken) TableName() string {
+	return "revoked_access_tokens"
+}
+
+// HashAccessToken returns the hash the access token is denied by.
+func HashAccessToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// RevokeAccessToken denies the access token of the user until it expires.
+// Revoking a token twice is not an error.
+func RevokeAccessToken(tx *storage.Connection, token string, userID *uuid.UUID, expiresAt time.Time) error {
+	tableName := (&pop.Model{Value: RevokedAccessToken{}}).TableName()
+
+	if err := tx.RawQuery(
+		"insert into "+tableName+" (id, token_hash, user_id, expires_at, created_at) values (?, ?, ?, ?, now()) on conflict (token_hash) do nothing",
+		uuid.Must(uuid.NewV4()),
+		HashAccessToken(token),
+		userID,
+		expiresAt,
+	).Exec(); err != nil {
+		return errors.Wrap(err, "error revoking access token")
+	}
+
+	return nil
+}
+
+// IsAccessTokenRevoked returns whether the access token was revoked.
+func IsAccessTokenRevoked(tx *storage.Connection, token string) (bool, error) {
+	exists, err := tx.Q().Where("token_hash = ?", HashAccessToken(token)).Exists(&RevokedAccessToken{})
+	if err != nil {
+		return false, errors.Wrap(err, "error finding revoked access token")
+	}
+
+	return exists, nil
+}
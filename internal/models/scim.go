@@ -0,0 +1,219 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// SCIMGroup is a group provisioned through SCIM. The names of the groups of a
+// user are kept in the user's app_metadata.
+type SCIMGroup struct {
+	ID          uuid.UUID          `json:"id" db:"id"`
+	DisplayName string             `json:"display_name" db:"display_name"`
+	ExternalID  storage.NullString `json:"external_id" db:"external_id"`
+	CreatedAt   time.Time          `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" db:"updated_at"`
+}
+
+func (SCIMGroup) TableName() string {
+	return "scim_groups"
+}
+
+// SCIMGroupMember is the membership of a user in a SCIM group.
+type SCIMGroupMember struct {
+	GroupID   uuid.UUID `db:"group_id"`
+	UserID    uuid.UUID `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (SCIMGroupMember) TableName() string {
+	return "scim_group_members"
+}
+
+// scimUserNameIndex is the unique index on the user names of the users
+// provisioned through SCIM.
+const scimUserNameIndex = "users_scim_user_name_key"
+
+// IsDuplicatedSCIMUserNameError reports whether err is from giving a user a
+// SCIM user name another user in the audience has already.
+func IsDuplicatedSCIMUserNameError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == scimUserNameIndex
+}
+
+// SCIMFilter narrows down the users or groups listed through SCIM to those
+// whose attribute, in lower case, equals the value.
+type SCIMFilter struct {
+	Attribute string
+	Value     string
+}
+
+// NewSCIMGroup creates a group with a new ID.
+func NewSCIMGroup(displayName, externalID string) (*SCIMGroup, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating unique id")
+	}
+
+	return &SCIMGroup{
+		ID:          id,
+		DisplayName: displayName,
+		ExternalID:  storage.NullString(externalID),
+	}, nil
+}
+
+// FindSCIMGroupByID finds a group by its ID.
+func FindSCIMGroupByID(tx *storage.Connection, id uuid.UUID) (*SCIMGroup, error) {
+	g := &SCIMGroup{}
+	if err := tx.Q().Where("id = ?", id).First(g); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, SCIMGroupNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding SCIM group")
+	}
+
+	return g, nil
+}
+
+// FindSCIMGroupByDisplayName finds a group by its display name, which is
+// unique regardless of case.
+func FindSCIMGroupByDisplayName(tx *storage.Connection, displayName string) (*SCIMGroup, error) {
+	g := &SCIMGroup{}
+	if err := tx.Q().Where("lower(display_name) = ?", strings.ToLower(displayName)).First(g); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, SCIMGroupNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding SCIM group")
+	}
+
+	return g, nil
+}
+
+// FindSCIMGroups returns a page of the groups matching filter, which may be
+// nil, and the number of groups matching it.
+func FindSCIMGroups(tx *storage.Connection, filter *SCIMFilter, offset, limit int) ([]*SCIMGroup, int, error) {
+	q := tx.Q()
+	if filter != nil {
+		switch filter.Attribute {
+		case "id":
+			q = q.Where("id::text = ?", strings.ToLower(filter.Value))
+		case "displayname":
+			q = q.Where("lower(display_name) = ?", strings.ToLower(filter.Value))
+		case "externalid":
+			q = q.Where("external_id = ?", filter.Value)
+		default:
+			return nil, 0, errors.Errorf("unsupported SCIM group filter attribute %q", filter.Attribute)
+		}
+	}
+
+	total, err := q.Count(&SCIMGroup{})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error counting SCIM groups")
+	}
+
+	groups := []*SCIMGroup{}
+	if limit > 0 {
+		q.Paginator = &pop.Paginator{PerPage: limit, Offset: offset}
+		if err := q.Order("created_at asc").Order("id asc").All(&groups); err != nil {
+			return nil, 0, errors.Wrap(err, "error finding SCIM groups")
+		}
+	}
+
+	return groups, total, nil
+}
+
+// FindSCIMGroupsByUser returns the groups of a user, sorted by name.
+func FindSCIMGroupsByUser(tx *storage.Connection, userID uuid.UUID) ([]*SCIMGroup, error) {
+	membersTable := (&pop.Model{Value: SCIMGroupMember{}}).TableName()
+
+	groups := []*SCIMGroup{}
+	if err := tx.Q().Where("id in (select group_id from "+membersTable+" where user_id = ?)", userID).Order("display_name asc").All(&groups); err != nil {
+		return nil, errors.Wrap(err, "error finding SCIM groups of user")
+	}
+
+	return groups, nil
+}
+
+// MemberIDs returns the IDs of the members of the group.
+func (g *SCIMGroup) MemberIDs(tx *storage.Connection) ([]uuid.UUID, error) {
+	members := []SCIMGroupMember{}
+	if err := tx.Q().Where("group_id = ?", g.ID).Order("created_at asc").Order("user_id asc").All(&members); err != nil {
+		return nil, errors.Wrap(err, "error finding SCIM group members")
+	}
+
+	ids := make([]uuid.UUID, len(members))
+	for i, m := range members {
+		ids[i] = m.UserID
+	}
+	return ids, nil
+}
+
+// AddMembers adds users to the group, ignoring those who already are members.
+func (g *SCIMGroup) AddMembers(tx *storage.Connection, userIDs []uuid.UUID) error {
+	tableName := (&pop.Model{Value: SCIMGroupMember{}}).TableName()
+
+	for _, userID := range userIDs {
+		if err := tx.RawQuery("insert into "+tableName+" (group_id, user_id) values (?, ?) on conflict do nothing", g.ID, userID).Exec(); err != nil {
+			return errors.Wrap(err, "error adding SCIM group member")
+		}
+	}
+
+	return nil
+}
+
+// RemoveMembers removes users from the group.
+func (g *SCIMGroup) RemoveMembers(tx *storage.Connection, userIDs []uuid.UUID) error {
+	tableName := (&pop.Model{Value: SCIMGroupMember{}}).TableName()
+
+	for _, userID := range userIDs {
+		if err := tx.RawQuery("delete from "+tableName+" where group_id = ? and user_id = ?", g.ID, userID).Exec(); err != nil {
+			return errors.Wrap(err, "error removing SCIM group member")
+		}
+	}
+
+	return nil
+}
+
+// FindSCIMUsers returns a page of the users in the audience matching filter,
+// which may be nil, and the number of users matching it. Users who weren't
+// provisioned through SCIM have their email address as user name.
+func FindSCIMUsers(tx *storage.Connection, aud string, filter *SCIMFilter, offset, limit int) ([]*User, int, error) {
+	q := tx.Q().Where("instance_id = ? and aud = ? and deleted_at is null", uuid.Nil, aud)
+	if filter != nil {
+		switch filter.Attribute {
+		case "id":
+			q = q.Where("id::text = ?", strings.ToLower(filter.Value))
+		case "username":
+			q = q.Where("lower(coalesce(raw_app_meta_data->'scim'->>'user_name', email)) = ?", strings.ToLower(filter.Value))
+		case "externalid":
+			q = q.Where("raw_app_meta_data->'scim'->>'external_id' = ?", filter.Value)
+		case "emails", "emails.value":
+			q = q.Where("lower(email) = ?", strings.ToLower(filter.Value))
+		default:
+			return nil, 0, errors.Errorf("unsupported SCIM user filter attribute %q", filter.Attribute)
+		}
+	}
+
+	total, err := q.Count(&User{})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error counting users")
+	}
+
+	users := []*User{}
+	if limit > 0 {
+		q.Paginator = &pop.Paginator{PerPage: limit, Offset: offset}
+		if err := q.Order("created_at asc").Order("id asc").All(&users); err != nil {
+			return nil, 0, errors.Wrap(err, "error finding users")
+		}
+	}
+
+	return users, total, nil
+}
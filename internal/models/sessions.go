@@ -212,6 +212,15 @@ func FindSessionByUserID(tx *storage.Connection, userId uuid.UUID) (*Session, er
 	return session, nil
 }
 
+// FindUserSessions returns the sessions of the user, the newest first.
+func FindUserSessions(tx *storage.Connection, userID uuid.UUID) ([]*Session, error) {
+	sessions := []*Session{}
+	if err := tx.Q().Where("user_id = ?", userID).Order("created_at desc").All(&sessions); err != nil {
+		return nil, errors.Wrap(err, "error finding sessions")
+	}
+	return sessions, nil
+}
+
 func FindSessionsByFactorID(tx *storage.Connection, factorID uuid.UUID) ([]*Session, error) {
 	sessions := []*Session{}
 	if err := tx.Q().Where("factor_id = ?", factorID).All(&sessions); err != nil {
@@ -253,6 +262,18 @@ func updateFactorAssociatedSessions(tx *storage.Connection, userID, factorID uui
 	return tx.RawQuery("UPDATE "+(&pop.Model{Value: Session{}}).TableName()+" set aal = ?, factor_id = ? WHERE user_id = ? AND factor_id = ?", aal, nil, userID, factorID).Exec()
 }
 
+// DowngradeUserSessionsToAAL1 removes the second factor claims from all
+// sessions of the user, such as after all of their factors are removed.
+func DowngradeUserSessionsToAAL1(tx *storage.Connection, userID uuid.UUID) error {
+	sessionTable := (&pop.Model{Value: Session{}}).TableName()
+	amrTable := (&pop.Model{Value: AMRClaim{}}).TableName()
+
+	if err := tx.RawQuery("DELETE FROM "+amrTable+" WHERE session_id IN (SELECT id FROM "+sessionTable+" WHERE user_id = ?) AND authentication_method IN (?, ?)", userID, TOTPSignIn.String(), RecoveryCodeSignIn.String()).Exec(); err != nil {
+		return err
+	}
+	return tx.RawQuery("UPDATE "+sessionTable+" set aal = ?, factor_id = ? WHERE user_id = ?", AAL1.String(), nil, userID).Exec()
+}
+
 func InvalidateSessionsWithAALLessThan(tx *storage.Connection, userID uuid.UUID, level string) error {
 	return tx.RawQuery("DELETE FROM "+(&pop.Model{Value: Session{}}).TableName()+" WHERE user_id = ? AND aal < ?", userID, level).Exec()
 }
@@ -282,7 +303,8 @@ func (s *Session) UpdateAALAndAssociatedFactor(tx *storage.Connection, aal Authe
 func (s *Session) CalculateAALAndAMR(user *User) (aal AuthenticatorAssuranceLevel, amr []AMREntry, err error) {
 	amr, aal = []AMREntry{}, AAL1
 	for _, claim := range s.AMRClaims {
-		if *claim.AuthenticationMethod == TOTPSignIn.String() {
+		switch *claim.AuthenticationMethod {
+		case TOTPSignIn.String(), RecoveryCodeSignIn.String(), WebAuthnSignIn.String(), PhoneSignIn.String():
 			aal = AAL2
 		}
 		amr = append(amr, AMREntry{Method: claim.GetAuthenticationMethod(), Timestamp: claim.UpdatedAt.Unix()})
@@ -9,6 +9,7 @@ import (
 
 	"github.com/crewjam/saml"
 	"github.com/crewjam/saml/samlsp"
+	"github.com/gobuffalo/pop/v6"
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 	"github.com/supabase/auth/internal/storage"
@@ -170,6 +171,46 @@ func (s SAMLRelayState) TableName() string {
 	return "saml_relay_states"
 }
 
+// SAMLConsumedAssertion is a SAML assertion that was used to sign in. It's
+// kept until the assertion expires, so that it can't be used again.
+type SAMLConsumedAssertion struct {
+	ID uuid.UUID `db:"id"`
+
+	SSOProviderID uuid.UUID `db:"sso_provider_id"`
+	AssertionID   string    `db:"assertion_id"`
+
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (a SAMLConsumedAssertion) TableName() string {
+	return "saml_consumed_assertions"
+}
+
+// ConsumeSAMLAssertion records that the assertion of the SSO provider was
+// used. It returns SAMLAssertionReplayedError if it already was.
+func ConsumeSAMLAssertion(tx *storage.Connection, ssoProviderID uuid.UUID, assertionID string, expiresAt time.Time) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "error generating unique id")
+	}
+
+	tableName := (&pop.Model{Value: SAMLConsumedAssertion{}}).TableName()
+	count, err := tx.RawQuery(
+		"insert into "+tableName+" (id, sso_provider_id, assertion_id, expires_at) values (?, ?, ?, ?) on conflict (sso_provider_id, assertion_id) do nothing",
+		id, ssoProviderID, assertionID, expiresAt,
+	).ExecWithCount()
+	if err != nil {
+		return errors.Wrap(err, "error recording consumed SAML assertion")
+	}
+
+	if count == 0 {
+		return SAMLAssertionReplayedError{}
+	}
+
+	return nil
+}
+
 func FindSAMLProviderByEntityID(tx *storage.Connection, entityId string) (*SSOProvider, error) {
 	var samlProvider SAMLProvider
 	if err := tx.Q().Where("entity_id = ?", entityId).First(&samlProvider); err != nil {
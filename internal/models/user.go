@@ -54,6 +54,10 @@ type User struct {
 	ReauthenticationToken  string     `json:"-" db:"reauthentication_token"`
 	ReauthenticationSentAt *time.Time `json:"reauthentication_sent_at,omitempty" db:"reauthentication_sent_at"`
 
+	// MFAPhoneChallengeSentAt is when a code was last sent for a phone
+	// factor of the user.
+	MFAPhoneChallengeSentAt *time.Time `json:"-" db:"mfa_phone_challenge_sent_at"`
+
 	LastSignInAt *time.Time `json:"last_sign_in_at,omitempty" db:"last_sign_in_at"`
 
 	AppMetaData  JSONMap `json:"app_metadata" db:"raw_app_meta_data"`
@@ -62,6 +66,12 @@ type User struct {
 	Factors    []Factor   `json:"factors,omitempty" has_many:"factors"`
 	Identities []Identity `json:"identities" has_many:"identities"`
 
+	// RecoveryCodesRemaining is only set by LoadRecoveryCodesRemaining.
+	RecoveryCodesRemaining *int `json:"recovery_codes_remaining,omitempty" db:"-"`
+
+	// ActiveSessions is only set by LoadActiveSessions.
+	ActiveSessions *int `json:"active_sessions,omitempty" db:"-"`
+
 	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
 	BannedUntil *time.Time `json:"banned_until,omitempty" db:"banned_until"`
@@ -100,6 +110,26 @@ func NewUser(phone, email, password, aud string, userData map[string]interface{}
 	return user, nil
 }
 
+// NewUserWithPasswordHash initializes a new user from an email, an already
+// hashed password and user data. The hash must be verifiable by
+// crypto.CompareHashAndPassword.
+func NewUserWithPasswordHash(phone, email, passwordHash, aud string, userData map[string]interface{}) (*User, error) {
+	if passwordHash != "" {
+		if err := crypto.ValidatePasswordHash(passwordHash); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := NewUser(phone, email, "", aud, userData)
+	if err != nil {
+		return nil, err
+	}
+
+	user.EncryptedPassword = passwordHash
+
+	return user, nil
+}
+
 // TableName overrides the table name used by pop
 func (User) TableName() string {
 	tableName := "users"
@@ -158,6 +188,13 @@ func (u *User) IsPhoneConfirmed() bool {
 	return u.PhoneConfirmedAt != nil
 }
 
+// SetMFAPhoneChallengeSentAt records that a code was sent for a phone factor
+// of the user.
+func (u *User) SetMFAPhoneChallengeSentAt(tx *storage.Connection, sentAt time.Time) error {
+	u.MFAPhoneChallengeSentAt = &sentAt
+	return tx.UpdateOnly(u, "mfa_phone_challenge_sent_at")
+}
+
 // SetRole sets the users Role to roleName
 func (u *User) SetRole(tx *storage.Connection, roleName string) error {
 	u.Role = strings.TrimSpace(roleName)
@@ -179,6 +216,15 @@ func (u *User) GetPhone() string {
 	return string(u.Phone)
 }
 
+// GetLanguage returns the user's preferred language from their metadata, or an
+// empty string if it isn't known.
+func (u *User) GetLanguage() string {
+	if language, ok := u.UserMetaData["language"].(string); ok {
+		return language
+	}
+	return ""
+}
+
 // UpdateUserMetaData sets all user data from a map of updates,
 // ensuring that it doesn't override attributes that are not
 // in the provided map.
@@ -339,7 +385,10 @@ func (u *User) UpdatePassword(tx *storage.Connection, sessionID *uuid.UUID) erro
 	}
 }
 
-// Authenticate a user from a password
+// Authenticate a user from a password. The second return value reports
+// whether a valid password should be stored again with SetPassword, because
+// it needs to be encrypted with the current key or its hash doesn't use the
+// configured algorithm and cost.
 func (u *User) Authenticate(ctx context.Context, password string, decryptionKeys map[string]string, encrypt bool, encryptionKeyID string) (bool, bool, error) {
 	hash := u.EncryptedPassword
 
@@ -353,23 +402,32 @@ func (u *User) Authenticate(ctx context.Context, password string, decryptionKeys
 		hash = string(h)
 	}
 
-	compareErr := crypto.CompareHashAndPassword(ctx, hash, password)
+	if err := crypto.CompareHashAndPassword(ctx, hash, password); err != nil {
+		return false, false, nil
+	}
+
+	shouldReEncrypt := encrypt && (es == nil || es.ShouldReEncrypt(encryptionKeyID))
 
-	return compareErr == nil, encrypt && (es == nil || es.ShouldReEncrypt(encryptionKeyID)), nil
+	return true, shouldReEncrypt || crypto.NeedsRehash(hash), nil
 }
 
-// ConfirmReauthentication resets the reauthentication token
-func (u *User) ConfirmReauthentication(tx *storage.Connection) error {
+// ConfirmReauthentication resets the reauthentication token. It returns
+// false if the token was already used, for example by a concurrent request.
+func (u *User) ConfirmReauthentication(tx *storage.Connection) (bool, error) {
+	count, err := tx.RawQuery("UPDATE "+(&pop.Model{Value: User{}}).TableName()+" SET reauthentication_token = '' WHERE id = ? AND reauthentication_token = ?", u.ID, u.ReauthenticationToken).ExecWithCount()
+	if err != nil {
+		return false, err
+	}
 	u.ReauthenticationToken = ""
-	if err := tx.UpdateOnly(u, "reauthentication_token"); err != nil {
-		return err
+	if count == 0 {
+		return false, nil
 	}
 
 	if err := ClearAllOneTimeTokensForUser(tx, u.ID); err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
 // Confirm resets the confimation token and sets the confirm timestamp
@@ -401,9 +459,15 @@ func (u *User) ConfirmPhone(tx *storage.Connection) error {
 	return ClearAllOneTimeTokensForUser(tx, u.ID)
 }
 
-// UpdateLastSignInAt update field last_sign_in_at for user according to specified field
+// UpdateLastSignInAt sets last_sign_in_at to the current time. Signing in
+// doesn't modify the user, so updated_at is left as is.
 func (u *User) UpdateLastSignInAt(tx *storage.Connection) error {
-	return tx.UpdateOnly(u, "last_sign_in_at")
+	now := time.Now()
+	if err := tx.RawQuery("UPDATE "+(&pop.Model{Value: User{}}).TableName()+" SET last_sign_in_at = ? WHERE id = ?", now, u.ID).Exec(); err != nil {
+		return err
+	}
+	u.LastSignInAt = &now
+	return nil
 }
 
 // ConfirmEmailChange confirm the change of email for a user
@@ -603,19 +667,34 @@ func FindUserWithRefreshToken(tx *storage.Connection, token string, forUpdate bo
 	return user, refreshToken, session, nil
 }
 
-// FindUsersInAudience finds users with the matching audience.
-func FindUsersInAudience(tx *storage.Connection, aud string, pageParams *Pagination, sortParams *SortParams, filter string) ([]*User, error) {
-	users := []*User{}
-	q := tx.Q().Where("instance_id = ? and aud = ?", uuid.Nil, aud)
+// UserFilter narrows down the users returned by FindUsersInAudience. Zero
+// values are ignored.
+type UserFilter struct {
+	// Query is matched against the email and full name of the user.
+	Query          string
+	IncludeDeleted bool
+	IsAnonymous    *bool
+}
 
-	if filter != "" {
-		lf := "%" + filter + "%"
-		// we must specify the collation in order to get case insensitive search for the JSON column
-		q = q.Where("(email LIKE ? OR raw_user_meta_data->>'full_name' ILIKE ?)", lf, lf)
+// FindUsersInAudience finds users with the matching audience.
+func FindUsersInAudience(tx *storage.Connection, aud string, pageParams *Pagination, sortParams *SortParams, filter *UserFilter) ([]*User, error) {
+	if filter == nil {
+		filter = &UserFilter{}
 	}
 
+	users := []*User{}
+	q := usersInAudienceQuery(tx, aud, filter)
+
 	if sortParams != nil && len(sortParams.Fields) > 0 {
 		for _, field := range sortParams.Fields {
+			if field.Name == CreatedAt {
+				// users are sorted like in FindUsersInAudienceAfter, by
+				// ID when created at the same time, so that a page's
+				// last user can be the cursor of the next page
+				q = q.Order(userCursorCreatedAt + " " + string(field.Dir))
+				q = q.Order("id " + string(field.Dir))
+				continue
+			}
 			q = q.Order(field.Name + " " + string(field.Dir))
 		}
 	}
@@ -627,8 +706,65 @@ func FindUsersInAudience(tx *storage.Connection, aud string, pageParams *Paginat
 	} else {
 		err = q.All(&users)
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	return users, err
+	if err := loadIdentitiesForUsers(tx, users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// usersInAudienceQuery selects the users in the audience matching filter.
+func usersInAudienceQuery(tx *storage.Connection, aud string, filter *UserFilter) *pop.Query {
+	q := tx.Q().Where("instance_id = ? and aud = ?", uuid.Nil, aud)
+
+	if !filter.IncludeDeleted {
+		q = q.Where("deleted_at is null")
+	}
+
+	if filter.IsAnonymous != nil {
+		q = q.Where("is_anonymous = ?", *filter.IsAnonymous)
+	}
+
+	if filter.Query != "" {
+		lf := "%" + filter.Query + "%"
+		// we must specify the collation in order to get case insensitive search for the JSON column
+		q = q.Where("(email LIKE ? OR raw_user_meta_data->>'full_name' ILIKE ?)", lf, lf)
+	}
+
+	return q
+}
+
+// loadIdentitiesForUsers loads the identities of all users with a single
+// query, instead of one per user as eager loading would.
+func loadIdentitiesForUsers(tx *storage.Connection, users []*User) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	userIDs := make([]interface{}, 0, len(users))
+	usersByID := make(map[uuid.UUID]*User, len(users))
+	for _, u := range users {
+		u.Identities = []Identity{}
+		userIDs = append(userIDs, u.ID)
+		usersByID[u.ID] = u
+	}
+
+	identities := []Identity{}
+	if err := tx.Q().Where("user_id in (?)", userIDs...).Order("created_at asc").All(&identities); err != nil {
+		return errors.Wrap(err, "error finding identities")
+	}
+
+	for _, identity := range identities {
+		if u, ok := usersByID[identity.UserID]; ok {
+			u.Identities = append(u.Identities, identity)
+		}
+	}
+
+	return nil
 }
 
 // IsDuplicatedEmail returns whether a user exists with a matching email and audience.
@@ -692,6 +828,35 @@ func IsDuplicatedPhone(tx *storage.Connection, phone, aud string) (bool, error)
 	return true, nil
 }
 
+// LoadRecoveryCodesRemaining sets the number of unused recovery codes of the
+// user.
+func (u *User) LoadRecoveryCodesRemaining(tx *storage.Connection) error {
+	count, err := CountRemainingRecoveryCodes(tx, u.ID)
+	if err != nil {
+		return err
+	}
+	u.RecoveryCodesRemaining = &count
+	return nil
+}
+
+// LoadActiveSessions sets the number of sessions of the user that are still
+// valid at now.
+func (u *User) LoadActiveSessions(tx *storage.Connection, now time.Time, timebox, inactivityTimeout *time.Duration) error {
+	sessions, err := FindAllSessionsForUser(tx, u.ID, false)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, session := range sessions {
+		if session.CheckValidity(now, nil, timebox, inactivityTimeout) == SessionValid {
+			count++
+		}
+	}
+	u.ActiveSessions = &count
+	return nil
+}
+
 // Ban a user for a given duration.
 func (u *User) Ban(tx *storage.Connection, duration time.Duration) error {
 	if duration == time.Duration(0) {
@@ -749,8 +914,50 @@ func (u *User) RemoveUnconfirmedIdentities(tx *storage.Connection, identity *Ide
 	return nil
 }
 
+// InvalidateEmailOtps clears the OTPs and links sent to the user by email.
+func (u *User) InvalidateEmailOtps(tx *storage.Connection) error {
+	u.ConfirmationToken = ""
+	u.RecoveryToken = ""
+	u.EmailChangeTokenCurrent = ""
+	u.EmailChangeTokenNew = ""
+
+	if err := tx.UpdateOnly(u, "confirmation_token", "recovery_token", "email_change_token_current", "email_change_token_new"); err != nil {
+		return err
+	}
+
+	for _, tokenType := range []OneTimeTokenType{ConfirmationToken, RecoveryToken, EmailChangeTokenCurrent, EmailChangeTokenNew} {
+		if err := ClearOneTimeTokenForUser(tx, u.ID, tokenType); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InvalidatePhoneOtps clears the OTPs sent to the user by SMS.
+func (u *User) InvalidatePhoneOtps(tx *storage.Connection) error {
+	u.ConfirmationToken = ""
+	u.PhoneChangeToken = ""
+
+	if err := tx.UpdateOnly(u, "confirmation_token", "phone_change_token"); err != nil {
+		return err
+	}
+
+	for _, tokenType := range []OneTimeTokenType{ConfirmationToken, PhoneChangeToken} {
+		if err := ClearOneTimeTokenForUser(tx, u.ID, tokenType); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // SoftDeleteUser performs a soft deletion on the user by obfuscating and clearing certain fields
 func (u *User) SoftDeleteUser(tx *storage.Connection) error {
+	if err := tx.Create(newUserSoftDeletion(u)); err != nil {
+		return err
+	}
+
 	u.Email = storage.NullString(obfuscateEmail(u, u.GetEmail()))
 	u.Phone = storage.NullString(obfuscatePhone(u, u.GetPhone()))
 	u.EmailChange = obfuscateEmail(u, u.EmailChange)
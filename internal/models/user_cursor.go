@@ -0,0 +1,161 @@
+package models
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// UserCursor is the position of a user in a listing of users sorted by
+// created_at. Users created at the same time are told apart by their ID.
+//
+// Unlike with offsets, a page after a cursor doesn't skip or repeat users
+// when users are created or deleted between the pages, and reading it is as
+// fast as reading the first page.
+type UserCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// userCursorCreatedAt is the created_at of users in cursors and the sort
+// order of listings. Users can have a null created_at, which can't be
+// compared with a cursor, so they are sorted as created at the zero time, the
+// CreatedAt of their cursors.
+const userCursorCreatedAt = "coalesce(created_at, '0001-01-01 00:00:00+00'::timestamptz)"
+
+// NewUserCursor returns the cursor of the listing's page after user.
+func NewUserCursor(user *User) *UserCursor {
+	return &UserCursor{
+		CreatedAt: user.CreatedAt,
+		ID:        user.ID,
+	}
+}
+
+// String encodes the cursor. The encoding is opaque to clients.
+func (c *UserCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "/" + c.ID.String()))
+}
+
+// ParseUserCursor decodes a cursor encoded with UserCursor.String.
+func ParseUserCursor(value string) (*UserCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	createdAt, id, ok := strings.Cut(string(decoded), "/")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+
+	cursor := &UserCursor{}
+	if cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if cursor.ID, err = uuid.FromString(id); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return cursor, nil
+}
+
+// FindUsersInAudienceAfter finds up to limit users with the matching audience
+// sorted by created_at in the direction dir, starting after cursor or from
+// the start if it's nil. The cursor of the next page is nil once there are no
+// more users.
+func FindUsersInAudienceAfter(tx *storage.Connection, aud string, cursor *UserCursor, limit int, dir SortDirection, filter *UserFilter) ([]*User, *UserCursor, error) {
+	if filter == nil {
+		filter = &UserFilter{}
+	}
+
+	comparison := "<"
+	if dir == Ascending {
+		comparison = ">"
+	}
+
+	q := usersInAudienceQuery(tx, aud, filter)
+	if cursor != nil {
+		q = q.Where("("+userCursorCreatedAt+", id) "+comparison+" (?, ?)", cursor.CreatedAt, cursor.ID)
+	}
+
+	// one more user is read to tell whether there's a next page
+	users := []*User{}
+	if err := q.Order(userCursorCreatedAt + " " + string(dir)).Order("id " + string(dir)).Limit(limit + 1).All(&users); err != nil {
+		return nil, nil, errors.Wrap(err, "error finding users")
+	}
+
+	var next *UserCursor
+	if len(users) > limit {
+		users = users[:limit]
+		next = NewUserCursor(users[limit-1])
+	}
+
+	if err := loadIdentitiesForUsers(tx, users); err != nil {
+		return nil, nil, err
+	}
+
+	return users, next, nil
+}
+
+// UserIterator reads all of the users matching a filter in batches, so that
+// any number of users can be read with a bounded amount of memory. Each
+// batch is read with its own query, so no transaction or connection is held
+// between batches.
+type UserIterator struct {
+	tx        *storage.Connection
+	aud       string
+	dir       SortDirection
+	filter    *UserFilter
+	batchSize int
+
+	batch  []*User
+	index  int
+	cursor *UserCursor
+	done   bool
+	err    error
+}
+
+// NewUserIterator returns an iterator over the users with the matching
+// audience sorted by created_at in the direction dir.
+func NewUserIterator(tx *storage.Connection, aud string, dir SortDirection, filter *UserFilter, batchSize int) *UserIterator {
+	return &UserIterator{
+		tx:        tx,
+		aud:       aud,
+		dir:       dir,
+		filter:    filter,
+		batchSize: batchSize,
+	}
+}
+
+// Next advances to the next user and reports whether there is one. Once it
+// returns false, Err returns the error that ended the iteration, if any.
+func (it *UserIterator) Next() bool {
+	if it.index+1 < len(it.batch) {
+		it.index++
+		return true
+	}
+
+	if it.done || it.err != nil {
+		return false
+	}
+
+	it.batch, it.cursor, it.err = FindUsersInAudienceAfter(it.tx, it.aud, it.cursor, it.batchSize, it.dir, it.filter)
+	it.index = 0
+	it.done = it.cursor == nil
+
+	return it.err == nil && len(it.batch) > 0
+}
+
+// User returns the current user.
+func (it *UserIterator) User() *User {
+	return it.batch[it.index]
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *UserIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserCursor(t *testing.T) {
+	cursor := &UserCursor{
+		CreatedAt: time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.FixedZone("CET", 3600)),
+		ID:        uuid.Must(uuid.NewV4()),
+	}
+
+	parsed, err := ParseUserCursor(cursor.String())
+	require.NoError(t, err)
+	require.True(t, cursor.CreatedAt.Equal(parsed.CreatedAt))
+	require.Equal(t, cursor.ID, parsed.ID)
+
+	for _, value := range []string{
+		"",
+		"not base64!",
+		"bm8gc2VwYXJhdG9y",
+		(&UserCursor{ID: cursor.ID}).String()[:10],
+	} {
+		_, err := ParseUserCursor(value)
+		require.Error(t, err, value)
+	}
+}
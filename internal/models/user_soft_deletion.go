@@ -0,0 +1,63 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// UserSoftDeletion holds the original values of a user that SoftDeleteUser
+// obfuscates or clears, so that the user can be recovered. The row is removed
+// along with the user when the user is hard deleted.
+type UserSoftDeletion struct {
+	ID     uuid.UUID `json:"id" db:"id"`
+	UserID uuid.UUID `json:"user_id" db:"user_id"`
+
+	Email        storage.NullString `json:"email" db:"email"`
+	Phone        storage.NullString `json:"phone" db:"phone"`
+	UserMetaData JSONMap            `json:"user_metadata" db:"raw_user_meta_data"`
+	AppMetaData  JSONMap            `json:"app_metadata" db:"raw_app_meta_data"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (UserSoftDeletion) TableName() string {
+	return "user_soft_deletions"
+}
+
+func newUserSoftDeletion(u *User) *UserSoftDeletion {
+	deletion := &UserSoftDeletion{
+		ID:           uuid.Must(uuid.NewV4()),
+		UserID:       u.ID,
+		Email:        u.Email,
+		Phone:        u.Phone,
+		UserMetaData: JSONMap{},
+		AppMetaData:  JSONMap{},
+	}
+
+	// the metadata maps are cleared in place by SoftDeleteUser, so they need
+	// to be copied
+	for k, v := range u.UserMetaData {
+		deletion.UserMetaData[k] = v
+	}
+	for k, v := range u.AppMetaData {
+		deletion.AppMetaData[k] = v
+	}
+
+	return deletion
+}
+
+// FindUserSoftDeletion finds the original values of a soft deleted user.
+func FindUserSoftDeletion(tx *storage.Connection, userID uuid.UUID) (*UserSoftDeletion, error) {
+	deletion := &UserSoftDeletion{}
+	if err := tx.Q().Where("user_id = ?", userID).First(deletion); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, UserNotFoundError{}
+		}
+		return nil, errors.Wrap(err, "error finding user soft deletion")
+	}
+	return deletion, nil
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -105,15 +106,17 @@ func (ts *UserTestSuite) TestFindUserByEmailAndAudience() {
 func (ts *UserTestSuite) TestFindUsersInAudience() {
 	u := ts.createUser()
 
-	n, err := FindUsersInAudience(ts.db, u.Aud, nil, nil, "")
+	n, err := FindUsersInAudience(ts.db, u.Aud, nil, nil, nil)
 	require.NoError(ts.T(), err)
 	require.Len(ts.T(), n, 1)
+	require.Len(ts.T(), n[0].Identities, 1)
+	require.Equal(ts.T(), "email", n[0].Identities[0].Provider)
 
 	p := Pagination{
 		Page:    1,
 		PerPage: 50,
 	}
-	n, err = FindUsersInAudience(ts.db, u.Aud, &p, nil, "")
+	n, err = FindUsersInAudience(ts.db, u.Aud, &p, nil, nil)
 	require.NoError(ts.T(), err)
 	require.Len(ts.T(), n, 1)
 	assert.Equal(ts.T(), uint64(1), p.Count)
@@ -123,9 +126,33 @@ func (ts *UserTestSuite) TestFindUsersInAudience() {
 			{Name: "created_at", Dir: Descending},
 		},
 	}
-	n, err = FindUsersInAudience(ts.db, u.Aud, nil, sp, "")
+	n, err = FindUsersInAudience(ts.db, u.Aud, nil, sp, nil)
 	require.NoError(ts.T(), err)
 	require.Len(ts.T(), n, 1)
+
+	require.NoError(ts.T(), u.SoftDeleteUser(ts.db))
+
+	n, err = FindUsersInAudience(ts.db, u.Aud, nil, nil, nil)
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), n, 0)
+
+	n, err = FindUsersInAudience(ts.db, u.Aud, nil, nil, &UserFilter{IncludeDeleted: true})
+	require.NoError(ts.T(), err)
+	require.Len(ts.T(), n, 1)
+}
+
+func (ts *UserTestSuite) TestUpdateLastSignInAt() {
+	u := ts.createUser()
+	updatedAt := u.UpdatedAt
+
+	require.NoError(ts.T(), u.UpdateLastSignInAt(ts.db))
+	require.NotNil(ts.T(), u.LastSignInAt)
+
+	n, err := FindUserByID(ts.db, u.ID)
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), n.LastSignInAt)
+	require.WithinDuration(ts.T(), *u.LastSignInAt, *n.LastSignInAt, time.Millisecond)
+	require.WithinDuration(ts.T(), updatedAt, n.UpdatedAt, time.Millisecond)
 }
 
 func (ts *UserTestSuite) TestFindUserByID() {
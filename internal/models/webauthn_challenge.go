@@ -0,0 +1,72 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/storage"
+)
+
+// WebAuthnChallenge is the challenge of a WebAuthn ceremony. Challenges of
+// a factor register or verify it, challenges without a factor sign in with
+// any passkey.
+type WebAuthnChallenge struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	UserID    *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	FactorID  *uuid.UUID `json:"factor_id,omitempty" db:"factor_id"`
+	Challenge string     `json:"-" db:"challenge"`
+	IPAddress string     `json:"ip_address" db:"ip_address"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+func (WebAuthnChallenge) TableName() string {
+	return "webauthn_challenges"
+}
+
+// NewWebAuthnChallenge creates a challenge for the factor, or a sign in
+// challenge when the factor is nil.
+func NewWebAuthnChallenge(factor *Factor, challenge, ipAddress string) (*WebAuthnChallenge, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating unique id")
+	}
+
+	c := &WebAuthnChallenge{
+		ID:        id,
+		Challenge: challenge,
+		IPAddress: ipAddress,
+	}
+	if factor != nil {
+		c.UserID = &factor.UserID
+		c.FactorID = &factor.ID
+	}
+
+	return c, nil
+}
+
+// FindWebAuthnChallengeByID finds the challenge, locking it so that it can
+// only be used once.
+func FindWebAuthnChallengeByID(tx *storage.Connection, id uuid.UUID) (*WebAuthnChallenge, error) {
+	tableName := (&pop.Model{Value: WebAuthnChallenge{}}).TableName()
+
+	challenge := &WebAuthnChallenge{}
+	if err := tx.RawQuery("select * from "+tableName+" where id = ? limit 1 for update", id).First(challenge); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, ChallengeNotFoundError{}
+		}
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// GetExpiryTime returns when the challenge can no longer be verified.
+func (c *WebAuthnChallenge) GetExpiryTime(expiryDuration float64) time.Time {
+	return c.CreatedAt.Add(time.Second * time.Duration(expiryDuration))
+}
+
+func (c *WebAuthnChallenge) HasExpired(now time.Time, expiryDuration float64) bool {
+	return now.After(c.GetExpiryTime(expiryDuration))
+}
@@ -1,6 +1,7 @@
 package observability
 
 import (
+	"fmt"
 	"os"
 	"sync"
 	"time"
@@ -42,11 +43,32 @@ func (f *CustomFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return f.JSONFormatter.Format(entry)
 }
 
+// NewFormatter returns the log formatter for the configured format.
+func NewFormatter(config *conf.LoggingConfig) (logrus.Formatter, error) {
+	switch config.Format {
+	case "", "json":
+		return NewCustomFormatter(), nil
+	case "text":
+		return &logrus.TextFormatter{
+			FullTimestamp:    true,
+			TimestampFormat:  time.RFC3339,
+			DisableColors:    config.DisableColors,
+			QuoteEmptyFields: config.QuoteEmptyFields,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", config.Format)
+	}
+}
+
 func ConfigureLogging(config *conf.LoggingConfig) error {
 	var err error
 
 	loggingOnce.Do(func() {
-		formatter := NewCustomFormatter()
+		formatter, errFormat := NewFormatter(config)
+		if errFormat != nil {
+			err = errFormat
+			return
+		}
 		logrus.SetFormatter(formatter)
 
 		// use a file if you want
@@ -62,7 +84,7 @@ func ConfigureLogging(config *conf.LoggingConfig) error {
 
 		if config.Level != "" {
 			level, errParse := logrus.ParseLevel(config.Level)
-			if err != nil {
+			if errParse != nil {
 				err = errParse
 				return
 			}
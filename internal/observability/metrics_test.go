@@ -0,0 +1,62 @@
+package observability
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	// counters obtained before the meter provider is configured, like the
+	// package level counters elsewhere, must still be exported
+	counter := ObtainMetricCounter("gotrue_test_sends", "Number of test sends")
+
+	registry := promclient.NewRegistry()
+	exporter, err := prometheus.New(prometheus.WithRegisterer(registry))
+	require.NoError(t, err)
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)))
+
+	r := chi.NewRouter()
+	r.Use(RequestTracing())
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("{}"))
+	})
+	r.Get("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/fail"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	counter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("provider", "twilio")))
+
+	server := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer server.Close()
+
+	rsp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	body, err := io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	metrics := string(body)
+
+	require.Regexp(t, `gotrue_http_request_duration_seconds_count\{code="200",http_route="/users/\{id\}",[^}]*\} 2`, metrics)
+	require.Regexp(t, `gotrue_http_request_duration_seconds_count\{code="503",http_route="/fail",[^}]*\} 1`, metrics)
+	require.Regexp(t, `http_status_codes_total\{code="200",http_route="/users/\{id\}",[^}]*\} 2`, metrics)
+	require.Regexp(t, `gotrue_test_sends_total\{[^}]*provider="twilio"[^}]*\} 1`, metrics)
+}
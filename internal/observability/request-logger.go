@@ -12,13 +12,27 @@ import (
 	"github.com/supabase/auth/internal/utilities"
 )
 
+// maxRequestIDLength limits the length of request IDs taken from requests.
+const maxRequestIDLength = 128
+
+// AddRequestID assigns every request an ID that is stored in the context and
+// echoed in the response header. An ID sent in the request header is used
+// instead of a new one, so that requests can be traced across services.
 func AddRequestID(globalConfig *conf.GlobalConfiguration) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			id := uuid.Must(uuid.NewV4()).String()
-			if globalConfig.API.RequestIDHeader != "" {
-				id = r.Header.Get(globalConfig.API.RequestIDHeader)
+			header := globalConfig.API.RequestIDHeader
+			id := ""
+			if header != "" {
+				id = r.Header.Get(header)
+			}
+			if !isValidRequestID(id) {
+				id = uuid.Must(uuid.NewV4()).String()
+			}
+			if header != "" {
+				w.Header().Set(header, id)
 			}
+
 			ctx := r.Context()
 			ctx = utilities.WithRequestID(ctx, id)
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -27,6 +41,19 @@ func AddRequestID(globalConfig *conf.GlobalConfiguration) func(next http.Handler
 	}
 }
 
+// isValidRequestID reports whether id is safe to log and echo back.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
 func NewStructuredLogger(logger *logrus.Logger, config *conf.GlobalConfiguration) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -51,7 +78,7 @@ func (l *structuredLogger) NewLogEntry(r *http.Request) chimiddleware.LogEntry {
 		"component":   "api",
 		"method":      r.Method,
 		"path":        r.URL.Path,
-		"remote_addr": utilities.GetIPAddress(r),
+		"remote_addr": utilities.GetClientIP(r),
 		"referer":     referrer,
 	}
 
@@ -86,8 +113,13 @@ func (e *logEntry) Panic(v interface{}, stack []byte) {
 	e.Entry = entry
 }
 
+// GetLogEntry returns the logger of the request, which carries the request ID.
+// Without a request it returns the standard logger.
 func GetLogEntry(r *http.Request) *logEntry {
-	l, _ := chimiddleware.GetLogEntry(r).(*logEntry)
+	var l *logEntry
+	if r != nil {
+		l, _ = chimiddleware.GetLogEntry(r).(*logEntry)
+	}
 	if l == nil {
 		return &logEntry{Entry: logrus.NewEntry(logrus.StandardLogger())}
 	}
@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gofrs/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"github.com/supabase/auth/internal/conf"
+	"github.com/supabase/auth/internal/utilities"
 )
 
 const apiTestConfig = "../../hack/test.env"
@@ -70,3 +73,100 @@ func TestExcludeHealthFromLogs(t *testing.T) {
 
 	require.Empty(t, logBuffer)
 }
+
+func TestRequestIDRoundTrip(t *testing.T) {
+	config, err := conf.LoadGlobal(apiTestConfig)
+	require.NoError(t, err)
+	config.API.RequestIDHeader = "X-Request-ID"
+
+	var contextID string
+	handler := AddRequestID(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextID = utilities.GetRequestID(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		desc     string
+		incoming string
+		honored  bool
+	}{
+		{desc: "Incoming ID", incoming: "client-request-1", honored: true},
+		{desc: "No incoming ID", incoming: ""},
+		{desc: "ID with whitespace", incoming: "bad id\nvalue"},
+		{desc: "ID too long", incoming: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/settings", nil)
+			if c.incoming != "" {
+				req.Header.Set("X-Request-ID", c.incoming)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			id := w.Header().Get("X-Request-ID")
+			require.NotEmpty(t, id)
+			require.Equal(t, contextID, id)
+			if c.honored {
+				require.Equal(t, c.incoming, id)
+			} else {
+				_, err := uuid.FromString(id)
+				require.NoError(t, err, "a new request ID should be generated")
+			}
+		})
+	}
+}
+
+func TestRequestCompletedLog(t *testing.T) {
+	var logBuffer bytes.Buffer
+	config, err := conf.LoadGlobal(apiTestConfig)
+	require.NoError(t, err)
+	config.API.RequestIDHeader = "X-Request-ID"
+
+	logger := logrus.New()
+	logger.SetOutput(&logBuffer)
+	logger.SetFormatter(NewCustomFormatter())
+
+	handler := AddRequestID(config)(NewStructuredLogger(logger, config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// handlers log through the request's logger
+		GetLogEntry(r).Entry.Warn("handler log line")
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/signup", nil)
+	req.Header.Set("X-Request-ID", "completion-test")
+	req.RemoteAddr = "192.0.2.10:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	decoder := json.NewDecoder(&logBuffer)
+
+	var handlerLog map[string]interface{}
+	require.NoError(t, decoder.Decode(&handlerLog))
+	require.Equal(t, "handler log line", handlerLog["msg"])
+	require.Equal(t, "completion-test", handlerLog["request_id"])
+
+	var completed map[string]interface{}
+	require.NoError(t, decoder.Decode(&completed))
+	require.Equal(t, "request completed", completed["msg"])
+	require.Equal(t, "completion-test", completed["request_id"])
+	require.Equal(t, http.MethodPost, completed["method"])
+	require.Equal(t, "/signup", completed["path"])
+	require.Equal(t, "192.0.2.10", completed["remote_addr"])
+	require.Equal(t, float64(http.StatusTeapot), completed["status"])
+	require.Contains(t, completed, "duration")
+}
+
+func TestNewFormatter(t *testing.T) {
+	formatter, err := NewFormatter(&conf.LoggingConfig{})
+	require.NoError(t, err)
+	require.IsType(t, &CustomFormatter{}, formatter)
+
+	formatter, err = NewFormatter(&conf.LoggingConfig{Format: "text"})
+	require.NoError(t, err)
+	require.IsType(t, &logrus.TextFormatter{}, formatter)
+
+	_, err = NewFormatter(&conf.LoggingConfig{Format: "xml"})
+	require.Error(t, err)
+}
@@ -2,6 +2,7 @@ package observability
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
@@ -72,6 +73,11 @@ func (w *interceptingResponseWriter) WriteHeader(statusCode int) {
 }
 
 func (w *interceptingResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		// the status is implicitly 200 when the header isn't written first
+		w.statusCode = http.StatusOK
+	}
+
 	return w.writer.Write(data)
 }
 
@@ -79,6 +85,12 @@ func (w *interceptingResponseWriter) Header() http.Header {
 	return w.writer.Header()
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses.
+func (w *interceptingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.writer
+}
+
 // countStatusCodesSafely counts the number of HTTP status codes per route that
 // occurred while GoTrue was running. If it is not able to identify the route
 // via chi.RouteContext(ctx).RoutePattern() it counts with a noroute attribute.
@@ -112,6 +124,41 @@ func countStatusCodesSafely(w *interceptingResponseWriter, r *http.Request, coun
 	)
 }
 
+// measureRequestDurationSafely records how long the request took per route
+// and status code. If it is not able to identify the route it records with a
+// noroute attribute.
+func measureRequestDurationSafely(w *interceptingResponseWriter, r *http.Request, start time.Time, histogram metric.Float64Histogram) {
+	if histogram == nil {
+		return
+	}
+
+	duration := time.Since(start).Seconds()
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			logrus.WithField("error", rec).Error("unable to measure request duration safely, metrics may be off")
+			histogram.Record(
+				r.Context(),
+				duration,
+				metric.WithAttributes(
+					attribute.Bool("noroute", true),
+					attribute.Int("code", w.statusCode)),
+			)
+		}
+	}()
+
+	ctx := r.Context()
+
+	routeContext := chi.RouteContext(ctx)
+	routePattern := semconv.HTTPRouteKey.String(routeContext.RoutePattern())
+
+	histogram.Record(
+		ctx,
+		duration,
+		metric.WithAttributes(attribute.Int("code", w.statusCode), routePattern),
+	)
+}
+
 // RequestTracing returns an HTTP handler that traces all HTTP requests coming
 // in. Supports Chi routers, so this should be one of the first middlewares on
 // the router.
@@ -125,15 +172,27 @@ func RequestTracing() func(http.Handler) http.Handler {
 		logrus.WithError(err).Error("unable to get gotrue.http_status_codes counter metric")
 	}
 
+	requestDurations, err := meter.Float64Histogram(
+		"gotrue_http_request_duration",
+		metric.WithDescription("Duration of HTTP requests by route and status code"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10),
+	)
+	if err != nil {
+		logrus.WithError(err).Error("unable to get gotrue.gotrue_http_request_duration histogram metric")
+	}
+
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			writer := interceptingResponseWriter{
 				writer: w,
 			}
+			start := time.Now()
 
 			defer traceChiRoutesSafely(r)
 			defer traceChiRouteURLParamsSafely(r)
 			defer countStatusCodesSafely(&writer, r, statusCodes)
+			defer measureRequestDurationSafely(&writer, r, start, requestDurations)
 
 			originalUserAgent := r.Header.Get("X-Gotrue-Original-User-Agent")
 			if originalUserAgent != "" {
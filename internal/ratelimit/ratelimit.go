@@ -0,0 +1,82 @@
+// Package ratelimit counts requests per key in fixed time windows.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter records requests and decides whether they are within a limit.
+//
+// The in-memory implementation keeps counts per process, which suits a
+// single instance. Deployments running several instances can provide an
+// implementation that shares the counts, for example in the database.
+type Limiter interface {
+	// Allow records a request for key and reports whether it is one of the
+	// first limit requests in the current window. When it isn't, retryAfter
+	// is the time until the window ends.
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// sweepInterval is how often expired windows are removed from memory.
+const sweepInterval = time.Minute
+
+type memoryWindow struct {
+	end   time.Time
+	count int
+}
+
+// MemoryLimiter is a Limiter that keeps the counts in memory.
+type MemoryLimiter struct {
+	mutex     sync.Mutex
+	windows   map[string]*memoryWindow
+	lastSweep time.Time
+
+	now func() time.Time
+}
+
+// NewMemoryLimiter creates a MemoryLimiter.
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		windows: make(map[string]*memoryWindow),
+		now:     time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok || !now.Before(w.end) {
+		w = &memoryWindow{end: now.Add(window)}
+		l.windows[key] = w
+	}
+
+	if w.count >= limit {
+		return false, w.end.Sub(now), nil
+	}
+
+	w.count++
+	return true, 0, nil
+}
+
+// sweep removes expired windows so that the memory used is bounded by the
+// number of keys seen recently.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, w := range l.windows {
+		if !now.Before(w.end) {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := NewMemoryLimiter()
+	l.now = func() time.Time {
+		return now
+	}
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := l.Allow(ctx, "a", 3, time.Minute)
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+
+	now = now.Add(20 * time.Second)
+
+	allowed, retryAfter, err := l.Allow(ctx, "a", 3, time.Minute)
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Equal(t, 40*time.Second, retryAfter)
+
+	// other keys are counted separately
+	allowed, _, err = l.Allow(ctx, "b", 3, time.Minute)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	// a new window starts once the previous one has ended
+	now = now.Add(40 * time.Second)
+
+	allowed, _, err = l.Allow(ctx, "a", 3, time.Minute)
+	require.NoError(t, err)
+	require.True(t, allowed)
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := NewMemoryLimiter()
+	l.now = func() time.Time {
+		return now
+	}
+
+	ctx := context.Background()
+
+	_, _, err := l.Allow(ctx, "a", 1, time.Second)
+	require.NoError(t, err)
+	_, _, err = l.Allow(ctx, "b", 1, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, l.windows, 2)
+
+	now = now.Add(sweepInterval)
+
+	_, _, err = l.Allow(ctx, "c", 1, time.Second)
+	require.NoError(t, err)
+	require.Len(t, l.windows, 2)
+	require.Contains(t, l.windows, "b")
+	require.Contains(t, l.windows, "c")
+}
@@ -1,17 +1,17 @@
 package security
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/conf"
 	"github.com/supabase/auth/internal/utilities"
 )
 
@@ -29,23 +29,14 @@ type VerificationResponse struct {
 	Hostname   string   `json:"hostname"`
 }
 
-var Client *http.Client
+// DefaultTimeout is used when the configuration does not specify how long to
+// wait for the CAPTCHA provider.
+const DefaultTimeout = 10 * time.Second
 
-func init() {
-	var defaultTimeout time.Duration = time.Second * 10
-	timeoutStr := os.Getenv("GOTRUE_SECURITY_CAPTCHA_TIMEOUT")
-	if timeoutStr != "" {
-		if timeout, err := time.ParseDuration(timeoutStr); err != nil {
-			log.Fatalf("error loading GOTRUE_SECURITY_CAPTCHA_TIMEOUT: %v", err.Error())
-		} else if timeout != 0 {
-			defaultTimeout = timeout
-		}
-	}
+// ErrMissingToken is returned when the request carries no captcha_token.
+var ErrMissingToken = errors.New("no captcha response (captcha_token) found in request")
 
-	Client = &http.Client{Timeout: defaultTimeout}
-}
-
-func VerifyRequest(r *http.Request, secretKey, captchaProvider string) (VerificationResponse, error) {
+func VerifyRequest(r *http.Request, config *conf.CaptchaConfiguration) (VerificationResponse, error) {
 	bodyBytes, err := utilities.GetBodyBytes(r)
 	if err != nil {
 		return VerificationResponse{}, err
@@ -60,32 +51,43 @@ func VerifyRequest(r *http.Request, secretKey, captchaProvider string) (Verifica
 	captchaResponse := strings.TrimSpace(requestBody.Security.Token)
 
 	if captchaResponse == "" {
-		return VerificationResponse{}, errors.New("no captcha response (captcha_token) found in request")
+		return VerificationResponse{}, ErrMissingToken
 	}
 
-	clientIP := utilities.GetIPAddress(r)
-	captchaURL, err := GetCaptchaURL(captchaProvider)
-	if err != nil {
-		return VerificationResponse{}, err
+	captchaURL := config.VerifyURL
+	if captchaURL == "" {
+		captchaURL, err = GetCaptchaURL(config.Provider)
+		if err != nil {
+			return VerificationResponse{}, err
+		}
 	}
 
-	return verifyCaptchaCode(captchaResponse, secretKey, clientIP, captchaURL)
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	clientIP := utilities.GetClientIP(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	return verifyCaptchaCode(ctx, captchaResponse, strings.TrimSpace(config.Secret), clientIP, captchaURL)
 }
 
-func verifyCaptchaCode(token, secretKey, clientIP, captchaURL string) (VerificationResponse, error) {
+func verifyCaptchaCode(ctx context.Context, token, secretKey, clientIP, captchaURL string) (VerificationResponse, error) {
 	data := url.Values{}
 	data.Set("secret", secretKey)
 	data.Set("response", token)
 	data.Set("remoteip", clientIP)
 	// TODO (darora): pipe through sitekey
 
-	r, err := http.NewRequest("POST", captchaURL, strings.NewReader(data.Encode()))
+	r, err := http.NewRequestWithContext(ctx, "POST", captchaURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return VerificationResponse{}, errors.Wrap(err, "couldn't initialize request object for captcha check")
 	}
 	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
-	res, err := Client.Do(r)
+	res, err := http.DefaultClient.Do(r)
 	if err != nil {
 		return VerificationResponse{}, errors.Wrap(err, "failed to verify captcha response")
 	}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"net/url"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/XSAM/otelsql"
@@ -30,6 +32,7 @@ func Dial(config *conf.GlobalConfiguration) (*Connection, error) {
 		}
 		config.DB.Driver = u.Scheme
 	}
+	config.DB.Driver = normalizeDialect(config.DB.Driver)
 
 	driver := ""
 	if config.DB.Driver != "postgres" {
@@ -55,6 +58,14 @@ func Dial(config *conf.GlobalConfiguration) (*Connection, error) {
 		}
 	}
 
+	if driver != "" {
+		resilientDriver, err := registerResilientDriver(driver, config.DB.QueryTimeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "registering database driver")
+		}
+		driver = resilientDriver
+	}
+
 	options := make(map[string]string)
 
 	if config.DB.HealthCheckPeriod != time.Duration(0) {
@@ -89,6 +100,17 @@ func Dial(config *conf.GlobalConfiguration) (*Connection, error) {
 	return &Connection{db}, nil
 }
 
+// normalizeDialect maps the aliases of a database, such as the postgresql
+// URL scheme, to the dialect name pop uses for it.
+func normalizeDialect(name string) string {
+	switch strings.ToLower(name) {
+	case "postgres", "postgresql", "pg":
+		return "postgres"
+	}
+
+	return name
+}
+
 func registerOpenTelemetryDatabaseStats(db *pop.Connection) {
 	defer func() {
 		if rec := recover(); rec != nil {
@@ -135,7 +157,11 @@ func NewCommitWithError(err error) *CommitWithError {
 func (c *Connection) Transaction(fn func(*Connection) error) error {
 	if c.TX == nil {
 		var returnErr error
+		var hooks []func()
 		if terr := c.Connection.Transaction(func(tx *pop.Connection) error {
+			afterCommitHooks.Store(tx.TX, &hooks)
+			defer afterCommitHooks.Delete(tx.TX)
+
 			err := fn(&Connection{tx})
 			switch err.(type) {
 			case *CommitWithError:
@@ -147,11 +173,37 @@ func (c *Connection) Transaction(fn func(*Connection) error) error {
 		}); terr != nil {
 			return terr
 		}
+		for _, hook := range hooks {
+			hook()
+		}
 		return returnErr
 	}
 	return fn(c)
 }
 
+// afterCommitHooks holds the hooks of the open transactions, the connections
+// of a transaction share its *pop.Tx.
+var afterCommitHooks sync.Map
+
+// AfterCommit runs fn once the transaction of the connection is committed,
+// it's dropped if the transaction is rolled back. Outside of transactions fn
+// runs right away.
+func (c *Connection) AfterCommit(fn func()) {
+	if c.TX != nil {
+		if hooks, ok := afterCommitHooks.Load(c.TX); ok {
+			hooks := hooks.(*[]func())
+			*hooks = append(*hooks, fn)
+			return
+		}
+	}
+	fn()
+}
+
+// Ping checks that the database can be reached.
+func (c *Connection) Ping(ctx context.Context) error {
+	return c.WithContext(ctx).RawQuery("select 1").Exec()
+}
+
 // WithContext returns a new connection with an updated context. This is
 // typically used for tracing as the context contains trace span information.
 func (c *Connection) WithContext(ctx context.Context) *Connection {
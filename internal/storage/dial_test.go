@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -29,6 +30,13 @@ func TestGetExcludedColumns_InvalidName(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestNormalizeDialect(t *testing.T) {
+	require.Equal(t, "postgres", normalizeDialect("postgres"))
+	require.Equal(t, "postgres", normalizeDialect("postgresql"))
+	require.Equal(t, "postgres", normalizeDialect("PostgreSQL"))
+	require.Equal(t, "mysql", normalizeDialect("mysql"))
+}
+
 func TestTransaction(t *testing.T) {
 	apiTestConfig := "../../hack/test.env"
 	config, err := conf.LoadGlobal(apiTestConfig)
@@ -58,3 +66,70 @@ func TestTransaction(t *testing.T) {
 	require.NoError(t, err)
 	require.Empty(t, data)
 }
+
+func TestReconnect(t *testing.T) {
+	config, err := conf.LoadGlobal("../../hack/test.env")
+	require.NoError(t, err)
+	conn, err := Dial(config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	admin, err := Dial(config)
+	require.NoError(t, err)
+	defer admin.Close()
+
+	type Backend struct {
+		PID int `db:"pid"`
+	}
+
+	backend := &Backend{}
+	require.NoError(t, conn.RawQuery("select pg_backend_pid() as pid").First(backend))
+
+	// drop the connection, as a failover of the database does
+	require.NoError(t, admin.RawQuery("select pg_terminate_backend(?)", backend.PID).Exec())
+
+	newBackend := &Backend{}
+	require.NoError(t, conn.RawQuery("select pg_backend_pid() as pid").First(newBackend))
+	require.NotEqual(t, backend.PID, newBackend.PID)
+	require.NoError(t, conn.Ping(context.Background()))
+}
+
+func TestAfterCommit(t *testing.T) {
+	config, err := conf.LoadGlobal("../../hack/test.env")
+	require.NoError(t, err)
+	conn, err := Dial(config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var ran []string
+	hook := func(name string) func() {
+		return func() {
+			ran = append(ran, name)
+		}
+	}
+
+	require.NoError(t, conn.Transaction(func(tx *Connection) error {
+		tx.AfterCommit(hook("committed"))
+
+		// nested transactions and connections with another context run
+		// their hooks with the outer transaction
+		require.NoError(t, tx.Transaction(func(tx *Connection) error {
+			tx.WithContext(context.Background()).AfterCommit(hook("nested"))
+			return nil
+		}))
+
+		require.Empty(t, ran)
+		return nil
+	}))
+	require.Equal(t, []string{"committed", "nested"}, ran)
+
+	ran = nil
+	require.Error(t, conn.Transaction(func(tx *Connection) error {
+		tx.AfterCommit(hook("rolled back"))
+		return errors.New("rollback")
+	}))
+	require.Empty(t, ran)
+
+	conn.AfterCommit(hook("no transaction"))
+	require.Equal(t, []string{"no transaction"}, ran)
+}
@@ -0,0 +1,372 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	resilientDriversMu sync.Mutex
+	resilientDrivers   = make(map[string]bool)
+)
+
+// registerResilientDriver registers a driver wrapping the driver name, see
+// resilientConn, and returns its name.
+func registerResilientDriver(name string, queryTimeout time.Duration) (string, error) {
+	resilientDriversMu.Lock()
+	defer resilientDriversMu.Unlock()
+
+	key := fmt.Sprintf("%s-resilient-%s", name, queryTimeout)
+	if resilientDrivers[key] {
+		return key, nil
+	}
+
+	// sql.Open doesn't connect, it's only used to find the driver
+	db, err := sql.Open(name, "")
+	if err != nil {
+		return "", err
+	}
+	inner := db.Driver()
+	if err := db.Close(); err != nil {
+		return "", err
+	}
+
+	sql.Register(key, &resilientDriver{Driver: inner, queryTimeout: queryTimeout})
+	// sqlx needs to be informed that the wrapping driver has the same
+	// semantics as the wrapped driver
+	sqlx.BindDriver(key, sqlx.BindType(name))
+
+	resilientDrivers[key] = true
+	return key, nil
+}
+
+type resilientDriver struct {
+	driver.Driver
+	queryTimeout time.Duration
+}
+
+func (d *resilientDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.wrap(conn)
+}
+
+func (d *resilientDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.Driver.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &resilientConnector{Connector: connector, driver: d}, nil
+	}
+
+	return &resilientConnector{name: name, driver: d}, nil
+}
+
+func (d *resilientDriver) wrap(conn driver.Conn) (driver.Conn, error) {
+	for _, ok := range []bool{
+		implements[driver.ExecerContext](conn),
+		implements[driver.QueryerContext](conn),
+		implements[driver.ConnBeginTx](conn),
+		implements[driver.ConnPrepareContext](conn),
+	} {
+		if !ok {
+			conn.Close()
+			return nil, fmt.Errorf("driver connection %T doesn't support contexts", conn)
+		}
+	}
+
+	return &resilientConn{Conn: conn, queryTimeout: d.queryTimeout}, nil
+}
+
+func implements[T any](v any) bool {
+	_, ok := v.(T)
+	return ok
+}
+
+type resilientConnector struct {
+	driver.Connector
+
+	// name is used to open connections of drivers without a connector
+	name   string
+	driver *resilientDriver
+}
+
+func (c *resilientConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	var err error
+	if c.Connector != nil {
+		conn, err = c.Connector.Connect(ctx)
+	} else {
+		conn, err = c.driver.Driver.Open(c.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.driver.wrap(conn)
+}
+
+func (c *resilientConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// resilientConn is a database connection that survives the database going
+// away, such as on a failover, and limits how long each statement runs.
+//
+// A statement that fails outside of a transaction because the connection
+// broke before it was sent fails with driver.ErrBadConn, so that database/sql
+// discards the connection and runs the statement again on another one
+// instead of returning the error. Statements that may have reached the
+// database aren't run again, they could be applied twice. Inside of a
+// transaction the error is returned as is, as the transaction is lost with
+// the connection.
+type resilientConn struct {
+	driver.Conn
+
+	queryTimeout time.Duration
+	inTx         bool
+}
+
+func (c *resilientConn) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.queryTimeout)
+}
+
+// checkErr returns driver.ErrBadConn for errors of statements that can run
+// again on another connection.
+func (c *resilientConn) checkErr(ctx context.Context, err error) error {
+	if err != nil && !c.inTx && ctx.Err() == nil && isSafeToRetry(err) {
+		return driver.ErrBadConn
+	}
+	return err
+}
+
+// checkSetupErr is checkErr for calls without effects on the database, such
+// as preparing statements, which can always run again if the connection
+// broke.
+func (c *resilientConn) checkSetupErr(ctx context.Context, err error) error {
+	if err != nil && !c.inTx && ctx.Err() == nil && isBrokenConnection(err) {
+		return driver.ErrBadConn
+	}
+	return err
+}
+
+func (c *resilientConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	result, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	return result, c.checkErr(ctx, err)
+}
+
+func (c *resilientConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, cancel := c.withTimeout(ctx)
+
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	if err != nil {
+		cancel()
+		return nil, c.checkErr(ctx, err)
+	}
+	return &resilientRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (c *resilientConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, c.checkSetupErr(ctx, err)
+	}
+	return &resilientStmt{Stmt: stmt, conn: c}, nil
+}
+
+func (c *resilientConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, c.checkSetupErr(ctx, err)
+	}
+	c.inTx = true
+	return &resilientTx{Tx: tx, conn: c}, nil
+}
+
+func (c *resilientConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return c.checkSetupErr(ctx, pinger.Ping(ctx))
+	}
+	return nil
+}
+
+func (c *resilientConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *resilientConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *resilientConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type resilientTx struct {
+	driver.Tx
+	conn *resilientConn
+}
+
+func (tx *resilientTx) Commit() error {
+	tx.conn.inTx = false
+	return tx.Tx.Commit()
+}
+
+func (tx *resilientTx) Rollback() error {
+	tx.conn.inTx = false
+	return tx.Tx.Rollback()
+}
+
+// resilientStmt is a prepared statement of a resilientConn.
+type resilientStmt struct {
+	driver.Stmt
+	conn *resilientConn
+}
+
+func (s *resilientStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, cancel := s.conn.withTimeout(ctx)
+	defer cancel()
+
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		values, verr := namedValuesToValues(args)
+		if verr != nil {
+			return nil, verr
+		}
+		result, err = s.Stmt.Exec(values)
+	}
+	return result, s.conn.checkErr(ctx, err)
+}
+
+func (s *resilientStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, cancel := s.conn.withTimeout(ctx)
+
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		values, verr := namedValuesToValues(args)
+		if verr != nil {
+			cancel()
+			return nil, verr
+		}
+		rows, err = s.Stmt.Query(values)
+	}
+	if err != nil {
+		cancel()
+		return nil, s.conn.checkErr(ctx, err)
+	}
+	return &resilientRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (s *resilientStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("driver doesn't support named parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+// resilientRows ends the query timeout once the rows are closed.
+type resilientRows struct {
+	driver.Rows
+	cancel context.CancelFunc
+}
+
+func (r *resilientRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+// isSafeToRetry reports whether the statement that failed with err didn't
+// reach the database, or was rejected by it without effects, so that it can
+// run again on another connection.
+func isSafeToRetry(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	// pgconn.SafeToRetry doesn't look at wrapped errors
+	var notSentErr interface{ SafeToRetry() bool }
+	if errors.As(err, &notSentErr) {
+		return notSentErr.SafeToRetry()
+	}
+
+	// the server was shut down or restarted, or is starting up, and aborted
+	// the statement
+	return isServerUnavailable(err)
+}
+
+// isBrokenConnection reports whether err means that the connection to the
+// database broke, rather than the statement failing. The statement may have
+// reached the database before.
+func isBrokenConnection(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	return isServerUnavailable(err)
+}
+
+// isServerUnavailable reports whether err is the server being shut down or
+// restarted, or starting up.
+func isServerUnavailable(err error) bool {
+	var sqlStateErr interface{ SQLState() string }
+	if errors.As(err, &sqlStateErr) {
+		return strings.HasPrefix(sqlStateErr.SQLState(), "57P")
+	}
+
+	return false
+}
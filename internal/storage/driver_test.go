@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDriver is a database driver whose connections can be dropped.
+type fakeDriver struct {
+	mu       sync.Mutex
+	conns    []*fakeConn
+	executed []string
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	conn := &fakeConn{driver: d}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+// dropConnections breaks all of the open connections, like a database
+// failover does.
+func (d *fakeDriver) dropConnections() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, conn := range d.conns {
+		conn.broken = true
+	}
+}
+
+func (d *fakeDriver) opened() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.conns)
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+	broken bool
+}
+
+// errNotSent is the error of statements on broken connections, they fail
+// before being sent like pgconn's errors that are safe to retry.
+type errNotSent struct {
+	err error
+}
+
+func (e *errNotSent) Error() string {
+	return "write: " + e.err.Error()
+}
+
+func (e *errNotSent) Unwrap() error {
+	return e.err
+}
+
+func (e *errNotSent) SafeToRetry() bool {
+	return true
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *fakeConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.broken {
+		return nil, fmt.Errorf("write: %w", syscall.EPIPE)
+	}
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.broken {
+		return nil, fmt.Errorf("write: %w", syscall.EPIPE)
+	}
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.broken {
+		return nil, &errNotSent{syscall.EPIPE}
+	}
+
+	switch query {
+	case "sleep":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case "invalid":
+		c.driver.executed = append(c.driver.executed, query)
+		return nil, errors.New("syntax error")
+	case "insert and drop":
+		// the connection breaks after the statement was sent
+		c.driver.executed = append(c.driver.executed, query)
+		c.broken = true
+		return nil, fmt.Errorf("read: %w", io.ErrUnexpectedEOF)
+	}
+
+	c.driver.executed = append(c.driver.executed, query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.broken {
+		return nil, &errNotSent{syscall.EPIPE}
+	}
+
+	c.driver.executed = append(c.driver.executed, query)
+	return &fakeRows{}, nil
+}
+
+func (c *fakeConn) IsValid() bool {
+	return !c.broken
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, nil)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, nil)
+}
+
+type fakeRows struct{}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"n"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
+
+var fakeDrivers atomic.Int32
+
+func openFakeDatabase(t *testing.T, queryTimeout time.Duration) (*sql.DB, *fakeDriver) {
+	fake := &fakeDriver{}
+	name := fmt.Sprintf("fake-%d", fakeDrivers.Add(1))
+	sql.Register(name, fake)
+
+	resilientName, err := registerResilientDriver(name, queryTimeout)
+	require.NoError(t, err)
+
+	db, err := sql.Open(resilientName, "")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	return db, fake
+}
+
+func TestResilientDriverReconnects(t *testing.T) {
+	db, fake := openFakeDatabase(t, 0)
+
+	_, err := db.Exec("insert 1")
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.opened())
+
+	fake.dropConnections()
+
+	_, err = db.Exec("insert 2")
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.opened())
+
+	fake.dropConnections()
+
+	rows, err := db.Query("select 1")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.Equal(t, 3, fake.opened())
+
+	require.Equal(t, []string{"insert 1", "insert 2", "select 1"}, fake.executed)
+}
+
+func TestResilientDriverPreparedStatements(t *testing.T) {
+	db, fake := openFakeDatabase(t, 0)
+
+	stmt, err := db.Prepare("insert 1")
+	require.NoError(t, err)
+	defer stmt.Close()
+
+	_, err = stmt.Exec()
+	require.NoError(t, err)
+
+	// the statement is prepared again on a new connection
+	fake.dropConnections()
+	_, err = stmt.Exec()
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.opened())
+
+	require.Equal(t, []string{"insert 1", "insert 1"}, fake.executed)
+}
+
+func TestResilientDriverStatementSent(t *testing.T) {
+	db, fake := openFakeDatabase(t, 0)
+
+	// statements that may have reached the database aren't run again
+	_, err := db.Exec("insert and drop")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Equal(t, []string{"insert and drop"}, fake.executed)
+	require.Equal(t, 1, fake.opened())
+
+	// but the broken connection is replaced
+	_, err = db.Exec("insert 1")
+	require.NoError(t, err)
+	require.Equal(t, 2, fake.opened())
+}
+
+func TestResilientDriverTransaction(t *testing.T) {
+	db, fake := openFakeDatabase(t, 0)
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+
+	// the transaction is lost with the connection
+	fake.dropConnections()
+	_, err = tx.Exec("insert 1")
+	require.ErrorIs(t, err, syscall.EPIPE)
+	require.NoError(t, tx.Rollback())
+
+	// but later statements use a new connection
+	_, err = db.Exec("insert 2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"insert 2"}, fake.executed)
+}
+
+func TestResilientDriverErrors(t *testing.T) {
+	db, fake := openFakeDatabase(t, 0)
+
+	// failed statements aren't run again
+	_, err := db.Exec("invalid")
+	require.EqualError(t, err, "syntax error")
+	require.Equal(t, []string{"invalid"}, fake.executed)
+	require.Equal(t, 1, fake.opened())
+}
+
+func TestResilientDriverQueryTimeout(t *testing.T) {
+	db, fake := openFakeDatabase(t, 50*time.Millisecond)
+
+	start := time.Now()
+	_, err := db.Exec("sleep")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, 1, fake.opened())
+
+	_, err = db.Exec("insert 1")
+	require.NoError(t, err)
+}
+
+func TestIsSafeToRetry(t *testing.T) {
+	require.True(t, isSafeToRetry(&errNotSent{syscall.EPIPE}))
+	require.True(t, isSafeToRetry(fmt.Errorf("exec: %w", &errNotSent{syscall.EPIPE})))
+	require.True(t, isSafeToRetry(driver.ErrBadConn))
+	require.True(t, isSafeToRetry(sqlStateError("57P01")))
+
+	require.False(t, isSafeToRetry(fmt.Errorf("write: %w", syscall.EPIPE)))
+	require.False(t, isSafeToRetry(fmt.Errorf("read: %w", syscall.ECONNRESET)))
+	require.False(t, isSafeToRetry(io.ErrUnexpectedEOF))
+	require.False(t, isSafeToRetry(sqlStateError("23505")))
+}
+
+func TestIsBrokenConnection(t *testing.T) {
+	require.True(t, isBrokenConnection(fmt.Errorf("write: %w", syscall.EPIPE)))
+	require.True(t, isBrokenConnection(fmt.Errorf("read: %w", syscall.ECONNRESET)))
+	require.True(t, isBrokenConnection(io.ErrUnexpectedEOF))
+	require.True(t, isBrokenConnection(driver.ErrBadConn))
+	require.True(t, isBrokenConnection(sqlStateError("57P01")))
+
+	require.False(t, isBrokenConnection(errors.New("syntax error")))
+	require.False(t, isBrokenConnection(sqlStateError("23505")))
+	require.False(t, isBrokenConnection(context.DeadlineExceeded))
+}
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string {
+	return "sql error " + string(e)
+}
+
+func (e sqlStateError) SQLState() string {
+	return string(e)
+}
@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/pkg/errors"
+	"github.com/supabase/auth/internal/conf"
+)
+
+// MigrationTableName is the table recording the applied migrations.
+const MigrationTableName = "schema_migrations"
+
+// migrationLockKey identifies the advisory lock held while migrating, so
+// that instances starting at the same time don't migrate concurrently.
+const migrationLockKey int64 = 0x676f74727565 // "gotrue"
+
+// MigrationStatus describes one migration.
+type MigrationStatus struct {
+	Version string
+	Name    string
+	Applied bool
+
+	// Reversible is set when the migration has a down migration.
+	Reversible bool
+}
+
+// Migrator applies and rolls back the migrations in DB_MIGRATIONS_PATH.
+type Migrator struct {
+	conn  *pop.Connection
+	files pop.FileMigrator
+}
+
+// NewMigrator connects to the database with a connection of its own, which
+// holds the migration lock.
+func NewMigrator(config *conf.GlobalConfiguration) (*Migrator, error) {
+	return newMigrator(config, MigrationTableName)
+}
+
+func newMigrator(config *conf.GlobalConfiguration, tableName string) (*Migrator, error) {
+	u, err := url.Parse(config.DB.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing db connection url")
+	}
+
+	dialect := config.DB.Driver
+	if dialect == "" {
+		dialect = u.Scheme
+	}
+
+	query := u.Query()
+	query.Set("application_name", "gotrue_migrations")
+	u.RawQuery = query.Encode()
+
+	conn, err := pop.NewConnection(&pop.ConnectionDetails{
+		Dialect: normalizeDialect(dialect),
+		URL:     u.String(),
+		// the advisory lock belongs to the session, so all statements
+		// have to use the same connection
+		Pool:     1,
+		IdlePool: 1,
+		Options: map[string]string{
+			"migration_table_name": tableName,
+			"Namespace":            config.DB.Namespace,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "opening db connection")
+	}
+
+	if err := conn.Open(); err != nil {
+		return nil, errors.Wrap(err, "checking database connection")
+	}
+
+	files, err := pop.NewFileMigrator(config.DB.MigrationsPath, conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "reading migrations")
+	}
+
+	return &Migrator{conn: conn, files: files}, nil
+}
+
+// Close closes the migrator's database connection.
+func (m *Migrator) Close() error {
+	return m.conn.Close()
+}
+
+// withLock runs fn while holding the migration lock.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pop.Connection) error) error {
+	conn := m.conn.WithContext(ctx)
+
+	if err := conn.RawQuery("select pg_advisory_lock(?)", migrationLockKey).Exec(); err != nil {
+		return errors.Wrap(err, "acquiring migration lock")
+	}
+	defer func() {
+		// the lock is released with the session if this fails
+		_ = m.conn.RawQuery("select pg_advisory_unlock(?)", migrationLockKey).Exec()
+	}()
+
+	if err := pop.CreateSchemaMigrations(conn); err != nil {
+		return errors.Wrap(err, "creating migration table")
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) appliedVersions(conn *pop.Connection) (map[string]bool, error) {
+	var versions []string
+	if err := conn.Store.Select(&versions, "select version from "+conn.MigrationTableName()); err != nil {
+		return nil, errors.Wrap(err, "reading applied migrations")
+	}
+
+	applied := make(map[string]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+
+	return applied, nil
+}
+
+// Status lists the migrations from oldest to newest.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *pop.Connection) error {
+		applied, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+
+		statuses = migrationStatuses(m.compatible(m.files.UpMigrations.Migrations), m.compatible(m.files.DownMigrations.Migrations), applied)
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Up applies the pending migrations, each in a transaction of its own, and
+// returns their versions.
+func (m *Migrator) Up(ctx context.Context) ([]string, error) {
+	var versions []string
+	err := m.withLock(ctx, func(conn *pop.Connection) error {
+		pending, err := m.pending(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			if err := conn.Transaction(func(tx *pop.Connection) error {
+				if err := migration.Run(tx); err != nil {
+					return err
+				}
+				return tx.RawQuery("insert into "+tx.MigrationTableName()+" (version) values (?)", migration.Version).Exec()
+			}); err != nil {
+				return errors.Wrapf(err, "applying migration %s_%s", migration.Version, migration.Name)
+			}
+
+			versions = append(versions, migration.Version)
+		}
+
+		return nil
+	})
+
+	return versions, err
+}
+
+// Plan writes the SQL of the pending migrations to w without applying them.
+func (m *Migrator) Plan(ctx context.Context, w io.Writer) error {
+	return m.withLock(ctx, func(conn *pop.Connection) error {
+		pending, err := m.pending(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			content, err := migrationContent(migration, conn)
+			if err != nil {
+				return err
+			}
+
+			if _, err := fmt.Fprintf(w, "-- %s_%s\n%s\n", migration.Version, migration.Name, content); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down rolls back the last steps applied migrations, newest first, and
+// returns their versions. Nothing is rolled back unless all of them have a
+// down migration.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]string, error) {
+	if steps <= 0 {
+		return nil, errors.New("the number of migrations to roll back must be positive")
+	}
+
+	var versions []string
+	err := m.withLock(ctx, func(conn *pop.Connection) error {
+		applied, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+
+		downs := make(map[string]pop.Migration)
+		for _, migration := range m.compatible(m.files.DownMigrations.Migrations) {
+			downs[migration.Version] = migration
+		}
+
+		var latest []string
+		for version := range applied {
+			latest = append(latest, version)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(latest)))
+		if steps < len(latest) {
+			latest = latest[:steps]
+		}
+
+		for _, version := range latest {
+			if _, ok := downs[version]; !ok {
+				return fmt.Errorf("migration %s can't be rolled back, it has no down migration", version)
+			}
+		}
+
+		for _, version := range latest {
+			migration := downs[version]
+			if err := conn.Transaction(func(tx *pop.Connection) error {
+				if err := migration.Run(tx); err != nil {
+					return err
+				}
+				return tx.RawQuery("delete from "+tx.MigrationTableName()+" where version = ?", version).Exec()
+			}); err != nil {
+				return errors.Wrapf(err, "rolling back migration %s_%s", migration.Version, migration.Name)
+			}
+
+			versions = append(versions, version)
+		}
+
+		return nil
+	})
+
+	return versions, err
+}
+
+func (m *Migrator) pending(conn *pop.Connection) ([]pop.Migration, error) {
+	applied, err := m.appliedVersions(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []pop.Migration
+	for _, migration := range sortedMigrations(m.compatible(m.files.UpMigrations.Migrations)) {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+func (m *Migrator) compatible(migrations pop.Migrations) pop.Migrations {
+	var compatible pop.Migrations
+	for _, migration := range migrations {
+		if migration.DBType == "all" || migration.DBType == m.conn.Dialect.Name() {
+			compatible = append(compatible, migration)
+		}
+	}
+
+	return compatible
+}
+
+func sortedMigrations(migrations pop.Migrations) pop.Migrations {
+	sorted := append(pop.Migrations(nil), migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return sorted
+}
+
+// migrationStatuses combines the up and down migrations with the applied
+// versions.
+func migrationStatuses(ups, downs pop.Migrations, applied map[string]bool) []MigrationStatus {
+	reversible := make(map[string]bool, len(downs))
+	for _, migration := range downs {
+		reversible[migration.Version] = true
+	}
+
+	statuses := make([]MigrationStatus, 0, len(ups))
+	for _, migration := range sortedMigrations(ups) {
+		statuses = append(statuses, MigrationStatus{
+			Version:    migration.Version,
+			Name:       migration.Name,
+			Applied:    applied[migration.Version],
+			Reversible: reversible[migration.Version],
+		})
+	}
+
+	return statuses
+}
+
+// PrintMigrationStatus writes the statuses as a table.
+func PrintMigrationStatus(w io.Writer, statuses []MigrationStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "Version\tName\tStatus\tReversible")
+	for _, status := range statuses {
+		state := "Pending"
+		if status.Applied {
+			state = "Applied"
+		}
+
+		reversible := "no"
+		if status.Reversible {
+			reversible = "yes"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status.Version, status.Name, state, reversible)
+	}
+
+	return tw.Flush()
+}
+
+func migrationContent(migration pop.Migration, conn *pop.Connection) (string, error) {
+	f, err := os.Open(migration.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	content, err := pop.MigrationContent(migration, conn, f, true)
+	if err != nil {
+		return "", errors.Wrapf(err, "processing %s", migration.Path)
+	}
+
+	return content, nil
+}
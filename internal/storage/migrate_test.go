@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gobuffalo/pop/v6"
+	"github.com/stretchr/testify/require"
+	"github.com/supabase/auth/internal/conf"
+)
+
+func TestMigrationStatuses(t *testing.T) {
+	ups := pop.Migrations{
+		{Version: "20240102000000", Name: "second"},
+		{Version: "20240101000000", Name: "first"},
+		{Version: "20240103000000", Name: "third"},
+	}
+	downs := pop.Migrations{
+		{Version: "20240103000000", Name: "third"},
+	}
+	applied := map[string]bool{"20240101000000": true, "20240102000000": true}
+
+	statuses := migrationStatuses(ups, downs, applied)
+	require.Equal(t, []MigrationStatus{
+		{Version: "20240101000000", Name: "first", Applied: true},
+		{Version: "20240102000000", Name: "second", Applied: true},
+		{Version: "20240103000000", Name: "third", Reversible: true},
+	}, statuses)
+
+	var output bytes.Buffer
+	require.NoError(t, PrintMigrationStatus(&output, statuses))
+	require.Contains(t, output.String(), "20240102000000   second   Applied   no")
+	require.Contains(t, output.String(), "20240103000000   third    Pending   yes")
+}
+
+func writeTestMigration(t *testing.T, dir, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+}
+
+func setupMigratorForTest(t *testing.T) *conf.GlobalConfiguration {
+	config, err := conf.LoadGlobal("../../hack/test.env")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeTestMigration(t, dir, "99990101000000_create_migration_test_a.up.sql", `create table if not exists {{ index .Options "Namespace" }}.migration_test_a (id integer);`)
+	writeTestMigration(t, dir, "99990101000000_create_migration_test_a.down.sql", `drop table if exists {{ index .Options "Namespace" }}.migration_test_a;`)
+	writeTestMigration(t, dir, "99990102000000_create_migration_test_b.up.sql", `create table if not exists {{ index .Options "Namespace" }}.migration_test_b (id integer);`)
+	writeTestMigration(t, dir, "99990102000000_create_migration_test_b.down.sql", `drop table if exists {{ index .Options "Namespace" }}.migration_test_b;`)
+	config.DB.MigrationsPath = dir
+
+	conn, err := Dial(config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, conn.RawQuery("drop table if exists test_schema_migrations").Exec())
+		require.NoError(t, conn.RawQuery("drop table if exists "+config.DB.Namespace+".migration_test_a").Exec())
+		require.NoError(t, conn.RawQuery("drop table if exists "+config.DB.Namespace+".migration_test_b").Exec())
+		conn.Close()
+	})
+
+	return config
+}
+
+func newTestMigrator(t *testing.T, config *conf.GlobalConfiguration) *Migrator {
+	migrator, err := newMigrator(config, "test_schema_migrations")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		migrator.Close()
+	})
+
+	return migrator
+}
+
+func appliedCount(t *testing.T, migrator *Migrator) int {
+	statuses, err := migrator.Status(context.Background())
+	require.NoError(t, err)
+
+	count := 0
+	for _, status := range statuses {
+		if status.Applied {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	ctx := context.Background()
+	config := setupMigratorForTest(t)
+	migrator := newTestMigrator(t, config)
+
+	require.Equal(t, 0, appliedCount(t, migrator))
+
+	var plan bytes.Buffer
+	require.NoError(t, migrator.Plan(ctx, &plan))
+	require.Contains(t, plan.String(), "-- 99990101000000_create_migration_test_a")
+	require.Contains(t, plan.String(), "create table if not exists "+config.DB.Namespace+".migration_test_b")
+	// planning doesn't apply anything
+	require.Equal(t, 0, appliedCount(t, migrator))
+
+	versions, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"99990101000000", "99990102000000"}, versions)
+	require.Equal(t, 2, appliedCount(t, migrator))
+
+	// applying again is a no-op
+	versions, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Empty(t, versions)
+
+	versions, err = migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"99990102000000"}, versions)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []MigrationStatus{
+		{Version: "99990101000000", Name: "create_migration_test_a", Applied: true, Reversible: true},
+		{Version: "99990102000000", Name: "create_migration_test_b", Reversible: true},
+	}, statuses)
+
+	versions, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"99990102000000"}, versions)
+
+	versions, err = migrator.Down(ctx, 5)
+	require.NoError(t, err)
+	require.Equal(t, []string{"99990102000000", "99990101000000"}, versions)
+	require.Equal(t, 0, appliedCount(t, migrator))
+}
+
+func TestMigratorDownWithoutDownMigration(t *testing.T) {
+	ctx := context.Background()
+	config := setupMigratorForTest(t)
+	writeTestMigration(t, config.DB.MigrationsPath, "99990103000000_irreversible.up.sql", `select 1;`)
+	migrator := newTestMigrator(t, config)
+
+	_, err := migrator.Up(ctx)
+	require.NoError(t, err)
+
+	// nothing is rolled back when one of the migrations can't be
+	_, err = migrator.Down(ctx, 2)
+	require.Error(t, err)
+	require.Equal(t, 3, appliedCount(t, migrator))
+
+	_, err = migrator.Down(ctx, 0)
+	require.Error(t, err)
+}
+
+func TestMigratorConcurrentUp(t *testing.T) {
+	config := setupMigratorForTest(t)
+	migrators := []*Migrator{newTestMigrator(t, config), newTestMigrator(t, config)}
+
+	var wg sync.WaitGroup
+	applied := make([][]string, len(migrators))
+	errs := make([]error, len(migrators))
+	for i, migrator := range migrators {
+		wg.Add(1)
+		go func(i int, migrator *Migrator) {
+			defer wg.Done()
+			applied[i], errs[i] = migrator.Up(context.Background())
+		}(i, migrator)
+	}
+	wg.Wait()
+
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	// every migration was applied by exactly one of the instances
+	require.Len(t, append(applied[0], applied[1]...), 2)
+}
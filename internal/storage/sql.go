@@ -7,3 +7,37 @@ func (conn *Connection) UpdateOnly(model interface{}, includeColumns ...string)
 	}
 	return conn.Update(model, xcols...)
 }
+
+// SavepointError means that a savepoint could not be created, released or
+// rolled back to, after which the transaction can no longer be used.
+type SavepointError struct {
+	error
+}
+
+func (e *SavepointError) Unwrap() error {
+	return e.error
+}
+
+// Savepoint runs fn behind a savepoint of the transaction. When fn returns an
+// error, its writes are rolled back while the rest of the transaction can go
+// on, as a failed statement would otherwise abort the whole transaction in
+// PostgreSQL.
+func (conn *Connection) Savepoint(name string, fn func() error) error {
+	if err := conn.RawQuery("savepoint " + name).Exec(); err != nil {
+		return &SavepointError{err}
+	}
+
+	if err := fn(); err != nil {
+		if rerr := conn.RawQuery("rollback to savepoint " + name).Exec(); rerr != nil {
+			return &SavepointError{rerr}
+		}
+
+		return err
+	}
+
+	if err := conn.RawQuery("release savepoint " + name).Exec(); err != nil {
+		return &SavepointError{err}
+	}
+
+	return nil
+}
@@ -1,6 +1,9 @@
 package utilities
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type contextKey string
 
@@ -9,7 +12,8 @@ func (c contextKey) String() string {
 }
 
 const (
-	requestIDKey = contextKey("request_id")
+	requestIDKey      = contextKey("request_id")
+	trustedProxiesKey = contextKey("trusted_proxies")
 )
 
 // WithRequestID adds the provided request ID to the context.
@@ -26,3 +30,34 @@ func GetRequestID(ctx context.Context) string {
 
 	return obj.(string)
 }
+
+// WithTrustedProxies adds the proxies whose X-Forwarded-For entries are
+// trusted by GetClientIP to the context.
+func WithTrustedProxies(ctx context.Context, proxies *TrustedProxies) context.Context {
+	return context.WithValue(ctx, trustedProxiesKey, proxies)
+}
+
+// GetTrustedProxies reads the trusted proxies from the context.
+func GetTrustedProxies(ctx context.Context) *TrustedProxies {
+	obj := ctx.Value(trustedProxiesKey)
+	if obj == nil {
+		return nil
+	}
+
+	return obj.(*TrustedProxies)
+}
+
+// Sleep waits for d or until ctx is done, whichever comes first. It returns
+// the error of ctx if ctx is done first, so that retry loops stop waiting
+// when the request is cancelled or the server shuts down.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
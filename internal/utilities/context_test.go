@@ -0,0 +1,20 @@
+package utilities
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSleep(t *testing.T) {
+	require.NoError(t, Sleep(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	require.ErrorIs(t, Sleep(ctx, time.Minute), context.Canceled)
+	require.Less(t, time.Since(start), time.Second)
+}
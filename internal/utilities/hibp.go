@@ -0,0 +1,139 @@
+package utilities
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// hibpRangeCacheSize is the maximum number of prefixes kept in the range
+// cache at once.
+const hibpRangeCacheSize = 1024
+
+type hibpRangeEntry struct {
+	body      []byte
+	expiresAt time.Time
+}
+
+// HIBPRangeClient sits between the pwned passwords client and the range API.
+// Suffixes seen fewer than Threshold times are reported as not found, and
+// responses are cached per prefix for TTL.
+type HIBPRangeClient struct {
+	HTTP interface {
+		Do(*http.Request) (*http.Response, error)
+	}
+
+	Threshold int
+	TTL       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]hibpRangeEntry
+}
+
+func (c *HIBPRangeClient) Do(req *http.Request) (*http.Response, error) {
+	prefix := path.Base(req.URL.Path)
+
+	if body, ok := c.lookup(prefix); ok {
+		return c.response(req, body), nil
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	body = c.filter(body)
+	c.store(prefix, body)
+
+	return c.response(req, body), nil
+}
+
+// filter zeroes the count of suffixes seen fewer than Threshold times, which
+// the pwned passwords client treats as padding.
+func (c *HIBPRangeClient) filter(body []byte) []byte {
+	if c.Threshold <= 1 {
+		return body
+	}
+
+	var filtered bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			if count, err := strconv.Atoi(string(line[i+1:])); err == nil && count < c.Threshold {
+				line = append(line[:i+1:i+1], '0')
+			}
+		}
+
+		filtered.Write(line)
+		filtered.WriteString("\r\n")
+	}
+
+	return filtered.Bytes()
+}
+
+func (c *HIBPRangeClient) lookup(prefix string) ([]byte, bool) {
+	if c.TTL <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[prefix]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+
+	return entry.body, true
+}
+
+func (c *HIBPRangeClient) store(prefix string, body []byte) {
+	if c.TTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	if len(c.cache) >= hibpRangeCacheSize {
+		for key, entry := range c.cache {
+			if now.After(entry.expiresAt) {
+				delete(c.cache, key)
+			}
+		}
+	}
+
+	if c.cache == nil || len(c.cache) >= hibpRangeCacheSize {
+		c.cache = make(map[string]hibpRangeEntry)
+	}
+
+	c.cache[prefix] = hibpRangeEntry{
+		body:      body,
+		expiresAt: now.Add(c.TTL),
+	}
+}
+
+func (c *HIBPRangeClient) response(req *http.Request, body []byte) *http.Response {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+}
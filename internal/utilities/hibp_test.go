@@ -0,0 +1,79 @@
+package utilities
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	tst "testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRangeAPI struct {
+	body     string
+	requests int
+}
+
+func (f *fakeRangeAPI) Do(req *http.Request) (*http.Response, error) {
+	f.requests += 1
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(f.body)),
+		Request:    req,
+	}, nil
+}
+
+func rangeRequest(t *tst.T, client *HIBPRangeClient, prefix string) string {
+	req, err := http.NewRequest(http.MethodGet, "https://api.pwnedpasswords.com/range/"+prefix, nil)
+	require.NoError(t, err)
+
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestHIBPRangeClientThreshold(t *tst.T) {
+	api := &fakeRangeAPI{
+		body: "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n00D4F6E8FA6EECAD2A3AA415EEC418D38EC:2\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:10\r\n",
+	}
+
+	client := &HIBPRangeClient{
+		HTTP:      api,
+		Threshold: 2,
+	}
+
+	require.Equal(t, "0018A45C4D1DEF81644B54AB7F969B88D65:0\r\n00D4F6E8FA6EECAD2A3AA415EEC418D38EC:2\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:10\r\n", rangeRequest(t, client, "21BD1"))
+}
+
+func TestHIBPRangeClientCache(t *tst.T) {
+	api := &fakeRangeAPI{
+		body: "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n",
+	}
+
+	client := &HIBPRangeClient{
+		HTTP: api,
+		TTL:  time.Minute,
+	}
+
+	require.Equal(t, api.body, rangeRequest(t, client, "21BD1"))
+	require.Equal(t, api.body, rangeRequest(t, client, "21BD1"))
+	require.Equal(t, 1, api.requests)
+
+	rangeRequest(t, client, "21BD2")
+	require.Equal(t, 2, api.requests)
+
+	// expired entries are fetched again
+	client.cache["21BD1"] = hibpRangeEntry{
+		body:      client.cache["21BD1"].body,
+		expiresAt: time.Now().Add(-time.Second),
+	}
+	rangeRequest(t, client, "21BD1")
+	require.Equal(t, 3, api.requests)
+}
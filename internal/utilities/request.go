@@ -11,37 +11,78 @@ import (
 	"github.com/supabase/auth/internal/conf"
 )
 
-// GetIPAddress returns the real IP address of the HTTP request. It parses the
-// X-Forwarded-For header.
-func GetIPAddress(r *http.Request) string {
-	if r.Header != nil {
-		xForwardedFor := r.Header.Get("X-Forwarded-For")
-		if xForwardedFor != "" {
-			ips := strings.Split(xForwardedFor, ",")
-			for i := range ips {
-				ips[i] = strings.TrimSpace(ips[i])
-			}
-
-			for _, ip := range ips {
-				if ip != "" {
-					parsed := net.ParseIP(ip)
-					if parsed == nil {
-						continue
-					}
-
-					return parsed.String()
-				}
-			}
+// TrustedProxies describes the proxies in front of the server whose
+// X-Forwarded-For entries can be trusted.
+type TrustedProxies struct {
+	// Networks contains the addresses of the trusted proxies.
+	Networks []*net.IPNet
+
+	// Depth is the number of proxies trusted regardless of their address.
+	Depth int
+}
+
+func (p *TrustedProxies) trusts(hop int, ip net.IP) bool {
+	if p == nil {
+		return false
+	}
+
+	if hop < p.Depth {
+		return true
+	}
+
+	for _, network := range p.Networks {
+		if network.Contains(ip) {
+			return true
 		}
 	}
 
-	ipPort := r.RemoteAddr
-	ip, _, err := net.SplitHostPort(ipPort)
+	return false
+}
+
+// GetClientIP returns the IP address of the client that sent the request.
+// The X-Forwarded-For header is followed from the right for as long as the
+// entries were added by the trusted proxies in the request's context, so
+// that clients can't spoof their address. Without trusted proxies the
+// address of the connection's peer is returned.
+func GetClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return ipPort
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	proxies := GetTrustedProxies(r.Context())
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+
+	hop := 0
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(forwarded[i])
+		if entry == "" {
+			continue
+		}
+
+		if !proxies.trusts(hop, ip) {
+			break
+		}
+
+		next := net.ParseIP(entry)
+		if next == nil {
+			break
+		}
+
+		ip = next
+		hop++
 	}
 
-	return ip
+	return ip.String()
 }
 
 // GetBodyBytes reads the whole request body properly into a byte array.
@@ -1,6 +1,7 @@
 package utilities
 
 import (
+	"net"
 	"net/http"
 	"net/http/httptest"
 	tst "testing"
@@ -9,83 +10,57 @@ import (
 	"github.com/supabase/auth/internal/conf"
 )
 
-func TestGetIPAddress(t *tst.T) {
-	examples := []func(r *http.Request) string{
-		func(r *http.Request) string {
-			r.Header = nil
-			r.RemoteAddr = "127.0.0.1:8080"
+func TestGetClientIP(t *tst.T) {
+	_, private, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	_, privateV6, err := net.ParseCIDR("fd00::/8")
+	require.NoError(t, err)
 
-			return "127.0.0.1"
-		},
-
-		func(r *http.Request) string {
-			r.Header = nil
-			r.RemoteAddr = "incorrect"
-
-			return "incorrect"
-		},
-
-		func(r *http.Request) string {
-			r.Header = make(http.Header)
-			r.RemoteAddr = "127.0.0.1:8080"
-
-			return "127.0.0.1"
-		},
-
-		func(r *http.Request) string {
-			r.Header = make(http.Header)
-			r.RemoteAddr = "[::1]:8080"
+	cidrs := &TrustedProxies{Networks: []*net.IPNet{private, privateV6}}
 
-			return "::1"
-		},
-
-		func(r *http.Request) string {
-			r.Header = make(http.Header)
-			r.RemoteAddr = "127.0.0.1:8080"
-			r.Header.Add("X-Forwarded-For", "127.0.0.2")
-
-			return "127.0.0.2"
-		},
-
-		func(r *http.Request) string {
-			r.Header = make(http.Header)
-			r.RemoteAddr = "127.0.0.1:8080"
-			r.Header.Add("X-Forwarded-For", "127.0.0.2")
-
-			return "127.0.0.2"
-		},
-
-		func(r *http.Request) string {
-			r.Header = make(http.Header)
-			r.RemoteAddr = "127.0.0.1:8080"
-			r.Header.Add("X-Forwarded-For", "127.0.0.2,")
-
-			return "127.0.0.2"
-		},
-
-		func(r *http.Request) string {
-			r.Header = make(http.Header)
-			r.RemoteAddr = "127.0.0.1:8080"
-			r.Header.Add("X-Forwarded-For", "127.0.0.2,127.0.0.3")
-
-			return "127.0.0.2"
-		},
-
-		func(r *http.Request) string {
-			r.Header = make(http.Header)
-			r.RemoteAddr = "127.0.0.1:8080"
-			r.Header.Add("X-Forwarded-For", "::1,127.0.0.2")
-
-			return "::1"
-		},
+	cases := []struct {
+		desc       string
+		proxies    *TrustedProxies
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{desc: "No proxies", remoteAddr: "127.0.0.1:8080", expected: "127.0.0.1"},
+		{desc: "Invalid remote address", remoteAddr: "incorrect", expected: "incorrect"},
+		{desc: "IPv6 peer", remoteAddr: "[::1]:8080", expected: "::1"},
+		{desc: "No proxies ignores the header", remoteAddr: "198.51.100.1:8080", forwarded: []string{"203.0.113.1"}, expected: "198.51.100.1"},
+		{desc: "Untrusted peer", proxies: cidrs, remoteAddr: "198.51.100.1:8080", forwarded: []string{"203.0.113.1"}, expected: "198.51.100.1"},
+		{desc: "Trusted proxy", proxies: cidrs, remoteAddr: "10.0.0.1:8080", forwarded: []string{"203.0.113.1"}, expected: "203.0.113.1"},
+		{desc: "Trusted proxy without header", proxies: cidrs, remoteAddr: "10.0.0.1:8080", expected: "10.0.0.1"},
+		{desc: "Chain of trusted proxies", proxies: cidrs, remoteAddr: "10.0.0.1:8080", forwarded: []string{"203.0.113.1, 10.2.2.2, 10.1.1.1"}, expected: "203.0.113.1"},
+		{desc: "Spoofed entries", proxies: cidrs, remoteAddr: "10.0.0.1:8080", forwarded: []string{"1.1.1.1, 203.0.113.1"}, expected: "203.0.113.1"},
+		{desc: "Multiple headers", proxies: cidrs, remoteAddr: "10.0.0.1:8080", forwarded: []string{"1.1.1.1", "203.0.113.1, 10.1.1.1"}, expected: "203.0.113.1"},
+		{desc: "Empty entries", proxies: cidrs, remoteAddr: "10.0.0.1:8080", forwarded: []string{"203.0.113.1,"}, expected: "203.0.113.1"},
+		{desc: "Invalid entry", proxies: cidrs, remoteAddr: "10.0.0.1:8080", forwarded: []string{"203.0.113.1, garbage"}, expected: "10.0.0.1"},
+		{desc: "IPv6 proxy", proxies: cidrs, remoteAddr: "[fd00::1]:8080", forwarded: []string{"2001:db8::1"}, expected: "2001:db8::1"},
+		{desc: "IPv6 client behind IPv4 proxy", proxies: cidrs, remoteAddr: "10.0.0.1:8080", forwarded: []string{"2001:db8::1"}, expected: "2001:db8::1"},
+		{desc: "Depth", proxies: &TrustedProxies{Depth: 1}, remoteAddr: "198.51.100.1:8080", forwarded: []string{"1.1.1.1, 203.0.113.1"}, expected: "203.0.113.1"},
+		{desc: "Depth of two", proxies: &TrustedProxies{Depth: 2}, remoteAddr: "198.51.100.1:8080", forwarded: []string{"1.1.1.1, 203.0.113.1, 198.51.100.2"}, expected: "203.0.113.1"},
+		{desc: "Depth and networks", proxies: &TrustedProxies{Networks: cidrs.Networks, Depth: 1}, remoteAddr: "198.51.100.1:8080", forwarded: []string{"203.0.113.1, 10.1.1.1"}, expected: "203.0.113.1"},
 	}
 
-	for _, example := range examples {
-		req := &http.Request{}
-		expected := example(req)
-
-		require.Equal(t, GetIPAddress(req), expected)
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *tst.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tc.proxies != nil {
+				req = req.WithContext(WithTrustedProxies(req.Context(), tc.proxies))
+			}
+
+			require.Equal(t, tc.expected, GetClientIP(req))
+		})
 	}
+
+	// requests without headers are handled
+	require.Equal(t, "127.0.0.1", GetClientIP(&http.Request{RemoteAddr: "127.0.0.1:8080"}))
 }
 
 func TestGetReferrer(t *tst.T) {
@@ -0,0 +1,18 @@
+-- keeps the original identifying values of soft deleted users so that they
+-- can be recovered during a grace period
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.user_soft_deletions (
+    id uuid primary key,
+    user_id uuid not null references {{ index .Options "Namespace" }}.users on delete cascade,
+    email varchar(255) null,
+    phone text null,
+    raw_user_meta_data jsonb null,
+    raw_app_meta_data jsonb null,
+    created_at timestamptz not null default now()
+  );
+
+  create unique index if not exists user_soft_deletions_user_id_key on {{ index .Options "Namespace" }}.user_soft_deletions (user_id);
+  create index if not exists user_soft_deletions_created_at_idx on {{ index .Options "Namespace" }}.user_soft_deletions (created_at);
+
+  alter table {{ index .Options "Namespace" }}.user_soft_deletions enable row level security;
+end $$;
@@ -0,0 +1,16 @@
+-- single use recovery codes that allow users who lost access to their
+-- factors to reach aal2
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.mfa_recovery_codes (
+    id uuid primary key,
+    user_id uuid not null references {{ index .Options "Namespace" }}.users on delete cascade,
+    code_hash text not null,
+    created_at timestamptz not null default now(),
+    used_at timestamptz null,
+    check (char_length(code_hash) > 0)
+  );
+
+  create unique index if not exists mfa_recovery_codes_user_id_code_hash_key on {{ index .Options "Namespace" }}.mfa_recovery_codes (user_id, code_hash);
+
+  alter table {{ index .Options "Namespace" }}.mfa_recovery_codes enable row level security;
+end $$;
@@ -0,0 +1,18 @@
+-- counts the emails of each type sent to an address, so that an address can't
+-- be flooded with emails
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.email_send_counters (
+    id uuid primary key,
+    email text not null,
+    email_type text not null,
+    window_start timestamptz not null default now(),
+    send_count integer not null default 0,
+    last_sent_at timestamptz not null default now(),
+    check (char_length(email) > 0)
+  );
+
+  create unique index if not exists email_send_counters_email_email_type_key on {{ index .Options "Namespace" }}.email_send_counters (email, email_type);
+  create index if not exists email_send_counters_last_sent_at_idx on {{ index .Options "Namespace" }}.email_send_counters (last_sent_at);
+
+  alter table {{ index .Options "Namespace" }}.email_send_counters enable row level security;
+end $$;
@@ -0,0 +1,17 @@
+-- counts failed password logins per email address, phone number and client
+-- IP address, so that repeated failures can lock further attempts
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.login_attempts (
+    id uuid primary key,
+    key text not null,
+    failed_count integer not null default 0,
+    last_failed_at timestamptz not null default now(),
+    locked_until timestamptz null,
+    check (char_length(key) > 0)
+  );
+
+  create unique index if not exists login_attempts_key_key on {{ index .Options "Namespace" }}.login_attempts (key);
+  create index if not exists login_attempts_last_failed_at_idx on {{ index .Options "Namespace" }}.login_attempts (last_failed_at);
+
+  alter table {{ index .Options "Namespace" }}.login_attempts enable row level security;
+end $$;
@@ -0,0 +1,20 @@
+-- holds the configuration overrides of the instances served by this server,
+-- identified by the audience of their requests
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.instance_configs (
+    id uuid primary key,
+    aud varchar(255) not null,
+    overrides jsonb not null default '{}'::jsonb,
+    encrypted_secrets text null,
+    created_at timestamptz not null default now(),
+    updated_at timestamptz not null default now(),
+    check (char_length(aud) > 0)
+  );
+
+  create unique index if not exists instance_configs_aud_key on {{ index .Options "Namespace" }}.instance_configs (aud);
+
+  comment on column {{ index .Options "Namespace" }}.instance_configs.overrides is 'Auth: Configuration overrides without the secrets.';
+  comment on column {{ index .Options "Namespace" }}.instance_configs.encrypted_secrets is 'Auth: External provider secrets, encrypted with the database encryption key.';
+
+  alter table {{ index .Options "Namespace" }}.instance_configs enable row level security;
+end $$;
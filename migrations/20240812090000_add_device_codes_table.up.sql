@@ -0,0 +1,23 @@
+-- holds the pending device authorizations of the OAuth device flow
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.device_codes (
+    id uuid primary key,
+    device_code_hash text not null,
+    user_code varchar(16) not null,
+    user_id uuid null references {{ index .Options "Namespace" }}.users(id) on delete cascade,
+    poll_interval integer not null,
+    last_polled_at timestamptz null,
+    approved_at timestamptz null,
+    expires_at timestamptz not null,
+    created_at timestamptz not null default now(),
+    updated_at timestamptz not null default now()
+  );
+
+  create unique index if not exists device_codes_device_code_hash_key on {{ index .Options "Namespace" }}.device_codes (device_code_hash);
+  create unique index if not exists device_codes_user_code_key on {{ index .Options "Namespace" }}.device_codes (user_code);
+  create index if not exists device_codes_expires_at_idx on {{ index .Options "Namespace" }}.device_codes (expires_at);
+
+  comment on table {{ index .Options "Namespace" }}.device_codes is 'Auth: Device authorizations of the OAuth device flow.';
+
+  alter table {{ index .Options "Namespace" }}.device_codes enable row level security;
+end $$;
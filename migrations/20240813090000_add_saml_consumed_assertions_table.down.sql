@@ -0,0 +1 @@
+drop table if exists {{ index .Options "Namespace" }}.saml_consumed_assertions;
@@ -0,0 +1,19 @@
+-- records the SAML assertions that were used to sign in, so that they can't
+-- be replayed while they are still valid
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.saml_consumed_assertions (
+    id uuid primary key,
+    sso_provider_id uuid not null references {{ index .Options "Namespace" }}.sso_providers(id) on delete cascade,
+    assertion_id text not null,
+    expires_at timestamptz not null,
+    created_at timestamptz not null default now(),
+    constraint "assertion_id not empty" check (char_length(assertion_id) > 0)
+  );
+
+  create unique index if not exists saml_consumed_assertions_sso_provider_id_assertion_id_key on {{ index .Options "Namespace" }}.saml_consumed_assertions (sso_provider_id, assertion_id);
+  create index if not exists saml_consumed_assertions_expires_at_idx on {{ index .Options "Namespace" }}.saml_consumed_assertions (expires_at);
+
+  comment on table {{ index .Options "Namespace" }}.saml_consumed_assertions is 'Auth: Consumed SAML assertions, kept until they expire to reject replays.';
+
+  alter table {{ index .Options "Namespace" }}.saml_consumed_assertions enable row level security;
+end $$;
@@ -0,0 +1,14 @@
+-- holds the feature flags set through the admin API, which replace the
+-- configured defaults; the table has at most one row
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.feature_flags (
+    id smallint primary key,
+    flags jsonb not null default '{}'::jsonb,
+    updated_at timestamptz not null default now(),
+    check (id = 1)
+  );
+
+  comment on table {{ index .Options "Namespace" }}.feature_flags is 'Auth: Feature flags set through the admin API.';
+
+  alter table {{ index .Options "Namespace" }}.feature_flags enable row level security;
+end $$;
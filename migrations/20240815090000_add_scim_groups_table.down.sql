@@ -0,0 +1,2 @@
+drop table if exists {{ index .Options "Namespace" }}.scim_group_members;
+drop table if exists {{ index .Options "Namespace" }}.scim_groups;
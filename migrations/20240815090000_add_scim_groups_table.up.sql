@@ -0,0 +1,30 @@
+-- holds the groups provisioned through SCIM and their members
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.scim_groups (
+    id uuid primary key,
+    display_name text not null,
+    external_id text null,
+    created_at timestamptz not null default now(),
+    updated_at timestamptz not null default now()
+  );
+
+  create unique index if not exists scim_groups_display_name_key on {{ index .Options "Namespace" }}.scim_groups (lower(display_name));
+  create index if not exists scim_groups_external_id_idx on {{ index .Options "Namespace" }}.scim_groups (external_id);
+
+  comment on table {{ index .Options "Namespace" }}.scim_groups is 'Auth: Groups provisioned through SCIM.';
+
+  alter table {{ index .Options "Namespace" }}.scim_groups enable row level security;
+
+  create table if not exists {{ index .Options "Namespace" }}.scim_group_members (
+    group_id uuid not null references {{ index .Options "Namespace" }}.scim_groups(id) on delete cascade,
+    user_id uuid not null references {{ index .Options "Namespace" }}.users(id) on delete cascade,
+    created_at timestamptz not null default now(),
+    primary key (group_id, user_id)
+  );
+
+  create index if not exists scim_group_members_user_id_idx on {{ index .Options "Namespace" }}.scim_group_members (user_id);
+
+  comment on table {{ index .Options "Namespace" }}.scim_group_members is 'Auth: Members of the groups provisioned through SCIM.';
+
+  alter table {{ index .Options "Namespace" }}.scim_group_members enable row level security;
+end $$;
@@ -0,0 +1,17 @@
+-- holds the keys signing the access tokens which were generated through the
+-- admin API; the newest one signs and the others only verify
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.jwt_keys (
+    id uuid primary key,
+    key_id text not null unique,
+    algorithm text not null,
+    private_key text not null,
+    created_at timestamptz not null default now()
+  );
+
+  create index if not exists jwt_keys_created_at_idx on {{ index .Options "Namespace" }}.jwt_keys (created_at desc);
+
+  comment on table {{ index .Options "Namespace" }}.jwt_keys is 'Auth: Keys signing the access tokens, generated through the admin API.';
+
+  alter table {{ index .Options "Namespace" }}.jwt_keys enable row level security;
+end $$;
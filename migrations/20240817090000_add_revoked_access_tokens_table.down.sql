@@ -0,0 +1 @@
+drop table if exists {{ index .Options "Namespace" }}.revoked_access_tokens;
@@ -0,0 +1,17 @@
+-- holds the hashes of the access tokens which were revoked before they
+-- expired, until they expire
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.revoked_access_tokens (
+    id uuid primary key,
+    token_hash text not null unique,
+    user_id uuid null references {{ index .Options "Namespace" }}.users(id) on delete cascade,
+    expires_at timestamptz not null,
+    created_at timestamptz not null default now()
+  );
+
+  create index if not exists revoked_access_tokens_expires_at_idx on {{ index .Options "Namespace" }}.revoked_access_tokens (expires_at);
+
+  comment on table {{ index .Options "Namespace" }}.revoked_access_tokens is 'Auth: Denylist of the access tokens revoked before they expired.';
+
+  alter table {{ index .Options "Namespace" }}.revoked_access_tokens enable row level security;
+end $$;
@@ -0,0 +1,17 @@
+-- holds the confidential clients which get access tokens with the client
+-- credentials grant, such as backend services
+do $$ begin
+  create table if not exists {{ index .Options "Namespace" }}.oauth_clients (
+    id uuid primary key,
+    client_secret_hash text not null,
+    name text not null,
+    role text not null,
+    scope text not null default '',
+    created_at timestamptz not null default now(),
+    updated_at timestamptz not null default now()
+  );
+
+  comment on table {{ index .Options "Namespace" }}.oauth_clients is 'Auth: Confidential clients of the client credentials grant.';
+
+  alter table {{ index .Options "Namespace" }}.oauth_clients enable row level security;
+end $$;
@@ -0,0 +1,5 @@
+drop table if exists {{ index .Options "Namespace" }}.webauthn_challenges;
+
+alter table {{ index .Options "Namespace" }}.mfa_factors
+  drop column if exists web_authn_credential,
+  drop column if exists web_authn_credential_id;
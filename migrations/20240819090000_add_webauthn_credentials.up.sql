@@ -0,0 +1,24 @@
+-- holds the credentials of webauthn factors, such as passkeys, and the
+-- challenges of their registration and sign in ceremonies
+do $$ begin
+  alter table {{ index .Options "Namespace" }}.mfa_factors
+    add column if not exists web_authn_credential jsonb null,
+    add column if not exists web_authn_credential_id text null;
+
+  create unique index if not exists mfa_factors_web_authn_credential_id_idx on {{ index .Options "Namespace" }}.mfa_factors (web_authn_credential_id);
+
+  create table if not exists {{ index .Options "Namespace" }}.webauthn_challenges (
+    id uuid primary key,
+    user_id uuid null references {{ index .Options "Namespace" }}.users (id) on delete cascade,
+    factor_id uuid null references {{ index .Options "Namespace" }}.mfa_factors (id) on delete cascade,
+    challenge text not null,
+    ip_address inet not null,
+    created_at timestamptz not null default now()
+  );
+
+  create index if not exists webauthn_challenges_created_at_idx on {{ index .Options "Namespace" }}.webauthn_challenges (created_at);
+
+  comment on table {{ index .Options "Namespace" }}.webauthn_challenges is 'Auth: Challenges of WebAuthn registrations and sign ins.';
+
+  alter table {{ index .Options "Namespace" }}.webauthn_challenges enable row level security;
+end $$;
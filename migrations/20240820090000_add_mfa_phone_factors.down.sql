@@ -0,0 +1,9 @@
+delete from {{ index .Options "Namespace" }}.mfa_factors where factor_type = 'phone';
+
+drop index if exists {{ index .Options "Namespace" }}.mfa_factors_user_phone_unique;
+
+alter table {{ index .Options "Namespace" }}.mfa_factors
+  drop column if exists phone;
+
+alter table {{ index .Options "Namespace" }}.mfa_challenges
+  drop column if exists otp_code;
@@ -0,0 +1,10 @@
+-- phone factors receive the codes of their challenges by SMS
+alter type {{ index .Options "Namespace" }}.factor_type add value if not exists 'phone';
+
+alter table {{ index .Options "Namespace" }}.mfa_factors
+  add column if not exists phone text null;
+
+create unique index if not exists mfa_factors_user_phone_unique on {{ index .Options "Namespace" }}.mfa_factors (user_id, phone) where phone is not null;
+
+alter table {{ index .Options "Namespace" }}.mfa_challenges
+  add column if not exists otp_code text null;
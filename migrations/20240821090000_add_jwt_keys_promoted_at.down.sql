@@ -0,0 +1,2 @@
+alter table {{ index .Options "Namespace" }}.jwt_keys
+  drop column if exists promoted_at;
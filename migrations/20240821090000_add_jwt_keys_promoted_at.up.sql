@@ -0,0 +1,7 @@
+-- keys generated through the admin API only verify until they're promoted,
+-- the most recently promoted key signs; the existing keys keep signing as
+-- before
+alter table {{ index .Options "Namespace" }}.jwt_keys
+  add column if not exists promoted_at timestamptz null;
+
+update {{ index .Options "Namespace" }}.jwt_keys set promoted_at = created_at where promoted_at is null;
@@ -0,0 +1 @@
+drop index if exists {{ index .Options "Namespace" }}.users_scim_user_name_key;
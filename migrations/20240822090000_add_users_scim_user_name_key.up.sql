@@ -0,0 +1,5 @@
+-- the user names of the users provisioned through SCIM are unique in their
+-- audience, regardless of case
+create unique index if not exists users_scim_user_name_key
+  on {{ index .Options "Namespace" }}.users (aud, lower(raw_app_meta_data->'scim'->>'user_name'))
+  where raw_app_meta_data->'scim'->>'user_name' is not null;
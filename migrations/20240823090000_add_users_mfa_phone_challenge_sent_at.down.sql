@@ -0,0 +1,2 @@
+alter table {{ index .Options "Namespace" }}.users
+  drop column if exists mfa_phone_challenge_sent_at;
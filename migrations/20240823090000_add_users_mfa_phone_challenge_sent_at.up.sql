@@ -0,0 +1,4 @@
+-- the codes of phone factors are limited per user, as unverified phone
+-- factors and their challenges are deleted when they're enrolled again
+alter table {{ index .Options "Namespace" }}.users
+  add column if not exists mfa_phone_challenge_sent_at timestamptz null;